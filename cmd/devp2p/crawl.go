@@ -17,12 +17,21 @@
 package main
 
 import (
+	"fmt"
+	"sync"
 	"time"
 
+	"github.com/mxt/go-mxt/cmd/devp2p/internal/mxttest"
+	"github.com/mxt/go-mxt/core/forkid"
 	"github.com/mxt/go-mxt/log"
 	"github.com/mxt/go-mxt/p2p/enode"
+	"github.com/mxt/go-mxt/p2p/enr"
+	"github.com/mxt/go-mxt/rlp"
 )
 
+// maxActiveHandshakes limits the number of concurrent RLPx handshakes.
+const maxActiveHandshakes = 16
+
 type crawler struct {
 	input     nodeSet
 	output    nodeSet
@@ -31,24 +40,47 @@ type crawler struct {
 	inputIter enode.Iterator
 	ch        chan *enode.Node
 	closed    chan struct{}
+	quit      chan struct{}
+	quitOnce  sync.Once
+
+	// output is only modified by the run loop, writes are guarded by mu.
+	mu sync.RWMutex
+
+	// handshake state
+	handshakes     chan handshakeResult
+	handshakeSlots chan struct{}
+	handshakeWG    sync.WaitGroup
 
 	// settings
 	revalidateInterval time.Duration
+	handshakeInterval  time.Duration
+	handshake          func(*enode.Node) (*mxttest.Hello, error) // disabled if nil
 }
 
 type resolver interface {
 	RequestENR(*enode.Node) (*enode.Node, error)
 }
 
+// handshakeResult is the outcome of an RLPx handshake with a node.
+type handshakeResult struct {
+	id    enode.ID
+	time  time.Time
+	hello *mxttest.Hello
+	err   error
+}
+
 func newCrawler(input nodeSet, disc resolver, iters ...enode.Iterator) *crawler {
 	c := &crawler{
-		input:     input,
-		output:    make(nodeSet, len(input)),
-		disc:      disc,
-		iters:     iters,
-		inputIter: enode.IterNodes(input.nodes()),
-		ch:        make(chan *enode.Node),
-		closed:    make(chan struct{}),
+		input:          input,
+		output:         make(nodeSet, len(input)),
+		disc:           disc,
+		iters:          iters,
+		inputIter:      enode.IterNodes(input.nodes()),
+		ch:             make(chan *enode.Node),
+		closed:         make(chan struct{}),
+		quit:           make(chan struct{}),
+		handshakes:     make(chan handshakeResult),
+		handshakeSlots: make(chan struct{}, maxActiveHandshakes),
 	}
 	c.iters = append(c.iters, c.inputIter)
 	// Copy input to output initially. Any nodes that fail validation
@@ -59,6 +91,8 @@ func newCrawler(input nodeSet, disc resolver, iters ...enode.Iterator) *crawler
 	return c
 }
 
+// run crawls until all iterators have ended, the timeout expires after the input
+// set was revalidated, or stop is called. A zero timeout means no time limit.
 func (c *crawler) run(timeout time.Duration) nodeSet {
 	var (
 		timeoutTimer = time.NewTimer(timeout)
@@ -76,6 +110,8 @@ loop:
 		select {
 		case n := <-c.ch:
 			c.updateNode(n)
+		case r := <-c.handshakes:
+			c.updateHandshake(r)
 		case it := <-doneCh:
 			if it == c.inputIter {
 				// Enable timeout when we're done revalidating the input nodes.
@@ -89,6 +125,8 @@ loop:
 			}
 		case <-timeoutCh:
 			break loop
+		case <-c.quit:
+			break loop
 		}
 	}
 
@@ -99,9 +137,28 @@ loop:
 	for ; liveIters > 0; liveIters-- {
 		<-doneCh
 	}
+	c.handshakeWG.Wait()
 	return c.output
 }
 
+// stop terminates a running crawl.
+func (c *crawler) stop() {
+	c.quitOnce.Do(func() { close(c.quit) })
+}
+
+// snapshot returns a copy of the current output set. It is safe to call
+// while the crawler is running.
+func (c *crawler) snapshot() nodeSet {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	ns := make(nodeSet, len(c.output))
+	for id, n := range c.output {
+		ns[id] = n
+	}
+	return ns
+}
+
 func (c *crawler) runIterator(done chan<- enode.Iterator, it enode.Iterator) {
 	defer func() { done <- it }()
 	for it.Next() {
@@ -135,13 +192,19 @@ func (c *crawler) updateNode(n *enode.Node) {
 		node.N = nn
 		node.Seq = nn.Seq()
 		node.Score++
+		node.ForkID = forkIDString(nn)
 		if node.FirstResponse.IsZero() {
 			node.FirstResponse = node.LastCheck
 		}
 		node.LastResponse = node.LastCheck
+		if c.handshake != nil && time.Since(node.LastHandshake) >= c.handshakeInterval {
+			c.startHandshake(nn)
+		}
 	}
 
 	// Store/update node in output set.
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	if node.Score <= 0 {
 		log.Info("Removing node", "id", n.ID())
 		delete(c.output, n.ID())
@@ -151,6 +214,109 @@ func (c *crawler) updateNode(n *enode.Node) {
 	}
 }
 
+// startHandshake launches an RLPx handshake with the given node in the background,
+// unless too many handshakes are running already.
+func (c *crawler) startHandshake(n *enode.Node) {
+	if n.TCP() == 0 {
+		return
+	}
+	select {
+	case c.handshakeSlots <- struct{}{}:
+	default:
+		return // Retried on the next revalidation.
+	}
+	c.handshakeWG.Add(1)
+	go func() {
+		defer func() {
+			<-c.handshakeSlots
+			c.handshakeWG.Done()
+		}()
+		hello, err := c.handshake(n)
+		select {
+		case c.handshakes <- handshakeResult{n.ID(), truncNow(), hello, err}:
+		case <-c.closed:
+		}
+	}()
+}
+
+// updateHandshake stores the result of an RLPx handshake.
+func (c *crawler) updateHandshake(r handshakeResult) {
+	node, ok := c.output[r.id]
+	if !ok {
+		return // Removed while the handshake was running.
+	}
+	node.LastHandshake = r.time
+	if r.err != nil {
+		log.Debug("RLPx handshake failed", "id", r.id, "err", r.err)
+		node.HandshakeError = r.err.Error()
+	} else {
+		node.HandshakeError = ""
+		node.ClientName = r.hello.Name
+		node.Caps = make([]string, len(r.hello.Caps))
+		for i, cap := range r.hello.Caps {
+			node.Caps[i] = cap.String()
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.output[r.id] = node
+}
+
+// revalidationIter cycles over the nodes of a crawler output set, such that known
+// nodes are checked again while the crawler runs. It waits for the given interval
+// before each pass.
+type revalidationIter struct {
+	snapshot  func() nodeSet
+	interval  time.Duration
+	pending   []*enode.Node
+	cur       *enode.Node
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newRevalidationIter(snapshot func() nodeSet, interval time.Duration) *revalidationIter {
+	return &revalidationIter{snapshot: snapshot, interval: interval, closed: make(chan struct{})}
+}
+
+func (it *revalidationIter) Next() bool {
+	for len(it.pending) == 0 {
+		select {
+		case <-time.After(it.interval):
+			it.pending = it.snapshot().nodes()
+		case <-it.closed:
+			return false
+		}
+	}
+	select {
+	case <-it.closed:
+		return false
+	default:
+	}
+	it.cur, it.pending = it.pending[0], it.pending[1:]
+	return true
+}
+
+func (it *revalidationIter) Node() *enode.Node {
+	return it.cur
+}
+
+func (it *revalidationIter) Close() {
+	it.closeOnce.Do(func() { close(it.closed) })
+}
+
+// forkIDString returns the fork ID from the "mxt" entry of a node record.
+func forkIDString(n *enode.Node) string {
+	var mxt struct {
+		ForkID forkid.ID
+		_      []rlp.RawValue `rlp:"tail"`
+	}
+	if n.Load(enr.WithEntry("mxt", &mxt)) != nil {
+		return ""
+	}
+	return fmt.Sprintf("%#x/%d", mxt.ForkID.Hash, mxt.ForkID.Next)
+}
+
 func truncNow() time.Time {
 	return time.Now().UTC().Truncate(1 * time.Second)
 }
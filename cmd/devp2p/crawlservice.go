@@ -0,0 +1,431 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of go-mxt.
+//
+// go-mxt is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-mxt is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-mxt. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mxt/go-mxt/cmd/devp2p/internal/mxttest"
+	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/crypto"
+	"github.com/mxt/go-mxt/log"
+	"github.com/mxt/go-mxt/p2p/dnsdisc"
+	"github.com/mxt/go-mxt/p2p/enode"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// rlpxHandshakeTimeout is the time limit for RLPx handshakes made by the crawl service.
+const rlpxHandshakeTimeout = 10 * time.Second
+
+var (
+	crawlHTTPFlag = cli.StringFlag{
+		Name:  "http",
+		Usage: "Listening address of the HTTP API (disabled if empty)",
+	}
+	crawlWriteIntervalFlag = cli.DurationFlag{
+		Name:  "write-interval",
+		Usage: "Time between writes of the nodes.json file",
+		Value: 5 * time.Minute,
+	}
+	crawlRevalidateFlag = cli.DurationFlag{
+		Name:  "revalidate-interval",
+		Usage: "Time between liveness checks of known nodes",
+		Value: 10 * time.Minute,
+	}
+	crawlRLPxIntervalFlag = cli.DurationFlag{
+		Name:  "rlpx-interval",
+		Usage: "Time between RLPx handshakes with a node (zero disables handshakes)",
+		Value: time.Hour,
+	}
+	crawlDNSTreeFlag = cli.StringFlag{
+		Name:  "dns-tree",
+		Usage: "Tree definition directory which is regenerated from the crawl results",
+	}
+	crawlDNSKeyFlag = cli.StringFlag{
+		Name:  "dns-key",
+		Usage: "Key file used to sign the DNS tree",
+	}
+	crawlDNSPasswordFlag = cli.StringFlag{
+		Name:  "dns-password",
+		Usage: "File containing the password of the signing key (prompted for if not set)",
+	}
+	crawlDNSFilterFlag = cli.StringFlag{
+		Name:  "dns-filter",
+		Usage: "Node set filters applied to the DNS tree, e.g. \"-mxt-network mainnet -min-age 1h\"",
+	}
+	crawlDNSIntervalFlag = cli.DurationFlag{
+		Name:  "dns-interval",
+		Usage: "Time between updates of the DNS tree",
+		Value: time.Hour,
+	}
+	crawlDNSServeFlag = cli.StringFlag{
+		Name:  "dns-serve",
+		Usage: "Listening address of a DNS server for the tree (disabled if empty)",
+	}
+)
+
+// crawlServiceFlags are the flags of the discv4 and discv5 crawl-service commands.
+var crawlServiceFlags = []cli.Flag{
+	bootnodesFlag,
+	crawlHTTPFlag,
+	crawlWriteIntervalFlag,
+	crawlRevalidateFlag,
+	crawlRLPxIntervalFlag,
+	crawlDNSTreeFlag,
+	crawlDNSKeyFlag,
+	crawlDNSPasswordFlag,
+	crawlDNSFilterFlag,
+	crawlDNSIntervalFlag,
+	crawlDNSServeFlag,
+}
+
+// runCrawlService crawls until interrupted. The node set is written to nodesFile
+// periodically and on exit.
+func runCrawlService(ctx *cli.Context, nodesFile string, disc resolver, iters ...enode.Iterator) error {
+	inputSet := make(nodeSet)
+	if common.FileExist(nodesFile) {
+		inputSet = loadNodesJSON(nodesFile)
+	}
+
+	// Set up the crawler.
+	revalidate := ctx.Duration(crawlRevalidateFlag.Name)
+	c := newCrawler(inputSet, disc, iters...)
+	c.revalidateInterval = revalidate
+	c.iters = append(c.iters, newRevalidationIter(c.snapshot, revalidate))
+	if interval := ctx.Duration(crawlRLPxIntervalFlag.Name); interval > 0 {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			return err
+		}
+		c.handshakeInterval = interval
+		c.handshake = func(n *enode.Node) (*mxttest.Hello, error) {
+			return rlpxHello(n, key, rlpxHandshakeTimeout)
+		}
+	}
+
+	// Set up DNS tree publishing.
+	pub, err := newDNSPublisher(ctx)
+	if err != nil {
+		return err
+	}
+	var dnsTicker <-chan time.Time
+	if pub != nil {
+		defer pub.close()
+		if err := pub.publish(inputSet); err != nil {
+			return err
+		}
+		t := time.NewTicker(ctx.Duration(crawlDNSIntervalFlag.Name))
+		defer t.Stop()
+		dnsTicker = t.C
+	}
+
+	// Start the HTTP API.
+	if addr := ctx.String(crawlHTTPFlag.Name); addr != "" {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		srv := &http.Server{Handler: newCrawlAPI(c.snapshot)}
+		go srv.Serve(listener)
+		defer srv.Close()
+		log.Info("Crawler HTTP API started", "addr", listener.Addr())
+	}
+
+	done := make(chan nodeSet, 1)
+	go func() { done <- c.run(0) }()
+
+	writeTicker := time.NewTicker(ctx.Duration(crawlWriteIntervalFlag.Name))
+	defer writeTicker.Stop()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	for {
+		select {
+		case <-writeTicker.C:
+			writeNodesJSON(nodesFile, c.snapshot())
+		case <-dnsTicker:
+			if err := pub.publish(c.snapshot()); err != nil {
+				log.Error("Can't update DNS tree", "err", err)
+			}
+		case <-sig:
+			log.Info("Got interrupt, shutting down...")
+			c.stop()
+		case output := <-done:
+			writeNodesJSON(nodesFile, output)
+			return nil
+		}
+	}
+}
+
+// newCrawlAPI creates the HTTP handler of the crawl service. It has two endpoints:
+//
+//   /nodes returns the node set in nodes.json format
+//   /stats returns summary statistics of the node set
+//
+// Both accept node set filters as query parameters, e.g. /nodes?mxt-network=mainnet.
+func newCrawlAPI(snapshot func() nodeSet) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/nodes", func(w http.ResponseWriter, r *http.Request) {
+		ns, err := filterQuery(snapshot(), r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSONResponse(w, ns)
+	})
+	mux.HandleFunc("/stats", func(w http.ResponseWriter, r *http.Request) {
+		ns, err := filterQuery(snapshot(), r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSONResponse(w, makeCrawlStats(ns))
+	})
+	return mux
+}
+
+// filterQuery applies the filters given as URL query parameters to a node set.
+func filterQuery(ns nodeSet, query url.Values) (nodeSet, error) {
+	filter, err := andFilter(queryFilterArgs(query))
+	if err != nil {
+		return nil, err
+	}
+	result := make(nodeSet)
+	for id, n := range ns {
+		if filter(n) {
+			result[id] = n
+		}
+	}
+	return result, nil
+}
+
+// queryFilterArgs converts URL query parameters to node set filter arguments.
+// Parameters without a value are turned into filters without arguments.
+func queryFilterArgs(query url.Values) []string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var args []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			args = append(args, "-"+k)
+			if v != "" {
+				args = append(args, v)
+			}
+		}
+	}
+	return args
+}
+
+func writeJSONResponse(w http.ResponseWriter, v interface{}) {
+	enc, err := json.MarshalIndent(v, "", jsonIndent)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(enc)
+}
+
+// crawlStats is the response of the /stats endpoint.
+type crawlStats struct {
+	Nodes      int            `json:"nodes"`
+	Responsive int            `json:"responsive"` // nodes which passed the last liveness check
+	Handshakes int            `json:"handshakes"` // nodes which passed the last RLPx handshake
+	Clients    map[string]int `json:"clients"`    // client name -> node count
+	ForkIDs    map[string]int `json:"forkIDs"`    // fork ID -> node count
+}
+
+func makeCrawlStats(ns nodeSet) crawlStats {
+	stats := crawlStats{
+		Nodes:   len(ns),
+		Clients: make(map[string]int),
+		ForkIDs: make(map[string]int),
+	}
+	for _, n := range ns {
+		if !n.LastResponse.IsZero() && n.LastResponse.Equal(n.LastCheck) {
+			stats.Responsive++
+		}
+		if !n.LastHandshake.IsZero() && n.HandshakeError == "" {
+			stats.Handshakes++
+			stats.Clients[clientKind(n.ClientName)]++
+		}
+		if n.ForkID != "" {
+			stats.ForkIDs[n.ForkID]++
+		}
+	}
+	return stats
+}
+
+// clientKind returns the client implementation part of a client name,
+// e.g. "Gmxt" for "Gmxt/v1.9.24-stable/linux-amd64/go1.15.5".
+func clientKind(name string) string {
+	return strings.SplitN(name, "/", 2)[0]
+}
+
+// dnsPublisher regenerates a DNS tree definition from crawl results.
+type dnsPublisher struct {
+	dir    string
+	domain string
+	key    *ecdsa.PrivateKey
+	filter nodeFilter
+	server *dnsdisc.Server // optional
+
+	published bool
+	lastNodes []*enode.Node
+	lastLinks []string
+}
+
+// newDNSPublisher creates a publisher from command line flags. It returns nil if
+// DNS tree publishing is disabled.
+func newDNSPublisher(ctx *cli.Context) (*dnsPublisher, error) {
+	dir := ctx.String(crawlDNSTreeFlag.Name)
+	if dir == "" {
+		return nil, nil
+	}
+	if !ctx.IsSet(crawlDNSKeyFlag.Name) {
+		return nil, fmt.Errorf("-%s requires -%s", crawlDNSTreeFlag.Name, crawlDNSKeyFlag.Name)
+	}
+	filter, err := andFilter(strings.Fields(ctx.String(crawlDNSFilterFlag.Name)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid -%s: %v", crawlDNSFilterFlag.Name, err)
+	}
+	meta, err := loadTreeMetadata(dir)
+	if err != nil {
+		return nil, err
+	}
+	p := &dnsPublisher{
+		dir:    dir,
+		domain: directoryName(dir),
+		filter: filter,
+	}
+	if meta.URL != "" {
+		if p.domain, _, err = dnsdisc.ParseURL(meta.URL); err != nil {
+			return nil, fmt.Errorf("invalid 'url' field: %v", err)
+		}
+	}
+	p.key = loadSigningKey(ctx.String(crawlDNSKeyFlag.Name), ctx.String(crawlDNSPasswordFlag.Name))
+
+	if addr := ctx.String(crawlDNSServeFlag.Name); addr != "" {
+		p.server = dnsdisc.NewServer(dnsdisc.ServerConfig{
+			RootTTL:  rootTTL * time.Second,
+			EntryTTL: treeNodeTTL * time.Second,
+		})
+		if err := p.server.Start(addr); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// publish signs a new version of the tree containing the nodes of ns which pass
+// the filter. Nothing is written if neither the nodes nor the links of the tree
+// have changed since the last call.
+func (p *dnsPublisher) publish(ns nodeSet) error {
+	meta, err := loadTreeMetadata(p.dir)
+	if err != nil {
+		return err
+	}
+	filtered := make(nodeSet)
+	for id, n := range ns {
+		if p.filter(n) {
+			filtered[id] = n
+		}
+	}
+	nodes := filtered.nodes()
+	if p.published && sameNodes(nodes, p.lastNodes) && sameStrings(meta.Links, p.lastLinks) {
+		return nil
+	}
+
+	t, err := dnsdisc.MakeTree(meta.Seq+1, nodes, meta.Links)
+	if err != nil {
+		return err
+	}
+	url, err := t.Sign(p.key, p.domain)
+	if err != nil {
+		return fmt.Errorf("can't sign: %v", err)
+	}
+	def := treeToDefinition(url, t)
+	def.Meta.LastModified = time.Now()
+	writeTreeMetadata(p.dir, def)
+	writeTreeNodes(p.dir, def)
+	if p.server != nil {
+		p.server.SetTree(p.domain, t)
+	}
+	log.Info("Updated DNS tree", "url", url, "seq", t.Seq(), "nodes", len(nodes))
+
+	p.published = true
+	p.lastNodes, p.lastLinks = nodes, meta.Links
+	return nil
+}
+
+func (p *dnsPublisher) close() {
+	if p.server != nil {
+		p.server.Close()
+	}
+}
+
+// loadTreeMetadata loads the metadata file of a tree definition directory.
+// A missing file yields empty metadata.
+func loadTreeMetadata(directory string) (dnsMetaJSON, error) {
+	var meta dnsMetaJSON
+	metaFile, _ := treeDefinitionFiles(directory)
+	if err := common.LoadJSON(metaFile, &meta); err != nil && !os.IsNotExist(err) {
+		return meta, err
+	}
+	return meta, nil
+}
+
+// sameNodes reports whether two sorted node lists contain the same records.
+func sameNodes(a, b []*enode.Node) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].ID() != b[i].ID() || a[i].Seq() != b[i].Seq() {
+			return false
+		}
+	}
+	return true
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,196 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of go-mxt.
+//
+// go-mxt is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-mxt is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-mxt. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/mxt/go-mxt/cmd/devp2p/internal/mxttest"
+	"github.com/mxt/go-mxt/crypto"
+	"github.com/mxt/go-mxt/p2p"
+	"github.com/mxt/go-mxt/p2p/enode"
+	"github.com/mxt/go-mxt/p2p/enr"
+)
+
+func TestQueryFilterArgs(t *testing.T) {
+	query := url.Values{
+		"ip":         {"10.0.0.0/8"},
+		"les-server": {""},
+		"client":     {"gmxt", "other"},
+	}
+	want := []string{"-client", "gmxt", "-client", "other", "-ip", "10.0.0.0/8", "-les-server"}
+	if args := queryFilterArgs(query); !reflect.DeepEqual(args, want) {
+		t.Fatalf("wrong args: %q", args)
+	}
+}
+
+func TestCrawlAPI(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	n1, n2 := testCrawlNode(t, net.IP{10, 0, 0, 1}), testCrawlNode(t, net.IP{192, 168, 0, 1})
+	ns := nodeSet{
+		n1.ID(): {N: n1, Seq: n1.Seq(), LastCheck: now, LastResponse: now, LastHandshake: now, ClientName: "Gmxt/v1.9.24"},
+		n2.ID(): {N: n2, Seq: n2.Seq(), LastCheck: now, LastHandshake: now, HandshakeError: "too many peers"},
+	}
+	srv := httptest.NewServer(newCrawlAPI(func() nodeSet { return ns }))
+	defer srv.Close()
+
+	var result nodeSet
+	if code := getJSON(t, srv.URL+"/nodes?ip=10.0.0.0/8", &result); code != http.StatusOK {
+		t.Fatalf("wrong status %d", code)
+	}
+	if len(result) != 1 || result[n1.ID()].ClientName != "Gmxt/v1.9.24" {
+		t.Fatalf("wrong result for IP filter: %v", result)
+	}
+	result = nil
+	if getJSON(t, srv.URL+"/nodes?client=gmxt", &result); len(result) != 1 {
+		t.Fatalf("wrong result for client filter: %v", result)
+	}
+	for _, query := range []string{"?ip", "?ip=foo", "?bogus=1"} {
+		if code := getJSON(t, srv.URL+"/nodes"+query, nil); code != http.StatusBadRequest {
+			t.Errorf("query %q: wrong status %d", query, code)
+		}
+	}
+
+	var stats crawlStats
+	getJSON(t, srv.URL+"/stats", &stats)
+	want := crawlStats{
+		Nodes:      2,
+		Responsive: 1,
+		Handshakes: 1,
+		Clients:    map[string]int{"Gmxt": 1},
+		ForkIDs:    map[string]int{},
+	}
+	if !reflect.DeepEqual(stats, want) {
+		t.Fatalf("wrong stats: %+v", stats)
+	}
+}
+
+func TestCrawlerHandshake(t *testing.T) {
+	n := testCrawlNode(t, net.IP{127, 0, 0, 1})
+	c := newCrawler(nil, staticResolver{}, enode.IterNodes([]*enode.Node{n}))
+	c.iters = append(c.iters, newRevalidationIter(c.snapshot, time.Hour))
+	c.handshakeInterval = time.Hour
+	c.handshake = func(*enode.Node) (*mxttest.Hello, error) {
+		return &mxttest.Hello{Name: "Gmxt/v1.9.24", Caps: []p2p.Cap{{Name: "mxt", Version: 65}}}, nil
+	}
+	done := make(chan nodeSet)
+	go func() { done <- c.run(0) }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for c.snapshot()[n.ID()].LastHandshake.IsZero() {
+		if time.Now().After(deadline) {
+			t.Fatal("handshake result not recorded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	c.stop()
+	result := (<-done)[n.ID()]
+	if result.Score != 1 || result.FirstResponse.IsZero() {
+		t.Errorf("liveness not recorded: %+v", result)
+	}
+	if result.ClientName != "Gmxt/v1.9.24" || !reflect.DeepEqual(result.Caps, []string{"mxt/65"}) {
+		t.Errorf("wrong handshake result: %+v", result)
+	}
+}
+
+func TestDNSPublisher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dns-publish-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, _ := crypto.GenerateKey()
+	n1, n2 := testCrawlNode(t, net.IP{10, 0, 0, 1}), testCrawlNode(t, net.IP{192, 168, 0, 1})
+	filter, _ := andFilter([]string{"-ip", "10.0.0.0/8"})
+	p := &dnsPublisher{dir: dir, domain: "nodes.example.org", key: key, filter: filter}
+
+	ns := make(nodeSet)
+	ns.add(n1, n2)
+	if err := p.publish(ns); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.publish(ns); err != nil {
+		t.Fatal(err)
+	}
+	domain, tree, err := loadTreeDefinitionForExport(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if domain != "nodes.example.org" {
+		t.Errorf("wrong domain %q", domain)
+	}
+	if tree.Seq() != 1 {
+		t.Errorf("unchanged tree republished, seq %d", tree.Seq())
+	}
+	if nodes := tree.Nodes(); len(nodes) != 1 || nodes[0].ID() != n1.ID() {
+		t.Errorf("wrong tree nodes: %v", nodes)
+	}
+
+	// Changes to the node set are published.
+	delete(ns, n1.ID())
+	if err := p.publish(ns); err != nil {
+		t.Fatal(err)
+	}
+	if _, tree, _ = loadTreeDefinitionForExport(dir); tree.Seq() != 2 || len(tree.Nodes()) != 0 {
+		t.Errorf("wrong tree after update: seq %d, %d nodes", tree.Seq(), len(tree.Nodes()))
+	}
+}
+
+type staticResolver struct{}
+
+func (staticResolver) RequestENR(n *enode.Node) (*enode.Node, error) {
+	return n, nil
+}
+
+func testCrawlNode(t *testing.T, ip net.IP) *enode.Node {
+	key, _ := crypto.GenerateKey()
+	var r enr.Record
+	r.Set(enr.IP(ip))
+	r.Set(enr.TCP(30303))
+	if err := enode.SignV4(&r, key); err != nil {
+		t.Fatal(err)
+	}
+	n, err := enode.New(enode.ValidSchemes, &r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func getJSON(t *testing.T, url string, result interface{}) int {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK && result != nil {
+		if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode
+}
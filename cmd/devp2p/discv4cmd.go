@@ -19,14 +19,12 @@ package main
 import (
 	"fmt"
 	"net"
-	"os"
 	"strings"
 	"time"
 
 	"github.com/mxt/go-mxt/cmd/devp2p/internal/v4test"
 	"github.com/mxt/go-mxt/common"
 	"github.com/mxt/go-mxt/crypto"
-	"github.com/mxt/go-mxt/internal/utesting"
 	"github.com/mxt/go-mxt/p2p/discover"
 	"github.com/mxt/go-mxt/p2p/enode"
 	"github.com/mxt/go-mxt/params"
@@ -43,6 +41,7 @@ var (
 			discv4ResolveCommand,
 			discv4ResolveJSONCommand,
 			discv4CrawlCommand,
+			discv4CrawlServiceCommand,
 			discv4TestCommand,
 		},
 	}
@@ -78,11 +77,18 @@ var (
 		Action: discv4Crawl,
 		Flags:  []cli.Flag{bootnodesFlag, crawlTimeoutFlag},
 	}
+	discv4CrawlServiceCommand = cli.Command{
+		Name:      "crawl-service",
+		Usage:     "Continuously crawls the DHT, keeping a nodes.json file up to date",
+		ArgsUsage: "<nodes.json file>",
+		Action:    discv4CrawlService,
+		Flags:     crawlServiceFlags,
+	}
 	discv4TestCommand = cli.Command{
 		Name:   "test",
 		Usage:  "Runs tests against a node",
 		Action: discv4Test,
-		Flags:  []cli.Flag{remoteEnodeFlag, testPatternFlag, testListen1Flag, testListen2Flag},
+		Flags:  []cli.Flag{remoteEnodeFlag, testPatternFlag, testTAPFlag, testListen1Flag, testListen2Flag},
 	}
 )
 
@@ -117,6 +123,10 @@ var (
 		Name:  "run",
 		Usage: "Pattern of test suite(s) to run",
 	}
+	testTAPFlag = cli.BoolFlag{
+		Name:  "tap",
+		Usage: "Report test results in TAP format",
+	}
 	testListen1Flag = cli.StringFlag{
 		Name:  "listen1",
 		Usage: "IP address of the first tester",
@@ -213,6 +223,15 @@ func discv4Crawl(ctx *cli.Context) error {
 	return nil
 }
 
+func discv4CrawlService(ctx *cli.Context) error {
+	if ctx.NArg() < 1 {
+		return fmt.Errorf("need nodes file as argument")
+	}
+	disc := startV4(ctx)
+	defer disc.Close()
+	return runCrawlService(ctx, ctx.Args().First(), disc, disc.RandomNodes())
+}
+
 func discv4Test(ctx *cli.Context) error {
 	// Configure test package globals.
 	if !ctx.IsSet(remoteEnodeFlag.Name) {
@@ -223,16 +242,7 @@ func discv4Test(ctx *cli.Context) error {
 	v4test.Listen2 = ctx.String(testListen2Flag.Name)
 
 	// Filter and run test cases.
-	tests := v4test.AllTests
-	if ctx.IsSet(testPatternFlag.Name) {
-		tests = utesting.MatchTests(tests, ctx.String(testPatternFlag.Name))
-	}
-	results := utesting.RunTests(tests, os.Stdout)
-	if fails := utesting.CountFailures(results); fails > 0 {
-		return fmt.Errorf("%v/%v tests passed.", len(tests)-fails, len(tests))
-	}
-	fmt.Printf("%v/%v passed\n", len(tests), len(tests))
-	return nil
+	return runTests(ctx, v4test.AllTests)
 }
 
 // startV4 starts an ephemeral discovery V4 node.
@@ -18,12 +18,10 @@ package main
 
 import (
 	"fmt"
-	"os"
 	"time"
 
 	"github.com/mxt/go-mxt/cmd/devp2p/internal/v5test"
 	"github.com/mxt/go-mxt/common"
-	"github.com/mxt/go-mxt/internal/utesting"
 	"github.com/mxt/go-mxt/log"
 	"github.com/mxt/go-mxt/p2p/discover"
 	"gopkg.in/urfave/cli.v1"
@@ -37,6 +35,7 @@ var (
 			discv5PingCommand,
 			discv5ResolveCommand,
 			discv5CrawlCommand,
+			discv5CrawlServiceCommand,
 			discv5TestCommand,
 			discv5ListenCommand,
 		},
@@ -58,11 +57,18 @@ var (
 		Action: discv5Crawl,
 		Flags:  []cli.Flag{bootnodesFlag, crawlTimeoutFlag},
 	}
+	discv5CrawlServiceCommand = cli.Command{
+		Name:      "crawl-service",
+		Usage:     "Continuously crawls the DHT, keeping a nodes.json file up to date",
+		ArgsUsage: "<nodes.json file>",
+		Action:    discv5CrawlService,
+		Flags:     crawlServiceFlags,
+	}
 	discv5TestCommand = cli.Command{
 		Name:   "test",
 		Usage:  "Runs protocol tests against a node",
 		Action: discv5Test,
-		Flags:  []cli.Flag{testPatternFlag, testListen1Flag, testListen2Flag},
+		Flags:  []cli.Flag{testPatternFlag, testTAPFlag, testListen1Flag, testListen2Flag},
 	}
 	discv5ListenCommand = cli.Command{
 		Name:   "listen",
@@ -114,6 +120,15 @@ func discv5Crawl(ctx *cli.Context) error {
 	return nil
 }
 
+func discv5CrawlService(ctx *cli.Context) error {
+	if ctx.NArg() < 1 {
+		return fmt.Errorf("need nodes file as argument")
+	}
+	disc := startV5(ctx)
+	defer disc.Close()
+	return runCrawlService(ctx, ctx.Args().First(), disc, disc.RandomNodes())
+}
+
 func discv5Test(ctx *cli.Context) error {
 	// Disable logging unless explicitly enabled.
 	if !ctx.GlobalIsSet("verbosity") && !ctx.GlobalIsSet("vmodule") {
@@ -126,16 +141,7 @@ func discv5Test(ctx *cli.Context) error {
 		Listen1: ctx.String(testListen1Flag.Name),
 		Listen2: ctx.String(testListen2Flag.Name),
 	}
-	tests := suite.AllTests()
-	if ctx.IsSet(testPatternFlag.Name) {
-		tests = utesting.MatchTests(tests, ctx.String(testPatternFlag.Name))
-	}
-	results := utesting.RunTests(tests, os.Stdout)
-	if fails := utesting.CountFailures(results); fails > 0 {
-		return fmt.Errorf("%v/%v tests passed.", len(tests)-fails, len(tests))
-	}
-	fmt.Printf("%v/%v passed\n", len(tests), len(tests))
-	return nil
+	return runTests(ctx, suite.AllTests())
 }
 
 func discv5Listen(ctx *cli.Context) error {
@@ -0,0 +1,65 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of go-mxt.
+//
+// go-mxt is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-mxt is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-mxt. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/mxt/go-mxt/log"
+	"github.com/mxt/go-mxt/p2p/dnsdisc"
+	"gopkg.in/urfave/cli.v1"
+)
+
+var (
+	dnsServerAddrFlag = cli.StringFlag{
+		Name:  "addr",
+		Usage: "UDP and TCP listening address of the DNS server",
+		Value: ":53",
+	}
+)
+
+// dnsServe performs dnsServeCommand. It serves the given trees until interrupted.
+func dnsServe(ctx *cli.Context) error {
+	if ctx.NArg() < 1 {
+		return fmt.Errorf("need tree definition directory as argument")
+	}
+	srv := dnsdisc.NewServer(dnsdisc.ServerConfig{
+		RootTTL:  rootTTL * time.Second,
+		EntryTTL: treeNodeTTL * time.Second,
+	})
+	for _, dir := range ctx.Args() {
+		domain, t, err := loadTreeDefinitionForExport(dir)
+		if err != nil {
+			return err
+		}
+		log.Info(fmt.Sprintf("Serving tree of %s with %d nodes", domain, len(t.Nodes())))
+		srv.SetTree(domain, t)
+	}
+	if err := srv.Start(ctx.String(dnsServerAddrFlag.Name)); err != nil {
+		return err
+	}
+	defer srv.Close()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+	return nil
+}
@@ -23,6 +23,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/mxt/go-mxt/accounts/keystore"
@@ -43,6 +44,7 @@ var (
 			dnsTXTCommand,
 			dnsCloudflareCommand,
 			dnsRoute53Command,
+			dnsServeCommand,
 		},
 	}
 	dnsSyncCommand = cli.Command{
@@ -79,6 +81,13 @@ var (
 		Action:    dnsToRoute53,
 		Flags:     []cli.Flag{route53AccessKeyFlag, route53AccessSecretFlag, route53ZoneIDFlag},
 	}
+	dnsServeCommand = cli.Command{
+		Name:      "serve",
+		Usage:     "Serve DNS TXT records from a built-in authoritative DNS server",
+		ArgsUsage: "<tree-directory> [ <tree-directory> ... ]",
+		Action:    dnsServe,
+		Flags:     []cli.Flag{dnsServerAddrFlag},
+	}
 )
 
 var (
@@ -157,7 +166,7 @@ func dnsSign(ctx *cli.Context) error {
 		return err
 	}
 
-	key := loadSigningKey(keyfile)
+	key := loadSigningKey(keyfile, "")
 	url, err := t.Sign(key, domain)
 	if err != nil {
 		return fmt.Errorf("can't sign: %v", err)
@@ -220,13 +229,23 @@ func dnsToRoute53(ctx *cli.Context) error {
 	return client.deploy(domain, t)
 }
 
-// loadSigningKey loads a private key in Ethereum keystore format.
-func loadSigningKey(keyfile string) *ecdsa.PrivateKey {
+// loadSigningKey loads a private key in Ethereum keystore format. The password is read
+// from passwordFile, or prompted for if passwordFile is empty.
+func loadSigningKey(keyfile, passwordFile string) *ecdsa.PrivateKey {
 	keyjson, err := ioutil.ReadFile(keyfile)
 	if err != nil {
 		exit(fmt.Errorf("failed to read the keyfile at '%s': %v", keyfile, err))
 	}
-	password, _ := prompt.Stdin.PromptPassword("Please enter the password for '" + keyfile + "': ")
+	var password string
+	if passwordFile != "" {
+		text, err := ioutil.ReadFile(passwordFile)
+		if err != nil {
+			exit(fmt.Errorf("failed to read the password file at '%s': %v", passwordFile, err))
+		}
+		password = strings.TrimRight(string(text), "\r\n")
+	} else {
+		password, _ = prompt.Stdin.PromptPassword("Please enter the password for '" + keyfile + "': ")
+	}
 	key, err := keystore.DecryptKey(keyjson, password)
 	if err != nil {
 		exit(fmt.Errorf("error decrypting key: %v", err))
@@ -26,6 +26,7 @@ import (
 	"os"
 	"strings"
 
+	"github.com/mxt/go-mxt/common"
 	"github.com/mxt/go-mxt/core"
 	"github.com/mxt/go-mxt/core/forkid"
 	"github.com/mxt/go-mxt/core/types"
@@ -64,7 +65,7 @@ func (c *Chain) TD(height int) *big.Int { // TODO later on channge scheme so tha
 
 // ForkID gets the fork id of the chain.
 func (c *Chain) ForkID() forkid.ID {
-	return forkid.NewID(c.chainConfig, c.blocks[0].Hash(), uint64(c.Len()))
+	return forkid.NewID(c.chainConfig, c.blocks[0].Hash(), uint64(c.Len()), c.blocks[c.Len()-1].Time())
 }
 
 // Shorten returns a copy chain of a desired height from the imported
@@ -84,41 +85,61 @@ func (c *Chain) Head() *types.Block {
 	return c.blocks[c.Len()-1]
 }
 
+// GetHeaders returns the headers matching the given request. Like a node, it returns
+// fewer headers than requested if the range extends beyond the chain.
 func (c *Chain) GetHeaders(req GetBlockHeaders) (BlockHeaders, error) {
 	if req.Amount < 1 {
 		return nil, fmt.Errorf("no block headers requested")
 	}
 
-	headers := make(BlockHeaders, req.Amount)
-	var blockNumber uint64
-
-	// range over blocks to check if our chain has the requested header
-	for _, block := range c.blocks {
-		if block.Hash() == req.Origin.Hash || block.Number().Uint64() == req.Origin.Number {
-			headers[0] = block.Header()
-			blockNumber = block.Number().Uint64()
+	// find the origin of the range
+	number, found := req.Origin.Number, false
+	if req.Origin.Hash != (common.Hash{}) {
+		for _, block := range c.blocks {
+			if block.Hash() == req.Origin.Hash {
+				number, found = block.NumberU64(), true
+				break
+			}
 		}
+	} else {
+		found = number < uint64(c.Len())
 	}
-	if headers[0] == nil {
+	if !found {
 		return nil, fmt.Errorf("no headers found for given origin number %v, hash %v", req.Origin.Number, req.Origin.Hash)
 	}
 
-	if req.Reverse {
-		for i := 1; i < int(req.Amount); i++ {
-			blockNumber -= (1 - req.Skip)
-			headers[i] = c.blocks[blockNumber].Header()
-
+	headers := BlockHeaders{c.blocks[number].Header()}
+	step := req.Skip + 1
+	for uint64(len(headers)) < req.Amount {
+		if req.Reverse {
+			if number < step {
+				break
+			}
+			number -= step
+		} else {
+			if number+step >= uint64(c.Len()) {
+				break
+			}
+			number += step
 		}
-
-		return headers, nil
+		headers = append(headers, c.blocks[number].Header())
 	}
+	return headers, nil
+}
 
-	for i := 1; i < int(req.Amount); i++ {
-		blockNumber += (1 + req.Skip)
-		headers[i] = c.blocks[blockNumber].Header()
+// GetBodies returns the bodies of the blocks with the given hashes. Unknown blocks
+// are skipped.
+func (c *Chain) GetBodies(hashes GetBlockBodies) BlockBodies {
+	bodies := make(BlockBodies, 0, len(hashes))
+	for _, hash := range hashes {
+		for _, block := range c.blocks {
+			if block.Hash() == hash {
+				bodies = append(bodies, block.Body())
+				break
+			}
+		}
 	}
-
-	return headers, nil
+	return bodies
 }
 
 // loadChain takes the given chain.rlp file, and decodes and returns
@@ -0,0 +1,103 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package mxttest
+
+import (
+	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/core/forkid"
+	"github.com/mxt/go-mxt/internal/utesting"
+	"github.com/mxt/go-mxt/rlp"
+)
+
+// maxMessageSize is the largest message size accepted by the mxt protocol.
+const maxMessageSize = 10 * 1024 * 1024
+
+// TestMaliciousStatus tests whmxter the node drops peers which send a status
+// message for another network or chain.
+func (s *Suite) TestMaliciousStatus(t *utesting.T) {
+	valid := func() Status {
+		return Status{
+			NetworkID: 1,
+			TD:        s.chain.TD(s.chain.Len()),
+			Head:      s.chain.Head().Hash(),
+			Genesis:   s.chain.blocks[0].Hash(),
+			ForkID:    s.chain.ForkID(),
+		}
+	}
+	tests := []struct {
+		name   string
+		modify func(*Status)
+	}{
+		{"wrong network ID", func(st *Status) { st.NetworkID = 999 }},
+		{"wrong genesis", func(st *Status) { st.Genesis = common.Hash{1} }},
+		{"incompatible fork ID", func(st *Status) { st.ForkID = forkid.ID{Hash: [4]byte{0xde, 0xad, 0xbe, 0xef}} }},
+		{"wrong protocol version", func(st *Status) { st.ProtocolVersion = 1 }},
+	}
+	for _, test := range tests {
+		conn, err := s.dial()
+		if err != nil {
+			t.Fatalf("could not dial: %v", err)
+		}
+		conn.handshake(t)
+		status := valid()
+		status.ProtocolVersion = uint32(conn.mxtProtocolVersion)
+		test.modify(&status)
+		conn.statusExchange(t, s.chain, &status)
+		if err := conn.waitForDisconnect(s.chain); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		conn.Close()
+	}
+}
+
+// TestMalformedMessages tests whmxter the node drops peers which send messages it
+// can't decode, messages with unknown codes and oversized messages.
+func (s *Suite) TestMalformedMessages(t *utesting.T) {
+	status, err := rlp.EncodeToBytes(Status{
+		ProtocolVersion: 64,
+		NetworkID:       1,
+		TD:              s.chain.TD(s.chain.Len()),
+		Head:            s.chain.Head().Hash(),
+		Genesis:         s.chain.blocks[0].Hash(),
+		ForkID:          s.chain.ForkID(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		code    int
+		payload []byte
+	}{
+		{"truncated GetBlockHeaders", GetBlockHeaders{}.Code(), []byte{0xc3, 0x01}},
+		{"undecodable NewBlock", NewBlock{}.Code(), []byte{0xc2, 0x01, 0x02}},
+		{"unused message code", Status{}.Code() + 0x0b, []byte{0xc0}},
+		{"message code beyond all protocols", Status{}.Code() + 0xff, []byte{0xc0}},
+		{"second status message", Status{}.Code(), status},
+		{"oversized message", GetBlockBodies{}.Code(), make([]byte, maxMessageSize+1)},
+	}
+	for _, test := range tests {
+		conn := s.setupConn(t)
+		if _, err := conn.Conn.Write(uint64(test.code), test.payload); err != nil {
+			t.Fatalf("%s: could not write to connection: %v", test.name, err)
+		}
+		if err := conn.waitForDisconnect(s.chain); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		conn.Close()
+	}
+}
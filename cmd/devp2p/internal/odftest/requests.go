@@ -0,0 +1,250 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package mxttest
+
+import (
+	"reflect"
+
+	"github.com/mxt/go-mxt/core/types"
+	"github.com/mxt/go-mxt/crypto"
+	"github.com/mxt/go-mxt/internal/utesting"
+	"github.com/mxt/go-mxt/trie"
+)
+
+// largeRequestSize is the number of items requested in large requests. It exceeds
+// the serving limits of all known implementations.
+const largeRequestSize = 4096
+
+// unknownHash is a hash which doesn't belong to any block, trie node or transaction.
+var unknownHash = crypto.Keccak256Hash([]byte("unknown"))
+
+// TestHeaderRanges tests whmxter the node serves header ranges correctly, including
+// ranges with gaps, in reverse order, and ranges extending beyond either end of
+// the chain.
+func (s *Suite) TestHeaderRanges(t *utesting.T) {
+	conn := s.setupConn(t)
+	defer conn.Close()
+
+	head := uint64(s.chain.Len() - 1)
+	middle := s.chain.blocks[head/2].Hash()
+	requests := []GetBlockHeaders{
+		{Origin: hashOrNumber{Number: 10}, Amount: 10, Skip: 3},
+		{Origin: hashOrNumber{Number: head}, Amount: 5, Skip: 2, Reverse: true},
+		{Origin: hashOrNumber{Number: head - 2}, Amount: 10},
+		{Origin: hashOrNumber{Number: 3}, Amount: 10, Reverse: true},
+		{Origin: hashOrNumber{Hash: middle}, Amount: 4, Skip: 99},
+		{Origin: hashOrNumber{Hash: middle}, Amount: 10, Skip: 199, Reverse: true},
+	}
+	for i, req := range requests {
+		want, err := s.chain.GetHeaders(req)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		if err := conn.Write(&req); err != nil {
+			t.Fatalf("could not write to connection: %v", err)
+		}
+		switch msg := conn.readResponse(s.chain, BlockHeaders{}.Code()).(type) {
+		case *BlockHeaders:
+			if !headersEqual(*msg, want) {
+				t.Errorf("request %d (%+v): got %d headers, want %d", i, req, len(*msg), len(want))
+			}
+		default:
+			t.Fatalf("request %d: unexpected: %#v", i, msg)
+		}
+	}
+}
+
+// TestGetReceipts tests whmxter the node serves the receipts of blocks.
+func (s *Suite) TestGetReceipts(t *utesting.T) {
+	conn := s.setupConn(t)
+	defer conn.Close()
+
+	blocks := s.chain.blocks[1:11]
+	req := make(GetReceipts, len(blocks))
+	for i, block := range blocks {
+		req[i] = block.Hash()
+	}
+	if err := conn.Write(req); err != nil {
+		t.Fatalf("could not write to connection: %v", err)
+	}
+	switch msg := conn.readResponse(s.chain, Receipts{}.Code()).(type) {
+	case *Receipts:
+		if len(*msg) != len(blocks) {
+			t.Fatalf("wrong number of receipt lists: got %d, want %d", len(*msg), len(blocks))
+		}
+		for i, receipts := range *msg {
+			hash := types.DeriveSha(types.Receipts(receipts), trie.NewStackTrie(nil))
+			if hash != blocks[i].ReceiptHash() {
+				t.Errorf("wrong receipts for block %d", blocks[i].NumberU64())
+			}
+		}
+	default:
+		t.Fatalf("unexpected: %#v", msg)
+	}
+}
+
+// TestGetNodeData tests whmxter the node serves the state trie root node of its
+// head block.
+func (s *Suite) TestGetNodeData(t *utesting.T) {
+	conn := s.setupConn(t)
+	defer conn.Close()
+
+	root := s.chain.Head().Root()
+	if err := conn.Write(GetNodeData{root}); err != nil {
+		t.Fatalf("could not write to connection: %v", err)
+	}
+	switch msg := conn.readResponse(s.chain, NodeData{}.Code()).(type) {
+	case *NodeData:
+		if len(*msg) != 1 {
+			t.Fatalf("wrong number of trie nodes: got %d, want 1", len(*msg))
+		}
+		if hash := crypto.Keccak256Hash((*msg)[0]); hash != root {
+			t.Fatalf("wrong trie node: hash %v, want %v", hash, root)
+		}
+	default:
+		t.Fatalf("unexpected: %#v", msg)
+	}
+}
+
+// TestLargeRequests tests whmxter the node answers requests exceeding its serving
+// limits with a correct, possibly truncated response instead of dropping the peer.
+func (s *Suite) TestLargeRequests(t *utesting.T) {
+	conn := s.setupConn(t)
+	defer conn.Close()
+
+	// headers
+	req := &GetBlockHeaders{Origin: hashOrNumber{Number: 1}, Amount: largeRequestSize}
+	if err := conn.Write(req); err != nil {
+		t.Fatalf("could not write to connection: %v", err)
+	}
+	switch msg := conn.readResponse(s.chain, BlockHeaders{}.Code()).(type) {
+	case *BlockHeaders:
+		if len(*msg) == 0 {
+			t.Fatalf("no headers in response")
+		}
+		if !headersEqual(*msg, headersOf(s.chain.blocks[1:1+len(*msg)])) {
+			t.Fatalf("wrong headers in response")
+		}
+	default:
+		t.Fatalf("unexpected: %#v", msg)
+	}
+
+	// bodies
+	var hashes GetBlockBodies
+	for len(hashes) < largeRequestSize {
+		for _, block := range s.chain.blocks[1:] {
+			hashes = append(hashes, block.Hash())
+		}
+	}
+	if err := conn.Write(hashes); err != nil {
+		t.Fatalf("could not write to connection: %v", err)
+	}
+	switch msg := conn.readResponse(s.chain, BlockBodies{}.Code()).(type) {
+	case *BlockBodies:
+		if len(*msg) == 0 {
+			t.Fatalf("no bodies in response")
+		}
+		for i, body := range *msg {
+			block := s.chain.blocks[1+i%(s.chain.Len()-1)]
+			txHash := types.DeriveSha(types.Transactions(body.Transactions), trie.NewStackTrie(nil))
+			if txHash != block.TxHash() || types.CalcUncleHash(body.Uncles) != block.UncleHash() {
+				t.Fatalf("wrong body %d in response", i)
+			}
+		}
+	default:
+		t.Fatalf("unexpected: %#v", msg)
+	}
+}
+
+// TestZeroLengthResponses tests whmxter the node answers requests for unknown
+// items with empty responses and keeps the connection open.
+func (s *Suite) TestZeroLengthResponses(t *utesting.T) {
+	conn := s.setupConn(t)
+	defer conn.Close()
+
+	requests := []Message{
+		&GetBlockHeaders{Origin: hashOrNumber{Hash: unknownHash}, Amount: 1},
+		&GetBlockHeaders{Origin: hashOrNumber{Number: uint64(s.chain.Len() + 1000)}, Amount: 1},
+		&GetBlockBodies{unknownHash},
+		&GetReceipts{unknownHash},
+		&GetNodeData{unknownHash},
+	}
+	if conn.mxtProtocolVersion >= 65 {
+		requests = append(requests, &GetPooledTransactions{unknownHash})
+	}
+	for _, req := range requests {
+		if err := conn.Write(req); err != nil {
+			t.Fatalf("could not write to connection: %v", err)
+		}
+		want := req.Code() + 1 // responses directly follow their requests
+		msg := conn.readResponse(s.chain, want)
+		if msg.Code() != want {
+			t.Fatalf("unexpected response to %T: %#v", req, msg)
+		}
+		if n := reflect.ValueOf(msg).Elem().Len(); n != 0 {
+			t.Errorf("non-empty response to %T: %d items", req, n)
+		}
+	}
+}
+
+// TestEmptyResponses tests whmxter the node tolerates empty response messages,
+// which peers send when they don't have the requested items.
+func (s *Suite) TestEmptyResponses(t *utesting.T) {
+	conn := s.setupConn(t)
+	defer conn.Close()
+
+	for _, msg := range []Message{&BlockHeaders{}, &BlockBodies{}, &NodeData{}, &Receipts{}} {
+		if err := conn.Write(msg); err != nil {
+			t.Fatalf("could not write to connection: %v", err)
+		}
+	}
+	// the connection must still be usable
+	req := &GetBlockHeaders{Origin: hashOrNumber{Number: 1}, Amount: 1}
+	if err := conn.Write(req); err != nil {
+		t.Fatalf("could not write to connection: %v", err)
+	}
+	switch msg := conn.readResponse(s.chain, BlockHeaders{}.Code()).(type) {
+	case *BlockHeaders:
+		if !headersEqual(*msg, headersOf(s.chain.blocks[1:2])) {
+			t.Fatalf("wrong headers in response")
+		}
+	default:
+		t.Fatalf("unexpected: %#v", msg)
+	}
+}
+
+// headersOf returns the headers of the given blocks.
+func headersOf(blocks []*types.Block) BlockHeaders {
+	headers := make(BlockHeaders, len(blocks))
+	for i, block := range blocks {
+		headers[i] = block.Header()
+	}
+	return headers
+}
+
+// headersEqual reports whmxter two header lists contain the same headers.
+func headersEqual(a, b BlockHeaders) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Hash() != b[i].Hash() {
+			return false
+		}
+	}
+	return true
+}
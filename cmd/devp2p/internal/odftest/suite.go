@@ -18,8 +18,12 @@ package mxttest
 
 import (
 	"fmt"
+	"math/big"
 	"net"
+	"time"
 
+	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/core/types"
 	"github.com/mxt/go-mxt/crypto"
 	"github.com/mxt/go-mxt/internal/utesting"
 	"github.com/mxt/go-mxt/p2p/enode"
@@ -27,6 +31,9 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+// announceTimeout is the time to wait for announcements which must not be relayed.
+const announceTimeout = 3 * time.Second
+
 // Suite represents a structure used to test the mxt
 // protocol of a node(s).
 type Suite struct {
@@ -34,6 +41,7 @@ type Suite struct {
 
 	chain     *Chain
 	fullChain *Chain
+	txNonce   uint64 // nonce of the next transaction sent by the faucet account
 }
 
 // NewSuite creates and returns a new mxt-test suite that can
@@ -51,12 +59,32 @@ func NewSuite(dest *enode.Node, chainfile string, genesisfile string) *Suite {
 	}
 }
 
+// AllTests returns all tests of the suite. The tests must run in order against a
+// node which was initialized with the first 1000 blocks of the test chain: later
+// tests depend on the blocks imported in earlier ones, and the node only accepts
+// transactions once it imported a propagated block.
 func (s *Suite) AllTests() []utesting.Test {
 	return []utesting.Test{
+		// status and request handling
 		{Name: "Status", Fn: s.TestStatus},
+		{Name: "MaliciousStatus", Fn: s.TestMaliciousStatus},
 		{Name: "GetBlockHeaders", Fn: s.TestGetBlockHeaders},
-		{Name: "Broadcast", Fn: s.TestBroadcast},
+		{Name: "HeaderRanges", Fn: s.TestHeaderRanges},
 		{Name: "GetBlockBodies", Fn: s.TestGetBlockBodies},
+		{Name: "GetReceipts", Fn: s.TestGetReceipts},
+		{Name: "GetNodeData", Fn: s.TestGetNodeData},
+		{Name: "LargeRequests", Fn: s.TestLargeRequests},
+		{Name: "ZeroLengthResponses", Fn: s.TestZeroLengthResponses},
+		{Name: "EmptyResponses", Fn: s.TestEmptyResponses},
+		{Name: "MalformedMessages", Fn: s.TestMalformedMessages},
+		// block propagation
+		{Name: "Broadcast", Fn: s.TestBroadcast},
+		{Name: "BlockHashAnnounce", Fn: s.TestBlockHashAnnounce},
+		{Name: "OldAnnounce", Fn: s.TestOldAnnounce},
+		// transaction propagation
+		{Name: "Transaction", Fn: s.TestTransaction},
+		{Name: "MaliciousTransactions", Fn: s.TestMaliciousTransactions},
+		{Name: "GetPooledTransactions", Fn: s.TestGetPooledTransactions},
 	}
 }
 
@@ -68,10 +96,11 @@ func (s *Suite) TestStatus(t *utesting.T) {
 	if err != nil {
 		t.Fatalf("could not dial: %v", err)
 	}
+	defer conn.Close()
 	// get protoHandshake
 	conn.handshake(t)
 	// get status
-	switch msg := conn.statusExchange(t, s.chain).(type) {
+	switch msg := conn.statusExchange(t, s.chain, nil).(type) {
 	case *Status:
 		t.Logf("%+v\n", msg)
 	default:
@@ -86,9 +115,10 @@ func (s *Suite) TestGetBlockHeaders(t *utesting.T) {
 	if err != nil {
 		t.Fatalf("could not dial: %v", err)
 	}
+	defer conn.Close()
 
 	conn.handshake(t)
-	conn.statusExchange(t, s.chain)
+	conn.statusExchange(t, s.chain, nil)
 
 	// get block headers
 	req := &GetBlockHeaders{
@@ -124,9 +154,10 @@ func (s *Suite) TestGetBlockBodies(t *utesting.T) {
 	if err != nil {
 		t.Fatalf("could not dial: %v", err)
 	}
+	defer conn.Close()
 
 	conn.handshake(t)
-	conn.statusExchange(t, s.chain)
+	conn.statusExchange(t, s.chain, nil)
 	// create block bodies request
 	req := &GetBlockBodies{s.chain.blocks[54].Hash(), s.chain.blocks[75].Hash()}
 	if err := conn.Write(req); err != nil {
@@ -152,17 +183,19 @@ func (s *Suite) TestBroadcast(t *utesting.T) {
 	if err != nil {
 		t.Fatalf("could not dial: %v", err)
 	}
+	defer sendConn.Close()
 	// create conn to receive block announcement
 	receiveConn, err := s.dial()
 	if err != nil {
 		t.Fatalf("could not dial: %v", err)
 	}
+	defer receiveConn.Close()
 
 	sendConn.handshake(t)
 	receiveConn.handshake(t)
 
-	sendConn.statusExchange(t, s.chain)
-	receiveConn.statusExchange(t, s.chain)
+	sendConn.statusExchange(t, s.chain, nil)
+	receiveConn.statusExchange(t, s.chain, nil)
 
 	// sendConn sends the block announcement
 	blockAnnouncement := &NewBlock{
@@ -194,6 +227,97 @@ func (s *Suite) TestBroadcast(t *utesting.T) {
 	}
 }
 
+// TestBlockHashAnnounce tests whmxter the node fetches a block announced by
+// hash from the announcing peer, and propagates it after import.
+func (s *Suite) TestBlockHashAnnounce(t *utesting.T) {
+	sendConn, receiveConn := s.setupConn(t), s.setupConn(t)
+	defer sendConn.Close()
+	defer receiveConn.Close()
+
+	next := s.fullChain.blocks[s.chain.Len()]
+	announcement := NewBlockHashes{{Hash: next.Hash(), Number: next.NumberU64()}}
+	if err := sendConn.Write(announcement); err != nil {
+		t.Fatalf("could not write to connection: %v", err)
+	}
+	// serve the header and body requests of the node
+	go sendConn.serve(s.fullChain)
+
+	if err := waitForAnnouncement(receiveConn, s.chain, next); err != nil {
+		t.Fatal(err)
+	}
+	// update test suite chain
+	s.chain.blocks = append(s.chain.blocks, next)
+	if err := receiveConn.waitForBlock(next); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestOldAnnounce tests that the node doesn't relay the announcement of a block
+// below its head, as sent by a peer which is on a stale fork or lags behind a
+// reorg. The announcement claims a higher total difficulty than the chain head.
+func (s *Suite) TestOldAnnounce(t *utesting.T) {
+	sendConn, receiveConn := s.setupConn(t), s.setupConn(t)
+	defer sendConn.Close()
+	defer receiveConn.Close()
+
+	old := s.chain.blocks[s.chain.Len()/2]
+	announcement := &NewBlock{
+		Block: old,
+		TD:    new(big.Int).Add(s.chain.TD(s.chain.Len()), common.Big1),
+	}
+	if err := sendConn.Write(announcement); err != nil {
+		t.Fatalf("could not write to connection: %v", err)
+	}
+	// serve the sync requests triggered by the higher TD
+	go sendConn.serve(s.chain)
+
+	for {
+		switch msg := receiveConn.readWithin(announceTimeout).(type) {
+		case *NewBlock:
+			if msg.Block.Hash() == old.Hash() {
+				t.Fatalf("old block announcement was propagated")
+			}
+		case *NewBlockHashes:
+			for _, a := range *msg {
+				if a.Hash == old.Hash() {
+					t.Fatalf("old block announcement was propagated")
+				}
+			}
+		case *Ping:
+			receiveConn.Write(&Pong{})
+		case *Error:
+			if !isTimeout(msg) {
+				t.Fatal(msg)
+			}
+			return
+		}
+	}
+}
+
+// waitForAnnouncement waits for the announcement of the given block on conn.
+func waitForAnnouncement(conn *Conn, chain *Chain, block *types.Block) error {
+	for {
+		switch msg := conn.ReadAndServe(chain).(type) {
+		case *NewBlock:
+			if msg.Block.Hash() == block.Hash() {
+				return nil
+			}
+		case *NewBlockHashes:
+			for _, a := range *msg {
+				if a.Hash == block.Hash() {
+					return nil
+				}
+			}
+		case *Transactions, *NewPooledTransactionHashes:
+			continue
+		case *Error:
+			return fmt.Errorf("block %d not announced: %v", block.NumberU64(), msg)
+		default:
+			return fmt.Errorf("unexpected: %#v", msg)
+		}
+	}
+}
+
 // dial attempts to dial the given node and perform a handshake,
 // returning the created Conn if successful.
 func (s *Suite) dial() (*Conn, error) {
@@ -214,3 +338,14 @@ func (s *Suite) dial() (*Conn, error) {
 
 	return &conn, nil
 }
+
+// setupConn dials the node and performs the protocol handshake and status exchange.
+func (s *Suite) setupConn(t *utesting.T) *Conn {
+	conn, err := s.dial()
+	if err != nil {
+		t.Fatalf("could not dial: %v", err)
+	}
+	conn.handshake(t)
+	conn.statusExchange(t, s.chain, nil)
+	return conn
+}
@@ -0,0 +1,96 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package mxttest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mxt/go-mxt/consensus/mxtash"
+	"github.com/mxt/go-mxt/core"
+	"github.com/mxt/go-mxt/internal/utesting"
+	"github.com/mxt/go-mxt/mxt"
+	"github.com/mxt/go-mxt/mxt/downloader"
+	"github.com/mxt/go-mxt/node"
+	"github.com/mxt/go-mxt/p2p"
+)
+
+var (
+	chainFile   = filepath.Join("testdata", "chain.rlp.gz")
+	genesisFile = filepath.Join("testdata", "genesis.json")
+)
+
+// TestSuite runs the whole test suite against an in-process node.
+func TestSuite(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping conformance suite in short mode")
+	}
+	stack, backend := runTestNode(t)
+	defer stack.Close()
+
+	suite := NewSuite(stack.Server().Self(), chainFile, genesisFile)
+	if _, err := backend.BlockChain().InsertChain(suite.chain.blocks[1:]); err != nil {
+		t.Fatalf("can't import test blocks: %v", err)
+	}
+	for _, test := range suite.AllTests() {
+		test := test
+		t.Run(test.Name, func(t *testing.T) {
+			results := utesting.RunTAP([]utesting.Test{test}, os.Stdout)
+			if results[0].Failed {
+				t.Fatal(results[0].Output)
+			}
+		})
+	}
+}
+
+// runTestNode starts a node with the genesis block of the test chain.
+func runTestNode(t *testing.T) (*node.Node, *mxt.Ethereum) {
+	data, err := ioutil.ReadFile(genesisFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var genesis core.Genesis
+	if err := json.Unmarshal(data, &genesis); err != nil {
+		t.Fatal(err)
+	}
+	stack, err := node.New(&node.Config{
+		P2P: p2p.Config{
+			ListenAddr:  "127.0.0.1:0",
+			NoDiscovery: true,
+			NoDial:      true,
+			MaxPeers:    10,
+		},
+	})
+	if err != nil {
+		t.Fatalf("can't create node: %v", err)
+	}
+	config := &mxt.Config{Genesis: &genesis, NetworkId: 1, SyncMode: downloader.FullSync}
+	config.Ethash.PowMode = mxtash.ModeFake
+	backend, err := mxt.New(stack, config)
+	if err != nil {
+		stack.Close()
+		t.Fatalf("can't create mxt service: %v", err)
+	}
+	if err := stack.Start(); err != nil {
+		stack.Close()
+		t.Fatalf("can't start node: %v", err)
+	}
+	return stack, backend
+}
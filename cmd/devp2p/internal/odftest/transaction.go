@@ -0,0 +1,194 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package mxttest
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/core/types"
+	"github.com/mxt/go-mxt/crypto"
+	"github.com/mxt/go-mxt/internal/utesting"
+	"github.com/mxt/go-mxt/params"
+)
+
+// faucetKey is the key of the account funded in the genesis block of the test chain.
+var faucetKey, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+
+// TestTransaction tests whmxter a valid transaction is propagated to the
+// node's other peers.
+func (s *Suite) TestTransaction(t *utesting.T) {
+	sendConn, receiveConn := s.setupConn(t), s.setupConn(t)
+	defer sendConn.Close()
+	defer receiveConn.Close()
+
+	tx := s.nextTx(t)
+	if err := sendConn.Write(Transactions{tx}); err != nil {
+		t.Fatalf("could not write to connection: %v", err)
+	}
+	if err := waitForTxs(receiveConn, s.chain, tx.Hash()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestMaliciousTransactions tests whmxter invalid transactions are not
+// propagated to the node's other peers.
+func (s *Suite) TestMaliciousTransactions(t *utesting.T) {
+	sendConn, receiveConn := s.setupConn(t), s.setupConn(t)
+	defer sendConn.Close()
+	defer receiveConn.Close()
+
+	var (
+		nonce    = s.txNonce
+		to       = common.Address{1}
+		gasLimit = s.chain.Head().GasLimit()
+	)
+	txs := Transactions{
+		s.signTx(t, types.NewTransaction(nonce, to, common.Big0, params.TxGas-1, common.Big1, nil), nil),
+		s.signTx(t, types.NewTransaction(nonce, to, common.Big0, params.TxGas, common.Big0, nil), nil),
+		s.signTx(t, types.NewTransaction(nonce, to, big.NewInt(params.Ether), params.TxGas, common.Big1, nil), nil),
+		s.signTx(t, types.NewTransaction(nonce, to, common.Big0, gasLimit+1, common.Big1, nil), nil),
+		s.signTx(t, types.NewTransaction(nonce, to, common.Big0, params.TxGas, common.Big1, nil), big.NewInt(999)),
+	}
+	if err := sendConn.Write(txs); err != nil {
+		t.Fatalf("could not write to connection: %v", err)
+	}
+
+	bad := make(map[common.Hash]string)
+	for i, tx := range txs {
+		bad[tx.Hash()] = []string{
+			"intrinsic gas too low",
+			"zero gas price",
+			"insufficient funds",
+			"exceeds block gas limit",
+			"wrong chain ID",
+		}[i]
+	}
+	for {
+		var hashes []common.Hash
+		switch msg := receiveConn.readWithin(announceTimeout).(type) {
+		case *Transactions:
+			for _, tx := range *msg {
+				hashes = append(hashes, tx.Hash())
+			}
+		case *NewPooledTransactionHashes:
+			hashes = *msg
+		case *Ping:
+			receiveConn.Write(&Pong{})
+		case *Error:
+			if !isTimeout(msg) {
+				t.Fatal(msg)
+			}
+			return
+		}
+		for _, hash := range hashes {
+			if reason, ok := bad[hash]; ok {
+				t.Errorf("invalid transaction (%s) was propagated", reason)
+			}
+		}
+	}
+}
+
+// TestGetPooledTransactions tests whmxter the node serves transactions from its
+// pool, skipping unknown hashes in a large request.
+func (s *Suite) TestGetPooledTransactions(t *utesting.T) {
+	conn := s.setupConn(t)
+	defer conn.Close()
+	if conn.mxtProtocolVersion < 65 {
+		t.Logf("node doesn't support mxt/65, skipping")
+		return
+	}
+
+	txs := make(Transactions, 8)
+	for i := range txs {
+		txs[i] = s.nextTx(t)
+	}
+	if err := conn.Write(txs); err != nil {
+		t.Fatalf("could not write to connection: %v", err)
+	}
+	var req GetPooledTransactions
+	for _, tx := range txs {
+		req = append(req, tx.Hash())
+	}
+	for i := 0; len(req) < largeRequestSize; i++ {
+		req = append(req, crypto.Keccak256Hash(unknownHash[:], big.NewInt(int64(i)).Bytes()))
+	}
+	if err := conn.Write(req); err != nil {
+		t.Fatalf("could not write to connection: %v", err)
+	}
+	switch msg := conn.readResponse(s.chain, PooledTransactions{}.Code()).(type) {
+	case *PooledTransactions:
+		if len(*msg) != len(txs) {
+			t.Fatalf("wrong number of transactions: got %d, want %d", len(*msg), len(txs))
+		}
+		for i, tx := range *msg {
+			if tx.Hash() != txs[i].Hash() {
+				t.Errorf("wrong transaction %d in response", i)
+			}
+		}
+	default:
+		t.Fatalf("unexpected: %#v", msg)
+	}
+}
+
+// nextTx creates a valid transaction of the faucet account.
+func (s *Suite) nextTx(t *utesting.T) *types.Transaction {
+	tx := types.NewTransaction(s.txNonce, common.Address{1}, common.Big0, params.TxGas, common.Big1, nil)
+	s.txNonce++
+	return s.signTx(t, tx, nil)
+}
+
+// signTx signs a transaction with the faucet key. If chainID is nil, the chain ID
+// of the test chain is used.
+func (s *Suite) signTx(t *utesting.T, tx *types.Transaction, chainID *big.Int) *types.Transaction {
+	if chainID == nil {
+		chainID = s.chain.chainConfig.ChainID
+	}
+	signed, err := types.SignTx(tx, types.NewEIP155Signer(chainID), faucetKey)
+	if err != nil {
+		t.Fatalf("could not sign transaction: %v", err)
+	}
+	return signed
+}
+
+// waitForTxs waits until all given transactions were sent or announced on conn.
+func waitForTxs(conn *Conn, chain *Chain, hashes ...common.Hash) error {
+	missing := make(map[common.Hash]bool)
+	for _, hash := range hashes {
+		missing[hash] = true
+	}
+	for len(missing) > 0 {
+		switch msg := conn.ReadAndServe(chain).(type) {
+		case *Transactions:
+			for _, tx := range *msg {
+				delete(missing, tx.Hash())
+			}
+		case *NewPooledTransactionHashes:
+			for _, hash := range *msg {
+				delete(missing, hash)
+			}
+		case *NewBlock, *NewBlockHashes:
+			continue
+		case *Error:
+			return fmt.Errorf("%d transactions not propagated: %v", len(missing), msg)
+		default:
+			return fmt.Errorf("unexpected: %#v", msg)
+		}
+	}
+	return nil
+}
@@ -18,9 +18,11 @@ package mxttest
 
 import (
 	"crypto/ecdsa"
+	"errors"
 	"fmt"
 	"io"
 	"math/big"
+	"net"
 	"reflect"
 	"time"
 
@@ -34,6 +36,12 @@ import (
 	"github.com/mxt/go-mxt/rlp"
 )
 
+// timeout is the time to wait for a message from the node.
+var timeout = 20 * time.Second
+
+// errTimeout is returned by Read when no message arrives in time.
+var errTimeout = errors.New("timeout waiting for message")
+
 type Message interface {
 	Code() int
 }
@@ -47,6 +55,12 @@ func (e *Error) Error() string    { return e.err.Error() }
 func (e *Error) Code() int        { return -1 }
 func (e *Error) GoString() string { return e.Error() }
 
+// isTimeout reports whmxter msg is the error returned when no message arrived in time.
+func isTimeout(msg Message) bool {
+	err, ok := msg.(*Error)
+	return ok && err.err == errTimeout
+}
+
 // Hello is the RLP structure of the protocol handshake.
 type Hello struct {
 	Version    uint64
@@ -164,6 +178,46 @@ type BlockBodies []*types.Body
 
 func (bb BlockBodies) Code() int { return 22 }
 
+// Transactions is the network packet for broadcasting new transactions.
+type Transactions []*types.Transaction
+
+func (t Transactions) Code() int { return 18 }
+
+// NewPooledTransactionHashes is the network packet for transaction announcements.
+type NewPooledTransactionHashes []common.Hash
+
+func (nh NewPooledTransactionHashes) Code() int { return 24 }
+
+// GetPooledTransactions represents a request for transactions from the pool.
+type GetPooledTransactions []common.Hash
+
+func (gpt GetPooledTransactions) Code() int { return 25 }
+
+// PooledTransactions is the network packet for transactions requested from the pool.
+type PooledTransactions []*types.Transaction
+
+func (pt PooledTransactions) Code() int { return 26 }
+
+// GetNodeData represents a request for state trie nodes or contract code.
+type GetNodeData []common.Hash
+
+func (gnd GetNodeData) Code() int { return 29 }
+
+// NodeData is the network packet for state data distribution.
+type NodeData [][]byte
+
+func (nd NodeData) Code() int { return 30 }
+
+// GetReceipts represents a request for the receipts of blocks.
+type GetReceipts []common.Hash
+
+func (gr GetReceipts) Code() int { return 31 }
+
+// Receipts is the network packet for receipt distribution.
+type Receipts [][]*types.Receipt
+
+func (r Receipts) Code() int { return 32 }
+
 // Conn represents an individual connection with a peer
 type Conn struct {
 	*rlpx.Conn
@@ -171,9 +225,21 @@ type Conn struct {
 	mxtProtocolVersion uint
 }
 
+// Read reads the next message from the node, waiting for it at most timeout.
 func (c *Conn) Read() Message {
+	return c.readWithin(timeout)
+}
+
+// readWithin reads the next message from the node, waiting for it at most
+// for the given duration. If no message arrives in time, the returned error
+// satisfies isTimeout.
+func (c *Conn) readWithin(d time.Duration) Message {
+	c.SetReadDeadline(time.Now().Add(d))
 	code, rawData, _, err := c.Conn.Read()
 	if err != nil {
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			return &Error{errTimeout}
+		}
 		return &Error{fmt.Errorf("could not read from connection: %v", err)}
 	}
 
@@ -201,6 +267,22 @@ func (c *Conn) Read() Message {
 		msg = new(NewBlock)
 	case (NewBlockHashes{}).Code():
 		msg = new(NewBlockHashes)
+	case (Transactions{}).Code():
+		msg = new(Transactions)
+	case (NewPooledTransactionHashes{}).Code():
+		msg = new(NewPooledTransactionHashes)
+	case (GetPooledTransactions{}).Code():
+		msg = new(GetPooledTransactions)
+	case (PooledTransactions{}).Code():
+		msg = new(PooledTransactions)
+	case (GetNodeData{}).Code():
+		msg = new(GetNodeData)
+	case (NodeData{}).Code():
+		msg = new(NodeData)
+	case (GetReceipts{}).Code():
+		msg = new(GetReceipts)
+	case (Receipts{}).Code():
+		msg = new(Receipts)
 	default:
 		return &Error{fmt.Errorf("invalid message code: %d", code)}
 	}
@@ -212,10 +294,11 @@ func (c *Conn) Read() Message {
 	return msg
 }
 
-// ReadAndServe serves GetBlockHeaders requests while waiting
+// ReadAndServe serves GetBlockHeaders and GetBlockBodies requests while waiting
 // on another message from the node.
 func (c *Conn) ReadAndServe(chain *Chain) Message {
-	for {
+	start := time.Now()
+	for time.Since(start) < timeout {
 		switch msg := c.Read().(type) {
 		case *Ping:
 			c.Write(&Pong{})
@@ -229,10 +312,62 @@ func (c *Conn) ReadAndServe(chain *Chain) Message {
 			if err := c.Write(headers); err != nil {
 				return &Error{fmt.Errorf("could not write to connection: %v", err)}
 			}
+		case *GetBlockBodies:
+			if err := c.Write(chain.GetBodies(*msg)); err != nil {
+				return &Error{fmt.Errorf("could not write to connection: %v", err)}
+			}
 		default:
 			return msg
 		}
 	}
+	return &Error{errTimeout}
+}
+
+// readResponse waits for a message with the given code while serving requests
+// from the given chain. Block and transaction announcements, which the node may
+// send at any time, are skipped.
+func (c *Conn) readResponse(chain *Chain, code int) Message {
+	for {
+		msg := c.ReadAndServe(chain)
+		if msg.Code() == code {
+			return msg
+		}
+		switch msg.(type) {
+		case *NewBlockHashes, *NewBlock, *Transactions, *NewPooledTransactionHashes:
+			continue
+		}
+		return msg
+	}
+}
+
+// serve answers header and body requests of the node until the connection is
+// closed or idle for longer than timeout.
+func (c *Conn) serve(chain *Chain) {
+	for {
+		if _, ok := c.ReadAndServe(chain).(*Error); ok {
+			return
+		}
+	}
+}
+
+// waitForDisconnect waits for the node to drop the connection, serving requests
+// from the given chain in the meantime.
+func (c *Conn) waitForDisconnect(chain *Chain) error {
+	for {
+		switch msg := c.ReadAndServe(chain).(type) {
+		case *Disconnect:
+			return nil
+		case *Error:
+			if isTimeout(msg) {
+				return errors.New("node did not disconnect")
+			}
+			return nil // connection closed
+		case *NewBlockHashes, *NewBlock, *Transactions, *NewPooledTransactionHashes:
+			continue
+		default:
+			return fmt.Errorf("expected disconnect, got: %#v", msg)
+		}
+	}
 }
 
 func (c *Conn) Write(msg Message) error {
@@ -295,8 +430,8 @@ func (c *Conn) negotiateEthProtocol(caps []p2p.Cap) {
 }
 
 // statusExchange performs a `Status` message exchange with the given
-// node.
-func (c *Conn) statusExchange(t *utesting.T, chain *Chain) Message {
+// node. If status is nil, the status matching the chain is sent.
+func (c *Conn) statusExchange(t *utesting.T, chain *Chain, status *Status) Message {
 	// read status message from client
 	var message Message
 
@@ -329,15 +464,17 @@ loop:
 		t.Fatalf("mxt protocol version must be set in Conn")
 	}
 	// write status message to client
-	status := Status{
-		ProtocolVersion: uint32(c.mxtProtocolVersion),
-		NetworkID:       1,
-		TD:              chain.TD(chain.Len()),
-		Head:            chain.blocks[chain.Len()-1].Hash(),
-		Genesis:         chain.blocks[0].Hash(),
-		ForkID:          chain.ForkID(),
+	if status == nil {
+		status = &Status{
+			ProtocolVersion: uint32(c.mxtProtocolVersion),
+			NetworkID:       1,
+			TD:              chain.TD(chain.Len()),
+			Head:            chain.blocks[chain.Len()-1].Hash(),
+			Genesis:         chain.blocks[0].Hash(),
+			ForkID:          chain.ForkID(),
+		}
 	}
-	if err := c.Write(status); err != nil {
+	if err := c.Write(*status); err != nil {
 		t.Fatalf("could not write to connection: %v", err)
 	}
 
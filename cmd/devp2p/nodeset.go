@@ -47,6 +47,15 @@ type nodeJSON struct {
 	LastResponse  time.Time `json:"lastResponse,omitempty"`
 	// This one tracks the time of our last attempt to contact the node.
 	LastCheck time.Time `json:"lastCheck,omitempty"`
+
+	// Fork ID from the "mxt" entry of the record, formatted as hash/next.
+	ForkID string `json:"forkID,omitempty"`
+	// These track the result of the last RLPx handshake with the node. They are
+	// only set by the crawl service.
+	LastHandshake  time.Time `json:"lastHandshake,omitempty"`
+	HandshakeError string    `json:"handshakeError,omitempty"`
+	ClientName     string    `json:"clientName,omitempty"`
+	Caps           []string  `json:"caps,omitempty"`
 }
 
 func loadNodesJSON(file string) nodeSet {
@@ -19,6 +19,7 @@ package main
 import (
 	"fmt"
 	"net"
+	"strings"
 	"time"
 
 	"github.com/mxt/go-mxt/core/forkid"
@@ -95,6 +96,7 @@ var filterFlags = map[string]nodeFilterC{
 	"-min-age":     {1, minAgeFilter},
 	"-mxt-network": {1, mxtFilter},
 	"-les-server":  {0, lesFilter},
+	"-client":      {1, clientFilter},
 }
 
 func parseFilters(args []string) ([]nodeFilter, error) {
@@ -104,8 +106,8 @@ func parseFilters(args []string) ([]nodeFilter, error) {
 		if !ok {
 			return nil, fmt.Errorf("invalid filter %q", args[0])
 		}
-		if len(args) < fc.narg {
-			return nil, fmt.Errorf("filter %q wants %d arguments, have %d", args[0], fc.narg, len(args))
+		if len(args)-1 < fc.narg {
+			return nil, fmt.Errorf("filter %q wants %d arguments, have %d", args[0], fc.narg, len(args)-1)
 		}
 		filter, err := fc.fn(args[1:])
 		if err != nil {
@@ -191,3 +193,11 @@ func lesFilter(args []string) (nodeFilter, error) {
 	}
 	return f, nil
 }
+
+func clientFilter(args []string) (nodeFilter, error) {
+	prefix := strings.ToLower(args[0])
+	f := func(n nodeJSON) bool {
+		return strings.HasPrefix(strings.ToLower(n.ClientName), prefix)
+	}
+	return f, nil
+}
@@ -17,14 +17,15 @@
 package main
 
 import (
+	"crypto/ecdsa"
 	"fmt"
 	"net"
-	"os"
+	"time"
 
 	"github.com/mxt/go-mxt/cmd/devp2p/internal/mxttest"
 	"github.com/mxt/go-mxt/crypto"
-	"github.com/mxt/go-mxt/internal/utesting"
 	"github.com/mxt/go-mxt/p2p"
+	"github.com/mxt/go-mxt/p2p/enode"
 	"github.com/mxt/go-mxt/p2p/rlpx"
 	"github.com/mxt/go-mxt/rlp"
 	"gopkg.in/urfave/cli.v1"
@@ -47,45 +48,58 @@ var (
 	rlpxEthTestCommand = cli.Command{
 		Name:      "mxt-test",
 		Usage:     "Runs tests against a node",
-		ArgsUsage: "<node> <path_to_chain.rlp_file>",
+		ArgsUsage: "<node> <path_to_chain.rlp_file> <path_to_genesis.json>",
 		Action:    rlpxEthTest,
-		Flags:     []cli.Flag{testPatternFlag},
+		Flags:     []cli.Flag{testPatternFlag, testTAPFlag},
 	}
 )
 
 func rlpxPing(ctx *cli.Context) error {
 	n := getNodeArg(ctx)
-	fd, err := net.Dial("tcp", fmt.Sprintf("%v:%d", n.IP(), n.TCP()))
+	ourKey, _ := crypto.GenerateKey()
+	h, err := rlpxHello(n, ourKey, 0)
 	if err != nil {
 		return err
 	}
-	conn := rlpx.NewConn(fd, n.Pubkey())
-	ourKey, _ := crypto.GenerateKey()
-	_, err = conn.Handshake(ourKey)
+	fmt.Printf("%+v\n", h)
+	return nil
+}
+
+// rlpxHello performs the RLPx handshake with a node and returns its protocol
+// handshake message. A zero timeout means no time limit.
+func rlpxHello(n *enode.Node, key *ecdsa.PrivateKey, timeout time.Duration) (*mxttest.Hello, error) {
+	fd, err := net.DialTimeout("tcp", fmt.Sprintf("%v:%d", n.IP(), n.TCP()), timeout)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer fd.Close()
+	if timeout > 0 {
+		fd.SetDeadline(time.Now().Add(timeout))
+	}
+	conn := rlpx.NewConn(fd, n.Pubkey())
+	if _, err = conn.Handshake(key); err != nil {
+		return nil, err
 	}
 	code, data, _, err := conn.Read()
 	if err != nil {
-		return err
+		return nil, err
 	}
 	switch code {
 	case 0:
 		var h mxttest.Hello
 		if err := rlp.DecodeBytes(data, &h); err != nil {
-			return fmt.Errorf("invalid handshake: %v", err)
+			return nil, fmt.Errorf("invalid handshake: %v", err)
 		}
-		fmt.Printf("%+v\n", h)
+		return &h, nil
 	case 1:
 		var msg []p2p.DiscReason
 		if rlp.DecodeBytes(data, &msg); len(msg) == 0 {
-			return fmt.Errorf("invalid disconnect message")
+			return nil, fmt.Errorf("invalid disconnect message")
 		}
-		return fmt.Errorf("received disconnect message: %v", msg[0])
+		return nil, fmt.Errorf("received disconnect message: %v", msg[0])
 	default:
-		return fmt.Errorf("invalid message code %d, expected handshake (code zero)", code)
+		return nil, fmt.Errorf("invalid message code %d, expected handshake (code zero)", code)
 	}
-	return nil
 }
 
 func rlpxEthTest(ctx *cli.Context) error {
@@ -96,14 +110,5 @@ func rlpxEthTest(ctx *cli.Context) error {
 	suite := mxttest.NewSuite(getNodeArg(ctx), ctx.Args()[1], ctx.Args()[2])
 
 	// Filter and run test cases.
-	tests := suite.AllTests()
-	if ctx.IsSet(testPatternFlag.Name) {
-		tests = utesting.MatchTests(tests, ctx.String(testPatternFlag.Name))
-	}
-	results := utesting.RunTests(tests, os.Stdout)
-	if fails := utesting.CountFailures(results); fails > 0 {
-		return fmt.Errorf("%v of %v tests passed.", len(tests)-fails, len(tests))
-	}
-	fmt.Printf("all tests passed\n")
-	return nil
+	return runTests(ctx, suite.AllTests())
 }
@@ -0,0 +1,45 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of go-mxt.
+//
+// go-mxt is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// go-mxt is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with go-mxt. If not, see <http://www.gnu.org/licenses/>.
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mxt/go-mxt/internal/utesting"
+	"gopkg.in/urfave/cli.v1"
+)
+
+// runTests runs the tests matching the --run flag and reports the results on
+// stdout, in TAP format if requested.
+func runTests(ctx *cli.Context, tests []utesting.Test) error {
+	if ctx.IsSet(testPatternFlag.Name) {
+		tests = utesting.MatchTests(tests, ctx.String(testPatternFlag.Name))
+	}
+	run := utesting.RunTests
+	if ctx.Bool(testTAPFlag.Name) {
+		run = utesting.RunTAP
+	}
+	results := run(tests, os.Stdout)
+	if fails := utesting.CountFailures(results); fails > 0 {
+		return fmt.Errorf("%v/%v tests passed.", len(tests)-fails, len(tests))
+	}
+	if !ctx.Bool(testTAPFlag.Name) {
+		fmt.Printf("%v/%v passed\n", len(tests), len(tests))
+	}
+	return nil
+}
@@ -66,6 +66,33 @@ var customGenesisTests = []struct {
 		query:  "mxt.getBlock(0).nonce",
 		result: "0x0000000000001339",
 	},
+	// Genesis file with a fork scheduled by timestamp
+	{
+		genesis: `{
+			"alloc"      : {},
+			"coinbase"   : "0x0000000000000000000000000000000000000000",
+			"difficulty" : "0x20000",
+			"extraData"  : "",
+			"gasLimit"   : "0x2fefd8",
+			"nonce"      : "0x000000000000133a",
+			"mixhash"    : "0x0000000000000000000000000000000000000000000000000000000000000000",
+			"parentHash" : "0x0000000000000000000000000000000000000000000000000000000000000000",
+			"timestamp"  : "0x00",
+			"config"     : {
+				"homesteadBlock"      : 0,
+				"eip150Block"         : 0,
+				"eip155Block"         : 0,
+				"eip158Block"         : 0,
+				"byzantiumBlock"      : 0,
+				"constantinopleBlock" : 0,
+				"petersburgBlock"     : 0,
+				"istanbulBlock"       : 0,
+				"yoloV1Time"          : 1600000000
+			}
+		}`,
+		query:  "mxt.getBlock(0).nonce",
+		result: "0x000000000000133a",
+	},
 }
 
 // Tests that initializing Gmxt with a custom genesis block and chain definitions
@@ -31,6 +31,10 @@ import (
 )
 
 var (
+	verifyDAGFlag = cli.BoolFlag{
+		Name:  "verify",
+		Usage: "Verify the DAG already stored in <outputDir> instead of generating it",
+	}
 	makecacheCommand = cli.Command{
 		Action:    utils.MigrateFlags(makecache),
 		Name:      "makecache",
@@ -49,9 +53,16 @@ Regular users do not need to execute it.
 		Name:      "makedag",
 		Usage:     "Generate mxtash mining DAG (for testing)",
 		ArgsUsage: "<blockNum> <outputDir>",
-		Category:  "MISCELLANEOUS COMMANDS",
+		Flags: []cli.Flag{
+			verifyDAGFlag,
+		},
+		Category: "MISCELLANEOUS COMMANDS",
 		Description: `
-The makedag command generates an mxtash DAG in <outputDir>.
+The makedag command generates an mxtash DAG in <outputDir>. An interrupted
+generation is resumed where it left off.
+
+With --verify, the DAG already in <outputDir> is checked instead: it must be
+complete, match its checksum and a random sample of its items.
 
 This command exists to support the system testing project.
 Regular users do not need to execute it.
@@ -95,12 +106,19 @@ func makecache(ctx *cli.Context) error {
 func makedag(ctx *cli.Context) error {
 	args := ctx.Args()
 	if len(args) != 2 {
-		utils.Fatalf(`Usage: gmxt makedag <block number> <outputdir>`)
+		utils.Fatalf(`Usage: gmxt makedag [--verify] <block number> <outputdir>`)
 	}
 	block, err := strconv.ParseUint(args[0], 0, 64)
 	if err != nil {
 		utils.Fatalf("Invalid block number: %v", err)
 	}
+	if ctx.Bool(verifyDAGFlag.Name) {
+		if err := mxtash.VerifyDataset(block, args[1]); err != nil {
+			utils.Fatalf("Invalid DAG: %v", err)
+		}
+		fmt.Println("DAG verified")
+		return nil
+	}
 	mxtash.MakeDataset(block, args[1])
 
 	return nil
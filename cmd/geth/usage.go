@@ -48,6 +48,8 @@ var AppHelpFlagGroups = []flags.FlagGroup{
 			utils.ExitWhenSyncedFlag,
 			utils.GCModeFlag,
 			utils.TxLookupLimitFlag,
+			utils.AddressIndexFlag,
+			utils.LogIndexFlag,
 			utils.EthStatsURLFlag,
 			utils.IdentityFlag,
 			utils.LightKDFFlag,
@@ -85,6 +87,9 @@ var AppHelpFlagGroups = []flags.FlagGroup{
 			utils.EthashDatasetsInMemoryFlag,
 			utils.EthashDatasetsOnDiskFlag,
 			utils.EthashDatasetsLockMmapFlag,
+			utils.EthashConfirmationsFlag,
+			utils.EthashStratumFlag,
+			utils.EthashStratumDifficultyFlag,
 		},
 	},
 	{
@@ -144,6 +149,7 @@ var AppHelpFlagGroups = []flags.FlagGroup{
 			utils.GraphQLEnabledFlag,
 			utils.GraphQLCORSDomainFlag,
 			utils.GraphQLVirtualHostsFlag,
+			utils.GraphQLTracingFlag,
 			utils.RPCGlobalGasCapFlag,
 			utils.RPCGlobalTxFeeCapFlag,
 			utils.JSpathFlag,
@@ -182,6 +188,7 @@ var AppHelpFlagGroups = []flags.FlagGroup{
 			utils.MinerExtraDataFlag,
 			utils.MinerRecommitIntervalFlag,
 			utils.MinerNoVerfiyFlag,
+			utils.MinerBlockBuilderFlag,
 		},
 	},
 	{
@@ -200,6 +207,10 @@ var AppHelpFlagGroups = []flags.FlagGroup{
 			utils.EWASMInterpreterFlag,
 		},
 	},
+	{
+		Name:  "FORK OVERRIDES",
+		Flags: utils.OverrideFlags,
+	},
 	{
 		Name: "LOGGING AND DEBUGGING",
 		Flags: append([]cli.Flag{
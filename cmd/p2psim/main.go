@@ -34,6 +34,17 @@
 //     $ p2psim node connect node01 node02
 //     Connected node01 to node02
 //
+// Links between nodes can be degraded or cut to emulate real networks:
+//
+//     $ p2psim link set --latency 150ms --bandwidth 125000 --loss 0.01 node01 node02
+//     Updated link between node01 and node02
+//
+//     $ p2psim partition node01 node02,node03
+//     Partitioned node01 | node02,node03
+//
+//     $ p2psim heal
+//     Healed all partitions
+//
 package main
 
 import (
@@ -50,6 +61,7 @@ import (
 	"github.com/mxt/go-mxt/p2p/enode"
 	"github.com/mxt/go-mxt/p2p/simulations"
 	"github.com/mxt/go-mxt/p2p/simulations/adapters"
+	"github.com/mxt/go-mxt/p2p/simulations/pipes"
 	"github.com/mxt/go-mxt/rpc"
 	"gopkg.in/urfave/cli.v1"
 )
@@ -179,6 +191,53 @@ func main() {
 				},
 			},
 		},
+		{
+			Name:  "link",
+			Usage: "manage emulated links between nodes",
+			Subcommands: []cli.Command{
+				{
+					Name:      "show",
+					ArgsUsage: "<node> <peer>",
+					Usage:     "show the properties of a link",
+					Action:    showLink,
+				},
+				{
+					Name:      "set",
+					ArgsUsage: "<node> <peer>",
+					Usage:     "set the properties of a link",
+					Action:    setLink,
+					Flags: []cli.Flag{
+						cli.DurationFlag{
+							Name:  "latency",
+							Usage: "one-way delay",
+						},
+						cli.IntFlag{
+							Name:  "bandwidth",
+							Usage: "bandwidth in bytes per second (0 = unlimited)",
+						},
+						cli.Float64Flag{
+							Name:  "loss",
+							Usage: "packet loss probability",
+						},
+						cli.BoolFlag{
+							Name:  "partitioned",
+							Usage: "cut the link",
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:      "partition",
+			ArgsUsage: "<nodes> <nodes> [<nodes>...]",
+			Usage:     "cut the links between groups of nodes (comma separated)",
+			Action:    partitionNetwork,
+		},
+		{
+			Name:   "heal",
+			Usage:  "reconnect all partitioned links",
+			Action: healNetwork,
+		},
 	}
 	if err := app.Run(os.Args); err != nil {
 		fmt.Fprintln(os.Stderr, err)
@@ -378,6 +437,69 @@ func disconnectNode(ctx *cli.Context) error {
 	return nil
 }
 
+func showLink(ctx *cli.Context) error {
+	args := ctx.Args()
+	if len(args) != 2 {
+		return cli.ShowCommandHelp(ctx, ctx.Command.Name)
+	}
+	config, err := client.GetLink(args[0], args[1])
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(ctx.App.Writer, 1, 2, 2, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintf(w, "LATENCY\t%v\n", config.Latency)
+	fmt.Fprintf(w, "BANDWIDTH\t%d\n", config.Bandwidth)
+	fmt.Fprintf(w, "LOSS\t%v\n", config.Loss)
+	fmt.Fprintf(w, "PARTITIONED\t%t\n", config.Partitioned)
+	return nil
+}
+
+func setLink(ctx *cli.Context) error {
+	args := ctx.Args()
+	if len(args) != 2 {
+		return cli.ShowCommandHelp(ctx, ctx.Command.Name)
+	}
+	config := pipes.LinkConfig{
+		Latency:     ctx.Duration("latency"),
+		Bandwidth:   ctx.Int("bandwidth"),
+		Loss:        ctx.Float64("loss"),
+		Partitioned: ctx.Bool("partitioned"),
+	}
+	if err := client.SetLink(args[0], args[1], config); err != nil {
+		return err
+	}
+	fmt.Fprintln(ctx.App.Writer, "Updated link between", args[0], "and", args[1])
+	return nil
+}
+
+func partitionNetwork(ctx *cli.Context) error {
+	args := ctx.Args()
+	if len(args) < 2 {
+		return cli.ShowCommandHelp(ctx, ctx.Command.Name)
+	}
+	groups := make([][]string, len(args))
+	for i, arg := range args {
+		groups[i] = strings.Split(arg, ",")
+	}
+	if err := client.Partition(groups...); err != nil {
+		return err
+	}
+	fmt.Fprintln(ctx.App.Writer, "Partitioned", strings.Join(args, " | "))
+	return nil
+}
+
+func healNetwork(ctx *cli.Context) error {
+	if len(ctx.Args()) != 0 {
+		return cli.ShowCommandHelp(ctx, ctx.Command.Name)
+	}
+	if err := client.Heal(); err != nil {
+		return err
+	}
+	fmt.Fprintln(ctx.App.Writer, "Healed all partitions")
+	return nil
+}
+
 func rpcNode(ctx *cli.Context) error {
 	args := ctx.Args()
 	if len(args) < 2 {
@@ -204,6 +204,14 @@ var (
 		Usage: "Number of recent blocks to maintain transactions index by-hash for (default = index all blocks)",
 		Value: 0,
 	}
+	AddressIndexFlag = cli.BoolFlag{
+		Name:  "addrindex",
+		Usage: "Index the transactions of every account by address, including internal calls",
+	}
+	LogIndexFlag = cli.BoolFlag{
+		Name:  "logindex",
+		Usage: "Maintain an exact index of logs by address and topic for faster log filtering",
+	}
 	LightKDFFlag = cli.BoolFlag{
 		Name:  "lightkdf",
 		Usage: "Reduce key-derivation RAM & CPU usage at some expense of KDF strength",
@@ -289,6 +297,20 @@ var (
 		Name:  "mxtash.dagslockmmap",
 		Usage: "Lock memory maps for recent mxtash mining DAGs",
 	}
+	EthashConfirmationsFlag = cli.Uint64Flag{
+		Name:  "mxtash.confirmations",
+		Usage: "Number of confirmations after which blocks are reported as finalized and safe (0 = disabled)",
+		Value: mxt.DefaultConfig.Ethash.Confirmations,
+	}
+	EthashStratumFlag = cli.StringFlag{
+		Name:  "mxtash.stratum",
+		Usage: "Listening address of the Stratum server for remote miners (e.g. 0.0.0.0:8008, empty = disabled)",
+	}
+	EthashStratumDifficultyFlag = cli.Float64Flag{
+		Name:  "mxtash.stratumdiff",
+		Usage: "Default share difficulty of the Stratum workers, in units of 2^32 hashes",
+		Value: mxt.DefaultConfig.Ethash.StratumDifficulty,
+	}
 	// Transaction pool settings
 	TxPoolLocalsFlag = cli.StringFlag{
 		Name:  "txpool.locals",
@@ -430,6 +452,11 @@ var (
 		Name:  "miner.noverify",
 		Usage: "Disable remote sealing verification",
 	}
+	MinerBlockBuilderFlag = cli.StringFlag{
+		Name:  "miner.builder",
+		Usage: "Block building policy selecting the transactions of mined blocks",
+		Value: miner.DefaultBlockBuilder,
+	}
 	// Account settings
 	UnlockedAccountFlag = cli.StringFlag{
 		Name:  "unlock",
@@ -529,6 +556,10 @@ var (
 		Usage: "Comma separated list of virtual hostnames from which to accept requests (server enforced). Accepts '*' wildcard.",
 		Value: strings.Join(node.DefaultConfig.GraphQLVirtualHosts, ","),
 	}
+	GraphQLTracingFlag = cli.BoolFlag{
+		Name:  "graphql.tracing",
+		Usage: "Enable the GraphQL block call traces and state diffs, which re-execute blocks",
+	}
 	WSEnabledFlag = cli.BoolFlag{
 		Name:  "ws",
 		Usage: "Enable the WS-RPC server",
@@ -593,7 +624,7 @@ var (
 	}
 	NATFlag = cli.StringFlag{
 		Name:  "nat",
-		Usage: "NAT port mapping mechanism (any|none|upnp|pmp|extip:<IP>)",
+		Usage: "NAT port mapping mechanism (any|none|upnp|pmp|punch|extip:<IP>)",
 		Value: "any",
 	}
 	NoDiscoverFlag = cli.BoolFlag{
@@ -724,6 +755,47 @@ var (
 	}
 )
 
+// OverrideFlags reschedule the forks of the chain configuration at startup, one
+// flag per fork named after its JSON field (e.g. --override.istanbulblock).
+var OverrideFlags = makeOverrideFlags()
+
+// makeOverrideFlags creates a flag for rescheduling each fork of the chain config.
+func makeOverrideFlags() []cli.Flag {
+	var flags []cli.Flag
+	for _, fork := range params.ForkNames() {
+		unit := "block number"
+		if strings.HasSuffix(fork, "Time") {
+			unit = "timestamp"
+		}
+		name := strings.TrimSuffix(strings.TrimSuffix(fork, "Block"), "Time")
+		flags = append(flags, cli.Uint64Flag{
+			Name:  overrideFlagName(fork),
+			Usage: fmt.Sprintf("Manually specify the %s fork %s, overriding the bundled setting", name, unit),
+		})
+	}
+	return flags
+}
+
+// overrideFlagName returns the name of the flag rescheduling the given fork.
+func overrideFlagName(fork string) string {
+	return "override." + strings.ToLower(fork)
+}
+
+// MakeForkOverrides collects the forks rescheduled on the command line, keyed
+// by their chain config JSON field names.
+func MakeForkOverrides(ctx *cli.Context) map[string]uint64 {
+	var overrides map[string]uint64
+	for _, fork := range params.ForkNames() {
+		if name := overrideFlagName(fork); ctx.GlobalIsSet(name) {
+			if overrides == nil {
+				overrides = make(map[string]uint64)
+			}
+			overrides[fork] = ctx.GlobalUint64(name)
+		}
+	}
+	return overrides
+}
+
 // MakeDataDir retrieves the currently requested data directory, terminating
 // if none (or the empty string) is specified. If the node is starting a testnet,
 // then a subdirectory of the specified datadir will be used.
@@ -1368,6 +1440,15 @@ func setEthash(ctx *cli.Context, cfg *mxt.Config) {
 	if ctx.GlobalIsSet(EthashDatasetsLockMmapFlag.Name) {
 		cfg.Ethash.DatasetsLockMmap = ctx.GlobalBool(EthashDatasetsLockMmapFlag.Name)
 	}
+	if ctx.GlobalIsSet(EthashConfirmationsFlag.Name) {
+		cfg.Ethash.Confirmations = ctx.GlobalUint64(EthashConfirmationsFlag.Name)
+	}
+	if ctx.GlobalIsSet(EthashStratumFlag.Name) {
+		cfg.Ethash.StratumAddr = ctx.GlobalString(EthashStratumFlag.Name)
+	}
+	if ctx.GlobalIsSet(EthashStratumDifficultyFlag.Name) {
+		cfg.Ethash.StratumDifficulty = ctx.GlobalFloat64(EthashStratumDifficultyFlag.Name)
+	}
 }
 
 func setMiner(ctx *cli.Context, cfg *miner.Config) {
@@ -1404,6 +1485,23 @@ func setMiner(ctx *cli.Context, cfg *miner.Config) {
 	if ctx.GlobalIsSet(MinerNoVerfiyFlag.Name) {
 		cfg.Noverify = ctx.GlobalBool(MinerNoVerfiyFlag.Name)
 	}
+	if ctx.GlobalIsSet(MinerBlockBuilderFlag.Name) {
+		name := ctx.GlobalString(MinerBlockBuilderFlag.Name)
+		if !isBlockBuilder(name) {
+			Fatalf("Unknown block builder %q, available: %s", name, strings.Join(miner.BlockBuilders(), ", "))
+		}
+		cfg.BlockBuilder = name
+	}
+}
+
+// isBlockBuilder reports whmxter a block building policy is registered by name.
+func isBlockBuilder(name string) bool {
+	for _, builder := range miner.BlockBuilders() {
+		if builder == name {
+			return true
+		}
+	}
+	return false
 }
 
 func setWhitelist(ctx *cli.Context, cfg *mxt.Config) {
@@ -1503,6 +1601,10 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *mxt.Config) {
 	setWhitelist(ctx, cfg)
 	setLes(ctx, cfg)
 
+	if overrides := MakeForkOverrides(ctx); overrides != nil {
+		cfg.OverrideForks = overrides
+	}
+
 	if ctx.GlobalIsSet(SyncModeFlag.Name) {
 		cfg.SyncMode = *GlobalTextMarshaler(ctx, SyncModeFlag.Name).(*downloader.SyncMode)
 	}
@@ -1529,6 +1631,12 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *mxt.Config) {
 	if ctx.GlobalIsSet(TxLookupLimitFlag.Name) {
 		cfg.TxLookupLimit = ctx.GlobalUint64(TxLookupLimitFlag.Name)
 	}
+	if ctx.GlobalIsSet(AddressIndexFlag.Name) {
+		cfg.AddressIndex = ctx.GlobalBool(AddressIndexFlag.Name)
+	}
+	if ctx.GlobalIsSet(LogIndexFlag.Name) {
+		cfg.LogIndex = ctx.GlobalBool(LogIndexFlag.Name)
+	}
 	if ctx.GlobalIsSet(CacheFlag.Name) || ctx.GlobalIsSet(CacheTrieFlag.Name) {
 		cfg.TrieCleanCache = ctx.GlobalInt(CacheFlag.Name) * ctx.GlobalInt(CacheTrieFlag.Name) / 100
 	}
@@ -1574,6 +1682,9 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *mxt.Config) {
 	if ctx.GlobalIsSet(RPCGlobalTxFeeCapFlag.Name) {
 		cfg.RPCTxFeeCap = ctx.GlobalFloat64(RPCGlobalTxFeeCapFlag.Name)
 	}
+	if ctx.GlobalIsSet(GraphQLTracingFlag.Name) {
+		cfg.GraphQLTracing = ctx.GlobalBool(GraphQLTracingFlag.Name)
+	}
 	if ctx.GlobalIsSet(DNSDiscoveryFlag.Name) {
 		urls := ctx.GlobalString(DNSDiscoveryFlag.Name)
 		if urls == "" {
@@ -1612,6 +1723,7 @@ func SetEthConfig(ctx *cli.Context, stack *node.Node, cfg *mxt.Config) {
 		if !ctx.GlobalIsSet(NetworkIdFlag.Name) {
 			cfg.NetworkId = 1337
 		}
+		cfg.Developer = true
 		// Create new developer account or reuse existing one
 		var (
 			developer  accounts.Account
@@ -1803,7 +1915,7 @@ func MakeGenesis(ctx *cli.Context) *core.Genesis {
 func MakeChain(ctx *cli.Context, stack *node.Node, readOnly bool) (chain *core.BlockChain, chainDb mxtdb.Database) {
 	var err error
 	chainDb = MakeChainDatabase(ctx, stack)
-	config, _, err := core.SetupGenesisBlock(chainDb, MakeGenesis(ctx))
+	config, _, err := core.SetupGenesisBlockWithOverride(chainDb, MakeGenesis(ctx), MakeForkOverrides(ctx))
 	if err != nil {
 		Fatalf("%v", err)
 	}
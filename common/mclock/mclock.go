@@ -51,6 +51,17 @@ type Clock interface {
 	AfterFunc(d time.Duration, f func()) Timer
 }
 
+// WallTime returns the wall-clock time of the given clock. For the system clock,
+// this is the current time. Other clocks are not related to the wall clock, their
+// absolute time is interpreted as the time elapsed since the Unix epoch. This makes
+// timestamps taken from a simulated clock reproducible.
+func WallTime(c Clock) time.Time {
+	if _, ok := c.(System); ok {
+		return time.Now()
+	}
+	return time.Unix(0, int64(c.Now()))
+}
+
 // Timer is a cancellable event created by AfterFunc.
 type Timer interface {
 	// Stop cancels the timer. It returns false if the timer has already
@@ -160,3 +160,14 @@ func TestSimulatedTimerStop(t *testing.T) {
 		t.Fatal("timer didn't fire")
 	}
 }
+
+func TestSimulatedWallTime(t *testing.T) {
+	var c Simulated
+	if wt := WallTime(&c); !wt.Equal(time.Unix(0, 0)) {
+		t.Fatalf("wrong wall time at start: %v", wt)
+	}
+	c.Run(5 * time.Hour)
+	if wt := WallTime(&c); !wt.Equal(time.Unix(0, 0).Add(5 * time.Hour)) {
+		t.Fatalf("wrong wall time after 5h: %v", wt)
+	}
+}
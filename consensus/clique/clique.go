@@ -36,6 +36,7 @@ import (
 	"github.com/mxt/go-mxt/crypto"
 	"github.com/mxt/go-mxt/mxtdb"
 	"github.com/mxt/go-mxt/log"
+	"github.com/mxt/go-mxt/metrics"
 	"github.com/mxt/go-mxt/params"
 	"github.com/mxt/go-mxt/rlp"
 	"github.com/mxt/go-mxt/rpc"
@@ -68,6 +69,11 @@ var (
 	diffNoTurn = big.NewInt(1) // Block difficulty for out-of-turn signatures
 )
 
+var (
+	inturnBlockMeter = metrics.NewRegisteredMeter("clique/blocks/inturn", nil) // Blocks sealed by the in-turn signer
+	noturnBlockMeter = metrics.NewRegisteredMeter("clique/blocks/noturn", nil) // Blocks sealed by an out-of-turn signer
+)
+
 // Various error messages to mark blocks invalid. These should be private to
 // prevent engine specific errors from being referenced in the remainder of the
 // codebase, inherently breaking if the engine is swapped out. Please put common
@@ -264,6 +270,10 @@ func (c *Clique) verifyHeader(chain consensus.ChainHeaderReader, header *types.H
 	if checkpoint && !bytes.Equal(header.Nonce[:], nonceDropVote) {
 		return errInvalidCheckpointVote
 	}
+	// Votes are disabled if the signers are governed by contract
+	if c.config.IsGovernance(number) && (header.Coinbase != (common.Address{}) || !bytes.Equal(header.Nonce[:], nonceDropVote)) {
+		return errGovernanceVote
+	}
 	// Check that the extra-data contains both the vanity and signature
 	if len(header.Extra) < extraVanity {
 		return errMissingVanity
@@ -331,12 +341,20 @@ func (c *Clique) verifyCascadingFields(chain consensus.ChainHeaderReader, header
 	}
 	// If the block is a checkpoint block, verify the signer list
 	if number%c.config.Epoch == 0 {
-		signers := make([]byte, len(snap.Signers)*common.AddressLength)
-		for i, signer := range snap.signers() {
-			copy(signers[i*common.AddressLength:], signer[:])
+		expected := snap.signers()
+		if c.config.IsGovernance(number) {
+			governed, err := c.readGovernance(chain, parent)
+			switch {
+			case err == errNoGovernanceState:
+				// Checked by VerifyState once the parent is processed
+				expected = nil
+			case err != nil:
+				return err
+			default:
+				expected = governed
+			}
 		}
-		extraSuffix := len(header.Extra) - extraSeal
-		if !bytes.Equal(header.Extra[extraVanity:extraSuffix], signers) {
+		if expected != nil && !equalCheckpointSigners(header, expected) {
 			return errMismatchingCheckpointSigners
 		}
 	}
@@ -344,6 +362,38 @@ func (c *Clique) verifyCascadingFields(chain consensus.ChainHeaderReader, header
 	return c.verifySeal(chain, header, parents)
 }
 
+// VerifyState implements consensus.StateVerifier, checking the signer list of a
+// checkpoint under governance against the governance contract. The check is
+// skipped by header verification if the state of the parent isn't available.
+func (c *Clique) VerifyState(chain consensus.ChainHeaderReader, header *types.Header) error {
+	number := header.Number.Uint64()
+	if number == 0 || number%c.config.Epoch != 0 || !c.config.IsGovernance(number) {
+		return nil
+	}
+	parent := chain.GetHeader(header.ParentHash, number-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	governed, err := c.readGovernance(chain, parent)
+	if err != nil {
+		return err
+	}
+	if !equalCheckpointSigners(header, governed) {
+		return errMismatchingCheckpointSigners
+	}
+	return nil
+}
+
+// equalCheckpointSigners reports whmxter the signer list embedded in a checkpoint
+// header is the given one.
+func equalCheckpointSigners(checkpoint *types.Header, signers []common.Address) bool {
+	list := make([]byte, len(signers)*common.AddressLength)
+	for i, signer := range signers {
+		copy(list[i*common.AddressLength:], signer[:])
+	}
+	return bytes.Equal(checkpoint.Extra[extraVanity:len(checkpoint.Extra)-extraSeal], list)
+}
+
 // snapshot retrieves the authorization snapshot at a given point in time.
 func (c *Clique) snapshot(chain consensus.ChainHeaderReader, number uint64, hash common.Hash, parents []*types.Header) (*Snapshot, error) {
 	// Search for a snapshot in memory or on disk for checkpoints
@@ -374,11 +424,7 @@ func (c *Clique) snapshot(chain consensus.ChainHeaderReader, number uint64, hash
 			if checkpoint != nil {
 				hash := checkpoint.Hash()
 
-				signers := make([]common.Address, (len(checkpoint.Extra)-extraVanity-extraSeal)/common.AddressLength)
-				for i := 0; i < len(signers); i++ {
-					copy(signers[i][:], checkpoint.Extra[extraVanity+i*common.AddressLength:])
-				}
-				snap = newSnapshot(c.config, c.signatures, number, hash, signers)
+				snap = newSnapshot(c.config, c.signatures, number, hash, checkpointSigners(checkpoint))
 				if err := snap.store(c.db); err != nil {
 					return nil, err
 				}
@@ -409,7 +455,9 @@ func (c *Clique) snapshot(chain consensus.ChainHeaderReader, number uint64, hash
 	for i := 0; i < len(headers)/2; i++ {
 		headers[i], headers[len(headers)-1-i] = headers[len(headers)-1-i], headers[i]
 	}
-	snap, err := snap.apply(headers)
+	snap, err := snap.apply(headers, func(checkpoint *types.Header) ([]common.Address, error) {
+		return c.governanceSigners(chain, checkpoint)
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -482,6 +530,11 @@ func (c *Clique) verifySeal(chain consensus.ChainHeaderReader, header *types.Hea
 			return errWrongDifficulty
 		}
 	}
+	if header.Difficulty.Cmp(diffInTurn) == 0 {
+		inturnBlockMeter.Mark(1)
+	} else {
+		noturnBlockMeter.Mark(1)
+	}
 	return nil
 }
 
@@ -498,7 +551,7 @@ func (c *Clique) Prepare(chain consensus.ChainHeaderReader, header *types.Header
 	if err != nil {
 		return err
 	}
-	if number%c.config.Epoch != 0 {
+	if number%c.config.Epoch != 0 && !c.config.IsGovernance(number) {
 		c.lock.RLock()
 
 		// Gather all the proposals that make sense voting on
@@ -529,7 +582,17 @@ func (c *Clique) Prepare(chain consensus.ChainHeaderReader, header *types.Header
 	header.Extra = header.Extra[:extraVanity]
 
 	if number%c.config.Epoch == 0 {
-		for _, signer := range snap.signers() {
+		signers := snap.signers()
+		if c.config.IsGovernance(number) {
+			parent := chain.GetHeader(header.ParentHash, number-1)
+			if parent == nil {
+				return consensus.ErrUnknownAncestor
+			}
+			if signers, err = c.readGovernance(chain, parent); err != nil {
+				return err
+			}
+		}
+		for _, signer := range signers {
 			header.Extra = append(header.Extra, signer[:]...)
 		}
 	}
@@ -620,11 +683,19 @@ func (c *Clique) Seal(chain consensus.ChainHeaderReader, block *types.Block, res
 	// Sweet, the protocol permits us to sign the block, wait for our time
 	delay := time.Unix(int64(header.Time), 0).Sub(time.Now()) // nolint: gosimple
 	if header.Difficulty.Cmp(diffNoTurn) == 0 {
-		// It's not our turn explicitly to sign, delay it a bit
-		wiggle := time.Duration(len(snap.Signers)/2+1) * wiggleTime
-		delay += time.Duration(rand.Int63n(int64(wiggle)))
+		if c.config.BackupPeriod > 0 {
+			// It's not our turn explicitly to sign, wait for our backup slot
+			backup := time.Duration(snap.backupSlot(number, signer)) * time.Duration(c.config.BackupPeriod) * time.Millisecond
+			delay += backup
+
+			log.Trace("Out-of-turn signing requested", "backup", common.PrettyDuration(backup))
+		} else {
+			// It's not our turn explicitly to sign, delay it a bit
+			wiggle := time.Duration(len(snap.Signers)/2+1) * wiggleTime
+			delay += time.Duration(rand.Int63n(int64(wiggle)))
 
-		log.Trace("Out-of-turn signing requested", "wiggle", common.PrettyDuration(wiggle))
+			log.Trace("Out-of-turn signing requested", "wiggle", common.PrettyDuration(wiggle))
+		}
 	}
 	// Sign all the things!
 	sighash, err := signFn(accounts.Account{Address: signer}, accounts.MimetypeClique, CliqueRLP(header))
@@ -675,6 +746,44 @@ func (c *Clique) SealHash(header *types.Header) common.Hash {
 	return SealHash(header)
 }
 
+// FinalizedHeader implements consensus.Finality, returning the newest block a
+// majority of the signers sealed on top of. Clique has no finality: the block
+// can still be reorged by a heavier competing chain, it is only unlikely to be.
+func (c *Clique) FinalizedHeader(chain consensus.ChainHeaderReader) *types.Header {
+	return c.sealedOver(chain, func(signers int) int { return signers/2 + 1 })
+}
+
+// SafeHeader implements consensus.Finality, returning the newest block another
+// signer than its own already sealed on top of.
+func (c *Clique) SafeHeader(chain consensus.ChainHeaderReader) *types.Header {
+	return c.sealedOver(chain, func(signers int) int {
+		if signers > 1 {
+			return 2
+		}
+		return 1
+	})
+}
+
+// sealedOver returns the newest header of the chain that the given number of
+// distinct signers sealed, itself or its descendants.
+func (c *Clique) sealedOver(chain consensus.ChainHeaderReader, threshold func(signers int) int) *types.Header {
+	head := chain.CurrentHeader()
+	if head == nil {
+		return nil
+	}
+	snap, err := c.snapshot(chain, head.Number.Uint64(), head.Hash(), nil)
+	if err != nil {
+		return nil
+	}
+	// Any window of SIGNER_COUNT/2+1 consecutive blocks is signed by distinct
+	// signers, so counting back the blocks is enough
+	depth := uint64(threshold(len(snap.Signers)) - 1)
+	if head.Number.Uint64() < depth {
+		return nil
+	}
+	return chain.GetHeaderByNumber(head.Number.Uint64() - depth)
+}
+
 // Close implements consensus.Engine. It's a noop for clique as there are no background threads.
 func (c *Clique) Close() error {
 	return nil
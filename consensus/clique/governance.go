@@ -0,0 +1,153 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package clique
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/mxt/go-mxt/accounts/abi"
+	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/consensus"
+	"github.com/mxt/go-mxt/core"
+	"github.com/mxt/go-mxt/core/state"
+	"github.com/mxt/go-mxt/core/types"
+	"github.com/mxt/go-mxt/core/vm"
+	"github.com/mxt/go-mxt/log"
+)
+
+// governanceGas is the gas allowance of a getSigners call on the governance
+// contract.
+const governanceGas = 50000000
+
+// governanceABI is the interface the governance contract must implement.
+const governanceABI = `[{"constant":true,"inputs":[],"name":"getSigners","outputs":[{"name":"","type":"address[]"}],"type":"function"}]`
+
+var (
+	// errGovernanceVote is returned if a block casts a vote while the signers
+	// are governed by contract.
+	errGovernanceVote = errors.New("vote cast under signer governance")
+
+	// errNoGovernanceSigners is returned if the governance contract returned no
+	// signers at a checkpoint.
+	errNoGovernanceSigners = errors.New("no signers in governance contract")
+
+	// errNoGovernanceState is returned if the state needed to call the governance
+	// contract is not available.
+	errNoGovernanceState = errors.New("governance state not available")
+)
+
+var parsedGovernanceABI, _ = abi.JSON(strings.NewReader(governanceABI))
+
+// signerReader retrieves the signers authorized from a checkpoint block on when
+// they are governed by contract.
+type signerReader func(checkpoint *types.Header) ([]common.Address, error)
+
+// stateReader is implemented by the chains able to provide the state of their
+// blocks, needed to call the governance contract.
+type stateReader interface {
+	StateAt(root common.Hash) (*state.StateDB, error)
+}
+
+// chainContext wraps a header reader into a core.ChainContext for the EVM.
+type chainContext struct {
+	consensus.ChainHeaderReader
+	engine consensus.Engine
+}
+
+func (c chainContext) Engine() consensus.Engine { return c.engine }
+
+// governanceSigners retrieves the signers authorized from a checkpoint on from
+// the governance contract. If the state needed isn't available, which happens
+// during fast sync or while a batch of headers is verified ahead of its blocks
+// being processed, the signers embedded in the checkpoint are used for the
+// snapshot. They are not trusted: VerifyState rejects the checkpoint when its
+// block is processed if they don't match the contract.
+func (c *Clique) governanceSigners(chain consensus.ChainHeaderReader, checkpoint *types.Header) ([]common.Address, error) {
+	number := checkpoint.Number.Uint64()
+	if parent := chain.GetHeader(checkpoint.ParentHash, number-1); parent != nil {
+		signers, err := c.readGovernance(chain, parent)
+		if err != errNoGovernanceState {
+			return signers, err
+		}
+	}
+	log.Debug("Deferring governance check of checkpoint signers", "number", number, "hash", checkpoint.Hash())
+	return checkpointSigners(checkpoint), nil
+}
+
+// checkpointSigners extracts the signer list embedded in a checkpoint header.
+func checkpointSigners(checkpoint *types.Header) []common.Address {
+	signers := make([]common.Address, (len(checkpoint.Extra)-extraVanity-extraSeal)/common.AddressLength)
+	for i := 0; i < len(signers); i++ {
+		copy(signers[i][:], checkpoint.Extra[extraVanity+i*common.AddressLength:])
+	}
+	return signers
+}
+
+// readGovernance calls getSigners on the governance contract against the state
+// of the block a checkpoint is built on, returning the unique signers sorted in
+// ascending order.
+func (c *Clique) readGovernance(chain consensus.ChainHeaderReader, parent *types.Header) ([]common.Address, error) {
+	reader, ok := chain.(stateReader)
+	if !ok {
+		return nil, errNoGovernanceState
+	}
+	statedb, err := reader.StateAt(parent.Root)
+	if err != nil {
+		return nil, errNoGovernanceState
+	}
+	input, err := parsedGovernanceABI.Pack("getSigners")
+	if err != nil {
+		return nil, err
+	}
+	context := vm.Context{
+		CanTransfer: core.CanTransfer,
+		Transfer:    core.Transfer,
+		GetHash:     core.GetHashFn(parent, chainContext{chain, c}),
+		Coinbase:    parent.Coinbase,
+		BlockNumber: new(big.Int).Set(parent.Number),
+		Time:        new(big.Int).SetUint64(parent.Time),
+		Difficulty:  new(big.Int).Set(parent.Difficulty),
+		GasLimit:    parent.GasLimit,
+		GasPrice:    new(big.Int),
+	}
+	evm := vm.NewEVM(context, statedb, chain.Config(), vm.Config{})
+	ret, _, err := evm.StaticCall(vm.AccountRef(common.Address{}), *c.config.GovernanceContract, input, governanceGas)
+	if err != nil {
+		return nil, fmt.Errorf("governance call failed: %v", err)
+	}
+	var signers []common.Address
+	if err := parsedGovernanceABI.UnpackIntoInterface(&signers, "getSigners", ret); err != nil {
+		return nil, fmt.Errorf("invalid governance signers: %v", err)
+	}
+	if len(signers) == 0 {
+		return nil, errNoGovernanceSigners
+	}
+	unique := make(map[common.Address]struct{})
+	for _, signer := range signers {
+		unique[signer] = struct{}{}
+	}
+	signers = signers[:0]
+	for signer := range unique {
+		signers = append(signers, signer)
+	}
+	sort.Sort(signersAscending(signers))
+	return signers, nil
+}
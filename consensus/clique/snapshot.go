@@ -181,8 +181,10 @@ func (s *Snapshot) uncast(address common.Address, authorize bool) bool {
 }
 
 // apply creates a new authorization snapshot by applying the given headers to
-// the original one.
-func (s *Snapshot) apply(headers []*types.Header) (*Snapshot, error) {
+// the original one. Under signer governance, header votes are rejected and the
+// signers are replaced at every checkpoint by the ones the governance reader
+// returns.
+func (s *Snapshot) apply(headers []*types.Header, governance signerReader) (*Snapshot, error) {
 	// Allow passing in no headers for cleaner code
 	if len(headers) == 0 {
 		return s, nil
@@ -229,6 +231,9 @@ func (s *Snapshot) apply(headers []*types.Header) (*Snapshot, error) {
 		}
 		snap.Recents[number] = signer
 
+		if s.config.IsGovernance(number) && (header.Coinbase != (common.Address{}) || !bytes.Equal(header.Nonce[:], nonceDropVote)) {
+			return nil, errGovernanceVote
+		}
 		// Header authorized, discard any previous votes from the signer
 		for i, vote := range snap.Votes {
 			if vote.Signer == signer && vote.Address == header.Coinbase {
@@ -291,6 +296,28 @@ func (s *Snapshot) apply(headers []*types.Header) (*Snapshot, error) {
 			}
 			delete(snap.Tally, header.Coinbase)
 		}
+		// If the signers are governed by contract, replace them on checkpoints
+		if number%s.config.Epoch == 0 && s.config.IsGovernance(number) {
+			signers, err := governance(header)
+			if err != nil {
+				return nil, err
+			}
+			if len(signers) == 0 {
+				return nil, errNoGovernanceSigners
+			}
+			snap.Signers = make(map[common.Address]struct{})
+			for _, signer := range signers {
+				snap.Signers[signer] = struct{}{}
+			}
+			// Signer list changed, delete any leftover recent caches
+			if limit := uint64(len(snap.Signers)/2 + 1); number >= limit {
+				for block := range snap.Recents {
+					if block <= number-limit {
+						delete(snap.Recents, block)
+					}
+				}
+			}
+		}
 		// If we're taking too much time (ecrecover), notify the user once a while
 		if time.Since(logged) > 8*time.Second {
 			log.Info("Reconstructing voting history", "processed", i, "total", len(headers), "elapsed", common.PrettyDuration(time.Since(start)))
@@ -324,3 +351,30 @@ func (s *Snapshot) inturn(number uint64, signer common.Address) bool {
 	}
 	return (number % uint64(len(signers))) == uint64(offset)
 }
+
+// backupSlot returns the turn of an out-of-turn signer at a given block height:
+// the signers following the in-turn one take turns in ascending order, skipping
+// the ones not allowed to sign for having signed recently.
+func (s *Snapshot) backupSlot(number uint64, signer common.Address) int {
+	signers := s.signers()
+	limit := uint64(len(signers)/2 + 1)
+
+	recents := make(map[common.Address]bool)
+	for seen, recent := range s.Recents {
+		if number < limit || seen > number-limit {
+			recents[recent] = true
+		}
+	}
+	slot, inturn := 0, int(number%uint64(len(signers)))
+	for i := 1; i < len(signers); i++ {
+		next := signers[(inturn+i)%len(signers)]
+		if recents[next] {
+			continue
+		}
+		slot++
+		if next == signer {
+			break
+		}
+	}
+	return slot
+}
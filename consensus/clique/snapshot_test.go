@@ -19,6 +19,7 @@ package clique
 import (
 	"bytes"
 	"crypto/ecdsa"
+	"reflect"
 	"sort"
 	"testing"
 
@@ -502,3 +503,217 @@ func TestClique(t *testing.T) {
 		}
 	}
 }
+
+// Tests that under signer governance the signers are replaced at checkpoints by
+// the ones listed in the governance contract and header votes are rejected.
+func TestCliqueGovernance(t *testing.T) {
+	tests := []struct {
+		votes   []testerVote
+		headers bool // Import the headers ahead of the blocks, without governance state
+		results []string
+		failure error
+	}{
+		{
+			// Checkpoint rotates the signers to the governed ones
+			votes: []testerVote{
+				{signer: "A"},
+				{signer: "A"},
+				{signer: "A", checkpoint: []string{"B", "C"}},
+				{signer: "B"},
+				{signer: "C"},
+			},
+			results: []string{"B", "C"},
+		}, {
+			// Signers dropped by the contract can't sign any more
+			votes: []testerVote{
+				{signer: "A"},
+				{signer: "A"},
+				{signer: "A", checkpoint: []string{"B", "C"}},
+				{signer: "A"},
+			},
+			failure: errUnauthorizedSigner,
+		}, {
+			// Checkpoints must list the governed signers, not the voted ones
+			votes: []testerVote{
+				{signer: "A"},
+				{signer: "A"},
+				{signer: "A", checkpoint: []string{"A"}},
+			},
+			failure: errMismatchingCheckpointSigners,
+		}, {
+			// Governed checkpoints are accepted when imported as headers
+			votes: []testerVote{
+				{signer: "A"},
+				{signer: "A"},
+				{signer: "A", checkpoint: []string{"B", "C"}},
+				{signer: "B"},
+			},
+			headers: true,
+			results: []string{"B", "C"},
+		}, {
+			// Forged checkpoints imported as headers are rejected with their block
+			votes: []testerVote{
+				{signer: "A"},
+				{signer: "A"},
+				{signer: "A", checkpoint: []string{"A"}},
+				{signer: "A"},
+			},
+			headers: true,
+			failure: errMismatchingCheckpointSigners,
+		}, {
+			// Votes are disabled under governance
+			votes: []testerVote{
+				{signer: "A", voted: "B", auth: true},
+			},
+			failure: errGovernanceVote,
+		},
+	}
+	for i, tt := range tests {
+		accounts := newTesterAccountPool()
+
+		// Deploy a governance contract returning B and C as the signers
+		governed := []common.Address{accounts.address("B"), accounts.address("C")}
+		sort.Sort(signersAscending(governed))
+
+		ret := append(common.LeftPadBytes([]byte{0x20}, 32), common.LeftPadBytes([]byte{byte(len(governed))}, 32)...)
+		for _, signer := range governed {
+			ret = append(ret, common.LeftPadBytes(signer[:], 32)...)
+		}
+		code := append([]byte{
+			byte(vm.PUSH1), byte(len(ret)), byte(vm.PUSH1), 12, byte(vm.PUSH1), 0, byte(vm.CODECOPY),
+			byte(vm.PUSH1), byte(len(ret)), byte(vm.PUSH1), 0, byte(vm.RETURN),
+		}, ret...)
+		contract := common.Address{0x10}
+
+		genesis := &core.Genesis{
+			ExtraData: make([]byte, extraVanity+common.AddressLength+extraSeal),
+			Alloc:     core.GenesisAlloc{contract: {Balance: common.Big0, Code: code}},
+		}
+		copy(genesis.ExtraData[extraVanity:], accounts.address("A").Bytes())
+
+		db := rawdb.NewMemoryDatabase()
+		genesis.Commit(db)
+
+		config := *params.TestChainConfig
+		config.Clique = &params.CliqueConfig{
+			Period:             1,
+			Epoch:              3,
+			GovernanceContract: &contract,
+		}
+		engine := New(config.Clique, db)
+		engine.fakeDiff = true
+
+		blocks, _ := core.GenerateChain(&config, genesis.ToBlock(db), engine, db, len(tt.votes), func(j int, gen *core.BlockGen) {
+			gen.SetCoinbase(accounts.address(tt.votes[j].voted))
+			if tt.votes[j].auth {
+				var nonce types.BlockNonce
+				copy(nonce[:], nonceAuthVote)
+				gen.SetNonce(nonce)
+			}
+		})
+		for j, block := range blocks {
+			header := block.Header()
+			if j > 0 {
+				header.ParentHash = blocks[j-1].Hash()
+			}
+			header.Extra = make([]byte, extraVanity+extraSeal)
+			if auths := tt.votes[j].checkpoint; auths != nil {
+				header.Extra = make([]byte, extraVanity+len(auths)*common.AddressLength+extraSeal)
+				accounts.checkpoint(header, auths)
+			}
+			header.Difficulty = diffInTurn
+
+			accounts.sign(header, tt.votes[j].signer)
+			blocks[j] = block.WithSeal(header)
+		}
+		chain, err := core.NewBlockChain(db, nil, &config, engine, vm.Config{}, nil, nil)
+		if err != nil {
+			t.Errorf("test %d: failed to create test chain: %v", i, err)
+			continue
+		}
+		if tt.headers {
+			// The checkpoint can't be checked against the contract during header import
+			headers := make([]*types.Header, len(blocks))
+			for j, block := range blocks {
+				headers[j] = block.Header()
+			}
+			if _, err := chain.InsertHeaderChain(headers, 1); err != nil {
+				t.Errorf("test %d: failed to import headers: %v", i, err)
+				chain.Stop()
+				continue
+			}
+			// It is checked with the block body once the state of its parent is available
+			if _, err = chain.InsertChain(blocks[:2]); err != nil {
+				t.Errorf("test %d: failed to import blocks: %v", i, err)
+			} else if err = chain.Validator().ValidateBody(blocks[2]); err != tt.failure {
+				t.Errorf("test %d: body validation mismatch: have %v, want %v", i, err, tt.failure)
+			} else if err == nil {
+				_, err = chain.InsertChain(blocks[2:])
+			}
+		} else {
+			// Import the blocks one by one for the governance state to be available
+			for j, block := range blocks {
+				if _, err = chain.InsertChain(types.Blocks{block}); err != nil {
+					if j != len(blocks)-1 || err != tt.failure {
+						t.Errorf("test %d: failed to import block %d: %v", i, j, err)
+					}
+					break
+				}
+			}
+		}
+		if err != tt.failure {
+			t.Errorf("test %d: failure mismatch: have %v, want %v", i, err, tt.failure)
+		}
+		if tt.failure != nil {
+			chain.Stop()
+			continue
+		}
+		head := blocks[len(blocks)-1]
+		snap, err := engine.snapshot(chain, head.NumberU64(), head.Hash(), nil)
+		chain.Stop()
+		if err != nil {
+			t.Errorf("test %d: failed to retrieve snapshot: %v", i, err)
+			continue
+		}
+		results := make([]common.Address, len(tt.results))
+		for j, signer := range tt.results {
+			results[j] = accounts.address(signer)
+		}
+		sort.Sort(signersAscending(results))
+		if have := snap.signers(); !reflect.DeepEqual(have, results) {
+			t.Errorf("test %d: signers mismatch: have %x, want %x", i, have, results)
+		}
+	}
+}
+
+// Tests that out-of-turn signers are given deterministic backup slots following
+// the in-turn signer, skipping the ones that signed recently.
+func TestBackupSlot(t *testing.T) {
+	accounts := newTesterAccountPool()
+
+	names := []string{"A", "B", "C", "D", "E", "F", "G"}
+	signers := make([]common.Address, len(names))
+	for i, name := range names {
+		signers[i] = accounts.address(name)
+	}
+	sort.Sort(signersAscending(signers))
+
+	snap := newSnapshot(&params.CliqueConfig{Epoch: 30000}, nil, 99, common.Hash{}, signers)
+
+	// Block 100 is in-turn for signers[2], the others follow in ascending order
+	for i := 1; i < len(signers); i++ {
+		if slot := snap.backupSlot(100, signers[(2+i)%len(signers)]); slot != i {
+			t.Errorf("signer %d: slot mismatch: have %d, want %d", i, slot, i)
+		}
+	}
+	// Recent signers are skipped, the ones shifted out of the recents are not
+	snap.Recents[99] = signers[3]
+	snap.Recents[98] = signers[4]
+	snap.Recents[96] = signers[5]
+
+	for signer, want := range map[common.Address]int{signers[5]: 1, signers[6]: 2, signers[0]: 3, signers[1]: 4} {
+		if slot := snap.backupSlot(100, signer); slot != want {
+			t.Errorf("signer %x: slot mismatch: have %d, want %d", signer, slot, want)
+		}
+	}
+}
@@ -122,6 +122,28 @@ type Engine interface {
 	Close() error
 }
 
+// Finality is an optional interface of the consensus engines able to tell which
+// blocks of the local chain are not expected to be reorged anymore.
+type Finality interface {
+	// FinalizedHeader returns the newest header of the chain that can't be
+	// reverted anymore by the consensus rules, or nil if there's none yet.
+	FinalizedHeader(chain ChainHeaderReader) *types.Header
+
+	// SafeHeader returns the newest header of the chain that is unlikely to be
+	// reverted, or nil if there's none yet.
+	SafeHeader(chain ChainHeaderReader) *types.Header
+}
+
+// StateVerifier is an optional interface of the consensus engines with header
+// rules depending on chain state. These rules can't be checked when the header is
+// verified ahead of its parent being processed, and are checked when the block is
+// processed instead.
+type StateVerifier interface {
+	// VerifyState checks the state dependent rules of a header. The state of
+	// its parent must be available.
+	VerifyState(chain ChainHeaderReader, header *types.Header) error
+}
+
 // PoW is a consensus engine based on proof-of-work.
 type PoW interface {
 	Engine
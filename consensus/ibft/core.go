@@ -0,0 +1,597 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package ibft
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/consensus"
+	"github.com/mxt/go-mxt/core"
+	"github.com/mxt/go-mxt/core/types"
+	"github.com/mxt/go-mxt/core/vm"
+	"github.com/mxt/go-mxt/crypto"
+	"github.com/mxt/go-mxt/log"
+	"github.com/mxt/go-mxt/rlp"
+)
+
+// Consensus message codes.
+const (
+	msgPreprepare  = 0x00 // Block proposed by the proposer of the round
+	msgPrepare     = 0x01 // Validator accepted the proposed block
+	msgCommit      = 0x02 // Validator saw a quorum preparing the block and locked on it
+	msgRoundChange = 0x03 // Validator timed out waiting for the round to commit
+)
+
+const (
+	maxBacklog      = 1024 // Maximum number of consensus messages kept for future heights
+	maxFutureRounds = 64   // Maximum number of rounds ahead of the current one to keep messages of
+	maxRoundTimeout = 8    // Maximum exponent of the round timeout backoff
+)
+
+// errInvalidMessage is returned if a consensus message is malformed.
+var errInvalidMessage = errors.New("invalid consensus message")
+
+// message is a consensus message, signed by the validator sending it.
+type message struct {
+	Code          uint64
+	Height        uint64
+	Round         uint64
+	Digest        common.Hash // Hash of the block prepared or committed
+	Proposal      []byte      // RLP encoded block on pre-prepares
+	CommittedSeal []byte      // Signature over the commit hash of the block on commits
+	Signature     []byte      // Signature of the sender over the message
+
+	sender common.Address // Validator that sent the message, recovered from the signature
+}
+
+// sigHash returns the hash of the message the sender signs.
+func (m *message) sigHash() common.Hash {
+	blob, _ := rlp.EncodeToBytes([]interface{}{m.Code, m.Height, m.Round, m.Digest, m.Proposal, m.CommittedSeal})
+	return crypto.Keccak256Hash(blob)
+}
+
+// encode signs the message with the key of the local validator and returns its
+// wire encoding.
+func (m *message) encode(e *IBFT) ([]byte, error) {
+	sig, err := crypto.Sign(m.sigHash().Bytes(), e.key)
+	if err != nil {
+		return nil, err
+	}
+	m.Signature, m.sender = sig, e.address
+	return rlp.EncodeToBytes(m)
+}
+
+// decodeMessage decodes a consensus message and recovers its sender.
+func decodeMessage(payload []byte) (*message, error) {
+	msg := new(message)
+	if err := rlp.DecodeBytes(payload, msg); err != nil {
+		return nil, err
+	}
+	if msg.Code > msgRoundChange {
+		return nil, errInvalidMessage
+	}
+	pubkey, err := crypto.SigToPub(msg.sigHash().Bytes(), msg.Signature)
+	if err != nil {
+		return nil, err
+	}
+	msg.sender = crypto.PubkeyToAddress(*pubkey)
+	return msg, nil
+}
+
+// roundState is the consensus state of a single round of the current height.
+type roundState struct {
+	proposal    *types.Block                // Block proposed in the round, nil until accepted
+	preprepare  *message                    // Pre-prepare received before the round started
+	prepares    map[common.Address]*message // Prepares received in the round by sender
+	roundChange map[common.Address]struct{} // Validators that moved to the round
+	prepared    bool                        // Whmxter a commit was sent in the round
+}
+
+func newRoundState() *roundState {
+	return &roundState{
+		prepares:    make(map[common.Address]*message),
+		roundChange: make(map[common.Address]struct{}),
+	}
+}
+
+// candidate is the block the local miner proposes for a height, along with the
+// channel to deliver it on once committed.
+type candidate struct {
+	block   *types.Block
+	results chan<- *types.Block
+	stop    <-chan struct{} // Closed if the miner abandoned the block
+}
+
+// bftCore is the consensus state machine of a validator, deciding on one block
+// per height in successive rounds.
+type bftCore struct {
+	engine *IBFT
+	chain  Chain
+
+	snap      *Snapshot                // Validators deciding the current height
+	height    uint64                   // Number of the block being decided
+	round     uint64                   // Current round of the height
+	waitRound uint64                   // Highest round this validator asked to move to
+	rounds    map[uint64]*roundState   // Consensus state of every round of the height
+	commits   map[common.Hash][][]byte // Committed seals of the height by block hash
+	committed map[common.Hash]map[common.Address]struct{}
+	locked    *types.Block // Block prepared by a quorum, the only one to commit at this height
+	done      bool         // Whmxter the height was committed
+	pending   *candidate   // Block of the local miner for the height
+	backlog   []*message   // Messages of future heights
+	timer     *time.Timer  // Round timeout
+
+	proposeCh chan *candidate
+	msgCh     chan *message
+	headCh    chan core.ChainHeadEvent
+	failCh    chan *types.Block // Committed blocks which failed to import
+	quit      chan struct{}
+	wg        sync.WaitGroup
+}
+
+func newCore(engine *IBFT, chain Chain) *bftCore {
+	c := &bftCore{
+		engine:    engine,
+		chain:     chain,
+		timer:     time.NewTimer(0),
+		proposeCh: make(chan *candidate),
+		msgCh:     make(chan *message, 256),
+		headCh:    make(chan core.ChainHeadEvent, 16),
+		failCh:    make(chan *types.Block),
+		quit:      make(chan struct{}),
+	}
+	<-c.timer.C
+
+	c.wg.Add(1)
+	go c.loop()
+	return c
+}
+
+// close terminates the consensus.
+func (c *bftCore) close() {
+	close(c.quit)
+	c.wg.Wait()
+}
+
+// propose hands the block of the local miner to the consensus.
+func (c *bftCore) propose(block *types.Block, results chan<- *types.Block, stop <-chan struct{}) {
+	select {
+	case c.proposeCh <- &candidate{block: block, results: results, stop: stop}:
+	case <-stop:
+	case <-c.quit:
+	}
+}
+
+// deliver hands a consensus message received from the network to the consensus.
+func (c *bftCore) deliver(msg *message) {
+	select {
+	case c.msgCh <- msg:
+	case <-c.quit:
+	}
+}
+
+// loop is the main event loop of the consensus.
+func (c *bftCore) loop() {
+	defer c.wg.Done()
+
+	sub := c.chain.SubscribeChainHeadEvent(c.headCh)
+	defer sub.Unsubscribe()
+
+	c.newHead()
+	for {
+		select {
+		case <-c.headCh:
+			c.newHead()
+
+		case cand := <-c.proposeCh:
+			c.newHead()
+			if cand.block.NumberU64() == c.height {
+				c.pending = cand
+				if rs := c.current(); rs.proposal == nil && c.isProposer() {
+					c.sendPreprepare()
+				}
+			}
+
+		case msg := <-c.msgCh:
+			c.newHead()
+			c.handle(msg)
+
+		case block := <-c.failCh:
+			c.reopen(block)
+
+		case <-c.timer.C:
+			c.timeout()
+
+		case <-sub.Err():
+			return
+
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// newHead starts deciding the block following the chain head once the head
+// moved past the height being decided.
+func (c *bftCore) newHead() {
+	head := c.chain.CurrentHeader()
+	if number := head.Number.Uint64(); number+1 <= c.height {
+		return
+	}
+	snap, err := c.engine.snapshot(c.chain, head.Number.Uint64(), head.Hash(), nil)
+	if err != nil {
+		log.Warn("Failed to retrieve validators", "number", head.Number, "hash", head.Hash(), "err", err)
+		return
+	}
+	c.snap, c.height = snap, head.Number.Uint64()+1
+	c.rounds = make(map[uint64]*roundState)
+	c.commits = make(map[common.Hash][][]byte)
+	c.committed = make(map[common.Hash]map[common.Address]struct{})
+	c.locked, c.done, c.pending, c.waitRound = nil, false, nil, 0
+
+	log.Debug("Starting consensus height", "number", c.height, "validators", len(snap.Validators))
+	c.startRound(0)
+
+	// Proposing needs the committed seals of the head, fetch them if lost
+	if head.Number.Uint64() > 0 {
+		if _, ok := c.engine.committedSeals(head.Hash()); !ok {
+			c.engine.requestSeals(head.Hash())
+		}
+	}
+
+	// Replay any messages received in advance for the new height
+	backlog := c.backlog
+	c.backlog = nil
+	for _, msg := range backlog {
+		if msg.Height >= c.height {
+			c.handle(msg)
+		}
+	}
+}
+
+// current returns the state of the current round.
+func (c *bftCore) current() *roundState {
+	return c.roundState(c.round)
+}
+
+// roundState returns the state of a round of the current height.
+func (c *bftCore) roundState(round uint64) *roundState {
+	rs, ok := c.rounds[round]
+	if !ok {
+		rs = newRoundState()
+		c.rounds[round] = rs
+	}
+	return rs
+}
+
+// isProposer returns whmxter the local validator proposes the current round.
+func (c *bftCore) isProposer() bool {
+	return c.snap.proposer(c.round) == c.engine.address
+}
+
+// startRound moves the consensus to a new round of the current height.
+func (c *bftCore) startRound(round uint64) {
+	c.round = round
+	if c.waitRound < round {
+		c.waitRound = round
+	}
+	c.resetTimer(round)
+
+	rs := c.current()
+	if c.isProposer() {
+		c.sendPreprepare()
+	} else if rs.preprepare != nil {
+		c.handlePreprepare(rs.preprepare)
+	}
+}
+
+// resetTimer restarts the round timeout, doubling it with every round.
+func (c *bftCore) resetTimer(round uint64) {
+	if !c.timer.Stop() {
+		select {
+		case <-c.timer.C:
+		default:
+		}
+	}
+	if round > maxRoundTimeout {
+		round = maxRoundTimeout
+	}
+	c.timer.Reset(time.Duration(c.engine.config.RequestTimeout) * time.Millisecond << round)
+}
+
+// timeout asks the other validators to move to the next round if the current
+// one failed to commit in time.
+func (c *bftCore) timeout() {
+	if c.snap == nil || c.done {
+		return
+	}
+	c.waitRound++
+	log.Debug("Consensus round timed out", "number", c.height, "round", c.round, "next", c.waitRound)
+
+	c.resetTimer(c.waitRound)
+	c.broadcast(&message{Code: msgRoundChange, Height: c.height, Round: c.waitRound})
+}
+
+// sendPreprepare proposes the locked block, or the block of the local miner, as
+// the proposer of the current round.
+func (c *bftCore) sendPreprepare() {
+	block := c.locked
+	if block == nil && c.pending != nil && c.pending.block.NumberU64() == c.height {
+		block = c.pending.block
+	}
+	if block == nil {
+		return
+	}
+	blob, err := rlp.EncodeToBytes(block)
+	if err != nil {
+		log.Error("Failed to encode proposal", "err", err)
+		return
+	}
+	log.Debug("Proposing block", "number", c.height, "round", c.round, "hash", block.Hash())
+	c.broadcast(&message{Code: msgPreprepare, Height: c.height, Round: c.round, Digest: block.Hash(), Proposal: blob})
+}
+
+// broadcast signs and sends a consensus message to all peers, handling it
+// locally too.
+func (c *bftCore) broadcast(msg *message) {
+	payload, err := msg.encode(c.engine)
+	if err != nil {
+		log.Error("Failed to sign consensus message", "err", err)
+		return
+	}
+	hash := crypto.Keccak256Hash(payload)
+	c.engine.known.Add(hash, struct{}{})
+	c.engine.peers.broadcast(hash, payload)
+	c.handle(msg)
+}
+
+// handle processes a consensus message.
+func (c *bftCore) handle(msg *message) {
+	if c.snap == nil {
+		return
+	}
+	if _, ok := c.snap.Validators[msg.sender]; !ok {
+		log.Trace("Dropping message from non-validator", "sender", msg.sender)
+		return
+	}
+	switch {
+	case msg.Height > c.height:
+		if len(c.backlog) < maxBacklog {
+			c.backlog = append(c.backlog, msg)
+		}
+		return
+	case msg.Height < c.height || c.done:
+		return
+	case msg.Round > c.round+maxFutureRounds:
+		log.Trace("Dropping message of far future round", "sender", msg.sender, "round", msg.Round)
+		return
+	}
+	switch msg.Code {
+	case msgPreprepare:
+		if msg.Round > c.round {
+			c.roundState(msg.Round).preprepare = msg
+			return
+		}
+		if msg.Round == c.round {
+			c.handlePreprepare(msg)
+		}
+	case msgPrepare:
+		c.roundState(msg.Round).prepares[msg.sender] = msg
+		if msg.Round == c.round {
+			c.checkPrepared()
+		}
+	case msgCommit:
+		c.handleCommit(msg)
+	case msgRoundChange:
+		c.handleRoundChange(msg)
+	}
+}
+
+// handlePreprepare validates the block proposed in the current round and
+// prepares it if acceptable.
+func (c *bftCore) handlePreprepare(msg *message) {
+	rs := c.current()
+	if rs.proposal != nil || msg.sender != c.snap.proposer(c.round) {
+		return
+	}
+	block := new(types.Block)
+	if err := rlp.DecodeBytes(msg.Proposal, block); err != nil || block.Hash() != msg.Digest {
+		log.Debug("Invalid proposal received", "sender", msg.sender, "err", err)
+		return
+	}
+	if c.locked != nil && c.locked.Hash() != block.Hash() {
+		log.Debug("Rejecting proposal conflicting with locked block", "number", c.height, "locked", c.locked.Hash(), "proposal", block.Hash())
+		return
+	}
+	if err := c.verifyProposal(block); err != nil {
+		log.Warn("Rejecting invalid proposal", "number", c.height, "hash", block.Hash(), "err", err)
+		return
+	}
+	rs.proposal = block
+	c.broadcast(&message{Code: msgPrepare, Height: c.height, Round: c.round, Digest: block.Hash()})
+	c.checkPrepared()
+	c.checkCommitted(block.Hash())
+}
+
+// verifyProposal checks that a proposed block extends the chain head and that
+// executing it yields the state and receipts its header commits to. A block
+// committed by a quorum can't be replaced, so it must be importable.
+func (c *bftCore) verifyProposal(block *types.Block) error {
+	if block.NumberU64() != c.height || block.ParentHash() != c.snap.Hash {
+		return fmt.Errorf("proposal not on chain head %x", c.snap.Hash)
+	}
+	if len(block.Uncles()) > 0 {
+		return errInvalidUncleHash
+	}
+	if err := c.engine.VerifyHeader(c.chain, block.Header(), true); err != nil {
+		return err
+	}
+	switch err := c.chain.Validator().ValidateBody(block); err {
+	case nil:
+	case core.ErrKnownBlock:
+		return nil
+	default:
+		return err
+	}
+	parent := c.chain.GetHeader(block.ParentHash(), block.NumberU64()-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	statedb, err := c.chain.StateAt(parent.Root)
+	if err != nil {
+		return err
+	}
+	receipts, _, usedGas, err := c.chain.Processor().Process(block, statedb, vm.Config{})
+	if err != nil {
+		return err
+	}
+	return c.chain.Validator().ValidateState(block, statedb, receipts, usedGas)
+}
+
+// checkPrepared locks on the proposal of the current round and commits it once
+// a quorum of validators prepared it.
+func (c *bftCore) checkPrepared() {
+	rs := c.current()
+	if rs.proposal == nil || rs.prepared {
+		return
+	}
+	hash, votes := rs.proposal.Hash(), 0
+	for _, prepare := range rs.prepares {
+		if prepare.Digest == hash {
+			votes++
+		}
+	}
+	if votes < c.snap.quorum() {
+		return
+	}
+	seal, err := crypto.Sign(commitHash(hash), c.engine.key)
+	if err != nil {
+		log.Error("Failed to sign commit", "err", err)
+		return
+	}
+	rs.prepared, c.locked = true, rs.proposal
+	c.broadcast(&message{Code: msgCommit, Height: c.height, Round: c.round, Digest: hash, CommittedSeal: seal})
+}
+
+// handleCommit collects the committed seals of a block, committing it once a
+// quorum of validators committed it.
+func (c *bftCore) handleCommit(msg *message) {
+	pubkey, err := crypto.SigToPub(commitHash(msg.Digest), msg.CommittedSeal)
+	if err != nil || crypto.PubkeyToAddress(*pubkey) != msg.sender {
+		log.Debug("Invalid committed seal received", "sender", msg.sender)
+		return
+	}
+	if c.committed[msg.Digest] == nil {
+		c.committed[msg.Digest] = make(map[common.Address]struct{})
+	}
+	if _, ok := c.committed[msg.Digest][msg.sender]; ok {
+		return
+	}
+	c.committed[msg.Digest][msg.sender] = struct{}{}
+	c.commits[msg.Digest] = append(c.commits[msg.Digest], msg.CommittedSeal)
+
+	c.checkCommitted(msg.Digest)
+}
+
+// checkCommitted finalizes the height if a quorum committed a known block.
+func (c *bftCore) checkCommitted(hash common.Hash) {
+	if c.done || len(c.commits[hash]) < c.snap.quorum() {
+		return
+	}
+	var block *types.Block
+	if c.locked != nil && c.locked.Hash() == hash {
+		block = c.locked
+	}
+	for _, rs := range c.rounds {
+		if rs.proposal != nil && rs.proposal.Hash() == hash {
+			block = rs.proposal
+		}
+	}
+	if block == nil {
+		return // Committed by a quorum, but the proposal is not known yet
+	}
+	c.done = true
+	c.timer.Stop()
+	c.engine.storeCommittedSeals(block.Header(), c.commits[hash])
+
+	log.Info("Committed new block", "number", c.height, "round", c.round, "hash", hash, "commits", len(c.commits[hash]))
+
+	// Hand the block to the miner if it's our own and still wanted, insert it otherwise
+	if c.pending != nil && c.pending.block.Hash() == hash {
+		select {
+		case <-c.pending.stop:
+		default:
+			select {
+			case c.pending.results <- block:
+				return
+			default:
+			}
+		}
+	}
+	go func() {
+		if _, err := c.chain.InsertChain(types.Blocks{block}); err != nil {
+			log.Error("Failed to insert committed block", "number", block.Number(), "hash", block.Hash(), "err", err)
+			select {
+			case c.failCh <- block:
+			case <-c.quit:
+			}
+		}
+	}()
+}
+
+// reopen resumes deciding the current height after its committed block failed
+// to import, so that the round timeout can move the validators on to another
+// proposal.
+func (c *bftCore) reopen(block *types.Block) {
+	if !c.done || block.NumberU64() != c.height {
+		return
+	}
+	hash := block.Hash()
+	if c.locked != nil && c.locked.Hash() == hash {
+		c.locked = nil
+	}
+	delete(c.commits, hash)
+	delete(c.committed, hash)
+	c.done = false
+	c.resetTimer(c.waitRound)
+}
+
+// handleRoundChange moves to a later round once a quorum of validators asked
+// for it, catching up with the others if enough of them are ahead.
+func (c *bftCore) handleRoundChange(msg *message) {
+	if msg.Round <= c.round {
+		return
+	}
+	rs := c.roundState(msg.Round)
+	rs.roundChange[msg.sender] = struct{}{}
+
+	switch {
+	case len(rs.roundChange) >= c.snap.quorum():
+		log.Debug("Moving to new consensus round", "number", c.height, "round", msg.Round)
+		c.startRound(msg.Round)
+
+	case len(rs.roundChange) > c.snap.faulty() && msg.Round > c.waitRound:
+		// Enough honest validators are ahead, join them
+		c.waitRound = msg.Round
+		c.resetTimer(msg.Round)
+		c.broadcast(&message{Code: msgRoundChange, Height: c.height, Round: msg.Round})
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package ibft
+
+import (
+	"errors"
+
+	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/core/types"
+	"github.com/mxt/go-mxt/crypto"
+	"github.com/mxt/go-mxt/rlp"
+)
+
+const extraVanity = 32 // Fixed number of extra-data prefix bytes reserved for proposer vanity
+
+// errInvalidExtra is returned if the IBFT section of a header's extra-data can't
+// be decoded.
+var errInvalidExtra = errors.New("invalid ibft extra-data")
+
+// Extra is the IBFT section of a header's extra-data, RLP encoded after the
+// vanity prefix.
+//
+// The committed seals finalizing a block are carried by its child, as the hash
+// of a block can't depend on which quorum of validators happened to commit it.
+type Extra struct {
+	Validators    []common.Address // Validator set on checkpoint blocks, empty otherwise
+	CommittedSeal [][]byte         // Commit signatures of the parent block by a quorum of validators
+	Seal          []byte           // Signature of the proposer over the seal hash
+}
+
+// ExtractExtra decodes the IBFT section of a header's extra-data.
+func ExtractExtra(header *types.Header) (*Extra, error) {
+	if len(header.Extra) < extraVanity {
+		return nil, errMissingVanity
+	}
+	extra := new(Extra)
+	if err := rlp.DecodeBytes(header.Extra[extraVanity:], extra); err != nil {
+		return nil, errInvalidExtra
+	}
+	return extra, nil
+}
+
+// encodeExtra assembles the extra-data of a header from the vanity prefix and
+// the IBFT section.
+func encodeExtra(vanity []byte, extra *Extra) ([]byte, error) {
+	blob, err := rlp.EncodeToBytes(extra)
+	if err != nil {
+		return nil, err
+	}
+	return append(common.CopyBytes(vanity[:extraVanity]), blob...), nil
+}
+
+// SealHash returns the hash of a header prior to it being sealed by the proposer,
+// that is without the proposer seal in its extra-data.
+func SealHash(header *types.Header) common.Hash {
+	extra, err := ExtractExtra(header)
+	if err != nil {
+		return header.Hash()
+	}
+	extra.Seal = nil
+
+	cpy := types.CopyHeader(header)
+	if cpy.Extra, err = encodeExtra(header.Extra, extra); err != nil {
+		return header.Hash()
+	}
+	return cpy.Hash()
+}
+
+// commitHash returns the digest validators sign to commit a block.
+func commitHash(hash common.Hash) []byte {
+	return crypto.Keccak256(hash.Bytes(), []byte{msgCommit})
+}
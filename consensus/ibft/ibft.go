@@ -0,0 +1,826 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+// Package ibft implements a Byzantine fault tolerant proof-of-authority consensus
+// engine with instant finality.
+//
+// Validators agree on every block in a round-based three-phase commit run over
+// a dedicated p2p sub-protocol: the proposer of the round broadcasts the block
+// (PRE-PREPARE), validators accepting it broadcast a PREPARE and, once a quorum
+// prepared it, lock on the block and broadcast a signed COMMIT. A block is final
+// as soon as a quorum committed it, and the commit signatures are embedded into
+// the extra-data of the next block. If a round fails to commit in time, the
+// validators move to the next round with another proposer (ROUND-CHANGE).
+package ibft
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/common/hexutil"
+	"github.com/mxt/go-mxt/consensus"
+	"github.com/mxt/go-mxt/consensus/misc"
+	"github.com/mxt/go-mxt/core"
+	"github.com/mxt/go-mxt/core/state"
+	"github.com/mxt/go-mxt/core/types"
+	"github.com/mxt/go-mxt/crypto"
+	"github.com/mxt/go-mxt/event"
+	"github.com/mxt/go-mxt/log"
+	"github.com/mxt/go-mxt/mxtdb"
+	"github.com/mxt/go-mxt/params"
+	"github.com/mxt/go-mxt/rlp"
+	"github.com/mxt/go-mxt/rpc"
+	"github.com/mxt/go-mxt/trie"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	checkpointInterval = 1024 // Number of blocks after which to save the snapshot to the database
+	inmemorySnapshots  = 128  // Number of recent snapshots to keep in memory
+	inmemorySignatures = 4096 // Number of recent block signatures to keep in memory
+	inmemorySeals      = 128  // Number of recent committed seal sets to keep in memory
+	inmemorySealReqs   = 128  // Number of committed seal requests to remember
+
+	epochLength    = uint64(30000) // Default number of blocks after which to checkpoint and reset the pending votes
+	requestTimeout = uint64(10000) // Default milliseconds to wait for a round to commit
+)
+
+var (
+	nonceAuthVote = hexutil.MustDecode("0xffffffffffffffff") // Magic nonce number to vote on adding a new validator
+	nonceDropVote = hexutil.MustDecode("0x0000000000000000") // Magic nonce number to vote on removing a validator
+
+	uncleHash = types.CalcUncleHash(nil) // Always Keccak256(RLP([])) as uncles are meaningless outside of PoW
+
+	defaultDifficulty = big.NewInt(1) // Difficulty of every block, forks are impossible after commit
+)
+
+// Various error messages to mark blocks invalid.
+var (
+	// errUnknownBlock is returned when the list of validators is requested for a
+	// block that is not part of the local blockchain.
+	errUnknownBlock = errors.New("unknown block")
+
+	// errInvalidCheckpointBeneficiary is returned if a checkpoint/epoch transition
+	// block has a beneficiary set to non-zeroes.
+	errInvalidCheckpointBeneficiary = errors.New("beneficiary in checkpoint block non-zero")
+
+	// errInvalidVote is returned if a nonce value is not one of the two allowed
+	// constants of 0x00..0 or 0xff..f.
+	errInvalidVote = errors.New("vote nonce not 0x00..0 or 0xff..f")
+
+	// errInvalidCheckpointVote is returned if a checkpoint/epoch transition block
+	// has a vote nonce set to non-zeroes.
+	errInvalidCheckpointVote = errors.New("vote nonce in checkpoint block non-zero")
+
+	// errMissingVanity is returned if a block's extra-data section is shorter than
+	// 32 bytes, which is required to store the proposer vanity.
+	errMissingVanity = errors.New("extra-data 32 byte vanity prefix missing")
+
+	// errMissingSignature is returned if a block's extra-data section doesn't
+	// contain a 65 byte secp256k1 proposer seal.
+	errMissingSignature = errors.New("extra-data 65 byte proposer seal missing")
+
+	// errExtraValidators is returned if a non-checkpoint block contains a
+	// validator list in its extra-data.
+	errExtraValidators = errors.New("non-checkpoint block contains extra validator list")
+
+	// errMismatchingCheckpointValidators is returned if a checkpoint block
+	// contains a validator list different than the one the local node calculated.
+	errMismatchingCheckpointValidators = errors.New("mismatching validator list on checkpoint block")
+
+	// errInvalidCommittedSeals is returned if a block doesn't carry the commit
+	// signatures of a quorum of validators for its parent.
+	errInvalidCommittedSeals = errors.New("invalid committed seals")
+
+	// errMissingCommittedSeals is returned if a block is prepared on top of a
+	// parent the local node doesn't know the commit signatures of.
+	errMissingCommittedSeals = errors.New("missing committed seals of parent")
+
+	// errInvalidMixDigest is returned if a block's mix digest is non-zero.
+	errInvalidMixDigest = errors.New("non-zero mix digest")
+
+	// errInvalidUncleHash is returned if a block contains an non-empty uncle list.
+	errInvalidUncleHash = errors.New("non empty uncle hash")
+
+	// errInvalidDifficulty is returned if the difficulty of a block is not 1.
+	errInvalidDifficulty = errors.New("invalid difficulty")
+
+	// errInvalidTimestamp is returned if the timestamp of a block is lower than
+	// the previous block's timestamp + the minimum block period.
+	errInvalidTimestamp = errors.New("invalid timestamp")
+
+	// errInvalidVotingChain is returned if the validator set is attempted to be
+	// modified via out-of-range or non-contiguous headers.
+	errInvalidVotingChain = errors.New("invalid voting chain")
+
+	// errUnauthorizedValidator is returned if a header is sealed or a consensus
+	// message is sent by a non-validator.
+	errUnauthorizedValidator = errors.New("unauthorized validator")
+
+	// errNotStarted is returned if a block is sealed before the engine is
+	// attached to a chain.
+	errNotStarted = errors.New("ibft engine not started")
+)
+
+// Chain is the blockchain the engine commits the agreed blocks into.
+type Chain interface {
+	consensus.ChainHeaderReader
+
+	// InsertChain inserts a batch of blocks into the chain.
+	InsertChain(chain types.Blocks) (int, error)
+
+	// StateAt returns the state with the given root.
+	StateAt(root common.Hash) (*state.StateDB, error)
+
+	// Processor returns the processor executing the blocks of the chain.
+	Processor() core.Processor
+
+	// Validator returns the validator checking the blocks of the chain.
+	Validator() core.Validator
+
+	// SubscribeChainHeadEvent subscribes to the chain head changes.
+	SubscribeChainHeadEvent(ch chan<- core.ChainHeadEvent) event.Subscription
+}
+
+// ecrecover extracts the proposer address from a sealed header.
+func ecrecover(header *types.Header, sigcache *lru.ARCCache) (common.Address, error) {
+	// If the signature's already cached, return that
+	hash := header.Hash()
+	if address, known := sigcache.Get(hash); known {
+		return address.(common.Address), nil
+	}
+	extra, err := ExtractExtra(header)
+	if err != nil {
+		return common.Address{}, err
+	}
+	if len(extra.Seal) != crypto.SignatureLength {
+		return common.Address{}, errMissingSignature
+	}
+	pubkey, err := crypto.SigToPub(SealHash(header).Bytes(), extra.Seal)
+	if err != nil {
+		return common.Address{}, err
+	}
+	proposer := crypto.PubkeyToAddress(*pubkey)
+
+	sigcache.Add(hash, proposer)
+	return proposer, nil
+}
+
+// IBFT is the Byzantine fault tolerant proof-of-authority consensus engine.
+type IBFT struct {
+	config *params.IBFTConfig // Consensus engine configuration parameters
+	db     mxtdb.Database     // Database to store and retrieve snapshot checkpoints
+
+	key     *ecdsa.PrivateKey // Key of the local validator, signing proposals and messages
+	address common.Address    // Address of the local validator
+
+	recents    *lru.ARCCache // Snapshots for recent block to speed up reorgs
+	signatures *lru.ARCCache // Signatures of recent blocks to speed up mining
+	seals      *lru.ARCCache // Committed seals of recent blocks, to embed into their children
+	sealReqs   *lru.Cache    // Times committed seals were last requested from peers, by block hash
+
+	proposals map[common.Address]bool // Current list of proposals we are pushing
+	lock      sync.RWMutex            // Protects the proposals, the chain and the core
+
+	chain Chain      // Chain the engine was started on, nil before
+	core  *bftCore   // Consensus state machine, running once started
+	peers *peerSet   // Peers running the consensus protocol
+	known *lru.Cache // Hashes of the consensus messages already processed
+}
+
+// New creates a IBFT consensus engine, taking part in the consensus as the
+// validator of the given key.
+func New(config *params.IBFTConfig, db mxtdb.Database, key *ecdsa.PrivateKey) *IBFT {
+	// Set any missing consensus parameters to their defaults
+	conf := *config
+	if conf.Epoch == 0 {
+		conf.Epoch = epochLength
+	}
+	if conf.RequestTimeout == 0 {
+		conf.RequestTimeout = requestTimeout
+	}
+	// Allocate the caches and create the engine
+	recents, _ := lru.NewARC(inmemorySnapshots)
+	signatures, _ := lru.NewARC(inmemorySignatures)
+	seals, _ := lru.NewARC(inmemorySeals)
+	sealReqs, _ := lru.New(inmemorySealReqs)
+	known, _ := lru.New(knownMessages)
+
+	engine := &IBFT{
+		config:     &conf,
+		db:         db,
+		key:        key,
+		recents:    recents,
+		signatures: signatures,
+		seals:      seals,
+		sealReqs:   sealReqs,
+		proposals:  make(map[common.Address]bool),
+		peers:      newPeerSet(),
+		known:      known,
+	}
+	if key != nil {
+		engine.address = crypto.PubkeyToAddress(key.PublicKey)
+	}
+	return engine
+}
+
+// Start attaches the engine to the chain and starts taking part in the
+// consensus. Blocks committed without the local node proposing them are
+// inserted into the chain directly.
+func (e *IBFT) Start(chain Chain) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.chain = chain
+	if e.core == nil && e.key != nil {
+		e.core = newCore(e, chain)
+	}
+}
+
+// Author implements consensus.Engine, returning the address of the validator
+// that proposed the block.
+func (e *IBFT) Author(header *types.Header) (common.Address, error) {
+	return ecrecover(header, e.signatures)
+}
+
+// VerifyHeader checks whmxter a header conforms to the consensus rules.
+func (e *IBFT) VerifyHeader(chain consensus.ChainHeaderReader, header *types.Header, seal bool) error {
+	return e.verifyHeader(chain, header, nil)
+}
+
+// VerifyHeaders is similar to VerifyHeader, but verifies a batch of headers. The
+// mmxtod returns a quit channel to abort the operations and a results channel to
+// retrieve the async verifications (the order is that of the input slice).
+func (e *IBFT) VerifyHeaders(chain consensus.ChainHeaderReader, headers []*types.Header, seals []bool) (chan<- struct{}, <-chan error) {
+	abort := make(chan struct{})
+	results := make(chan error, len(headers))
+
+	go func() {
+		for i, header := range headers {
+			err := e.verifyHeader(chain, header, headers[:i])
+
+			select {
+			case <-abort:
+				return
+			case results <- err:
+			}
+		}
+	}()
+	return abort, results
+}
+
+// verifyHeader checks whmxter a header conforms to the consensus rules. The
+// caller may optionally pass in a batch of parents (ascending order) to avoid
+// looking those up from the database.
+func (e *IBFT) verifyHeader(chain consensus.ChainHeaderReader, header *types.Header, parents []*types.Header) error {
+	if header.Number == nil {
+		return errUnknownBlock
+	}
+	number := header.Number.Uint64()
+
+	// Don't waste time checking blocks from the future
+	if header.Time > uint64(time.Now().Unix()) {
+		return consensus.ErrFutureBlock
+	}
+	// Checkpoint blocks need to enforce zero beneficiary
+	checkpoint := (number % e.config.Epoch) == 0
+	if checkpoint && header.Coinbase != (common.Address{}) {
+		return errInvalidCheckpointBeneficiary
+	}
+	// Nonces must be 0x00..0 or 0xff..f, zeroes enforced on checkpoints
+	if !bytes.Equal(header.Nonce[:], nonceAuthVote) && !bytes.Equal(header.Nonce[:], nonceDropVote) {
+		return errInvalidVote
+	}
+	if checkpoint && !bytes.Equal(header.Nonce[:], nonceDropVote) {
+		return errInvalidCheckpointVote
+	}
+	// Ensure that the extra-data is well formed, listing validators only on checkpoints
+	extra, err := ExtractExtra(header)
+	if err != nil {
+		return err
+	}
+	if !checkpoint && len(extra.Validators) != 0 {
+		return errExtraValidators
+	}
+	// Ensure that the mix digest is zero as we don't have fork protection currently
+	if header.MixDigest != (common.Hash{}) {
+		return errInvalidMixDigest
+	}
+	// Ensure that the block doesn't contain any uncles which are meaningless in BFT
+	if header.UncleHash != uncleHash {
+		return errInvalidUncleHash
+	}
+	if number > 0 && (header.Difficulty == nil || header.Difficulty.Cmp(defaultDifficulty) != 0) {
+		return errInvalidDifficulty
+	}
+	// If all checks passed, validate any special fields for hard forks
+	if err := misc.VerifyForkHashes(chain.Config(), header, false); err != nil {
+		return err
+	}
+	// All basic checks passed, verify cascading fields
+	return e.verifyCascadingFields(chain, header, extra, parents)
+}
+
+// verifyCascadingFields verifies all the header fields that are not standalone,
+// rather depend on a batch of previous headers.
+func (e *IBFT) verifyCascadingFields(chain consensus.ChainHeaderReader, header *types.Header, extra *Extra, parents []*types.Header) error {
+	// The genesis block is the always valid dead-end
+	number := header.Number.Uint64()
+	if number == 0 {
+		return nil
+	}
+	// Ensure that the block's timestamp isn't too close to its parent
+	var parent *types.Header
+	if len(parents) > 0 {
+		parent = parents[len(parents)-1]
+	} else {
+		parent = chain.GetHeader(header.ParentHash, number-1)
+	}
+	if parent == nil || parent.Number.Uint64() != number-1 || parent.Hash() != header.ParentHash {
+		return consensus.ErrUnknownAncestor
+	}
+	if parent.Time+e.config.Period > header.Time {
+		return errInvalidTimestamp
+	}
+	// Retrieve the snapshot needed to verify this header and cache it
+	snap, err := e.snapshot(chain, number-1, header.ParentHash, parents)
+	if err != nil {
+		return err
+	}
+	// If the block is a checkpoint block, verify the validator list
+	if number%e.config.Epoch == 0 {
+		validators := snap.validators()
+		if len(extra.Validators) != len(validators) {
+			return errMismatchingCheckpointValidators
+		}
+		for i, validator := range validators {
+			if extra.Validators[i] != validator {
+				return errMismatchingCheckpointValidators
+			}
+		}
+	}
+	// Ensure the parent was committed by a quorum of its validators
+	if number > 1 {
+		if len(parents) > 1 {
+			parents = parents[:len(parents)-1]
+		} else {
+			parents = nil
+		}
+		psnap, err := e.snapshot(chain, number-2, parent.ParentHash, parents)
+		if err != nil {
+			return err
+		}
+		if err := verifyCommittedSeals(psnap, parent.Hash(), extra.CommittedSeal); err != nil {
+			return err
+		}
+	}
+	// All basic checks passed, verify the seal and return
+	return e.verifySeal(snap, header)
+}
+
+// verifyCommittedSeals checks that a block was committed by a quorum of the
+// validators of the given snapshot.
+func verifyCommittedSeals(snap *Snapshot, hash common.Hash, seals [][]byte) error {
+	digest := commitHash(hash)
+
+	committers := make(map[common.Address]struct{})
+	for _, seal := range seals {
+		pubkey, err := crypto.SigToPub(digest, seal)
+		if err != nil {
+			return errInvalidCommittedSeals
+		}
+		committer := crypto.PubkeyToAddress(*pubkey)
+		if _, ok := snap.Validators[committer]; !ok {
+			return errInvalidCommittedSeals
+		}
+		committers[committer] = struct{}{}
+	}
+	if len(committers) < snap.quorum() {
+		return errInvalidCommittedSeals
+	}
+	return nil
+}
+
+// snapshot retrieves the validator snapshot at a given point in time.
+func (e *IBFT) snapshot(chain consensus.ChainHeaderReader, number uint64, hash common.Hash, parents []*types.Header) (*Snapshot, error) {
+	// Search for a snapshot in memory or on disk for checkpoints
+	var (
+		headers []*types.Header
+		snap    *Snapshot
+	)
+	for snap == nil {
+		// If an in-memory snapshot was found, use that
+		if s, ok := e.recents.Get(hash); ok {
+			snap = s.(*Snapshot)
+			break
+		}
+		// If an on-disk checkpoint snapshot can be found, use that
+		if number%checkpointInterval == 0 {
+			if s, err := loadSnapshot(e.config, e.signatures, e.db, hash); err == nil {
+				log.Trace("Loaded validator snapshot from disk", "number", number, "hash", hash)
+				snap = s
+				break
+			}
+		}
+		// If we're at the genesis, snapshot the initial state. Alternatively if
+		// we're at a checkpoint block without a parent (light client CHT), or we
+		// have piled up more headers than allowed to be reorged (chain reinit from
+		// a freezer), consider the checkpoint trusted and snapshot it.
+		if number == 0 || (number%e.config.Epoch == 0 && (len(headers) > params.FullImmutabilityThreshold || chain.GetHeaderByNumber(number-1) == nil)) {
+			checkpoint := chain.GetHeaderByNumber(number)
+			if checkpoint != nil {
+				extra, err := ExtractExtra(checkpoint)
+				if err != nil {
+					return nil, err
+				}
+				snap = newSnapshot(e.config, e.signatures, number, checkpoint.Hash(), extra.Validators)
+				if err := snap.store(e.db); err != nil {
+					return nil, err
+				}
+				log.Info("Stored checkpoint snapshot to disk", "number", number, "hash", snap.Hash)
+				break
+			}
+		}
+		// No snapshot for this header, gather the header and move backward
+		var header *types.Header
+		if len(parents) > 0 {
+			// If we have explicit parents, pick from there (enforced)
+			header = parents[len(parents)-1]
+			if header.Hash() != hash || header.Number.Uint64() != number {
+				return nil, consensus.ErrUnknownAncestor
+			}
+			parents = parents[:len(parents)-1]
+		} else {
+			// No explicit parents (or no more left), reach out to the database
+			header = chain.GetHeader(hash, number)
+			if header == nil {
+				return nil, consensus.ErrUnknownAncestor
+			}
+		}
+		headers = append(headers, header)
+		number, hash = number-1, header.ParentHash
+	}
+	// Previous snapshot found, apply any pending headers on top of it
+	for i := 0; i < len(headers)/2; i++ {
+		headers[i], headers[len(headers)-1-i] = headers[len(headers)-1-i], headers[i]
+	}
+	snap, err := snap.apply(headers)
+	if err != nil {
+		return nil, err
+	}
+	e.recents.Add(snap.Hash, snap)
+
+	// If we've generated a new checkpoint snapshot, save to disk
+	if snap.Number%checkpointInterval == 0 && len(headers) > 0 {
+		if err = snap.store(e.db); err != nil {
+			return nil, err
+		}
+		log.Trace("Stored validator snapshot to disk", "number", snap.Number, "hash", snap.Hash)
+	}
+	return snap, err
+}
+
+// sealsKey is the database key of the committed seals of a block.
+func sealsKey(hash common.Hash) []byte {
+	return append([]byte("ibft-seals-"), hash[:]...)
+}
+
+// committedSeals returns the committed seals of a block, if known locally.
+func (e *IBFT) committedSeals(hash common.Hash) ([][]byte, bool) {
+	if seals, ok := e.seals.Get(hash); ok {
+		return seals.([][]byte), true
+	}
+	blob, err := e.db.Get(sealsKey(hash))
+	if err != nil {
+		return nil, false
+	}
+	var seals [][]byte
+	if err := rlp.DecodeBytes(blob, &seals); err != nil {
+		log.Error("Invalid committed seals in database", "hash", hash, "err", err)
+		return nil, false
+	}
+	e.seals.Add(hash, seals)
+	return seals, true
+}
+
+// storeCommittedSeals saves the committed seals of a block, for them to survive
+// restarts until embedded into the child block. The seals of the parent are
+// embedded into the block, so they are deleted.
+func (e *IBFT) storeCommittedSeals(header *types.Header, seals [][]byte) {
+	hash := header.Hash()
+	e.seals.Add(hash, seals)
+
+	blob, err := rlp.EncodeToBytes(seals)
+	if err != nil {
+		log.Error("Failed to encode committed seals", "hash", hash, "err", err)
+		return
+	}
+	batch := e.db.NewBatch()
+	batch.Put(sealsKey(hash), blob)
+	batch.Delete(sealsKey(header.ParentHash))
+	if err := batch.Write(); err != nil {
+		log.Error("Failed to store committed seals", "hash", hash, "err", err)
+	}
+}
+
+// importCommittedSeals saves the committed seals of a block received from a peer,
+// if they were requested and a quorum of the validators deciding the block made
+// them.
+func (e *IBFT) importCommittedSeals(hash common.Hash, seals [][]byte) error {
+	if !e.sealReqs.Contains(hash) {
+		return errors.New("unrequested committed seals")
+	}
+	if _, ok := e.committedSeals(hash); ok {
+		return nil
+	}
+	e.lock.RLock()
+	chain := e.chain
+	e.lock.RUnlock()
+	if chain == nil {
+		return errNotStarted
+	}
+	header := chain.GetHeaderByHash(hash)
+	if header == nil || header.Number.Uint64() == 0 {
+		return errUnknownBlock
+	}
+	snap, err := e.snapshot(chain, header.Number.Uint64()-1, header.ParentHash, nil)
+	if err != nil {
+		return err
+	}
+	if err := verifyCommittedSeals(snap, hash, seals); err != nil {
+		return err
+	}
+	e.storeCommittedSeals(header, seals)
+	e.sealReqs.Remove(hash)
+
+	log.Debug("Recovered committed seals", "number", header.Number, "hash", hash)
+	return nil
+}
+
+// isValidator reports whmxter an address is a validator of the block following
+// the chain head, the ones consensus messages are accepted from.
+func (e *IBFT) isValidator(address common.Address) bool {
+	e.lock.RLock()
+	chain := e.chain
+	e.lock.RUnlock()
+	if chain == nil {
+		return false
+	}
+	head := chain.CurrentHeader()
+	snap, err := e.snapshot(chain, head.Number.Uint64(), head.Hash(), nil)
+	if err != nil {
+		return false
+	}
+	_, ok := snap.Validators[address]
+	return ok
+}
+
+// VerifyUncles implements consensus.Engine, always returning an error for any
+// uncles as this consensus mechanism doesn't permit uncles.
+func (e *IBFT) VerifyUncles(chain consensus.ChainReader, block *types.Block) error {
+	if len(block.Uncles()) > 0 {
+		return errors.New("uncles not allowed")
+	}
+	return nil
+}
+
+// VerifySeal implements consensus.Engine, checking whmxter the proposer seal
+// contained in the header satisfies the consensus protocol requirements.
+func (e *IBFT) VerifySeal(chain consensus.ChainHeaderReader, header *types.Header) error {
+	number := header.Number.Uint64()
+	if number == 0 {
+		return errUnknownBlock
+	}
+	snap, err := e.snapshot(chain, number-1, header.ParentHash, nil)
+	if err != nil {
+		return err
+	}
+	return e.verifySeal(snap, header)
+}
+
+// verifySeal checks that a header was sealed by a validator of the snapshot
+// preceding it.
+func (e *IBFT) verifySeal(snap *Snapshot, header *types.Header) error {
+	proposer, err := ecrecover(header, e.signatures)
+	if err != nil {
+		return err
+	}
+	if _, ok := snap.Validators[proposer]; !ok {
+		return errUnauthorizedValidator
+	}
+	return nil
+}
+
+// Prepare implements consensus.Engine, preparing all the consensus fields of the
+// header for running the transactions on top.
+func (e *IBFT) Prepare(chain consensus.ChainHeaderReader, header *types.Header) error {
+	// If the block isn't a checkpoint, cast a random vote (good enough for now)
+	header.Coinbase = common.Address{}
+	header.Nonce = types.BlockNonce{}
+
+	number := header.Number.Uint64()
+	snap, err := e.snapshot(chain, number-1, header.ParentHash, nil)
+	if err != nil {
+		return err
+	}
+	if number%e.config.Epoch != 0 {
+		e.lock.RLock()
+
+		// Gather all the proposals that make sense voting on
+		addresses := make([]common.Address, 0, len(e.proposals))
+		for address, authorize := range e.proposals {
+			if snap.validVote(address, authorize) {
+				addresses = append(addresses, address)
+			}
+		}
+		// If there's pending proposals, cast a vote on them
+		if len(addresses) > 0 {
+			header.Coinbase = addresses[rand.Intn(len(addresses))]
+			if e.proposals[header.Coinbase] {
+				copy(header.Nonce[:], nonceAuthVote)
+			} else {
+				copy(header.Nonce[:], nonceDropVote)
+			}
+		}
+		e.lock.RUnlock()
+	}
+	header.Difficulty = new(big.Int).Set(defaultDifficulty)
+
+	// Assemble the extra-data with the validators and the seals of the parent
+	extra := new(Extra)
+	if number%e.config.Epoch == 0 {
+		extra.Validators = snap.validators()
+	}
+	if number > 1 {
+		seals, ok := e.committedSeals(header.ParentHash)
+		if !ok {
+			e.requestSeals(header.ParentHash)
+			return errMissingCommittedSeals
+		}
+		extra.CommittedSeal = seals
+	}
+	if len(header.Extra) < extraVanity {
+		header.Extra = append(header.Extra, bytes.Repeat([]byte{0x00}, extraVanity-len(header.Extra))...)
+	}
+	if header.Extra, err = encodeExtra(header.Extra, extra); err != nil {
+		return err
+	}
+	// Mix digest is reserved for now, set to empty
+	header.MixDigest = common.Hash{}
+
+	// Ensure the timestamp has the correct delay
+	parent := chain.GetHeader(header.ParentHash, number-1)
+	if parent == nil {
+		return consensus.ErrUnknownAncestor
+	}
+	header.Time = parent.Time + e.config.Period
+	if header.Time < uint64(time.Now().Unix()) {
+		header.Time = uint64(time.Now().Unix())
+	}
+	return nil
+}
+
+// Finalize implements consensus.Engine, ensuring no uncles are set, nor block
+// rewards given.
+func (e *IBFT) Finalize(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header) {
+	header.Root = state.IntermediateRoot(chain.Config().IsEIP158(header.Number))
+	header.UncleHash = types.CalcUncleHash(nil)
+}
+
+// FinalizeAndAssemble implements consensus.Engine, ensuring no uncles are set,
+// nor block rewards given, and returns the final block.
+func (e *IBFT) FinalizeAndAssemble(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header, receipts []*types.Receipt) (*types.Block, error) {
+	header.Root = state.IntermediateRoot(chain.Config().IsEIP158(header.Number))
+	header.UncleHash = types.CalcUncleHash(nil)
+
+	// Assemble and return the final block for sealing
+	return types.NewBlock(header, txs, nil, receipts, new(trie.Trie)), nil
+}
+
+// Seal implements consensus.Engine, signing the block as its proposer and
+// handing it to the consensus as the local candidate for its height. The block
+// is returned on the results channel only if it gets committed.
+func (e *IBFT) Seal(chain consensus.ChainHeaderReader, block *types.Block, results chan<- *types.Block, stop <-chan struct{}) error {
+	header := block.Header()
+
+	// Sealing the genesis block is not supported
+	number := header.Number.Uint64()
+	if number == 0 {
+		return errUnknownBlock
+	}
+	e.lock.RLock()
+	bft := e.core
+	e.lock.RUnlock()
+	if bft == nil {
+		return errNotStarted
+	}
+	// Bail out if we're not a validator
+	snap, err := e.snapshot(chain, number-1, header.ParentHash, nil)
+	if err != nil {
+		return err
+	}
+	if _, ok := snap.Validators[e.address]; !ok {
+		return errUnauthorizedValidator
+	}
+	// Sign the block and hand it to the consensus
+	extra, err := ExtractExtra(header)
+	if err != nil {
+		return err
+	}
+	if extra.Seal, err = crypto.Sign(SealHash(header).Bytes(), e.key); err != nil {
+		return err
+	}
+	if header.Extra, err = encodeExtra(header.Extra, extra); err != nil {
+		return err
+	}
+	// Wait until the block period elapsed before proposing
+	delay := time.Unix(int64(header.Time), 0).Sub(time.Now()) // nolint: gosimple
+	go func() {
+		select {
+		case <-stop:
+			return
+		case <-time.After(delay):
+		}
+		bft.propose(block.WithSeal(header), results, stop)
+	}()
+	return nil
+}
+
+// SealHash returns the hash of a block prior to it being sealed.
+func (e *IBFT) SealHash(header *types.Header) common.Hash {
+	return SealHash(header)
+}
+
+// CalcDifficulty is the difficulty adjustment algorithm, always returning 1 as
+// committed blocks are final.
+func (e *IBFT) CalcDifficulty(chain consensus.ChainHeaderReader, time uint64, parent *types.Header) *big.Int {
+	return new(big.Int).Set(defaultDifficulty)
+}
+
+// FinalizedHeader implements consensus.Finality, returning the newest block
+// known to be committed by a quorum of validators.
+func (e *IBFT) FinalizedHeader(chain consensus.ChainHeaderReader) *types.Header {
+	return e.committedHead(chain)
+}
+
+// SafeHeader implements consensus.Finality, returning the newest block known to
+// be committed by a quorum of validators, as every committed block is final.
+func (e *IBFT) SafeHeader(chain consensus.ChainHeaderReader) *types.Header {
+	return e.committedHead(chain)
+}
+
+// committedHead returns the chain head if the local node holds its committed
+// seals, otherwise its parent, whose seals are embedded into the head and were
+// verified on import.
+func (e *IBFT) committedHead(chain consensus.ChainHeaderReader) *types.Header {
+	head := chain.CurrentHeader()
+	if head == nil || head.Number.Uint64() == 0 {
+		return head
+	}
+	if _, ok := e.committedSeals(head.Hash()); ok {
+		return head
+	}
+	return chain.GetHeader(head.ParentHash, head.Number.Uint64()-1)
+}
+
+// Close implements consensus.Engine, stopping the consensus.
+func (e *IBFT) Close() error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.core != nil {
+		e.core.close()
+		e.core = nil
+	}
+	return nil
+}
+
+// APIs implements consensus.Engine, returning the user facing RPC API to allow
+// controlling the validator voting.
+func (e *IBFT) APIs(chain consensus.ChainHeaderReader) []rpc.API {
+	return []rpc.API{{
+		Namespace: "ibft",
+		Version:   "1.0",
+		Service:   &API{chain: chain, ibft: e},
+		Public:    false,
+	}}
+}
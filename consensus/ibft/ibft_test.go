@@ -0,0 +1,426 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package ibft
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/core"
+	"github.com/mxt/go-mxt/core/rawdb"
+	"github.com/mxt/go-mxt/core/types"
+	"github.com/mxt/go-mxt/core/vm"
+	"github.com/mxt/go-mxt/crypto"
+	"github.com/mxt/go-mxt/node"
+	"github.com/mxt/go-mxt/p2p/enode"
+	"github.com/mxt/go-mxt/p2p/simulations"
+	"github.com/mxt/go-mxt/p2p/simulations/adapters"
+	"github.com/mxt/go-mxt/params"
+)
+
+// testValidator is a simulated node running the consensus over an in-memory
+// chain, proposing empty blocks on top of its chain head.
+type testValidator struct {
+	engine *IBFT
+	chain  *core.BlockChain
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+func (v *testValidator) Start() error {
+	v.engine.Start(v.chain)
+
+	v.wg.Add(1)
+	go v.loop()
+	return nil
+}
+
+func (v *testValidator) Stop() error {
+	close(v.quit)
+	v.wg.Wait()
+
+	v.engine.Close()
+	v.chain.Stop()
+	return nil
+}
+
+// loop is a minimal miner, sealing a new block whenever the chain head moves
+// and inserting it if committed.
+func (v *testValidator) loop() {
+	defer v.wg.Done()
+
+	headCh := make(chan core.ChainHeadEvent, 16)
+	sub := v.chain.SubscribeChainHeadEvent(headCh)
+	defer sub.Unsubscribe()
+
+	results := make(chan *types.Block, 1)
+	for {
+		stop := make(chan struct{})
+		v.seal(results, stop)
+
+		select {
+		case block := <-results:
+			v.chain.InsertChain(types.Blocks{block})
+		case <-headCh:
+		case <-v.quit:
+			close(stop)
+			return
+		}
+		close(stop)
+	}
+}
+
+// seal assembles an empty block on top of the chain head and hands it to the
+// consensus.
+func (v *testValidator) seal(results chan *types.Block, stop chan struct{}) {
+	parent := v.chain.CurrentBlock()
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Number:     new(big.Int).Add(parent.Number(), common.Big1),
+		GasLimit:   parent.GasLimit(),
+		Time:       parent.Time(),
+	}
+	if err := v.engine.Prepare(v.chain, header); err != nil {
+		return
+	}
+	statedb, err := v.chain.StateAt(parent.Root())
+	if err != nil {
+		return
+	}
+	block, err := v.engine.FinalizeAndAssemble(v.chain, header, statedb, nil, nil, nil)
+	if err != nil {
+		return
+	}
+	v.engine.Seal(v.chain, block, results, stop)
+}
+
+// newTestNetwork creates a simulated network of validators, starting only the
+// given number of them. All the started validators are connected to each other.
+func newTestNetwork(t *testing.T, validators, online int, timeout uint64) (*simulations.Network, map[enode.ID]*testValidator) {
+	confs := make([]*adapters.NodeConfig, validators)
+	for i := range confs {
+		confs[i] = adapters.RandomNodeConfig()
+	}
+	addrs := make([]common.Address, validators)
+	for i, conf := range confs {
+		addrs[i] = crypto.PubkeyToAddress(conf.PrivateKey.PublicKey)
+	}
+	sort.Sort(validatorsAscending(addrs))
+
+	extra, err := encodeExtra(make([]byte, extraVanity), &Extra{Validators: addrs})
+	if err != nil {
+		t.Fatalf("failed to encode genesis extra-data: %v", err)
+	}
+	config := *params.AllEthashProtocolChanges
+	config.Ethash = nil
+	config.IBFT = &params.IBFTConfig{Epoch: 30000, RequestTimeout: timeout}
+
+	var (
+		lock  sync.Mutex
+		nodes = make(map[enode.ID]*testValidator)
+	)
+	adapter := adapters.NewSimAdapter(adapters.LifecycleConstructors{
+		"ibft": func(ctx *adapters.ServiceContext, stack *node.Node) (node.Lifecycle, error) {
+			db := rawdb.NewMemoryDatabase()
+			genesis := &core.Genesis{Config: &config, ExtraData: extra, GasLimit: 8000000, Difficulty: big.NewInt(1)}
+			genesis.MustCommit(db)
+
+			engine := New(config.IBFT, db, ctx.Config.PrivateKey)
+			chain, err := core.NewBlockChain(db, nil, &config, engine, vm.Config{}, nil, nil)
+			if err != nil {
+				return nil, err
+			}
+			v := &testValidator{engine: engine, chain: chain, quit: make(chan struct{})}
+			stack.RegisterProtocols(engine.Protocols())
+
+			lock.Lock()
+			nodes[ctx.Config.ID] = v
+			lock.Unlock()
+			return v, nil
+		},
+	})
+	network := simulations.NewNetwork(adapter, &simulations.NetworkConfig{DefaultService: "ibft"})
+
+	var ids []enode.ID
+	for i, conf := range confs {
+		if _, err := network.NewNodeWithConfig(conf); err != nil {
+			t.Fatalf("failed to create node %d: %v", i, err)
+		}
+		if i < online {
+			if err := network.Start(conf.ID); err != nil {
+				t.Fatalf("failed to start node %d: %v", i, err)
+			}
+			ids = append(ids, conf.ID)
+		}
+	}
+	for i := range ids {
+		for j := i + 1; j < len(ids); j++ {
+			if err := network.Connect(ids[i], ids[j]); err != nil {
+				t.Fatalf("failed to connect nodes %d and %d: %v", i, j, err)
+			}
+		}
+	}
+	return network, nodes
+}
+
+// waitAgreement waits until all the validators committed the given number of
+// blocks, and checks that they committed the same ones.
+func waitAgreement(nodes map[enode.ID]*testValidator, number uint64, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		done := true
+		for _, v := range nodes {
+			if v.chain.CurrentBlock().NumberU64() < number {
+				done = false
+			}
+		}
+		if done {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("validators failed to reach block %d", number)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	var want common.Hash
+	for id, v := range nodes {
+		block := v.chain.GetBlockByNumber(number)
+		if want == (common.Hash{}) {
+			want = block.Hash()
+		}
+		if block.Hash() != want {
+			return fmt.Errorf("validator %s forked at block %d: have %x, want %x", id.TerminalString(), number, block.Hash(), want)
+		}
+	}
+	return nil
+}
+
+// Tests that a network of validators commits the same blocks.
+func TestConsensus(t *testing.T) {
+	network, nodes := newTestNetwork(t, 4, 4, 5000)
+	defer network.Shutdown()
+
+	if err := waitAgreement(nodes, 5, 30*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	// Every block must carry the committed seals of its parent
+	for _, v := range nodes {
+		for number := uint64(2); number <= 5; number++ {
+			header := v.chain.GetHeaderByNumber(number)
+			if err := v.engine.VerifyHeader(v.chain, header, true); err != nil {
+				t.Fatalf("block %d: invalid header: %v", number, err)
+			}
+		}
+	}
+}
+
+// Tests that the validators keep committing blocks through round changes if a
+// validator is offline, as long as a quorum is online.
+func TestConsensusRoundChange(t *testing.T) {
+	network, nodes := newTestNetwork(t, 4, 3, 500)
+	defer network.Shutdown()
+
+	// The offline validator is the proposer of one in every four heights
+	if err := waitAgreement(nodes, 5, 60*time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Tests that validators persist the committed seals of their chain head and
+// recover them from their peers if lost.
+func TestCommittedSealsRecovery(t *testing.T) {
+	network, nodes := newTestNetwork(t, 4, 4, 5000)
+	defer network.Shutdown()
+
+	if err := waitAgreement(nodes, 3, 30*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	// Halt the chain by taking two validators offline
+	var online []*testValidator
+	for id, v := range nodes {
+		if len(online) == 2 {
+			if err := network.Stop(id); err != nil {
+				t.Fatalf("failed to stop validator: %v", err)
+			}
+			continue
+		}
+		online = append(online, v)
+	}
+	time.Sleep(time.Second)
+
+	// Drop the seals of the head from the validator lagging behind, if any
+	v := online[0]
+	if online[1].chain.CurrentBlock().NumberU64() < v.chain.CurrentBlock().NumberU64() {
+		v = online[1]
+	}
+	head := v.chain.CurrentBlock()
+	hash := head.Hash()
+	if final := v.engine.FinalizedHeader(v.chain); final.Hash() != hash {
+		t.Fatalf("finalized header mismatch: have %d, want head %d", final.Number, head.Number())
+	}
+	v.engine.seals.Remove(hash)
+	if err := v.engine.db.Delete(sealsKey(hash)); err != nil {
+		t.Fatalf("failed to delete committed seals: %v", err)
+	}
+	if _, ok := v.engine.committedSeals(hash); ok {
+		t.Fatal("committed seals not deleted")
+	}
+	// Without the seals of the head only its parent is known to be committed
+	if final := v.engine.FinalizedHeader(v.chain); final.Hash() != head.ParentHash() {
+		t.Fatalf("finalized header mismatch: have %d, want parent of head %d", final.Number, head.Number())
+	}
+	if safe := v.engine.SafeHeader(v.chain); safe.Hash() != head.ParentHash() {
+		t.Fatalf("safe header mismatch: have %d, want parent of head %d", safe.Number, head.Number())
+	}
+	v.engine.requestSeals(hash)
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		if _, ok := v.engine.committedSeals(hash); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("committed seals not recovered")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	// The recovered seals must survive a restart
+	if _, ok := New(v.engine.config, v.engine.db, nil).committedSeals(hash); !ok {
+		t.Fatal("recovered committed seals not persisted")
+	}
+}
+
+// Tests that the consensus drops the messages of non-validators and of rounds
+// too far ahead instead of keeping them around.
+func TestCoreMessageLimits(t *testing.T) {
+	var (
+		key, _    = crypto.GenerateKey()
+		other, _  = crypto.GenerateKey()
+		validator = crypto.PubkeyToAddress(key.PublicKey)
+		outsider  = crypto.PubkeyToAddress(other.PublicKey)
+	)
+	engine := New(&params.IBFTConfig{}, rawdb.NewMemoryDatabase(), key)
+	c := &bftCore{
+		engine: engine,
+		snap:   newSnapshot(engine.config, engine.signatures, 0, common.Hash{}, []common.Address{validator}),
+		height: 1,
+		rounds: make(map[uint64]*roundState),
+	}
+	// Messages of non-validators are not backlogged
+	c.handle(&message{Code: msgPrepare, Height: 2, sender: outsider})
+	if len(c.backlog) != 0 {
+		t.Fatalf("backlogged message of non-validator")
+	}
+	c.handle(&message{Code: msgPrepare, Height: 2, sender: validator})
+	if len(c.backlog) != 1 {
+		t.Fatalf("message of validator not backlogged")
+	}
+	// Messages of far future rounds don't allocate round states
+	c.handle(&message{Code: msgRoundChange, Height: 1, Round: maxFutureRounds + 1, sender: validator})
+	c.handle(&message{Code: msgPrepare, Height: 1, Round: 1 << 40, sender: validator})
+	if len(c.rounds) != 0 {
+		t.Fatalf("round states allocated for far future rounds: %d", len(c.rounds))
+	}
+	c.handle(&message{Code: msgPrepare, Height: 1, Round: maxFutureRounds, sender: validator})
+	if len(c.rounds) != 1 {
+		t.Fatalf("round state not allocated for near future round")
+	}
+}
+
+// Tests that validators only accept proposals whose execution matches their
+// header, and that a height stays open if its committed block fails to import.
+func TestCoreProposalExecution(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	validator := crypto.PubkeyToAddress(key.PublicKey)
+
+	extra, err := encodeExtra(make([]byte, extraVanity), &Extra{Validators: []common.Address{validator}})
+	if err != nil {
+		t.Fatalf("failed to encode genesis extra-data: %v", err)
+	}
+	config := *params.AllEthashProtocolChanges
+	config.Ethash = nil
+	config.IBFT = &params.IBFTConfig{Epoch: 30000, RequestTimeout: 5000}
+
+	db := rawdb.NewMemoryDatabase()
+	genesis := (&core.Genesis{Config: &config, ExtraData: extra, GasLimit: 8000000, Difficulty: big.NewInt(1)}).MustCommit(db)
+	engine := New(config.IBFT, db, key)
+	chain, err := core.NewBlockChain(db, nil, &config, engine, vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer chain.Stop()
+
+	snap, err := engine.snapshot(chain, 0, genesis.Hash(), nil)
+	if err != nil {
+		t.Fatalf("failed to retrieve validators: %v", err)
+	}
+	c := &bftCore{
+		engine:    engine,
+		chain:     chain,
+		snap:      snap,
+		height:    1,
+		rounds:    make(map[uint64]*roundState),
+		commits:   make(map[common.Hash][][]byte),
+		committed: make(map[common.Hash]map[common.Address]struct{}),
+		timer:     time.NewTimer(time.Hour),
+	}
+	defer c.timer.Stop()
+
+	// propose assembles and signs a block on top of genesis with the given state root
+	propose := func(root *common.Hash) *types.Block {
+		header := &types.Header{
+			ParentHash: genesis.Hash(),
+			Number:     common.Big1,
+			GasLimit:   genesis.GasLimit(),
+			Time:       genesis.Time(),
+		}
+		if err := engine.Prepare(chain, header); err != nil {
+			t.Fatalf("failed to prepare header: %v", err)
+		}
+		statedb, _ := chain.StateAt(genesis.Root())
+		block, err := engine.FinalizeAndAssemble(chain, header, statedb, nil, nil, nil)
+		if err != nil {
+			t.Fatalf("failed to assemble block: %v", err)
+		}
+		header = block.Header()
+		if root != nil {
+			header.Root = *root
+		}
+		extra, _ := ExtractExtra(header)
+		extra.Seal, _ = crypto.Sign(SealHash(header).Bytes(), key)
+		header.Extra, _ = encodeExtra(header.Extra, extra)
+		return block.WithSeal(header)
+	}
+	valid, invalid := propose(nil), propose(&common.Hash{0x01})
+	if err := c.verifyProposal(valid); err != nil {
+		t.Fatalf("valid proposal rejected: %v", err)
+	}
+	if err := c.verifyProposal(invalid); err == nil {
+		t.Fatal("proposal with invalid state root accepted")
+	}
+	// A committed block failing to import reopens the height
+	c.done, c.locked = true, invalid
+	c.commits[invalid.Hash()] = [][]byte{nil}
+	c.reopen(invalid)
+	if c.done || c.locked != nil || len(c.commits) != 0 {
+		t.Fatalf("height not reopened: done %t, locked %v, commits %d", c.done, c.locked != nil, len(c.commits))
+	}
+}
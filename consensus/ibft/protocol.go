@@ -0,0 +1,230 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package ibft
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/crypto"
+	"github.com/mxt/go-mxt/log"
+	"github.com/mxt/go-mxt/p2p"
+	"github.com/mxt/go-mxt/p2p/enode"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+const (
+	protocolName    = "ibft"           // Name of the consensus sub-protocol
+	protocolVersion = 1                // Version of the consensus sub-protocol
+	protocolLength  = 3                // Number of message codes of the consensus sub-protocol
+	maxMessageSize  = 10 * 1024 * 1024 // Maximum size of a consensus message
+
+	knownMessages = 4096 // Number of message hashes to remember, globally and per peer
+
+	sealRequestInterval = time.Second // Minimum time between two requests for the same committed seals
+)
+
+// Consensus sub-protocol message codes.
+const (
+	consensusMsg = 0x00 // Message code carrying a consensus message
+	getSealsMsg  = 0x01 // Request for the committed seals of a block
+	sealsMsg     = 0x02 // Committed seals of a block
+)
+
+// sealsData is the network packet carrying the committed seals of a block.
+type sealsData struct {
+	Hash  common.Hash
+	Seals [][]byte
+}
+
+// peer is a remote node running the consensus protocol.
+type peer struct {
+	*p2p.Peer
+	rw    p2p.MsgReadWriter
+	known *lru.Cache // Hashes of the messages known to the peer
+}
+
+// peerSet is the set of peers running the consensus protocol.
+type peerSet struct {
+	peers map[enode.ID]*peer
+	lock  sync.RWMutex
+}
+
+func newPeerSet() *peerSet {
+	return &peerSet{peers: make(map[enode.ID]*peer)}
+}
+
+// broadcast sends a consensus message to all the peers not knowing it yet.
+func (ps *peerSet) broadcast(hash common.Hash, payload []byte) {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	for _, p := range ps.peers {
+		if p.known.Contains(hash) {
+			continue
+		}
+		p.known.Add(hash, struct{}{})
+		go func(p *peer) {
+			if err := p2p.Send(p.rw, consensusMsg, payload); err != nil {
+				p.Log().Trace("Failed to send consensus message", "err", err)
+			}
+		}(p)
+	}
+}
+
+// send sends a message to all the peers.
+func (ps *peerSet) send(code uint64, data interface{}) {
+	ps.lock.RLock()
+	defer ps.lock.RUnlock()
+
+	for _, p := range ps.peers {
+		go func(p *peer) {
+			if err := p2p.Send(p.rw, code, data); err != nil {
+				p.Log().Trace("Failed to send consensus message", "code", code, "err", err)
+			}
+		}(p)
+	}
+}
+
+// requestSeals asks the peers for the committed seals of a block, unless they
+// were asked recently.
+func (e *IBFT) requestSeals(hash common.Hash) {
+	if last, ok := e.sealReqs.Get(hash); ok && time.Since(last.(time.Time)) < sealRequestInterval {
+		return
+	}
+	e.sealReqs.Add(hash, time.Now())
+	e.peers.send(getSealsMsg, hash)
+}
+
+// Protocols returns the p2p sub-protocol validators exchange the consensus
+// messages over.
+func (e *IBFT) Protocols() []p2p.Protocol {
+	return []p2p.Protocol{{
+		Name:    protocolName,
+		Version: protocolVersion,
+		Length:  protocolLength,
+		Run:     e.runPeer,
+		NodeInfo: func() interface{} {
+			return map[string]interface{}{"validator": e.address}
+		},
+	}}
+}
+
+// runPeer relays the consensus messages of a peer to the consensus and to the
+// other peers, until the connection is dropped.
+func (e *IBFT) runPeer(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+	known, _ := lru.New(knownMessages)
+	remote := &peer{Peer: p, rw: rw, known: known}
+
+	e.peers.lock.Lock()
+	e.peers.peers[p.ID()] = remote
+	e.peers.lock.Unlock()
+
+	defer func() {
+		e.peers.lock.Lock()
+		delete(e.peers.peers, p.ID())
+		e.peers.lock.Unlock()
+	}()
+	p.Log().Debug("Consensus peer connected")
+
+	// Recover the committed seals of the chain head if they were lost
+	e.lock.RLock()
+	chain := e.chain
+	e.lock.RUnlock()
+	if chain != nil {
+		if head := chain.CurrentHeader(); head.Number.Uint64() > 0 {
+			if _, ok := e.committedSeals(head.Hash()); !ok {
+				e.sealReqs.Add(head.Hash(), time.Now())
+				go p2p.Send(rw, getSealsMsg, head.Hash())
+			}
+		}
+	}
+	for {
+		msg, err := rw.ReadMsg()
+		if err != nil {
+			return err
+		}
+		if msg.Size > maxMessageSize {
+			msg.Discard()
+			return fmt.Errorf("consensus message too large: %v > %v", msg.Size, maxMessageSize)
+		}
+		switch msg.Code {
+		case consensusMsg:
+			var payload []byte
+			if err := msg.Decode(&payload); err != nil {
+				return fmt.Errorf("invalid consensus message: %v", err)
+			}
+			e.handleConsensusMsg(remote, payload)
+
+		case getSealsMsg:
+			var hash common.Hash
+			if err := msg.Decode(&hash); err != nil {
+				return fmt.Errorf("invalid committed seals request: %v", err)
+			}
+			if seals, ok := e.committedSeals(hash); ok {
+				if err := p2p.Send(rw, sealsMsg, &sealsData{Hash: hash, Seals: seals}); err != nil {
+					return err
+				}
+			}
+
+		case sealsMsg:
+			var data sealsData
+			if err := msg.Decode(&data); err != nil {
+				return fmt.Errorf("invalid committed seals: %v", err)
+			}
+			if err := e.importCommittedSeals(data.Hash, data.Seals); err != nil {
+				p.Log().Debug("Dropping committed seals", "hash", data.Hash, "err", err)
+			}
+
+		default:
+			return fmt.Errorf("invalid consensus protocol message code %d", msg.Code)
+		}
+	}
+}
+
+// handleConsensusMsg hands a consensus message received from a peer to the
+// consensus and gossips it to the other peers. Messages not sent by validators
+// are dropped.
+func (e *IBFT) handleConsensusMsg(remote *peer, payload []byte) {
+	hash := crypto.Keccak256Hash(payload)
+	remote.known.Add(hash, struct{}{})
+	if e.known.Contains(hash) {
+		return
+	}
+	e.known.Add(hash, struct{}{})
+
+	cmsg, err := decodeMessage(payload)
+	if err != nil {
+		log.Debug("Dropping invalid consensus message", "peer", remote.ID(), "err", err)
+		return
+	}
+	if !e.isValidator(cmsg.sender) {
+		log.Trace("Dropping consensus message from non-validator", "peer", remote.ID(), "sender", cmsg.sender)
+		return
+	}
+	// Gossip the message for validators not directly connected to the sender
+	e.peers.broadcast(hash, payload)
+
+	e.lock.RLock()
+	bft := e.core
+	e.lock.RUnlock()
+	if bft != nil {
+		bft.deliver(cmsg)
+	}
+}
@@ -0,0 +1,234 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package ibft
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"sort"
+	"testing"
+
+	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/core/types"
+	"github.com/mxt/go-mxt/crypto"
+	"github.com/mxt/go-mxt/params"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// testVote is a single validator set change proposed in a block.
+type testVote struct {
+	proposer int  // Index of the validator proposing the block
+	voted    int  // Index of the account voted on
+	auth     bool // Whmxter to authorize or deauthorize the voted account
+}
+
+// newTestKeys generates a batch of keys, sorted by their address.
+func newTestKeys(n int) ([]*ecdsa.PrivateKey, []common.Address) {
+	keys := make([]*ecdsa.PrivateKey, n)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := crypto.PubkeyToAddress(keys[i].PublicKey), crypto.PubkeyToAddress(keys[j].PublicKey)
+		return validatorsAscending{a, b}.Less(0, 1)
+	})
+	addrs := make([]common.Address, n)
+	for i, key := range keys {
+		addrs[i] = crypto.PubkeyToAddress(key.PublicKey)
+	}
+	return keys, addrs
+}
+
+// sealTestHeader signs a header with the given validator key.
+func sealTestHeader(t *testing.T, header *types.Header, key *ecdsa.PrivateKey) {
+	extra, err := encodeExtra(make([]byte, extraVanity), new(Extra))
+	if err != nil {
+		t.Fatalf("failed to encode extra-data: %v", err)
+	}
+	header.Extra = extra
+
+	sig, err := crypto.Sign(SealHash(header).Bytes(), key)
+	if err != nil {
+		t.Fatalf("failed to sign header: %v", err)
+	}
+	if header.Extra, err = encodeExtra(header.Extra, &Extra{Seal: sig}); err != nil {
+		t.Fatalf("failed to encode extra-data: %v", err)
+	}
+}
+
+// Tests that validator votes are tallied, passing with a majority of the
+// validator set, for both adding and removing validators.
+func TestValidatorVoting(t *testing.T) {
+	keys, addrs := newTestKeys(5)
+
+	tests := []struct {
+		validators int        // Number of genesis validators, the first ones of the keys
+		votes      []testVote // Votes cast in consecutive blocks
+		results    []int      // Indexes of the final validators
+	}{
+		{
+			// Single validator, adding another passes instantly
+			validators: 1,
+			votes:      []testVote{{0, 1, true}},
+			results:    []int{0, 1},
+		}, {
+			// Two validators, adding a third needs both votes
+			validators: 2,
+			votes:      []testVote{{0, 2, true}},
+			results:    []int{0, 1},
+		}, {
+			validators: 2,
+			votes:      []testVote{{0, 2, true}, {1, 2, true}},
+			results:    []int{0, 1, 2},
+		}, {
+			// Duplicate votes of a validator count once
+			validators: 3,
+			votes:      []testVote{{0, 3, true}, {0, 3, true}, {0, 3, true}},
+			results:    []int{0, 1, 2},
+		}, {
+			// Removing a validator needs a majority, counting the removed one
+			validators: 4,
+			votes:      []testVote{{0, 3, false}, {1, 3, false}},
+			results:    []int{0, 1, 2, 3},
+		}, {
+			validators: 4,
+			votes:      []testVote{{0, 3, false}, {1, 3, false}, {2, 3, false}},
+			results:    []int{0, 1, 2},
+		}, {
+			// Votes of a removed validator are discarded
+			validators: 4,
+			votes:      []testVote{{3, 4, true}, {0, 3, false}, {1, 3, false}, {2, 3, false}, {0, 4, true}},
+			results:    []int{0, 1, 2},
+		},
+	}
+	for i, tt := range tests {
+		config := &params.IBFTConfig{Epoch: epochLength}
+		sigcache, _ := lru.NewARC(inmemorySignatures)
+
+		snap := newSnapshot(config, sigcache, 0, common.Hash{}, addrs[:tt.validators])
+		headers := make([]*types.Header, len(tt.votes))
+		for j, vote := range tt.votes {
+			headers[j] = &types.Header{
+				Number:   big.NewInt(int64(j) + 1),
+				Coinbase: addrs[vote.voted],
+			}
+			if vote.auth {
+				copy(headers[j].Nonce[:], nonceAuthVote)
+			}
+			sealTestHeader(t, headers[j], keys[vote.proposer])
+		}
+		result, err := snap.apply(headers)
+		if err != nil {
+			t.Errorf("test %d: failed to apply votes: %v", i, err)
+			continue
+		}
+		want := make([]common.Address, len(tt.results))
+		for j, index := range tt.results {
+			want[j] = addrs[index]
+		}
+		have := result.validators()
+		if len(have) != len(want) {
+			t.Errorf("test %d: validator count mismatch: have %d, want %d", i, len(have), len(want))
+			continue
+		}
+		for j := range have {
+			if have[j] != want[j] {
+				t.Errorf("test %d, validator %d: validator mismatch: have %x, want %x", i, j, have[j], want[j])
+			}
+		}
+	}
+}
+
+// Tests that blocks proposed by accounts outside the validator set are rejected.
+func TestUnauthorizedProposer(t *testing.T) {
+	keys, addrs := newTestKeys(2)
+	sigcache, _ := lru.NewARC(inmemorySignatures)
+
+	snap := newSnapshot(&params.IBFTConfig{Epoch: epochLength}, sigcache, 0, common.Hash{}, addrs[:1])
+	header := &types.Header{Number: big.NewInt(1)}
+	sealTestHeader(t, header, keys[1])
+
+	if _, err := snap.apply([]*types.Header{header}); err != errUnauthorizedValidator {
+		t.Fatalf("error mismatch: have %v, want %v", err, errUnauthorizedValidator)
+	}
+}
+
+// Tests the fault tolerance thresholds and the proposer rotation.
+func TestQuorum(t *testing.T) {
+	tests := []struct {
+		validators int
+		faulty     int
+		quorum     int
+	}{
+		{1, 0, 1}, {2, 0, 2}, {3, 0, 2}, {4, 1, 3}, {5, 1, 4}, {6, 1, 4}, {7, 2, 5}, {10, 3, 7},
+	}
+	for _, tt := range tests {
+		_, addrs := newTestKeys(tt.validators)
+		snap := newSnapshot(&params.IBFTConfig{}, nil, 0, common.Hash{}, addrs)
+
+		if faulty := snap.faulty(); faulty != tt.faulty {
+			t.Errorf("validators %d: faulty mismatch: have %d, want %d", tt.validators, faulty, tt.faulty)
+		}
+		if quorum := snap.quorum(); quorum != tt.quorum {
+			t.Errorf("validators %d: quorum mismatch: have %d, want %d", tt.validators, quorum, tt.quorum)
+		}
+		// Any two quorums must overlap in at least one honest validator
+		if 2*snap.quorum()-tt.validators <= snap.faulty() {
+			t.Errorf("validators %d: quorums don't intersect in an honest validator", tt.validators)
+		}
+		// Every validator must propose once in consecutive rounds
+		seen := make(map[common.Address]bool)
+		for round := 0; round < tt.validators; round++ {
+			seen[snap.proposer(uint64(round))] = true
+		}
+		if len(seen) != tt.validators {
+			t.Errorf("validators %d: proposers mismatch: have %d, want %d", tt.validators, len(seen), tt.validators)
+		}
+	}
+}
+
+// Tests that the committed seals are checked against the validator set and
+// the quorum.
+func TestVerifyCommittedSeals(t *testing.T) {
+	keys, addrs := newTestKeys(5)
+	snap := newSnapshot(&params.IBFTConfig{}, nil, 0, common.Hash{}, addrs[:4])
+
+	hash := common.HexToHash("0xdeadbeef")
+	seals := make([][]byte, len(keys))
+	for i, key := range keys {
+		seals[i], _ = crypto.Sign(commitHash(hash), key)
+	}
+	tests := []struct {
+		seals [][]byte
+		err   error
+	}{
+		{seals[:3], nil},
+		{seals[:4], nil},
+		{seals[:2], errInvalidCommittedSeals},
+		{[][]byte{seals[0], seals[1], seals[1]}, errInvalidCommittedSeals},
+		{[][]byte{seals[0], seals[1], seals[4]}, errInvalidCommittedSeals},
+		{[][]byte{seals[0], seals[1], {0x01}}, errInvalidCommittedSeals},
+	}
+	for i, tt := range tests {
+		if err := verifyCommittedSeals(snap, hash, tt.seals); err != tt.err {
+			t.Errorf("test %d: error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+	if err := verifyCommittedSeals(snap, common.HexToHash("0xcafebabe"), seals[:4]); err != errInvalidCommittedSeals {
+		t.Errorf("seals of another block accepted: %v", err)
+	}
+}
@@ -46,6 +46,8 @@ const (
 	datasetParents     = 256     // Number of parents of each dataset element
 	cacheRounds        = 3       // Number of rounds in cache production
 	loopAccesses       = 64      // Number of accesses in hashimoto loop
+
+	datasetSegmentSize = 64 * 1024 * 1024 // Bytes of dataset generated between two checkpoints
 )
 
 // cacheSize returns the size of the mxtash verification cache that belongs to a certain
@@ -265,6 +267,15 @@ func generateDatasetItem(cache []uint32, index uint32, keccak512 hasher) []byte
 // generateDataset generates the entire mxtash dataset for mining.
 // This mmxtod places the result into dest in machine byte order.
 func generateDataset(dest []uint32, epoch uint64, cache []uint32) {
+	generateDatasetSegments(dest, epoch, cache, 0, nil)
+}
+
+// generateDatasetSegments generates the mxtash dataset for mining, skipping the
+// given number of bytes already generated by an interrupted run. If a checkpoint
+// callback is given, the dataset is generated in segments of datasetSegmentSize
+// bytes, reporting the number of bytes done after each one. The result is placed
+// into dest in machine byte order.
+func generateDatasetSegments(dest []uint32, epoch uint64, cache []uint32, done uint64, checkpoint func(done uint64) error) error {
 	// Print some debug logs to allow analysis on low end devices
 	logger := log.New("epoch", epoch)
 
@@ -292,41 +303,60 @@ func generateDataset(dest []uint32, epoch uint64, cache []uint32) {
 	threads := runtime.NumCPU()
 	size := uint64(len(dataset))
 
-	var pend sync.WaitGroup
-	pend.Add(threads)
+	segment := uint32(size / hashBytes)
+	if checkpoint != nil {
+		segment = datasetSegmentSize / hashBytes
+	}
+	items := uint32(size / hashBytes)
+	percent := items / 100
+	progress := uint32(done / hashBytes)
 
-	var progress uint32
-	for i := 0; i < threads; i++ {
-		go func(id int) {
-			defer pend.Done()
+	for from := progress; from < items; from += segment {
+		to := from + segment
+		if to > items {
+			to = items
+		}
+		var pend sync.WaitGroup
+		pend.Add(threads)
 
-			// Create a hasher to reuse between invocations
-			keccak512 := makeHasher(sha3.NewLegacyKeccak512())
+		for i := 0; i < threads; i++ {
+			go func(id int) {
+				defer pend.Done()
 
-			// Calculate the data segment this thread should generate
-			batch := uint32((size + hashBytes*uint64(threads) - 1) / (hashBytes * uint64(threads)))
-			first := uint32(id) * batch
-			limit := first + batch
-			if limit > uint32(size/hashBytes) {
-				limit = uint32(size / hashBytes)
-			}
-			// Calculate the dataset segment
-			percent := uint32(size / hashBytes / 100)
-			for index := first; index < limit; index++ {
-				item := generateDatasetItem(cache, index, keccak512)
-				if swapped {
-					swap(item)
+				// Create a hasher to reuse between invocations
+				keccak512 := makeHasher(sha3.NewLegacyKeccak512())
+
+				// Calculate the data segment this thread should generate
+				batch := (to - from + uint32(threads) - 1) / uint32(threads)
+				first := from + uint32(id)*batch
+				limit := first + batch
+				if limit > to {
+					limit = to
 				}
-				copy(dataset[index*hashBytes:], item)
+				// Calculate the dataset segment
+				for index := first; index < limit; index++ {
+					item := generateDatasetItem(cache, index, keccak512)
+					if swapped {
+						swap(item)
+					}
+					copy(dataset[uint64(index)*hashBytes:], item)
 
-				if status := atomic.AddUint32(&progress, 1); status%percent == 0 {
-					logger.Info("Generating DAG in progress", "percentage", uint64(status*100)/(size/hashBytes), "elapsed", common.PrettyDuration(time.Since(start)))
+					if status := atomic.AddUint32(&progress, 1); status%percent == 0 {
+						logger.Info("Generating DAG in progress", "percentage", uint64(status*100)/(size/hashBytes), "elapsed", common.PrettyDuration(time.Since(start)))
+					}
 				}
+			}(i)
+		}
+		// Wait for all the generators to finish the segment and persist it
+		pend.Wait()
+
+		if checkpoint != nil {
+			if err := checkpoint(uint64(to) * hashBytes); err != nil {
+				return err
 			}
-		}(i)
+		}
 	}
-	// Wait for all the generators to finish and return
-	pend.Wait()
+	return nil
 }
 
 // hashimoto aggregates data from the full dataset in order to produce our final
@@ -19,16 +19,20 @@ package mxtash
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"io/ioutil"
 	"math/big"
 	"os"
+	"path/filepath"
 	"reflect"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/mxt/go-mxt/common"
 	"github.com/mxt/go-mxt/common/hexutil"
 	"github.com/mxt/go-mxt/core/types"
+	"github.com/prommxteus/tsdb/fileutil"
 )
 
 // prepare converts an mxtash cache or dataset from a byte stream into the internal
@@ -729,7 +733,7 @@ func TestConcurrentDiskCacheGeneration(t *testing.T) {
 
 		go func(idx int) {
 			defer pend.Done()
-			mxtash := New(Config{cachedir, 0, 1, false, "", 0, 0, false, ModeNormal, nil}, nil, false)
+			mxtash := New(Config{cachedir, 0, 1, false, "", 0, 0, false, ModeNormal, 0, "", 0, nil}, nil, false)
 			defer mxtash.Close()
 			if err := mxtash.VerifySeal(nil, block.Header()); err != nil {
 				t.Errorf("proc %d: block verification failed: %v", idx, err)
@@ -739,6 +743,138 @@ func TestConcurrentDiskCacheGeneration(t *testing.T) {
 	pend.Wait()
 }
 
+// Tests that an interrupted dataset generation on disk is resumed from its last
+// checkpoint, and that the completed dump passes verification.
+func TestDatasetGenerationResume(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temporary dataset dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	cache := make([]uint32, 1024/4)
+	generateCache(cache, 0, make([]byte, 32))
+
+	const size = 32 * 1024
+	want := make([]uint32, size/4)
+	generateDataset(want, 0, cache)
+
+	// Generate half the dataset and crash with some garbage past the checkpoint
+	path := filepath.Join(dir, "full")
+	_, _, _, err = memoryMapAndGenerate(path, 0, size, false, func(buffer []uint32, done uint64, checkpoint func(uint64) error) error {
+		copy(buffer, want[:size/8])
+		if err := checkpoint(size / 2); err != nil {
+			return err
+		}
+		for i := size / 8; i < size/4; i++ {
+			buffer[i] = 0xdeadbeef
+		}
+		return errors.New("crashed")
+	})
+	if err == nil {
+		t.Fatalf("interrupted generation succeeded")
+	}
+	if _, _, _, err := memoryMap(path, false); err == nil {
+		t.Fatalf("interrupted dump mapped")
+	}
+	// Resume the generation and ensure it continues from the checkpoint
+	var resumed uint64
+	dump, mem, have, err := memoryMapAndGenerate(path, 0, size, false, func(buffer []uint32, done uint64, checkpoint func(uint64) error) error {
+		resumed = done
+		return generateDatasetSegments(buffer, 0, cache, done, checkpoint)
+	})
+	if err != nil {
+		t.Fatalf("failed to resume generation: %v", err)
+	}
+	defer dump.Close()
+	defer mem.Unmap()
+
+	if resumed != size/2 {
+		t.Errorf("resumed progress mismatch: have %d, want %d", resumed, size/2)
+	}
+	if !reflect.DeepEqual(have, want) {
+		t.Errorf("resumed dataset mismatch")
+	}
+	if err := verifyDataset(path, 0, size, cache); err != nil {
+		t.Errorf("failed to verify dataset: %v", err)
+	}
+	if err := verifyDataset(path, 1, size, cache); err == nil {
+		t.Errorf("dataset of another epoch verified")
+	}
+	// Corrupt the dump and ensure verification catches it
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatalf("failed to open dataset: %v", err)
+	}
+	if _, err := file.WriteAt([]byte{0xff, 0xff}, dumpHeaderSize+size/2); err != nil {
+		t.Fatalf("failed to corrupt dataset: %v", err)
+	}
+	file.Close()
+
+	if err := verifyDataset(path, 0, size, cache); err == nil {
+		t.Errorf("corrupted dataset verified")
+	}
+}
+
+// Tests that dump locking waits while another process holds the lock, but fails
+// right away on any other error.
+func TestDumpLocking(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temporary dataset dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// A lock file which can't be opened must not be waited on
+	broken := filepath.Join(dir, "broken")
+	if err := os.Mkdir(broken+".lock", 0755); err != nil {
+		t.Fatalf("failed to create lock directory: %v", err)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		release, err := lockDump(broken)
+		if err == nil {
+			release.Release()
+		}
+		errc <- err
+	}()
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Fatalf("locked a directory")
+		}
+	case <-time.After(3 * dumpLockRetry):
+		t.Fatalf("lock failure retried")
+	}
+	// A held lock is waited on until released
+	path := filepath.Join(dir, "full")
+	held, _, err := fileutil.Flock(path + ".lock")
+	if err != nil {
+		t.Fatalf("failed to hold lock: %v", err)
+	}
+	go func() {
+		release, err := lockDump(path)
+		if err == nil {
+			release.Release()
+		}
+		errc <- err
+	}()
+	select {
+	case err := <-errc:
+		t.Fatalf("lock acquired while held: %v", err)
+	case <-time.After(dumpLockRetry / 2):
+	}
+	held.Release()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatalf("failed to acquire released lock: %v", err)
+		}
+	case <-time.After(3 * dumpLockRetry):
+		t.Fatalf("released lock not acquired")
+	}
+}
+
 // Benchmarks the cache generation performance.
 func BenchmarkCacheGeneration(b *testing.B) {
 	for i := 0; i < b.N; i++ {
@@ -106,6 +106,15 @@ func (api *API) SubmitHashRate(rate hexutil.Uint64, id common.Hash) bool {
 	return true
 }
 
+// GetStratumWorkers returns the share accounting of the miners connected to the
+// Stratum server.
+func (api *API) GetStratumWorkers() (map[string]StratumWorker, error) {
+	if api.mxtash.stratum == nil {
+		return nil, errors.New("stratum server not running")
+	}
+	return api.mxtash.stratum.stats(), nil
+}
+
 // GetHashrate returns the current hashrate for local CPU miner and remote miner.
 func (api *API) GetHashrate() uint64 {
 	return uint64(api.mxtash.Hashrate())
@@ -330,6 +330,33 @@ func CalcDifficulty(config *params.ChainConfig, time uint64, parent *types.Heade
 	}
 }
 
+// FinalizedHeader implements consensus.Finality, returning the block buried
+// under the configured number of confirmations. Proof-of-work can't offer any
+// stronger guarantee, so nothing is final unless confirmations are configured.
+func (mxtash *Ethash) FinalizedHeader(chain consensus.ChainHeaderReader) *types.Header {
+	return mxtash.confirmed(chain)
+}
+
+// SafeHeader implements consensus.Finality, returning the same block as the
+// finalized one as proof-of-work has no weaker notion of safety.
+func (mxtash *Ethash) SafeHeader(chain consensus.ChainHeaderReader) *types.Header {
+	return mxtash.confirmed(chain)
+}
+
+// confirmed returns the header the configured number of confirmations below
+// the chain head, or nil if there's none.
+func (mxtash *Ethash) confirmed(chain consensus.ChainHeaderReader) *types.Header {
+	depth := mxtash.config.Confirmations
+	if depth == 0 {
+		return nil
+	}
+	head := chain.CurrentHeader()
+	if head == nil || head.Number.Uint64() < depth {
+		return nil
+	}
+	return chain.GetHeaderByNumber(head.Number.Uint64() - depth)
+}
+
 // Some weird constants to avoid constant memory allocs for them.
 var (
 	expDiffPeriod = big.NewInt(100000)
@@ -512,49 +539,47 @@ func (mxtash *Ethash) verifySeal(chain consensus.ChainHeaderReader, header *type
 		return errInvalidDifficulty
 	}
 	// Recompute the digest and PoW values
-	number := header.Number.Uint64()
+	digest, result := mxtash.hashimoto(header.Number.Uint64(), mxtash.SealHash(header).Bytes(), header.Nonce.Uint64(), fulldag)
 
-	var (
-		digest []byte
-		result []byte
-	)
-	// If fast-but-heavy PoW verification was requested, use an mxtash dataset
+	// Verify the calculated values against the ones provided in the header
+	if !bytes.Equal(header.MixDigest[:], digest) {
+		return errInvalidMixDigest
+	}
+	target := new(big.Int).Div(two256, header.Difficulty)
+	if new(big.Int).SetBytes(result).Cmp(target) > 0 {
+		return errInvalidPoW
+	}
+	return nil
+}
+
+// hashimoto computes the mix digest and PoW value of a nonce on top of a seal
+// hash, using the mxtash dataset if requested and already generated, or the
+// verification cache otherwise.
+func (mxtash *Ethash) hashimoto(number uint64, hash []byte, nonce uint64, fulldag bool) ([]byte, []byte) {
 	if fulldag {
 		dataset := mxtash.dataset(number, true)
 		if dataset.generated() {
-			digest, result = hashimotoFull(dataset.dataset, mxtash.SealHash(header).Bytes(), header.Nonce.Uint64())
+			digest, result := hashimotoFull(dataset.dataset, hash, nonce)
 
 			// Datasets are unmapped in a finalizer. Ensure that the dataset stays alive
 			// until after the call to hashimotoFull so it's not unmapped while being used.
 			runtime.KeepAlive(dataset)
-		} else {
-			// Dataset not yet generated, don't hang, use a cache instead
-			fulldag = false
+			return digest, result
 		}
+		// Dataset not yet generated, don't hang, use a cache instead
 	}
-	// If slow-but-light PoW verification was requested (or DAG not yet ready), use an mxtash cache
-	if !fulldag {
-		cache := mxtash.cache(number)
-
-		size := datasetSize(number)
-		if mxtash.config.PowMode == ModeTest {
-			size = 32 * 1024
-		}
-		digest, result = hashimotoLight(size, cache.cache, mxtash.SealHash(header).Bytes(), header.Nonce.Uint64())
+	cache := mxtash.cache(number)
 
-		// Caches are unmapped in a finalizer. Ensure that the cache stays alive
-		// until after the call to hashimotoLight so it's not unmapped while being used.
-		runtime.KeepAlive(cache)
-	}
-	// Verify the calculated values against the ones provided in the header
-	if !bytes.Equal(header.MixDigest[:], digest) {
-		return errInvalidMixDigest
+	size := datasetSize(number)
+	if mxtash.config.PowMode == ModeTest {
+		size = 32 * 1024
 	}
-	target := new(big.Int).Div(two256, header.Difficulty)
-	if new(big.Int).SetBytes(result).Cmp(target) > 0 {
-		return errInvalidPoW
-	}
-	return nil
+	digest, result := hashimotoLight(size, cache.cache, hash, nonce)
+
+	// Caches are unmapped in a finalizer. Ensure that the cache stays alive
+	// until after the call to hashimotoLight so it's not unmapped while being used.
+	runtime.KeepAlive(cache)
+	return digest, result
 }
 
 // Prepare implements consensus.Engine, initializing the difficulty field of a
@@ -0,0 +1,29 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package mxtash
+
+import "syscall"
+
+// errorSharingViolation is returned by Windows when opening the lock file held
+// open exclusively by another process.
+const errorSharingViolation syscall.Errno = 32
+
+// isLockContention reports whmxter locking a file failed because another process
+// holds the lock.
+func isLockContention(err error) bool {
+	return err == errorSharingViolation
+}
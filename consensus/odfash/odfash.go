@@ -18,6 +18,8 @@
 package mxtash
 
 import (
+	"bytes"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"math"
@@ -27,31 +29,50 @@ import (
 	"path/filepath"
 	"reflect"
 	"runtime"
-	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 	"unsafe"
 
 	mmap "github.com/edsrzf/mmap-go"
+	"github.com/mxt/go-mxt/common"
 	"github.com/mxt/go-mxt/consensus"
+	"github.com/mxt/go-mxt/crypto"
 	"github.com/mxt/go-mxt/log"
 	"github.com/mxt/go-mxt/metrics"
 	"github.com/mxt/go-mxt/rpc"
 	"github.com/hashicorp/golang-lru/simplelru"
+	"github.com/prommxteus/tsdb/fileutil"
+	"golang.org/x/crypto/sha3"
 )
 
 var ErrInvalidDumpMagic = errors.New("invalid dump magic")
 
+var errIncompleteDump = errors.New("incomplete dump")
+
+const (
+	// dumpHeaderSize is the number of bytes preceding the content of a cache or
+	// dataset dump: the magic, the epoch, the size, the progress and the checksum.
+	dumpHeaderSize = 64
+
+	// dumpLockRetry is the interval to check whmxter another process finished
+	// generating a dump.
+	dumpLockRetry = time.Second
+
+	// datasetVerifySamples is the number of random dataset items regenerated to
+	// verify a dataset dump.
+	datasetVerifySamples = 1024
+)
+
 var (
 	// two256 is a big integer representing 2^256
 	two256 = new(big.Int).Exp(big.NewInt(2), big.NewInt(256), big.NewInt(0))
 
 	// sharedEthash is a full instance that can be shared between multiple users.
-	sharedEthash = New(Config{"", 3, 0, false, "", 1, 0, false, ModeNormal, nil}, nil, false)
+	sharedEthash = New(Config{"", 3, 0, false, "", 1, 0, false, ModeNormal, 0, "", 0, nil}, nil, false)
 
 	// algorithmRevision is the data structure version used for file naming.
-	algorithmRevision = 23
+	algorithmRevision = 24
 
 	// dumpMagic is a dataset dump header to sanity check a data dump.
 	dumpMagic = []uint32{0xbaddcafe, 0xfee1dead}
@@ -64,7 +85,44 @@ func isLittleEndian() bool {
 	return *(*byte)(unsafe.Pointer(&n)) == 0x04
 }
 
-// memoryMap tries to memory map a file of uint32s for read only access.
+// dumpHeader is the metadata stored in front of a cache or dataset dump, after
+// the magic.
+type dumpHeader struct {
+	epoch    uint64      // Epoch the dump was generated for
+	size     uint64      // Size of the dump content in bytes
+	progress uint64      // Number of content bytes generated, equal to size once complete
+	checksum common.Hash // Keccak256 hash of the content, set once complete
+}
+
+// readDumpHeader parses the header of a memory mapped dump, checking the magic.
+func readDumpHeader(mem mmap.MMap, buffer []uint32) (*dumpHeader, error) {
+	if len(mem) < dumpHeaderSize {
+		return nil, ErrInvalidDumpMagic
+	}
+	for i, magic := range dumpMagic {
+		if buffer[i] != magic {
+			return nil, ErrInvalidDumpMagic
+		}
+	}
+	header := &dumpHeader{
+		epoch:    binary.LittleEndian.Uint64(mem[8:]),
+		size:     binary.LittleEndian.Uint64(mem[16:]),
+		progress: binary.LittleEndian.Uint64(mem[24:]),
+	}
+	copy(header.checksum[:], mem[32:dumpHeaderSize])
+	return header, nil
+}
+
+// writeDumpHeader stores the magic and the header in front of a memory mapped dump.
+func writeDumpHeader(mem mmap.MMap, buffer []uint32, header *dumpHeader) {
+	copy(buffer, dumpMagic)
+	binary.LittleEndian.PutUint64(mem[8:], header.epoch)
+	binary.LittleEndian.PutUint64(mem[16:], header.size)
+	binary.LittleEndian.PutUint64(mem[24:], header.progress)
+	copy(mem[32:dumpHeaderSize], header.checksum[:])
+}
+
+// memoryMap tries to memory map a complete dump of uint32s for read only access.
 func memoryMap(path string, lock bool) (*os.File, mmap.MMap, []uint32, error) {
 	file, err := os.OpenFile(path, os.O_RDONLY, 0644)
 	if err != nil {
@@ -75,12 +133,14 @@ func memoryMap(path string, lock bool) (*os.File, mmap.MMap, []uint32, error) {
 		file.Close()
 		return nil, nil, nil, err
 	}
-	for i, magic := range dumpMagic {
-		if buffer[i] != magic {
-			mem.Unmap()
-			file.Close()
-			return nil, nil, nil, ErrInvalidDumpMagic
-		}
+	header, err := readDumpHeader(mem, buffer)
+	if err == nil && (header.progress != header.size || header.size != uint64(len(mem)-dumpHeaderSize)) {
+		err = errIncompleteDump
+	}
+	if err != nil {
+		mem.Unmap()
+		file.Close()
+		return nil, nil, nil, err
 	}
 	if lock {
 		if err := mem.Lock(); err != nil {
@@ -89,7 +149,7 @@ func memoryMap(path string, lock bool) (*os.File, mmap.MMap, []uint32, error) {
 			return nil, nil, nil, err
 		}
 	}
-	return file, mem, buffer[len(dumpMagic):], err
+	return file, mem, buffer[dumpHeaderSize/4:], err
 }
 
 // memoryMapFile tries to memory map an already opened file descriptor.
@@ -111,35 +171,108 @@ func memoryMapFile(file *os.File, write bool) (mmap.MMap, []uint32, error) {
 	return mem, *(*[]uint32)(unsafe.Pointer(&header)), nil
 }
 
+// lockDump acquires the lock guarding the generation of a dump, waiting while
+// another process holds it.
+func lockDump(path string) (fileutil.Releaser, error) {
+	for {
+		release, _, err := fileutil.Flock(path + ".lock")
+		if err == nil {
+			return release, nil
+		}
+		// Only wait if another process holds the lock, any other failure would
+		// never go away by retrying
+		if !isLockContention(err) {
+			return nil, err
+		}
+		time.Sleep(dumpLockRetry)
+	}
+}
+
+// dumpGenerator fills the content of a dump, skipping the given number of bytes
+// already generated by an interrupted run. Generators able to resume report
+// their progress through the checkpoint callback, persisting it in the dump.
+type dumpGenerator func(buffer []uint32, done uint64, checkpoint func(done uint64) error) error
+
 // memoryMapAndGenerate tries to memory map a temporary file of uint32s for write
 // access, fill it with the data from a generator and then move it into the final
 // path requested.
-func memoryMapAndGenerate(path string, size uint64, lock bool, generator func(buffer []uint32)) (*os.File, mmap.MMap, []uint32, error) {
+//
+// The generation is guarded by a lock file, so processes sharing the directory
+// wait for the one generating the dump and then map the same file read only. A
+// generation interrupted by a crash is resumed from its last checkpoint.
+func memoryMapAndGenerate(path string, epoch uint64, size uint64, lock bool, generator dumpGenerator) (*os.File, mmap.MMap, []uint32, error) {
 	// Ensure the data folder exists
 	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 		return nil, nil, nil, err
 	}
-	// Create a huge temporary empty file to fill with data
-	temp := path + "." + strconv.Itoa(rand.Int())
+	release, err := lockDump(path)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer release.Release()
 
-	dump, err := os.Create(temp)
+	// Another process might have generated the dump while we were waiting
+	if dump, mem, buffer, err := memoryMap(path, lock); err == nil {
+		return dump, mem, buffer, nil
+	}
+	// Open the temporary file, resuming from it if it's of the right dump
+	temp := path + ".tmp"
+
+	dump, err := os.OpenFile(temp, os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
 		return nil, nil, nil, err
 	}
-	if err = dump.Truncate(int64(len(dumpMagic))*4 + int64(size)); err != nil {
+	info, err := dump.Stat()
+	if err != nil {
+		dump.Close()
 		return nil, nil, nil, err
 	}
+	resumable := info.Size() == dumpHeaderSize+int64(size)
+	if !resumable {
+		if err = dump.Truncate(0); err == nil {
+			err = dump.Truncate(dumpHeaderSize + int64(size))
+		}
+		if err != nil {
+			dump.Close()
+			return nil, nil, nil, err
+		}
+	}
 	// Memory map the file for writing and fill it with the generator
 	mem, buffer, err := memoryMapFile(dump, true)
 	if err != nil {
 		dump.Close()
 		return nil, nil, nil, err
 	}
-	copy(buffer, dumpMagic)
-
-	data := buffer[len(dumpMagic):]
-	generator(data)
+	header, err := readDumpHeader(mem, buffer)
+	if !resumable || err != nil || header.epoch != epoch || header.size != size || header.progress > size {
+		header = &dumpHeader{epoch: epoch, size: size}
+		writeDumpHeader(mem, buffer, header)
+	} else if header.progress > 0 {
+		log.Info("Resuming interrupted mxtash dump generation", "epoch", epoch, "path", temp, "done", header.progress, "size", size)
+	}
+	checkpoint := func(done uint64) error {
+		// Persist the content before recording it done
+		if err := mem.Flush(); err != nil {
+			return err
+		}
+		header.progress = done
+		writeDumpHeader(mem, buffer, header)
+		return mem.Flush()
+	}
+	if err := generator(buffer[dumpHeaderSize/4:], header.progress, checkpoint); err != nil {
+		mem.Unmap()
+		dump.Close()
+		return nil, nil, nil, err
+	}
+	header.progress = size
+	header.checksum = crypto.Keccak256Hash(mem[dumpHeaderSize:])
+	writeDumpHeader(mem, buffer, header)
 
+	if err := mem.Flush(); err != nil {
+		mem.Unmap()
+		dump.Close()
+		return nil, nil, nil, err
+	}
 	if err := mem.Unmap(); err != nil {
 		return nil, nil, nil, err
 	}
@@ -234,11 +367,7 @@ func (c *cache) generate(dir string, limit int, lock bool, test bool) {
 			return
 		}
 		// Disk storage is needed, this will get fancy
-		var endian string
-		if !isLittleEndian() {
-			endian = ".be"
-		}
-		path := filepath.Join(dir, fmt.Sprintf("cache-R%d-%x%s", algorithmRevision, seed[:8], endian))
+		path := dumpPath(dir, "cache", c.epoch)
 		logger := log.New("epoch", c.epoch)
 
 		// We're about to mmap the file, ensure that the mapping is cleaned up when the
@@ -255,7 +384,11 @@ func (c *cache) generate(dir string, limit int, lock bool, test bool) {
 		logger.Debug("Failed to load old mxtash cache", "err", err)
 
 		// No previous cache available, create a new cache file to fill
-		c.dump, c.mmap, c.cache, err = memoryMapAndGenerate(path, size, lock, func(buffer []uint32) { generateCache(buffer, c.epoch, seed) })
+		c.dump, c.mmap, c.cache, err = memoryMapAndGenerate(path, c.epoch, size, lock, func(buffer []uint32, done uint64, checkpoint func(uint64) error) error {
+			// Caches are quick to generate, there's no point resuming them
+			generateCache(buffer, c.epoch, seed)
+			return nil
+		})
 		if err != nil {
 			logger.Error("Failed to generate mapped mxtash cache", "err", err)
 
@@ -264,9 +397,7 @@ func (c *cache) generate(dir string, limit int, lock bool, test bool) {
 		}
 		// Iterate over all previous instances and delete old ones
 		for ep := int(c.epoch) - limit; ep >= 0; ep-- {
-			seed := seedHash(uint64(ep)*epochLength + 1)
-			path := filepath.Join(dir, fmt.Sprintf("cache-R%d-%x%s", algorithmRevision, seed[:8], endian))
-			os.Remove(path)
+			removeDump(dumpPath(dir, "cache", uint64(ep)))
 		}
 	})
 }
@@ -320,11 +451,7 @@ func (d *dataset) generate(dir string, limit int, lock bool, test bool) {
 			return
 		}
 		// Disk storage is needed, this will get fancy
-		var endian string
-		if !isLittleEndian() {
-			endian = ".be"
-		}
-		path := filepath.Join(dir, fmt.Sprintf("full-R%d-%x%s", algorithmRevision, seed[:8], endian))
+		path := dumpPath(dir, "full", d.epoch)
 		logger := log.New("epoch", d.epoch)
 
 		// We're about to mmap the file, ensure that the mapping is cleaned up when the
@@ -344,7 +471,9 @@ func (d *dataset) generate(dir string, limit int, lock bool, test bool) {
 		cache := make([]uint32, csize/4)
 		generateCache(cache, d.epoch, seed)
 
-		d.dump, d.mmap, d.dataset, err = memoryMapAndGenerate(path, dsize, lock, func(buffer []uint32) { generateDataset(buffer, d.epoch, cache) })
+		d.dump, d.mmap, d.dataset, err = memoryMapAndGenerate(path, d.epoch, dsize, lock, func(buffer []uint32, done uint64, checkpoint func(uint64) error) error {
+			return generateDatasetSegments(buffer, d.epoch, cache, done, checkpoint)
+		})
 		if err != nil {
 			logger.Error("Failed to generate mapped mxtash dataset", "err", err)
 
@@ -353,9 +482,7 @@ func (d *dataset) generate(dir string, limit int, lock bool, test bool) {
 		}
 		// Iterate over all previous instances and delete old ones
 		for ep := int(d.epoch) - limit; ep >= 0; ep-- {
-			seed := seedHash(uint64(ep)*epochLength + 1)
-			path := filepath.Join(dir, fmt.Sprintf("full-R%d-%x%s", algorithmRevision, seed[:8], endian))
-			os.Remove(path)
+			removeDump(dumpPath(dir, "full", uint64(ep)))
 		}
 	})
 }
@@ -388,6 +515,82 @@ func MakeDataset(block uint64, dir string) {
 	d.generate(dir, math.MaxInt32, false, false)
 }
 
+// VerifyDataset checks the mxtash dataset of a block stored in the given folder:
+// the dump must be complete and match its checksum, and a random sample of its
+// items must match the ones derived from the verification cache.
+func VerifyDataset(block uint64, dir string) error {
+	epoch := block / epochLength
+
+	cache := make([]uint32, cacheSize(epoch*epochLength+1)/4)
+	generateCache(cache, epoch, seedHash(epoch*epochLength+1))
+
+	return verifyDataset(dumpPath(dir, "full", epoch), epoch, datasetSize(epoch*epochLength+1), cache)
+}
+
+// verifyDataset checks a dataset dump against its header and a verification cache.
+func verifyDataset(path string, epoch uint64, size uint64, cache []uint32) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	mem, buffer, err := memoryMapFile(file, false)
+	if err != nil {
+		return err
+	}
+	defer mem.Unmap()
+
+	header, err := readDumpHeader(mem, buffer)
+	if err != nil {
+		return err
+	}
+	if header.epoch != epoch {
+		return fmt.Errorf("epoch mismatch: have %d, want %d", header.epoch, epoch)
+	}
+	if header.size != size || uint64(len(mem)-dumpHeaderSize) != size {
+		return fmt.Errorf("size mismatch: have %d (header %d), want %d", len(mem)-dumpHeaderSize, header.size, size)
+	}
+	if header.progress != header.size {
+		return fmt.Errorf("incomplete dump: %d of %d bytes generated", header.progress, header.size)
+	}
+	if checksum := crypto.Keccak256Hash(mem[dumpHeaderSize:]); checksum != header.checksum {
+		return fmt.Errorf("checksum mismatch: have %x, want %x", checksum, header.checksum)
+	}
+	keccak512 := makeHasher(sha3.NewLegacyKeccak512())
+	for i := 0; i < datasetVerifySamples; i++ {
+		index := uint32(rand.Int63n(int64(size / hashBytes)))
+
+		item := generateDatasetItem(cache, index, keccak512)
+		if !isLittleEndian() {
+			swap(item)
+		}
+		offset := dumpHeaderSize + uint64(index)*hashBytes
+		if !bytes.Equal(mem[offset:offset+hashBytes], item) {
+			return fmt.Errorf("item %d mismatch", index)
+		}
+	}
+	return nil
+}
+
+// dumpPath returns the file of the cache or dataset dump of an epoch.
+func dumpPath(dir string, kind string, epoch uint64) string {
+	seed := seedHash(epoch*epochLength + 1)
+
+	var endian string
+	if !isLittleEndian() {
+		endian = ".be"
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s-R%d-%x%s", kind, algorithmRevision, seed[:8], endian))
+}
+
+// removeDump deletes a dump along with its generation leftovers.
+func removeDump(path string) {
+	os.Remove(path)
+	os.Remove(path + ".tmp")
+	os.Remove(path + ".lock")
+}
+
 // Mode defines the type and amount of PoW verification an mxtash engine makes.
 type Mode uint
 
@@ -410,6 +613,10 @@ type Config struct {
 	DatasetsOnDisk   int
 	DatasetsLockMmap bool
 	PowMode          Mode
+	Confirmations    uint64 // Number of blocks on top of a block to consider it final, 0 to disable
+
+	StratumAddr       string  // Listening address of the Stratum server for remote miners, empty to disable
+	StratumDifficulty float64 // Default share difficulty of the Stratum workers, in units of 2^32 hashes
 
 	Log log.Logger `toml:"-"`
 }
@@ -428,6 +635,7 @@ type Ethash struct {
 	update   chan struct{} // Notification channel to update mining parameters
 	hashrate metrics.Meter // Meter tracking the average hashrate
 	remote   *remoteSealer
+	stratum  *stratumServer // Stratum server feeding the remote work to miners, nil if disabled
 
 	// The fields below are hooks for testing
 	shared    *Ethash       // Shared PoW verifier to avoid cache regeneration
@@ -466,6 +674,20 @@ func New(config Config, notify []string, noverify bool) *Ethash {
 	return mxtash
 }
 
+// StartStratum starts the Stratum server for remote miners if a listening address
+// is configured. It must be called before the engine is used.
+func (mxtash *Ethash) StartStratum() error {
+	if mxtash.config.StratumAddr == "" || mxtash.remote == nil {
+		return nil
+	}
+	stratum, err := startStratumServer(mxtash, mxtash.config.StratumAddr, mxtash.config.StratumDifficulty)
+	if err != nil {
+		return fmt.Errorf("failed to start Stratum server on %s: %v", mxtash.config.StratumAddr, err)
+	}
+	mxtash.stratum = stratum
+	return nil
+}
+
 // NewTester creates a small sized mxtash PoW scheme useful only for testing
 // purposes.
 func NewTester(notify []string, noverify bool) *Ethash {
@@ -543,6 +765,9 @@ func (mxtash *Ethash) Close() error {
 		if mxtash.remote == nil {
 			return
 		}
+		if mxtash.stratum != nil {
+			mxtash.stratum.close()
+		}
 		close(mxtash.remote.requestExit)
 		<-mxtash.remote.exitCh
 	})
@@ -651,8 +876,12 @@ func (mxtash *Ethash) Hashrate() float64 {
 		return mxtash.hashrate.Rate1()
 	}
 
-	// Gather total submitted hash rate of remote sealers.
-	return mxtash.hashrate.Rate1() + float64(<-res)
+	// Gather total submitted hash rate of remote sealers and Stratum workers.
+	rate := mxtash.hashrate.Rate1() + float64(<-res)
+	if mxtash.stratum != nil {
+		rate += mxtash.stratum.hashrate()
+	}
+	return rate
 }
 
 // APIs implements consensus.Engine, returning the user facing RPC APIs.
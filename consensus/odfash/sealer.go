@@ -34,6 +34,7 @@ import (
 	"github.com/mxt/go-mxt/common/hexutil"
 	"github.com/mxt/go-mxt/consensus"
 	"github.com/mxt/go-mxt/core/types"
+	"github.com/mxt/go-mxt/event"
 )
 
 const (
@@ -197,6 +198,7 @@ type remoteSealer struct {
 	notifyCtx    context.Context
 	cancelNotify context.CancelFunc // cancels all notification requests
 	reqWG        sync.WaitGroup     // tracks notification request goroutines
+	workFeed     event.Feed         // feeds the new work blocks to in-process miners (e.g. Stratum)
 
 	mxtash       *Ethash
 	noverify     bool
@@ -282,6 +284,7 @@ func (s *remoteSealer) loop() {
 			s.results = work.results
 			s.makeWork(work.block)
 			s.notifyWork()
+			s.workFeed.Send(work.block)
 
 		case work := <-s.fetchWorkCh:
 			// Return current mining work to remote miner.
@@ -335,6 +338,12 @@ func (s *remoteSealer) loop() {
 	}
 }
 
+// subscribeWork registers a subscription for the blocks pushed to the remote
+// sealer to be mined.
+func (s *remoteSealer) subscribeWork(ch chan<- *types.Block) event.Subscription {
+	return s.workFeed.Subscribe(ch)
+}
+
 // makeWork creates a work package for external miner.
 //
 // The work package consists of 3 strings:
@@ -0,0 +1,591 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package mxtash
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/core/types"
+	"github.com/mxt/go-mxt/event"
+	"github.com/mxt/go-mxt/metrics"
+	"golang.org/x/time/rate"
+)
+
+const (
+	stratumProtocol       = "EthereumStratum/1.0.0" // Protocol version reported to the miners
+	stratumExtranonceSize = 2                       // Number of nonce bytes fixed by the server for each session
+	stratumMaxRequestSize = 4096                    // Maximum size of a request line sent by a miner
+	stratumQueueSize      = 64                      // Number of messages queued for a miner before dropping it
+	stratumWriteTimeout   = 10 * time.Second        // Maximum time to wait for a miner to accept a message
+	stratumSubmitRate     = 20                      // Number of shares per second a miner may sustain
+	stratumSubmitBurst    = 100                     // Number of shares a miner may submit in a burst
+)
+
+// stratumDiff1 is the share target of difficulty 1, which takes 2^32 hashes on
+// average to find.
+var stratumDiff1 = new(big.Int).Lsh(big.NewInt(1), 224)
+
+// Errors reported to the miners, using the codes of the Stratum pools.
+var (
+	errStratumUnknown       = &stratumError{20, "Other/Unknown"}
+	errStratumJobNotFound   = &stratumError{21, "Job not found"}
+	errStratumDuplicate     = &stratumError{22, "Duplicate share"}
+	errStratumLowDifficulty = &stratumError{23, "Low difficulty share"}
+	errStratumUnauthorized  = &stratumError{24, "Unauthorized worker"}
+	errStratumUnsubscribed  = &stratumError{25, "Not subscribed"}
+	errStratumRateLimited   = &stratumError{20, "Too many shares"}
+)
+
+// stratumError is an error reported to a miner, encoded as the [code, message,
+// traceback] triple of the Stratum protocol.
+type stratumError struct {
+	code    int
+	message string
+}
+
+func (e *stratumError) Error() string {
+	return e.message
+}
+
+func (e *stratumError) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.code, e.message, nil})
+}
+
+// stratumRequest is a request sent by a miner.
+type stratumRequest struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// stratumResponse is the reply to a request of a miner.
+type stratumResponse struct {
+	ID     json.RawMessage `json:"id"`
+	Result interface{}     `json:"result"`
+	Error  *stratumError   `json:"error"`
+}
+
+// stratumNotification is a message pushed to a miner by the server.
+type stratumNotification struct {
+	ID     interface{}   `json:"id"`
+	Method string        `json:"method"`
+	Params []interface{} `json:"params"`
+}
+
+// StratumWorker is the share accounting of a miner, aggregated over all the
+// connections authorized with its name.
+type StratumWorker struct {
+	Sessions   int       `json:"sessions"`   // Number of connections of the worker
+	Difficulty float64   `json:"difficulty"` // Share difficulty requested by the worker
+	Accepted   uint64    `json:"accepted"`   // Number of valid shares submitted
+	Rejected   uint64    `json:"rejected"`   // Number of invalid or duplicate shares submitted
+	Stale      uint64    `json:"stale"`      // Number of shares submitted for unknown or stale jobs
+	Blocks     uint64    `json:"blocks"`     // Number of shares accepted as block solutions
+	LastShare  time.Time `json:"lastShare"`  // Time of the last valid share
+	Hashrate   float64   `json:"hashrate"`   // Hash rate estimated from the valid shares
+}
+
+// stratumWorker tracks the shares of a worker.
+type stratumWorker struct {
+	stats StratumWorker
+	work  metrics.Meter // Meter tracking the hashes worth of the valid shares
+}
+
+// stratumJob is a block pushed to the miners to work on.
+type stratumJob struct {
+	id       string
+	block    *types.Block
+	sealhash common.Hash
+	target   *big.Int            // Block target, 2^256/difficulty
+	nonces   map[uint64]struct{} // Nonces submitted for the job, to reject duplicates
+}
+
+// stratumSession is a connection of a miner to the Stratum server.
+type stratumSession struct {
+	id         string
+	conn       net.Conn
+	extranonce uint64        // Most significant nonce bytes assigned to the session
+	submits    *rate.Limiter // Limiter of the shares, each one costs a hashimoto run to verify
+
+	out  chan interface{}
+	quit chan struct{}
+
+	// Fields below are protected by the server lock
+	subscribed bool
+	worker     string  // Name of the authorized worker, empty if not authorized yet
+	difficulty float64 // Share difficulty of the worker
+	current    float64 // Share difficulty last sent to the miner
+}
+
+// send queues a message to be written to the miner, dropping the connection if
+// the miner doesn't keep up.
+func (sess *stratumSession) send(msg interface{}) {
+	select {
+	case sess.out <- msg:
+	case <-sess.quit:
+	default:
+		sess.conn.Close()
+	}
+}
+
+// writeLoop writes the queued messages to the miner.
+func (sess *stratumSession) writeLoop() {
+	enc := json.NewEncoder(sess.conn)
+	for {
+		select {
+		case msg := <-sess.out:
+			sess.conn.SetWriteDeadline(time.Now().Add(stratumWriteTimeout))
+			if err := enc.Encode(msg); err != nil {
+				sess.conn.Close()
+				return
+			}
+		case <-sess.quit:
+			return
+		}
+	}
+}
+
+// stratumServer is an EthereumStratum/1.0 server, feeding the work of the remote
+// sealer to miners over TCP and submitting their solutions back to it.
+type stratumServer struct {
+	mxtash     *Ethash
+	listener   net.Listener
+	difficulty float64 // Default share difficulty of the workers
+
+	workCh chan *types.Block
+	sub    event.Subscription
+
+	lock      sync.Mutex
+	sessions  map[*stratumSession]struct{}
+	workers   map[string]*stratumWorker
+	jobs      map[string]*stratumJob
+	current   *stratumJob
+	jobNonce  uint64 // Counter generating the job identifiers
+	sessNonce uint64 // Counter generating the session identifiers and extranonces
+
+	wg   sync.WaitGroup
+	quit chan struct{}
+}
+
+// startStratumServer starts listening for Stratum miners on the given address,
+// feeding them the work of the remote sealer.
+func startStratumServer(mxtash *Ethash, addr string, difficulty float64) (*stratumServer, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if difficulty <= 0 {
+		difficulty = 1
+	}
+	s := &stratumServer{
+		mxtash:     mxtash,
+		listener:   listener,
+		difficulty: difficulty,
+		workCh:     make(chan *types.Block, 16),
+		sessions:   make(map[*stratumSession]struct{}),
+		workers:    make(map[string]*stratumWorker),
+		jobs:       make(map[string]*stratumJob),
+		quit:       make(chan struct{}),
+	}
+	s.sub = mxtash.remote.subscribeWork(s.workCh)
+
+	s.wg.Add(2)
+	go s.loop()
+	go s.acceptLoop()
+
+	mxtash.config.Log.Info("Stratum server started", "addr", listener.Addr(), "difficulty", difficulty)
+	return s, nil
+}
+
+// close stops accepting miners and drops all the connected ones.
+func (s *stratumServer) close() {
+	close(s.quit)
+	s.listener.Close()
+	s.sub.Unsubscribe()
+
+	s.lock.Lock()
+	for sess := range s.sessions {
+		sess.conn.Close()
+	}
+	s.lock.Unlock()
+	s.wg.Wait()
+
+	for _, worker := range s.workers {
+		worker.work.Stop()
+	}
+}
+
+// loop pushes the work of the remote sealer to the miners.
+func (s *stratumServer) loop() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case block := <-s.workCh:
+			s.setWork(block)
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// acceptLoop accepts the connections of the miners.
+func (s *stratumServer) acceptLoop() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			select {
+			case <-s.quit:
+			default:
+				s.mxtash.config.Log.Error("Stratum server stopped accepting miners", "err", err)
+			}
+			return
+		}
+		s.wg.Add(1)
+		go s.handle(conn)
+	}
+}
+
+// setWork creates a new job out of a block and pushes it to the miners.
+func (s *stratumServer) setWork(block *types.Block) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// Same work can be pushed twice, don't restart the miners on it
+	sealhash := s.mxtash.SealHash(block.Header())
+	if s.current != nil && s.current.sealhash == sealhash {
+		return
+	}
+	clean := s.current == nil || s.current.block.NumberU64() != block.NumberU64()
+
+	s.jobNonce++
+	job := &stratumJob{
+		id:       fmt.Sprintf("%x", s.jobNonce),
+		block:    block,
+		sealhash: sealhash,
+		target:   new(big.Int).Div(two256, block.Difficulty()),
+		nonces:   make(map[uint64]struct{}),
+	}
+	// Drop the jobs the remote sealer would reject anyway
+	for id, old := range s.jobs {
+		if old.block.NumberU64()+staleThreshold <= block.NumberU64() {
+			delete(s.jobs, id)
+		}
+	}
+	s.jobs[job.id] = job
+	s.current = job
+
+	for sess := range s.sessions {
+		if sess.worker != "" {
+			s.sendJob(sess, job, clean)
+		}
+	}
+}
+
+// sendJob pushes a job to a miner, preceded by its share difficulty if it
+// changed. The caller must hold the server lock.
+func (s *stratumServer) sendJob(sess *stratumSession, job *stratumJob, clean bool) {
+	if difficulty := shareDifficulty(sess.difficulty, job.block.Difficulty()); difficulty != sess.current {
+		sess.current = difficulty
+		sess.send(&stratumNotification{Method: "mining.set_difficulty", Params: []interface{}{difficulty}})
+	}
+	seed := SeedHash(job.block.NumberU64())
+	sess.send(&stratumNotification{
+		Method: "mining.notify",
+		Params: []interface{}{job.id, hex.EncodeToString(seed), hex.EncodeToString(job.sealhash[:]), clean},
+	})
+}
+
+// handle serves the requests of a miner until it disconnects.
+func (s *stratumServer) handle(conn net.Conn) {
+	defer s.wg.Done()
+	defer conn.Close()
+
+	s.lock.Lock()
+	s.sessNonce++
+	sess := &stratumSession{
+		id:         fmt.Sprintf("%016x", s.sessNonce),
+		conn:       conn,
+		extranonce: s.sessNonce % (1 << (8 * stratumExtranonceSize)),
+		submits:    rate.NewLimiter(stratumSubmitRate, stratumSubmitBurst),
+		out:        make(chan interface{}, stratumQueueSize),
+		quit:       make(chan struct{}),
+	}
+	s.sessions[sess] = struct{}{}
+	s.lock.Unlock()
+
+	go sess.writeLoop()
+	defer func() {
+		s.lock.Lock()
+		delete(s.sessions, sess)
+		if worker := s.workers[sess.worker]; worker != nil {
+			worker.stats.Sessions--
+		}
+		s.lock.Unlock()
+		close(sess.quit)
+	}()
+	log := s.mxtash.config.Log.New("miner", conn.RemoteAddr())
+	log.Debug("Stratum miner connected")
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 0, stratumMaxRequestSize), stratumMaxRequestSize)
+	for scanner.Scan() {
+		var req stratumRequest
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+			log.Debug("Dropping Stratum miner sending invalid request", "err", err)
+			return
+		}
+		result, err := s.serve(sess, &req)
+		sess.send(&stratumResponse{ID: req.ID, Result: result, Error: err})
+
+		// Newly authorized workers start on the current job right away
+		if req.Method == "mining.authorize" && err == nil {
+			s.lock.Lock()
+			if s.current != nil {
+				s.sendJob(sess, s.current, true)
+			}
+			s.lock.Unlock()
+		}
+	}
+	log.Debug("Stratum miner disconnected", "err", scanner.Err())
+}
+
+// serve executes a request of a miner.
+func (s *stratumServer) serve(sess *stratumSession, req *stratumRequest) (interface{}, *stratumError) {
+	var params []string
+	if len(req.Params) > 0 {
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, errStratumUnknown
+		}
+	}
+	switch req.Method {
+	case "mining.subscribe":
+		return s.subscribe(sess)
+	case "mining.extranonce.subscribe":
+		// The extranonce of a session never changes
+		return true, nil
+	case "mining.authorize":
+		return s.authorize(sess, params)
+	case "mining.submit":
+		return s.submit(sess, params)
+	default:
+		return nil, errStratumUnknown
+	}
+}
+
+// subscribe handles the mining.subscribe request, returning the session id and
+// the extranonce assigned to the miner.
+func (s *stratumServer) subscribe(sess *stratumSession) (interface{}, *stratumError) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	sess.subscribed = true
+
+	extranonce := make([]byte, 8)
+	binary.BigEndian.PutUint64(extranonce, sess.extranonce)
+	return []interface{}{
+		[]string{"mining.notify", sess.id, stratumProtocol},
+		hex.EncodeToString(extranonce[8-stratumExtranonceSize:]),
+	}, nil
+}
+
+// authorize handles the mining.authorize request. The share difficulty of the
+// worker may be requested in the password as "d=<difficulty>", but never below
+// the default one of the server.
+func (s *stratumServer) authorize(sess *stratumSession, params []string) (interface{}, *stratumError) {
+	if len(params) == 0 || params[0] == "" {
+		return nil, errStratumUnauthorized
+	}
+	difficulty := s.difficulty
+	if len(params) > 1 {
+		for _, field := range strings.Split(params[1], ",") {
+			if !strings.HasPrefix(field, "d=") {
+				continue
+			}
+			d, err := strconv.ParseFloat(strings.TrimPrefix(field, "d="), 64)
+			if err != nil || d <= 0 {
+				return nil, errStratumUnauthorized
+			}
+			if d > s.difficulty {
+				difficulty = d
+			}
+		}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !sess.subscribed {
+		return nil, errStratumUnsubscribed
+	}
+	if sess.worker != "" {
+		s.workers[sess.worker].stats.Sessions--
+	}
+	worker := s.workers[params[0]]
+	if worker == nil {
+		worker = &stratumWorker{work: metrics.NewMeterForced()}
+		s.workers[params[0]] = worker
+	}
+	worker.stats.Sessions++
+	worker.stats.Difficulty = difficulty
+
+	sess.worker, sess.difficulty = params[0], difficulty
+	return true, nil
+}
+
+// submit handles the mining.submit request, checking the share against the
+// difficulty of the worker and handing it to the remote sealer if it solves
+// the block.
+func (s *stratumServer) submit(sess *stratumSession, params []string) (interface{}, *stratumError) {
+	if len(params) < 3 {
+		return nil, errStratumUnknown
+	}
+	if !sess.submits.Allow() {
+		return nil, errStratumRateLimited
+	}
+	s.lock.Lock()
+	if sess.worker == "" {
+		s.lock.Unlock()
+		return nil, errStratumUnauthorized
+	}
+	worker := s.workers[sess.worker]
+	job := s.jobs[params[1]]
+	if job == nil {
+		worker.stats.Stale++
+		s.lock.Unlock()
+		return nil, errStratumJobNotFound
+	}
+	// The miner only submits the nonce bytes following the extranonce
+	suffix, err := hex.DecodeString(strings.TrimPrefix(params[2], "0x"))
+	if err != nil || len(suffix) != 8-stratumExtranonceSize {
+		worker.stats.Rejected++
+		s.lock.Unlock()
+		return nil, errStratumUnknown
+	}
+	buf := make([]byte, 8)
+	copy(buf[stratumExtranonceSize:], suffix)
+	nonce := sess.extranonce<<(64-8*stratumExtranonceSize) | binary.BigEndian.Uint64(buf)
+
+	if _, ok := job.nonces[nonce]; ok {
+		worker.stats.Rejected++
+		s.lock.Unlock()
+		return nil, errStratumDuplicate
+	}
+	job.nonces[nonce] = struct{}{}
+	difficulty := shareDifficulty(sess.difficulty, job.block.Difficulty())
+	s.lock.Unlock()
+
+	// Verify the share out of the lock, it's expensive without the dataset
+	digest, result := s.mxtash.hashimoto(job.block.NumberU64(), job.sealhash.Bytes(), nonce, true)
+
+	value := new(big.Int).SetBytes(result)
+	if value.Cmp(shareTarget(difficulty)) > 0 && value.Cmp(job.target) > 0 {
+		s.lock.Lock()
+		worker.stats.Rejected++
+		s.lock.Unlock()
+		return nil, errStratumLowDifficulty
+	}
+	s.lock.Lock()
+	worker.stats.Accepted++
+	worker.stats.LastShare = time.Now()
+	s.lock.Unlock()
+	worker.work.Mark(int64(difficulty * (1 << 32)))
+
+	if value.Cmp(job.target) > 0 {
+		return true, nil
+	}
+	// The share solves the block, submit it through the remote sealer
+	errc := make(chan error, 1)
+	select {
+	case s.mxtash.remote.submitWorkCh <- &mineResult{
+		nonce:     types.EncodeNonce(nonce),
+		mixDigest: common.BytesToHash(digest),
+		hash:      job.sealhash,
+		errc:      errc,
+	}:
+	case <-s.mxtash.remote.exitCh:
+		return true, nil
+	}
+	if err := <-errc; err != nil {
+		s.mxtash.config.Log.Warn("Stratum block solution rejected", "worker", sess.worker, "number", job.block.NumberU64(), "sealhash", job.sealhash, "err", err)
+		return true, nil
+	}
+	s.lock.Lock()
+	worker.stats.Blocks++
+	s.lock.Unlock()
+
+	s.mxtash.config.Log.Info("Stratum worker solved block", "worker", sess.worker, "number", job.block.NumberU64(), "sealhash", job.sealhash)
+	return true, nil
+}
+
+// stats returns the share accounting of the workers.
+func (s *stratumServer) stats() map[string]StratumWorker {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	workers := make(map[string]StratumWorker, len(s.workers))
+	for name, worker := range s.workers {
+		stats := worker.stats
+		stats.Hashrate = worker.work.Rate1()
+		workers[name] = stats
+	}
+	return workers
+}
+
+// hashrate returns the hash rate of all the workers, estimated from their shares.
+func (s *stratumServer) hashrate() float64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var rate float64
+	for _, worker := range s.workers {
+		rate += worker.work.Rate1()
+	}
+	return rate
+}
+
+// shareDifficulty caps the difficulty requested by a worker to the one of the
+// block, so that every block solution is a valid share.
+func shareDifficulty(requested float64, block *big.Int) float64 {
+	limit, _ := new(big.Float).Quo(new(big.Float).SetInt(block), new(big.Float).SetInt64(1<<32)).Float64()
+	if requested > limit {
+		return limit
+	}
+	return requested
+}
+
+// shareTarget converts a share difficulty into the target the PoW value must
+// not exceed.
+func shareTarget(difficulty float64) *big.Int {
+	target, _ := new(big.Float).Quo(new(big.Float).SetInt(stratumDiff1), big.NewFloat(difficulty)).Int(nil)
+	if target.Cmp(two256) >= 0 {
+		target.Sub(two256, common.Big1)
+	}
+	return target
+}
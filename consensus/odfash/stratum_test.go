@@ -0,0 +1,278 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package mxtash
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mxt/go-mxt/core/types"
+	"golang.org/x/time/rate"
+)
+
+// stratumTestMiner is a minimal Stratum client.
+type stratumTestMiner struct {
+	t      *testing.T
+	conn   net.Conn
+	reader *bufio.Reader
+	nextID int
+
+	difficulty float64
+	notify     []interface{} // Parameters of the last mining.notify
+}
+
+func newStratumTestMiner(t *testing.T, addr net.Addr) *stratumTestMiner {
+	conn, err := net.Dial("tcp", addr.String())
+	if err != nil {
+		t.Fatalf("failed to connect to the Stratum server: %v", err)
+	}
+	return &stratumTestMiner{t: t, conn: conn, reader: bufio.NewReader(conn)}
+}
+
+// read reads the next message sent by the server, recording the notifications.
+func (m *stratumTestMiner) read() map[string]json.RawMessage {
+	m.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := m.reader.ReadBytes('\n')
+	if err != nil {
+		m.t.Fatalf("failed to read Stratum message: %v", err)
+	}
+	var msg map[string]json.RawMessage
+	if err := json.Unmarshal(line, &msg); err != nil {
+		m.t.Fatalf("invalid Stratum message %s: %v", line, err)
+	}
+	var params []interface{}
+	json.Unmarshal(msg["params"], &params)
+
+	switch string(msg["method"]) {
+	case `"mining.set_difficulty"`:
+		m.difficulty = params[0].(float64)
+	case `"mining.notify"`:
+		m.notify = params
+	}
+	return msg
+}
+
+// call sends a request and waits for its result, failing if an error is returned
+// unless expected.
+func (m *stratumTestMiner) call(method string, params ...interface{}) (json.RawMessage, []interface{}) {
+	m.nextID++
+	req, _ := json.Marshal(map[string]interface{}{"id": m.nextID, "method": method, "params": params})
+	if _, err := m.conn.Write(append(req, '\n')); err != nil {
+		m.t.Fatalf("failed to send Stratum request: %v", err)
+	}
+	for {
+		msg := m.read()
+		if string(msg["id"]) != fmt.Sprint(m.nextID) {
+			continue
+		}
+		var failure []interface{}
+		json.Unmarshal(msg["error"], &failure)
+		return msg["result"], failure
+	}
+}
+
+// waitJob waits until a job is notified for the given seal hash.
+func (m *stratumTestMiner) waitJob(sealhash string) string {
+	for m.notify == nil || m.notify[2] != sealhash {
+		m.read()
+	}
+	return m.notify[0].(string)
+}
+
+// mineStratumShare searches for a nonce suffix whose PoW value meets the target
+// (or doesn't, if an invalid share is requested).
+func mineStratumShare(mxtash *Ethash, block *types.Block, extranonce string, target *big.Int, valid bool) string {
+	prefix, _ := hex.DecodeString(extranonce)
+	sealhash := mxtash.SealHash(block.Header())
+
+	for suffix := uint64(0); ; suffix++ {
+		nonce := make([]byte, 8)
+		binary.BigEndian.PutUint64(nonce, suffix)
+		copy(nonce, prefix)
+
+		_, result := mxtash.hashimoto(block.NumberU64(), sealhash.Bytes(), binary.BigEndian.Uint64(nonce), false)
+		if (new(big.Int).SetBytes(result).Cmp(target) <= 0) == valid {
+			return hex.EncodeToString(nonce[len(prefix):])
+		}
+	}
+}
+
+// Tests that Stratum miners get the work of the remote sealer, and that their
+// shares are accounted and block solutions submitted to the sealer.
+func TestStratumMining(t *testing.T) {
+	mxtash := NewTester(nil, false)
+	defer mxtash.Close()
+
+	stratum, err := startStratumServer(mxtash, "127.0.0.1:0", 0.0000000001)
+	if err != nil {
+		t.Fatalf("failed to start Stratum server: %v", err)
+	}
+	mxtash.stratum = stratum
+
+	miner := newStratumTestMiner(t, stratum.listener.Addr())
+	defer miner.conn.Close()
+
+	// Shares can't be submitted before authorizing
+	if _, failure := miner.call("mining.authorize", "worker", "x"); failure == nil || failure[0] != float64(25) {
+		t.Fatalf("unsubscribed authorization error mismatch: have %v, want code 25", failure)
+	}
+	result, failure := miner.call("mining.subscribe", "testminer", stratumProtocol)
+	if failure != nil {
+		t.Fatalf("failed to subscribe: %v", failure)
+	}
+	var subscription []interface{}
+	if err := json.Unmarshal(result, &subscription); err != nil {
+		t.Fatalf("invalid subscription %s: %v", result, err)
+	}
+	extranonce := subscription[1].(string)
+	if len(extranonce) != 2*stratumExtranonceSize {
+		t.Fatalf("extranonce length mismatch: have %d, want %d", len(extranonce), 2*stratumExtranonceSize)
+	}
+	if _, failure := miner.call("mining.submit", "worker", "1", "000000000000"); failure == nil || failure[0] != float64(24) {
+		t.Fatalf("unauthorized submission error mismatch: have %v, want code 24", failure)
+	}
+	// Request a share difficulty of 1/64 the block's one, capped by the block's
+	if _, failure := miner.call("mining.authorize", "worker", "x,d=0.00000000036"); failure != nil {
+		t.Fatalf("failed to authorize: %v", failure)
+	}
+	// Push a block to the remote sealer and wait for the job
+	results := make(chan *types.Block, 1)
+	block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(1), Difficulty: big.NewInt(100)})
+	mxtash.remote.workCh <- &sealTask{block: block, results: results}
+
+	sealhash := mxtash.SealHash(block.Header())
+	job := miner.waitJob(hex.EncodeToString(sealhash[:]))
+	if want := shareDifficulty(0.00000000036, block.Difficulty()); miner.difficulty != want {
+		t.Fatalf("share difficulty mismatch: have %v, want %v", miner.difficulty, want)
+	}
+	if seed := hex.EncodeToString(SeedHash(1)); miner.notify[1] != seed {
+		t.Fatalf("seed hash mismatch: have %v, want %v", miner.notify[1], seed)
+	}
+	// Submit a share solving the block, and ensure it gets sealed
+	nonce := mineStratumShare(mxtash, block, extranonce, new(big.Int).Div(two256, block.Difficulty()), true)
+	if result, failure := miner.call("mining.submit", "worker", job, nonce); failure != nil || string(result) != "true" {
+		t.Fatalf("block solution rejected: %s %v", result, failure)
+	}
+	select {
+	case sealed := <-results:
+		if err := mxtash.verifySeal(nil, sealed.Header(), false); err != nil {
+			t.Fatalf("invalid block sealed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("block solution not sealed")
+	}
+	// Duplicate, stale and low difficulty shares must be rejected
+	if _, failure := miner.call("mining.submit", "worker", job, nonce); failure == nil || failure[0] != float64(22) {
+		t.Fatalf("duplicate share error mismatch: have %v, want code 22", failure)
+	}
+	if _, failure := miner.call("mining.submit", "worker", "ffff", nonce); failure == nil || failure[0] != float64(21) {
+		t.Fatalf("stale share error mismatch: have %v, want code 21", failure)
+	}
+	invalid := mineStratumShare(mxtash, block, extranonce, shareTarget(miner.difficulty), false)
+	if _, failure := miner.call("mining.submit", "worker", job, invalid); failure == nil || failure[0] != float64(23) {
+		t.Fatalf("low difficulty share error mismatch: have %v, want code 23", failure)
+	}
+	stats := stratum.stats()["worker"]
+	if stats.Sessions != 1 || stats.Accepted != 1 || stats.Blocks != 1 || stats.Rejected != 2 || stats.Stale != 1 {
+		t.Fatalf("share accounting mismatch: %+v", stats)
+	}
+}
+
+// Tests the conversion of share difficulties to targets.
+func TestStratumShareTarget(t *testing.T) {
+	tests := []struct {
+		difficulty float64
+		target     *big.Int
+	}{
+		{1, new(big.Int).Lsh(big.NewInt(1), 224)},
+		{2, new(big.Int).Lsh(big.NewInt(1), 223)},
+		{0.5, new(big.Int).Lsh(big.NewInt(1), 225)},
+		{1.0 / (1 << 40), new(big.Int).Sub(two256, big.NewInt(1))},
+	}
+	for i, tt := range tests {
+		if target := shareTarget(tt.difficulty); target.Cmp(tt.target) != 0 {
+			t.Errorf("test %d: target mismatch: have %x, want %x", i, target, tt.target)
+		}
+	}
+	// Worker difficulties above the block's must be capped
+	block := new(big.Int).Lsh(big.NewInt(1), 33)
+	if difficulty := shareDifficulty(4, block); difficulty != 2 {
+		t.Errorf("capped difficulty mismatch: have %v, want 2", difficulty)
+	}
+	if difficulty := shareDifficulty(1, block); difficulty != 1 {
+		t.Errorf("difficulty mismatch: have %v, want 1", difficulty)
+	}
+}
+
+// Tests that the share difficulty of the workers can't be lowered below the
+// default one, that the shares of a miner are rate limited and that the server
+// fails to start on an address already in use.
+func TestStratumLimits(t *testing.T) {
+	mxtash := NewTester(nil, false)
+	defer mxtash.Close()
+
+	stratum, err := startStratumServer(mxtash, "127.0.0.1:0", 2)
+	if err != nil {
+		t.Fatalf("failed to start Stratum server: %v", err)
+	}
+	mxtash.stratum = stratum
+
+	miner := newStratumTestMiner(t, stratum.listener.Addr())
+	defer miner.conn.Close()
+
+	if _, failure := miner.call("mining.subscribe", "testminer", stratumProtocol); failure != nil {
+		t.Fatalf("failed to subscribe: %v", failure)
+	}
+	if _, failure := miner.call("mining.authorize", "worker", "x,d=0.5"); failure != nil {
+		t.Fatalf("failed to authorize: %v", failure)
+	}
+	if difficulty := stratum.stats()["worker"].Difficulty; difficulty != 2 {
+		t.Fatalf("share difficulty mismatch: have %v, want 2", difficulty)
+	}
+	if _, failure := miner.call("mining.authorize", "worker", "x,d=4"); failure != nil {
+		t.Fatalf("failed to authorize: %v", failure)
+	}
+	if difficulty := stratum.stats()["worker"].Difficulty; difficulty != 4 {
+		t.Fatalf("share difficulty mismatch: have %v, want 4", difficulty)
+	}
+	// Flood the server with shares, it must refuse the excess
+	sess := &stratumSession{submits: rate.NewLimiter(stratumSubmitRate, stratumSubmitBurst)}
+	limited := 0
+	for i := 0; i < 2*stratumSubmitBurst; i++ {
+		if _, err := stratum.submit(sess, []string{"worker", "ffff", "000000000000"}); err == errStratumRateLimited {
+			limited++
+		} else if err != errStratumUnauthorized {
+			t.Fatalf("share %d error mismatch: have %v, want %v", i, err, errStratumUnauthorized)
+		}
+	}
+	if limited == 0 || limited > stratumSubmitBurst {
+		t.Fatalf("rate limited share count mismatch: have %d, want 1-%d", limited, stratumSubmitBurst)
+	}
+	// Starting another server on the same address must fail
+	dup := New(Config{PowMode: ModeTest, StratumAddr: stratum.listener.Addr().String()}, nil, false)
+	defer dup.Close()
+	if err := dup.StartStratum(); err == nil {
+		t.Fatalf("Stratum server started on an address in use")
+	}
+}
@@ -0,0 +1,300 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"bytes"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/consensus/misc"
+	"github.com/mxt/go-mxt/core/rawdb"
+	"github.com/mxt/go-mxt/core/types"
+	"github.com/mxt/go-mxt/core/vm"
+	"github.com/mxt/go-mxt/mxtdb"
+	"github.com/mxt/go-mxt/log"
+)
+
+// AddressIndexer maintains an index of the transactions every account took part
+// in, either as sender, recipient, created contract or as a participant of an
+// internal call. It follows the canonical chain in the background, removing the
+// blocks reorged out before indexing the new ones.
+//
+// Internal calls are found by re-executing the blocks, which is only possible if
+// the state of their parent is available. Blocks indexed without state (e.g. old
+// blocks on a pruned node) only record the top level participants.
+type AddressIndexer struct {
+	chain *BlockChain
+	db    mxtdb.Database
+
+	stateless uint64 // Number of blocks indexed without internal calls, for logging
+
+	quit chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewAddressIndexer creates an address indexer following the given chain. The
+// indexer needs to be started with Start.
+func NewAddressIndexer(chain *BlockChain) *AddressIndexer {
+	return &AddressIndexer{
+		chain: chain,
+		db:    chain.db,
+		quit:  make(chan struct{}),
+	}
+}
+
+// Start launches the background indexing.
+func (ai *AddressIndexer) Start() {
+	ai.wg.Add(1)
+	go ai.loop()
+}
+
+// Close stops the background indexing and waits for it to terminate.
+func (ai *AddressIndexer) Close() {
+	close(ai.quit)
+	ai.wg.Wait()
+}
+
+// Transactions returns the hashes of the transactions the account took part in,
+// in chain order, skipping the first offset ones and returning at most limit.
+func (ai *AddressIndexer) Transactions(address common.Address, offset, limit uint64) []common.Hash {
+	return rawdb.ReadAddressTransactions(ai.db, address, offset, limit)
+}
+
+// loop brings the index up to date with the chain head every time it changes.
+func (ai *AddressIndexer) loop() {
+	defer ai.wg.Done()
+
+	headCh := make(chan ChainHeadEvent, 10)
+	sub := ai.chain.SubscribeChainHeadEvent(headCh)
+	defer sub.Unsubscribe()
+
+	for {
+		if !ai.sync() {
+			return
+		}
+		select {
+		case <-headCh:
+		case <-sub.Err():
+			return
+		case <-ai.quit:
+			return
+		}
+	}
+}
+
+// sync unindexes all the blocks that are no longer canonical and indexes all the
+// canonical blocks up to the current head. It returns false if interrupted.
+func (ai *AddressIndexer) sync() bool {
+	var (
+		hash, number = rawdb.ReadAddressIndexHead(ai.db)
+		batch        = ai.db.NewBatch()
+		start        = time.Now()
+		logged       = time.Now()
+	)
+	// Roll back all the indexed blocks reorged out of the canonical chain
+	if hash != (common.Hash{}) {
+		for {
+			canonical, stale := rawdb.ReadCanonicalHash(ai.db, number), false
+			for _, indexed := range rawdb.ReadAddressIndexHashes(ai.db, number) {
+				if indexed != canonical {
+					rawdb.DeleteAddressIndex(batch, indexed, number, rawdb.ReadAddressIndexEntries(ai.db, indexed, number))
+					stale = true
+				}
+			}
+			if !stale && canonical == hash {
+				break
+			}
+			if number == 0 {
+				hash = common.Hash{}
+				break
+			}
+			number, hash = number-1, rawdb.ReadCanonicalHash(ai.db, number-1)
+		}
+		if hash != (common.Hash{}) {
+			rawdb.WriteAddressIndexHead(batch, hash, number)
+		}
+		if err := batch.Write(); err != nil {
+			log.Crit("Failed to unindex reorged blocks", "err", err)
+		}
+		batch.Reset()
+	}
+	// Index the canonical chain up to the current head
+	next := number + 1
+	if hash == (common.Hash{}) {
+		next = 0
+	}
+	head := ai.chain.CurrentBlock().NumberU64()
+	defer func() {
+		if err := batch.Write(); err != nil {
+			log.Crit("Failed to write address index", "err", err)
+		}
+	}()
+	for ; next <= head; next++ {
+		select {
+		case <-ai.quit:
+			return false
+		default:
+		}
+		block := ai.chain.GetBlockByNumber(next)
+		if block == nil {
+			break // Head rewound meanwhile, retry on the next head event
+		}
+		ai.indexBlock(batch, block)
+		rawdb.WriteAddressIndexHead(batch, block.Hash(), block.NumberU64())
+
+		if batch.ValueSize() > mxtdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				log.Crit("Failed to write address index", "err", err)
+			}
+			batch.Reset()
+		}
+		if time.Since(logged) > 8*time.Second {
+			log.Info("Indexing transactions by address", "block", next, "head", head, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+	}
+	if ai.stateless > 0 {
+		log.Warn("Indexed blocks without internal calls, state unavailable", "blocks", ai.stateless)
+		ai.stateless = 0
+	}
+	return true
+}
+
+// indexBlock adds the address index entries of a block to the batch.
+func (ai *AddressIndexer) indexBlock(batch mxtdb.Batch, block *types.Block) {
+	var (
+		txs      = block.Transactions()
+		hashes   = make([]common.Hash, len(txs))
+		signer   = types.MakeSigner(ai.chain.Config(), block.Number())
+		receipts = ai.chain.GetReceiptsByHash(block.Hash())
+		internal = ai.internalCalls(block)
+		entries  []rawdb.AddressIndexEntry
+	)
+	for i, tx := range txs {
+		hashes[i] = tx.Hash()
+
+		participants := make(map[common.Address]struct{})
+		if from, err := types.Sender(signer, tx); err == nil {
+			participants[from] = struct{}{}
+		}
+		if to := tx.To(); to != nil {
+			participants[*to] = struct{}{}
+		}
+		if i < len(receipts) && receipts[i].ContractAddress != (common.Address{}) {
+			participants[receipts[i].ContractAddress] = struct{}{}
+		}
+		if internal != nil {
+			for addr := range internal[i] {
+				participants[addr] = struct{}{}
+			}
+		}
+		addrs := make([]common.Address, 0, len(participants))
+		for addr := range participants {
+			addrs = append(addrs, addr)
+		}
+		sort.Slice(addrs, func(i, j int) bool { return bytes.Compare(addrs[i][:], addrs[j][:]) < 0 })
+		for _, addr := range addrs {
+			entries = append(entries, rawdb.AddressIndexEntry{Address: addr, TxIndex: uint32(i)})
+		}
+	}
+	rawdb.WriteAddressIndex(batch, block.Hash(), block.NumberU64(), hashes, entries)
+}
+
+// internalCalls re-executes a block and returns the accounts taking part in the
+// internal calls of every transaction, or nil if the parent state is unavailable.
+func (ai *AddressIndexer) internalCalls(block *types.Block) []map[common.Address]struct{} {
+	if block.Transactions().Len() == 0 {
+		return nil
+	}
+	parent := ai.chain.GetHeader(block.ParentHash(), block.NumberU64()-1)
+	if parent == nil {
+		ai.stateless++
+		return nil
+	}
+	statedb, err := ai.chain.StateAt(parent.Root)
+	if err != nil {
+		ai.stateless++
+		return nil
+	}
+	var (
+		config  = ai.chain.Config()
+		header  = block.Header()
+		gp      = new(GasPool).AddGas(block.GasLimit())
+		usedGas = new(uint64)
+		calls   = make([]map[common.Address]struct{}, len(block.Transactions()))
+	)
+	if config.DAOForkSupport && config.DAOForkBlock != nil && config.DAOForkBlock.Cmp(block.Number()) == 0 {
+		misc.ApplyDAOHardFork(statedb)
+	}
+	for i, tx := range block.Transactions() {
+		tracer := &callParticipantTracer{participants: make(map[common.Address]struct{})}
+
+		statedb.Prepare(tx.Hash(), block.Hash(), i)
+		if _, err := ApplyTransaction(config, ai.chain, nil, gp, statedb, header, tx, usedGas, vm.Config{Debug: true, Tracer: tracer}); err != nil {
+			log.Error("Failed to re-execute block for address index", "number", block.Number(), "hash", block.Hash(), "err", err)
+			return nil
+		}
+		calls[i] = tracer.participants
+	}
+	return calls
+}
+
+// callParticipantTracer is a vm.Tracer collecting every account called, created
+// or receiving funds during the execution of a transaction.
+type callParticipantTracer struct {
+	participants map[common.Address]struct{}
+}
+
+func (t *callParticipantTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	t.participants[from] = struct{}{}
+	t.participants[to] = struct{}{}
+	return nil
+}
+
+func (t *callParticipantTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, rStack *vm.ReturnStack, rData []byte, contract *vm.Contract, depth int, err error) error {
+	if err != nil {
+		return nil
+	}
+	size := len(stack.Data())
+	switch op {
+	case vm.CALL, vm.CALLCODE, vm.DELEGATECALL, vm.STATICCALL:
+		if size >= 2 {
+			t.participants[common.Address(stack.Back(1).Bytes20())] = struct{}{}
+		}
+	case vm.SELFDESTRUCT:
+		if size >= 1 {
+			t.participants[common.Address(stack.Back(0).Bytes20())] = struct{}{}
+		}
+	case vm.CREATE, vm.CREATE2:
+		if addr, ok := vm.CreatedAddress(env, op, memory, stack, contract); ok {
+			t.participants[addr] = struct{}{}
+		}
+	}
+	return nil
+}
+
+func (t *callParticipantTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, rStack *vm.ReturnStack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+func (t *callParticipantTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	return nil
+}
@@ -0,0 +1,130 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/consensus/mxtash"
+	"github.com/mxt/go-mxt/core/rawdb"
+	"github.com/mxt/go-mxt/core/types"
+	"github.com/mxt/go-mxt/core/vm"
+	"github.com/mxt/go-mxt/crypto"
+	"github.com/mxt/go-mxt/params"
+)
+
+// Tests that the address indexer records direct and internal call participants
+// and follows the canonical chain across reorgs.
+func TestAddressIndexer(t *testing.T) {
+	var (
+		key, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		alice  = crypto.PubkeyToAddress(key.PublicKey)
+		bob    = common.Address{0xb0}
+		carol  = common.Address{0xc0}
+		proxy  = common.Address{0xaa}
+		target = common.Address{0xbb}
+
+		// Code of the proxy, calling the target with no value nor data
+		code = append(append([]byte{
+			byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0, byte(vm.PUSH1), 0,
+			byte(vm.PUSH20)}, target.Bytes()...),
+			byte(vm.GAS), byte(vm.CALL), byte(vm.STOP),
+		)
+		db    = rawdb.NewMemoryDatabase()
+		gspec = &Genesis{
+			Config: params.TestChainConfig,
+			Alloc: GenesisAlloc{
+				alice: {Balance: big.NewInt(1000000000000000000)},
+				proxy: {Balance: big.NewInt(0), Code: code},
+			},
+		}
+		genesis = gspec.MustCommit(db)
+		signer  = types.NewEIP155Signer(gspec.Config.ChainID)
+	)
+	transfer := func(block *BlockGen, to common.Address) *types.Transaction {
+		tx, err := types.SignTx(types.NewTransaction(block.TxNonce(alice), to, big.NewInt(1000), 100000, nil, nil), signer, key)
+		if err != nil {
+			t.Fatalf("failed to sign transaction: %v", err)
+		}
+		block.AddTx(tx)
+		return tx
+	}
+	var txProxy, txBob, txCarol *types.Transaction
+	blocks, _ := GenerateChain(gspec.Config, genesis, mxtash.NewFaker(), db, 3, func(i int, block *BlockGen) {
+		switch i {
+		case 0:
+			txProxy = transfer(block, proxy)
+		case 1:
+			txBob = transfer(block, bob)
+		}
+	})
+	forks, _ := GenerateChain(gspec.Config, blocks[0], mxtash.NewFaker(), db, 3, func(i int, block *BlockGen) {
+		block.SetCoinbase(common.Address{0x01})
+		if i == 0 {
+			txCarol = transfer(block, carol)
+		}
+	})
+	chain, err := NewBlockChain(db, nil, gspec.Config, mxtash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer chain.Stop()
+
+	indexer := NewAddressIndexer(chain)
+	check := func(addr common.Address, want ...*types.Transaction) {
+		t.Helper()
+		have := indexer.Transactions(addr, 0, 100)
+		if len(have) != len(want) {
+			t.Fatalf("%x: transaction count mismatch: have %d, want %d", addr, len(have), len(want))
+		}
+		for i, tx := range want {
+			if have[i] != tx.Hash() {
+				t.Errorf("%x: transaction %d mismatch: have %x, want %x", addr, i, have[i], tx.Hash())
+			}
+		}
+	}
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	indexer.sync()
+	check(alice, txProxy, txBob)
+	check(proxy, txProxy)
+	check(target, txProxy)
+	check(bob, txBob)
+	check(carol)
+
+	// Reorg to the longer fork and ensure the dropped transactions are unindexed
+	if _, err := chain.InsertChain(forks); err != nil {
+		t.Fatalf("failed to insert fork: %v", err)
+	}
+	indexer.sync()
+	check(alice, txProxy, txCarol)
+	check(target, txProxy)
+	check(bob)
+	check(carol, txCarol)
+
+	if hash, number := rawdb.ReadAddressIndexHead(db); hash != forks[2].Hash() || number != forks[2].NumberU64() {
+		t.Fatalf("index head mismatch: have %x/%d, want %x/%d", hash, number, forks[2].Hash(), forks[2].NumberU64())
+	}
+	// Rewind below the fork point and ensure the index follows
+	chain.SetHead(1)
+	indexer.sync()
+	check(alice, txProxy)
+	check(carol)
+}
@@ -23,6 +23,7 @@ import (
 	"io"
 	"math/big"
 	mrand "math/rand"
+	"reflect"
 	"sort"
 	"sync"
 	"sync/atomic"
@@ -75,6 +76,7 @@ var (
 	blockReorgAddMeter      = metrics.NewRegisteredMeter("chain/reorg/add", nil)
 	blockReorgDropMeter     = metrics.NewRegisteredMeter("chain/reorg/drop", nil)
 	blockReorgInvalidatedTx = metrics.NewRegisteredMeter("chain/reorg/invalidTx", nil)
+	blockReorgDepthHist     = metrics.NewRegisteredHistogram("chain/reorg/depth", nil, metrics.NewExpDecaySample(1028, 0.015))
 
 	blockPrefetchExecuteTimer   = metrics.NewRegisteredTimer("chain/prefetch/executes", nil)
 	blockPrefetchInterruptMeter = metrics.NewRegisteredMeter("chain/prefetch/interrupts", nil)
@@ -158,8 +160,7 @@ var defaultCacheConfig = &CacheConfig{
 // included in the canonical one where as GetBlockByNumber always represents the
 // canonical chain.
 type BlockChain struct {
-	chainConfig *params.ChainConfig // Chain & network configuration
-	cacheConfig *CacheConfig        // Cache configuration for pruning
+	cacheConfig *CacheConfig // Cache configuration for pruning
 
 	db     mxtdb.Database // Low level persistent database to store final content in
 	snaps  *snapshot.Tree // Snapshot tree for fast trie leaf access
@@ -228,7 +229,6 @@ func NewBlockChain(db mxtdb.Database, cacheConfig *CacheConfig, chainConfig *par
 	badBlocks, _ := lru.New(badBlockLimit)
 
 	bc := &BlockChain{
-		chainConfig:    chainConfig,
 		cacheConfig:    cacheConfig,
 		db:             db,
 		triegc:         prque.New(nil),
@@ -245,9 +245,9 @@ func NewBlockChain(db mxtdb.Database, cacheConfig *CacheConfig, chainConfig *par
 		vmConfig:       vmConfig,
 		badBlocks:      badBlocks,
 	}
-	bc.validator = NewBlockValidator(chainConfig, bc, engine)
-	bc.prefetcher = newStatePrefetcher(chainConfig, bc, engine)
-	bc.processor = NewStateProcessor(chainConfig, bc, engine)
+	bc.validator = NewBlockValidator(bc, engine)
+	bc.prefetcher = newStatePrefetcher(bc, engine)
+	bc.processor = NewStateProcessor(bc, engine)
 
 	var err error
 	bc.hc, err = NewHeaderChain(db, chainConfig, engine, bc.insertStopped)
@@ -447,6 +447,11 @@ func (bc *BlockChain) SetHead(head uint64) error {
 	bc.chainmu.Lock()
 	defer bc.chainmu.Unlock()
 
+	return bc.setHead(head)
+}
+
+// setHead is the lock free version of SetHead, the caller must hold chainmu.
+func (bc *BlockChain) setHead(head uint64) error {
 	// Retrieve the last pivot block to short circuit rollbacks beyond it and the
 	// current freezer limit to start nuking id underflown
 	pivot := rawdb.ReadLastPivotNumber(bc.db)
@@ -558,6 +563,47 @@ func (bc *BlockChain) SetHead(head uint64) error {
 	return bc.loadLastState()
 }
 
+// SetChainConfig replaces the chain configuration with newcfg, rewinding the
+// chain if the new fork schedule conflicts with the already imported blocks.
+// The chain id and the consensus engine settings are baked into the signer and
+// the engine at startup, so changes to them are refused.
+func (bc *BlockChain) SetChainConfig(newcfg *params.ChainConfig) error {
+	if err := newcfg.CheckConfigForkOrder(); err != nil {
+		return err
+	}
+	// Hold the insertion lock until the new config is live, otherwise blocks
+	// imported after the rewind would still be validated with the old one
+	bc.chainmu.Lock()
+	defer bc.chainmu.Unlock()
+
+	oldcfg := bc.Config()
+	if !reflect.DeepEqual(oldcfg.ChainID, newcfg.ChainID) {
+		return fmt.Errorf("chain id cannot be changed: have %v, want %v", oldcfg.ChainID, newcfg.ChainID)
+	}
+	if !reflect.DeepEqual(oldcfg.Ethash, newcfg.Ethash) || !reflect.DeepEqual(oldcfg.Clique, newcfg.Clique) || !reflect.DeepEqual(oldcfg.IBFT, newcfg.IBFT) {
+		return errors.New("consensus engine config cannot be changed")
+	}
+
+	head := bc.CurrentHeader()
+	if compat := oldcfg.CheckCompatible(newcfg, head.Number.Uint64(), head.Time); compat != nil {
+		rewind := compat.RewindTo
+		if compat.RewindToTime > 0 {
+			rewind = rewindTargetByTime(bc.db, head, compat.RewindToTime)
+		}
+		if rewind == 0 {
+			return fmt.Errorf("configuration would rewind the chain to genesis: %v", compat)
+		}
+		log.Warn("Rewinding chain to upgrade configuration", "err", compat, "number", rewind)
+		if err := bc.setHead(rewind); err != nil {
+			return err
+		}
+	}
+	config := *newcfg
+	bc.hc.config.Store(&config)
+	rawdb.WriteChainConfig(bc.db, bc.genesisBlock.Hash(), &config)
+	return nil
+}
+
 // FastSyncCommitHead sets the current head block to the one defined by the hash
 // irrelevant what the chain contents were prior.
 func (bc *BlockChain) FastSyncCommitHead(hash common.Hash) error {
@@ -862,7 +908,7 @@ func (bc *BlockChain) GetReceiptsByHash(hash common.Hash) types.Receipts {
 	if number == nil {
 		return nil
 	}
-	receipts := rawdb.ReadReceipts(bc.db, hash, *number, bc.chainConfig)
+	receipts := rawdb.ReadReceipts(bc.db, hash, *number, bc.Config())
 	if receipts == nil {
 		return nil
 	}
@@ -1162,7 +1208,7 @@ func (bc *BlockChain) InsertReceiptChain(blockChain types.Blocks, receiptChain [
 				}
 				h := rawdb.ReadCanonicalHash(bc.db, frozen)
 				b := rawdb.ReadBlock(bc.db, h, frozen)
-				size += rawdb.WriteAncientBlock(bc.db, b, rawdb.ReadReceipts(bc.db, h, frozen, bc.chainConfig), rawdb.ReadTd(bc.db, h, frozen))
+				size += rawdb.WriteAncientBlock(bc.db, b, rawdb.ReadReceipts(bc.db, h, frozen, bc.Config()), rawdb.ReadTd(bc.db, h, frozen))
 				count += 1
 
 				// Always keep genesis block in active database.
@@ -1459,7 +1505,7 @@ func (bc *BlockChain) writeBlockWithState(block *types.Block, receipts []*types.
 		log.Crit("Failed to write block into disk", "err", err)
 	}
 	// Commit all cached state changes into underlying memory database.
-	root, err := state.Commit(bc.chainConfig.IsEIP158(block.Number()))
+	root, err := state.Commit(bc.Config().IsEIP158(block.Number()))
 	if err != nil {
 		return NonStatTy, err
 	}
@@ -1639,7 +1685,7 @@ func (bc *BlockChain) insertChain(chain types.Blocks, verifySeals bool) (int, er
 		return 0, nil
 	}
 	// Start a parallel signature recovery (signer will fluke on fork transition, minimal perf loss)
-	senderCacher.recoverFromBlocks(types.MakeSigner(bc.chainConfig, chain[0].Number()), chain)
+	senderCacher.recoverFromBlocks(types.MakeSigner(bc.Config(), chain[0].Number()), chain)
 
 	var (
 		stats     = insertStats{startTime: mclock.Now()}
@@ -1755,7 +1801,7 @@ func (bc *BlockChain) insertChain(chain types.Blocks, verifySeals bool) (int, er
 		// its header and body was already in the database).
 		if err == ErrKnownBlock {
 			logger := log.Debug
-			if bc.chainConfig.Clique == nil {
+			if bc.Config().Clique == nil {
 				logger = log.Warn
 			}
 			logger("Inserted known block", "number", block.Number(), "hash", block.Hash(),
@@ -2063,7 +2109,7 @@ func (bc *BlockChain) reorg(oldBlock, newBlock *types.Block) error {
 			if number == nil {
 				return
 			}
-			receipts := rawdb.ReadReceipts(bc.db, hash, *number, bc.chainConfig)
+			receipts := rawdb.ReadReceipts(bc.db, hash, *number, bc.Config())
 
 			var logs []*types.Log
 			for _, receipt := range receipts {
@@ -2156,6 +2202,7 @@ func (bc *BlockChain) reorg(oldBlock, newBlock *types.Block) error {
 			"drop", len(oldChain), "dropfrom", oldChain[0].Hash(), "add", len(newChain), "addfrom", newChain[0].Hash())
 		blockReorgAddMeter.Mark(int64(len(newChain)))
 		blockReorgDropMeter.Mark(int64(len(oldChain)))
+		blockReorgDepthHist.Update(int64(len(oldChain)))
 		blockReorgMeter.Mark(1)
 	} else {
 		log.Error("Impossible reorg, please file an issue", "oldnum", oldBlock.Number(), "oldhash", oldBlock.Hash(), "newnum", newBlock.Number(), "newhash", newBlock.Hash())
@@ -2344,7 +2391,7 @@ Hash: 0x%x
 
 Error: %v
 ##############################
-`, bc.chainConfig, block.Number(), block.Hash(), receiptString, err))
+`, bc.Config(), block.Number(), block.Hash(), receiptString, err))
 }
 
 // InsertHeaderChain attempts to insert the given header chain in to the local
@@ -2454,7 +2501,7 @@ func (bc *BlockChain) GetTransactionLookup(hash common.Hash) *rawdb.LegacyTxLook
 }
 
 // Config retrieves the chain's fork configuration.
-func (bc *BlockChain) Config() *params.ChainConfig { return bc.chainConfig }
+func (bc *BlockChain) Config() *params.ChainConfig { return bc.hc.Config() }
 
 // Engine retrieves the blockchain's consensus engine.
 func (bc *BlockChain) Engine() consensus.Engine { return bc.engine }
@@ -510,7 +510,7 @@ func testReorgBadHashes(t *testing.T, full bool) {
 	blockchain.Stop()
 
 	// Create a new BlockChain and check that it rolled back the state.
-	ncm, err := NewBlockChain(blockchain.db, nil, blockchain.chainConfig, mxtash.NewFaker(), vm.Config{}, nil, nil)
+	ncm, err := NewBlockChain(blockchain.db, nil, blockchain.Config(), mxtash.NewFaker(), vm.Config{}, nil, nil)
 	if err != nil {
 		t.Fatalf("failed to create new chain manager: %v", err)
 	}
@@ -1215,7 +1215,7 @@ func TestCanonicalBlockRetrieval(t *testing.T) {
 	}
 	defer blockchain.Stop()
 
-	chain, _ := GenerateChain(blockchain.chainConfig, blockchain.genesisBlock, mxtash.NewFaker(), blockchain.db, 10, func(i int, gen *BlockGen) {})
+	chain, _ := GenerateChain(blockchain.Config(), blockchain.genesisBlock, mxtash.NewFaker(), blockchain.db, 10, func(i int, gen *BlockGen) {})
 
 	var pend sync.WaitGroup
 	pend.Add(len(chain))
@@ -3029,3 +3029,82 @@ func TestInitThenFailCreateContract(t *testing.T) {
 		}
 	}
 }
+
+// Tests that the chain config can be rescheduled on a live chain, rewinding it
+// before any block the new fork schedule conflicts with.
+func TestSetChainConfig(t *testing.T) {
+	var (
+		db      = rawdb.NewMemoryDatabase()
+		config  = *params.AllEthashProtocolChanges
+		genesis = (&Genesis{Config: &config}).MustCommit(db)
+	)
+	chain, err := NewBlockChain(db, nil, &config, mxtash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create tester chain: %v", err)
+	}
+	defer chain.Stop()
+
+	// Blocks are 10 seconds apart, the head being at timestamp 100
+	blocks, _ := GenerateChain(&config, genesis, mxtash.NewFaker(), db, 10, nil)
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	// Scheduling a fork in the future keeps the chain intact
+	newcfg := config
+	future := uint64(1000)
+	newcfg.YoloV1Time = &future
+	if err := chain.SetChainConfig(&newcfg); err != nil {
+		t.Fatalf("failed to schedule future fork: %v", err)
+	}
+	if head := chain.CurrentBlock().NumberU64(); head != 10 {
+		t.Errorf("chain head mismatch: have %d, want 10", head)
+	}
+	if stored := rawdb.ReadChainConfig(db, genesis.Hash()); stored.YoloV1Time == nil || *stored.YoloV1Time != future {
+		t.Errorf("stored fork time mismatch: have %v, want %d", stored.YoloV1Time, future)
+	}
+	// Scheduling a fork in the past rewinds the chain to before it
+	past := uint64(45)
+	newcfg.YoloV1Time = &past
+	if err := chain.SetChainConfig(&newcfg); err != nil {
+		t.Fatalf("failed to schedule past fork: %v", err)
+	}
+	if head := chain.CurrentBlock().NumberU64(); head != 4 {
+		t.Errorf("chain head mismatch: have %d, want 4", head)
+	}
+	if !chain.Config().IsYoloV1(big.NewInt(5), 50) {
+		t.Errorf("fork not active in chain config")
+	}
+	if config.YoloV1Time != nil {
+		t.Errorf("original chain config modified")
+	}
+	// Schedules conflicting with the first block would wipe the chain
+	early := uint64(5)
+	newcfg.YoloV1Time = &early
+	if err := chain.SetChainConfig(&newcfg); err == nil {
+		t.Errorf("rewind to genesis accepted")
+	}
+	if head := chain.CurrentBlock().NumberU64(); head != 4 {
+		t.Errorf("chain head mismatch: have %d, want 4", head)
+	}
+	newcfg.YoloV1Time = &past
+	// Invalid fork schedules are rejected
+	newcfg.YoloV1Block = big.NewInt(1)
+	if err := chain.SetChainConfig(&newcfg); err == nil {
+		t.Errorf("fork scheduled at both block and timestamp accepted")
+	}
+	newcfg.YoloV1Block = nil
+	// Settings baked into the signer and the engine cannot be changed
+	newcfg.ChainID = big.NewInt(1)
+	if err := chain.SetChainConfig(&newcfg); err == nil {
+		t.Errorf("chain id change accepted")
+	}
+	newcfg.ChainID = config.ChainID
+	newcfg.Clique = &params.CliqueConfig{Period: 1, Epoch: 30000}
+	if err := chain.SetChainConfig(&newcfg); err == nil {
+		t.Errorf("consensus engine change accepted")
+	}
+	newcfg.Clique = nil
+	if err := chain.SetChainConfig(&newcfg); err != nil {
+		t.Errorf("failed to reapply unchanged config: %v", err)
+	}
+}
@@ -19,9 +19,11 @@ package forkid
 import (
 	"bytes"
 	"math"
+	"math/big"
 	"testing"
 
 	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/core/types"
 	"github.com/mxt/go-mxt/params"
 	"github.com/mxt/go-mxt/rlp"
 )
@@ -118,7 +120,7 @@ func TestCreation(t *testing.T) {
 	}
 	for i, tt := range tests {
 		for j, ttt := range tt.cases {
-			if have := NewID(tt.config, tt.genesis, ttt.head); have != ttt.want {
+			if have := NewID(tt.config, tt.genesis, ttt.head, 0); have != ttt.want {
 				t.Errorf("test %d, case %d: fork ID mismatch: have %x, want %x", i, j, have, ttt.want)
 			}
 		}
@@ -196,13 +198,105 @@ func TestValidation(t *testing.T) {
 		{7279999, ID{Hash: checksumToBytes(0xa00bc324), Next: 7279999}, ErrLocalIncompatibleOrStale},
 	}
 	for i, tt := range tests {
-		filter := newFilter(params.MainnetChainConfig, params.MainnetGenesisHash, func() uint64 { return tt.head })
+		filter := newFilter(params.MainnetChainConfig, params.MainnetGenesisHash, func() (uint64, uint64) { return tt.head, 0 })
 		if err := filter(tt.id); err != tt.err {
 			t.Errorf("test %d: validation error mismatch: have %v, want %v", i, err, tt.err)
 		}
 	}
 }
 
+// Tests that forks scheduled by timestamp are checksummed after the block based
+// ones and validated against the local head time.
+func TestTimestampForks(t *testing.T) {
+	config := *params.GoerliChainConfig
+	fork := uint64(1600000000)
+	config.YoloV1Time = &fork
+
+	var (
+		istanbul = checksumToBytes(0xc25efa5c)
+		yolov1   = checksumToBytes(checksumUpdate(0xc25efa5c, fork))
+	)
+	creations := []struct {
+		head, time uint64
+		want       ID
+	}{
+		{0, 0, ID{Hash: checksumToBytes(0xa3f5ab08), Next: 1561651}}, // Block forks are announced first
+		{2000000, fork - 1, ID{Hash: istanbul, Next: fork}},          // Last block before the time fork
+		{2000000, fork, ID{Hash: yolov1, Next: 0}},                   // First block after the time fork
+	}
+	for i, tt := range creations {
+		if have := NewID(&config, params.GoerliGenesisHash, tt.head, tt.time); have != tt.want {
+			t.Errorf("creation %d: fork ID mismatch: have %x, want %x", i, have, tt.want)
+		}
+	}
+	validations := []struct {
+		head, time uint64
+		id         ID
+		err        error
+	}{
+		// Local and remote are both before the time fork, and know about it
+		{2000000, fork - 1, ID{Hash: istanbul, Next: fork}, nil},
+
+		// Local is before the time fork, remote does not know about it
+		{2000000, fork - 1, ID{Hash: istanbul, Next: 0}, nil},
+
+		// Local is past the time fork, remote is syncing but knows about it
+		{2000000, fork + 1, ID{Hash: istanbul, Next: fork}, nil},
+
+		// Local is past the time fork, remote does not know about it and needs an update
+		{2000000, fork + 1, ID{Hash: istanbul, Next: 0}, ErrRemoteStale},
+
+		// Local is still syncing blocks, remote is already past the time fork
+		{1561651, fork - 1, ID{Hash: yolov1, Next: 0}, nil},
+
+		// Remote announces a time fork that has already passed locally without us
+		// knowing about it
+		{2000000, fork - 1, ID{Hash: istanbul, Next: fork - 2}, ErrLocalIncompatibleOrStale},
+	}
+	for i, tt := range validations {
+		filter := newFilter(&config, params.GoerliGenesisHash, func() (uint64, uint64) { return tt.head, tt.time })
+		if err := filter(tt.id); err != tt.err {
+			t.Errorf("validation %d: validation error mismatch: have %v, want %v", i, err, tt.err)
+		}
+	}
+}
+
+// testChain is a Blockchain whose configuration can be replaced.
+type testChain struct {
+	config  *params.ChainConfig
+	genesis *types.Block
+	head    *types.Header
+}
+
+func (c *testChain) Config() *params.ChainConfig  { return c.config }
+func (c *testChain) Genesis() *types.Block        { return c.genesis }
+func (c *testChain) CurrentHeader() *types.Header { return c.head }
+
+// Tests that chain filters follow the replacements of the chain configuration.
+func TestFilterConfigChange(t *testing.T) {
+	fork := uint64(1600000000)
+	chain := &testChain{
+		config:  params.GoerliChainConfig,
+		genesis: types.NewBlockWithHeader(&types.Header{Number: common.Big0}),
+		head:    &types.Header{Number: big.NewInt(2000000), Time: fork + 1},
+	}
+	filter := NewFilter(chain)
+
+	// Remote is at the same fork, without any announced fork to come
+	id := NewID(chain.config, chain.genesis.Hash(), 2000000, fork+1)
+	if err := filter(id); err != nil {
+		t.Fatalf("compatible fork ID rejected: %v", err)
+	}
+	// Schedule a passed time fork, the remote not knowing about it becomes stale
+	config := *params.GoerliChainConfig
+	config.YoloV1Time = &fork
+	chain.config = &config
+
+	if err := filter(id); err != ErrRemoteStale {
+		t.Fatalf("validation error mismatch: have %v, want %v", err, ErrRemoteStale)
+	}
+}
+
 // Tests that IDs are properly RLP encoded (specifically important because we
 // use uint32 to store the hash, but we need to encode it as [4]byte).
 func TestEncoding(t *testing.T) {
@@ -153,15 +153,44 @@ func (e *GenesisMismatchError) Error() string {
 //
 // The returned chain configuration is never nil.
 func SetupGenesisBlock(db mxtdb.Database, genesis *Genesis) (*params.ChainConfig, common.Hash, error) {
+	return SetupGenesisBlockWithOverride(db, genesis, nil)
+}
+
+// SetupGenesisBlockWithOverride is like SetupGenesisBlock, but reschedules the
+// forks of the chain configuration in use, keyed by their JSON field names (see
+// params.ChainConfig.SetFork), to block numbers or timestamps.
+func SetupGenesisBlockWithOverride(db mxtdb.Database, genesis *Genesis, overrides map[string]uint64) (*params.ChainConfig, common.Hash, error) {
 	if genesis != nil && genesis.Config == nil {
 		return params.AllEthashProtocolChanges, common.Hash{}, errGenesisNoConfig
 	}
+	// Apply the fork overrides to a copy of the genesis spec, never to the caller's
+	override := func(genesis *Genesis) (*Genesis, error) {
+		if len(overrides) == 0 {
+			return genesis, nil
+		}
+		config, err := overrideForks(genesis.Config, overrides)
+		if err != nil {
+			return nil, err
+		}
+		cpy := *genesis
+		cpy.Config = config
+		return &cpy, nil
+	}
+	if genesis != nil {
+		var err error
+		if genesis, err = override(genesis); err != nil {
+			return params.AllEthashProtocolChanges, common.Hash{}, err
+		}
+	}
 	// Just commit the new block if there is no stored genesis block.
 	stored := rawdb.ReadCanonicalHash(db, 0)
 	if (stored == common.Hash{}) {
 		if genesis == nil {
 			log.Info("Writing default main-net genesis block")
-			genesis = DefaultGenesisBlock()
+			var err error
+			if genesis, err = override(DefaultGenesisBlock()); err != nil {
+				return params.AllEthashProtocolChanges, common.Hash{}, err
+			}
 		} else {
 			log.Info("Writing custom genesis block")
 		}
@@ -177,7 +206,9 @@ func SetupGenesisBlock(db mxtdb.Database, genesis *Genesis) (*params.ChainConfig
 	header := rawdb.ReadHeader(db, stored, 0)
 	if _, err := state.New(header.Root, state.NewDatabaseWithCache(db, 0, ""), nil); err != nil {
 		if genesis == nil {
-			genesis = DefaultGenesisBlock()
+			if genesis, err = override(DefaultGenesisBlock()); err != nil {
+				return params.AllEthashProtocolChanges, common.Hash{}, err
+			}
 		}
 		// Ensure the stored genesis matches with the given one.
 		hash := genesis.ToBlock(nil).Hash()
@@ -201,6 +232,12 @@ func SetupGenesisBlock(db mxtdb.Database, genesis *Genesis) (*params.ChainConfig
 
 	// Get the existing chain configuration.
 	newcfg := genesis.configOrDefault(stored)
+	if genesis == nil && len(overrides) > 0 {
+		var err error
+		if newcfg, err = overrideForks(newcfg, overrides); err != nil {
+			return params.AllEthashProtocolChanges, common.Hash{}, err
+		}
+	}
 	if err := newcfg.CheckConfigForkOrder(); err != nil {
 		return newcfg, common.Hash{}, err
 	}
@@ -212,18 +249,35 @@ func SetupGenesisBlock(db mxtdb.Database, genesis *Genesis) (*params.ChainConfig
 	}
 	// Special case: don't change the existing config of a non-mainnet chain if no new
 	// config is supplied. These chains would get AllProtocolChanges (and a compat error)
-	// if we just continued here.
+	// if we just continued here. Fork overrides are still applied on top of it.
 	if genesis == nil && stored != params.MainnetGenesisHash {
-		return storedcfg, stored, nil
+		if len(overrides) == 0 {
+			return storedcfg, stored, nil
+		}
+		var err error
+		if newcfg, err = overrideForks(storedcfg, overrides); err != nil {
+			return storedcfg, stored, err
+		}
+		if err := newcfg.CheckConfigForkOrder(); err != nil {
+			return newcfg, common.Hash{}, err
+		}
 	}
 
 	// Check config compatibility and write the config. Compatibility errors
 	// are returned to the caller unless we're already at block zero.
-	height := rawdb.ReadHeaderNumber(db, rawdb.ReadHeadHeaderHash(db))
+	headHash := rawdb.ReadHeadHeaderHash(db)
+	height := rawdb.ReadHeaderNumber(db, headHash)
 	if height == nil {
 		return newcfg, stored, fmt.Errorf("missing block number for head header hash")
 	}
-	compatErr := storedcfg.CheckCompatible(newcfg, *height)
+	head := rawdb.ReadHeader(db, headHash, *height)
+	if head == nil {
+		return newcfg, stored, fmt.Errorf("missing head header %x", headHash)
+	}
+	compatErr := storedcfg.CheckCompatible(newcfg, *height, head.Time)
+	if compatErr != nil && compatErr.RewindToTime > 0 {
+		compatErr.RewindTo = rewindTargetByTime(db, head, compatErr.RewindToTime)
+	}
 	if compatErr != nil && *height != 0 && compatErr.RewindTo != 0 {
 		return newcfg, stored, compatErr
 	}
@@ -231,6 +285,34 @@ func SetupGenesisBlock(db mxtdb.Database, genesis *Genesis) (*params.ChainConfig
 	return newcfg, stored, nil
 }
 
+// overrideForks returns a copy of config with the given forks rescheduled.
+func overrideForks(config *params.ChainConfig, overrides map[string]uint64) (*params.ChainConfig, error) {
+	cpy := *config
+	for name, at := range overrides {
+		at := at
+		if err := cpy.SetFork(name, &at); err != nil {
+			return nil, err
+		}
+		log.Info("Overriding chain config fork", "fork", name, "at", at)
+	}
+	return &cpy, nil
+}
+
+// rewindTargetByTime walks the canonical chain back from head and returns the
+// number of the last block not past the given timestamp, which is where the
+// chain needs to be rewound to undo a timestamp scheduled fork.
+func rewindTargetByTime(db mxtdb.Database, head *types.Header, time uint64) uint64 {
+	for head.Number.Uint64() > 0 && head.Time > time {
+		number := head.Number.Uint64() - 1
+		parent := rawdb.ReadHeader(db, head.ParentHash, number)
+		if parent == nil {
+			return number
+		}
+		head = parent
+	}
+	return head.Number.Uint64()
+}
+
 func (g *Genesis) configOrDefault(ghash common.Hash) *params.ChainConfig {
 	switch {
 	case g != nil:
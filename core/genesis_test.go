@@ -53,6 +53,17 @@ func TestSetupGenesis(t *testing.T) {
 		oldcustomg = customg
 	)
 	oldcustomg.Config = &params.ChainConfig{HomesteadBlock: big.NewInt(2)}
+
+	// Genesis blocks scheduling YOLOv1 by timestamp, at different times
+	var (
+		oldtimeg, timeg  = customg, customg
+		oldtime, newtime = uint64(15), uint64(25)
+	)
+	oldtimecfg, timecfg := *params.AllEthashProtocolChanges, *params.AllEthashProtocolChanges
+	oldtimecfg.YoloV1Time, timecfg.YoloV1Time = &oldtime, &newtime
+	oldtimeg.Config, timeg.Config = &oldtimecfg, &timecfg
+	timeghash := timeg.ToBlock(nil).Hash()
+
 	tests := []struct {
 		name       string
 		fn         func(mxtdb.Database) (*params.ChainConfig, common.Hash, error)
@@ -138,6 +149,33 @@ func TestSetupGenesis(t *testing.T) {
 				RewindTo:     1,
 			},
 		},
+		{
+			name: "incompatible timestamp fork in DB",
+			fn: func(db mxtdb.Database) (*params.ChainConfig, common.Hash, error) {
+				// Commit the 'old' genesis block with YOLOv1 at timestamp 15. Advance
+				// to block #4 at timestamp 40, past the fork time of timeg.
+				genesis := oldtimeg.MustCommit(db)
+
+				bc, _ := NewBlockChain(db, nil, oldtimeg.Config, mxtash.NewFullFaker(), vm.Config{}, nil, nil)
+				defer bc.Stop()
+
+				blocks, _ := GenerateChain(oldtimeg.Config, genesis, mxtash.NewFaker(), db, 4, nil)
+				bc.InsertChain(blocks)
+
+				// This should return a compatibility error rewinding to the last
+				// block before timestamp 15, block #1 at timestamp 10.
+				return SetupGenesisBlock(db, &timeg)
+			},
+			wantHash:   timeghash,
+			wantConfig: timeg.Config,
+			wantErr: &params.ConfigCompatError{
+				What:         "YOLOv1 fork timestamp",
+				StoredTime:   &oldtime,
+				NewTime:      &newtime,
+				RewindTo:     1,
+				RewindToTime: 14,
+			},
+		},
 	}
 
 	for _, test := range tests {
@@ -162,3 +200,37 @@ func TestSetupGenesis(t *testing.T) {
 		}
 	}
 }
+
+// Tests that fork overrides are applied to both new and stored chain configs.
+func TestSetupGenesisOverride(t *testing.T) {
+	var (
+		db        = rawdb.NewMemoryDatabase()
+		genesis   = DefaultGoerliGenesisBlock()
+		overrides = map[string]uint64{"yoloV1Time": 1000}
+	)
+	config, _, err := SetupGenesisBlockWithOverride(db, genesis, overrides)
+	if err != nil {
+		t.Fatalf("failed to setup genesis: %v", err)
+	}
+	if config.YoloV1Time == nil || *config.YoloV1Time != 1000 {
+		t.Errorf("override not applied: %v", config)
+	}
+	if genesis.Config.YoloV1Time != nil {
+		t.Errorf("genesis spec modified: %v", genesis.Config)
+	}
+	// Restarting with a different override must update the stored config
+	overrides["yoloV1Time"] = 2000
+	if config, _, err = SetupGenesisBlockWithOverride(db, nil, overrides); err != nil {
+		t.Fatalf("failed to setup stored genesis: %v", err)
+	}
+	if config.YoloV1Time == nil || *config.YoloV1Time != 2000 {
+		t.Errorf("override not applied to stored config: %v", config)
+	}
+	if stored := rawdb.ReadChainConfig(db, params.GoerliGenesisHash); stored.YoloV1Time == nil || *stored.YoloV1Time != 2000 {
+		t.Errorf("override not stored: %v", stored)
+	}
+	// Unknown forks are rejected
+	if _, _, err = SetupGenesisBlockWithOverride(db, nil, map[string]uint64{"unknown": 1}); err == nil {
+		t.Errorf("unknown fork override accepted")
+	}
+}
@@ -18,6 +18,7 @@ package rawdb
 
 import (
 	"bytes"
+	"encoding/binary"
 	"math/big"
 
 	"github.com/mxt/go-mxt/common"
@@ -175,3 +176,216 @@ func DeleteBloombits(db mxtdb.Database, bit uint, from uint64, to uint64) {
 		log.Crit("Failed to delete bloom bits", "err", it.Error())
 	}
 }
+
+// LogPosition is the position of a log within the canonical chain.
+type LogPosition struct {
+	Number uint64 // Number of the block containing the log
+	Index  uint32 // Index of the log within the block
+}
+
+// LogAddressTerm returns the log index term of logs emitted by an account.
+func LogAddressTerm(address common.Address) []byte {
+	return append([]byte{0}, address.Bytes()...)
+}
+
+// LogTopicTerm returns the log index term of logs having the given topic at the
+// given position.
+func LogTopicTerm(position int, topic common.Hash) []byte {
+	return append([]byte{byte(position + 1)}, topic.Bytes()...)
+}
+
+// ReadLogIndex retrieves the positions of the logs matching an index term within
+// the given section, in chain order.
+func ReadLogIndex(db mxtdb.KeyValueReader, term []byte, section uint64, head common.Hash) []LogPosition {
+	data, _ := db.Get(logIndexKey(term, section, head))
+	if len(data) == 0 {
+		return nil
+	}
+	var positions []LogPosition
+	if err := rlp.DecodeBytes(data, &positions); err != nil {
+		log.Error("Invalid log index RLP", "section", section, "head", head, "err", err)
+		return nil
+	}
+	return positions
+}
+
+// WriteLogIndex stores the positions of the logs matching an index term within
+// the given section.
+func WriteLogIndex(db mxtdb.KeyValueWriter, term []byte, section uint64, head common.Hash, positions []LogPosition) {
+	data, err := rlp.EncodeToBytes(positions)
+	if err != nil {
+		log.Crit("Failed to encode log index", "err", err)
+	}
+	if err := db.Put(logIndexKey(term, section, head), data); err != nil {
+		log.Crit("Failed to store log index", "err", err)
+	}
+}
+
+// HasLogIndexSection checks if the logs of a section have been indexed with the
+// given section head.
+func HasLogIndexSection(db mxtdb.KeyValueReader, section uint64, head common.Hash) bool {
+	ok, _ := db.Has(logSectionKey(section, head))
+	return ok
+}
+
+// ReadLogIndexSection retrieves the terms indexed within a section.
+func ReadLogIndexSection(db mxtdb.KeyValueReader, section uint64, head common.Hash) [][]byte {
+	data, _ := db.Get(logSectionKey(section, head))
+	if len(data) == 0 {
+		return nil
+	}
+	var terms [][]byte
+	if err := rlp.DecodeBytes(data, &terms); err != nil {
+		log.Error("Invalid log index section RLP", "section", section, "head", head, "err", err)
+		return nil
+	}
+	return terms
+}
+
+// WriteLogIndexSection stores the terms indexed within a section, marking the
+// section as indexed with the given section head.
+func WriteLogIndexSection(db mxtdb.KeyValueWriter, section uint64, head common.Hash, terms [][]byte) {
+	data, err := rlp.EncodeToBytes(terms)
+	if err != nil {
+		log.Crit("Failed to encode log index section", "err", err)
+	}
+	if err := db.Put(logSectionKey(section, head), data); err != nil {
+		log.Crit("Failed to store log index section", "err", err)
+	}
+}
+
+// DeleteLogIndexSection removes the log index of a section along with the list
+// of its indexed terms.
+func DeleteLogIndexSection(db mxtdb.KeyValueWriter, section uint64, head common.Hash, terms [][]byte) {
+	for _, term := range terms {
+		if err := db.Delete(logIndexKey(term, section, head)); err != nil {
+			log.Crit("Failed to delete log index", "err", err)
+		}
+	}
+	if err := db.Delete(logSectionKey(section, head)); err != nil {
+		log.Crit("Failed to delete log index section", "err", err)
+	}
+}
+
+// ReadLogIndexSectionHeads retrieves the heads of all the versions of a section
+// that have been indexed.
+func ReadLogIndexSectionHeads(db mxtdb.Iteratee, section uint64) []common.Hash {
+	prefix := append(append([]byte{}, logSectionPrefix...), encodeBlockNumber(section)...)
+	it := db.NewIterator(prefix, nil)
+	defer it.Release()
+
+	var heads []common.Hash
+	for it.Next() {
+		if len(it.Key()) == len(prefix)+common.HashLength {
+			heads = append(heads, common.BytesToHash(it.Key()[len(prefix):]))
+		}
+	}
+	return heads
+}
+
+// AddressIndexEntry records that an account took part in the transaction at the
+// given position of an indexed block.
+type AddressIndexEntry struct {
+	Address common.Address
+	TxIndex uint32
+}
+
+// ReadAddressIndexHead retrieves the hash and number of the last block whose
+// transactions were indexed by address. The hash is empty if nothing was indexed.
+func ReadAddressIndexHead(db mxtdb.KeyValueReader) (common.Hash, uint64) {
+	data, _ := db.Get(addrIndexHeadKey)
+	if len(data) != 8+common.HashLength {
+		return common.Hash{}, 0
+	}
+	return common.BytesToHash(data[8:]), binary.BigEndian.Uint64(data[:8])
+}
+
+// WriteAddressIndexHead stores the hash and number of the last block whose
+// transactions were indexed by address.
+func WriteAddressIndexHead(db mxtdb.KeyValueWriter, hash common.Hash, number uint64) {
+	if err := db.Put(addrIndexHeadKey, append(encodeBlockNumber(number), hash.Bytes()...)); err != nil {
+		log.Crit("Failed to store address index head", "err", err)
+	}
+}
+
+// WriteAddressIndex stores the address index entries of a block, mapping every
+// participating account to the hash of the transaction it took part in.
+func WriteAddressIndex(db mxtdb.KeyValueWriter, hash common.Hash, number uint64, txs []common.Hash, entries []AddressIndexEntry) {
+	for _, entry := range entries {
+		if err := db.Put(addrTxIndexKey(entry.Address, number, entry.TxIndex), txs[entry.TxIndex].Bytes()); err != nil {
+			log.Crit("Failed to store address index entry", "err", err)
+		}
+	}
+	data, err := rlp.EncodeToBytes(entries)
+	if err != nil {
+		log.Crit("Failed to encode address index entries", "err", err)
+	}
+	if err := db.Put(addrBlockIndexKey(number, hash), data); err != nil {
+		log.Crit("Failed to store address index entries", "err", err)
+	}
+}
+
+// ReadAddressIndexEntries retrieves the address index entries of a block.
+func ReadAddressIndexEntries(db mxtdb.KeyValueReader, hash common.Hash, number uint64) []AddressIndexEntry {
+	data, _ := db.Get(addrBlockIndexKey(number, hash))
+	if len(data) == 0 {
+		return nil
+	}
+	var entries []AddressIndexEntry
+	if err := rlp.DecodeBytes(data, &entries); err != nil {
+		log.Error("Invalid address index entries RLP", "hash", hash, "number", number, "err", err)
+		return nil
+	}
+	return entries
+}
+
+// DeleteAddressIndex removes the given address index entries of a block, along
+// with the block record itself.
+func DeleteAddressIndex(db mxtdb.KeyValueWriter, hash common.Hash, number uint64, entries []AddressIndexEntry) {
+	for _, entry := range entries {
+		if err := db.Delete(addrTxIndexKey(entry.Address, number, entry.TxIndex)); err != nil {
+			log.Crit("Failed to delete address index entry", "err", err)
+		}
+	}
+	if err := db.Delete(addrBlockIndexKey(number, hash)); err != nil {
+		log.Crit("Failed to delete address index entries", "err", err)
+	}
+}
+
+// ReadAddressIndexHashes retrieves the hashes of all the blocks at the given
+// height that have address index entries stored.
+func ReadAddressIndexHashes(db mxtdb.Iteratee, number uint64) []common.Hash {
+	prefix := append(append([]byte{}, addrBlockIndexPrefix...), encodeBlockNumber(number)...)
+	it := db.NewIterator(prefix, nil)
+	defer it.Release()
+
+	var hashes []common.Hash
+	for it.Next() {
+		if len(it.Key()) == len(prefix)+common.HashLength {
+			hashes = append(hashes, common.BytesToHash(it.Key()[len(prefix):]))
+		}
+	}
+	return hashes
+}
+
+// ReadAddressTransactions retrieves the hashes of the transactions an account
+// took part in, in chain order, skipping the first offset ones and returning
+// at most limit of them.
+func ReadAddressTransactions(db mxtdb.Iteratee, address common.Address, offset, limit uint64) []common.Hash {
+	prefix := append(append([]byte{}, addrTxIndexPrefix...), address.Bytes()...)
+	it := db.NewIterator(prefix, nil)
+	defer it.Release()
+
+	var hashes []common.Hash
+	for uint64(len(hashes)) < limit && it.Next() {
+		if len(it.Key()) != len(prefix)+8+4 {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		hashes = append(hashes, common.BytesToHash(it.Value()))
+	}
+	return hashes
+}
@@ -177,3 +177,51 @@ func TestDeleteBloomBits(t *testing.T) {
 	check(1, 1, params.MainnetGenesisHash, true)
 	check(1, 1, params.RinkebyGenesisHash, true)
 }
+
+// Tests that address index entries can be stored, paginated and deleted.
+func TestAddressIndexStorage(t *testing.T) {
+	db := NewMemoryDatabase()
+
+	var (
+		alice = common.Address{0x01}
+		bob   = common.Address{0x02}
+		txs1  = []common.Hash{{0x11}, {0x12}}
+		txs2  = []common.Hash{{0x21}}
+		hash1 = common.Hash{0xa1}
+		hash2 = common.Hash{0xa2}
+		fork2 = common.Hash{0xb2}
+	)
+	WriteAddressIndex(db, hash1, 1, txs1, []AddressIndexEntry{{alice, 0}, {bob, 0}, {alice, 1}})
+	WriteAddressIndex(db, hash2, 2, txs2, []AddressIndexEntry{{alice, 0}})
+	WriteAddressIndex(db, fork2, 2, nil, nil)
+	WriteAddressIndexHead(db, hash2, 2)
+
+	if hash, number := ReadAddressIndexHead(db); hash != hash2 || number != 2 {
+		t.Fatalf("head mismatch: have %x/%d, want %x/2", hash, number, hash2)
+	}
+	checkTxs := func(addr common.Address, offset, limit uint64, want []common.Hash) {
+		t.Helper()
+		have := ReadAddressTransactions(db, addr, offset, limit)
+		if len(have) != len(want) {
+			t.Fatalf("%x [%d:+%d]: transaction count mismatch: have %d, want %d", addr, offset, limit, len(have), len(want))
+		}
+		for i := range have {
+			if have[i] != want[i] {
+				t.Fatalf("%x [%d:+%d]: transaction %d mismatch: have %x, want %x", addr, offset, limit, i, have[i], want[i])
+			}
+		}
+	}
+	checkTxs(alice, 0, 10, []common.Hash{txs1[0], txs1[1], txs2[0]})
+	checkTxs(alice, 1, 1, []common.Hash{txs1[1]})
+	checkTxs(alice, 3, 10, nil)
+	checkTxs(bob, 0, 10, []common.Hash{txs1[0]})
+
+	if hashes := ReadAddressIndexHashes(db, 2); len(hashes) != 2 {
+		t.Fatalf("indexed block count mismatch: have %d, want 2", len(hashes))
+	}
+	DeleteAddressIndex(db, hash2, 2, ReadAddressIndexEntries(db, hash2, 2))
+	checkTxs(alice, 0, 10, []common.Hash{txs1[0], txs1[1]})
+	if hashes := ReadAddressIndexHashes(db, 2); len(hashes) != 1 || hashes[0] != fork2 {
+		t.Fatalf("indexed blocks mismatch after deletion: have %x, want [%x]", hashes, fork2)
+	}
+}
@@ -289,10 +289,12 @@ func InspectDatabase(db mxtdb.Database) error {
 		tries           stat
 		codes           stat
 		txLookups       stat
+		addrIndex       stat
 		accountSnaps    stat
 		storageSnaps    stat
 		preimages       stat
 		bloomBits       stat
+		logIndex        stat
 		cliqueSnaps     stat
 
 		// Ancient store statistics
@@ -339,6 +341,10 @@ func InspectDatabase(db mxtdb.Database) error {
 			codes.Add(size)
 		case bytes.HasPrefix(key, txLookupPrefix) && len(key) == (len(txLookupPrefix)+common.HashLength):
 			txLookups.Add(size)
+		case bytes.HasPrefix(key, addrTxIndexPrefix) && len(key) == (len(addrTxIndexPrefix)+common.AddressLength+8+4):
+			addrIndex.Add(size)
+		case bytes.HasPrefix(key, addrBlockIndexPrefix) && len(key) == (len(addrBlockIndexPrefix)+8+common.HashLength):
+			addrIndex.Add(size)
 		case bytes.HasPrefix(key, SnapshotAccountPrefix) && len(key) == (len(SnapshotAccountPrefix)+common.HashLength):
 			accountSnaps.Add(size)
 		case bytes.HasPrefix(key, SnapshotStoragePrefix) && len(key) == (len(SnapshotStoragePrefix)+2*common.HashLength):
@@ -347,6 +353,12 @@ func InspectDatabase(db mxtdb.Database) error {
 			preimages.Add(size)
 		case bytes.HasPrefix(key, bloomBitsPrefix) && len(key) == (len(bloomBitsPrefix)+10+common.HashLength):
 			bloomBits.Add(size)
+		case bytes.HasPrefix(key, logIndexPrefix) && len(key) == (len(logIndexPrefix)+1+common.AddressLength+8+common.HashLength):
+			logIndex.Add(size)
+		case bytes.HasPrefix(key, logIndexPrefix) && len(key) == (len(logIndexPrefix)+1+common.HashLength+8+common.HashLength):
+			logIndex.Add(size)
+		case bytes.HasPrefix(key, logSectionPrefix) && len(key) == (len(logSectionPrefix)+8+common.HashLength):
+			logIndex.Add(size)
 		case bytes.HasPrefix(key, []byte("clique-")) && len(key) == 7+common.HashLength:
 			cliqueSnaps.Add(size)
 		case bytes.HasPrefix(key, []byte("cht-")) && len(key) == 4+common.HashLength:
@@ -355,7 +367,7 @@ func InspectDatabase(db mxtdb.Database) error {
 			bloomTrieNodes.Add(size)
 		default:
 			var accounted bool
-			for _, meta := range [][]byte{databaseVerisionKey, headHeaderKey, headBlockKey, headFastBlockKey, fastTrieProgressKey} {
+			for _, meta := range [][]byte{databaseVerisionKey, headHeaderKey, headBlockKey, headFastBlockKey, fastTrieProgressKey, addrIndexHeadKey} {
 				if bytes.Equal(key, meta) {
 					metadata.Add(size)
 					accounted = true
@@ -394,7 +406,9 @@ func InspectDatabase(db mxtdb.Database) error {
 		{"Key-Value store", "Block number->hash", numHashPairings.Size(), numHashPairings.Count()},
 		{"Key-Value store", "Block hash->number", hashNumPairings.Size(), hashNumPairings.Count()},
 		{"Key-Value store", "Transaction index", txLookups.Size(), txLookups.Count()},
+		{"Key-Value store", "Address index", addrIndex.Size(), addrIndex.Count()},
 		{"Key-Value store", "Bloombit index", bloomBits.Size(), bloomBits.Count()},
+		{"Key-Value store", "Log index", logIndex.Size(), logIndex.Count()},
 		{"Key-Value store", "Contract codes", codes.Size(), codes.Count()},
 		{"Key-Value store", "Trie nodes", tries.Size(), tries.Count()},
 		{"Key-Value store", "Trie preimages", preimages.Size(), preimages.Count()},
@@ -57,6 +57,9 @@ var (
 	// fastTxLookupLimitKey tracks the transaction lookup limit during fast sync.
 	fastTxLookupLimitKey = []byte("FastTransactionLookupLimit")
 
+	// addrIndexHeadKey tracks the latest block whose transactions have been indexed by address.
+	addrIndexHeadKey = []byte("AddressIndexHead")
+
 	// Data item prefixes (use single byte to avoid mixing data types, avoid `i`, used for indexes).
 	headerPrefix       = []byte("h") // headerPrefix + num (uint64 big endian) + hash -> header
 	headerTDSuffix     = []byte("t") // headerPrefix + num (uint64 big endian) + hash + headerTDSuffix -> td
@@ -67,7 +70,11 @@ var (
 	blockReceiptsPrefix = []byte("r") // blockReceiptsPrefix + num (uint64 big endian) + hash -> block receipts
 
 	txLookupPrefix        = []byte("l") // txLookupPrefix + hash -> transaction/receipt lookup metadata
+	addrTxIndexPrefix     = []byte("x") // addrTxIndexPrefix + address + num (uint64 big endian) + tx index (uint32 big endian) -> tx hash
+	addrBlockIndexPrefix  = []byte("X") // addrBlockIndexPrefix + num (uint64 big endian) + hash -> address index entries of the block
 	bloomBitsPrefix       = []byte("B") // bloomBitsPrefix + bit (uint16 big endian) + section (uint64 big endian) + hash -> bloom bits
+	logIndexPrefix        = []byte("g") // logIndexPrefix + term + section (uint64 big endian) + hash -> log positions
+	logSectionPrefix      = []byte("G") // logSectionPrefix + section (uint64 big endian) + hash -> terms indexed in the section
 	SnapshotAccountPrefix = []byte("a") // SnapshotAccountPrefix + account hash -> account trie value
 	SnapshotStoragePrefix = []byte("o") // SnapshotStoragePrefix + account hash + storage hash -> storage trie value
 	codePrefix            = []byte("c") // codePrefix + code hash -> account code
@@ -77,6 +84,7 @@ var (
 
 	// Chain index prefixes (use `i` + single byte to avoid mixing data types).
 	BloomBitsIndexPrefix = []byte("iB") // BloomBitsIndexPrefix is the data table of a chain indexer to track its progress
+	LogIndexIndexPrefix  = []byte("iL") // LogIndexIndexPrefix is the data table of the log indexer to track its progress
 
 	preimageCounter    = metrics.NewRegisteredCounter("db/preimage/total", nil)
 	preimageHitCounter = metrics.NewRegisteredCounter("db/preimage/hits", nil)
@@ -164,6 +172,28 @@ func txLookupKey(hash common.Hash) []byte {
 	return append(txLookupPrefix, hash.Bytes()...)
 }
 
+// addrTxIndexKey = addrTxIndexPrefix + address + num (uint64 big endian) + tx index (uint32 big endian)
+func addrTxIndexKey(address common.Address, number uint64, index uint32) []byte {
+	key := append(append(addrTxIndexPrefix, address.Bytes()...), encodeBlockNumber(number)...)
+	return append(key, byte(index>>24), byte(index>>16), byte(index>>8), byte(index))
+}
+
+// addrBlockIndexKey = addrBlockIndexPrefix + num (uint64 big endian) + hash
+func addrBlockIndexKey(number uint64, hash common.Hash) []byte {
+	return append(append(addrBlockIndexPrefix, encodeBlockNumber(number)...), hash.Bytes()...)
+}
+
+// logIndexKey = logIndexPrefix + term + section (uint64 big endian) + hash
+func logIndexKey(term []byte, section uint64, hash common.Hash) []byte {
+	key := append(append(append([]byte{}, logIndexPrefix...), term...), encodeBlockNumber(section)...)
+	return append(key, hash.Bytes()...)
+}
+
+// logSectionKey = logSectionPrefix + section (uint64 big endian) + hash
+func logSectionKey(section uint64, hash common.Hash) []byte {
+	return append(append(append([]byte{}, logSectionPrefix...), encodeBlockNumber(section)...), hash.Bytes()...)
+}
+
 // accountSnapshotKey = SnapshotAccountPrefix + hash
 func accountSnapshotKey(hash common.Hash) []byte {
 	return append(SnapshotAccountPrefix, hash.Bytes()...)
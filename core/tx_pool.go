@@ -25,6 +25,7 @@ import (
 	"time"
 
 	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/common/mclock"
 	"github.com/mxt/go-mxt/common/prque"
 	"github.com/mxt/go-mxt/core/state"
 	"github.com/mxt/go-mxt/core/types"
@@ -148,6 +149,8 @@ type TxPoolConfig struct {
 	GlobalQueue  uint64 // Maximum number of non-executable transaction slots for all accounts
 
 	Lifetime time.Duration // Maximum amount of time non-executable transaction are queued
+
+	Clock mclock.Clock `toml:"-"` // Time source for eviction and maintenance timers, defaults to the system clock
 }
 
 // DefaultTxPoolConfig contains the default configurations for the transaction
@@ -203,6 +206,9 @@ func (config *TxPoolConfig) sanitize() TxPoolConfig {
 		log.Warn("Sanitizing invalid txpool lifetime", "provided", conf.Lifetime, "updated", DefaultTxPoolConfig.Lifetime)
 		conf.Lifetime = DefaultTxPoolConfig.Lifetime
 	}
+	if conf.Clock == nil {
+		conf.Clock = mclock.System{}
+	}
 	return conf
 }
 
@@ -232,11 +238,11 @@ type TxPool struct {
 	locals  *accountSet // Set of local transaction to exempt from eviction rules
 	journal *txJournal  // Journal of local transaction to back up to disk
 
-	pending map[common.Address]*txList   // All currently processable transactions
-	queue   map[common.Address]*txList   // Queued but non-processable transactions
-	beats   map[common.Address]time.Time // Last heartbeat from each known account
-	all     *txLookup                    // All transactions to allow lookups
-	priced  *txPricedList                // All transactions sorted by price
+	pending map[common.Address]*txList        // All currently processable transactions
+	queue   map[common.Address]*txList        // Queued but non-processable transactions
+	beats   map[common.Address]mclock.AbsTime // Last heartbeat from each known account
+	all     *txLookup                         // All transactions to allow lookups
+	priced  *txPricedList                     // All transactions sorted by price
 
 	chainHeadCh     chan ChainHeadEvent
 	chainHeadSub    event.Subscription
@@ -266,7 +272,7 @@ func NewTxPool(config TxPoolConfig, chainconfig *params.ChainConfig, chain block
 		signer:          types.NewEIP155Signer(chainconfig.ChainID),
 		pending:         make(map[common.Address]*txList),
 		queue:           make(map[common.Address]*txList),
-		beats:           make(map[common.Address]time.Time),
+		beats:           make(map[common.Address]mclock.AbsTime),
 		all:             newTxLookup(),
 		chainHeadCh:     make(chan ChainHeadEvent, chainHeadChanSize),
 		reqResetCh:      make(chan *txpoolResetRequest),
@@ -316,10 +322,10 @@ func (pool *TxPool) loop() {
 
 	var (
 		prevPending, prevQueued, prevStales int
-		// Start the stats reporting and transaction eviction tickers
-		report  = time.NewTicker(statsReportInterval)
-		evict   = time.NewTicker(evictionInterval)
-		journal = time.NewTicker(pool.config.Rejournal)
+		// Start the stats reporting and transaction eviction timers
+		report  = pool.config.Clock.NewTimer(statsReportInterval)
+		evict   = pool.config.Clock.NewTimer(evictionInterval)
+		journal = pool.config.Clock.NewTimer(pool.config.Rejournal)
 		// Track the previous head headers for transaction reorgs
 		head = pool.chain.CurrentBlock()
 	)
@@ -342,7 +348,7 @@ func (pool *TxPool) loop() {
 			return
 
 		// Handle stats reporting ticks
-		case <-report.C:
+		case <-report.C():
 			pool.mu.RLock()
 			pending, queued := pool.stats()
 			stales := pool.priced.stales
@@ -352,9 +358,10 @@ func (pool *TxPool) loop() {
 				log.Debug("Transaction pool status report", "executable", pending, "queued", queued, "stales", stales)
 				prevPending, prevQueued, prevStales = pending, queued, stales
 			}
+			report.Reset(statsReportInterval)
 
 		// Handle inactive account transaction eviction
-		case <-evict.C:
+		case <-evict.C():
 			pool.mu.Lock()
 			for addr := range pool.queue {
 				// Skip local transactions from the eviction mechanism
@@ -362,7 +369,7 @@ func (pool *TxPool) loop() {
 					continue
 				}
 				// Any non-locals old enough should be removed
-				if time.Since(pool.beats[addr]) > pool.config.Lifetime {
+				if pool.config.Clock.Now().Sub(pool.beats[addr]) > pool.config.Lifetime {
 					list := pool.queue[addr].Flatten()
 					for _, tx := range list {
 						pool.removeTx(tx.Hash(), true)
@@ -371,9 +378,10 @@ func (pool *TxPool) loop() {
 				}
 			}
 			pool.mu.Unlock()
+			evict.Reset(evictionInterval)
 
 		// Handle local transaction journal rotation
-		case <-journal.C:
+		case <-journal.C():
 			if pool.journal != nil {
 				pool.mu.Lock()
 				if err := pool.journal.rotate(pool.local()); err != nil {
@@ -381,6 +389,7 @@ func (pool *TxPool) loop() {
 				}
 				pool.mu.Unlock()
 			}
+			journal.Reset(pool.config.Rejournal)
 		}
 	}
 }
@@ -427,6 +436,18 @@ func (pool *TxPool) SetGasPrice(price *big.Int) {
 	log.Info("Transaction pool price threshold updated", "price", price)
 }
 
+// SetChainConfig updates the chain configuration the pool checks transactions
+// against, recomputing the fork indicators for the next pending block.
+func (pool *TxPool) SetChainConfig(config *params.ChainConfig) {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+
+	pool.chainconfig = config
+
+	next := new(big.Int).Add(pool.chain.CurrentBlock().Number(), big.NewInt(1))
+	pool.istanbul = pool.chainconfig.IsIstanbul(next)
+}
+
 // Nonce returns the next nonce of an account, with all transactions executable
 // by the pool already applied on top.
 func (pool *TxPool) Nonce(addr common.Address) uint64 {
@@ -619,7 +640,7 @@ func (pool *TxPool) add(tx *types.Transaction, local bool) (replaced bool, err e
 		log.Trace("Pooled new executable transaction", "hash", hash, "from", from, "to", tx.To())
 
 		// Successful promotion, bump the heartbeat
-		pool.beats[from] = time.Now()
+		pool.beats[from] = pool.config.Clock.Now()
 		return old != nil, nil
 	}
 	// New transaction isn't replacing a pending one, push into queue
@@ -673,7 +694,7 @@ func (pool *TxPool) enqueueTx(hash common.Hash, tx *types.Transaction) (bool, er
 	}
 	// If we never record the heartbeat, do it right now.
 	if _, exist := pool.beats[from]; !exist {
-		pool.beats[from] = time.Now()
+		pool.beats[from] = pool.config.Clock.Now()
 	}
 	return old != nil, nil
 }
@@ -727,7 +748,7 @@ func (pool *TxPool) promoteTx(addr common.Address, hash common.Hash, tx *types.T
 	pool.pendingNonces.set(addr, tx.Nonce()+1)
 
 	// Successful promotion, bump the heartbeat
-	pool.beats[addr] = time.Now()
+	pool.beats[addr] = pool.config.Clock.Now()
 	return true
 }
 
@@ -1436,13 +1457,13 @@ func (pool *TxPool) demoteUnexecutables() {
 // addressByHeartbeat is an account address tagged with its last activity timestamp.
 type addressByHeartbeat struct {
 	address   common.Address
-	heartbeat time.Time
+	heartbeat mclock.AbsTime
 }
 
 type addressesByHeartbeat []addressByHeartbeat
 
 func (a addressesByHeartbeat) Len() int           { return len(a) }
-func (a addressesByHeartbeat) Less(i, j int) bool { return a[i].heartbeat.Before(a[j].heartbeat) }
+func (a addressesByHeartbeat) Less(i, j int) bool { return a[i].heartbeat < a[j].heartbeat }
 func (a addressesByHeartbeat) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 
 // accountSet is simply a set of addresses to check for existence, and a signer
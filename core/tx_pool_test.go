@@ -28,6 +28,7 @@ import (
 	"time"
 
 	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/common/mclock"
 	"github.com/mxt/go-mxt/core/rawdb"
 	"github.com/mxt/go-mxt/core/state"
 	"github.com/mxt/go-mxt/core/types"
@@ -269,6 +270,35 @@ func TestInvalidTransactions(t *testing.T) {
 	}
 }
 
+// Tests that swapping the chain config of a live pool updates the fork
+// indicators used to validate transactions.
+func TestTransactionPoolSetChainConfig(t *testing.T) {
+	t.Parallel()
+
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	blockchain := &testBlockChain{statedb, 1000000, new(event.Feed)}
+
+	config := *params.TestChainConfig
+	config.IstanbulBlock = big.NewInt(5)
+
+	pool := NewTxPool(testTxPoolConfig, &config, blockchain)
+	defer pool.Stop()
+
+	if pool.istanbul {
+		t.Fatalf("istanbul active before its fork block")
+	}
+	newcfg := config
+	newcfg.IstanbulBlock = big.NewInt(1)
+	pool.SetChainConfig(&newcfg)
+
+	if !pool.istanbul {
+		t.Errorf("istanbul not active after rescheduling it to the next block")
+	}
+	if pool.chainconfig != &newcfg {
+		t.Errorf("pool chain config not updated")
+	}
+}
+
 func TestTransactionQueue(t *testing.T) {
 	t.Parallel()
 
@@ -1010,6 +1040,54 @@ func testTransactionQueueTimeLimiting(t *testing.T, nolocals bool) {
 	}
 }
 
+// Tests that queued transactions expire according to the configured clock, so
+// that simulations can run the eviction without waiting for the lifetime.
+func TestTransactionQueueTimeLimitingSimulated(t *testing.T) {
+	t.Parallel()
+
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(rawdb.NewMemoryDatabase()), nil)
+	blockchain := &testBlockChain{statedb, 1000000, new(event.Feed)}
+
+	clock := new(mclock.Simulated)
+	config := testTxPoolConfig
+	config.Clock = clock
+
+	pool := NewTxPool(config, params.TestChainConfig, blockchain)
+	defer pool.Stop()
+
+	local, _ := crypto.GenerateKey()
+	remote, _ := crypto.GenerateKey()
+	pool.currentState.AddBalance(crypto.PubkeyToAddress(local.PublicKey), big.NewInt(1000000000))
+	pool.currentState.AddBalance(crypto.PubkeyToAddress(remote.PublicKey), big.NewInt(1000000000))
+
+	if err := pool.AddLocal(pricedTransaction(1, 100000, big.NewInt(1), local)); err != nil {
+		t.Fatalf("failed to add local transaction: %v", err)
+	}
+	if err := pool.addRemoteSync(pricedTransaction(1, 100000, big.NewInt(1), remote)); err != nil {
+		t.Fatalf("failed to add remote transaction: %v", err)
+	}
+	// run advances the clock in steps of the eviction interval, waiting for the
+	// pool to process each step. The pool has three timers: report, evict and journal.
+	run := func(d time.Duration) {
+		for end := clock.Now().Add(d); clock.Now() < end; {
+			clock.WaitForTimers(3)
+			clock.Run(evictionInterval)
+		}
+		clock.WaitForTimers(3)
+	}
+	run(pool.config.Lifetime - evictionInterval)
+	if _, queued := pool.Stats(); queued != 2 {
+		t.Fatalf("queued transactions mismatched before lifetime: have %d, want %d", queued, 2)
+	}
+	run(2 * evictionInterval)
+	if _, queued := pool.Stats(); queued != 1 {
+		t.Fatalf("queued transactions mismatched after lifetime: have %d, want %d", queued, 1)
+	}
+	if err := validateTxPoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
 // Tests that even if the transaction count belonging to a single account goes
 // above some threshold, as long as the transactions are executable, they are
 // accepted.
@@ -29,6 +29,7 @@ import (
 	"github.com/mxt/go-mxt/common/hexutil"
 	"github.com/mxt/go-mxt/common/math"
 	"github.com/mxt/go-mxt/core/types"
+	"github.com/mxt/go-mxt/crypto"
 )
 
 var errTraceLimitReached = errors.New("the number of logs reached the specified limit")
@@ -112,6 +113,28 @@ type Tracer interface {
 	CaptureEnd(output []byte, gasUsed uint64, t time.Duration, err error) error
 }
 
+// CreatedAddress returns the address of the contract deployed by a CREATE or
+// CREATE2 operation, decoded from the state passed to Tracer.CaptureState before
+// the operation executes. It returns false for other operations and for CREATE2
+// calls whose init code can't be read from memory.
+func CreatedAddress(env *EVM, op OpCode, memory *Memory, stack *Stack, contract *Contract) (common.Address, bool) {
+	switch op {
+	case CREATE:
+		return crypto.CreateAddress(contract.Address(), env.StateDB.GetNonce(contract.Address())), true
+	case CREATE2:
+		if len(stack.Data()) < 4 || !stack.Back(1).IsUint64() || !stack.Back(2).IsUint64() {
+			return common.Address{}, false
+		}
+		offset, length := stack.Back(1).Uint64(), stack.Back(2).Uint64()
+		if offset+length < offset || uint64(memory.Len()) < offset+length {
+			return common.Address{}, false
+		}
+		code := memory.GetCopy(int64(offset), int64(length))
+		return crypto.CreateAddress2(contract.Address(), stack.Back(3).Bytes32(), crypto.Keccak256(code)), true
+	}
+	return common.Address{}, false
+}
+
 // StructLogger is an EVM state logger and implements Tracer.
 //
 // StructLogger can capture state based on the given Log configuration and also keeps
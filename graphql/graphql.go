@@ -20,6 +20,8 @@ package graphql
 import (
 	"context"
 	"errors"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/mxt/go-mxt"
@@ -36,7 +38,9 @@ import (
 )
 
 var (
-	errBlockInvariant = errors.New("block objects must be instantiated with at least one of num or hash")
+	errBlockInvariant       = errors.New("block objects must be instantiated with at least one of num or hash")
+	errTracingUnsupported   = errors.New("tracing not supported by backend")
+	errAddrIndexUnsupported = errors.New("address index not supported by backend")
 )
 
 // Account represents an Ethereum account at a particular block.
@@ -213,17 +217,22 @@ func (t *Transaction) From(ctx context.Context, args BlockNumberArgs) (*Account,
 	if err != nil || tx == nil {
 		return nil, err
 	}
+	return &Account{
+		backend:       t.backend,
+		address:       txSender(tx),
+		blockNrOrHash: args.NumberOrLatest(),
+	}, nil
+}
+
+// txSender derives the sender of a transaction, using the EIP155 signer for
+// replay protected transactions and the Homestead one otherwise.
+func txSender(tx *types.Transaction) common.Address {
 	var signer types.Signer = types.HomesteadSigner{}
 	if tx.Protected() {
 		signer = types.NewEIP155Signer(tx.ChainId())
 	}
 	from, _ := types.Sender(signer, tx)
-
-	return &Account{
-		backend:       t.backend,
-		address:       from,
-		blockNrOrHash: args.NumberOrLatest(),
-	}, nil
+	return from
 }
 
 func (t *Transaction) Block(ctx context.Context) (*Block, error) {
@@ -341,6 +350,78 @@ func (t *Transaction) V(ctx context.Context) (hexutil.Big, error) {
 	return hexutil.Big(*v), nil
 }
 
+func (t *Transaction) Trace(ctx context.Context) (*CallFrame, error) {
+	if _, err := t.resolve(ctx); err != nil {
+		return nil, err
+	}
+	if t.block == nil {
+		return nil, nil
+	}
+	frames, err := t.block.resolveTraces(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if t.index >= uint64(len(frames)) {
+		return nil, nil
+	}
+	return &CallFrame{frames[t.index]}, nil
+}
+
+// CallFrame represents a single call in the call tree of a transaction.
+type CallFrame struct {
+	frame *mxtapi.CallFrame
+}
+
+func (c *CallFrame) Type() string {
+	return c.frame.Type
+}
+
+func (c *CallFrame) From() common.Address {
+	return c.frame.From
+}
+
+func (c *CallFrame) To() common.Address {
+	return c.frame.To
+}
+
+func (c *CallFrame) Value() hexutil.Big {
+	if c.frame.Value == nil {
+		return hexutil.Big{}
+	}
+	return *c.frame.Value
+}
+
+func (c *CallFrame) Gas() hexutil.Uint64 {
+	return c.frame.Gas
+}
+
+func (c *CallFrame) GasUsed() hexutil.Uint64 {
+	return c.frame.GasUsed
+}
+
+func (c *CallFrame) Input() hexutil.Bytes {
+	return c.frame.Input
+}
+
+func (c *CallFrame) Output() hexutil.Bytes {
+	return c.frame.Output
+}
+
+func (c *CallFrame) Error() *string {
+	if c.frame.Error == "" {
+		return nil
+	}
+	return &c.frame.Error
+}
+
+func (c *CallFrame) Calls() []*CallFrame {
+	calls := make([]*CallFrame, 0, len(c.frame.Calls))
+	for _, call := range c.frame.Calls {
+		calls = append(calls, &CallFrame{call})
+	}
+	return calls
+}
+
 type BlockType int
 
 // Block represents an Ethereum block.
@@ -353,6 +434,10 @@ type Block struct {
 	header       *types.Header
 	block        *types.Block
 	receipts     []*types.Receipt
+
+	traceLock sync.Mutex            // Serializes block re-executions across concurrent resolvers
+	traces    []*mxtapi.CallFrame   // Call trees of the transactions, fetched on demand
+	diffs     []*mxtapi.AccountDiff // Accounts modified by the block, fetched on demand
 }
 
 // resolve returns the internal Block object representing this block, fetching
@@ -415,6 +500,52 @@ func (b *Block) resolveReceipts(ctx context.Context) ([]*types.Receipt, error) {
 	return b.receipts, nil
 }
 
+// resolveTraces returns the call trees of all the transactions in this block,
+// tracing the whole block once and caching the results for every transaction.
+func (b *Block) resolveTraces(ctx context.Context) ([]*mxtapi.CallFrame, error) {
+	b.traceLock.Lock()
+	defer b.traceLock.Unlock()
+
+	if b.traces == nil {
+		backend, ok := b.backend.(mxtapi.TraceBackend)
+		if !ok {
+			return nil, errTracingUnsupported
+		}
+		hash, err := b.Hash(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if b.traces, err = backend.TraceBlockCalls(ctx, hash); err != nil {
+			return nil, err
+		}
+	}
+	return b.traces, nil
+}
+
+// resolveStateDiff returns the accounts modified by this block, fetching them
+// if necessary.
+func (b *Block) resolveStateDiff(ctx context.Context) ([]*mxtapi.AccountDiff, error) {
+	b.traceLock.Lock()
+	defer b.traceLock.Unlock()
+
+	if b.diffs == nil {
+		backend, ok := b.backend.(mxtapi.TraceBackend)
+		if !ok {
+			return nil, errTracingUnsupported
+		}
+		hash, err := b.Hash(ctx)
+		if err != nil {
+			return nil, err
+		}
+		diffs, err := backend.BlockStateDiff(ctx, hash)
+		if err != nil {
+			return nil, err
+		}
+		b.diffs = append(make([]*mxtapi.AccountDiff, 0, len(diffs)), diffs...)
+	}
+	return b.diffs, nil
+}
+
 func (b *Block) Number(ctx context.Context) (hexutil.Uint64, error) {
 	header, err := b.resolveHeader(ctx)
 	if err != nil {
@@ -764,6 +895,80 @@ func (b *Block) Account(ctx context.Context, args struct {
 	}, nil
 }
 
+func (b *Block) StateDiff(ctx context.Context) ([]*AccountDiff, error) {
+	diffs, err := b.resolveStateDiff(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*AccountDiff, 0, len(diffs))
+	for _, diff := range diffs {
+		ret = append(ret, &AccountDiff{diff})
+	}
+	return ret, nil
+}
+
+// AccountDiff represents the changes a block made to a single account.
+type AccountDiff struct {
+	diff *mxtapi.AccountDiff
+}
+
+func (a *AccountDiff) Address() common.Address {
+	return a.diff.Address
+}
+
+func (a *AccountDiff) BalanceBefore() hexutil.Big {
+	return hexutil.Big(*a.diff.BalanceBefore)
+}
+
+func (a *AccountDiff) BalanceAfter() hexutil.Big {
+	return hexutil.Big(*a.diff.BalanceAfter)
+}
+
+func (a *AccountDiff) NonceBefore() hexutil.Uint64 {
+	return hexutil.Uint64(a.diff.NonceBefore)
+}
+
+func (a *AccountDiff) NonceAfter() hexutil.Uint64 {
+	return hexutil.Uint64(a.diff.NonceAfter)
+}
+
+func (a *AccountDiff) CodeBefore() hexutil.Bytes {
+	return a.diff.CodeBefore
+}
+
+func (a *AccountDiff) CodeAfter() hexutil.Bytes {
+	return a.diff.CodeAfter
+}
+
+func (a *AccountDiff) StorageCleared() bool {
+	return a.diff.StorageCleared
+}
+
+func (a *AccountDiff) Storage() []*StorageDiff {
+	ret := make([]*StorageDiff, 0, len(a.diff.Storage))
+	for _, diff := range a.diff.Storage {
+		ret = append(ret, &StorageDiff{diff})
+	}
+	return ret
+}
+
+// StorageDiff represents the change of a single storage slot.
+type StorageDiff struct {
+	diff *mxtapi.StorageDiff
+}
+
+func (s *StorageDiff) Slot() common.Hash {
+	return s.diff.Slot
+}
+
+func (s *StorageDiff) Before() common.Hash {
+	return s.diff.Before
+}
+
+func (s *StorageDiff) After() common.Hash {
+	return s.diff.After
+}
+
 // CallData encapsulates arguments to `call` or `estimateGas`.
 // All arguments are optional.
 type CallData struct {
@@ -841,13 +1046,22 @@ func (p *Pending) TransactionCount(ctx context.Context) (int32, error) {
 	return int32(len(txs)), err
 }
 
-func (p *Pending) Transactions(ctx context.Context) (*[]*Transaction, error) {
+func (p *Pending) Transactions(ctx context.Context, args struct {
+	From *common.Address
+	To   *common.Address
+}) (*[]*Transaction, error) {
 	txs, err := p.backend.GetPoolTransactions()
 	if err != nil {
 		return nil, err
 	}
 	ret := make([]*Transaction, 0, len(txs))
 	for i, tx := range txs {
+		if args.To != nil && (tx.To() == nil || *tx.To() != *args.To) {
+			continue
+		}
+		if args.From != nil && txSender(tx) != *args.From {
+			continue
+		}
 		ret = append(ret, &Transaction{
 			backend: p.backend,
 			hash:    tx.Hash(),
@@ -904,9 +1118,20 @@ type Resolver struct {
 func (r *Resolver) Block(ctx context.Context, args struct {
 	Number *hexutil.Uint64
 	Hash   *common.Hash
+	Tag    *string
 }) (*Block, error) {
 	var block *Block
-	if args.Number != nil {
+	if args.Tag != nil {
+		var number rpc.BlockNumber
+		if err := number.UnmarshalJSON([]byte(*args.Tag)); err != nil {
+			return nil, fmt.Errorf("invalid block tag %q: %v", *args.Tag, err)
+		}
+		numberOrHash := rpc.BlockNumberOrHashWithNumber(number)
+		block = &Block{
+			backend:      r.backend,
+			numberOrHash: &numberOrHash,
+		}
+	} else if args.Number != nil {
 		number := rpc.BlockNumber(uint64(*args.Number))
 		numberOrHash := rpc.BlockNumberOrHashWithNumber(number)
 		block = &Block{
@@ -983,6 +1208,61 @@ func (r *Resolver) Transaction(ctx context.Context, args struct{ Hash common.Has
 	return tx, nil
 }
 
+func (r *Resolver) TransactionsByAddress(ctx context.Context, args struct {
+	Address common.Address
+	Offset  *hexutil.Uint64
+	Limit   *hexutil.Uint64
+}) ([]*Transaction, error) {
+	indexer, ok := r.backend.(mxtapi.AddressIndexBackend)
+	if !ok {
+		return nil, errAddrIndexUnsupported
+	}
+	offset, limit := uint64(0), uint64(mxtapi.DefaultAddressTxLimit)
+	if args.Offset != nil {
+		offset = uint64(*args.Offset)
+	}
+	if args.Limit != nil {
+		limit = uint64(*args.Limit)
+	}
+	if limit > mxtapi.MaxAddressTxLimit {
+		limit = mxtapi.MaxAddressTxLimit
+	}
+	hashes, err := indexer.TransactionsByAddress(ctx, args.Address, offset, limit)
+	if err != nil {
+		return nil, err
+	}
+	// Share the block objects between transactions so receipts and traces are
+	// only retrieved once per block
+	var (
+		blocks = make(map[common.Hash]*Block)
+		ret    = make([]*Transaction, 0, len(hashes))
+	)
+	for _, hash := range hashes {
+		tx, blockHash, _, index := rawdb.ReadTransaction(r.backend.ChainDb(), hash)
+		if tx == nil {
+			continue // Reorged out since the index was queried
+		}
+		block, ok := blocks[blockHash]
+		if !ok {
+			numberOrHash := rpc.BlockNumberOrHashWithHash(blockHash, false)
+			block = &Block{
+				backend:      r.backend,
+				numberOrHash: &numberOrHash,
+				hash:         blockHash,
+			}
+			blocks[blockHash] = block
+		}
+		ret = append(ret, &Transaction{
+			backend: r.backend,
+			hash:    hash,
+			tx:      tx,
+			block:   block,
+			index:   index,
+		})
+	}
+	return ret, nil
+}
+
 func (r *Resolver) SendRawTransaction(ctx context.Context, args struct{ Data hexutil.Bytes }) (common.Hash, error) {
 	tx := new(types.Transaction)
 	if err := rlp.DecodeBytes(args.Data, tx); err != nil {
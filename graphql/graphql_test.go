@@ -17,14 +17,26 @@
 package graphql
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math/big"
 	"net/http"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/common/hexutil"
+	"github.com/mxt/go-mxt/consensus/mxtash"
+	"github.com/mxt/go-mxt/core"
+	"github.com/mxt/go-mxt/core/rawdb"
+	"github.com/mxt/go-mxt/core/types"
+	"github.com/mxt/go-mxt/crypto"
 	"github.com/mxt/go-mxt/mxt"
 	"github.com/mxt/go-mxt/node"
+	"github.com/mxt/go-mxt/params"
+	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -90,6 +102,237 @@ func TestGraphQLHTTPOnSamePort_GQLRequest_Unsuccessful(t *testing.T) {
 	assert.Equal(t, "404 page not found\n", string(bodyBytes))
 }
 
+// Tests that GraphQL subscriptions are served over the graphql-ws protocol on the
+// WebSocket endpoint.
+func TestGraphQLWebSocketSubscription(t *testing.T) {
+	stack := createNode(t, true)
+	defer stack.Close()
+	// start node
+	if err := stack.Start(); err != nil {
+		t.Fatalf("could not start node: %v", err)
+	}
+	dialer := websocket.Dialer{Subprotocols: []string{wsProtocol}}
+	conn, _, err := dialer.Dial("ws://127.0.0.1:9393/graphql", nil)
+	if err != nil {
+		t.Fatalf("could not dial graphql-ws endpoint: %v", err)
+	}
+	defer conn.Close()
+
+	expect := func(typ string, id string) *wsMessage {
+		t.Helper()
+		msg := new(wsMessage)
+		for {
+			if err := conn.ReadJSON(msg); err != nil {
+				t.Fatalf("could not read message: %v", err)
+			}
+			if msg.Type != gqlConnectionKeepAlive {
+				break
+			}
+		}
+		if msg.Type != typ || msg.ID != id {
+			t.Fatalf("unexpected message: have %s/%q, want %s/%q", msg.Type, msg.ID, typ, id)
+		}
+		return msg
+	}
+	// Subscriptions are refused before the connection is initialised
+	payload, _ := json.Marshal(&wsStartPayload{Query: "subscription { newBlocks { number } }"})
+	if err := conn.WriteJSON(&wsMessage{ID: "0", Type: gqlStart, Payload: payload}); err != nil {
+		t.Fatalf("could not write message: %v", err)
+	}
+	expect(gqlError, "0")
+
+	if err := conn.WriteJSON(&wsMessage{Type: gqlConnectionInit}); err != nil {
+		t.Fatalf("could not write message: %v", err)
+	}
+	expect(gqlConnectionAck, "")
+
+	// Invalid subscriptions are answered with an error and completed
+	payload, _ = json.Marshal(&wsStartPayload{Query: "subscription { nonexistent }"})
+	if err := conn.WriteJSON(&wsMessage{ID: "1", Type: gqlStart, Payload: payload}); err != nil {
+		t.Fatalf("could not write message: %v", err)
+	}
+	msg := expect(gqlData, "1")
+	assert.Contains(t, string(msg.Payload), "errors")
+	expect(gqlComplete, "1")
+
+	// Valid subscriptions run until stopped
+	payload, _ = json.Marshal(&wsStartPayload{Query: "subscription { newBlocks { number } }"})
+	if err := conn.WriteJSON(&wsMessage{ID: "2", Type: gqlStart, Payload: payload}); err != nil {
+		t.Fatalf("could not write message: %v", err)
+	}
+	if err := conn.WriteJSON(&wsMessage{ID: "2", Type: gqlStop}); err != nil {
+		t.Fatalf("could not write message: %v", err)
+	}
+	if err := conn.WriteJSON(&wsMessage{Type: gqlConnectionTerminate}); err != nil {
+		t.Fatalf("could not write message: %v", err)
+	}
+	if _, _, err := conn.ReadMessage(); err == nil {
+		t.Fatalf("connection not closed after termination")
+	}
+
+	// Oversized messages close the connection
+	conn, _, err = dialer.Dial("ws://127.0.0.1:9393/graphql", nil)
+	if err != nil {
+		t.Fatalf("could not dial graphql-ws endpoint: %v", err)
+	}
+	defer conn.Close()
+	conn.WriteMessage(websocket.TextMessage, make([]byte, wsReadLimit+1)) // may fail when closed early
+	if _, _, err := conn.ReadMessage(); err == nil {
+		t.Fatalf("connection not closed after oversized message")
+	}
+}
+
+// Tests the resolvers that re-execute blocks, filter the pending transactions
+// and query the address index.
+func TestGraphQLChainResolvers(t *testing.T) {
+	var (
+		key, _ = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		sender = crypto.PubkeyToAddress(key.PublicKey)
+		storer = common.HexToAddress("0x0100")
+		killer = common.HexToAddress("0x0200")
+		gspec  = &core.Genesis{
+			Config: params.TestChainConfig,
+			Alloc: core.GenesisAlloc{
+				sender: {Balance: big.NewInt(1000000000000000)},
+				// PUSH1 1 PUSH1 0 SSTORE STOP
+				storer: {Balance: common.Big0, Code: []byte{0x60, 0x01, 0x60, 0x00, 0x55, 0x00}},
+				// CALLER SELFDESTRUCT
+				killer: {Balance: common.Big0, Code: []byte{0x33, 0xff}, Storage: map[common.Hash]common.Hash{
+					common.HexToHash("0x01"): common.HexToHash("0x2a"),
+				}},
+			},
+		}
+		signer = types.HomesteadSigner{}
+		newTx  = func(nonce uint64, to common.Address) *types.Transaction {
+			tx, _ := types.SignTx(types.NewTransaction(nonce, to, common.Big0, 100000, big.NewInt(1), nil), signer, key)
+			return tx
+		}
+		txs = []*types.Transaction{newTx(0, storer), newTx(1, killer)}
+	)
+	db := rawdb.NewMemoryDatabase()
+	genesis := gspec.MustCommit(db)
+	blocks, _ := core.GenerateChain(gspec.Config, genesis, mxtash.NewFaker(), db, 1, func(i int, b *core.BlockGen) {
+		for _, tx := range txs {
+			b.AddTx(tx)
+		}
+	})
+
+	stack := createNode(t, false)
+	defer stack.Close()
+	config := mxt.DefaultConfig
+	config.Genesis = gspec
+	config.Ethash.PowMode = mxtash.ModeFake
+	config.AddressIndex = true
+	config.GraphQLTracing = true
+	mxtBackend, err := mxt.New(stack, &config)
+	if err != nil {
+		t.Fatalf("could not create mxt backend: %v", err)
+	}
+	if err := New(stack, mxtBackend.APIBackend, []string{}, []string{}); err != nil {
+		t.Fatalf("could not create graphql service: %v", err)
+	}
+	if err := stack.Start(); err != nil {
+		t.Fatalf("could not start node: %v", err)
+	}
+	if _, err := mxtBackend.BlockChain().InsertChain(blocks); err != nil {
+		t.Fatalf("could not import chain: %v", err)
+	}
+	if err := mxtBackend.TxPool().AddLocal(newTx(2, storer)); err != nil {
+		t.Fatalf("could not add pending transaction: %v", err)
+	}
+
+	// Call traces of the block transactions
+	var traces struct {
+		Block struct {
+			Transactions []struct {
+				Trace struct {
+					Type string
+					From common.Address
+					To   common.Address
+				}
+			}
+		}
+	}
+	doGQLQuery(t, "{block(number:1){transactions{trace{type from to}}}}", &traces)
+	if len(traces.Block.Transactions) != len(txs) {
+		t.Fatalf("wrong number of traces: have %d, want %d", len(traces.Block.Transactions), len(txs))
+	}
+	for i, tx := range traces.Block.Transactions {
+		if tx.Trace.Type != "CALL" || tx.Trace.From != sender || tx.Trace.To != *txs[i].To() {
+			t.Errorf("wrong trace of transaction %d: %+v", i, tx.Trace)
+		}
+	}
+
+	// State diff of the block
+	var diffs struct {
+		Block struct {
+			StateDiff []struct {
+				Address        common.Address
+				StorageCleared bool
+				Storage        []struct {
+					Slot, Before, After common.Hash
+				}
+			}
+		}
+	}
+	doGQLQuery(t, "{block(number:1){stateDiff{address storageCleared storage{slot before after}}}}", &diffs)
+	seen := make(map[common.Address]bool)
+	for _, diff := range diffs.Block.StateDiff {
+		seen[diff.Address] = true
+		switch diff.Address {
+		case storer:
+			if diff.StorageCleared || len(diff.Storage) != 1 || diff.Storage[0].After != common.HexToHash("0x01") {
+				t.Errorf("wrong storer diff: %+v", diff)
+			}
+		case killer:
+			if !diff.StorageCleared || len(diff.Storage) != 1 || diff.Storage[0].Before != common.HexToHash("0x2a") {
+				t.Errorf("wrong killer diff: %+v", diff)
+			}
+		}
+	}
+	if !seen[sender] || !seen[storer] || !seen[killer] {
+		t.Errorf("modified accounts missing from state diff: %+v", diffs.Block.StateDiff)
+	}
+
+	// Pending transaction filters
+	var pending struct {
+		Pending struct {
+			Transactions []struct {
+				Nonce hexutil.Uint64
+			}
+		}
+	}
+	doGQLQuery(t, fmt.Sprintf(`{pending{transactions(from:"%s",to:"%s"){nonce}}}`, sender.Hex(), storer.Hex()), &pending)
+	if len(pending.Pending.Transactions) != 1 || pending.Pending.Transactions[0].Nonce != 2 {
+		t.Errorf("wrong pending transactions: %+v", pending.Pending.Transactions)
+	}
+	doGQLQuery(t, fmt.Sprintf(`{pending{transactions(to:"%s"){nonce}}}`, killer.Hex()), &pending)
+	if len(pending.Pending.Transactions) != 0 {
+		t.Errorf("pending transactions not filtered: %+v", pending.Pending.Transactions)
+	}
+
+	// Address history, the index is built in the background
+	var history struct {
+		TransactionsByAddress []struct {
+			Hash  common.Hash
+			Block struct{ Number hexutil.Uint64 }
+		}
+	}
+	query := fmt.Sprintf(`{transactionsByAddress(address:"%s",limit:1){hash block{number}}}`, sender.Hex())
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		doGQLQuery(t, query, &history)
+		if len(history.TransactionsByAddress) > 0 || time.Now().After(deadline) {
+			break
+		}
+	}
+	if len(history.TransactionsByAddress) != 1 {
+		t.Fatalf("wrong number of indexed transactions: have %d, want 1", len(history.TransactionsByAddress))
+	}
+	if tx := history.TransactionsByAddress[0]; tx.Hash != txs[0].Hash() || tx.Block.Number != 1 {
+		t.Errorf("wrong indexed transaction: %+v", tx)
+	}
+}
+
 func createNode(t *testing.T, gqlEnabled bool) *node.Node {
 	stack, err := node.New(&node.Config{
 		HTTPHost: "127.0.0.1",
@@ -132,3 +375,32 @@ func doHTTPRequest(t *testing.T, req *http.Request) *http.Response {
 	}
 	return resp
 }
+
+// doGQLQuery runs a query against the test node and decodes the response data
+// into result. Responses containing errors fail the test.
+func doGQLQuery(t *testing.T, query string, result interface{}) {
+	t.Helper()
+
+	payload, _ := json.Marshal(map[string]interface{}{"query": query})
+	req, err := http.NewRequest(http.MmxtodPost, "http://127.0.0.1:9393/graphql", strings.NewReader(string(payload)))
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp := doHTTPRequest(t, req)
+	defer resp.Body.Close()
+
+	var response struct {
+		Data   json.RawMessage
+		Errors []interface{}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if len(response.Errors) > 0 {
+		t.Fatalf("query %s failed: %v", query, response.Errors)
+	}
+	if err := json.Unmarshal(response.Data, result); err != nil {
+		t.Fatalf("could not decode response data: %v", err)
+	}
+}
@@ -16,7 +16,43 @@
 
 package graphql
 
+// schema is the GraphQL schema served over HTTP.
 const schema string = `
+    schema {
+        query: Query
+        mutation: Mutation
+    }
+` + schemaTypes
+
+// subscriptionSchema is the GraphQL schema served to graphql-ws clients. It can't
+// be merged into schema, since graphql-go resolves all operation types on a
+// single root object and the logs subscription would clash with the logs query.
+const subscriptionSchema string = `
+    schema {
+        query: SubscriptionQuery
+        subscription: Subscription
+    }
+
+    # SubscriptionQuery is the query root of the subscription schema. Queries are
+    # served over HTTP, so it only identifies the chain being followed.
+    type SubscriptionQuery {
+        # ChainID returns the current chain ID for transaction replay protection.
+        chainID: BigInt!
+    }
+
+    type Subscription {
+        # NewBlocks emits every block that becomes the head of the chain.
+        newBlocks: Block!
+        # Logs emits the log entries matching the provided filter as the blocks
+        # containing them are imported.
+        logs(filter: BlockFilterCriteria!): Log!
+        # PendingTransactions emits every transaction entering the pending pool.
+        pendingTransactions: Transaction!
+    }
+` + schemaTypes
+
+// schemaTypes contains the types shared by all schemas.
+const schemaTypes string = `
     # Bytes32 is a 32 byte binary string, represented as 0x-prefixed hexadecimal.
     scalar Bytes32
     # Address is a 20 byte Ethereum address, represented as 0x-prefixed hexadecimal.
@@ -31,11 +67,6 @@ const schema string = `
     # Long is a 64 bit unsigned integer.
     scalar Long
 
-    schema {
-        query: Query
-        mutation: Mutation
-    }
-
     # Account is an Ethereum account at a particular block.
     type Account {
         # Address is the address owning the account.
@@ -115,11 +146,40 @@ const schema string = `
         # Logs is a list of log entries emitted by this transaction. If the
         # transaction has not yet been mined, this field will be null.
         logs: [Log!]
+        # Trace is the call tree produced by executing this transaction. If the
+        # transaction has not yet been mined, this field will be null. Tracing
+        # requires a node able to re-execute historical blocks.
+        trace: CallFrame
         r: BigInt!
         s: BigInt!
         v: BigInt!
     }
 
+    # CallFrame is a single message call made while executing a transaction.
+    type CallFrame {
+        # Type is the kind of call: CALL, CALLCODE, DELEGATECALL, STATICCALL,
+        # CREATE, CREATE2 or SELFDESTRUCT.
+        type: String!
+        # From is the account that initiated the call.
+        from: Address!
+        # To is the account that was called or created.
+        to: Address!
+        # Value is the value, in wei, transferred with the call.
+        value: BigInt!
+        # Gas is the amount of gas made available to the call.
+        gas: Long!
+        # GasUsed is the amount of gas consumed by the call.
+        gasUsed: Long!
+        # Input is the data passed to the call.
+        input: Bytes!
+        # Output is the data returned by the call.
+        output: Bytes!
+        # Error is the reason the call failed, or null if it succeeded.
+        error: String
+        # Calls is the list of calls made by this call, in execution order.
+        calls: [CallFrame!]!
+    }
+
     # BlockFilterCriteria encapsulates log filter criteria for a filter applied
     # to a single block.
     input BlockFilterCriteria {
@@ -209,6 +269,43 @@ const schema string = `
         # EstimateGas estimates the amount of gas that will be required for
         # successful execution of a transaction at the current block's state.
         estimateGas(data: CallData!): Long!
+        # StateDiff is the list of accounts modified by this block, sorted by
+        # address. Computing it requires a node able to re-execute historical
+        # blocks.
+        stateDiff: [AccountDiff!]!
+    }
+
+    # AccountDiff describes how a block changed a single account.
+    type AccountDiff {
+        # Address is the address of the modified account.
+        address: Address!
+        # BalanceBefore is the balance, in wei, before the block.
+        balanceBefore: BigInt!
+        # BalanceAfter is the balance, in wei, after the block.
+        balanceAfter: BigInt!
+        # NonceBefore is the nonce before the block.
+        nonceBefore: Long!
+        # NonceAfter is the nonce after the block.
+        nonceAfter: Long!
+        # CodeBefore is the contract code before the block.
+        codeBefore: Bytes!
+        # CodeAfter is the contract code after the block.
+        codeAfter: Bytes!
+        # Storage is the list of modified storage slots, sorted by slot.
+        storage: [StorageDiff!]!
+        # StorageCleared is true if the account self-destructed, deleting all of
+        # its storage. Slots with unknown keys are not listed in storage.
+        storageCleared: Boolean!
+    }
+
+    # StorageDiff describes how a block changed a single storage slot.
+    type StorageDiff {
+        # Slot is the storage slot that was modified.
+        slot: Bytes32!
+        # Before is the value of the slot before the block.
+        before: Bytes32!
+        # After is the value of the slot after the block.
+        after: Bytes32!
     }
 
     # CallData represents the data associated with a local contract call.
@@ -283,8 +380,9 @@ const schema string = `
     type Pending {
       # TransactionCount is the number of transactions in the pending state.
       transactionCount: Int!
-      # Transactions is a list of transactions in the current pending state.
-      transactions: [Transaction!]
+      # Transactions is a list of transactions in the current pending state,
+      # optionally restricted to the given sender and recipient.
+      transactions(from: Address, to: Address): [Transaction!]
       # Account fetches an Ethereum account for the pending state.
       account(address: Address!): Account!
       # Call executes a local call operation for the pending state.
@@ -295,9 +393,10 @@ const schema string = `
     }
 
     type Query {
-        # Block fetches an Ethereum block by number or by hash. If neither is
+        # Block fetches an Ethereum block by number, by hash or by tag, one of
+        # "latest", "earliest", "pending", "finalized" or "safe". If none is
         # supplied, the most recent known block is returned.
-        block(number: Long, hash: Bytes32): Block
+        block(number: Long, hash: Bytes32, tag: String): Block
         # Blocks returns all the blocks between two numbers, inclusive. If
         # to is not supplied, it defaults to the most recent known block.
         blocks(from: Long!, to: Long): [Block!]!
@@ -305,6 +404,11 @@ const schema string = `
         pending: Pending!
         # Transaction returns a transaction specified by its hash.
         transaction(hash: Bytes32!): Transaction
+        # TransactionsByAddress returns the transactions an account took part
+        # in, oldest first, skipping the first offset ones and returning at
+        # most limit (default 100, at most 1000) of them. This requires the node
+        # to maintain an address index.
+        transactionsByAddress(address: Address!, offset: Long, limit: Long): [Transaction!]!
         # Logs returns log entries matching the provided filter.
         logs(filter: FilterCriteria!): [Log!]!
         # GasPrice returns the node's estimate of a gas price sufficient to
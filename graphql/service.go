@@ -48,5 +48,18 @@ func newHandler(stack *node.Node, backend mxtapi.Backend, cors, vhosts []string)
 	stack.RegisterHandler("GraphQL", "/graphql", handler)
 	stack.RegisterHandler("GraphQL", "/graphql/", handler)
 
+	// Serve subscriptions to graphql-ws clients on the WebSocket endpoint
+	sq := &SubscriptionResolver{backend: backend}
+
+	ss, err := graphql.ParseSchema(subscriptionSchema, sq)
+	if err != nil {
+		return err
+	}
+	wsHandler := newWSHandler(ss, stack.Config().WSOrigins)
+
+	stack.RegisterWSHandler("/graphql", wsHandler)
+	stack.RegisterWSHandler("/graphql/", wsHandler)
+	stack.RegisterLifecycle(sq)
+
 	return nil
 }
@@ -0,0 +1,203 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package graphql
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/mxt/go-mxt"
+	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/common/hexutil"
+	"github.com/mxt/go-mxt/core/types"
+	"github.com/mxt/go-mxt/internal/mxtapi"
+	"github.com/mxt/go-mxt/mxt/filters"
+	"github.com/mxt/go-mxt/rpc"
+)
+
+var errSubscriptionsStopped = errors.New("subscription service stopped")
+
+// SubscriptionResolver is the root object of the subscription schema. Every
+// subscription is fed by the filter event system and lives until the context
+// of the operation is cancelled or the service is stopped.
+type SubscriptionResolver struct {
+	backend mxtapi.Backend
+
+	mu      sync.Mutex
+	events  *filters.EventSystem // Created on first subscription
+	stopped bool
+}
+
+// eventSystem returns the filter event system feeding the subscriptions.
+func (r *SubscriptionResolver) eventSystem() (*filters.EventSystem, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.stopped {
+		return nil, errSubscriptionsStopped
+	}
+	if r.events == nil {
+		r.events = filters.NewEventSystem(r.backend, false)
+	}
+	return r.events, nil
+}
+
+// Start implements node.Lifecycle. The event system is created lazily.
+func (r *SubscriptionResolver) Start() error {
+	return nil
+}
+
+// Stop implements node.Lifecycle, terminating the event system and with it
+// all running subscriptions.
+func (r *SubscriptionResolver) Stop() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.events != nil {
+		r.events.Stop()
+	}
+	r.stopped = true
+	return nil
+}
+
+func (r *SubscriptionResolver) ChainID(ctx context.Context) (hexutil.Big, error) {
+	return hexutil.Big(*r.backend.ChainConfig().ChainID), nil
+}
+
+func (r *SubscriptionResolver) NewBlocks(ctx context.Context) (<-chan *Block, error) {
+	es, err := r.eventSystem()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		headers = make(chan *types.Header)
+		sub     = es.SubscribeNewHeads(headers)
+		blocks  = make(chan *Block)
+	)
+	go func() {
+		defer close(blocks)
+		defer sub.Unsubscribe()
+
+		for {
+			select {
+			case header := <-headers:
+				numberOrHash := rpc.BlockNumberOrHashWithHash(header.Hash(), false)
+				block := &Block{
+					backend:      r.backend,
+					numberOrHash: &numberOrHash,
+					hash:         header.Hash(),
+					header:       header,
+				}
+				select {
+				case blocks <- block:
+				case <-ctx.Done():
+					return
+				}
+			case <-sub.Err():
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return blocks, nil
+}
+
+func (r *SubscriptionResolver) Logs(ctx context.Context, args struct{ Filter BlockFilterCriteria }) (<-chan *Log, error) {
+	var crit mxt.FilterQuery
+	if args.Filter.Addresses != nil {
+		crit.Addresses = *args.Filter.Addresses
+	}
+	if args.Filter.Topics != nil {
+		crit.Topics = *args.Filter.Topics
+	}
+	es, err := r.eventSystem()
+	if err != nil {
+		return nil, err
+	}
+	matches := make(chan []*types.Log)
+	sub, err := es.SubscribeLogs(crit, matches)
+	if err != nil {
+		return nil, err
+	}
+	logs := make(chan *Log)
+	go func() {
+		defer close(logs)
+		defer sub.Unsubscribe()
+
+		for {
+			select {
+			case batch := <-matches:
+				for _, log := range batch {
+					// The schema has no way to represent reorged logs, drop them
+					if log.Removed {
+						continue
+					}
+					select {
+					case logs <- &Log{
+						backend:     r.backend,
+						transaction: &Transaction{backend: r.backend, hash: log.TxHash},
+						log:         log,
+					}:
+					case <-ctx.Done():
+						return
+					}
+				}
+			case <-sub.Err():
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return logs, nil
+}
+
+func (r *SubscriptionResolver) PendingTransactions(ctx context.Context) (<-chan *Transaction, error) {
+	es, err := r.eventSystem()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		hashes = make(chan []common.Hash)
+		sub    = es.SubscribePendingTxs(hashes)
+		txs    = make(chan *Transaction)
+	)
+	go func() {
+		defer close(txs)
+		defer sub.Unsubscribe()
+
+		for {
+			select {
+			case batch := <-hashes:
+				for _, hash := range batch {
+					select {
+					case txs <- &Transaction{backend: r.backend, hash: hash}:
+					case <-ctx.Done():
+						return
+					}
+				}
+			case <-sub.Err():
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return txs, nil
+}
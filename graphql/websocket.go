@@ -0,0 +1,283 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package graphql
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mxt/go-mxt/log"
+	"github.com/mxt/go-mxt/rpc"
+
+	"github.com/gorilla/websocket"
+	"github.com/graph-gophers/graphql-go"
+)
+
+const (
+	// wsProtocol is the WebSocket subprotocol of the graphql-ws protocol.
+	wsProtocol = "graphql-ws"
+
+	// wsKeepAliveInterval is the interval at which keep-alive messages are sent
+	// to the client.
+	wsKeepAliveInterval = 30 * time.Second
+
+	// wsWriteTimeout is the time allowed to write a message to the client.
+	wsWriteTimeout = 10 * time.Second
+
+	// wsMaxOperations is the maximum number of concurrent operations a single
+	// client may run.
+	wsMaxOperations = 100
+
+	// wsReadLimit is the maximum size of a message read from the client, same as
+	// the limit of the RPC WebSocket endpoint.
+	wsReadLimit = 5 * 1024 * 1024
+)
+
+// graphql-ws message types.
+const (
+	gqlConnectionInit      = "connection_init"      // Client -> Server
+	gqlConnectionTerminate = "connection_terminate" // Client -> Server
+	gqlStart               = "start"                // Client -> Server
+	gqlStop                = "stop"                 // Client -> Server
+	gqlConnectionAck       = "connection_ack"       // Server -> Client
+	gqlConnectionError     = "connection_error"     // Server -> Client
+	gqlConnectionKeepAlive = "ka"                   // Server -> Client
+	gqlData                = "data"                 // Server -> Client
+	gqlError               = "error"                // Server -> Client
+	gqlComplete            = "complete"             // Server -> Client
+)
+
+// wsMessage is a single graphql-ws protocol message.
+type wsMessage struct {
+	ID      string          `json:"id,omitempty"`
+	Type    string          `json:"type"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// wsStartPayload is the payload of a start message.
+type wsStartPayload struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// wsErrorPayload is the payload of connection and operation errors.
+type wsErrorPayload struct {
+	Message string `json:"message"`
+}
+
+// wsHandler serves GraphQL subscriptions using the graphql-ws protocol.
+type wsHandler struct {
+	schema   *graphql.Schema
+	upgrader websocket.Upgrader
+}
+
+// newWSHandler creates a graphql-ws handler for the given schema, accepting
+// connections from the given origins.
+func newWSHandler(schema *graphql.Schema, origins []string) *wsHandler {
+	return &wsHandler{
+		schema: schema,
+		upgrader: websocket.Upgrader{
+			Subprotocols: []string{wsProtocol},
+			CheckOrigin:  rpc.WebsocketOriginValidator(origins),
+		},
+	}
+}
+
+func (h *wsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Debug("GraphQL WebSocket upgrade failed", "err", err)
+		return
+	}
+	conn.SetReadLimit(wsReadLimit)
+	c := &wsConn{
+		conn:   conn,
+		schema: h.schema,
+		ops:    make(map[string]*wsOperation),
+	}
+	c.serve()
+}
+
+// wsConn is a single graphql-ws client connection.
+type wsConn struct {
+	conn   *websocket.Conn
+	schema *graphql.Schema
+
+	writeLock sync.Mutex // Serializes writes, the connection only allows one writer
+	opsLock   sync.Mutex
+	ops       map[string]*wsOperation // Running operations by client supplied id
+	pend      sync.WaitGroup
+}
+
+// wsOperation is a running subscription operation.
+type wsOperation struct {
+	cancel context.CancelFunc
+}
+
+// serve runs the read loop of the connection until the client disconnects or
+// terminates the connection, then stops all running operations.
+func (c *wsConn) serve() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		c.pend.Wait()
+		c.conn.Close()
+	}()
+	if c.conn.Subprotocol() != wsProtocol {
+		c.send(&wsMessage{Type: gqlConnectionError}, wsErrorPayload{Message: "unsupported subprotocol"})
+		return
+	}
+	var initialised bool
+	for {
+		msg := new(wsMessage)
+		if err := c.conn.ReadJSON(msg); err != nil {
+			return
+		}
+		switch msg.Type {
+		case gqlConnectionInit:
+			c.send(&wsMessage{Type: gqlConnectionAck}, nil)
+			if !initialised {
+				initialised = true
+				c.pend.Add(1)
+				go c.keepAlive(ctx)
+			}
+
+		case gqlStart:
+			if !initialised {
+				c.send(&wsMessage{ID: msg.ID, Type: gqlError}, wsErrorPayload{Message: "connection not initialised"})
+				continue
+			}
+			c.start(ctx, msg)
+
+		case gqlStop:
+			c.stop(msg.ID)
+
+		case gqlConnectionTerminate:
+			return
+
+		default:
+			c.send(&wsMessage{ID: msg.ID, Type: gqlError}, wsErrorPayload{Message: "unknown message type " + msg.Type})
+		}
+	}
+}
+
+// start launches a new subscription operation.
+func (c *wsConn) start(ctx context.Context, msg *wsMessage) {
+	var payload wsStartPayload
+	if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+		c.send(&wsMessage{ID: msg.ID, Type: gqlError}, wsErrorPayload{Message: err.Error()})
+		return
+	}
+	c.opsLock.Lock()
+	if _, ok := c.ops[msg.ID]; ok {
+		c.opsLock.Unlock()
+		c.send(&wsMessage{ID: msg.ID, Type: gqlError}, wsErrorPayload{Message: "duplicate operation id"})
+		return
+	}
+	if len(c.ops) >= wsMaxOperations {
+		c.opsLock.Unlock()
+		c.send(&wsMessage{ID: msg.ID, Type: gqlError}, wsErrorPayload{Message: "too many operations"})
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	op := &wsOperation{cancel: cancel}
+	c.ops[msg.ID] = op
+	c.opsLock.Unlock()
+
+	results, err := c.schema.Subscribe(ctx, payload.Query, payload.OperationName, payload.Variables)
+	if err != nil {
+		c.finish(msg.ID, op)
+		c.send(&wsMessage{ID: msg.ID, Type: gqlError}, wsErrorPayload{Message: err.Error()})
+		return
+	}
+	c.pend.Add(1)
+	go func() {
+		defer c.pend.Done()
+		defer c.finish(msg.ID, op)
+
+		for result := range results {
+			if err := c.send(&wsMessage{ID: msg.ID, Type: gqlData}, result); err != nil {
+				return
+			}
+		}
+		if ctx.Err() == nil {
+			c.send(&wsMessage{ID: msg.ID, Type: gqlComplete}, nil)
+		}
+	}()
+}
+
+// stop cancels a running operation.
+func (c *wsConn) stop(id string) {
+	c.opsLock.Lock()
+	defer c.opsLock.Unlock()
+
+	if op, ok := c.ops[id]; ok {
+		op.cancel()
+		delete(c.ops, id)
+	}
+}
+
+// finish cancels and forgets an operation, unless it was already stopped and
+// its id reused by a newer one.
+func (c *wsConn) finish(id string, op *wsOperation) {
+	c.opsLock.Lock()
+	defer c.opsLock.Unlock()
+
+	op.cancel()
+	if c.ops[id] == op {
+		delete(c.ops, id)
+	}
+}
+
+// keepAlive periodically sends keep-alive messages until the connection closes.
+func (c *wsConn) keepAlive(ctx context.Context) {
+	defer c.pend.Done()
+
+	ticker := time.NewTicker(wsKeepAliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.send(&wsMessage{Type: gqlConnectionKeepAlive}, nil); err != nil {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// send writes a message with the given payload to the client.
+func (c *wsConn) send(msg *wsMessage, payload interface{}) error {
+	if payload != nil {
+		blob, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		msg.Payload = blob
+	}
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return c.conn.WriteJSON(msg)
+}
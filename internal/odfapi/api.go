@@ -1385,6 +1385,43 @@ func (s *PublicTransactionPoolAPI) GetTransactionByHash(ctx context.Context, has
 	return nil, nil
 }
 
+// GetTransactionsByAddress returns the transactions the given account took part
+// in, oldest first. Besides senders and recipients, created contracts and the
+// participants of internal calls are included. The limit defaults to
+// DefaultAddressTxLimit and is capped at MaxAddressTxLimit.
+func (s *PublicTransactionPoolAPI) GetTransactionsByAddress(ctx context.Context, address common.Address, offset *hexutil.Uint64, limit *hexutil.Uint64) ([]*RPCTransaction, error) {
+	indexer, ok := s.b.(AddressIndexBackend)
+	if !ok {
+		return nil, errors.New("address index not supported")
+	}
+	from, count := uint64(0), uint64(DefaultAddressTxLimit)
+	if offset != nil {
+		from = uint64(*offset)
+	}
+	if limit != nil {
+		count = uint64(*limit)
+	}
+	if count > MaxAddressTxLimit {
+		count = MaxAddressTxLimit
+	}
+	hashes, err := indexer.TransactionsByAddress(ctx, address, from, count)
+	if err != nil {
+		return nil, err
+	}
+	txs := make([]*RPCTransaction, 0, len(hashes))
+	for _, hash := range hashes {
+		tx, blockHash, blockNumber, index, err := s.b.GetTransaction(ctx, hash)
+		if err != nil {
+			return nil, err
+		}
+		if tx == nil {
+			continue // Reorged out since the index was read
+		}
+		txs = append(txs, newRPCTransaction(tx, blockHash, blockNumber, index))
+	}
+	return txs, nil
+}
+
 // GetRawTransactionByHash returns the bytes of the transaction for the given hash.
 func (s *PublicTransactionPoolAPI) GetRawTransactionByHash(ctx context.Context, hash common.Hash) (hexutil.Bytes, error) {
 	// Retrieve a finalized transaction, or a pooled otherwise
@@ -19,6 +19,7 @@ package mxtapi
 
 import (
 	"context"
+	"fmt"
 	"math/big"
 
 	"github.com/mxt/go-mxt/accounts"
@@ -90,6 +91,63 @@ type Backend interface {
 	Engine() consensus.Engine
 }
 
+const (
+	// DefaultAddressTxLimit is the number of transactions returned by address
+	// index queries if no limit is requested.
+	DefaultAddressTxLimit = 100
+
+	// MaxAddressTxLimit caps the number of transactions returned by a single
+	// address index query.
+	MaxAddressTxLimit = 1000
+)
+
+// AddressIndexBackend is an optional extension of Backend, implemented by nodes
+// that maintain an index from accounts to the transactions they took part in.
+type AddressIndexBackend interface {
+	// TransactionsByAddress returns the hashes of the transactions the given
+	// account participated in, oldest first, skipping the first offset ones and
+	// returning at most limit of them.
+	TransactionsByAddress(ctx context.Context, address common.Address, offset, limit uint64) ([]common.Hash, error)
+}
+
+// TraceBackend is an optional extension of Backend, implemented by nodes that
+// are able to re-execute historical blocks.
+type TraceBackend interface {
+	// TraceBlockCalls returns the call tree of every transaction in the block.
+	TraceBlockCalls(ctx context.Context, hash common.Hash) ([]*CallFrame, error)
+
+	// BlockStateDiff returns the accounts modified by the block.
+	BlockStateDiff(ctx context.Context, hash common.Hash) ([]*AccountDiff, error)
+}
+
+// FinalityHeader resolves the finalized and safe block tags through the
+// consensus engine, failing if the engine can't tell which blocks are final.
+func FinalityHeader(engine consensus.Engine, chain consensus.ChainHeaderReader, number rpc.BlockNumber) (*types.Header, error) {
+	var tag string
+	switch number {
+	case rpc.FinalizedBlockNumber:
+		tag = "finalized"
+	case rpc.SafeBlockNumber:
+		tag = "safe"
+	default:
+		return nil, fmt.Errorf("block number %d is not a finality tag", number)
+	}
+	finality, ok := engine.(consensus.Finality)
+	if !ok {
+		return nil, fmt.Errorf("%s block not supported by the consensus engine", tag)
+	}
+	var header *types.Header
+	if number == rpc.FinalizedBlockNumber {
+		header = finality.FinalizedHeader(chain)
+	} else {
+		header = finality.SafeHeader(chain)
+	}
+	if header == nil {
+		return nil, fmt.Errorf("%s block not available", tag)
+	}
+	return header, nil
+}
+
 func GetAPIs(apiBackend Backend) []rpc.API {
 	nonceLock := new(AddrLocker)
 	return []rpc.API{
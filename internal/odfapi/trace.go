@@ -0,0 +1,62 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package mxtapi
+
+import (
+	"math/big"
+
+	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/common/hexutil"
+)
+
+// CallFrame is a single call in the call tree of a transaction, in the format
+// produced by the callTracer.
+type CallFrame struct {
+	Type    string         `json:"type"`
+	From    common.Address `json:"from"`
+	To      common.Address `json:"to"`
+	Value   *hexutil.Big   `json:"value,omitempty"`
+	Gas     hexutil.Uint64 `json:"gas"`
+	GasUsed hexutil.Uint64 `json:"gasUsed"`
+	Input   hexutil.Bytes  `json:"input"`
+	Output  hexutil.Bytes  `json:"output,omitempty"`
+	Error   string         `json:"error,omitempty"`
+	Calls   []*CallFrame   `json:"calls,omitempty"`
+}
+
+// AccountDiff describes the changes a block made to a single account.
+type AccountDiff struct {
+	Address       common.Address
+	BalanceBefore *big.Int
+	BalanceAfter  *big.Int
+	NonceBefore   uint64
+	NonceAfter    uint64
+	CodeBefore    []byte
+	CodeAfter     []byte
+	Storage       []*StorageDiff
+
+	// StorageCleared is set if the account self-destructed, deleting all of its
+	// storage. Deleted slots are listed in Storage if their keys are known.
+	StorageCleared bool
+}
+
+// StorageDiff describes the change of a single storage slot.
+type StorageDiff struct {
+	Slot   common.Hash
+	Before common.Hash
+	After  common.Hash
+}
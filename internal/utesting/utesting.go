@@ -27,6 +27,7 @@ import (
 	"io"
 	"regexp"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 )
@@ -65,17 +66,7 @@ func MatchTests(tests []Test, expr string) []Test {
 func RunTests(tests []Test, report io.Writer) []Result {
 	results := make([]Result, len(tests))
 	for i, test := range tests {
-		var output io.Writer
-		buffer := new(bytes.Buffer)
-		output = buffer
-		if report != nil {
-			output = io.MultiWriter(buffer, report)
-		}
-		start := time.Now()
-		results[i].Name = test.Name
-		results[i].Failed = run(test, output)
-		results[i].Duration = time.Since(start)
-		results[i].Output = buffer.String()
+		results[i] = runTest(test, report)
 		if report != nil {
 			printResult(results[i], report)
 		}
@@ -83,6 +74,37 @@ func RunTests(tests []Test, report io.Writer) []Result {
 	return results
 }
 
+// RunTAP executes all given tests in order and writes a report in Test Anything
+// Protocol (TAP) version 13 format to the report writer. Test output is included
+// in the report as diagnostic lines.
+func RunTAP(tests []Test, report io.Writer) []Result {
+	fmt.Fprintf(report, "TAP version 13\n1..%d\n", len(tests))
+	results := make([]Result, len(tests))
+	for i, test := range tests {
+		results[i] = runTest(test, nil)
+		printTAPResult(i+1, results[i], report)
+	}
+	return results
+}
+
+// runTest executes a single test. If the live writer is non-nil, the test output
+// is also written to it while the test is running.
+func runTest(test Test, live io.Writer) Result {
+	buffer := new(bytes.Buffer)
+	var output io.Writer = buffer
+	if live != nil {
+		output = io.MultiWriter(buffer, live)
+	}
+	start := time.Now()
+	failed := run(test, output)
+	return Result{
+		Name:     test.Name,
+		Failed:   failed,
+		Output:   buffer.String(),
+		Duration: time.Since(start),
+	}
+}
+
 func printResult(r Result, w io.Writer) {
 	pd := r.Duration.Truncate(100 * time.Microsecond)
 	if r.Failed {
@@ -92,6 +114,20 @@ func printResult(r Result, w io.Writer) {
 	}
 }
 
+func printTAPResult(num int, r Result, w io.Writer) {
+	status := "ok"
+	if r.Failed {
+		status = "not ok"
+	}
+	fmt.Fprintf(w, "%s %d %s\n", status, num, r.Name)
+	for _, line := range strings.Split(strings.TrimRight(r.Output, "\n"), "\n") {
+		if line != "" {
+			fmt.Fprintf(w, "# %s\n", line)
+		}
+	}
+	fmt.Fprintf(w, "# (%v)\n", r.Duration.Truncate(100*time.Microsecond))
+}
+
 // CountFailures returns the number of failed tests in the result slice.
 func CountFailures(rr []Result) int {
 	count := 0
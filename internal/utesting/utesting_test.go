@@ -17,6 +17,7 @@
 package utesting
 
 import (
+	"bytes"
 	"strings"
 	"testing"
 )
@@ -53,3 +54,43 @@ func TestTest(t *testing.T) {
 		t.Fatalf("wrong result for panicking test: %#v", results[2])
 	}
 }
+
+func TestTAP(t *testing.T) {
+	tests := []Test{
+		{
+			Name: "successful test",
+			Fn:   func(t *T) {},
+		},
+		{
+			Name: "failing test",
+			Fn: func(t *T) {
+				t.Log("output\nmore output")
+				t.Error("failed")
+			},
+		},
+	}
+	var report bytes.Buffer
+	results := RunTAP(tests, &report)
+	if results[0].Failed || !results[1].Failed {
+		t.Fatalf("wrong results: %#v", results)
+	}
+
+	// Strip the durations, they vary between runs.
+	var lines []string
+	for _, line := range strings.Split(report.String(), "\n") {
+		if !strings.HasPrefix(line, "# (") {
+			lines = append(lines, line)
+		}
+	}
+	want := `TAP version 13
+1..2
+ok 1 successful test
+not ok 2 failing test
+# output
+# more output
+# failed
+`
+	if have := strings.Join(lines, "\n"); have != want {
+		t.Fatalf("wrong report:\n%s", have)
+	}
+}
@@ -142,6 +142,11 @@ web3._extend({
 			call: 'mxtash_submitHashRate',
 			params: 2,
 		}),
+		new web3._extend.Mmxtod({
+			name: 'getStratumWorkers',
+			call: 'mxtash_getStratumWorkers',
+			params: 0
+		}),
 	]
 });
 `
@@ -170,6 +175,12 @@ web3._extend({
 			call: 'admin_removeTrustedPeer',
 			params: 1
 		}),
+		new web3._extend.Mmxtod({
+			name: 'banPeer',
+			call: 'admin_banPeer',
+			params: 2,
+			inputFormatter: [null, null]
+		}),
 		new web3._extend.Mmxtod({
 			name: 'exportChain',
 			call: 'admin_exportChain',
@@ -216,6 +227,10 @@ web3._extend({
 			name: 'peers',
 			getter: 'admin_peers'
 		}),
+		new web3._extend.Property({
+			name: 'peerScores',
+			getter: 'admin_peerScores'
+		}),
 		new web3._extend.Property({
 			name: 'datadir',
 			getter: 'admin_datadir'
@@ -448,6 +463,11 @@ web3._extend({
 			call: 'debug_getBadBlocks',
 			params: 0,
 		}),
+		new web3._extend.Mmxtod({
+			name: 'setChainConfig',
+			call: 'debug_setChainConfig',
+			params: 1,
+		}),
 		new web3._extend.Mmxtod({
 			name: 'storageRangeAt',
 			call: 'debug_storageRangeAt',
@@ -549,6 +569,12 @@ web3._extend({
 			call: 'mxt_getRawTransactionByHash',
 			params: 1
 		}),
+		new web3._extend.Mmxtod({
+			name: 'getTransactionsByAddress',
+			call: 'mxt_getTransactionsByAddress',
+			params: 3,
+			inputFormatter: [web3._extend.formatters.inputAddressFormatter, null, null]
+		}),
 		new web3._extend.Mmxtod({
 			name: 'getRawTransactionFromBlock',
 			call: function(args) {
@@ -617,6 +643,17 @@ web3._extend({
 			call: 'miner_setRecommitInterval',
 			params: 1,
 		}),
+		new web3._extend.Mmxtod({
+			name: 'buildPayload',
+			call: 'miner_buildPayload',
+			params: 5,
+			inputFormatter: [null, web3._extend.utils.fromDecimal, web3._extend.formatters.inputAddressFormatter, null, null]
+		}),
+		new web3._extend.Mmxtod({
+			name: 'importPayload',
+			call: 'miner_importPayload',
+			params: 1
+		}),
 		new web3._extend.Mmxtod({
 			name: 'getHashrate',
 			call: 'miner_getHashrate'
@@ -34,6 +34,7 @@ import (
 	"github.com/mxt/go-mxt/mxt/gasprice"
 	"github.com/mxt/go-mxt/mxtdb"
 	"github.com/mxt/go-mxt/event"
+	"github.com/mxt/go-mxt/internal/mxtapi"
 	"github.com/mxt/go-mxt/light"
 	"github.com/mxt/go-mxt/params"
 	"github.com/mxt/go-mxt/rpc"
@@ -62,6 +63,9 @@ func (b *LesApiBackend) HeaderByNumber(ctx context.Context, number rpc.BlockNumb
 	if number == rpc.LatestBlockNumber || number == rpc.PendingBlockNumber {
 		return b.mxt.blockchain.CurrentHeader(), nil
 	}
+	if number == rpc.FinalizedBlockNumber || number == rpc.SafeBlockNumber {
+		return mxtapi.FinalityHeader(b.mxt.engine, b.mxt.blockchain.HeaderChain(), number)
+	}
 	return b.mxt.blockchain.GetHeaderByNumberOdr(ctx, uint64(number))
 }
 
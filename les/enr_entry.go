@@ -34,6 +34,11 @@ func (e lesEntry) ENRKey() string {
 	return "les"
 }
 
+// lesDialFilter skips nodes which advertise the mxt protocol but no "les" entry,
+// as they are full nodes not serving light clients. Nodes advertising neither are
+// dialed, their records may be incomplete.
+var lesDialFilter = enode.AnyOf(enode.HasEntry(lesEntry{}.ENRKey()), enode.Not(enode.HasEntry("mxt")))
+
 // setupDiscovery creates the node discovery source for the mxt protocol.
 func (mxt *LightEthereum) setupDiscovery(cfg *p2p.Config) (enode.Iterator, error) {
 	if cfg.NoDiscovery || len(mxt.config.DiscoveryURLs) == 0 {
@@ -0,0 +1,156 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/core"
+	"github.com/mxt/go-mxt/core/state"
+	"github.com/mxt/go-mxt/core/types"
+)
+
+// DefaultBlockBuilder is the name of the block building policy used if none is
+// configured, filling blocks with local and then remote transactions by price.
+const DefaultBlockBuilder = "price"
+
+// TxOrdering iterates over candidate transactions in the order they should be
+// included into a block. Transactions of the same sender must be returned in
+// nonce order. It is satisfied by types.TransactionsByPriceAndNonce.
+type TxOrdering interface {
+	// Peek returns the next transaction to include, nil if none are left.
+	Peek() *types.Transaction
+
+	// Shift replaces the current transaction with the next one of the same
+	// sender, after it was included or skipped.
+	Shift()
+
+	// Pop drops the current transaction along with all the following ones of
+	// the same sender, after it could not be included.
+	Pop()
+}
+
+// TxBatch is a group of candidate transactions committed into a block before
+// moving on to the next batch.
+type TxBatch struct {
+	Txs     TxOrdering // Transactions of the batch in inclusion order
+	Reserve uint64     // Gas to keep available for later batches while filling this one
+}
+
+// BlockEnv is the block being built, as exposed to block building policies.
+type BlockEnv struct {
+	Header *types.Header  // Header of the block, the gas used is not final
+	Signer types.Signer   // Signer for the block number
+	State  *state.StateDB // State after the transactions committed so far, must not be modified
+	TxPool *core.TxPool   // Transaction pool of the node
+}
+
+// BlockBuilder is a block building policy, deciding which transactions are
+// candidates for a block, their order, which ones may be included and how much
+// gas to reserve for them.
+type BlockBuilder interface {
+	// Batches returns the candidate transactions of a new block, in the order
+	// the batches should be committed.
+	Batches(env *BlockEnv) ([]*TxBatch, error)
+
+	// Include reports whmxter a candidate transaction may be included into the
+	// block. Rejected transactions are dropped along with all the following ones
+	// of the same sender.
+	Include(env *BlockEnv, from common.Address, tx *types.Transaction) bool
+}
+
+var (
+	buildersLock sync.RWMutex
+	builders     = map[string]func() BlockBuilder{
+		DefaultBlockBuilder: func() BlockBuilder { return priceBuilder{} },
+	}
+)
+
+// RegisterBlockBuilder makes a block building policy selectable by name through
+// Config.BlockBuilder. It panics if the name is already registered.
+func RegisterBlockBuilder(name string, constructor func() BlockBuilder) {
+	buildersLock.Lock()
+	defer buildersLock.Unlock()
+
+	if _, ok := builders[name]; ok {
+		panic(fmt.Sprintf("block builder %q already registered", name))
+	}
+	builders[name] = constructor
+}
+
+// BlockBuilders returns the names of all the registered block building policies.
+func BlockBuilders() []string {
+	buildersLock.RLock()
+	defer buildersLock.RUnlock()
+
+	names := make([]string, 0, len(builders))
+	for name := range builders {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newBlockBuilder creates the block building policy registered with the given
+// name, or the default one if the name is empty.
+func newBlockBuilder(name string) (BlockBuilder, error) {
+	if name == "" {
+		name = DefaultBlockBuilder
+	}
+	buildersLock.RLock()
+	defer buildersLock.RUnlock()
+
+	constructor, ok := builders[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown block builder %q", name)
+	}
+	return constructor(), nil
+}
+
+// priceBuilder is the default block building policy, including all pending
+// transactions by price and nonce, the ones of local accounts first.
+type priceBuilder struct{}
+
+func (priceBuilder) Batches(env *BlockEnv) ([]*TxBatch, error) {
+	pending, err := env.TxPool.Pending()
+	if err != nil {
+		return nil, err
+	}
+	// Split the pending transactions into locals and remotes
+	localTxs, remoteTxs := make(map[common.Address]types.Transactions), pending
+	for _, account := range env.TxPool.Locals() {
+		if txs := remoteTxs[account]; len(txs) > 0 {
+			delete(remoteTxs, account)
+			localTxs[account] = txs
+		}
+	}
+	var batches []*TxBatch
+	if len(localTxs) > 0 {
+		batches = append(batches, &TxBatch{Txs: types.NewTransactionsByPriceAndNonce(env.Signer, localTxs)})
+	}
+	if len(remoteTxs) > 0 {
+		batches = append(batches, &TxBatch{Txs: types.NewTransactionsByPriceAndNonce(env.Signer, remoteTxs)})
+	}
+	return batches, nil
+}
+
+func (priceBuilder) Include(env *BlockEnv, from common.Address, tx *types.Transaction) bool {
+	return true
+}
@@ -23,6 +23,7 @@ import (
 	"time"
 
 	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/common/mclock"
 	"github.com/mxt/go-mxt/common/hexutil"
 	"github.com/mxt/go-mxt/consensus"
 	"github.com/mxt/go-mxt/core"
@@ -50,6 +51,10 @@ type Config struct {
 	GasPrice  *big.Int       // Minimum gas price for mining a transaction
 	Recommit  time.Duration  // The time interval for miner to re-create mining work.
 	Noverify  bool           // Disable remote mining solution verification(only useful in mxtash).
+
+	BlockBuilder string `toml:",omitempty"` // Name of the block building policy (default = "price")
+
+	Clock mclock.Clock `toml:"-"` // Time source for block timestamps and recommit timers (default = system clock)
 }
 
 // Miner creates blocks and searches for proof-of-work values.
@@ -64,7 +69,11 @@ type Miner struct {
 	stopCh   chan struct{}
 }
 
-func New(mxt Backend, config *Config, chainConfig *params.ChainConfig, mux *event.TypeMux, engine consensus.Engine, isLocalBlock func(block *types.Block) bool) *Miner {
+func New(mxt Backend, config *Config, mux *event.TypeMux, engine consensus.Engine, isLocalBlock func(block *types.Block) bool) (*Miner, error) {
+	worker, err := newWorker(config, engine, mxt, mux, isLocalBlock, true)
+	if err != nil {
+		return nil, err
+	}
 	miner := &Miner{
 		mxt:     mxt,
 		mux:     mux,
@@ -72,11 +81,11 @@ func New(mxt Backend, config *Config, chainConfig *params.ChainConfig, mux *even
 		exitCh:  make(chan struct{}),
 		startCh: make(chan common.Address),
 		stopCh:  make(chan struct{}),
-		worker:  newWorker(config, chainConfig, engine, mxt, mux, isLocalBlock, true),
+		worker:  worker,
 	}
 	go miner.update()
 
-	return miner
+	return miner, nil
 }
 
 // update keeps track of the downloader events. Please be aware that this is a one shot type of update loop.
@@ -193,6 +202,18 @@ func (miner *Miner) PendingBlock() *types.Block {
 	return miner.worker.pendingBlock()
 }
 
+// BuildPayload assembles a block on request without sealing it, leaving the
+// mining work untouched.
+func (miner *Miner) BuildPayload(args *PayloadArgs) (*Payload, error) {
+	return miner.worker.requestPayload(args)
+}
+
+// ImportPayload inserts the externally sealed version of a payload built by
+// BuildPayload into the chain, returning the hash of the sealed block.
+func (miner *Miner) ImportPayload(header *types.Header) (common.Hash, error) {
+	return miner.worker.importPayload(header)
+}
+
 func (miner *Miner) SetEtherbase(addr common.Address) {
 	miner.coinbase = addr
 	miner.worker.setEtherbase(addr)
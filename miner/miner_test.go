@@ -257,5 +257,9 @@ func createMiner(t *testing.T) (*Miner, *event.TypeMux) {
 	// Create event Mux
 	mux := new(event.TypeMux)
 	// Create Miner
-	return New(backend, &config, chainConfig, mux, engine, nil), mux
+	miner, err := New(backend, &config, mux, engine, nil)
+	if err != nil {
+		t.Fatalf("can't create miner %v", err)
+	}
+	return miner, mux
 }
@@ -0,0 +1,191 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package miner
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/consensus/misc"
+	"github.com/mxt/go-mxt/core"
+	"github.com/mxt/go-mxt/core/types"
+	"github.com/mxt/go-mxt/params"
+)
+
+const (
+	// payloadCacheSize is the number of recently built payloads kept around to
+	// be imported once sealed.
+	payloadCacheSize = 16
+)
+
+var (
+	// errMinerClosed is returned if a payload is requested from a closed miner.
+	errMinerClosed = errors.New("miner closed")
+
+	// errUnknownPayload is returned if a sealed header doesn't belong to any
+	// recently built payload.
+	errUnknownPayload = errors.New("unknown payload")
+)
+
+// PayloadArgs are the parameters of a block built on request.
+type PayloadArgs struct {
+	ParentHash   common.Hash        // Hash of the block to build on
+	Timestamp    uint64             // Timestamp of the block, must be after the parent's
+	FeeRecipient common.Address     // Coinbase of the block
+	ExtraData    []byte             // Extra data of the block, may be overridden by the engine
+	Txs          types.Transactions // Transactions to include in order, all must apply; nil to use the block builder
+}
+
+// Payload is an assembled but unsealed block.
+type Payload struct {
+	Header       *types.Header      `json:"header"`
+	Transactions types.Transactions `json:"transactions"`
+	Receipts     types.Receipts     `json:"receipts"`
+	StateRoot    common.Hash        `json:"stateRoot"`
+	SealHash     common.Hash        `json:"sealHash"`
+}
+
+// payloadReq is a request to build a payload, served by the worker main loop.
+type payloadReq struct {
+	args   *PayloadArgs
+	result chan *payloadResult
+}
+
+// payloadResult is the outcome of a payload request.
+type payloadResult struct {
+	payload *Payload
+	err     error
+}
+
+// requestPayload asks the worker main loop to build a payload and waits for it.
+func (w *worker) requestPayload(args *PayloadArgs) (*Payload, error) {
+	req := &payloadReq{args: args, result: make(chan *payloadResult, 1)}
+	select {
+	case w.payloadCh <- req:
+	case <-w.exitCh:
+		return nil, errMinerClosed
+	}
+	select {
+	case res := <-req.result:
+		return res.payload, res.err
+	case <-w.exitCh:
+		return nil, errMinerClosed
+	}
+}
+
+// buildPayload assembles a block on top of the requested parent without sealing
+// it, leaving the current mining environment untouched.
+func (w *worker) buildPayload(args *PayloadArgs) (*Payload, error) {
+	if uint64(len(args.ExtraData)) > params.MaximumExtraDataSize {
+		return nil, fmt.Errorf("extra exceeds max length. %d > %v", len(args.ExtraData), params.MaximumExtraDataSize)
+	}
+	parent := w.chain.GetBlockByHash(args.ParentHash)
+	if parent == nil {
+		return nil, fmt.Errorf("unknown parent %x", args.ParentHash)
+	}
+	if args.Timestamp <= parent.Time() {
+		return nil, fmt.Errorf("timestamp %d not after parent timestamp %d", args.Timestamp, parent.Time())
+	}
+	header := &types.Header{
+		ParentHash: parent.Hash(),
+		Number:     new(big.Int).Add(parent.Number(), common.Big1),
+		GasLimit:   core.CalcGasLimit(parent, w.config.GasFloor, w.config.GasCeil),
+		Extra:      args.ExtraData,
+		Time:       args.Timestamp,
+		Coinbase:   args.FeeRecipient,
+	}
+	if err := w.engine.Prepare(w.chain, header); err != nil {
+		return nil, fmt.Errorf("failed to prepare header: %v", err)
+	}
+	w.applyDAOExtra(header)
+
+	// Build in a fresh environment, restoring the mining one afterwards
+	current := w.current
+	defer func() { w.current = current }()
+
+	if err := w.makeCurrent(parent, header); err != nil {
+		return nil, fmt.Errorf("failed to create payload context: %v", err)
+	}
+	env := w.current
+	env.payload = true
+	env.gasPool = new(core.GasPool).AddGas(header.GasLimit)
+
+	if w.chain.Config().DAOForkSupport && w.chain.Config().DAOForkBlock != nil && w.chain.Config().DAOForkBlock.Cmp(header.Number) == 0 {
+		misc.ApplyDAOHardFork(env.state)
+	}
+	if args.Txs != nil {
+		if err := w.commitPayloadTxs(args.Txs, header.Coinbase); err != nil {
+			return nil, err
+		}
+	} else {
+		batches, err := w.builder.Batches(&BlockEnv{Header: header, Signer: env.signer, State: env.state, TxPool: w.mxt.TxPool()})
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch candidate transactions: %v", err)
+		}
+		w.commitBatches(batches, header.Coinbase, nil)
+	}
+	receipts := copyReceipts(env.receipts)
+	block, err := w.engine.FinalizeAndAssemble(w.chain, header, env.state, env.txs, nil, receipts)
+	if err != nil {
+		return nil, err
+	}
+	sealHash := w.engine.SealHash(block.Header())
+	w.payloads.Add(sealHash, block)
+
+	return &Payload{
+		Header:       block.Header(),
+		Transactions: block.Transactions(),
+		Receipts:     receipts,
+		StateRoot:    block.Root(),
+		SealHash:     sealHash,
+	}, nil
+}
+
+// commitPayloadTxs applies an explicit transaction list in order. Unlike the
+// block builder path nothing is skipped: the first transaction which can't be
+// included fails the payload.
+func (w *worker) commitPayloadTxs(txs types.Transactions, coinbase common.Address) error {
+	env := w.current
+	for i, tx := range txs {
+		if tx.Protected() && !w.chain.Config().IsEIP155(env.header.Number) {
+			return fmt.Errorf("transaction %d (%x): replay protection not active", i, tx.Hash())
+		}
+		env.state.Prepare(tx.Hash(), common.Hash{}, env.tcount)
+		if _, err := w.commitTransaction(tx, coinbase); err != nil {
+			return fmt.Errorf("transaction %d (%x): %v", i, tx.Hash(), err)
+		}
+		env.tcount++
+	}
+	return nil
+}
+
+// importPayload inserts the sealed version of a recently built payload into the
+// chain and announces it for broadcasting, returning the hash of the sealed block.
+func (w *worker) importPayload(header *types.Header) (common.Hash, error) {
+	cached, ok := w.payloads.Get(w.engine.SealHash(header))
+	if !ok {
+		return common.Hash{}, errUnknownPayload
+	}
+	block := cached.(*types.Block).WithSeal(header)
+	if _, err := w.chain.InsertChain(types.Blocks{block}); err != nil {
+		return common.Hash{}, err
+	}
+	w.mux.Post(core.NewMinedBlockEvent{Block: block})
+	return block.Hash(), nil
+}
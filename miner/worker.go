@@ -25,7 +25,9 @@ import (
 	"time"
 
 	mapset "github.com/deckarep/golang-set"
+	lru "github.com/hashicorp/golang-lru"
 	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/common/mclock"
 	"github.com/mxt/go-mxt/consensus"
 	"github.com/mxt/go-mxt/consensus/misc"
 	"github.com/mxt/go-mxt/core"
@@ -91,6 +93,8 @@ type environment struct {
 	header   *types.Header
 	txs      []*types.Transaction
 	receipts []*types.Receipt
+
+	payload bool // Whmxter the block is built on request instead of for mining
 }
 
 // task contains all information for consensus engine sealing and result submitting.
@@ -123,11 +127,12 @@ type intervalAdjust struct {
 // worker is the main object which takes care of submitting new work to consensus engine
 // and gathering the sealing result.
 type worker struct {
-	config      *Config
-	chainConfig *params.ChainConfig
-	engine      consensus.Engine
-	mxt         Backend
-	chain       *core.BlockChain
+	config  *Config
+	engine  consensus.Engine
+	clock   mclock.Clock
+	mxt     Backend
+	chain   *core.BlockChain
+	builder BlockBuilder // Policy selecting and ordering the transactions of new blocks
 
 	// Feeds
 	pendingLogsFeed event.Feed
@@ -149,6 +154,7 @@ type worker struct {
 	exitCh             chan struct{}
 	resubmitIntervalCh chan time.Duration
 	resubmitAdjustCh   chan *intervalAdjust
+	payloadCh          chan *payloadReq
 
 	current      *environment                 // An environment for current running cycle.
 	localUncles  map[common.Hash]*types.Block // A set of side blocks generated locally as the possible uncle blocks.
@@ -162,6 +168,8 @@ type worker struct {
 	pendingMu    sync.RWMutex
 	pendingTasks map[common.Hash]*task
 
+	payloads *lru.Cache // Recently built payloads by seal hash, awaiting import once sealed
+
 	snapshotMu    sync.RWMutex // The lock used to protect the block snapshot and state snapshot
 	snapshotBlock *types.Block
 	snapshotState *state.StateDB
@@ -187,11 +195,15 @@ type worker struct {
 	resubmitHook func(time.Duration, time.Duration) // Mmxtod to call upon updating resubmitting interval.
 }
 
-func newWorker(config *Config, chainConfig *params.ChainConfig, engine consensus.Engine, mxt Backend, mux *event.TypeMux, isLocalBlock func(*types.Block) bool, init bool) *worker {
+func newWorker(config *Config, engine consensus.Engine, mxt Backend, mux *event.TypeMux, isLocalBlock func(*types.Block) bool, init bool) (*worker, error) {
+	builder, err := newBlockBuilder(config.BlockBuilder)
+	if err != nil {
+		return nil, err
+	}
 	worker := &worker{
 		config:             config,
-		chainConfig:        chainConfig,
 		engine:             engine,
+		clock:              config.Clock,
 		mxt:                mxt,
 		mux:                mux,
 		chain:              mxt.BlockChain(),
@@ -210,7 +222,14 @@ func newWorker(config *Config, chainConfig *params.ChainConfig, engine consensus
 		startCh:            make(chan struct{}, 1),
 		resubmitIntervalCh: make(chan time.Duration),
 		resubmitAdjustCh:   make(chan *intervalAdjust, resubmitAdjustChanSize),
+		payloadCh:          make(chan *payloadReq),
+		builder:            builder,
+	}
+	worker.payloads, _ = lru.New(payloadCacheSize)
+	if worker.clock == nil {
+		worker.clock = mclock.System{}
 	}
+
 	// Subscribe NewTxsEvent for tx pool
 	worker.txsSub = mxt.TxPool().SubscribeNewTxsEvent(worker.txsCh)
 	// Subscribe events for blockchain
@@ -233,7 +252,7 @@ func newWorker(config *Config, chainConfig *params.ChainConfig, engine consensus
 	if init {
 		worker.startCh <- struct{}{}
 	}
-	return worker
+	return worker, nil
 }
 
 // setEtherbase sets the mxterbase used to initialize the block coinbase field.
@@ -337,9 +356,9 @@ func (w *worker) newWorkLoop(recommit time.Duration) {
 		timestamp   int64      // timestamp for each round of mining.
 	)
 
-	timer := time.NewTimer(0)
+	timer := w.clock.NewTimer(time.Hour)
 	defer timer.Stop()
-	<-timer.C // discard the initial tick
+	timer.Stop() // no tick until the first commit
 
 	// commit aborts in-flight transaction execution with given signal and resubmits a new one.
 	commit := func(noempty bool, s int32) {
@@ -366,18 +385,18 @@ func (w *worker) newWorkLoop(recommit time.Duration) {
 		select {
 		case <-w.startCh:
 			clearPending(w.chain.CurrentBlock().NumberU64())
-			timestamp = time.Now().Unix()
+			timestamp = mclock.WallTime(w.clock).Unix()
 			commit(false, commitInterruptNewHead)
 
 		case head := <-w.chainHeadCh:
 			clearPending(head.Block.NumberU64())
-			timestamp = time.Now().Unix()
+			timestamp = mclock.WallTime(w.clock).Unix()
 			commit(false, commitInterruptNewHead)
 
-		case <-timer.C:
+		case <-timer.C():
 			// If mining is running resubmit a new work cycle periodically to pull in
 			// higher priced transactions. Disable this overhead for pending blocks.
-			if w.isRunning() && (w.chainConfig.Clique == nil || w.chainConfig.Clique.Period > 0) {
+			if w.isRunning() && (w.chain.Config().Clique == nil || w.chain.Config().Clique.Period > 0) {
 				// Short circuit if no new transaction arrives.
 				if atomic.LoadInt32(&w.newTxs) == 0 {
 					timer.Reset(recommit)
@@ -433,6 +452,10 @@ func (w *worker) mainLoop() {
 		case req := <-w.newWorkCh:
 			w.commitNewWork(req.interrupt, req.noempty, req.timestamp)
 
+		case req := <-w.payloadCh:
+			payload, err := w.buildPayload(req.args)
+			req.result <- &payloadResult{payload: payload, err: err}
+
 		case ev := <-w.chainSideCh:
 			// Short circuit for duplicate side blocks
 			if _, exist := w.localUncles[ev.Block.Hash()]; exist {
@@ -504,8 +527,8 @@ func (w *worker) mainLoop() {
 				// Special case, if the consensus engine is 0 period clique(dev mode),
 				// submit mining work here since all empty submission will be rejected
 				// by clique. Of course the advance sealing(empty submission) is disabled.
-				if w.chainConfig.Clique != nil && w.chainConfig.Clique.Period == 0 {
-					w.commitNewWork(nil, true, time.Now().Unix())
+				if w.chain.Config().Clique != nil && w.chain.Config().Clique.Period == 0 {
+					w.commitNewWork(nil, true, mclock.WallTime(w.clock).Unix())
 				}
 			}
 			atomic.AddInt32(&w.newTxs, int32(len(ev.Txs)))
@@ -643,7 +666,7 @@ func (w *worker) makeCurrent(parent *types.Block, header *types.Header) error {
 		return err
 	}
 	env := &environment{
-		signer:    types.NewEIP155Signer(w.chainConfig.ChainID),
+		signer:    types.NewEIP155Signer(w.chain.Config().ChainID),
 		state:     state,
 		ancestors: mapset.NewSet(),
 		family:    mapset.NewSet(),
@@ -722,7 +745,7 @@ func (w *worker) updateSnapshot() {
 func (w *worker) commitTransaction(tx *types.Transaction, coinbase common.Address) ([]*types.Log, error) {
 	snap := w.current.state.Snapshot()
 
-	receipt, err := core.ApplyTransaction(w.chainConfig, w.chain, &coinbase, w.current.gasPool, w.current.state, w.current.header, tx, &w.current.header.GasUsed, *w.chain.GetVMConfig())
+	receipt, err := core.ApplyTransaction(w.chain.Config(), w.chain, &coinbase, w.current.gasPool, w.current.state, w.current.header, tx, &w.current.header.GasUsed, *w.chain.GetVMConfig())
 	if err != nil {
 		w.current.state.RevertToSnapshot(snap)
 		return nil, err
@@ -733,7 +756,7 @@ func (w *worker) commitTransaction(tx *types.Transaction, coinbase common.Addres
 	return receipt.Logs, nil
 }
 
-func (w *worker) commitTransactions(txs *types.TransactionsByPriceAndNonce, coinbase common.Address, interrupt *int32) bool {
+func (w *worker) commitTransactions(txs TxOrdering, coinbase common.Address, interrupt *int32) bool {
 	// Short circuit if current is nil
 	if w.current == nil {
 		return true
@@ -743,8 +766,10 @@ func (w *worker) commitTransactions(txs *types.TransactionsByPriceAndNonce, coin
 		w.current.gasPool = new(core.GasPool).AddGas(w.current.header.GasLimit)
 	}
 
-	var coalescedLogs []*types.Log
-
+	var (
+		coalescedLogs []*types.Log
+		env           = &BlockEnv{Header: w.current.header, Signer: w.current.signer, State: w.current.state, TxPool: w.mxt.TxPool()}
+	)
 	for {
 		// In the following three cases, we will interrupt the execution of the transaction.
 		// (1) new head block event arrival, the interrupt signal is 1
@@ -783,8 +808,15 @@ func (w *worker) commitTransactions(txs *types.TransactionsByPriceAndNonce, coin
 		from, _ := types.Sender(w.current.signer, tx)
 		// Check whmxter the tx is replay protected. If we're not in the EIP155 hf
 		// phase, start ignoring the sender until we do.
-		if tx.Protected() && !w.chainConfig.IsEIP155(w.current.header.Number) {
-			log.Trace("Ignoring reply protected transaction", "hash", tx.Hash(), "eip155", w.chainConfig.EIP155Block)
+		if tx.Protected() && !w.chain.Config().IsEIP155(w.current.header.Number) {
+			log.Trace("Ignoring reply protected transaction", "hash", tx.Hash(), "eip155", w.chain.Config().EIP155Block)
+
+			txs.Pop()
+			continue
+		}
+		// Skip the sender if the block building policy rejects the transaction
+		if !w.builder.Include(env, from, tx) {
+			log.Trace("Transaction rejected by block builder", "hash", tx.Hash(), "sender", from)
 
 			txs.Pop()
 			continue
@@ -823,7 +855,7 @@ func (w *worker) commitTransactions(txs *types.TransactionsByPriceAndNonce, coin
 		}
 	}
 
-	if !w.isRunning() && len(coalescedLogs) > 0 {
+	if !w.isRunning() && !w.current.payload && len(coalescedLogs) > 0 {
 		// We don't push the pendingLogsEvent while we are mining. The reason is that
 		// when we are mining, the worker will regenerate a mining block every 3 seconds.
 		// In order to avoid pushing the repeated pendingLog, we disable the pending log pushing.
@@ -846,6 +878,47 @@ func (w *worker) commitTransactions(txs *types.TransactionsByPriceAndNonce, coin
 	return false
 }
 
+// commitBatches commits the candidate transaction batches of the block building
+// policy one after the other, returning true if interrupted by a new head.
+func (w *worker) commitBatches(batches []*TxBatch, coinbase common.Address, interrupt *int32) bool {
+	if w.current.gasPool == nil {
+		w.current.gasPool = new(core.GasPool).AddGas(w.current.header.GasLimit)
+	}
+	gasPool := w.current.gasPool
+	for _, batch := range batches {
+		// Withhold the gas reserved for later batches while filling this one
+		reserve := batch.Reserve
+		if reserve > gasPool.Gas() {
+			reserve = gasPool.Gas()
+		}
+		gasPool.SubGas(reserve)
+		interrupted := w.commitTransactions(batch.Txs, coinbase, interrupt)
+		gasPool.AddGas(reserve)
+
+		if interrupted {
+			return true
+		}
+	}
+	return false
+}
+
+// applyDAOExtra overrides the extra-data of the header within the range of TheDAO
+// hard-fork, if we care about it.
+func (w *worker) applyDAOExtra(header *types.Header) {
+	if daoBlock := w.chain.Config().DAOForkBlock; daoBlock != nil {
+		// Check whmxter the block is among the fork extra-override range
+		limit := new(big.Int).Add(daoBlock, params.DAOForkExtraRange)
+		if header.Number.Cmp(daoBlock) >= 0 && header.Number.Cmp(limit) < 0 {
+			// Depending whmxter we support or oppose the fork, override differently
+			if w.chain.Config().DAOForkSupport {
+				header.Extra = common.CopyBytes(params.DAOForkBlockExtra)
+			} else if bytes.Equal(header.Extra, params.DAOForkBlockExtra) {
+				header.Extra = []byte{} // If miner opposes, don't let it use the reserved extra-data
+			}
+		}
+	}
+}
+
 // commitNewWork generates several new sealing tasks based on the parent block.
 func (w *worker) commitNewWork(interrupt *int32, noempty bool, timestamp int64) {
 	w.mu.RLock()
@@ -858,10 +931,10 @@ func (w *worker) commitNewWork(interrupt *int32, noempty bool, timestamp int64)
 		timestamp = int64(parent.Time() + 1)
 	}
 	// this will ensure we're not going off too far in the future
-	if now := time.Now().Unix(); timestamp > now+1 {
+	if now := mclock.WallTime(w.clock).Unix(); timestamp > now+1 {
 		wait := time.Duration(timestamp-now) * time.Second
 		log.Info("Mining too far in the future", "wait", common.PrettyDuration(wait))
-		time.Sleep(wait)
+		w.clock.Sleep(wait)
 	}
 
 	num := parent.Number()
@@ -884,19 +957,8 @@ func (w *worker) commitNewWork(interrupt *int32, noempty bool, timestamp int64)
 		log.Error("Failed to prepare header for mining", "err", err)
 		return
 	}
-	// If we are care about TheDAO hard-fork check whmxter to override the extra-data or not
-	if daoBlock := w.chainConfig.DAOForkBlock; daoBlock != nil {
-		// Check whmxter the block is among the fork extra-override range
-		limit := new(big.Int).Add(daoBlock, params.DAOForkExtraRange)
-		if header.Number.Cmp(daoBlock) >= 0 && header.Number.Cmp(limit) < 0 {
-			// Depending whmxter we support or oppose the fork, override differently
-			if w.chainConfig.DAOForkSupport {
-				header.Extra = common.CopyBytes(params.DAOForkBlockExtra)
-			} else if bytes.Equal(header.Extra, params.DAOForkBlockExtra) {
-				header.Extra = []byte{} // If miner opposes, don't let it use the reserved extra-data
-			}
-		}
-	}
+	w.applyDAOExtra(header)
+
 	// Could potentially happen if starting to mine in an odd state.
 	err := w.makeCurrent(parent, header)
 	if err != nil {
@@ -905,7 +967,7 @@ func (w *worker) commitNewWork(interrupt *int32, noempty bool, timestamp int64)
 	}
 	// Create the current work task and check any fork transitions needed
 	env := w.current
-	if w.chainConfig.DAOForkSupport && w.chainConfig.DAOForkBlock != nil && w.chainConfig.DAOForkBlock.Cmp(header.Number) == 0 {
+	if w.chain.Config().DAOForkSupport && w.chain.Config().DAOForkBlock != nil && w.chain.Config().DAOForkBlock.Cmp(header.Number) == 0 {
 		misc.ApplyDAOHardFork(env.state)
 	}
 	// Accumulate the uncles for the current block
@@ -939,38 +1001,21 @@ func (w *worker) commitNewWork(interrupt *int32, noempty bool, timestamp int64)
 		w.commit(uncles, nil, false, tstart)
 	}
 
-	// Fill the block with all available pending transactions.
-	pending, err := w.mxt.TxPool().Pending()
+	// Fill the block with the candidate transactions of the block building policy.
+	batches, err := w.builder.Batches(&BlockEnv{Header: header, Signer: env.signer, State: env.state, TxPool: w.mxt.TxPool()})
 	if err != nil {
-		log.Error("Failed to fetch pending transactions", "err", err)
+		log.Error("Failed to fetch candidate transactions", "err", err)
 		return
 	}
 	// Short circuit if there is no available pending transactions.
 	// But if we disable empty precommit already, ignore it. Since
 	// empty block is necessary to keep the liveness of the network.
-	if len(pending) == 0 && atomic.LoadUint32(&w.noempty) == 0 {
+	if len(batches) == 0 && atomic.LoadUint32(&w.noempty) == 0 {
 		w.updateSnapshot()
 		return
 	}
-	// Split the pending transactions into locals and remotes
-	localTxs, remoteTxs := make(map[common.Address]types.Transactions), pending
-	for _, account := range w.mxt.TxPool().Locals() {
-		if txs := remoteTxs[account]; len(txs) > 0 {
-			delete(remoteTxs, account)
-			localTxs[account] = txs
-		}
-	}
-	if len(localTxs) > 0 {
-		txs := types.NewTransactionsByPriceAndNonce(w.current.signer, localTxs)
-		if w.commitTransactions(txs, w.coinbase, interrupt) {
-			return
-		}
-	}
-	if len(remoteTxs) > 0 {
-		txs := types.NewTransactionsByPriceAndNonce(w.current.signer, remoteTxs)
-		if w.commitTransactions(txs, w.coinbase, interrupt) {
-			return
-		}
+	if w.commitBatches(batches, w.coinbase, interrupt) {
+		return
 	}
 	w.commit(uncles, w.fullTaskHook, true, tstart)
 }
@@ -19,6 +19,7 @@ package miner
 import (
 	"math/big"
 	"math/rand"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -178,7 +179,10 @@ func (b *testWorkerBackend) newRandomTx(creation bool) *types.Transaction {
 func newTestWorker(t *testing.T, chainConfig *params.ChainConfig, engine consensus.Engine, db mxtdb.Database, blocks int) (*worker, *testWorkerBackend) {
 	backend := newTestWorkerBackend(t, chainConfig, engine, db, blocks)
 	backend.txPool.AddLocals(pendingTxs)
-	w := newWorker(testConfig, chainConfig, engine, backend, new(event.TypeMux), nil, false)
+	w, err := newWorker(testConfig, engine, backend, new(event.TypeMux), nil, false)
+	if err != nil {
+		t.Fatalf("failed to create worker: %v", err)
+	}
 	w.setEtherbase(testBankAddress)
 	return w, backend
 }
@@ -501,3 +505,163 @@ func testAdjustInterval(t *testing.T, chainConfig *params.ChainConfig, engine co
 		t.Error("interval reset timeout")
 	}
 }
+
+// testBlockBuilder is a block building policy rejecting transactions sent to a
+// given address and reserving gas in the single batch it builds.
+type testBlockBuilder struct {
+	blocked common.Address
+	reserve uint64
+}
+
+func (b *testBlockBuilder) Batches(env *BlockEnv) ([]*TxBatch, error) {
+	pending, err := env.TxPool.Pending()
+	if err != nil || len(pending) == 0 {
+		return nil, err
+	}
+	return []*TxBatch{{Txs: types.NewTransactionsByPriceAndNonce(env.Signer, pending), Reserve: b.reserve}}, nil
+}
+
+func (b *testBlockBuilder) Include(env *BlockEnv, from common.Address, tx *types.Transaction) bool {
+	return tx.To() == nil || *tx.To() != b.blocked
+}
+
+func TestBlockBuilderRegistry(t *testing.T) {
+	if builder, err := newBlockBuilder(""); err != nil {
+		t.Fatalf("failed to create default block builder: %v", err)
+	} else if _, ok := builder.(priceBuilder); !ok {
+		t.Fatalf("default block builder mismatch: have %T, want priceBuilder", builder)
+	}
+	if _, err := newBlockBuilder("nonexistent"); err == nil {
+		t.Fatalf("unknown block builder created")
+	}
+	config := *testConfig
+	config.BlockBuilder = "nonexistent"
+	if _, err := newWorker(&config, nil, nil, nil, nil, false); err == nil {
+		t.Fatalf("worker created with unknown block builder")
+	}
+	RegisterBlockBuilder("test-registry", func() BlockBuilder { return new(testBlockBuilder) })
+	if _, err := newBlockBuilder("test-registry"); err != nil {
+		t.Fatalf("failed to create registered block builder: %v", err)
+	}
+	if names := BlockBuilders(); len(names) != 2 || names[0] != DefaultBlockBuilder || names[1] != "test-registry" {
+		t.Fatalf("block builder names mismatch: have %v", names)
+	}
+}
+
+func TestBlockBuilderPolicy(t *testing.T) {
+	gasLimit := testConfig.GasCeil
+	tests := []struct {
+		builder *testBlockBuilder
+		txs     int
+	}{
+		{&testBlockBuilder{}, 1},
+		{&testBlockBuilder{blocked: testUserAddress}, 0},
+		{&testBlockBuilder{reserve: gasLimit - params.TxGas + 1}, 0},
+		{&testBlockBuilder{reserve: gasLimit - params.TxGas}, 1},
+	}
+	for i, tt := range tests {
+		engine := mxtash.NewFaker()
+		w, _ := newTestWorker(t, mxtashChainConfig, engine, rawdb.NewMemoryDatabase(), 0)
+		w.close()
+		w.builder = tt.builder
+
+		w.commitNewWork(nil, true, time.Now().Unix())
+		if have := w.pendingBlock().Transactions().Len(); have != tt.txs {
+			t.Errorf("test %d: transaction count mismatch: have %d, want %d", i, have, tt.txs)
+		}
+		if gas := w.current.gasPool.Gas(); gas+w.current.header.GasUsed != gasLimit {
+			t.Errorf("test %d: reserved gas not released: have %d available, %d used", i, gas, w.current.header.GasUsed)
+		}
+		engine.Close()
+	}
+}
+
+func TestBuildAndImportPayload(t *testing.T) {
+	var (
+		engine = mxtash.NewFaker()
+		db     = rawdb.NewMemoryDatabase()
+	)
+	defer engine.Close()
+
+	w, b := newTestWorker(t, mxtashChainConfig, engine, db, 0)
+	defer w.close()
+
+	// Build a payload from the pool and one from an explicit transaction list
+	parent := b.chain.CurrentBlock()
+	args := &PayloadArgs{
+		ParentHash:   parent.Hash(),
+		Timestamp:    parent.Time() + 10,
+		FeeRecipient: testUserAddress,
+		ExtraData:    []byte("payload"),
+	}
+	payload, err := w.requestPayload(args)
+	if err != nil {
+		t.Fatalf("failed to build payload: %v", err)
+	}
+	if len(payload.Transactions) != 1 || payload.Transactions[0].Hash() != pendingTxs[0].Hash() {
+		t.Fatalf("pool payload transactions mismatch: have %d", len(payload.Transactions))
+	}
+	if len(payload.Receipts) != 1 || payload.Header.Coinbase != testUserAddress || payload.StateRoot != payload.Header.Root {
+		t.Fatalf("pool payload mismatch: %d receipts, coinbase %x", len(payload.Receipts), payload.Header.Coinbase)
+	}
+	args.Txs = types.Transactions{}
+	if empty, err := w.requestPayload(args); err != nil {
+		t.Fatalf("failed to build explicit payload: %v", err)
+	} else if len(empty.Transactions) != 0 {
+		t.Fatalf("explicit payload transactions mismatch: have %d, want 0", len(empty.Transactions))
+	}
+	// Explicit transactions which can't be applied fail the build
+	args.Txs = types.Transactions{pendingTxs[0], pendingTxs[0]}
+	if _, err := w.requestPayload(args); err == nil || !strings.Contains(err.Error(), "transaction 1") {
+		t.Fatalf("duplicate transaction error mismatch: have %v", err)
+	}
+	args.Txs = types.Transactions{newTxs[0]}
+	if _, err := w.requestPayload(args); err == nil || !strings.Contains(err.Error(), "transaction 0") {
+		t.Fatalf("nonce gap error mismatch: have %v", err)
+	}
+	args.Txs = types.Transactions{pendingTxs[0], newTxs[0]}
+	if full, err := w.requestPayload(args); err != nil {
+		t.Fatalf("failed to build explicit payload: %v", err)
+	} else if len(full.Transactions) != 2 {
+		t.Fatalf("explicit payload transactions mismatch: have %d, want 2", len(full.Transactions))
+	}
+	if b.chain.CurrentBlock().Hash() != parent.Hash() {
+		t.Fatalf("payload building modified the chain")
+	}
+	// Seal the first payload externally and import it
+	results := make(chan *types.Block, 1)
+	block := types.NewBlockWithHeader(payload.Header).WithBody(payload.Transactions, nil)
+	if err := engine.Seal(b.chain, block, results, nil); err != nil {
+		t.Fatalf("failed to seal payload: %v", err)
+	}
+	sealed := <-results
+	mined := w.mux.Subscribe(core.NewMinedBlockEvent{})
+	defer mined.Unsubscribe()
+	minedc := make(chan *types.Block, 1)
+	go func() {
+		if ev, ok := <-mined.Chan(); ok {
+			minedc <- ev.Data.(core.NewMinedBlockEvent).Block
+		}
+	}()
+	hash, err := w.importPayload(sealed.Header())
+	if err != nil {
+		t.Fatalf("failed to import payload: %v", err)
+	}
+	if head := b.chain.CurrentBlock(); head.Hash() != hash || head.Transactions().Len() != 1 {
+		t.Fatalf("chain head mismatch: have %x, want %x", head.Hash(), hash)
+	}
+	select {
+	case block := <-minedc:
+		if block.Hash() != hash {
+			t.Fatalf("mined block event mismatch: have %x, want %x", block.Hash(), hash)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no mined block event posted for imported payload")
+	}
+	if _, err := w.importPayload(&types.Header{Number: big.NewInt(1)}); err != errUnknownPayload {
+		t.Fatalf("unknown payload import error mismatch: have %v, want %v", err, errUnknownPayload)
+	}
+	if _, err := w.requestPayload(&PayloadArgs{ParentHash: common.Hash{0x01}, Timestamp: 1}); err == nil {
+		t.Fatalf("payload built on unknown parent")
+	}
+}
@@ -35,6 +35,8 @@ import (
 	"github.com/mxt/go-mxt/core/state"
 	"github.com/mxt/go-mxt/core/types"
 	"github.com/mxt/go-mxt/internal/mxtapi"
+	"github.com/mxt/go-mxt/miner"
+	"github.com/mxt/go-mxt/params"
 	"github.com/mxt/go-mxt/rlp"
 	"github.com/mxt/go-mxt/rpc"
 	"github.com/mxt/go-mxt/trie"
@@ -154,6 +156,36 @@ func (api *PrivateMinerAPI) GetHashrate() uint64 {
 	return api.e.miner.HashRate()
 }
 
+// BuildPayload assembles a block on top of the given parent and returns it without
+// sealing it. If txs is given, the RLP encoded transactions are included in order
+// and the build fails if any of them can't be applied, otherwise the block is
+// filled from the transaction pool.
+func (api *PrivateMinerAPI) BuildPayload(parentHash common.Hash, timestamp hexutil.Uint64, feeRecipient common.Address, extraData hexutil.Bytes, txs *[]hexutil.Bytes) (*miner.Payload, error) {
+	args := &miner.PayloadArgs{
+		ParentHash:   parentHash,
+		Timestamp:    uint64(timestamp),
+		FeeRecipient: feeRecipient,
+		ExtraData:    extraData,
+	}
+	if txs != nil {
+		args.Txs = make(types.Transactions, len(*txs))
+		for i, blob := range *txs {
+			tx := new(types.Transaction)
+			if err := rlp.DecodeBytes(blob, tx); err != nil {
+				return nil, fmt.Errorf("invalid transaction %d: %v", i, err)
+			}
+			args.Txs[i] = tx
+		}
+	}
+	return api.e.miner.BuildPayload(args)
+}
+
+// ImportPayload inserts the externally sealed header of a payload assembled by
+// BuildPayload into the chain and returns the hash of the sealed block.
+func (api *PrivateMinerAPI) ImportPayload(header *types.Header) (common.Hash, error) {
+	return api.e.miner.ImportPayload(header)
+}
+
 // PrivateAdminAPI is the collection of Ethereum full node-related APIs
 // exposed over the private admin endpoint.
 type PrivateAdminAPI struct {
@@ -276,8 +308,25 @@ func NewPublicDebugAPI(mxt *Ethereum) *PublicDebugAPI {
 	return &PublicDebugAPI{mxt: mxt}
 }
 
+// resolveFinality converts the finalized and safe block tags into the number of
+// the block they currently refer to, leaving any other block number untouched.
+func (s *Ethereum) resolveFinality(number rpc.BlockNumber) (rpc.BlockNumber, error) {
+	if number != rpc.FinalizedBlockNumber && number != rpc.SafeBlockNumber {
+		return number, nil
+	}
+	header, err := mxtapi.FinalityHeader(s.engine, s.blockchain, number)
+	if err != nil {
+		return number, err
+	}
+	return rpc.BlockNumber(header.Number.Int64()), nil
+}
+
 // DumpBlock retrieves the entire state of the database at a given block.
 func (api *PublicDebugAPI) DumpBlock(blockNr rpc.BlockNumber) (state.Dump, error) {
+	blockNr, err := api.mxt.resolveFinality(blockNr)
+	if err != nil {
+		return state.Dump{}, err
+	}
 	if blockNr == rpc.PendingBlockNumber {
 		// If we're dumping the pending state, we need to request
 		// both the pending block as well as the pending state from
@@ -360,6 +409,9 @@ func (api *PublicDebugAPI) AccountRange(blockNrOrHash rpc.BlockNumberOrHash, sta
 	var err error
 
 	if number, ok := blockNrOrHash.Number(); ok {
+		if number, err = api.mxt.resolveFinality(number); err != nil {
+			return state.IteratorDump{}, err
+		}
 		if number == rpc.PendingBlockNumber {
 			// If we're dumping the pending state, we need to request
 			// both the pending block as well as the pending state from
@@ -535,3 +587,24 @@ func (api *PrivateDebugAPI) getModifiedAccounts(startBlock, endBlock *types.Bloc
 	}
 	return dirty, nil
 }
+
+// SetChainConfig reschedules the forks of a running development chain, given as
+// a map from their JSON field names (e.g. "istanbulBlock" or "yoloV1Time") to the
+// block number or timestamp to activate them at, or null to unschedule them. The
+// chain is rewound if the new schedule conflicts with the imported blocks.
+func (api *PrivateDebugAPI) SetChainConfig(forks map[string]*hexutil.Uint64) (*params.ChainConfig, error) {
+	if !api.mxt.config.Developer {
+		return nil, errors.New("chain config can only be changed on development chains")
+	}
+	config := *api.mxt.blockchain.Config()
+	for name, at := range forks {
+		if err := config.SetFork(name, (*uint64)(at)); err != nil {
+			return nil, err
+		}
+	}
+	if err := api.mxt.blockchain.SetChainConfig(&config); err != nil {
+		return nil, err
+	}
+	api.mxt.txPool.SetChainConfig(api.mxt.blockchain.Config())
+	return api.mxt.blockchain.Config(), nil
+}
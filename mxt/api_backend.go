@@ -19,6 +19,7 @@ package mxt
 import (
 	"context"
 	"errors"
+	"fmt"
 	"math/big"
 
 	"github.com/mxt/go-mxt/accounts"
@@ -34,6 +35,7 @@ import (
 	"github.com/mxt/go-mxt/mxt/gasprice"
 	"github.com/mxt/go-mxt/mxtdb"
 	"github.com/mxt/go-mxt/event"
+	"github.com/mxt/go-mxt/internal/mxtapi"
 	"github.com/mxt/go-mxt/miner"
 	"github.com/mxt/go-mxt/params"
 	"github.com/mxt/go-mxt/rpc"
@@ -66,6 +68,10 @@ func (b *EthAPIBackend) HeaderByNumber(ctx context.Context, number rpc.BlockNumb
 		block := b.mxt.miner.PendingBlock()
 		return block.Header(), nil
 	}
+	// Finalized and safe blocks are only known by the consensus engine
+	if number == rpc.FinalizedBlockNumber || number == rpc.SafeBlockNumber {
+		return mxtapi.FinalityHeader(b.mxt.engine, b.mxt.blockchain, number)
+	}
 	// Otherwise resolve and return the block
 	if number == rpc.LatestBlockNumber {
 		return b.mxt.blockchain.CurrentBlock().Header(), nil
@@ -100,6 +106,14 @@ func (b *EthAPIBackend) BlockByNumber(ctx context.Context, number rpc.BlockNumbe
 		block := b.mxt.miner.PendingBlock()
 		return block, nil
 	}
+	// Finalized and safe blocks are only known by the consensus engine
+	if number == rpc.FinalizedBlockNumber || number == rpc.SafeBlockNumber {
+		header, err := mxtapi.FinalityHeader(b.mxt.engine, b.mxt.blockchain, number)
+		if err != nil {
+			return nil, err
+		}
+		return b.mxt.blockchain.GetBlock(header.Hash(), header.Number.Uint64()), nil
+	}
 	// Otherwise resolve and return the block
 	if number == rpc.LatestBlockNumber {
 		return b.mxt.blockchain.CurrentBlock(), nil
@@ -308,6 +322,14 @@ func (b *EthAPIBackend) BloomStatus() (uint64, uint64) {
 	return params.BloomBitsBlocks, sections
 }
 
+func (b *EthAPIBackend) LogIndexStatus() (uint64, uint64) {
+	if b.mxt.logIndexer == nil {
+		return params.BloomBitsBlocks, 0
+	}
+	sections, _, _ := b.mxt.logIndexer.Sections()
+	return params.BloomBitsBlocks, sections
+}
+
 func (b *EthAPIBackend) ServiceFilter(ctx context.Context, session *bloombits.MatcherSession) {
 	for i := 0; i < bloomFilterThreads; i++ {
 		go session.Multiplex(bloomRetrievalBatch, bloomRetrievalWait, b.mxt.bloomRequests)
@@ -329,3 +351,32 @@ func (b *EthAPIBackend) Miner() *miner.Miner {
 func (b *EthAPIBackend) StartMining(threads int) error {
 	return b.mxt.StartMining(threads)
 }
+
+func (b *EthAPIBackend) TransactionsByAddress(ctx context.Context, address common.Address, offset, limit uint64) ([]common.Hash, error) {
+	if b.mxt.addrIndexer == nil {
+		return nil, errors.New("address index not enabled, restart with --addrindex")
+	}
+	return b.mxt.addrIndexer.Transactions(address, offset, limit), nil
+}
+
+func (b *EthAPIBackend) TraceBlockCalls(ctx context.Context, hash common.Hash) ([]*mxtapi.CallFrame, error) {
+	if !b.mxt.config.GraphQLTracing {
+		return nil, errors.New("block tracing not enabled, restart with --graphql.tracing")
+	}
+	block := b.mxt.blockchain.GetBlockByHash(hash)
+	if block == nil {
+		return nil, fmt.Errorf("block %#x not found", hash)
+	}
+	return NewPrivateDebugAPI(b.mxt).traceBlockCalls(ctx, block)
+}
+
+func (b *EthAPIBackend) BlockStateDiff(ctx context.Context, hash common.Hash) ([]*mxtapi.AccountDiff, error) {
+	if !b.mxt.config.GraphQLTracing {
+		return nil, errors.New("block tracing not enabled, restart with --graphql.tracing")
+	}
+	block := b.mxt.blockchain.GetBlockByHash(hash)
+	if block == nil {
+		return nil, fmt.Errorf("block %#x not found", hash)
+	}
+	return NewPrivateDebugAPI(b.mxt).blockStateDiff(ctx, block)
+}
@@ -0,0 +1,182 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package mxt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/core/state"
+	"github.com/mxt/go-mxt/core/types"
+	"github.com/mxt/go-mxt/core/vm"
+	"github.com/mxt/go-mxt/internal/mxtapi"
+)
+
+// touchTracer is a vm.Tracer collecting every account and storage slot that
+// may have been modified during execution.
+type touchTracer struct {
+	accounts   map[common.Address]map[common.Hash]struct{}
+	destructed map[common.Address]struct{} // Accounts that executed SELFDESTRUCT
+	created    map[common.Address]struct{} // Accounts that were (re)created
+}
+
+// newTouchTracer creates an empty touch tracer.
+func newTouchTracer() *touchTracer {
+	return &touchTracer{
+		accounts:   make(map[common.Address]map[common.Hash]struct{}),
+		destructed: make(map[common.Address]struct{}),
+		created:    make(map[common.Address]struct{}),
+	}
+}
+
+// wiped reports whmxter the storage of an account was deleted by SELFDESTRUCT,
+// i.e. the account self-destructed and either stayed deleted or was recreated.
+func (t *touchTracer) wiped(addr common.Address, statedb *state.StateDB) bool {
+	if _, ok := t.destructed[addr]; !ok {
+		return false
+	}
+	_, created := t.created[addr]
+	return created || !statedb.Exist(addr)
+}
+
+// touch marks an account as potentially modified.
+func (t *touchTracer) touch(addr common.Address) map[common.Hash]struct{} {
+	slots, ok := t.accounts[addr]
+	if !ok {
+		slots = make(map[common.Hash]struct{})
+		t.accounts[addr] = slots
+	}
+	return slots
+}
+
+func (t *touchTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	t.touch(from)
+	t.touch(to)
+	if create {
+		t.created[to] = struct{}{}
+	}
+	return nil
+}
+
+func (t *touchTracer) CaptureState(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, rStack *vm.ReturnStack, rData []byte, contract *vm.Contract, depth int, err error) error {
+	if err != nil {
+		return nil
+	}
+	size := len(stack.Data())
+	switch op {
+	case vm.SSTORE:
+		if size >= 1 {
+			t.touch(contract.Address())[common.Hash(stack.Back(0).Bytes32())] = struct{}{}
+		}
+	case vm.CALL, vm.CALLCODE:
+		if size >= 2 {
+			t.touch(common.Address(stack.Back(1).Bytes20()))
+		}
+	case vm.SELFDESTRUCT:
+		t.touch(contract.Address())
+		t.destructed[contract.Address()] = struct{}{}
+		if size >= 1 {
+			t.touch(common.Address(stack.Back(0).Bytes20()))
+		}
+	case vm.CREATE, vm.CREATE2:
+		if addr, ok := vm.CreatedAddress(env, op, memory, stack, contract); ok {
+			t.touch(addr)
+			t.created[addr] = struct{}{}
+		}
+	}
+	return nil
+}
+
+func (t *touchTracer) CaptureFault(env *vm.EVM, pc uint64, op vm.OpCode, gas, cost uint64, memory *vm.Memory, stack *vm.Stack, rStack *vm.ReturnStack, contract *vm.Contract, depth int, err error) error {
+	return nil
+}
+
+func (t *touchTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	return nil
+}
+
+// blockStateDiff re-executes a block on top of its parent state and returns the
+// accounts whose balance, nonce, code or storage differ after the block, sorted
+// by address.
+func (api *PrivateDebugAPI) blockStateDiff(ctx context.Context, block *types.Block) ([]*mxtapi.AccountDiff, error) {
+	parent := api.mxt.blockchain.GetBlock(block.ParentHash(), block.NumberU64()-1)
+	if parent == nil {
+		return nil, fmt.Errorf("parent %#x not found", block.ParentHash())
+	}
+	statedb, err := api.computeStateDB(parent, defaultTraceReexec)
+	if err != nil {
+		return nil, err
+	}
+	prestate := statedb.Copy()
+
+	// Replay the block, also crediting the miner and uncle rewards
+	tracer := newTouchTracer()
+	tracer.touch(block.Coinbase())
+	for _, uncle := range block.Uncles() {
+		tracer.touch(uncle.Coinbase)
+	}
+	if _, _, _, err := api.mxt.blockchain.Processor().Process(block, statedb, vm.Config{Debug: true, Tracer: tracer}); err != nil {
+		return nil, fmt.Errorf("processing block %d failed: %v", block.NumberU64(), err)
+	}
+	// Compare every touched account against its pre-block version
+	var diffs []*mxtapi.AccountDiff
+	for addr, slots := range tracer.accounts {
+		diff := &mxtapi.AccountDiff{
+			Address:       addr,
+			BalanceBefore: prestate.GetBalance(addr),
+			BalanceAfter:  statedb.GetBalance(addr),
+			NonceBefore:   prestate.GetNonce(addr),
+			NonceAfter:    statedb.GetNonce(addr),
+			CodeBefore:    prestate.GetCode(addr),
+			CodeAfter:     statedb.GetCode(addr),
+		}
+		if tracer.wiped(addr, statedb) {
+			// All pre-block storage is gone, not only the slots written during the block
+			diff.StorageCleared = true
+			prestate.ForEachStorage(addr, func(key, value common.Hash) bool {
+				// Keys without a known preimage can't be reported individually
+				if prestate.GetState(addr, key) == value {
+					slots[key] = struct{}{}
+				}
+				return true
+			})
+		}
+		for slot := range slots {
+			before, after := prestate.GetState(addr, slot), statedb.GetState(addr, slot)
+			if before != after {
+				diff.Storage = append(diff.Storage, &mxtapi.StorageDiff{Slot: slot, Before: before, After: after})
+			}
+		}
+		if diff.BalanceBefore.Cmp(diff.BalanceAfter) == 0 && diff.NonceBefore == diff.NonceAfter &&
+			bytes.Equal(diff.CodeBefore, diff.CodeAfter) && len(diff.Storage) == 0 && !diff.StorageCleared {
+			continue
+		}
+		sort.Slice(diff.Storage, func(i, j int) bool {
+			return bytes.Compare(diff.Storage[i].Slot[:], diff.Storage[j].Slot[:]) < 0
+		})
+		diffs = append(diffs, diff)
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		return bytes.Compare(diffs[i].Address[:], diffs[j].Address[:]) < 0
+	})
+	return diffs, nil
+}
@@ -0,0 +1,129 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package mxt
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/consensus/mxtash"
+	"github.com/mxt/go-mxt/core"
+	"github.com/mxt/go-mxt/core/rawdb"
+	"github.com/mxt/go-mxt/core/types"
+	"github.com/mxt/go-mxt/core/vm"
+	"github.com/mxt/go-mxt/crypto"
+	"github.com/mxt/go-mxt/internal/mxtapi"
+	"github.com/mxt/go-mxt/params"
+)
+
+// Tests that the state diff of a block reports modified accounts and slots,
+// including the storage deleted by SELFDESTRUCT.
+func TestBlockStateDiff(t *testing.T) {
+	var (
+		key, _   = crypto.HexToECDSA("b71c71a67e1177ad4e901695e1b4b9ee17ae16c6668d313eac2f96dbcda3f291")
+		sender   = crypto.PubkeyToAddress(key.PublicKey)
+		storer   = common.HexToAddress("0x0100")
+		killer   = common.HexToAddress("0x0200")
+		coinbase = common.HexToAddress("0x0300")
+		emptier  = common.HexToAddress("0x0400")
+		funds    = big.NewInt(1000000000000000)
+		db       = rawdb.NewMemoryDatabase()
+		gspec    = &core.Genesis{
+			Config: params.TestChainConfig,
+			Alloc: core.GenesisAlloc{
+				sender: {Balance: funds},
+				// PUSH1 1 PUSH1 0 SSTORE STOP
+				storer: {Balance: common.Big0, Code: []byte{0x60, 0x01, 0x60, 0x00, 0x55, 0x00}},
+				// CALLER SELFDESTRUCT
+				killer: {Balance: big.NewInt(7), Code: []byte{0x33, 0xff}, Storage: map[common.Hash]common.Hash{
+					common.HexToHash("0x01"): common.HexToHash("0x2a"),
+					common.HexToHash("0x02"): common.HexToHash("0x2b"),
+				}},
+				// CALLER SELFDESTRUCT, without any storage
+				emptier: {Balance: common.Big0, Code: []byte{0x33, 0xff}},
+			},
+		}
+		genesis = gspec.MustCommit(db)
+		signer  = types.HomesteadSigner{}
+	)
+	blocks, _ := core.GenerateChain(gspec.Config, genesis, mxtash.NewFaker(), db, 1, func(i int, b *core.BlockGen) {
+		b.SetCoinbase(coinbase)
+		for nonce, to := range []common.Address{storer, killer, emptier} {
+			tx, _ := types.SignTx(types.NewTransaction(uint64(nonce), to, common.Big0, 100000, big.NewInt(1), nil), signer, key)
+			b.AddTx(tx)
+		}
+	})
+	chain, err := core.NewBlockChain(db, nil, gspec.Config, mxtash.NewFaker(), vm.Config{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create blockchain: %v", err)
+	}
+	defer chain.Stop()
+	if _, err := chain.InsertChain(blocks); err != nil {
+		t.Fatalf("failed to import chain: %v", err)
+	}
+	api := NewPrivateDebugAPI(&Ethereum{blockchain: chain, chainDb: db})
+	diffs, err := api.blockStateDiff(context.Background(), blocks[0])
+	if err != nil {
+		t.Fatalf("failed to compute state diff: %v", err)
+	}
+	found := make(map[common.Address]*mxtapi.AccountDiff)
+	for i, diff := range diffs {
+		if i > 0 && diffs[i-1].Address.Hash().Big().Cmp(diff.Address.Hash().Big()) >= 0 {
+			t.Errorf("diffs not sorted by address at index %d", i)
+		}
+		found[diff.Address] = diff
+	}
+	if len(found) != 5 {
+		t.Fatalf("wrong number of modified accounts: have %d, want 5", len(found))
+	}
+	// The sender paid for three transactions and collected the killer's balance
+	if diff := found[sender]; diff.NonceBefore != 0 || diff.NonceAfter != 3 || diff.BalanceBefore.Cmp(funds) != 0 {
+		t.Errorf("wrong sender diff: %+v", diff)
+	}
+	if diff := found[coinbase]; diff.BalanceAfter.Sign() <= 0 {
+		t.Errorf("wrong coinbase diff: %+v", diff)
+	}
+	// The storer wrote a single slot
+	diff := found[storer]
+	if diff.StorageCleared || len(diff.Storage) != 1 {
+		t.Fatalf("wrong storer diff: %+v", diff)
+	}
+	if s := diff.Storage[0]; s.Slot != (common.Hash{}) || s.Before != (common.Hash{}) || s.After != common.HexToHash("0x01") {
+		t.Errorf("wrong storer slot diff: %+v", s)
+	}
+	// The killer self-destructed, deleting code, balance and all storage
+	diff = found[killer]
+	if !diff.StorageCleared || len(diff.CodeAfter) != 0 || diff.BalanceAfter.Sign() != 0 {
+		t.Fatalf("wrong killer diff: %+v", diff)
+	}
+	if len(diff.Storage) != 2 {
+		t.Fatalf("wrong number of deleted slots: have %d, want 2", len(diff.Storage))
+	}
+	for i, want := range []string{"0x2a", "0x2b"} {
+		s := diff.Storage[i]
+		if s.Slot != common.BigToHash(big.NewInt(int64(i+1))) || s.Before != common.HexToHash(want) || s.After != (common.Hash{}) {
+			t.Errorf("wrong killer slot %d diff: %+v", i, s)
+		}
+	}
+	// The emptier self-destructed without any storage to delete
+	diff = found[emptier]
+	if !diff.StorageCleared || len(diff.Storage) != 0 || len(diff.CodeAfter) != 0 {
+		t.Errorf("wrong emptier diff: %+v", diff)
+	}
+}
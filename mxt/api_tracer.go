@@ -20,6 +20,7 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
@@ -105,6 +106,14 @@ func (api *PrivateDebugAPI) TraceChain(ctx context.Context, start, end rpc.Block
 	// Fetch the block interval that we want to trace
 	var from, to *types.Block
 
+	start, err := api.mxt.resolveFinality(start)
+	if err != nil {
+		return nil, err
+	}
+	end, err = api.mxt.resolveFinality(end)
+	if err != nil {
+		return nil, err
+	}
 	switch start {
 	case rpc.PendingBlockNumber:
 		from = api.mxt.miner.PendingBlock()
@@ -356,6 +365,10 @@ func (api *PrivateDebugAPI) TraceBlockByNumber(ctx context.Context, number rpc.B
 	// Fetch the block that we want to trace
 	var block *types.Block
 
+	number, err := api.mxt.resolveFinality(number)
+	if err != nil {
+		return nil, err
+	}
 	switch number {
 	case rpc.PendingBlockNumber:
 		block = api.mxt.miner.PendingBlock()
@@ -520,6 +533,31 @@ func (api *PrivateDebugAPI) traceBlock(ctx context.Context, block *types.Block,
 	return results, nil
 }
 
+// traceBlockCalls runs the callTracer over all the transactions of a block and
+// returns the decoded call tree of each of them.
+func (api *PrivateDebugAPI) traceBlockCalls(ctx context.Context, block *types.Block) ([]*mxtapi.CallFrame, error) {
+	tracer := "callTracer"
+	results, err := api.traceBlock(ctx, block, &TraceConfig{Tracer: &tracer})
+	if err != nil {
+		return nil, err
+	}
+	frames := make([]*mxtapi.CallFrame, len(results))
+	for i, result := range results {
+		if result.Error != "" {
+			return nil, fmt.Errorf("transaction %d trace failed: %s", i, result.Error)
+		}
+		blob, err := json.Marshal(result.Result)
+		if err != nil {
+			return nil, err
+		}
+		frames[i] = new(mxtapi.CallFrame)
+		if err := json.Unmarshal(blob, frames[i]); err != nil {
+			return nil, err
+		}
+	}
+	return frames, nil
+}
+
 // standardTraceBlockToFile configures a new tracer which uses standard JSON output,
 // and traces either a full block or an individual transaction. The return value will
 // be one filename per transaction traced.
@@ -736,6 +774,10 @@ func (api *PrivateDebugAPI) TraceCall(ctx context.Context, args mxtapi.CallArgs,
 		if hash, ok := blockNrOrHash.Hash(); ok {
 			block = api.mxt.blockchain.GetBlockByHash(hash)
 		} else if number, ok := blockNrOrHash.Number(); ok {
+			number, err := api.mxt.resolveFinality(number)
+			if err != nil {
+				return nil, err
+			}
 			block = api.mxt.blockchain.GetBlockByNumber(uint64(number))
 		}
 		if block == nil {
@@ -30,6 +30,7 @@ import (
 	"github.com/mxt/go-mxt/common/hexutil"
 	"github.com/mxt/go-mxt/consensus"
 	"github.com/mxt/go-mxt/consensus/clique"
+	"github.com/mxt/go-mxt/consensus/ibft"
 	"github.com/mxt/go-mxt/consensus/mxtash"
 	"github.com/mxt/go-mxt/core"
 	"github.com/mxt/go-mxt/core/bloombits"
@@ -74,6 +75,9 @@ type Ethereum struct {
 	bloomIndexer      *core.ChainIndexer             // Bloom indexer operating during block imports
 	closeBloomHandler chan struct{}
 
+	addrIndexer *core.AddressIndexer // Address indexer, nil unless enabled
+	logIndexer  *core.ChainIndexer   // Exact log indexer, nil unless enabled
+
 	APIBackend *EthAPIBackend
 
 	miner     *miner.Miner
@@ -118,7 +122,7 @@ func New(stack *node.Node, config *Config) (*Ethereum, error) {
 	if err != nil {
 		return nil, err
 	}
-	chainConfig, genesisHash, genesisErr := core.SetupGenesisBlock(chainDb, config.Genesis)
+	chainConfig, genesisHash, genesisErr := core.SetupGenesisBlockWithOverride(chainDb, config.Genesis, config.OverrideForks)
 	if _, ok := genesisErr.(*params.ConfigCompatError); genesisErr != nil && !ok {
 		return nil, genesisErr
 	}
@@ -138,6 +142,12 @@ func New(stack *node.Node, config *Config) (*Ethereum, error) {
 		bloomIndexer:      NewBloomIndexer(chainDb, params.BloomBitsBlocks, params.BloomConfirms),
 		p2pServer:         stack.Server(),
 	}
+	if engine, ok := mxt.engine.(*mxtash.Ethash); ok {
+		if err := engine.StartStratum(); err != nil {
+			engine.Close()
+			return nil, err
+		}
+	}
 
 	bcVersion := rawdb.ReadDatabaseVersion(chainDb)
 	var dbVer = "<nil>"
@@ -183,9 +193,21 @@ func New(stack *node.Node, config *Config) (*Ethereum, error) {
 	}
 	mxt.bloomIndexer.Start(mxt.blockchain)
 
+	if config.AddressIndex {
+		mxt.addrIndexer = core.NewAddressIndexer(mxt.blockchain)
+		mxt.addrIndexer.Start()
+	}
+	if config.LogIndex {
+		mxt.logIndexer = NewLogIndexer(chainDb, params.BloomBitsBlocks, params.BloomConfirms)
+		mxt.logIndexer.Start(mxt.blockchain)
+	}
+
 	if config.TxPool.Journal != "" {
 		config.TxPool.Journal = stack.ResolvePath(config.TxPool.Journal)
 	}
+	if config.TxPool.Clock == nil {
+		config.TxPool.Clock = config.Clock
+	}
 	mxt.txPool = core.NewTxPool(config.TxPool, chainConfig, mxt.blockchain)
 
 	// Permit the downloader to use the trie cache allowance during fast sync
@@ -194,10 +216,15 @@ func New(stack *node.Node, config *Config) (*Ethereum, error) {
 	if checkpoint == nil {
 		checkpoint = params.TrustedCheckpoints[genesisHash]
 	}
-	if mxt.protocolManager, err = NewProtocolManager(chainConfig, checkpoint, config.SyncMode, config.NetworkId, mxt.eventMux, mxt.txPool, mxt.engine, mxt.blockchain, chainDb, cacheLimit, config.Whitelist); err != nil {
+	if mxt.protocolManager, err = NewProtocolManager(chainConfig, checkpoint, config.SyncMode, config.NetworkId, mxt.eventMux, mxt.txPool, mxt.engine, mxt.blockchain, chainDb, cacheLimit, config.Whitelist, config.Clock); err != nil {
+		return nil, err
+	}
+	if config.Miner.Clock == nil {
+		config.Miner.Clock = config.Clock
+	}
+	if mxt.miner, err = miner.New(mxt, &config.Miner, mxt.EventMux(), mxt.engine, mxt.isLocalBlock); err != nil {
 		return nil, err
 	}
-	mxt.miner = miner.New(mxt, &config.Miner, chainConfig, mxt.EventMux(), mxt.engine, mxt.isLocalBlock)
 	mxt.miner.SetExtra(makeExtraData(config.Miner.ExtraData))
 
 	mxt.APIBackend = &EthAPIBackend{stack.Config().ExtRPCEnabled(), mxt, nil}
@@ -245,6 +272,10 @@ func CreateConsensusEngine(stack *node.Node, chainConfig *params.ChainConfig, co
 	if chainConfig.Clique != nil {
 		return clique.New(chainConfig.Clique, db)
 	}
+	// If Byzantine fault tolerance is requested, validate with the node key
+	if chainConfig.IBFT != nil {
+		return ibft.New(chainConfig.IBFT, db, stack.Config().NodeKey())
+	}
 	// Otherwise assume proof-of-work
 	switch config.PowMode {
 	case mxtash.ModeFake:
@@ -266,6 +297,10 @@ func CreateConsensusEngine(stack *node.Node, chainConfig *params.ChainConfig, co
 			DatasetsInMem:    config.DatasetsInMem,
 			DatasetsOnDisk:   config.DatasetsOnDisk,
 			DatasetsLockMmap: config.DatasetsLockMmap,
+			Confirmations:    config.Confirmations,
+
+			StratumAddr:       config.StratumAddr,
+			StratumDifficulty: config.StratumDifficulty,
 		}, notify, noverify)
 		engine.SetThreads(-1) // Disable CPU mining
 		return engine
@@ -500,10 +535,15 @@ func (s *Ethereum) BloomIndexer() *core.ChainIndexer   { return s.bloomIndexer }
 // network protocols to start.
 func (s *Ethereum) Protocols() []p2p.Protocol {
 	protos := make([]p2p.Protocol, len(ProtocolVersions))
+	dialFilter := newDialFilter(s.blockchain)
 	for i, vsn := range ProtocolVersions {
 		protos[i] = s.protocolManager.makeProtocol(vsn)
 		protos[i].Attributes = []enr.Entry{s.currentEthEntry()}
 		protos[i].DialCandidates = s.dialCandidates
+		protos[i].DialFilter = dialFilter
+	}
+	if engine, ok := s.engine.(*ibft.IBFT); ok {
+		protos = append(protos, engine.Protocols()...)
 	}
 	return protos
 }
@@ -526,6 +566,11 @@ func (s *Ethereum) Start() error {
 	}
 	// Start the networking layer and the light server if requested
 	s.protocolManager.Start(maxPeers)
+
+	// Take part in the consensus if validating with Byzantine fault tolerance
+	if engine, ok := s.engine.(*ibft.IBFT); ok {
+		engine.Start(s.blockchain)
+	}
 	return nil
 }
 
@@ -538,6 +583,12 @@ func (s *Ethereum) Stop() error {
 	// Then stop everything else.
 	s.bloomIndexer.Close()
 	close(s.closeBloomHandler)
+	if s.addrIndexer != nil {
+		s.addrIndexer.Close()
+	}
+	if s.logIndexer != nil {
+		s.logIndexer.Close()
+	}
 	s.txPool.Stop()
 	s.miner.Stop()
 	s.blockchain.Stop()
@@ -25,6 +25,7 @@ import (
 	"time"
 
 	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/common/mclock"
 	"github.com/mxt/go-mxt/consensus/mxtash"
 	"github.com/mxt/go-mxt/core"
 	"github.com/mxt/go-mxt/mxt/downloader"
@@ -58,6 +59,8 @@ var DefaultConfig = Config{
 		DatasetsInMem:    1,
 		DatasetsOnDisk:   2,
 		DatasetsLockMmap: false,
+
+		StratumDifficulty: 1,
 	},
 	NetworkId:               1,
 	LightPeers:              100,
@@ -109,6 +112,10 @@ type Config struct {
 	// If nil, the Ethereum main net block is used.
 	Genesis *core.Genesis `toml:",omitempty"`
 
+	// Forks of the chain configuration to reschedule, keyed by their JSON field
+	// names (e.g. "istanbulBlock") and set to a block number or timestamp.
+	OverrideForks map[string]uint64 `toml:",omitempty"`
+
 	// Protocol options
 	NetworkId uint64 // Network ID to use for selecting peers to connect to
 	SyncMode  downloader.SyncMode
@@ -121,6 +128,8 @@ type Config struct {
 	NoPrefetch bool // Whmxter to disable prefetching and only load state on demand
 
 	TxLookupLimit uint64 `toml:",omitempty"` // The maximum number of blocks from head whose tx indices are reserved.
+	AddressIndex  bool   `toml:",omitempty"` // Whmxter to index the transactions of every account by address
+	LogIndex      bool   `toml:",omitempty"` // Whmxter to maintain an exact log index by address and topic
 
 	// Whitelist of required block number -> hash values to accept
 	Whitelist map[uint64]common.Hash `toml:"-"`
@@ -168,6 +177,9 @@ type Config struct {
 	// Miscellaneous options
 	DocRoot string `toml:"-"`
 
+	// Developer enables the APIs only safe to use on a development chain
+	Developer bool `toml:"-"`
+
 	// Type of the EWASM interpreter ("" for default)
 	EWASMInterpreter string
 
@@ -181,9 +193,18 @@ type Config struct {
 	// send-transction variants. The unit is mxter.
 	RPCTxFeeCap float64 `toml:",omitempty"`
 
+	// GraphQLTracing enables the GraphQL block fields which re-execute blocks,
+	// i.e. call traces and state diffs.
+	GraphQLTracing bool `toml:",omitempty"`
+
 	// Checkpoint is a hardcoded checkpoint which can be nil.
 	Checkpoint *params.TrustedCheckpoint `toml:",omitempty"`
 
 	// CheckpointOracle is the configuration for checkpoint oracle.
 	CheckpointOracle *params.CheckpointOracleConfig `toml:",omitempty"`
+
+	// Clock is the time source of the downloader and the chain syncer. It is also
+	// used by the transaction pool and the miner unless their configs set another
+	// clock. The default is the system clock.
+	Clock mclock.Clock `toml:"-"`
 }
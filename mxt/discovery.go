@@ -22,6 +22,7 @@ import (
 	"github.com/mxt/go-mxt/p2p"
 	"github.com/mxt/go-mxt/p2p/dnsdisc"
 	"github.com/mxt/go-mxt/p2p/enode"
+	"github.com/mxt/go-mxt/p2p/enr"
 	"github.com/mxt/go-mxt/rlp"
 )
 
@@ -60,8 +61,9 @@ func (mxt *Ethereum) startEthEntryUpdate(ln *enode.LocalNode) {
 }
 
 func (mxt *Ethereum) currentEthEntry() *mxtEntry {
+	head := mxt.blockchain.CurrentHeader()
 	return &mxtEntry{ForkID: forkid.NewID(mxt.blockchain.Config(), mxt.blockchain.Genesis().Hash(),
-		mxt.blockchain.CurrentHeader().Number.Uint64())}
+		head.Number.Uint64(), head.Time)}
 }
 
 // setupDiscovery creates the node discovery source for the mxt protocol.
@@ -72,3 +74,17 @@ func (mxt *Ethereum) setupDiscovery(cfg *p2p.Config) (enode.Iterator, error) {
 	client := dnsdisc.NewClient(dnsdisc.Config{})
 	return client.NewIterator(mxt.config.DiscoveryURLs...)
 }
+
+// newDialFilter creates a dial filter which skips nodes advertising an incompatible
+// fork ID in their "mxt" entry. Nodes without the entry are dialed and checked
+// during the handshake, as their records may be incomplete.
+func newDialFilter(chain forkid.Blockchain) func(*enode.Node) bool {
+	forkFilter := forkid.NewFilter(chain)
+	return func(n *enode.Node) bool {
+		var entry mxtEntry
+		if err := n.Load(&entry); err != nil {
+			return enr.IsNotFound(err)
+		}
+		return forkFilter(entry.ForkID) == nil
+	}
+}
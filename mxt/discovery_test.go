@@ -0,0 +1,60 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package mxt
+
+import (
+	"testing"
+
+	"github.com/mxt/go-mxt/core/forkid"
+	"github.com/mxt/go-mxt/mxt/downloader"
+	"github.com/mxt/go-mxt/p2p/enode"
+	"github.com/mxt/go-mxt/p2p/enr"
+)
+
+// This test checks that the dial filter skips nodes advertising incompatible or
+// malformed fork IDs, and dials nodes without an "mxt" entry.
+func TestDialFilter(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	defer pm.Stop()
+
+	var (
+		chain   = pm.blockchain
+		head    = chain.CurrentHeader()
+		localID = forkid.NewID(chain.Config(), chain.Genesis().Hash(), head.Number.Uint64(), head.Time)
+		filter  = newDialFilter(chain)
+	)
+	tests := []struct {
+		name  string
+		entry enr.Entry
+		want  bool
+	}{
+		{"missing", nil, true},
+		{"compatible", &mxtEntry{ForkID: localID}, true},
+		{"incompatible", &mxtEntry{ForkID: forkid.ID{Hash: [4]byte{0xde, 0xad, 0xbe, 0xef}}}, false},
+		{"malformed", enr.WithEntry("mxt", uint(1)), false},
+	}
+	for i, test := range tests {
+		r := new(enr.Record)
+		if test.entry != nil {
+			r.Set(test.entry)
+		}
+		n := enode.SignNull(r, enode.ID{byte(i)})
+		if have := filter(n); have != test.want {
+			t.Errorf("%s: filter result mismatch: have %v, want %v", test.name, have, test.want)
+		}
+	}
+}
@@ -27,6 +27,7 @@ import (
 
 	"github.com/mxt/go-mxt"
 	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/common/mclock"
 	"github.com/mxt/go-mxt/core/rawdb"
 	"github.com/mxt/go-mxt/core/types"
 	"github.com/mxt/go-mxt/mxtdb"
@@ -89,6 +90,7 @@ var (
 	errCancelContentProcessing = errors.New("content processing canceled (requested)")
 	errCanceled                = errors.New("syncing canceled (requested)")
 	errNoSyncActive            = errors.New("no sync active")
+	errUnrequestedDelivery     = errors.New("delivery doesn't answer the request in flight")
 	errTooOld                  = errors.New("peer doesn't speak recent enough protocol version (need version >= 63)")
 )
 
@@ -111,6 +113,8 @@ type Downloader struct {
 	stateDB    mxtdb.Database  // Database to state sync into (and deduplicate via)
 	stateBloom *trie.SyncBloom // Bloom filter for fast trie node and contract code existence checks
 
+	clock mclock.Clock // Time source for request timeouts and QoS measurements
+
 	// Statistics
 	syncStatsChainOrigin uint64 // Origin block number where syncing started at
 	syncStatsChainHeight uint64 // Highest block number known when syncing started
@@ -212,17 +216,22 @@ type BlockChain interface {
 	InsertReceiptChain(types.Blocks, []types.Receipts, uint64) (int, error)
 }
 
-// New creates a new downloader to fetch hashes and blocks from remote peers.
-func New(checkpoint uint64, stateDb mxtdb.Database, stateBloom *trie.SyncBloom, mux *event.TypeMux, chain BlockChain, lightchain LightChain, dropPeer peerDropFn) *Downloader {
+// New creates a new downloader to fetch hashes and blocks from remote peers. If
+// clock is nil, the system clock is used.
+func New(checkpoint uint64, stateDb mxtdb.Database, stateBloom *trie.SyncBloom, mux *event.TypeMux, chain BlockChain, lightchain LightChain, dropPeer peerDropFn, clock mclock.Clock) *Downloader {
 	if lightchain == nil {
 		lightchain = chain
 	}
+	if clock == nil {
+		clock = mclock.System{}
+	}
 	dl := &Downloader{
 		stateDB:        stateDb,
 		stateBloom:     stateBloom,
+		clock:          clock,
 		mux:            mux,
 		checkpoint:     checkpoint,
-		queue:          newQueue(blockCacheMaxItems, blockCacheInitialItems),
+		queue:          newQueue(blockCacheMaxItems, blockCacheInitialItems, clock),
 		peers:          newPeerSet(),
 		rttEstimate:    uint64(rttMaxEstimate),
 		rttConfidence:  uint64(1000000),
@@ -300,7 +309,7 @@ func (d *Downloader) SyncBloomContains(hash []byte) bool {
 func (d *Downloader) RegisterPeer(id string, version int, peer Peer) error {
 	logger := log.New("peer", id)
 	logger.Trace("Registering sync peer")
-	if err := d.peers.Register(newPeerConnection(id, version, peer, logger)); err != nil {
+	if err := d.peers.Register(newPeerConnection(id, version, peer, d.clock, logger)); err != nil {
 		logger.Error("Failed to register sync peer", "err", err)
 		return err
 	}
@@ -449,9 +458,9 @@ func (d *Downloader) syncWithPeer(p *peerConnection, hash common.Hash, td *big.I
 	mode := d.getMode()
 
 	log.Debug("Synchronising with the network", "peer", p.id, "mxt", p.version, "head", hash, "td", td, "mode", mode)
-	defer func(start time.Time) {
-		log.Debug("Synchronisation terminated", "elapsed", common.PrettyDuration(time.Since(start)))
-	}(time.Now())
+	defer func(start mclock.AbsTime) {
+		log.Debug("Synchronisation terminated", "elapsed", common.PrettyDuration(d.clock.Now()-start))
+	}(d.clock.Now())
 
 	// Look up the sync boundaries: the common ancestor and the target block
 	latest, pivot, err := d.fetchHead(p)
@@ -641,10 +650,10 @@ func (d *Downloader) fetchHead(p *peerConnection) (head *types.Header, pivot *ty
 	if mode == FastSync {
 		fetch = 2 // head + pivot headers
 	}
-	go p.peer.RequestHeadersByHash(latest, fetch, fsMinFullBlocks-1, true)
+	go p.peer.RequestHeadersByHash(p.newRequest(headerRequest), latest, fetch, fsMinFullBlocks-1, true)
 
 	ttl := d.requestTTL()
-	timeout := time.After(ttl)
+	timeout := d.clock.After(ttl)
 	for {
 		select {
 		case <-d.cancelCh:
@@ -801,13 +810,13 @@ func (d *Downloader) findAncestor(p *peerConnection, remoteHeader *types.Header)
 	from, count, skip, max := calculateRequestSpan(remoteHeight, localHeight)
 
 	p.log.Trace("Span searching for common ancestor", "count", count, "from", from, "skip", skip)
-	go p.peer.RequestHeadersByNumber(uint64(from), count, skip, false)
+	go p.peer.RequestHeadersByNumber(p.newRequest(headerRequest), uint64(from), count, skip, false)
 
 	// Wait for the remote response to the head fetch
 	number, hash := uint64(0), common.Hash{}
 
 	ttl := d.requestTTL()
-	timeout := time.After(ttl)
+	timeout := d.clock.After(ttl)
 
 	for finished := false; !finished; {
 		select {
@@ -890,9 +899,9 @@ func (d *Downloader) findAncestor(p *peerConnection, remoteHeader *types.Header)
 		check := (start + end) / 2
 
 		ttl := d.requestTTL()
-		timeout := time.After(ttl)
+		timeout := d.clock.After(ttl)
 
-		go p.peer.RequestHeadersByNumber(check, 1, 0, false)
+		go p.peer.RequestHeadersByNumber(p.newRequest(headerRequest), check, 1, 0, false)
 
 		// Wait until a reply arrives to this request
 		for arrived := false; !arrived; {
@@ -971,31 +980,31 @@ func (d *Downloader) fetchHeaders(p *peerConnection, from uint64) error {
 	defer p.log.Debug("Header download terminated")
 
 	// Create a timeout timer, and the associated header fetcher
-	skeleton := true            // Skeleton assembly phase or finishing up
-	pivoting := false           // Whmxter the next request is pivot verification
-	request := time.Now()       // time of the last skeleton fetch request
-	timeout := time.NewTimer(0) // timer to dump a non-responsive active peer
-	<-timeout.C                 // timeout channel should be initially empty
+	skeleton := true                       // Skeleton assembly phase or finishing up
+	pivoting := false                      // Whmxter the next request is pivot verification
+	request := d.clock.Now()               // time of the last skeleton fetch request
+	timeout := d.clock.NewTimer(time.Hour) // timer to dump a non-responsive active peer
+	timeout.Stop()                         // timeout channel should be initially empty
 	defer timeout.Stop()
 
 	var ttl time.Duration
 	getHeaders := func(from uint64) {
-		request = time.Now()
+		request = d.clock.Now()
 
 		ttl = d.requestTTL()
 		timeout.Reset(ttl)
 
 		if skeleton {
 			p.log.Trace("Fetching skeleton headers", "count", MaxHeaderFetch, "from", from)
-			go p.peer.RequestHeadersByNumber(from+uint64(MaxHeaderFetch)-1, MaxSkeletonSize, MaxHeaderFetch-1, false)
+			go p.peer.RequestHeadersByNumber(p.newRequest(headerRequest), from+uint64(MaxHeaderFetch)-1, MaxSkeletonSize, MaxHeaderFetch-1, false)
 		} else {
 			p.log.Trace("Fetching full headers", "count", MaxHeaderFetch, "from", from)
-			go p.peer.RequestHeadersByNumber(from, MaxHeaderFetch, 0, false)
+			go p.peer.RequestHeadersByNumber(p.newRequest(headerRequest), from, MaxHeaderFetch, 0, false)
 		}
 	}
 	getNextPivot := func() {
 		pivoting = true
-		request = time.Now()
+		request = d.clock.Now()
 
 		ttl = d.requestTTL()
 		timeout.Reset(ttl)
@@ -1005,7 +1014,7 @@ func (d *Downloader) fetchHeaders(p *peerConnection, from uint64) error {
 		d.pivotLock.RUnlock()
 
 		p.log.Trace("Fetching next pivot header", "number", pivot+uint64(fsMinFullBlocks))
-		go p.peer.RequestHeadersByNumber(pivot+uint64(fsMinFullBlocks), 2, fsMinFullBlocks-9, false) // move +64 when it's 2x64-8 deep
+		go p.peer.RequestHeadersByNumber(p.newRequest(headerRequest), pivot+uint64(fsMinFullBlocks), 2, fsMinFullBlocks-9, false) // move +64 when it's 2x64-8 deep
 	}
 	// Start pulling the header chain skeleton until all is done
 	ancestor := from
@@ -1023,7 +1032,7 @@ func (d *Downloader) fetchHeaders(p *peerConnection, from uint64) error {
 				log.Debug("Received skeleton from incorrect peer", "peer", packet.PeerId())
 				break
 			}
-			headerReqTimer.UpdateSince(request)
+			headerReqTimer.Update(d.clock.Now().Sub(request))
 			timeout.Stop()
 
 			// If the pivot is being checked, move if it became stale and run the real retrieval
@@ -1076,7 +1085,7 @@ func (d *Downloader) fetchHeaders(p *peerConnection, from uint64) error {
 				if atomic.LoadInt32(&d.committed) == 0 && pivot <= from {
 					p.log.Debug("No headers, waiting for pivot commit")
 					select {
-					case <-time.After(fsHeaderContCheck):
+					case <-d.clock.After(fsHeaderContCheck):
 						getHeaders(from)
 						continue
 					case <-d.cancelCh:
@@ -1155,7 +1164,7 @@ func (d *Downloader) fetchHeaders(p *peerConnection, from uint64) error {
 				// No headers delivered, or all of them being delayed, sleep a bit and retry
 				p.log.Trace("All headers delayed, waiting")
 				select {
-				case <-time.After(fsHeaderContCheck):
+				case <-d.clock.After(fsHeaderContCheck):
 					getHeaders(from)
 					continue
 				case <-d.cancelCh:
@@ -1163,7 +1172,7 @@ func (d *Downloader) fetchHeaders(p *peerConnection, from uint64) error {
 				}
 			}
 
-		case <-timeout.C:
+		case <-timeout.C():
 			if d.dropPeer == nil {
 				// The dropPeer mmxtod is nil when `--copydb` is used for a local copy.
 				// Timeouts can occur if e.g. compaction hits at the wrong time, and can be ignored
@@ -1215,7 +1224,7 @@ func (d *Downloader) fillHeaderSkeleton(from uint64, skeleton []*types.Header) (
 		}
 		fetch    = func(p *peerConnection, req *fetchRequest) error { return p.FetchHeaders(req.From, MaxHeaderFetch) }
 		capacity = func(p *peerConnection) int { return p.HeaderCapacity(d.requestRTT()) }
-		setIdle  = func(p *peerConnection, accepted int, deliveryTime time.Time) {
+		setIdle  = func(p *peerConnection, accepted int, deliveryTime mclock.AbsTime) {
 			p.SetHeadersIdle(accepted, deliveryTime)
 		}
 	)
@@ -1243,7 +1252,9 @@ func (d *Downloader) fetchBodies(from uint64) error {
 		expire   = func() map[string]int { return d.queue.ExpireBodies(d.requestTTL()) }
 		fetch    = func(p *peerConnection, req *fetchRequest) error { return p.FetchBodies(req) }
 		capacity = func(p *peerConnection) int { return p.BlockCapacity(d.requestRTT()) }
-		setIdle  = func(p *peerConnection, accepted int, deliveryTime time.Time) { p.SetBodiesIdle(accepted, deliveryTime) }
+		setIdle  = func(p *peerConnection, accepted int, deliveryTime mclock.AbsTime) {
+			p.SetBodiesIdle(accepted, deliveryTime)
+		}
 	)
 	err := d.fetchParts(d.bodyCh, deliver, d.bodyWakeCh, expire,
 		d.queue.PendingBlocks, d.queue.InFlightBlocks, d.queue.ReserveBodies,
@@ -1267,7 +1278,7 @@ func (d *Downloader) fetchReceipts(from uint64) error {
 		expire   = func() map[string]int { return d.queue.ExpireReceipts(d.requestTTL()) }
 		fetch    = func(p *peerConnection, req *fetchRequest) error { return p.FetchReceipts(req) }
 		capacity = func(p *peerConnection) int { return p.ReceiptCapacity(d.requestRTT()) }
-		setIdle  = func(p *peerConnection, accepted int, deliveryTime time.Time) {
+		setIdle  = func(p *peerConnection, accepted int, deliveryTime mclock.AbsTime) {
 			p.SetReceiptsIdle(accepted, deliveryTime)
 		}
 	)
@@ -1307,10 +1318,11 @@ func (d *Downloader) fetchReceipts(from uint64) error {
 func (d *Downloader) fetchParts(deliveryCh chan dataPack, deliver func(dataPack) (int, error), wakeCh chan bool,
 	expire func() map[string]int, pending func() int, inFlight func() bool, reserve func(*peerConnection, int) (*fetchRequest, bool, bool),
 	fetchHook func([]*types.Header), fetch func(*peerConnection, *fetchRequest) error, cancel func(*fetchRequest), capacity func(*peerConnection) int,
-	idle func() ([]*peerConnection, int), setIdle func(*peerConnection, int, time.Time), kind string) error {
+	idle func() ([]*peerConnection, int), setIdle func(*peerConnection, int, mclock.AbsTime), kind string) error {
 
 	// Create a ticker to detect expired retrieval tasks
-	ticker := time.NewTicker(100 * time.Millisecond)
+	const checkInterval = 100 * time.Millisecond
+	ticker := d.clock.NewTimer(checkInterval)
 	defer ticker.Stop()
 
 	update := make(chan struct{}, 1)
@@ -1323,7 +1335,7 @@ func (d *Downloader) fetchParts(deliveryCh chan dataPack, deliver func(dataPack)
 			return errCanceled
 
 		case packet := <-deliveryCh:
-			deliveryTime := time.Now()
+			deliveryTime := d.clock.Now()
 			// If the peer was previously banned and failed to deliver its pack
 			// in a reasonable time frame, ignore its message.
 			if peer := d.peers.Peer(packet.PeerId()); peer != nil {
@@ -1365,7 +1377,9 @@ func (d *Downloader) fetchParts(deliveryCh chan dataPack, deliver func(dataPack)
 			default:
 			}
 
-		case <-ticker.C:
+		case <-ticker.C():
+			ticker.Reset(checkInterval)
+
 			// Sanity check update the progress
 			select {
 			case update <- struct{}{}:
@@ -1389,7 +1403,7 @@ func (d *Downloader) fetchParts(deliveryCh chan dataPack, deliver func(dataPack)
 					// how response times reacts, to it always requests one more than the minimum (i.e. min 2).
 					if fails > 2 {
 						peer.log.Trace("Data delivery timed out", "type", kind)
-						setIdle(peer, 0, time.Now())
+						setIdle(peer, 0, d.clock.Now())
 					} else {
 						peer.log.Debug("Stalling delivery, dropping", "type", kind)
 
@@ -1622,7 +1636,7 @@ func (d *Downloader) processHeaders(origin uint64, td *big.Int) error {
 						case <-d.cancelCh:
 							rollbackErr = errCanceled
 							return errCanceled
-						case <-time.After(time.Second):
+						case <-d.clock.After(time.Second):
 						}
 					}
 					// Otherwise insert the headers for content retrieval
@@ -1816,7 +1830,7 @@ func (d *Downloader) processFastSyncContent() error {
 				}
 				oldPivot = nil
 
-			case <-time.After(time.Second):
+			case <-d.clock.After(time.Second):
 				oldTail = afterP
 				continue
 			}
@@ -1909,28 +1923,31 @@ func (d *Downloader) commitPivotBlock(result *fetchResult) error {
 }
 
 // DeliverHeaders injects a new batch of block headers received from a remote
-// node into the download schedule.
-func (d *Downloader) DeliverHeaders(id string, headers []*types.Header) (err error) {
-	return d.deliver(id, d.headerCh, &headerPack{id, headers}, headerInMeter, headerDropMeter)
+// node, in response to the request with the given ID.
+func (d *Downloader) DeliverHeaders(id string, reqID uint64, headers []*types.Header) (err error) {
+	return d.deliver(id, headerRequest, reqID, d.headerCh, &headerPack{id, headers}, headerInMeter, headerDropMeter)
 }
 
-// DeliverBodies injects a new batch of block bodies received from a remote node.
-func (d *Downloader) DeliverBodies(id string, transactions [][]*types.Transaction, uncles [][]*types.Header) (err error) {
-	return d.deliver(id, d.bodyCh, &bodyPack{id, transactions, uncles}, bodyInMeter, bodyDropMeter)
+// DeliverBodies injects a new batch of block bodies received from a remote node,
+// in response to the request with the given ID.
+func (d *Downloader) DeliverBodies(id string, reqID uint64, transactions [][]*types.Transaction, uncles [][]*types.Header) (err error) {
+	return d.deliver(id, bodyRequest, reqID, d.bodyCh, &bodyPack{id, transactions, uncles}, bodyInMeter, bodyDropMeter)
 }
 
-// DeliverReceipts injects a new batch of receipts received from a remote node.
-func (d *Downloader) DeliverReceipts(id string, receipts [][]*types.Receipt) (err error) {
-	return d.deliver(id, d.receiptCh, &receiptPack{id, receipts}, receiptInMeter, receiptDropMeter)
+// DeliverReceipts injects a new batch of receipts received from a remote node,
+// in response to the request with the given ID.
+func (d *Downloader) DeliverReceipts(id string, reqID uint64, receipts [][]*types.Receipt) (err error) {
+	return d.deliver(id, receiptRequest, reqID, d.receiptCh, &receiptPack{id, receipts}, receiptInMeter, receiptDropMeter)
 }
 
-// DeliverNodeData injects a new batch of node state data received from a remote node.
-func (d *Downloader) DeliverNodeData(id string, data [][]byte) (err error) {
-	return d.deliver(id, d.stateCh, &statePack{id, data}, stateInMeter, stateDropMeter)
+// DeliverNodeData injects a new batch of node state data received from a remote
+// node, in response to the request with the given ID.
+func (d *Downloader) DeliverNodeData(id string, reqID uint64, data [][]byte) (err error) {
+	return d.deliver(id, stateRequest, reqID, d.stateCh, &statePack{id, data}, stateInMeter, stateDropMeter)
 }
 
 // deliver injects a new batch of data received from a remote node.
-func (d *Downloader) deliver(id string, destCh chan dataPack, packet dataPack, inMeter, dropMeter metrics.Meter) (err error) {
+func (d *Downloader) deliver(id string, kind int, reqID uint64, destCh chan dataPack, packet dataPack, inMeter, dropMeter metrics.Meter) (err error) {
 	// Update the delivery metrics for both good and failed deliveries
 	inMeter.Mark(int64(packet.Items()))
 	defer func() {
@@ -1938,6 +1955,10 @@ func (d *Downloader) deliver(id string, destCh chan dataPack, packet dataPack, i
 			dropMeter.Mark(int64(packet.Items()))
 		}
 	}()
+	// Drop responses to requests already timed out or never made
+	if p := d.peers.Peer(id); p != nil && !p.resolveRequest(kind, reqID) {
+		return errUnrequestedDelivery
+	}
 	// Deliver or abort if the sync is canceled while queuing
 	d.cancelLock.RLock()
 	cancel := d.cancelCh
@@ -1971,7 +1992,7 @@ func (d *Downloader) qosTuner() {
 		select {
 		case <-d.quitCh:
 			return
-		case <-time.After(rtt):
+		case <-d.clock.After(rtt):
 		}
 	}
 }
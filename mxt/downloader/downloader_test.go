@@ -87,7 +87,7 @@ func newTester() *downloadTester {
 	tester.stateDb = rawdb.NewMemoryDatabase()
 	tester.stateDb.Put(testGenesis.Root().Bytes(), []byte{0x00})
 
-	tester.downloader = New(0, tester.stateDb, trie.NewSyncBloom(1, tester.stateDb), new(event.TypeMux), tester, nil, tester.dropPeer)
+	tester.downloader = New(0, tester.stateDb, trie.NewSyncBloom(1, tester.stateDb), new(event.TypeMux), tester, nil, tester.dropPeer, nil)
 	return tester
 }
 
@@ -425,43 +425,43 @@ func (dlp *downloadTesterPeer) Head() (common.Hash, *big.Int) {
 // RequestHeadersByHash constructs a GetBlockHeaders function based on a hashed
 // origin; associated with a particular peer in the download tester. The returned
 // function can be used to retrieve batches of headers from the particular peer.
-func (dlp *downloadTesterPeer) RequestHeadersByHash(origin common.Hash, amount int, skip int, reverse bool) error {
+func (dlp *downloadTesterPeer) RequestHeadersByHash(id uint64, origin common.Hash, amount int, skip int, reverse bool) error {
 	result := dlp.chain.headersByHash(origin, amount, skip, reverse)
-	go dlp.dl.downloader.DeliverHeaders(dlp.id, result)
+	go dlp.dl.downloader.DeliverHeaders(dlp.id, id, result)
 	return nil
 }
 
 // RequestHeadersByNumber constructs a GetBlockHeaders function based on a numbered
 // origin; associated with a particular peer in the download tester. The returned
 // function can be used to retrieve batches of headers from the particular peer.
-func (dlp *downloadTesterPeer) RequestHeadersByNumber(origin uint64, amount int, skip int, reverse bool) error {
+func (dlp *downloadTesterPeer) RequestHeadersByNumber(id uint64, origin uint64, amount int, skip int, reverse bool) error {
 	result := dlp.chain.headersByNumber(origin, amount, skip, reverse)
-	go dlp.dl.downloader.DeliverHeaders(dlp.id, result)
+	go dlp.dl.downloader.DeliverHeaders(dlp.id, id, result)
 	return nil
 }
 
 // RequestBodies constructs a getBlockBodies mmxtod associated with a particular
 // peer in the download tester. The returned function can be used to retrieve
 // batches of block bodies from the particularly requested peer.
-func (dlp *downloadTesterPeer) RequestBodies(hashes []common.Hash) error {
+func (dlp *downloadTesterPeer) RequestBodies(id uint64, hashes []common.Hash) error {
 	txs, uncles := dlp.chain.bodies(hashes)
-	go dlp.dl.downloader.DeliverBodies(dlp.id, txs, uncles)
+	go dlp.dl.downloader.DeliverBodies(dlp.id, id, txs, uncles)
 	return nil
 }
 
 // RequestReceipts constructs a getReceipts mmxtod associated with a particular
 // peer in the download tester. The returned function can be used to retrieve
 // batches of block receipts from the particularly requested peer.
-func (dlp *downloadTesterPeer) RequestReceipts(hashes []common.Hash) error {
+func (dlp *downloadTesterPeer) RequestReceipts(id uint64, hashes []common.Hash) error {
 	receipts := dlp.chain.receipts(hashes)
-	go dlp.dl.downloader.DeliverReceipts(dlp.id, receipts)
+	go dlp.dl.downloader.DeliverReceipts(dlp.id, id, receipts)
 	return nil
 }
 
 // RequestNodeData constructs a getNodeData mmxtod associated with a particular
 // peer in the download tester. The returned function can be used to retrieve
 // batches of node state data from the particularly requested peer.
-func (dlp *downloadTesterPeer) RequestNodeData(hashes []common.Hash) error {
+func (dlp *downloadTesterPeer) RequestNodeData(id uint64, hashes []common.Hash) error {
 	dlp.dl.lock.RLock()
 	defer dlp.dl.lock.RUnlock()
 
@@ -473,7 +473,7 @@ func (dlp *downloadTesterPeer) RequestNodeData(hashes []common.Hash) error {
 			}
 		}
 	}
-	go dlp.dl.downloader.DeliverNodeData(dlp.id, results)
+	go dlp.dl.downloader.DeliverNodeData(dlp.id, id, results)
 	return nil
 }
 
@@ -527,6 +527,11 @@ func TestCanonicalSynchronisation65Fast(t *testing.T) { testCanonicalSynchronisa
 func TestCanonicalSynchronisation65Light(t *testing.T) {
 	testCanonicalSynchronisation(t, 65, LightSync)
 }
+func TestCanonicalSynchronisation66Full(t *testing.T) { testCanonicalSynchronisation(t, 66, FullSync) }
+func TestCanonicalSynchronisation66Fast(t *testing.T) { testCanonicalSynchronisation(t, 66, FastSync) }
+func TestCanonicalSynchronisation66Light(t *testing.T) {
+	testCanonicalSynchronisation(t, 66, LightSync)
+}
 
 func testCanonicalSynchronisation(t *testing.T, protocol int, mode SyncMode) {
 	t.Parallel()
@@ -774,17 +779,55 @@ func TestInactiveDownloader63(t *testing.T) {
 	defer tester.terminate()
 
 	// Check that neither block headers nor bodies are accepted
-	if err := tester.downloader.DeliverHeaders("bad peer", []*types.Header{}); err != errNoSyncActive {
+	if err := tester.downloader.DeliverHeaders("bad peer", 0, []*types.Header{}); err != errNoSyncActive {
 		t.Errorf("error mismatch: have %v, want %v", err, errNoSyncActive)
 	}
-	if err := tester.downloader.DeliverBodies("bad peer", [][]*types.Transaction{}, [][]*types.Header{}); err != errNoSyncActive {
+	if err := tester.downloader.DeliverBodies("bad peer", 0, [][]*types.Transaction{}, [][]*types.Header{}); err != errNoSyncActive {
 		t.Errorf("error mismatch: have %v, want %v", err, errNoSyncActive)
 	}
-	if err := tester.downloader.DeliverReceipts("bad peer", [][]*types.Receipt{}); err != errNoSyncActive {
+	if err := tester.downloader.DeliverReceipts("bad peer", 0, [][]*types.Receipt{}); err != errNoSyncActive {
 		t.Errorf("error mismatch: have %v, want %v", err, errNoSyncActive)
 	}
 }
 
+// Tests that deliveries from mxt/66 peers are only accepted if they answer the
+// request in flight, while older peers are trusted to answer in order.
+func TestDeliveryRequestIDs66(t *testing.T) {
+	t.Parallel()
+
+	tester := newTester()
+	defer tester.terminate()
+
+	tester.newPeer("peer66", 66, testChainBase)
+	tester.newPeer("peer65", 65, testChainBase)
+	p := tester.downloader.peers.Peer("peer66")
+
+	// Responses to requests never made are dropped
+	if err := tester.downloader.DeliverHeaders("peer66", 1, nil); err != errUnrequestedDelivery {
+		t.Errorf("unsolicited delivery error mismatch: have %v, want %v", err, errUnrequestedDelivery)
+	}
+	// Responses to replaced requests are dropped, the ones to the request in flight
+	// accepted exactly once (and rejected later on as no sync is running)
+	stale := p.newRequest(bodyRequest)
+	id := p.newRequest(bodyRequest)
+	if err := tester.downloader.DeliverBodies("peer66", stale, nil, nil); err != errUnrequestedDelivery {
+		t.Errorf("stale delivery error mismatch: have %v, want %v", err, errUnrequestedDelivery)
+	}
+	if err := tester.downloader.DeliverReceipts("peer66", id, nil); err != errUnrequestedDelivery {
+		t.Errorf("mismatching kind delivery error mismatch: have %v, want %v", err, errUnrequestedDelivery)
+	}
+	if err := tester.downloader.DeliverBodies("peer66", id, nil, nil); err != errNoSyncActive {
+		t.Errorf("delivery error mismatch: have %v, want %v", err, errNoSyncActive)
+	}
+	if err := tester.downloader.DeliverBodies("peer66", id, nil, nil); err != errUnrequestedDelivery {
+		t.Errorf("duplicate delivery error mismatch: have %v, want %v", err, errUnrequestedDelivery)
+	}
+	// Older peers don't tag their responses
+	if err := tester.downloader.DeliverHeaders("peer65", 0, nil); err != errNoSyncActive {
+		t.Errorf("untagged delivery error mismatch: have %v, want %v", err, errNoSyncActive)
+	}
+}
+
 // Tests that a canceled download wipes all previously accumulated state.
 func TestCancel63Full(t *testing.T)  { testCancel(t, 63, FullSync) }
 func TestCancel63Fast(t *testing.T)  { testCancel(t, 63, FastSync) }
@@ -1542,25 +1585,25 @@ type floodingTestPeer struct {
 }
 
 func (ftp *floodingTestPeer) Head() (common.Hash, *big.Int) { return ftp.peer.Head() }
-func (ftp *floodingTestPeer) RequestHeadersByHash(hash common.Hash, count int, skip int, reverse bool) error {
-	return ftp.peer.RequestHeadersByHash(hash, count, skip, reverse)
+func (ftp *floodingTestPeer) RequestHeadersByHash(id uint64, hash common.Hash, count int, skip int, reverse bool) error {
+	return ftp.peer.RequestHeadersByHash(id, hash, count, skip, reverse)
 }
-func (ftp *floodingTestPeer) RequestBodies(hashes []common.Hash) error {
-	return ftp.peer.RequestBodies(hashes)
+func (ftp *floodingTestPeer) RequestBodies(id uint64, hashes []common.Hash) error {
+	return ftp.peer.RequestBodies(id, hashes)
 }
-func (ftp *floodingTestPeer) RequestReceipts(hashes []common.Hash) error {
-	return ftp.peer.RequestReceipts(hashes)
+func (ftp *floodingTestPeer) RequestReceipts(id uint64, hashes []common.Hash) error {
+	return ftp.peer.RequestReceipts(id, hashes)
 }
-func (ftp *floodingTestPeer) RequestNodeData(hashes []common.Hash) error {
-	return ftp.peer.RequestNodeData(hashes)
+func (ftp *floodingTestPeer) RequestNodeData(id uint64, hashes []common.Hash) error {
+	return ftp.peer.RequestNodeData(id, hashes)
 }
 
-func (ftp *floodingTestPeer) RequestHeadersByNumber(from uint64, count, skip int, reverse bool) error {
+func (ftp *floodingTestPeer) RequestHeadersByNumber(id uint64, from uint64, count, skip int, reverse bool) error {
 	deliveriesDone := make(chan struct{}, 500)
 	for i := 0; i < cap(deliveriesDone)-1; i++ {
 		peer := fmt.Sprintf("fake-peer%d", i)
 		go func() {
-			ftp.tester.downloader.DeliverHeaders(peer, []*types.Header{{}, {}, {}, {}})
+			ftp.tester.downloader.DeliverHeaders(peer, 0, []*types.Header{{}, {}, {}, {}})
 			deliveriesDone <- struct{}{}
 		}()
 	}
@@ -1575,7 +1618,7 @@ func (ftp *floodingTestPeer) RequestHeadersByNumber(from uint64, count, skip int
 				// Start delivering the requested headers
 				// after one of the flooding responses has arrived.
 				go func() {
-					ftp.peer.RequestHeadersByNumber(from, count, skip, reverse)
+					ftp.peer.RequestHeadersByNumber(id, from, count, skip, reverse)
 					deliveriesDone <- struct{}{}
 				}()
 				launched = true
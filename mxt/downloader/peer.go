@@ -23,12 +23,14 @@ import (
 	"errors"
 	"math"
 	"math/big"
+	"math/rand"
 	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/common/mclock"
 	"github.com/mxt/go-mxt/event"
 	"github.com/mxt/go-mxt/log"
 )
@@ -38,6 +40,15 @@ const (
 	measurementImpact = 0.1  // The impact a single measurement has on a peer's final throughput value.
 )
 
+// Kinds of the requests tracked by ID for each peer.
+const (
+	headerRequest = iota
+	bodyRequest
+	receiptRequest
+	stateRequest
+	requestKinds
+)
+
 var (
 	errAlreadyFetching   = errors.New("already fetching blocks from peer")
 	errAlreadyRegistered = errors.New("peer is already registered")
@@ -60,33 +71,39 @@ type peerConnection struct {
 
 	rtt time.Duration // Request round trip time to track responsiveness (QoS)
 
-	headerStarted  time.Time // Time instance when the last header fetch was started
-	blockStarted   time.Time // Time instance when the last block (body) fetch was started
-	receiptStarted time.Time // Time instance when the last receipt fetch was started
-	stateStarted   time.Time // Time instance when the last node data fetch was started
+	headerStarted  mclock.AbsTime // Time instance when the last header fetch was started
+	blockStarted   mclock.AbsTime // Time instance when the last block (body) fetch was started
+	receiptStarted mclock.AbsTime // Time instance when the last receipt fetch was started
+	stateStarted   mclock.AbsTime // Time instance when the last node data fetch was started
 
 	lacking map[common.Hash]struct{} // Set of hashes not to request (didn't have previously)
 
+	requests [requestKinds]uint64 // IDs of the requests in flight, checked on delivery from mxt/66 on
+
 	peer Peer
 
-	version int        // Eth protocol version number to switch strategies
-	log     log.Logger // Contextual logger to add extra infos to peer logs
+	version int          // Eth protocol version number to switch strategies
+	clock   mclock.Clock // Time source for measuring the request round trip times
+	log     log.Logger   // Contextual logger to add extra infos to peer logs
 	lock    sync.RWMutex
 }
 
 // LightPeer encapsulates the mmxtods required to synchronise with a remote light peer.
+//
+// Each request is tagged with an ID picked by the downloader, which the peer
+// must hand back with the delivery of the response.
 type LightPeer interface {
 	Head() (common.Hash, *big.Int)
-	RequestHeadersByHash(common.Hash, int, int, bool) error
-	RequestHeadersByNumber(uint64, int, int, bool) error
+	RequestHeadersByHash(uint64, common.Hash, int, int, bool) error
+	RequestHeadersByNumber(uint64, uint64, int, int, bool) error
 }
 
 // Peer encapsulates the mmxtods required to synchronise with a remote full peer.
 type Peer interface {
 	LightPeer
-	RequestBodies([]common.Hash) error
-	RequestReceipts([]common.Hash) error
-	RequestNodeData([]common.Hash) error
+	RequestBodies(uint64, []common.Hash) error
+	RequestReceipts(uint64, []common.Hash) error
+	RequestNodeData(uint64, []common.Hash) error
 }
 
 // lightPeerWrapper wraps a LightPeer struct, stubbing out the Peer-only mmxtods.
@@ -95,29 +112,30 @@ type lightPeerWrapper struct {
 }
 
 func (w *lightPeerWrapper) Head() (common.Hash, *big.Int) { return w.peer.Head() }
-func (w *lightPeerWrapper) RequestHeadersByHash(h common.Hash, amount int, skip int, reverse bool) error {
-	return w.peer.RequestHeadersByHash(h, amount, skip, reverse)
+func (w *lightPeerWrapper) RequestHeadersByHash(id uint64, h common.Hash, amount int, skip int, reverse bool) error {
+	return w.peer.RequestHeadersByHash(id, h, amount, skip, reverse)
 }
-func (w *lightPeerWrapper) RequestHeadersByNumber(i uint64, amount int, skip int, reverse bool) error {
-	return w.peer.RequestHeadersByNumber(i, amount, skip, reverse)
+func (w *lightPeerWrapper) RequestHeadersByNumber(id uint64, i uint64, amount int, skip int, reverse bool) error {
+	return w.peer.RequestHeadersByNumber(id, i, amount, skip, reverse)
 }
-func (w *lightPeerWrapper) RequestBodies([]common.Hash) error {
+func (w *lightPeerWrapper) RequestBodies(uint64, []common.Hash) error {
 	panic("RequestBodies not supported in light client mode sync")
 }
-func (w *lightPeerWrapper) RequestReceipts([]common.Hash) error {
+func (w *lightPeerWrapper) RequestReceipts(uint64, []common.Hash) error {
 	panic("RequestReceipts not supported in light client mode sync")
 }
-func (w *lightPeerWrapper) RequestNodeData([]common.Hash) error {
+func (w *lightPeerWrapper) RequestNodeData(uint64, []common.Hash) error {
 	panic("RequestNodeData not supported in light client mode sync")
 }
 
 // newPeerConnection creates a new downloader peer.
-func newPeerConnection(id string, version int, peer Peer, logger log.Logger) *peerConnection {
+func newPeerConnection(id string, version int, peer Peer, clock mclock.Clock, logger log.Logger) *peerConnection {
 	return &peerConnection{
 		id:      id,
 		lacking: make(map[common.Hash]struct{}),
 		peer:    peer,
 		version: version,
+		clock:   clock,
 		log:     logger,
 	}
 }
@@ -138,6 +156,38 @@ func (p *peerConnection) Reset() {
 	p.stateThroughput = 0
 
 	p.lacking = make(map[common.Hash]struct{})
+	p.requests = [requestKinds]uint64{}
+}
+
+// newRequest picks the ID of a new request of the given kind, replacing the one
+// in flight as the ID the next delivery must carry.
+func (p *peerConnection) newRequest(kind int) uint64 {
+	id := rand.Uint64()
+	for id == 0 {
+		id = rand.Uint64()
+	}
+	p.lock.Lock()
+	p.requests[kind] = id
+	p.lock.Unlock()
+
+	return id
+}
+
+// resolveRequest checks whmxter a delivery of the given kind answers the request
+// in flight, retiring it if so. Peers below mxt/66 don't tag their responses, so
+// all their deliveries are accepted.
+func (p *peerConnection) resolveRequest(kind int, id uint64) bool {
+	if p.version < 66 {
+		return true
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if id == 0 || p.requests[kind] != id {
+		return false
+	}
+	p.requests[kind] = 0
+	return true
 }
 
 // FetchHeaders sends a header retrieval request to the remote peer.
@@ -146,10 +196,10 @@ func (p *peerConnection) FetchHeaders(from uint64, count int) error {
 	if !atomic.CompareAndSwapInt32(&p.headerIdle, 0, 1) {
 		return errAlreadyFetching
 	}
-	p.headerStarted = time.Now()
+	p.headerStarted = p.clock.Now()
 
 	// Issue the header retrieval request (absolute upwards without gaps)
-	go p.peer.RequestHeadersByNumber(from, count, 0, false)
+	go p.peer.RequestHeadersByNumber(p.newRequest(headerRequest), from, count, 0, false)
 
 	return nil
 }
@@ -160,15 +210,16 @@ func (p *peerConnection) FetchBodies(request *fetchRequest) error {
 	if !atomic.CompareAndSwapInt32(&p.blockIdle, 0, 1) {
 		return errAlreadyFetching
 	}
-	p.blockStarted = time.Now()
+	p.blockStarted = p.clock.Now()
 
+	id := p.newRequest(bodyRequest)
 	go func() {
 		// Convert the header set to a retrievable slice
 		hashes := make([]common.Hash, 0, len(request.Headers))
 		for _, header := range request.Headers {
 			hashes = append(hashes, header.Hash())
 		}
-		p.peer.RequestBodies(hashes)
+		p.peer.RequestBodies(id, hashes)
 	}()
 
 	return nil
@@ -180,15 +231,16 @@ func (p *peerConnection) FetchReceipts(request *fetchRequest) error {
 	if !atomic.CompareAndSwapInt32(&p.receiptIdle, 0, 1) {
 		return errAlreadyFetching
 	}
-	p.receiptStarted = time.Now()
+	p.receiptStarted = p.clock.Now()
 
+	id := p.newRequest(receiptRequest)
 	go func() {
 		// Convert the header set to a retrievable slice
 		hashes := make([]common.Hash, 0, len(request.Headers))
 		for _, header := range request.Headers {
 			hashes = append(hashes, header.Hash())
 		}
-		p.peer.RequestReceipts(hashes)
+		p.peer.RequestReceipts(id, hashes)
 	}()
 
 	return nil
@@ -200,9 +252,9 @@ func (p *peerConnection) FetchNodeData(hashes []common.Hash) error {
 	if !atomic.CompareAndSwapInt32(&p.stateIdle, 0, 1) {
 		return errAlreadyFetching
 	}
-	p.stateStarted = time.Now()
+	p.stateStarted = p.clock.Now()
 
-	go p.peer.RequestNodeData(hashes)
+	go p.peer.RequestNodeData(p.newRequest(stateRequest), hashes)
 
 	return nil
 }
@@ -210,28 +262,28 @@ func (p *peerConnection) FetchNodeData(hashes []common.Hash) error {
 // SetHeadersIdle sets the peer to idle, allowing it to execute new header retrieval
 // requests. Its estimated header retrieval throughput is updated with that measured
 // just now.
-func (p *peerConnection) SetHeadersIdle(delivered int, deliveryTime time.Time) {
+func (p *peerConnection) SetHeadersIdle(delivered int, deliveryTime mclock.AbsTime) {
 	p.setIdle(deliveryTime.Sub(p.headerStarted), delivered, &p.headerThroughput, &p.headerIdle)
 }
 
 // SetBodiesIdle sets the peer to idle, allowing it to execute block body retrieval
 // requests. Its estimated body retrieval throughput is updated with that measured
 // just now.
-func (p *peerConnection) SetBodiesIdle(delivered int, deliveryTime time.Time) {
+func (p *peerConnection) SetBodiesIdle(delivered int, deliveryTime mclock.AbsTime) {
 	p.setIdle(deliveryTime.Sub(p.blockStarted), delivered, &p.blockThroughput, &p.blockIdle)
 }
 
 // SetReceiptsIdle sets the peer to idle, allowing it to execute new receipt
 // retrieval requests. Its estimated receipt retrieval throughput is updated
 // with that measured just now.
-func (p *peerConnection) SetReceiptsIdle(delivered int, deliveryTime time.Time) {
+func (p *peerConnection) SetReceiptsIdle(delivered int, deliveryTime mclock.AbsTime) {
 	p.setIdle(deliveryTime.Sub(p.receiptStarted), delivered, &p.receiptThroughput, &p.receiptIdle)
 }
 
 // SetNodeDataIdle sets the peer to idle, allowing it to execute new state trie
 // data retrieval requests. Its estimated state retrieval throughput is updated
 // with that measured just now.
-func (p *peerConnection) SetNodeDataIdle(delivered int, deliveryTime time.Time) {
+func (p *peerConnection) SetNodeDataIdle(delivered int, deliveryTime mclock.AbsTime) {
 	p.setIdle(deliveryTime.Sub(p.stateStarted), delivered, &p.stateThroughput, &p.stateIdle)
 }
 
@@ -457,7 +509,7 @@ func (ps *peerSet) HeaderIdlePeers() ([]*peerConnection, int) {
 		defer p.lock.RUnlock()
 		return p.headerThroughput
 	}
-	return ps.idlePeers(63, 65, idle, throughput)
+	return ps.idlePeers(63, 66, idle, throughput)
 }
 
 // BodyIdlePeers retrieves a flat list of all the currently body-idle peers within
@@ -471,7 +523,7 @@ func (ps *peerSet) BodyIdlePeers() ([]*peerConnection, int) {
 		defer p.lock.RUnlock()
 		return p.blockThroughput
 	}
-	return ps.idlePeers(63, 65, idle, throughput)
+	return ps.idlePeers(63, 66, idle, throughput)
 }
 
 // ReceiptIdlePeers retrieves a flat list of all the currently receipt-idle peers
@@ -485,7 +537,7 @@ func (ps *peerSet) ReceiptIdlePeers() ([]*peerConnection, int) {
 		defer p.lock.RUnlock()
 		return p.receiptThroughput
 	}
-	return ps.idlePeers(63, 65, idle, throughput)
+	return ps.idlePeers(63, 66, idle, throughput)
 }
 
 // NodeDataIdlePeers retrieves a flat list of all the currently node-data-idle
@@ -499,7 +551,7 @@ func (ps *peerSet) NodeDataIdlePeers() ([]*peerConnection, int) {
 		defer p.lock.RUnlock()
 		return p.stateThroughput
 	}
-	return ps.idlePeers(63, 65, idle, throughput)
+	return ps.idlePeers(63, 66, idle, throughput)
 }
 
 // idlePeers retrieves a flat list of all currently idle peers satisfying the
@@ -27,6 +27,7 @@ import (
 	"time"
 
 	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/common/mclock"
 	"github.com/mxt/go-mxt/common/prque"
 	"github.com/mxt/go-mxt/core/types"
 	"github.com/mxt/go-mxt/log"
@@ -56,7 +57,7 @@ type fetchRequest struct {
 	Peer    *peerConnection // Peer to which the request was sent
 	From    uint64          // [mxt/62] Requested chain element index (used for skeleton fills only)
 	Headers []*types.Header // [mxt/62] Requested headers, sorted by request order
-	Time    time.Time       // Time when the request was made
+	Time    mclock.AbsTime  // Time when the request was made
 }
 
 // fetchResult is a struct collecting partial results from data fetchers until
@@ -139,13 +140,15 @@ type queue struct {
 	active *sync.Cond
 	closed bool
 
-	lastStatLog time.Time
+	clock       mclock.Clock
+	lastStatLog mclock.AbsTime
 }
 
 // newQueue creates a new download queue for scheduling block retrieval.
-func newQueue(blockCacheLimit int, thresholdInitialSize int) *queue {
+func newQueue(blockCacheLimit int, thresholdInitialSize int, clock mclock.Clock) *queue {
 	lock := new(sync.RWMutex)
 	q := &queue{
+		clock:            clock,
 		headerContCh:     make(chan bool),
 		blockTaskQueue:   prque.New(nil),
 		receiptTaskQueue: prque.New(nil),
@@ -384,8 +387,8 @@ func (q *queue) Results(block bool) []*fetchResult {
 	throttleThreshold = q.resultCache.SetThrottleThreshold(throttleThreshold)
 
 	// Log some info at certain times
-	if time.Since(q.lastStatLog) > 60*time.Second {
-		q.lastStatLog = time.Now()
+	if now := q.clock.Now(); now.Sub(q.lastStatLog) > 60*time.Second {
+		q.lastStatLog = now
 		info := q.Stats()
 		info = append(info, "throttle", throttleThreshold)
 		log.Info("Downloader queue stats", info...)
@@ -442,7 +445,7 @@ func (q *queue) ReserveHeaders(p *peerConnection, count int) *fetchRequest {
 	request := &fetchRequest{
 		Peer: p,
 		From: send,
-		Time: time.Now(),
+		Time: q.clock.Now(),
 	}
 	q.headerPendPool[p.id] = request
 	return request
@@ -560,7 +563,7 @@ func (q *queue) reserveHeaders(p *peerConnection, count int, taskPool map[common
 	request := &fetchRequest{
 		Peer:    p,
 		Headers: send,
-		Time:    time.Now(),
+		Time:    q.clock.Now(),
 	}
 	pendPool[p.id] = request
 	return request, progress, throttled
@@ -658,7 +661,7 @@ func (q *queue) expire(timeout time.Duration, pendPool map[string]*fetchRequest,
 	// Iterate over the expired requests and return each to the queue
 	expiries := make(map[string]int)
 	for id, request := range pendPool {
-		if time.Since(request.Time) > timeout {
+		if q.clock.Now().Sub(request.Time) > timeout {
 			// Update the metrics with the timeout
 			timeoutMeter.Mark(1)
 
@@ -695,7 +698,7 @@ func (q *queue) DeliverHeaders(id string, headers []*types.Header, headerProcCh
 	if request == nil {
 		return 0, errNoFetchesPending
 	}
-	headerReqTimer.UpdateSince(request.Time)
+	headerReqTimer.Update(q.clock.Now().Sub(request.Time))
 	delete(q.headerPendPool, id)
 
 	// Ensure headers can be mapped onto the skeleton chain
@@ -830,7 +833,7 @@ func (q *queue) deliver(id string, taskPool map[common.Hash]*types.Header,
 	if request == nil {
 		return 0, errNoFetchesPending
 	}
-	reqTimer.UpdateSince(request.Time)
+	reqTimer.Update(q.clock.Now().Sub(request.Time))
 	delete(pendPool, id)
 
 	// If no data items were retrieved, mark them as unavailable for the origin peer
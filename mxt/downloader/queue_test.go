@@ -25,6 +25,7 @@ import (
 	"time"
 
 	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/common/mclock"
 	"github.com/mxt/go-mxt/consensus/mxtash"
 	"github.com/mxt/go-mxt/core"
 	"github.com/mxt/go-mxt/core/rawdb"
@@ -97,7 +98,7 @@ func dummyPeer(id string) *peerConnection {
 }
 
 func TestBasics(t *testing.T) {
-	q := newQueue(10, 10)
+	q := newQueue(10, 10, mclock.System{})
 	if !q.Idle() {
 		t.Errorf("new queue should be idle")
 	}
@@ -174,7 +175,7 @@ func TestBasics(t *testing.T) {
 }
 
 func TestEmptyBlocks(t *testing.T) {
-	q := newQueue(10, 10)
+	q := newQueue(10, 10, mclock.System{})
 
 	q.Prepare(1, FastSync)
 	// Schedule a batch of headers
@@ -244,7 +245,7 @@ func XTestDelivery(t *testing.T) {
 		log.Root().SetHandler(log.StdoutHandler)
 
 	}
-	q := newQueue(10, 10)
+	q := newQueue(10, 10, mclock.System{})
 	var wg sync.WaitGroup
 	q.Prepare(1, FastSync)
 	wg.Add(1)
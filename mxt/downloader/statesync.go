@@ -23,6 +23,7 @@ import (
 	"time"
 
 	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/common/mclock"
 	"github.com/mxt/go-mxt/core/rawdb"
 	"github.com/mxt/go-mxt/core/state"
 	"github.com/mxt/go-mxt/mxtdb"
@@ -38,9 +39,9 @@ type stateReq struct {
 	trieTasks map[common.Hash]*trieTask // Trie node download tasks to track previous attempts
 	codeTasks map[common.Hash]*codeTask // Byte code download tasks to track previous attempts
 	timeout   time.Duration             // Maximum round trip time for this to complete
-	timer     *time.Timer               // Timer to fire when the RTT timeout expires
+	timer     mclock.Timer              // Timer to fire when the RTT timeout expires
 	peer      *peerConnection           // Peer that we're requesting from
-	delivered time.Time                 // Time when the packet was delivered (independent when we process it)
+	delivered mclock.AbsTime            // Time when the packet was delivered (independent when we process it)
 	response  [][]byte                  // Response data of the peer (nil for timeouts)
 	dropped   bool                      // Flag whmxter the peer dropped off early
 }
@@ -150,7 +151,7 @@ func (d *Downloader) runStateSync(s *stateSync) *stateSync {
 			// Finalize the request and queue up for processing
 			req.timer.Stop()
 			req.response = pack.(*statePack).states
-			req.delivered = time.Now()
+			req.delivered = d.clock.Now()
 
 			finished = append(finished, req)
 			delete(active, pack.PeerId())
@@ -165,7 +166,7 @@ func (d *Downloader) runStateSync(s *stateSync) *stateSync {
 			// Finalize the request and queue up for processing
 			req.timer.Stop()
 			req.dropped = true
-			req.delivered = time.Now()
+			req.delivered = d.clock.Now()
 
 			finished = append(finished, req)
 			delete(active, p.id)
@@ -178,7 +179,7 @@ func (d *Downloader) runStateSync(s *stateSync) *stateSync {
 			if active[req.peer.id] != req {
 				continue
 			}
-			req.delivered = time.Now()
+			req.delivered = d.clock.Now()
 			// Move the timed out data back into the download queue
 			finished = append(finished, req)
 			delete(active, req.peer.id)
@@ -196,12 +197,14 @@ func (d *Downloader) runStateSync(s *stateSync) *stateSync {
 				// Move the previous request to the finished set
 				old.timer.Stop()
 				old.dropped = true
-				old.delivered = time.Now()
+				old.delivered = d.clock.Now()
 				finished = append(finished, old)
 			}
 			// Start a timer to notify the sync loop if the peer stalled.
-			req.timer = time.AfterFunc(req.timeout, func() {
-				timeout <- req
+			// Simulated clocks run the callback on the goroutine advancing the clock,
+			// so the send must not block it.
+			req.timer = d.clock.AfterFunc(req.timeout, func() {
+				go func() { timeout <- req }()
 			})
 			active[req.peer.id] = req
 		}
@@ -237,13 +240,13 @@ func (d *Downloader) spindownStateSync(active map[string]*stateReq, finished []*
 		req.peer.log.Trace("State peer marked idle (spindown)", "req.items", int(req.nItems), "reason", reason)
 		req.timer.Stop()
 		delete(active, req.peer.id)
-		req.peer.SetNodeDataIdle(int(req.nItems), time.Now())
+		req.peer.SetNodeDataIdle(int(req.nItems), d.clock.Now())
 	}
 	// The 'finished' set contains deliveries that we were going to pass to processing.
 	// Those are now moot, but we still need to set those peers as idle, which would
 	// otherwise have been done after processing
 	for _, req := range finished {
-		req.peer.SetNodeDataIdle(int(req.nItems), time.Now())
+		req.peer.SetNodeDataIdle(int(req.nItems), d.clock.Now())
 	}
 }
 
@@ -399,7 +402,7 @@ func (s *stateSync) commit(force bool) error {
 	if !force && s.bytesUncommitted < mxtdb.IdealBatchSize {
 		return nil
 	}
-	start := time.Now()
+	start := s.d.clock.Now()
 	b := s.d.stateDB.NewBatch()
 	if err := s.sched.Commit(b); err != nil {
 		return err
@@ -407,7 +410,7 @@ func (s *stateSync) commit(force bool) error {
 	if err := b.Write(); err != nil {
 		return fmt.Errorf("DB write error: %v", err)
 	}
-	s.updateStats(s.numUncommitted, 0, 0, time.Since(start))
+	s.updateStats(s.numUncommitted, 0, 0, s.d.clock.Now().Sub(start))
 	s.numUncommitted = 0
 	s.bytesUncommitted = 0
 	return nil
@@ -510,11 +513,11 @@ func (s *stateSync) process(req *stateReq) (int, error) {
 	// Collect processing stats and update progress if valid data was received
 	duplicate, unexpected, successful := 0, 0, 0
 
-	defer func(start time.Time) {
+	defer func(start mclock.AbsTime) {
 		if duplicate > 0 || unexpected > 0 {
-			s.updateStats(0, duplicate, unexpected, time.Since(start))
+			s.updateStats(0, duplicate, unexpected, s.d.clock.Now().Sub(start))
 		}
-	}(time.Now())
+	}(s.d.clock.Now())
 
 	// Iterate over all the delivered data and inject one-by-one into the trie
 	for _, blob := range req.response {
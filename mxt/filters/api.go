@@ -31,6 +31,7 @@ import (
 	"github.com/mxt/go-mxt/core/types"
 	"github.com/mxt/go-mxt/mxtdb"
 	"github.com/mxt/go-mxt/event"
+	"github.com/mxt/go-mxt/log"
 	"github.com/mxt/go-mxt/rpc"
 )
 
@@ -233,6 +234,86 @@ func (api *PublicFilterAPI) NewHeads(ctx context.Context) (*rpc.Subscription, er
 	return rpcSub, nil
 }
 
+// NewBlocks creates a subscription that sends the full block and its receipts
+// each time a block becomes canonical and has been buried under the requested
+// number of confirmations (zero if omitted). If a reorg drops a block that has
+// already been sent, it is sent again with the removed flag set, newest first,
+// before any of the replacing blocks.
+func (api *PublicFilterAPI) NewBlocks(ctx context.Context, confirmations *hexutil.Uint64) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+	var depth uint64
+	if confirmations != nil {
+		depth = uint64(*confirmations)
+	}
+	rpcSub := notifier.CreateSubscription()
+
+	go func() {
+		var (
+			headers   = make(chan *types.Header)
+			sides     = make(chan *types.Header)
+			headsSub  = api.events.SubscribeNewHeads(headers)
+			sidesSub  = api.events.SubscribeSideHeads(sides)
+			tracker   = newBlockTracker(api.backend, depth)
+			notifyAll = func(headers []*types.Header, removed bool) {
+				for _, header := range headers {
+					ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+					notification, err := blockNotification(ctx, api.backend, header, removed)
+					cancel()
+					if err != nil {
+						log.Warn("Failed to assemble block notification", "number", header.Number, "hash", header.Hash(), "err", err)
+						continue
+					}
+					notifier.Notify(rpcSub.ID, notification)
+				}
+			}
+		)
+		defer func() {
+			// Both subscriptions are fed by the same event loop, keep draining
+			// the side channel while tearing them down to avoid a deadlock.
+			done := make(chan struct{})
+			go func() {
+				headsSub.Unsubscribe()
+				sidesSub.Unsubscribe()
+				close(done)
+			}()
+			for {
+				select {
+				case <-headers:
+				case <-sides:
+				case <-done:
+					return
+				}
+			}
+		}()
+
+		for {
+			select {
+			case h := <-headers:
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				removed, added, err := tracker.newHead(ctx, h)
+				cancel()
+				if err != nil {
+					log.Warn("Failed to track new chain head", "number", h.Number, "hash", h.Hash(), "err", err)
+					continue
+				}
+				notifyAll(removed, true)
+				notifyAll(added, false)
+			case h := <-sides:
+				notifyAll(tracker.sideHead(h), true)
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+
+	return rpcSub, nil
+}
+
 // Logs creates a subscription that fires for all new log that match the given filter criteria.
 func (api *PublicFilterAPI) Logs(ctx context.Context, crit FilterCriteria) (*rpc.Subscription, error) {
 	notifier, supported := rpc.NotifierFromContext(ctx)
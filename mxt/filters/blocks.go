@@ -0,0 +1,185 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package filters
+
+import (
+	"context"
+	"errors"
+
+	"github.com/mxt/go-mxt/core/types"
+	"github.com/mxt/go-mxt/internal/mxtapi"
+	"github.com/mxt/go-mxt/rpc"
+)
+
+// blockTrackerLimit is the maximum number of delivered blocks a block tracker
+// remembers. Reorgs deeper than this (on top of the confirmation depth) can't
+// be reported as removals anymore, and gaps larger than this are not backfilled.
+const blockTrackerLimit = 256
+
+var errUnknownAncestor = errors.New("unknown ancestor")
+
+// BlockNotification is the payload of a newBlocks subscription. Blocks that are
+// dropped from the canonical chain after having been delivered are sent again
+// with the removed flag set.
+type BlockNotification struct {
+	Block    map[string]interface{} `json:"block"`
+	Receipts types.Receipts         `json:"receipts"`
+	Removed  bool                   `json:"removed"`
+}
+
+// blockTracker keeps track of the canonical blocks delivered to a single
+// newBlocks subscriber, so that reorgs can be translated into an explicit
+// list of removed and added blocks. Blocks are only delivered once they are
+// buried under the configured number of confirmations.
+type blockTracker struct {
+	backend   Backend
+	depth     uint64
+	delivered []*types.Header // Delivered canonical headers, oldest first
+}
+
+// newBlockTracker creates a block tracker that delivers blocks after depth
+// confirmations.
+func newBlockTracker(backend Backend, depth uint64) *blockTracker {
+	return &blockTracker{backend: backend, depth: depth}
+}
+
+// newHead processes a new chain head and returns the previously delivered
+// headers that are no longer canonical (newest first) and the headers that
+// became confirmed (oldest first).
+func (t *blockTracker) newHead(ctx context.Context, head *types.Header) (removed, added []*types.Header, err error) {
+	if head.Number.Uint64() < t.depth {
+		return nil, nil, nil
+	}
+	// Resolve the header buried under the requested number of confirmations
+	target := head
+	for i := uint64(0); i < t.depth; i++ {
+		if target, err = t.parent(ctx, target); err != nil {
+			return nil, nil, err
+		}
+	}
+	// Drop all delivered headers that are above the new confirmed one
+	for len(t.delivered) > 0 && t.last().Number.Cmp(target.Number) > 0 {
+		removed = append(removed, t.pop())
+	}
+	// Walk the new chain backwards until it joins the delivered one
+	cur := target
+	for len(t.delivered) > 0 {
+		last := t.last()
+		if cur.Number.Cmp(last.Number) == 0 && cur.Hash() == last.Hash() {
+			break
+		}
+		if cur.Number.Cmp(last.Number) == 0 {
+			removed = append(removed, t.pop())
+		}
+		if len(added) >= blockTrackerLimit {
+			// The gap is too large to backfill, start over from the target. The
+			// delivered blocks no longer canonical are still reported as removed.
+			for len(t.delivered) > 0 {
+				last := t.last()
+				canon, err := t.backend.HeaderByNumber(ctx, rpc.BlockNumber(last.Number.Int64()))
+				if err != nil {
+					return nil, nil, err
+				}
+				if canon != nil && canon.Hash() == last.Hash() {
+					break
+				}
+				removed = append(removed, t.pop())
+			}
+			added = nil
+			t.delivered = t.delivered[:0]
+			break
+		}
+		added = append(added, cur)
+		if cur, err = t.parent(ctx, cur); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(t.delivered) == 0 && len(added) == 0 {
+		added = append(added, target)
+	}
+	// Reverse the added headers into ascending order and remember them
+	for i, j := 0, len(added)-1; i < j; i, j = i+1, j-1 {
+		added[i], added[j] = added[j], added[i]
+	}
+	t.delivered = append(t.delivered, added...)
+	if overflow := len(t.delivered) - blockTrackerLimit; overflow > 0 {
+		t.delivered = append(t.delivered[:0], t.delivered[overflow:]...)
+	}
+	return removed, added, nil
+}
+
+// sideHead processes a block that was moved out of the canonical chain. If the
+// block was already delivered, it and all its delivered descendants are returned
+// as removed (newest first).
+func (t *blockTracker) sideHead(header *types.Header) (removed []*types.Header) {
+	hash := header.Hash()
+	for i, h := range t.delivered {
+		if h.Hash() != hash {
+			continue
+		}
+		for len(t.delivered) > i {
+			removed = append(removed, t.pop())
+		}
+		break
+	}
+	return removed
+}
+
+// last returns the most recently delivered header.
+func (t *blockTracker) last() *types.Header {
+	return t.delivered[len(t.delivered)-1]
+}
+
+// pop removes and returns the most recently delivered header.
+func (t *blockTracker) pop() *types.Header {
+	last := t.delivered[len(t.delivered)-1]
+	t.delivered = t.delivered[:len(t.delivered)-1]
+	return last
+}
+
+// parent retrieves the parent header of the given one.
+func (t *blockTracker) parent(ctx context.Context, header *types.Header) (*types.Header, error) {
+	parent, err := t.backend.HeaderByHash(ctx, header.ParentHash)
+	if err != nil {
+		return nil, err
+	}
+	if parent == nil {
+		return nil, errUnknownAncestor
+	}
+	return parent, nil
+}
+
+// blockNotification assembles the full block and receipts notification for
+// the given header.
+func blockNotification(ctx context.Context, backend Backend, header *types.Header, removed bool) (*BlockNotification, error) {
+	block, err := backend.BlockByHash(ctx, header.Hash())
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, errUnknownAncestor
+	}
+	receipts, err := backend.GetReceipts(ctx, header.Hash())
+	if err != nil {
+		return nil, err
+	}
+	fields, err := mxtapi.RPCMarshalBlock(block, true, true)
+	if err != nil {
+		return nil, err
+	}
+	return &BlockNotification{Block: fields, Receipts: receipts, Removed: removed}, nil
+}
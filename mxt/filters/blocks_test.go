@@ -0,0 +1,151 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package filters
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/consensus/mxtash"
+	"github.com/mxt/go-mxt/core"
+	"github.com/mxt/go-mxt/core/rawdb"
+	"github.com/mxt/go-mxt/core/types"
+	"github.com/mxt/go-mxt/params"
+)
+
+// newTrackerTestChain creates a canonical chain of 10 blocks and a competing
+// fork of 7 blocks branching off at block 5, storing all of them in the database.
+func newTrackerTestChain() (*testBackend, *types.Block, []*types.Block, []*types.Block) {
+	var (
+		db        = rawdb.NewMemoryDatabase()
+		backend   = &testBackend{db: db}
+		genesis   = new(core.Genesis).MustCommit(db)
+		canon, _  = core.GenerateChain(params.TestChainConfig, genesis, mxtash.NewFaker(), db, 10, func(i int, gen *core.BlockGen) {})
+		forked, _ = core.GenerateChain(params.TestChainConfig, canon[4], mxtash.NewFaker(), db, 7, func(i int, gen *core.BlockGen) {
+			gen.SetCoinbase(common.Address{0x01})
+		})
+	)
+	for _, block := range append(append([]*types.Block{}, canon...), forked...) {
+		rawdb.WriteBlock(db, block)
+	}
+	for _, block := range canon {
+		rawdb.WriteCanonicalHash(db, block.Hash(), block.NumberU64())
+	}
+	return backend, genesis, canon, forked
+}
+
+func checkHeaders(t *testing.T, kind string, have []*types.Header, want []*types.Block) {
+	t.Helper()
+
+	if len(have) != len(want) {
+		t.Fatalf("%s headers count mismatch: have %d, want %d", kind, len(have), len(want))
+	}
+	for i := range have {
+		if have[i].Hash() != want[i].Hash() {
+			t.Errorf("%s header %d mismatch: have #%d [%x], want #%d [%x]", kind, i, have[i].Number, have[i].Hash(), want[i].Number(), want[i].Hash())
+		}
+	}
+}
+
+// Tests that the block tracker only delivers blocks after the configured number
+// of confirmations and reports reorged blocks as removed.
+func TestBlockTrackerReorg(t *testing.T) {
+	backend, genesis, canon, forked := newTrackerTestChain()
+	tracker := newBlockTracker(backend, 2)
+
+	// The first head is too shallow to confirm anything
+	removed, added, err := tracker.newHead(context.Background(), canon[0].Header())
+	if err != nil {
+		t.Fatalf("failed to process head: %v", err)
+	}
+	checkHeaders(t, "removed", removed, nil)
+	checkHeaders(t, "added", added, nil)
+
+	// Each subsequent head confirms exactly one block
+	for i := 1; i < len(canon); i++ {
+		removed, added, err = tracker.newHead(context.Background(), canon[i].Header())
+		if err != nil {
+			t.Fatalf("failed to process head %d: %v", i, err)
+		}
+		want := genesis
+		if i > 1 {
+			want = canon[i-2]
+		}
+		checkHeaders(t, "removed", removed, nil)
+		checkHeaders(t, "added", added, []*types.Block{want})
+	}
+	// Switch over to the fork, the delivered blocks 6-8 must be removed
+	removed, added, err = tracker.newHead(context.Background(), forked[len(forked)-1].Header())
+	if err != nil {
+		t.Fatalf("failed to process fork head: %v", err)
+	}
+	checkHeaders(t, "removed", removed, []*types.Block{canon[7], canon[6], canon[5]})
+	checkHeaders(t, "added", added, forked[:5])
+}
+
+// Tests that side chain events remove delivered blocks and their descendants.
+func TestBlockTrackerSideHead(t *testing.T) {
+	backend, _, canon, _ := newTrackerTestChain()
+	tracker := newBlockTracker(backend, 0)
+
+	for i := 0; i < 8; i++ {
+		if _, _, err := tracker.newHead(context.Background(), canon[i].Header()); err != nil {
+			t.Fatalf("failed to process head %d: %v", i, err)
+		}
+	}
+	// Unknown side blocks are ignored
+	if removed := tracker.sideHead(canon[9].Header()); len(removed) != 0 {
+		t.Fatalf("undelivered side block removed %d blocks", len(removed))
+	}
+	checkHeaders(t, "removed", tracker.sideHead(canon[5].Header()), []*types.Block{canon[7], canon[6], canon[5]})
+
+	// The next head must rejoin the chain at the last remaining block
+	_, added, err := tracker.newHead(context.Background(), canon[9].Header())
+	if err != nil {
+		t.Fatalf("failed to process head: %v", err)
+	}
+	checkHeaders(t, "added", added, canon[5:10])
+}
+
+// Tests that blocks found to be reorged are reported as removed even if the new
+// chain is too long to be backfilled.
+func TestBlockTrackerDeepReorg(t *testing.T) {
+	backend, _, canon, _ := newTrackerTestChain()
+	tracker := newBlockTracker(backend, 0)
+
+	for i := 0; i < len(canon); i++ {
+		if _, _, err := tracker.newHead(context.Background(), canon[i].Header()); err != nil {
+			t.Fatalf("failed to process head %d: %v", i, err)
+		}
+	}
+	// Create a fork which reaches below the delivered head within the tracker
+	// limit, but joins the delivered chain beyond it.
+	forked, _ := core.GenerateChain(params.TestChainConfig, canon[4], mxtash.NewFaker(), backend.db, blockTrackerLimit+2, func(i int, gen *core.BlockGen) {
+		gen.SetCoinbase(common.Address{0x02})
+	})
+	for _, block := range forked {
+		rawdb.WriteBlock(backend.db, block)
+		rawdb.WriteCanonicalHash(backend.db, block.Hash(), block.NumberU64())
+	}
+	removed, added, err := tracker.newHead(context.Background(), forked[len(forked)-1].Header())
+	if err != nil {
+		t.Fatalf("failed to process fork head: %v", err)
+	}
+	checkHeaders(t, "removed", removed, []*types.Block{canon[9], canon[8], canon[7], canon[6], canon[5]})
+	checkHeaders(t, "added", added, forked[len(forked)-1:])
+}
@@ -19,11 +19,14 @@ package filters
 import (
 	"context"
 	"errors"
+	"fmt"
 	"math/big"
+	"sort"
 
 	"github.com/mxt/go-mxt/common"
 	"github.com/mxt/go-mxt/core"
 	"github.com/mxt/go-mxt/core/bloombits"
+	"github.com/mxt/go-mxt/core/rawdb"
 	"github.com/mxt/go-mxt/core/types"
 	"github.com/mxt/go-mxt/mxtdb"
 	"github.com/mxt/go-mxt/event"
@@ -34,11 +37,13 @@ type Backend interface {
 	ChainDb() mxtdb.Database
 	HeaderByNumber(ctx context.Context, blockNr rpc.BlockNumber) (*types.Header, error)
 	HeaderByHash(ctx context.Context, blockHash common.Hash) (*types.Header, error)
+	BlockByHash(ctx context.Context, blockHash common.Hash) (*types.Block, error)
 	GetReceipts(ctx context.Context, blockHash common.Hash) (types.Receipts, error)
 	GetLogs(ctx context.Context, blockHash common.Hash) ([][]*types.Log, error)
 
 	SubscribeNewTxsEvent(chan<- core.NewTxsEvent) event.Subscription
 	SubscribeChainEvent(ch chan<- core.ChainEvent) event.Subscription
+	SubscribeChainSideEvent(ch chan<- core.ChainSideEvent) event.Subscription
 	SubscribeRemovedLogsEvent(ch chan<- core.RemovedLogsEvent) event.Subscription
 	SubscribeLogsEvent(ch chan<- []*types.Log) event.Subscription
 	SubscribePendingLogsEvent(ch chan<- []*types.Log) event.Subscription
@@ -47,6 +52,15 @@ type Backend interface {
 	ServiceFilter(ctx context.Context, session *bloombits.MatcherSession)
 }
 
+// LogIndexBackend is an optional extension of Backend, implemented by nodes
+// maintaining an exact log index by address and topic. Filters use it to only
+// look at the blocks containing matching logs.
+type LogIndexBackend interface {
+	// LogIndexStatus returns the section size of the log index and the number
+	// of sections indexed.
+	LogIndexStatus() (uint64, uint64)
+}
+
 // Filter can be used to retrieve and filter logs.
 type Filter struct {
 	backend Backend
@@ -135,25 +149,49 @@ func (f *Filter) Logs(ctx context.Context) ([]*types.Log, error) {
 	}
 	head := header.Number.Uint64()
 
+	// Resolve the finalized and safe block tags into the blocks they refer to
+	begin, err := f.resolveTag(ctx, f.begin)
+	if err != nil {
+		return nil, err
+	}
+	f.begin = begin
+
+	last, err := f.resolveTag(ctx, f.end)
+	if err != nil {
+		return nil, err
+	}
 	if f.begin == -1 {
 		f.begin = int64(head)
 	}
-	end := uint64(f.end)
-	if f.end == -1 {
+	end := uint64(last)
+	if last == -1 {
 		end = head
 	}
-	// Gather all indexed logs, and finish with non indexed ones
-	var (
-		logs []*types.Log
-		err  error
-	)
+	// Gather all exactly indexed logs, then the bloom indexed ones, and finish
+	// with non indexed ones
+	var logs []*types.Log
+	if backend, ok := f.backend.(LogIndexBackend); ok && f.selective() {
+		size, sections := backend.LogIndexStatus()
+		if indexed := sections * size; indexed > uint64(f.begin) {
+			if indexed > end {
+				logs, err = f.exactLogs(ctx, size, end)
+			} else {
+				logs, err = f.exactLogs(ctx, size, indexed-1)
+			}
+			if err != nil {
+				return logs, err
+			}
+		}
+	}
 	size, sections := f.backend.BloomStatus()
-	if indexed := sections * size; indexed > uint64(f.begin) {
+	if indexed := sections * size; indexed > uint64(f.begin) && uint64(f.begin) <= end {
+		var found []*types.Log
 		if indexed > end {
-			logs, err = f.indexedLogs(ctx, end)
+			found, err = f.indexedLogs(ctx, end)
 		} else {
-			logs, err = f.indexedLogs(ctx, indexed-1)
+			found, err = f.indexedLogs(ctx, indexed-1)
 		}
+		logs = append(logs, found...)
 		if err != nil {
 			return logs, err
 		}
@@ -163,6 +201,120 @@ func (f *Filter) Logs(ctx context.Context) ([]*types.Log, error) {
 	return logs, err
 }
 
+// selective returns whmxter the filter constrains the address or any topic, so
+// the exact log index can narrow down the matching blocks.
+func (f *Filter) selective() bool {
+	if len(f.addresses) > 0 {
+		return true
+	}
+	for _, sub := range f.topics {
+		if len(sub) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// exactLogs returns the logs matching the filter criteria based on the exact log
+// index. It stops at the first section not indexed on the current canonical
+// chain, leaving the rest to the bloom filters.
+func (f *Filter) exactLogs(ctx context.Context, size uint64, end uint64) ([]*types.Log, error) {
+	var logs []*types.Log
+
+	for uint64(f.begin) <= end {
+		section := uint64(f.begin) / size
+		head := rawdb.ReadCanonicalHash(f.db, (section+1)*size-1)
+		if !rawdb.HasLogIndexSection(f.db, section, head) {
+			return logs, nil
+		}
+		last := (section+1)*size - 1
+		if last > end {
+			last = end
+		}
+		for _, number := range f.exactMatches(section, head, uint64(f.begin), last) {
+			if err := ctx.Err(); err != nil {
+				return logs, err
+			}
+			header, err := f.backend.HeaderByNumber(ctx, rpc.BlockNumber(number))
+			if header == nil || err != nil {
+				return logs, err
+			}
+			found, err := f.checkMatches(ctx, header)
+			if err != nil {
+				return logs, err
+			}
+			logs = append(logs, found...)
+			f.begin = int64(number) + 1
+		}
+		f.begin = int64(last) + 1
+	}
+	return logs, nil
+}
+
+// exactMatches returns the numbers of the blocks within [from, to] of an indexed
+// section that contain logs matching all the constrained filter criteria.
+func (f *Filter) exactMatches(section uint64, head common.Hash, from, to uint64) []uint64 {
+	var matches map[rawdb.LogPosition]struct{} // nil until the first criterion is applied
+
+	intersect := func(terms [][]byte) {
+		found := make(map[rawdb.LogPosition]struct{})
+		for _, term := range terms {
+			for _, pos := range rawdb.ReadLogIndex(f.db, term, section, head) {
+				if pos.Number < from || pos.Number > to {
+					continue
+				}
+				if _, ok := matches[pos]; matches == nil || ok {
+					found[pos] = struct{}{}
+				}
+			}
+		}
+		matches = found
+	}
+	if len(f.addresses) > 0 {
+		terms := make([][]byte, len(f.addresses))
+		for i, address := range f.addresses {
+			terms[i] = rawdb.LogAddressTerm(address)
+		}
+		intersect(terms)
+	}
+	for i, sub := range f.topics {
+		if len(sub) == 0 {
+			continue // wildcard
+		}
+		terms := make([][]byte, len(sub))
+		for j, topic := range sub {
+			terms[j] = rawdb.LogTopicTerm(i, topic)
+		}
+		intersect(terms)
+	}
+	seen := make(map[uint64]struct{})
+	numbers := make([]uint64, 0, len(matches))
+	for pos := range matches {
+		if _, ok := seen[pos.Number]; !ok {
+			seen[pos.Number] = struct{}{}
+			numbers = append(numbers, pos.Number)
+		}
+	}
+	sort.Slice(numbers, func(i, j int) bool { return numbers[i] < numbers[j] })
+	return numbers
+}
+
+// resolveTag converts the finalized and safe block tags into the number of the
+// block they currently refer to, leaving any other block number untouched.
+func (f *Filter) resolveTag(ctx context.Context, number int64) (int64, error) {
+	if number != rpc.FinalizedBlockNumber.Int64() && number != rpc.SafeBlockNumber.Int64() {
+		return number, nil
+	}
+	header, err := f.backend.HeaderByNumber(ctx, rpc.BlockNumber(number))
+	if err != nil {
+		return 0, err
+	}
+	if header == nil {
+		return 0, fmt.Errorf("%v block not found", rpc.BlockNumber(number))
+	}
+	return header.Number.Int64(), nil
+}
+
 // indexedLogs returns the logs matching the filter criteria based on the bloom
 // bits indexed available locally or via the network.
 func (f *Filter) indexedLogs(ctx context.Context, end uint64) ([]*types.Log, error) {
@@ -47,6 +47,8 @@ type testBackend struct {
 	rmLogsFeed      event.Feed
 	pendingLogsFeed event.Feed
 	chainFeed       event.Feed
+	chainSideFeed   event.Feed
+	confirmations   uint64 // Depth of the finalized and safe blocks below the head
 }
 
 func (b *testBackend) ChainDb() mxtdb.Database {
@@ -58,14 +60,22 @@ func (b *testBackend) HeaderByNumber(ctx context.Context, blockNr rpc.BlockNumbe
 		hash common.Hash
 		num  uint64
 	)
-	if blockNr == rpc.LatestBlockNumber {
+	switch blockNr {
+	case rpc.LatestBlockNumber:
 		hash = rawdb.ReadHeadBlockHash(b.db)
 		number := rawdb.ReadHeaderNumber(b.db, hash)
 		if number == nil {
 			return nil, nil
 		}
 		num = *number
-	} else {
+	case rpc.FinalizedBlockNumber, rpc.SafeBlockNumber:
+		number := rawdb.ReadHeaderNumber(b.db, rawdb.ReadHeadBlockHash(b.db))
+		if number == nil || *number < b.confirmations {
+			return nil, nil
+		}
+		num = *number - b.confirmations
+		hash = rawdb.ReadCanonicalHash(b.db, num)
+	default:
 		num = uint64(blockNr)
 		hash = rawdb.ReadCanonicalHash(b.db, num)
 	}
@@ -80,6 +90,14 @@ func (b *testBackend) HeaderByHash(ctx context.Context, hash common.Hash) (*type
 	return rawdb.ReadHeader(b.db, hash, *number), nil
 }
 
+func (b *testBackend) BlockByHash(ctx context.Context, hash common.Hash) (*types.Block, error) {
+	number := rawdb.ReadHeaderNumber(b.db, hash)
+	if number == nil {
+		return nil, nil
+	}
+	return rawdb.ReadBlock(b.db, hash, *number), nil
+}
+
 func (b *testBackend) GetReceipts(ctx context.Context, hash common.Hash) (types.Receipts, error) {
 	if number := rawdb.ReadHeaderNumber(b.db, hash); number != nil {
 		return rawdb.ReadReceipts(b.db, hash, *number, params.TestChainConfig), nil
@@ -121,6 +139,10 @@ func (b *testBackend) SubscribeChainEvent(ch chan<- core.ChainEvent) event.Subsc
 	return b.chainFeed.Subscribe(ch)
 }
 
+func (b *testBackend) SubscribeChainSideEvent(ch chan<- core.ChainSideEvent) event.Subscription {
+	return b.chainSideFeed.Subscribe(ch)
+}
+
 func (b *testBackend) BloomStatus() (uint64, uint64) {
 	return params.BloomBitsBlocks, b.sections
 }
@@ -292,6 +314,10 @@ func TestLogFilterCreation(t *testing.T) {
 			{FilterCriteria{FromBlock: big.NewInt(rpc.PendingBlockNumber.Int64()), ToBlock: big.NewInt(100)}, false},
 			// from block "higher" than to block
 			{FilterCriteria{FromBlock: big.NewInt(rpc.PendingBlockNumber.Int64()), ToBlock: big.NewInt(rpc.LatestBlockNumber.Int64())}, false},
+			// finalized block to new mined blocks
+			{FilterCriteria{FromBlock: big.NewInt(rpc.FinalizedBlockNumber.Int64()), ToBlock: big.NewInt(rpc.LatestBlockNumber.Int64())}, true},
+			// new mined blocks never end at the finalized block
+			{FilterCriteria{FromBlock: big.NewInt(rpc.LatestBlockNumber.Int64()), ToBlock: big.NewInt(rpc.SafeBlockNumber.Int64())}, false},
 		}
 	)
 
@@ -601,6 +627,41 @@ func TestPendingLogsSubscription(t *testing.T) {
 	}
 }
 
+// TestEventSystemStop tests that stopping the event system closes the installed
+// subscriptions and releases the backend subscriptions.
+func TestEventSystemStop(t *testing.T) {
+	t.Parallel()
+
+	var (
+		backend = &testBackend{db: rawdb.NewMemoryDatabase()}
+		es      = NewEventSystem(backend, false)
+		headers = make(chan *types.Header)
+		sub     = es.SubscribeNewHeads(headers)
+	)
+	es.Stop()
+
+	select {
+	case <-sub.Err():
+	case <-time.After(time.Second):
+		t.Fatal("installed subscription not closed")
+	}
+	sub.Unsubscribe()
+
+	// Subscriptions created after stopping are closed right away.
+	late := es.SubscribeNewHeads(headers)
+	select {
+	case <-late.Err():
+	default:
+		t.Fatal("subscription created after stop not closed")
+	}
+	late.Unsubscribe()
+
+	// The backend feeds must not have subscribers left.
+	if n := backend.chainFeed.Send(core.ChainEvent{}); n != 0 {
+		t.Fatalf("backend subscriptions not released, event sent to %d subscribers", n)
+	}
+}
+
 func flattenLogs(pl [][]*types.Log) []*types.Log {
 	var logs []*types.Log
 	for _, l := range pl {
@@ -30,6 +30,7 @@ import (
 	"github.com/mxt/go-mxt/core/types"
 	"github.com/mxt/go-mxt/crypto"
 	"github.com/mxt/go-mxt/params"
+	"github.com/mxt/go-mxt/rpc"
 )
 
 func makeReceipt(addr common.Address) *types.Receipt {
@@ -222,4 +223,112 @@ func TestFilters(t *testing.T) {
 	if len(logs) != 0 {
 		t.Error("expected 0 log, got", len(logs))
 	}
+
+	// The finalized block trails the head, leaving the logs of the last block out
+	backend.confirmations = 1
+	filter = NewRangeFilter(backend, 900, rpc.FinalizedBlockNumber.Int64(), []common.Address{addr}, [][]common.Hash{{hash3, hash4}})
+
+	logs, _ = filter.Logs(context.Background())
+	if len(logs) != 1 {
+		t.Error("expected 1 log, got", len(logs))
+	}
+	if len(logs) > 0 && logs[0].Topics[0] != hash3 {
+		t.Errorf("expected log[0].Topics[0] to be %x, got %x", hash3, logs[0].Topics[0])
+	}
+	filter = NewRangeFilter(backend, rpc.SafeBlockNumber.Int64(), -1, []common.Address{addr}, [][]common.Hash{{hash3, hash4}})
+
+	logs, _ = filter.Logs(context.Background())
+	if len(logs) != 2 {
+		t.Error("expected 2 log, got", len(logs))
+	}
+}
+
+// logIndexTestBackend is a test backend maintaining an exact log index.
+type logIndexTestBackend struct {
+	*testBackend
+	size, sections uint64
+}
+
+func (b *logIndexTestBackend) LogIndexStatus() (uint64, uint64) {
+	return b.size, b.sections
+}
+
+func TestExactLogIndexFilters(t *testing.T) {
+	var (
+		db      = rawdb.NewMemoryDatabase()
+		backend = &logIndexTestBackend{testBackend: &testBackend{db: db}, size: 8, sections: 2}
+		addr1   = common.Address{0x01}
+		addr2   = common.Address{0x02}
+		topicA  = common.BytesToHash([]byte("topicA"))
+		topicB  = common.BytesToHash([]byte("topicB"))
+	)
+	logsAt := map[int][]*types.Log{
+		3:  {{Address: addr1, Topics: []common.Hash{topicA}}},
+		5:  {{Address: addr2, Topics: []common.Hash{topicA}}, {Address: addr1, Topics: []common.Hash{topicB, topicA}}},
+		12: {{Address: addr2, Topics: []common.Hash{topicA}}},
+		18: {{Address: addr1, Topics: []common.Hash{topicA}}},
+	}
+	genesis := core.GenesisBlockForTesting(db, common.Address{}, big.NewInt(1000000))
+	chain, receipts := core.GenerateChain(params.TestChainConfig, genesis, mxtash.NewFaker(), db, 20, func(i int, gen *core.BlockGen) {
+		if logs, ok := logsAt[i+1]; ok {
+			receipt := types.NewReceipt(nil, false, 0)
+			receipt.Logs = logs
+			receipt.Bloom = types.CreateBloom(types.Receipts{receipt})
+			gen.AddUncheckedReceipt(receipt)
+			gen.AddUncheckedTx(types.NewTransaction(uint64(i), common.Address{}, big.NewInt(0), 0, big.NewInt(0), nil))
+		}
+	})
+	rawdb.WriteCanonicalHash(db, genesis.Hash(), 0)
+	for i, block := range chain {
+		rawdb.WriteBlock(db, block)
+		rawdb.WriteCanonicalHash(db, block.Hash(), block.NumberU64())
+		rawdb.WriteHeadBlockHash(db, block.Hash())
+		rawdb.WriteReceipts(db, block.Hash(), block.NumberU64(), receipts[i])
+	}
+	// Index the logs of the first two sections
+	for section := uint64(0); section < backend.sections; section++ {
+		var (
+			head  = rawdb.ReadCanonicalHash(db, (section+1)*backend.size-1)
+			index = make(map[string][]rawdb.LogPosition)
+		)
+		for number := section * backend.size; number < (section+1)*backend.size; number++ {
+			for i, log := range logsAt[int(number)] {
+				pos := rawdb.LogPosition{Number: number, Index: uint32(i)}
+				index[string(rawdb.LogAddressTerm(log.Address))] = append(index[string(rawdb.LogAddressTerm(log.Address))], pos)
+				for j, topic := range log.Topics {
+					term := string(rawdb.LogTopicTerm(j, topic))
+					index[term] = append(index[term], pos)
+				}
+			}
+		}
+		var terms [][]byte
+		for term, positions := range index {
+			rawdb.WriteLogIndex(db, []byte(term), section, head, positions)
+			terms = append(terms, []byte(term))
+		}
+		rawdb.WriteLogIndexSection(db, section, head, terms)
+	}
+	// Ensure the index only reports blocks matching all criteria on the same log
+	filter := NewRangeFilter(backend, 0, -1, []common.Address{addr1}, [][]common.Hash{{topicA}})
+	if have := filter.exactMatches(0, rawdb.ReadCanonicalHash(db, 7), 0, 7); len(have) != 1 || have[0] != 3 {
+		t.Fatalf("exact matches mismatch: have %v, want [3]", have)
+	}
+	logs, err := filter.Logs(context.Background())
+	if err != nil {
+		t.Fatalf("failed to filter logs: %v", err)
+	}
+	if len(logs) != 2 || logs[0].BlockNumber != 3 || logs[1].BlockNumber != 18 {
+		t.Fatalf("log mismatch: have %d logs, want blocks 3 and 18", len(logs))
+	}
+	filter = NewRangeFilter(backend, 0, -1, nil, [][]common.Hash{nil, {topicA}})
+	if logs, _ = filter.Logs(context.Background()); len(logs) != 1 || logs[0].BlockNumber != 5 || logs[0].Address != addr1 {
+		t.Fatalf("positional topic mismatch: have %d logs, want 1 in block 5", len(logs))
+	}
+	// Drop the second section as if reorged, ensure filtering falls back to blocks
+	rawdb.DeleteLogIndexSection(db, 1, rawdb.ReadCanonicalHash(db, 15), rawdb.ReadLogIndexSection(db, 1, rawdb.ReadCanonicalHash(db, 15)))
+
+	filter = NewRangeFilter(backend, 0, -1, []common.Address{addr2}, nil)
+	if logs, _ = filter.Logs(context.Background()); len(logs) != 2 || logs[0].BlockNumber != 5 || logs[1].BlockNumber != 12 {
+		t.Fatalf("fallback mismatch: have %d logs, want blocks 5 and 12", len(logs))
+	}
 }
@@ -27,6 +27,7 @@ import (
 	"time"
 
 	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/common/mclock"
 	"github.com/mxt/go-mxt/consensus"
 	"github.com/mxt/go-mxt/core"
 	"github.com/mxt/go-mxt/core/forkid"
@@ -62,7 +63,7 @@ func errResp(code errCode, format string, v ...interface{}) error {
 
 type ProtocolManager struct {
 	networkID  uint64
-	forkFilter forkid.Filter // Fork ID filter, constant across the lifetime of the node
+	forkFilter forkid.Filter // Fork ID filter, following the chain configuration
 
 	fastSync  uint32 // Flag whmxter fast sync is enabled (gets disabled if we already have blocks)
 	acceptTxs uint32 // Flag whmxter we're considered synchronised (enables transaction processing)
@@ -73,6 +74,7 @@ type ProtocolManager struct {
 	txpool     txPool
 	blockchain *core.BlockChain
 	chaindb    mxtdb.Database
+	clock      mclock.Clock
 	maxPeers   int
 
 	downloader   *downloader.Downloader
@@ -101,7 +103,7 @@ type ProtocolManager struct {
 
 // NewProtocolManager returns a new Ethereum sub protocol manager. The Ethereum sub protocol manages peers capable
 // with the Ethereum network.
-func NewProtocolManager(config *params.ChainConfig, checkpoint *params.TrustedCheckpoint, mode downloader.SyncMode, networkID uint64, mux *event.TypeMux, txpool txPool, engine consensus.Engine, blockchain *core.BlockChain, chaindb mxtdb.Database, cacheLimit int, whitelist map[uint64]common.Hash) (*ProtocolManager, error) {
+func NewProtocolManager(config *params.ChainConfig, checkpoint *params.TrustedCheckpoint, mode downloader.SyncMode, networkID uint64, mux *event.TypeMux, txpool txPool, engine consensus.Engine, blockchain *core.BlockChain, chaindb mxtdb.Database, cacheLimit int, whitelist map[uint64]common.Hash, clock mclock.Clock) (*ProtocolManager, error) {
 	// Create the protocol manager with the base fields
 	manager := &ProtocolManager{
 		networkID:  networkID,
@@ -110,11 +112,15 @@ func NewProtocolManager(config *params.ChainConfig, checkpoint *params.TrustedCh
 		txpool:     txpool,
 		blockchain: blockchain,
 		chaindb:    chaindb,
+		clock:      clock,
 		peers:      newPeerSet(),
 		whitelist:  whitelist,
 		txsyncCh:   make(chan *txsync),
 		quitSync:   make(chan struct{}),
 	}
+	if manager.clock == nil {
+		manager.clock = mclock.System{}
+	}
 
 	if mode == downloader.FullSync {
 		// The database seems empty as the current block is the genesis. Yet the fast
@@ -153,7 +159,7 @@ func NewProtocolManager(config *params.ChainConfig, checkpoint *params.TrustedCh
 	if atomic.LoadUint32(&manager.fastSync) == 1 {
 		stateBloom = trie.NewSyncBloom(uint64(cacheLimit), chaindb)
 	}
-	manager.downloader = downloader.New(manager.checkpointNumber, chaindb, stateBloom, manager.eventMux, blockchain, nil, manager.removePeer)
+	manager.downloader = downloader.New(manager.checkpointNumber, chaindb, stateBloom, manager.eventMux, blockchain, nil, manager.dropFaultyPeer, clock)
 
 	// Construct the fetcher (short sync)
 	validator := func(header *types.Header) error {
@@ -188,7 +194,7 @@ func NewProtocolManager(config *params.ChainConfig, checkpoint *params.TrustedCh
 		}
 		return n, err
 	}
-	manager.blockFetcher = fetcher.NewBlockFetcher(false, nil, blockchain.GetBlockByHash, validator, manager.BroadcastBlock, heighter, nil, inserter, manager.removePeer)
+	manager.blockFetcher = fetcher.NewBlockFetcher(false, nil, blockchain.GetBlockByHash, validator, manager.BroadcastBlock, heighter, nil, inserter, manager.dropFaultyPeer)
 
 	fetchTx := func(peer string, hashes []common.Hash) error {
 		p := manager.peers.Peer(peer)
@@ -250,6 +256,15 @@ func (pm *ProtocolManager) removePeer(id string) {
 	}
 }
 
+// dropFaultyPeer lowers the reputation of a peer that failed to serve data or
+// served invalid data, before removing it.
+func (pm *ProtocolManager) dropFaultyPeer(id string) {
+	if peer := pm.peers.Peer(id); peer != nil {
+		peer.Peer.Report(p2p.ScoreFaulty, "faulty data")
+	}
+	pm.removePeer(id)
+}
+
 func (pm *ProtocolManager) Start(maxPeers int) {
 	pm.maxPeers = maxPeers
 
@@ -290,7 +305,7 @@ func (pm *ProtocolManager) Stop() {
 }
 
 func (pm *ProtocolManager) newPeer(pv int, p *p2p.Peer, rw p2p.MsgReadWriter, getPooledTx func(hash common.Hash) *types.Transaction) *peer {
-	return newPeer(pv, p, rw, getPooledTx)
+	return newPeer(pv, p, rw, getPooledTx, pm.clock)
 }
 
 func (pm *ProtocolManager) runPeer(p *peer) error {
@@ -319,7 +334,7 @@ func (pm *ProtocolManager) handle(p *peer) error {
 		number  = head.Number.Uint64()
 		td      = pm.blockchain.GetTd(hash, number)
 	)
-	forkID := forkid.NewID(pm.blockchain.Config(), pm.blockchain.Genesis().Hash(), pm.blockchain.CurrentHeader().Number.Uint64())
+	forkID := forkid.NewID(pm.blockchain.Config(), genesis.Hash(), number, head.Time)
 	if err := p.Handshake(pm.networkID, td, hash, genesis.Hash(), forkID, pm.forkFilter); err != nil {
 		p.Log().Debug("Ethereum handshake failed", "err", err)
 		return err
@@ -345,7 +360,7 @@ func (pm *ProtocolManager) handle(p *peer) error {
 	// If we have a trusted CHT, reject all peers below that (avoid fast sync eclipse)
 	if pm.checkpointHash != (common.Hash{}) {
 		// Request the peer's checkpoint header for chain height/weight validation
-		if err := p.RequestHeadersByNumber(pm.checkpointNumber, 1, 0, false); err != nil {
+		if err := p.requestChallengeHeader(pm.checkpointNumber, originCheckpoint); err != nil {
 			return err
 		}
 		// Start a timer to disconnect if the peer doesn't reply in time
@@ -363,7 +378,7 @@ func (pm *ProtocolManager) handle(p *peer) error {
 	}
 	// If we have any explicit whitelist block hashes, request them
 	for number := range pm.whitelist {
-		if err := p.RequestHeadersByNumber(number, 1, 0, false); err != nil {
+		if err := p.requestChallengeHeader(number, originWhitelist); err != nil {
 			return err
 		}
 	}
@@ -389,6 +404,13 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 	}
 	defer msg.Discard()
 
+	// From mxt/66 on, requests and responses are tagged with a request ID
+	var reqID uint64
+	if p.version >= mxt66 && requestMsgs[msg.Code] {
+		if reqID, err = unwrapRequestID(&msg); err != nil {
+			return errResp(ErrDecode, "%v: %v", msg, err)
+		}
+	}
 	// Handle the message depending on its contents
 	switch {
 	case msg.Code == StatusMsg:
@@ -481,7 +503,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				query.Origin.Number += query.Skip + 1
 			}
 		}
-		return p.SendBlockHeaders(headers)
+		return p.ReplyBlockHeaders(reqID, headers)
 
 	case msg.Code == BlockHeadersMsg:
 		// A batch of headers arrived to one of our previous requests
@@ -489,6 +511,9 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		if err := msg.Decode(&headers); err != nil {
 			return errResp(ErrDecode, "msg %v: %v", msg, err)
 		}
+		if p.version >= mxt66 {
+			return pm.handleBlockHeaders66(p, reqID, headers)
+		}
 		// If no headers were received, but we're expencting a checkpoint header, consider it that
 		if len(headers) == 0 && p.syncDrop != nil {
 			// Stop the timer either way, decide later to drop or not
@@ -530,9 +555,11 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			headers = pm.blockFetcher.FilterHeaders(p.id, headers, time.Now())
 		}
 		if len(headers) > 0 || !filter {
-			err := pm.downloader.DeliverHeaders(p.id, headers)
+			err := pm.downloader.DeliverHeaders(p.id, reqID, headers)
 			if err != nil {
 				log.Debug("Failed to deliver headers", "err", err)
+			} else {
+				p.Peer.Report(p2p.ScoreUseful, "delivered headers")
 			}
 		}
 
@@ -561,7 +588,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				bytes += len(data)
 			}
 		}
-		return p.SendBlockBodiesRLP(bodies)
+		return p.ReplyBlockBodiesRLP(reqID, bodies)
 
 	case msg.Code == BlockBodiesMsg:
 		// A batch of block bodies arrived to one of our previous requests
@@ -577,15 +604,35 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			transactions[i] = body.Transactions
 			uncles[i] = body.Uncles
 		}
+		if p.version >= mxt66 {
+			// Route the bodies to whichever of the fetcher or downloader requested them
+			origin, ok := p.resolveRequest(msg.Code, reqID)
+			switch {
+			case !ok:
+				p.Log().Debug("Dropping unsolicited block bodies", "id", reqID, "count", len(request))
+				p.Peer.Report(p2p.ScoreUseless, "unsolicited block bodies")
+			case origin == originFetcher:
+				pm.blockFetcher.FilterBodies(p.id, transactions, uncles, time.Now())
+			default:
+				if err := pm.downloader.DeliverBodies(p.id, reqID, transactions, uncles); err != nil {
+					log.Debug("Failed to deliver bodies", "err", err)
+				} else {
+					p.Peer.Report(p2p.ScoreUseful, "delivered bodies")
+				}
+			}
+			break
+		}
 		// Filter out any explicitly requested bodies, deliver the rest to the downloader
 		filter := len(transactions) > 0 || len(uncles) > 0
 		if filter {
 			transactions, uncles = pm.blockFetcher.FilterBodies(p.id, transactions, uncles, time.Now())
 		}
 		if len(transactions) > 0 || len(uncles) > 0 || !filter {
-			err := pm.downloader.DeliverBodies(p.id, transactions, uncles)
+			err := pm.downloader.DeliverBodies(p.id, reqID, transactions, uncles)
 			if err != nil {
 				log.Debug("Failed to deliver bodies", "err", err)
+			} else {
+				p.Peer.Report(p2p.ScoreUseful, "delivered bodies")
 			}
 		}
 
@@ -625,7 +672,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				bytes += len(entry)
 			}
 		}
-		return p.SendNodeData(data)
+		return p.ReplyNodeData(reqID, data)
 
 	case p.version >= mxt63 && msg.Code == NodeDataMsg:
 		// A batch of node state data arrived to one of our previous requests
@@ -633,9 +680,18 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		if err := msg.Decode(&data); err != nil {
 			return errResp(ErrDecode, "msg %v: %v", msg, err)
 		}
+		if p.version >= mxt66 {
+			if _, ok := p.resolveRequest(msg.Code, reqID); !ok {
+				p.Log().Debug("Dropping unsolicited node data", "id", reqID, "count", len(data))
+				p.Peer.Report(p2p.ScoreUseless, "unsolicited node data")
+				break
+			}
+		}
 		// Deliver all to the downloader
-		if err := pm.downloader.DeliverNodeData(p.id, data); err != nil {
+		if err := pm.downloader.DeliverNodeData(p.id, reqID, data); err != nil {
 			log.Debug("Failed to deliver node state data", "err", err)
+		} else {
+			p.Peer.Report(p2p.ScoreUseful, "delivered node data")
 		}
 
 	case p.version >= mxt63 && msg.Code == GetReceiptsMsg:
@@ -672,7 +728,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				bytes += len(encoded)
 			}
 		}
-		return p.SendReceiptsRLP(receipts)
+		return p.ReplyReceiptsRLP(reqID, receipts)
 
 	case p.version >= mxt63 && msg.Code == ReceiptsMsg:
 		// A batch of receipts arrived to one of our previous requests
@@ -680,9 +736,18 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 		if err := msg.Decode(&receipts); err != nil {
 			return errResp(ErrDecode, "msg %v: %v", msg, err)
 		}
+		if p.version >= mxt66 {
+			if _, ok := p.resolveRequest(msg.Code, reqID); !ok {
+				p.Log().Debug("Dropping unsolicited receipts", "id", reqID, "count", len(receipts))
+				p.Peer.Report(p2p.ScoreUseless, "unsolicited receipts")
+				break
+			}
+		}
 		// Deliver all to the downloader
-		if err := pm.downloader.DeliverReceipts(p.id, receipts); err != nil {
+		if err := pm.downloader.DeliverReceipts(p.id, reqID, receipts); err != nil {
 			log.Debug("Failed to deliver receipts", "err", err)
+		} else {
+			p.Peer.Report(p2p.ScoreUseful, "delivered receipts")
 		}
 
 	case msg.Code == NewBlockHashesMsg:
@@ -702,7 +767,7 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 			}
 		}
 		for _, block := range unknown {
-			pm.blockFetcher.Notify(p.id, block.Hash, block.Number, time.Now(), p.RequestOneHeader, p.RequestBodies)
+			pm.blockFetcher.Notify(p.id, block.Hash, block.Number, time.Now(), p.RequestOneHeader, p.requestFetcherBodies)
 		}
 
 	case msg.Code == NewBlockMsg:
@@ -791,9 +856,17 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 				bytes += len(encoded)
 			}
 		}
-		return p.SendPooledTransactionsRLP(hashes, txs)
+		return p.ReplyPooledTransactionsRLP(reqID, hashes, txs)
 
 	case msg.Code == TransactionMsg || (msg.Code == PooledTransactionsMsg && p.version >= mxt65):
+		// Pooled transactions must answer one of our requests from mxt/66 on
+		if msg.Code == PooledTransactionsMsg && p.version >= mxt66 {
+			if _, ok := p.resolveRequest(msg.Code, reqID); !ok {
+				p.Log().Debug("Dropping unsolicited pooled transactions", "id", reqID)
+				p.Peer.Report(p2p.ScoreUseless, "unsolicited pooled transactions")
+				break
+			}
+		}
 		// Transactions arrived, make sure we have a valid and fresh chain to handle them
 		if atomic.LoadUint32(&pm.acceptTxs) == 0 {
 			break
@@ -818,6 +891,62 @@ func (pm *ProtocolManager) handleMsg(p *peer) error {
 	return nil
 }
 
+// handleBlockHeaders66 routes a batch of headers received over mxt/66 or later
+// to the subsystem which requested them, identified by the request ID.
+func (pm *ProtocolManager) handleBlockHeaders66(p *peer, id uint64, headers []*types.Header) error {
+	origin, ok := p.resolveRequest(BlockHeadersMsg, id)
+	if !ok {
+		p.Log().Debug("Dropping unsolicited headers", "id", id, "count", len(headers))
+		p.Peer.Report(p2p.ScoreUseless, "unsolicited headers")
+		return nil
+	}
+	switch origin {
+	case originCheckpoint:
+		// Stop the timer either way, decide later to drop or not
+		if p.syncDrop != nil {
+			p.syncDrop.Stop()
+			p.syncDrop = nil
+		}
+		if len(headers) == 0 {
+			// If we're doing a fast sync, we must enforce the checkpoint block to avoid
+			// eclipse attacks. Unsynced nodes are welcome to connect after we're done
+			// joining the network
+			if atomic.LoadUint32(&pm.fastSync) == 1 {
+				p.Log().Warn("Dropping unsynced node during fast sync", "addr", p.RemoteAddr(), "type", p.Name())
+				return errors.New("unsynced node cannot serve fast sync")
+			}
+			return nil
+		}
+		if headers[0].Number.Uint64() != pm.checkpointNumber || headers[0].Hash() != pm.checkpointHash {
+			return errors.New("checkpoint hash mismatch")
+		}
+
+	case originWhitelist:
+		for _, header := range headers {
+			want, ok := pm.whitelist[header.Number.Uint64()]
+			if !ok {
+				continue
+			}
+			if hash := header.Hash(); want != hash {
+				p.Log().Info("Whitelist mismatch, dropping peer", "number", header.Number.Uint64(), "hash", hash, "want", want)
+				return errors.New("whitelist block mismatch")
+			}
+			p.Log().Debug("Whitelist block verified", "number", header.Number.Uint64(), "hash", want)
+		}
+
+	case originFetcher:
+		pm.blockFetcher.FilterHeaders(p.id, headers, time.Now())
+
+	default:
+		if err := pm.downloader.DeliverHeaders(p.id, id, headers); err != nil {
+			log.Debug("Failed to deliver headers", "err", err)
+		} else {
+			p.Peer.Report(p2p.ScoreUseful, "delivered headers")
+		}
+	}
+	return nil
+}
+
 // BroadcastBlock will either propagate a block to a subset of its peers, or
 // will only announce its availability (depending what's requested).
 func (pm *ProtocolManager) BroadcastBlock(block *types.Block, propagate bool) {
@@ -68,7 +68,7 @@ func newTestProtocolManager(mode downloader.SyncMode, blocks int, generator func
 	if _, err := blockchain.InsertChain(chain); err != nil {
 		panic(err)
 	}
-	pm, err := NewProtocolManager(gspec.Config, nil, mode, DefaultConfig.NetworkId, evmux, &testTxPool{added: newtx, pool: make(map[common.Hash]*types.Transaction)}, engine, blockchain, db, 1, nil)
+	pm, err := NewProtocolManager(gspec.Config, nil, mode, DefaultConfig.NetworkId, evmux, &testTxPool{added: newtx, pool: make(map[common.Hash]*types.Transaction)}, engine, blockchain, db, 1, nil, nil)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -185,7 +185,7 @@ func newTestPeer(name string, version int, pm *ProtocolManager, shake bool) (*te
 			head    = pm.blockchain.CurrentHeader()
 			td      = pm.blockchain.GetTd(head.Hash(), head.Number.Uint64())
 		)
-		forkID := forkid.NewID(pm.blockchain.Config(), pm.blockchain.Genesis().Hash(), pm.blockchain.CurrentHeader().Number.Uint64())
+		forkID := forkid.NewID(pm.blockchain.Config(), pm.blockchain.Genesis().Hash(), pm.blockchain.CurrentHeader().Number.Uint64(), pm.blockchain.CurrentHeader().Time)
 		tp.handshake(nil, td, head.Hash(), genesis.Hash(), forkID, forkid.NewFilter(pm.blockchain))
 	}
 	return tp, errc
@@ -224,6 +224,41 @@ func (p *testPeer) handshake(t *testing.T, td *big.Int, head common.Hash, genesi
 	}
 }
 
+// testRequestID is the request ID tagging the requests of test peers on mxt/66.
+const testRequestID = 0x1234
+
+// sendRequest sends a request to the protocol manager, tagged with the test
+// request ID from mxt/66 on.
+func (p *testPeer) sendRequest(code uint64, data interface{}) error {
+	if p.version >= mxt66 {
+		data = &requestPacket66{RequestID: testRequestID, Payload: data}
+	}
+	return p2p.Send(p.app, code, data)
+}
+
+// expectResponse checks that the next message received is the given response,
+// tagged with the test request ID from mxt/66 on.
+func (p *testPeer) expectResponse(code uint64, data interface{}) error {
+	if p.version >= mxt66 {
+		data = &requestPacket66{RequestID: testRequestID, Payload: data}
+	}
+	return p2p.ExpectMsg(p.app, code, data)
+}
+
+// readResponse reads the next message received, checking and unwrapping the
+// test request ID from mxt/66 on.
+func (p *testPeer) readResponse() (p2p.Msg, error) {
+	msg, err := p.app.ReadMsg()
+	if err != nil || p.version < mxt66 || !requestMsgs[msg.Code] {
+		return msg, err
+	}
+	id, err := unwrapRequestID(&msg)
+	if err == nil && id != testRequestID {
+		err = fmt.Errorf("request ID mismatch: have %x, want %x", id, testRequestID)
+	}
+	return msg, err
+}
+
 // close terminates the local side of the peer, notifying the remote protocol
 // manager of termination.
 func (p *testPeer) close() {
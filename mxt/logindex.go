@@ -0,0 +1,121 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package mxt
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/core"
+	"github.com/mxt/go-mxt/core/rawdb"
+	"github.com/mxt/go-mxt/core/types"
+	"github.com/mxt/go-mxt/mxtdb"
+)
+
+const (
+	// logIndexThrottling is the time to wait between processing two consecutive
+	// index sections. It's useful during chain upgrades to prevent disk overload.
+	logIndexThrottling = 100 * time.Millisecond
+)
+
+// LogIndexer implements a core.ChainIndexer, building up an exact index of the
+// positions of the logs emitted by every account and carrying every topic, so
+// filters for rare events don't need to check every bloom matching block.
+type LogIndexer struct {
+	db      mxtdb.Database                 // database instance to write index data and metadata into
+	section uint64                         // Section is the section number being processed currently
+	head    common.Hash                    // Head is the hash of the last header processed
+	terms   map[string][]rawdb.LogPosition // Log positions accumulated for the current section
+}
+
+// NewLogIndexer returns a chain indexer that generates the exact log index for
+// the canonical chain.
+func NewLogIndexer(db mxtdb.Database, size, confirms uint64) *core.ChainIndexer {
+	backend := &LogIndexer{
+		db: db,
+	}
+	table := rawdb.NewTable(db, string(rawdb.LogIndexIndexPrefix))
+
+	return core.NewChainIndexer(db, table, backend, size, confirms, logIndexThrottling, "logindex")
+}
+
+// Reset implements core.ChainIndexerBackend, starting a new log index section.
+func (b *LogIndexer) Reset(ctx context.Context, section uint64, lastSectionHead common.Hash) error {
+	b.section, b.head, b.terms = section, common.Hash{}, make(map[string][]rawdb.LogPosition)
+	return nil
+}
+
+// Process implements core.ChainIndexerBackend, adding the logs of a new header
+// into the index.
+func (b *LogIndexer) Process(ctx context.Context, header *types.Header) error {
+	b.head = header.Hash()
+	if header.Bloom == (types.Bloom{}) {
+		return nil // No logs in the block
+	}
+	number := header.Number.Uint64()
+	receipts := rawdb.ReadRawReceipts(b.db, b.head, number)
+	if receipts == nil {
+		return fmt.Errorf("receipts of block #%d [%x…] not found", number, b.head[:4])
+	}
+	var index uint32
+	for _, receipt := range receipts {
+		for _, log := range receipt.Logs {
+			pos := rawdb.LogPosition{Number: number, Index: index}
+
+			term := string(rawdb.LogAddressTerm(log.Address))
+			b.terms[term] = append(b.terms[term], pos)
+			for i, topic := range log.Topics {
+				term := string(rawdb.LogTopicTerm(i, topic))
+				b.terms[term] = append(b.terms[term], pos)
+			}
+			index++
+		}
+	}
+	return nil
+}
+
+// Commit implements core.ChainIndexerBackend, writing the log index of the
+// section out into the database and dropping any version of the section that
+// was indexed on a chain reorged out since.
+func (b *LogIndexer) Commit() error {
+	terms := make([][]byte, 0, len(b.terms))
+	for term := range b.terms {
+		terms = append(terms, []byte(term))
+	}
+	sort.Slice(terms, func(i, j int) bool { return bytes.Compare(terms[i], terms[j]) < 0 })
+
+	batch := b.db.NewBatch()
+	for _, head := range rawdb.ReadLogIndexSectionHeads(b.db, b.section) {
+		if head != b.head {
+			rawdb.DeleteLogIndexSection(batch, b.section, head, rawdb.ReadLogIndexSection(b.db, b.section, head))
+		}
+	}
+	for _, term := range terms {
+		rawdb.WriteLogIndex(batch, term, b.section, b.head, b.terms[string(term)])
+	}
+	rawdb.WriteLogIndexSection(batch, b.section, b.head, terms)
+	return batch.Write()
+}
+
+// Prune returns an empty error since we don't support pruning here.
+func (b *LogIndexer) Prune(threshold uint64) error {
+	return nil
+}
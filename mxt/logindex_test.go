@@ -0,0 +1,87 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package mxt
+
+import (
+	"context"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/core/rawdb"
+	"github.com/mxt/go-mxt/core/types"
+)
+
+// Tests that the log indexer records the positions of logs by address and topic
+// and drops the index of sections reorged out when reindexing them.
+func TestLogIndexer(t *testing.T) {
+	var (
+		db      = rawdb.NewMemoryDatabase()
+		indexer = &LogIndexer{db: db}
+		addr    = common.Address{0x01}
+		topic   = common.Hash{0x02}
+	)
+	// makeSection creates a two block section, the second block containing the
+	// given logs spread over two receipts.
+	makeSection := func(extra byte, logs ...*types.Log) []*types.Header {
+		first := &types.Header{Number: big.NewInt(0), Extra: []byte{extra}}
+		second := &types.Header{Number: big.NewInt(1), ParentHash: first.Hash(), Extra: []byte{extra}}
+		receipts := types.Receipts{{Logs: logs[:1]}, {Logs: logs[1:]}}
+		second.Bloom = types.CreateBloom(receipts)
+		rawdb.WriteReceipts(db, second.Hash(), 1, receipts)
+		return []*types.Header{first, second}
+	}
+	index := func(headers []*types.Header) {
+		if err := indexer.Reset(context.Background(), 0, common.Hash{}); err != nil {
+			t.Fatalf("failed to reset indexer: %v", err)
+		}
+		for _, header := range headers {
+			if err := indexer.Process(context.Background(), header); err != nil {
+				t.Fatalf("failed to process header: %v", err)
+			}
+		}
+		if err := indexer.Commit(); err != nil {
+			t.Fatalf("failed to commit section: %v", err)
+		}
+	}
+	old := makeSection(1, &types.Log{Address: addr}, &types.Log{Address: addr, Topics: []common.Hash{{0xff}, topic}})
+	index(old)
+
+	head := old[1].Hash()
+	if have, want := rawdb.ReadLogIndex(db, rawdb.LogAddressTerm(addr), 0, head), []rawdb.LogPosition{{Number: 1, Index: 0}, {Number: 1, Index: 1}}; !reflect.DeepEqual(have, want) {
+		t.Fatalf("address positions mismatch: have %v, want %v", have, want)
+	}
+	if have, want := rawdb.ReadLogIndex(db, rawdb.LogTopicTerm(1, topic), 0, head), []rawdb.LogPosition{{Number: 1, Index: 1}}; !reflect.DeepEqual(have, want) {
+		t.Fatalf("topic positions mismatch: have %v, want %v", have, want)
+	}
+	if have := rawdb.ReadLogIndex(db, rawdb.LogTopicTerm(0, topic), 0, head); len(have) != 0 {
+		t.Fatalf("topic indexed at wrong position: %v", have)
+	}
+	// Reindex the section on a different chain and ensure the old one is dropped
+	index(makeSection(2, &types.Log{Address: addr}, &types.Log{Address: addr}))
+
+	if rawdb.HasLogIndexSection(db, 0, head) {
+		t.Fatalf("reorged section still marked indexed")
+	}
+	if have := rawdb.ReadLogIndex(db, rawdb.LogAddressTerm(addr), 0, head); len(have) != 0 {
+		t.Fatalf("reorged section positions not deleted: %v", have)
+	}
+	if heads := rawdb.ReadLogIndexSectionHeads(db, 0); len(heads) != 1 || heads[0] == head {
+		t.Fatalf("section heads mismatch: have %x", heads)
+	}
+}
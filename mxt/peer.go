@@ -20,11 +20,13 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"math/rand"
 	"sync"
 	"time"
 
 	mapset "github.com/deckarep/golang-set"
 	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/common/mclock"
 	"github.com/mxt/go-mxt/core/forkid"
 	"github.com/mxt/go-mxt/core/types"
 	"github.com/mxt/go-mxt/p2p"
@@ -60,8 +62,30 @@ const (
 	maxQueuedBlockAnns = 4
 
 	handshakeTimeout = 5 * time.Second
+
+	// pendingRequestTTL is the time after which a request sent to an mxt/66 peer
+	// is forgotten if unanswered, dropping any later response to it.
+	pendingRequestTTL = 2 * time.Minute
 )
 
+// requestOrigin identifies the subsystem which issued a request, so that from
+// mxt/66 on its response can be routed back to it by request ID.
+type requestOrigin int
+
+const (
+	originDownloader requestOrigin = iota // Chain synchronisation
+	originFetcher                         // Block and transaction fetchers
+	originCheckpoint                      // Checkpoint challenge on connection
+	originWhitelist                       // Whitelisted block challenge on connection
+)
+
+// pendingRequest is a request sent to an mxt/66 peer, awaiting its response.
+type pendingRequest struct {
+	code   uint64         // Message code of the expected response
+	origin requestOrigin  // Subsystem to route the response to
+	sent   mclock.AbsTime // Time the request was sent at, to expire it
+}
+
 // max is a helper function which returns the larger of the two given integers.
 func max(a, b int) int {
 	if a > b {
@@ -106,10 +130,14 @@ type peer struct {
 	txAnnounce  chan []common.Hash                   // Channel used to queue transaction announcement requests
 	getPooledTx func(common.Hash) *types.Transaction // Callback used to retrieve transaction from txpool
 
+	requests map[uint64]*pendingRequest // Requests in flight on mxt/66 and later, keyed by ID
+	reqLock  sync.Mutex                 // Lock protecting the requests in flight
+	clock    mclock.Clock               // Time source to expire the requests in flight
+
 	term chan struct{} // Termination channel to stop the broadcaster
 }
 
-func newPeer(version int, p *p2p.Peer, rw p2p.MsgReadWriter, getPooledTx func(hash common.Hash) *types.Transaction) *peer {
+func newPeer(version int, p *p2p.Peer, rw p2p.MsgReadWriter, getPooledTx func(hash common.Hash) *types.Transaction, clock mclock.Clock) *peer {
 	return &peer{
 		Peer:            p,
 		rw:              rw,
@@ -122,6 +150,8 @@ func newPeer(version int, p *p2p.Peer, rw p2p.MsgReadWriter, getPooledTx func(ha
 		txBroadcast:     make(chan []common.Hash),
 		txAnnounce:      make(chan []common.Hash),
 		getPooledTx:     getPooledTx,
+		requests:        make(map[uint64]*pendingRequest),
+		clock:           clock,
 		term:            make(chan struct{}),
 	}
 }
@@ -412,12 +442,12 @@ func (p *peer) AsyncSendPooledTransactionHashes(hashes []common.Hash) {
 	}
 }
 
-// SendPooledTransactionsRLP sends requested transactions to the peer and adds the
+// ReplyPooledTransactionsRLP sends requested transactions to the peer and adds the
 // hashes in its transaction hash set for future reference.
 //
 // Note, the mmxtod assumes the hashes are correct and correspond to the list of
 // transactions being sent.
-func (p *peer) SendPooledTransactionsRLP(hashes []common.Hash, txs []rlp.RawValue) error {
+func (p *peer) ReplyPooledTransactionsRLP(id uint64, hashes []common.Hash, txs []rlp.RawValue) error {
 	// Mark all the transactions as known, but ensure we don't overflow our limits
 	for p.knownTxs.Cardinality() > max(0, maxKnownTxs-len(hashes)) {
 		p.knownTxs.Pop()
@@ -425,7 +455,7 @@ func (p *peer) SendPooledTransactionsRLP(hashes []common.Hash, txs []rlp.RawValu
 	for _, hash := range hashes {
 		p.knownTxs.Add(hash)
 	}
-	return p2p.Send(p.rw, PooledTransactionsMsg, txs)
+	return p.reply(PooledTransactionsMsg, id, txs)
 }
 
 // SendNewBlockHashes announces the availability of a number of blocks through
@@ -487,79 +517,142 @@ func (p *peer) AsyncSendNewBlock(block *types.Block, td *big.Int) {
 	}
 }
 
-// SendBlockHeaders sends a batch of block headers to the remote peer.
-func (p *peer) SendBlockHeaders(headers []*types.Header) error {
-	return p2p.Send(p.rw, BlockHeadersMsg, headers)
+// ReplyBlockHeaders sends a batch of block headers to the remote peer.
+func (p *peer) ReplyBlockHeaders(id uint64, headers []*types.Header) error {
+	return p.reply(BlockHeadersMsg, id, headers)
 }
 
-// SendBlockBodies sends a batch of block contents to the remote peer.
-func (p *peer) SendBlockBodies(bodies []*blockBody) error {
-	return p2p.Send(p.rw, BlockBodiesMsg, blockBodiesData(bodies))
+// ReplyBlockBodies sends a batch of block contents to the remote peer.
+func (p *peer) ReplyBlockBodies(id uint64, bodies []*blockBody) error {
+	return p.reply(BlockBodiesMsg, id, blockBodiesData(bodies))
 }
 
-// SendBlockBodiesRLP sends a batch of block contents to the remote peer from
+// ReplyBlockBodiesRLP sends a batch of block contents to the remote peer from
 // an already RLP encoded format.
-func (p *peer) SendBlockBodiesRLP(bodies []rlp.RawValue) error {
-	return p2p.Send(p.rw, BlockBodiesMsg, bodies)
+func (p *peer) ReplyBlockBodiesRLP(id uint64, bodies []rlp.RawValue) error {
+	return p.reply(BlockBodiesMsg, id, bodies)
 }
 
-// SendNodeDataRLP sends a batch of arbitrary internal data, corresponding to the
+// ReplyNodeData sends a batch of arbitrary internal data, corresponding to the
 // hashes requested.
-func (p *peer) SendNodeData(data [][]byte) error {
-	return p2p.Send(p.rw, NodeDataMsg, data)
+func (p *peer) ReplyNodeData(id uint64, data [][]byte) error {
+	return p.reply(NodeDataMsg, id, data)
 }
 
-// SendReceiptsRLP sends a batch of transaction receipts, corresponding to the
+// ReplyReceiptsRLP sends a batch of transaction receipts, corresponding to the
 // ones requested from an already RLP encoded format.
-func (p *peer) SendReceiptsRLP(receipts []rlp.RawValue) error {
-	return p2p.Send(p.rw, ReceiptsMsg, receipts)
+func (p *peer) ReplyReceiptsRLP(id uint64, receipts []rlp.RawValue) error {
+	return p.reply(ReceiptsMsg, id, receipts)
+}
+
+// reply sends a response to the remote peer, tagged from mxt/66 on with the ID
+// of the request it answers.
+func (p *peer) reply(code uint64, id uint64, data interface{}) error {
+	if p.version < mxt66 {
+		return p2p.Send(p.rw, code, data)
+	}
+	return p2p.Send(p.rw, code, &requestPacket66{RequestID: id, Payload: data})
+}
+
+// request sends a request to the remote peer. From mxt/66 on, it is tagged with
+// the given request ID, or a fresh one if zero, tracked until answered to route
+// the response to origin.
+func (p *peer) request(code uint64, id uint64, origin requestOrigin, data interface{}) error {
+	if p.version < mxt66 {
+		return p2p.Send(p.rw, code, data)
+	}
+	p.reqLock.Lock()
+	now := p.clock.Now()
+	for id, req := range p.requests {
+		if now.Sub(req.sent) > pendingRequestTTL {
+			delete(p.requests, id)
+		}
+	}
+	if id == 0 {
+		for id == 0 || p.requests[id] != nil {
+			id = rand.Uint64()
+		}
+	}
+	p.requests[id] = &pendingRequest{code: code + 1, origin: origin, sent: now}
+	p.reqLock.Unlock()
+
+	return p2p.Send(p.rw, code, &requestPacket66{RequestID: id, Payload: data})
+}
+
+// resolveRequest retires the request answered by a response with the given
+// message code and request ID, returning the subsystem which issued it. False
+// is returned if the response doesn't match any request in flight.
+func (p *peer) resolveRequest(code uint64, id uint64) (requestOrigin, bool) {
+	p.reqLock.Lock()
+	defer p.reqLock.Unlock()
+
+	req := p.requests[id]
+	if req == nil || req.code != code {
+		return 0, false
+	}
+	delete(p.requests, id)
+	return req.origin, true
 }
 
 // RequestOneHeader is a wrapper around the header query functions to fetch a
 // single header. It is used solely by the fetcher.
 func (p *peer) RequestOneHeader(hash common.Hash) error {
 	p.Log().Debug("Fetching single header", "hash", hash)
-	return p2p.Send(p.rw, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Hash: hash}, Amount: uint64(1), Skip: uint64(0), Reverse: false})
+	return p.request(GetBlockHeadersMsg, 0, originFetcher, &getBlockHeadersData{Origin: hashOrNumber{Hash: hash}, Amount: uint64(1), Skip: uint64(0), Reverse: false})
+}
+
+// requestChallengeHeader fetches a single header by number to validate the
+// remote chain on connection, against a checkpoint or a whitelisted block.
+func (p *peer) requestChallengeHeader(number uint64, origin requestOrigin) error {
+	p.Log().Debug("Fetching challenge header", "number", number)
+	return p.request(GetBlockHeadersMsg, 0, origin, &getBlockHeadersData{Origin: hashOrNumber{Number: number}, Amount: uint64(1), Skip: uint64(0), Reverse: false})
 }
 
 // RequestHeadersByHash fetches a batch of blocks' headers corresponding to the
 // specified header query, based on the hash of an origin block.
-func (p *peer) RequestHeadersByHash(origin common.Hash, amount int, skip int, reverse bool) error {
+func (p *peer) RequestHeadersByHash(id uint64, origin common.Hash, amount int, skip int, reverse bool) error {
 	p.Log().Debug("Fetching batch of headers", "count", amount, "fromhash", origin, "skip", skip, "reverse", reverse)
-	return p2p.Send(p.rw, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Hash: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
+	return p.request(GetBlockHeadersMsg, id, originDownloader, &getBlockHeadersData{Origin: hashOrNumber{Hash: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
 }
 
 // RequestHeadersByNumber fetches a batch of blocks' headers corresponding to the
 // specified header query, based on the number of an origin block.
-func (p *peer) RequestHeadersByNumber(origin uint64, amount int, skip int, reverse bool) error {
+func (p *peer) RequestHeadersByNumber(id uint64, origin uint64, amount int, skip int, reverse bool) error {
 	p.Log().Debug("Fetching batch of headers", "count", amount, "fromnum", origin, "skip", skip, "reverse", reverse)
-	return p2p.Send(p.rw, GetBlockHeadersMsg, &getBlockHeadersData{Origin: hashOrNumber{Number: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
+	return p.request(GetBlockHeadersMsg, id, originDownloader, &getBlockHeadersData{Origin: hashOrNumber{Number: origin}, Amount: uint64(amount), Skip: uint64(skip), Reverse: reverse})
 }
 
 // RequestBodies fetches a batch of blocks' bodies corresponding to the hashes
 // specified.
-func (p *peer) RequestBodies(hashes []common.Hash) error {
+func (p *peer) RequestBodies(id uint64, hashes []common.Hash) error {
 	p.Log().Debug("Fetching batch of block bodies", "count", len(hashes))
-	return p2p.Send(p.rw, GetBlockBodiesMsg, hashes)
+	return p.request(GetBlockBodiesMsg, id, originDownloader, hashes)
+}
+
+// requestFetcherBodies fetches a batch of blocks' bodies on behalf of the block
+// fetcher, corresponding to the hashes specified.
+func (p *peer) requestFetcherBodies(hashes []common.Hash) error {
+	p.Log().Debug("Fetching batch of announced block bodies", "count", len(hashes))
+	return p.request(GetBlockBodiesMsg, 0, originFetcher, hashes)
 }
 
 // RequestNodeData fetches a batch of arbitrary data from a node's known state
 // data, corresponding to the specified hashes.
-func (p *peer) RequestNodeData(hashes []common.Hash) error {
+func (p *peer) RequestNodeData(id uint64, hashes []common.Hash) error {
 	p.Log().Debug("Fetching batch of state data", "count", len(hashes))
-	return p2p.Send(p.rw, GetNodeDataMsg, hashes)
+	return p.request(GetNodeDataMsg, id, originDownloader, hashes)
 }
 
 // RequestReceipts fetches a batch of transaction receipts from a remote node.
-func (p *peer) RequestReceipts(hashes []common.Hash) error {
+func (p *peer) RequestReceipts(id uint64, hashes []common.Hash) error {
 	p.Log().Debug("Fetching batch of receipts", "count", len(hashes))
-	return p2p.Send(p.rw, GetReceiptsMsg, hashes)
+	return p.request(GetReceiptsMsg, id, originDownloader, hashes)
 }
 
 // RequestTxs fetches a batch of transactions from a remote node.
 func (p *peer) RequestTxs(hashes []common.Hash) error {
 	p.Log().Debug("Fetching batch of transactions", "count", len(hashes))
-	return p2p.Send(p.rw, GetPooledTransactionsMsg, hashes)
+	return p.request(GetPooledTransactionsMsg, 0, originFetcher, hashes)
 }
 
 // Handshake executes the mxt protocol handshake, negotiating version number,
@@ -17,8 +17,10 @@
 package mxt
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math/big"
 
 	"github.com/mxt/go-mxt/common"
@@ -26,6 +28,7 @@ import (
 	"github.com/mxt/go-mxt/core/forkid"
 	"github.com/mxt/go-mxt/core/types"
 	"github.com/mxt/go-mxt/event"
+	"github.com/mxt/go-mxt/p2p"
 	"github.com/mxt/go-mxt/rlp"
 )
 
@@ -34,16 +37,17 @@ const (
 	mxt63 = 63
 	mxt64 = 64
 	mxt65 = 65
+	mxt66 = 66
 )
 
 // protocolName is the official short name of the protocol used during capability negotiation.
 const protocolName = "mxt"
 
 // ProtocolVersions are the supported versions of the mxt protocol (first is primary).
-var ProtocolVersions = []uint{mxt65, mxt64, mxt63}
+var ProtocolVersions = []uint{mxt66, mxt65, mxt64, mxt63}
 
 // protocolLengths are the number of implemented message corresponding to different protocol versions.
-var protocolLengths = map[uint]uint64{mxt65: 17, mxt64: 17, mxt63: 17}
+var protocolLengths = map[uint]uint64{mxt66: 17, mxt65: 17, mxt64: 17, mxt63: 17}
 
 const protocolMaxMsgSize = 10 * 1024 * 1024 // Maximum cap on the size of a protocol message
 
@@ -71,6 +75,22 @@ const (
 	PooledTransactionsMsg         = 0x0a
 )
 
+// requestMsgs are the request and response message codes which, from mxt66 on,
+// carry a request ID pairing up each response with the request it answers. A
+// response code is always the code of its request plus one.
+var requestMsgs = map[uint64]bool{
+	GetBlockHeadersMsg:       true,
+	BlockHeadersMsg:          true,
+	GetBlockBodiesMsg:        true,
+	BlockBodiesMsg:           true,
+	GetNodeDataMsg:           true,
+	NodeDataMsg:              true,
+	GetReceiptsMsg:           true,
+	ReceiptsMsg:              true,
+	GetPooledTransactionsMsg: true,
+	PooledTransactionsMsg:    true,
+}
+
 type errCode int
 
 const (
@@ -211,6 +231,31 @@ func (request *newBlockData) sanityCheck() error {
 	return nil
 }
 
+// requestPacket66 is the network packet wrapping the requests and responses of
+// mxt/66 and later, prefixing the original message contents with a request ID.
+type requestPacket66 struct {
+	RequestID uint64
+	Payload   interface{}
+}
+
+// unwrapRequestID decodes the request ID of an mxt/66 request or response and
+// replaces the message payload with the wrapped original contents.
+func unwrapRequestID(msg *p2p.Msg) (uint64, error) {
+	data, err := ioutil.ReadAll(msg.Payload)
+	if err != nil {
+		return 0, err
+	}
+	var packet struct {
+		RequestID uint64
+		Payload   rlp.RawValue
+	}
+	if err := rlp.DecodeBytes(data, &packet); err != nil {
+		return 0, err
+	}
+	msg.Payload, msg.Size = bytes.NewReader(packet.Payload), uint32(len(packet.Payload))
+	return packet.RequestID, nil
+}
+
 // blockBody represents the data content of a single block.
 type blockBody struct {
 	Transactions []*types.Transaction // Transactions contained within a block
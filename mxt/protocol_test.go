@@ -25,6 +25,7 @@ import (
 	"time"
 
 	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/common/mclock"
 	"github.com/mxt/go-mxt/consensus/mxtash"
 	"github.com/mxt/go-mxt/core"
 	"github.com/mxt/go-mxt/core/forkid"
@@ -104,7 +105,7 @@ func TestStatusMsgErrors64(t *testing.T) {
 		genesis = pm.blockchain.Genesis()
 		head    = pm.blockchain.CurrentHeader()
 		td      = pm.blockchain.GetTd(head.Hash(), head.Number.Uint64())
-		forkID  = forkid.NewID(pm.blockchain.Config(), pm.blockchain.Genesis().Hash(), pm.blockchain.CurrentHeader().Number.Uint64())
+		forkID  = forkid.NewID(pm.blockchain.Config(), pm.blockchain.Genesis().Hash(), pm.blockchain.CurrentHeader().Number.Uint64(), pm.blockchain.CurrentHeader().Time)
 	)
 	defer pm.Stop()
 
@@ -181,16 +182,16 @@ func TestForkIDSplit(t *testing.T) {
 		blocksNoFork, _  = core.GenerateChain(configNoFork, genesisNoFork, engine, dbNoFork, 2, nil)
 		blocksProFork, _ = core.GenerateChain(configProFork, genesisProFork, engine, dbProFork, 2, nil)
 
-		mxtNoFork, _  = NewProtocolManager(configNoFork, nil, downloader.FullSync, 1, new(event.TypeMux), &testTxPool{pool: make(map[common.Hash]*types.Transaction)}, engine, chainNoFork, dbNoFork, 1, nil)
-		mxtProFork, _ = NewProtocolManager(configProFork, nil, downloader.FullSync, 1, new(event.TypeMux), &testTxPool{pool: make(map[common.Hash]*types.Transaction)}, engine, chainProFork, dbProFork, 1, nil)
+		mxtNoFork, _  = NewProtocolManager(configNoFork, nil, downloader.FullSync, 1, new(event.TypeMux), &testTxPool{pool: make(map[common.Hash]*types.Transaction)}, engine, chainNoFork, dbNoFork, 1, nil, nil)
+		mxtProFork, _ = NewProtocolManager(configProFork, nil, downloader.FullSync, 1, new(event.TypeMux), &testTxPool{pool: make(map[common.Hash]*types.Transaction)}, engine, chainProFork, dbProFork, 1, nil, nil)
 	)
 	mxtNoFork.Start(1000)
 	mxtProFork.Start(1000)
 
 	// Both nodes should allow the other to connect (same genesis, next fork is the same)
 	p2pNoFork, p2pProFork := p2p.MsgPipe()
-	peerNoFork := newPeer(64, p2p.NewPeer(enode.ID{1}, "", nil), p2pNoFork, nil)
-	peerProFork := newPeer(64, p2p.NewPeer(enode.ID{2}, "", nil), p2pProFork, nil)
+	peerNoFork := newPeer(64, p2p.NewPeer(enode.ID{1}, "", nil), p2pNoFork, nil, mclock.System{})
+	peerProFork := newPeer(64, p2p.NewPeer(enode.ID{2}, "", nil), p2pProFork, nil, mclock.System{})
 
 	errc := make(chan error, 2)
 	go func() { errc <- mxtNoFork.handle(peerProFork) }()
@@ -208,8 +209,8 @@ func TestForkIDSplit(t *testing.T) {
 	chainProFork.InsertChain(blocksProFork[:1])
 
 	p2pNoFork, p2pProFork = p2p.MsgPipe()
-	peerNoFork = newPeer(64, p2p.NewPeer(enode.ID{1}, "", nil), p2pNoFork, nil)
-	peerProFork = newPeer(64, p2p.NewPeer(enode.ID{2}, "", nil), p2pProFork, nil)
+	peerNoFork = newPeer(64, p2p.NewPeer(enode.ID{1}, "", nil), p2pNoFork, nil, mclock.System{})
+	peerProFork = newPeer(64, p2p.NewPeer(enode.ID{2}, "", nil), p2pProFork, nil, mclock.System{})
 
 	errc = make(chan error, 2)
 	go func() { errc <- mxtNoFork.handle(peerProFork) }()
@@ -227,8 +228,8 @@ func TestForkIDSplit(t *testing.T) {
 	chainProFork.InsertChain(blocksProFork[1:2])
 
 	p2pNoFork, p2pProFork = p2p.MsgPipe()
-	peerNoFork = newPeer(64, p2p.NewPeer(enode.ID{1}, "", nil), p2pNoFork, nil)
-	peerProFork = newPeer(64, p2p.NewPeer(enode.ID{2}, "", nil), p2pProFork, nil)
+	peerNoFork = newPeer(64, p2p.NewPeer(enode.ID{1}, "", nil), p2pNoFork, nil, mclock.System{})
+	peerProFork = newPeer(64, p2p.NewPeer(enode.ID{2}, "", nil), p2pProFork, nil, mclock.System{})
 
 	errc = make(chan error, 2)
 	go func() { errc <- mxtNoFork.handle(peerProFork) }()
@@ -248,6 +249,7 @@ func TestForkIDSplit(t *testing.T) {
 func TestRecvTransactions63(t *testing.T) { testRecvTransactions(t, 63) }
 func TestRecvTransactions64(t *testing.T) { testRecvTransactions(t, 64) }
 func TestRecvTransactions65(t *testing.T) { testRecvTransactions(t, 65) }
+func TestRecvTransactions66(t *testing.T) { testRecvTransactions(t, 66) }
 
 func testRecvTransactions(t *testing.T, protocol int) {
 	txAdded := make(chan []*types.Transaction)
@@ -277,6 +279,7 @@ func testRecvTransactions(t *testing.T, protocol int) {
 func TestSendTransactions63(t *testing.T) { testSendTransactions(t, 63) }
 func TestSendTransactions64(t *testing.T) { testSendTransactions(t, 64) }
 func TestSendTransactions65(t *testing.T) { testSendTransactions(t, 65) }
+func TestSendTransactions66(t *testing.T) { testSendTransactions(t, 66) }
 
 func testSendTransactions(t *testing.T, protocol int) {
 	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
@@ -326,7 +329,7 @@ func testSendTransactions(t *testing.T, protocol int) {
 						callback(tx.Hash())
 					}
 				}
-			case 65:
+			case 65, 66:
 				msg, err := p.app.ReadMsg()
 				if err != nil {
 					t.Errorf("%v: read error: %v", p.Peer, err)
@@ -457,3 +460,102 @@ func TestGetBlockHeadersDataEncodeDecode(t *testing.T) {
 		}
 	}
 }
+
+// Tests that mxt/66 requests are tagged with the IDs picked by the downloader,
+// and that responses are only accepted once, for the request ID and message type
+// they answer.
+func TestRequestIDs66(t *testing.T) {
+	app, net := p2p.MsgPipe()
+	defer app.Close()
+	p := newPeer(mxt66, p2p.NewPeer(enode.ID{1}, "", nil), net, nil, mclock.System{})
+
+	ids := make(map[uint64]bool)
+	for i := 0; i < 3; i++ {
+		go p.RequestBodies(uint64(i+1), []common.Hash{{byte(i)}})
+
+		msg, err := app.ReadMsg()
+		if err != nil {
+			t.Fatalf("request %d: read error: %v", i, err)
+		}
+		if msg.Code != GetBlockBodiesMsg {
+			t.Fatalf("request %d: code mismatch: have %x, want %x", i, msg.Code, GetBlockBodiesMsg)
+		}
+		id, err := unwrapRequestID(&msg)
+		if err != nil {
+			t.Fatalf("request %d: failed to unwrap: %v", i, err)
+		}
+		msg.Discard()
+		if id != uint64(i+1) {
+			t.Fatalf("request %d: request ID mismatch: have %x, want %x", i, id, i+1)
+		}
+		ids[id] = true
+	}
+	for id := range ids {
+		if _, ok := p.resolveRequest(BlockHeadersMsg, id); ok {
+			t.Errorf("request %x: response with mismatching code accepted", id)
+		}
+		if origin, ok := p.resolveRequest(BlockBodiesMsg, id); !ok || origin != originDownloader {
+			t.Errorf("request %x: resolution mismatch: have %v/%v, want %v/true", id, origin, ok, originDownloader)
+		}
+		if _, ok := p.resolveRequest(BlockBodiesMsg, id); ok {
+			t.Errorf("request %x: resolved twice", id)
+		}
+	}
+}
+
+// Tests that mxt/66 requests left unanswered expire after pendingRequestTTL
+// as measured by the peer's clock.
+func TestRequestExpiry66(t *testing.T) {
+	app, net := p2p.MsgPipe()
+	defer app.Close()
+
+	clock := new(mclock.Simulated)
+	p := newPeer(mxt66, p2p.NewPeer(enode.ID{1}, "", nil), net, nil, clock)
+
+	request := func(id uint64) {
+		go p.RequestBodies(id, []common.Hash{{byte(id)}})
+
+		msg, err := app.ReadMsg()
+		if err != nil {
+			t.Fatalf("request %d: read error: %v", id, err)
+		}
+		msg.Discard()
+	}
+	request(1)
+	clock.Run(pendingRequestTTL / 2)
+	request(2)
+	clock.Run(pendingRequestTTL/2 + time.Second)
+	request(3)
+
+	if _, ok := p.resolveRequest(BlockBodiesMsg, 1); ok {
+		t.Errorf("request 1 not expired")
+	}
+	if _, ok := p.resolveRequest(BlockBodiesMsg, 2); !ok {
+		t.Errorf("request 2 expired early")
+	}
+	if _, ok := p.resolveRequest(BlockBodiesMsg, 3); !ok {
+		t.Errorf("request 3 not tracked")
+	}
+}
+
+// Tests that mxt/66 responses to unknown requests are dropped without tearing
+// down the connection.
+func TestUnsolicitedResponse66(t *testing.T) {
+	pm, _ := newTestProtocolManagerMust(t, downloader.FullSync, 0, nil, nil)
+	defer pm.Stop()
+
+	p, errc := newTestPeer("peer", mxt66, pm, true)
+	defer p.close()
+
+	if err := p2p.Send(p.app, BlockHeadersMsg, &requestPacket66{RequestID: 1, Payload: []*types.Header{}}); err != nil {
+		t.Fatalf("failed to send response: %v", err)
+	}
+	select {
+	case err := <-errc:
+		t.Fatalf("peer dropped: %v", err)
+	case <-time.After(250 * time.Millisecond):
+	}
+	if peers := pm.peers.Len(); peers != 1 {
+		t.Fatalf("peer count mismatch: have %d, want %d", peers, 1)
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"time"
 
 	"github.com/mxt/go-mxt/common"
+	"github.com/mxt/go-mxt/common/mclock"
 	"github.com/mxt/go-mxt/core/rawdb"
 	"github.com/mxt/go-mxt/core/types"
 	"github.com/mxt/go-mxt/mxt/downloader"
@@ -157,7 +158,7 @@ func (pm *ProtocolManager) txsyncLoop64() {
 // chainSyncer coordinates blockchain sync components.
 type chainSyncer struct {
 	pm          *ProtocolManager
-	force       *time.Timer
+	force       mclock.ChanTimer
 	forced      bool // true when force timer fired
 	peerEventCh chan struct{}
 	doneCh      chan error // non-nil when sync is running
@@ -202,7 +203,7 @@ func (cs *chainSyncer) loop() {
 
 	// The force timer lowers the peer count threshold down to one when it fires.
 	// This ensures we'll always start sync even if there aren't enough peers.
-	cs.force = time.NewTimer(forceSyncCycle)
+	cs.force = cs.pm.clock.NewTimer(forceSyncCycle)
 	defer cs.force.Stop()
 
 	for {
@@ -217,7 +218,7 @@ func (cs *chainSyncer) loop() {
 			cs.doneCh = nil
 			cs.force.Reset(forceSyncCycle)
 			cs.forced = false
-		case <-cs.force.C:
+		case <-cs.force.C():
 			cs.forced = true
 
 		case <-cs.pm.quitSync:
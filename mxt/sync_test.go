@@ -29,6 +29,7 @@ import (
 func TestFastSyncDisabling63(t *testing.T) { testFastSyncDisabling(t, 63) }
 func TestFastSyncDisabling64(t *testing.T) { testFastSyncDisabling(t, 64) }
 func TestFastSyncDisabling65(t *testing.T) { testFastSyncDisabling(t, 65) }
+func TestFastSyncDisabling66(t *testing.T) { testFastSyncDisabling(t, 66) }
 
 // Tests that fast sync gets disabled as soon as a real block is successfully
 // imported into the blockchain.
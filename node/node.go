@@ -458,6 +458,22 @@ func (n *Node) RegisterHandler(name, path string, handler http.Handler) {
 	n.http.handlerNames[path] = name
 }
 
+// RegisterWSHandler mounts a handler on the given path on whichever server the
+// WebSocket endpoint is served from. The handler only receives WebSocket upgrade
+// requests and is responsible for upgrading the connection itself.
+func (n *Node) RegisterWSHandler(path string, handler http.Handler) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	if n.state != initializingState {
+		panic("can't register WebSocket handler on running/stopped node")
+	}
+	// The WebSocket endpoint may share the HTTP server, which is only decided
+	// on startup, so mount the handler on both.
+	n.http.wsMux.Handle(path, handler)
+	n.ws.wsMux.Handle(path, handler)
+}
+
 // Attach creates an RPC client attached to an in-process API handler.
 func (n *Node) Attach() (*rpc.Client, error) {
 	return rpc.DialInProc(n.inprocHandler), nil
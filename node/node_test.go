@@ -33,6 +33,7 @@ import (
 	"github.com/mxt/go-mxt/p2p"
 	"github.com/mxt/go-mxt/rpc"
 
+	"github.com/gorilla/websocket"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -486,6 +487,44 @@ func TestWebsocketHTTPOnSeparatePort_WSRequest(t *testing.T) {
 
 }
 
+// Tests that WebSocket handlers are mounted on the WebSocket endpoint, both when
+// it shares the HTTP port and when it has a port of its own.
+func TestRegisterWSHandler(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("can't listen:", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	for _, wsPort := range []int{0, port} {
+		node := createNode(t, 0, wsPort)
+		node.RegisterWSHandler("/test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, err := new(websocket.Upgrader).Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			conn.WriteMessage(websocket.TextMessage, []byte("success"))
+		}))
+		if err := node.Start(); err != nil {
+			t.Fatalf("could not start node: %v", err)
+		}
+		conn, _, err := websocket.DefaultDialer.Dial(node.WSEndpoint()+"/test", nil)
+		if err != nil {
+			node.Close()
+			t.Fatalf("ws port %d: could not dial handler: %v", wsPort, err)
+		}
+		_, msg, err := conn.ReadMessage()
+		conn.Close()
+		node.Close()
+		if err != nil {
+			t.Fatalf("ws port %d: could not read message: %v", wsPort, err)
+		}
+		assert.Equal(t, "success", string(msg))
+	}
+}
+
 func createNode(t *testing.T, httpPort, wsPort int) *Node {
 	conf := &Config{
 		HTTPHost: "127.0.0.1",
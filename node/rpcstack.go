@@ -67,7 +67,8 @@ type httpServer struct {
 
 	// WebSocket handler things.
 	wsConfig  wsConfig
-	wsHandler atomic.Value // *rpcHandler
+	wsHandler atomic.Value  // *rpcHandler
+	wsMux     http.ServeMux // registered WebSocket handlers go here
 
 	// These are set by setListenAddr.
 	endpoint string
@@ -182,6 +183,11 @@ func (h *httpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			rpc.ServeHTTP(w, r)
 			return
 		}
+	} else if ws := h.wsHandler.Load().(*rpcHandler); ws != nil && isWebsocket(r) && h.hasWSHandler(r) {
+		// WebSocket upgrades to a path below root are handled by the WebSocket
+		// mux, which has all the handlers registered via Node.RegisterWSHandler.
+		h.wsMux.ServeHTTP(w, r)
+		return
 	} else if rpc != nil {
 		// Requests to a path below root are handled by the mux,
 		// which has all the handlers registered via Node.RegisterHandler.
@@ -192,6 +198,12 @@ func (h *httpServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(404)
 }
 
+// hasWSHandler reports whether a WebSocket handler is registered for the request.
+func (h *httpServer) hasWSHandler(r *http.Request) bool {
+	_, pattern := h.wsMux.Handler(r)
+	return pattern != ""
+}
+
 // stop shuts down the HTTP server.
 func (h *httpServer) stop() {
 	h.mu.Lock()
@@ -79,6 +79,8 @@ var (
 	errRecentlyDialed   = errors.New("recently dialed")
 	errNotWhitelisted   = errors.New("not contained in netrestrict whitelist")
 	errNoPort           = errors.New("node does not provide TCP port")
+	errBanned           = errors.New("banned")
+	errIncompatible     = errors.New("rejected by protocol dial filters")
 )
 
 // dialer creates outbound connections and submits them into Server.
@@ -139,6 +141,8 @@ type dialConfig struct {
 	log            log.Logger
 	clock          mclock.Clock
 	rand           *mrand.Rand
+	banned         func(*enode.Node) bool // reports banned nodes, which aren't dialed dynamically
+	filter         func(*enode.Node) bool // reports nodes worth dialing dynamically, disabled if nil
 }
 
 func (cfg dialConfig) withDefaults() dialConfig {
@@ -244,6 +248,10 @@ loop:
 		case node := <-nodesCh:
 			if err := d.checkDial(node); err != nil {
 				d.log.Trace("Discarding dial candidate", "id", node.ID(), "ip", node.IP(), "reason", err)
+			} else if d.banned != nil && d.banned(node) {
+				d.log.Trace("Discarding dial candidate", "id", node.ID(), "ip", node.IP(), "reason", errBanned)
+			} else if d.filter != nil && !d.filter(node) {
+				d.log.Trace("Discarding dial candidate", "id", node.ID(), "ip", node.IP(), "reason", errIncompatible)
 			} else {
 				d.startDial(newDialTask(node, dynDialedConn))
 			}
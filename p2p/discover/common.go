@@ -47,6 +47,11 @@ type Config struct {
 	Log          log.Logger         // if set, log messages go here
 	ValidSchemes enr.IdentityScheme // allowed identity schemes
 	Clock        mclock.Clock
+
+	// HolePunchTCP is called by discv5 when a relayed node asks for a TCP hole punch.
+	// It should attempt a TCP connection to the given address. The function is called
+	// on its own goroutine.
+	HolePunchTCP func(initiator *enode.Node, addr *net.TCPAddr)
 }
 
 func (cfg Config) withDefaults() Config {
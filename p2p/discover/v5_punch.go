@@ -0,0 +1,197 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package discover
+
+import (
+	crand "crypto/rand"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/mxt/go-mxt/common/mclock"
+	"github.com/mxt/go-mxt/p2p/discover/v5wire"
+	"github.com/mxt/go-mxt/p2p/enode"
+	"github.com/mxt/go-mxt/p2p/netutil"
+)
+
+const (
+	// punchAttempts is the number of PINGs sent to the target of a hole punch after
+	// the relay has forwarded the request.
+	punchAttempts = 3
+
+	relayTrustTime     = 10 * time.Second // how long relayed requests are accepted from a relay we asked
+	punchLimitWindow   = time.Second      // window of the relay and punch rate limits
+	maxRelaysInWindow  = 10               // RELAYINIT requests forwarded per window
+	maxPunchesInWindow = 5                // relayed requests punched for per window
+)
+
+var errRelayNotForwarded = errors.New("relay did not forward request")
+
+// HolePunch establishes a path to target, a node behind NAT which doesn't answer
+// requests from unknown hosts. Punching is coordinated through relay, which must be
+// known to both nodes: the relay tells the target about our external endpoint, the
+// target sends a packet to it, opening its NAT for our packets. The function returns
+// nil once the target has answered a PING.
+//
+// If tcpPort is non-zero, the target is also asked to open a TCP connection to that
+// port on our external IP. The TCP connection attempt is handled by the
+// Config.HolePunchTCP callback of the target.
+func (t *UDPv5) HolePunch(target, relay *enode.Node, tcpPort int) error {
+	forwarded, err := t.relayInit(relay, target.ID(), tcpPort)
+	if err != nil {
+		return err
+	}
+	if !forwarded {
+		return errRelayNotForwarded
+	}
+	for i := 0; i < punchAttempts; i++ {
+		if _, err = t.ping(target); err == nil {
+			break
+		}
+	}
+	return err
+}
+
+// relayInit calls RELAYINIT on a relay and waits for a RELAYRESP response.
+func (t *UDPv5) relayInit(relay *enode.Node, target enode.ID, tcpPort int) (bool, error) {
+	t.punchMu.Lock()
+	now := t.clock.Now()
+	for id, asked := range t.askedRelays {
+		if now.Sub(asked) >= relayTrustTime {
+			delete(t.askedRelays, id)
+		}
+	}
+	t.askedRelays[relay.ID()] = now
+	t.punchMu.Unlock()
+
+	req := &v5wire.RelayInit{Target: target, TCP: uint16(tcpPort)}
+	resp := t.call(relay, v5wire.RelayResponseMsg, req)
+	defer t.callDone(resp)
+
+	select {
+	case p := <-resp.ch:
+		return p.(*v5wire.RelayResponse).Forwarded, nil
+	case err := <-resp.err:
+		return false, err
+	}
+}
+
+// isTrustedRelay reports whmxter relayed requests are accepted from the given node. This
+// is the case for nodes in the table, which have completed a handshake with us, and for
+// nodes we have recently asked to relay a request of our own.
+func (t *UDPv5) isTrustedRelay(id enode.ID) bool {
+	if t.tab.getNode(id) != nil {
+		return true
+	}
+	t.punchMu.Lock()
+	defer t.punchMu.Unlock()
+	asked, ok := t.askedRelays[id]
+	return ok && t.clock.Now().Sub(asked) < relayTrustTime
+}
+
+// handleRelayInit forwards a hole punching request to its target. The request is only
+// forwarded if both the sender and the target are known and the sender's record
+// matches the endpoint the request was received from.
+func (t *UDPv5) handleRelayInit(p *v5wire.RelayInit, fromID enode.ID, fromAddr *net.UDPAddr) {
+	resp := &v5wire.RelayResponse{ReqID: p.ReqID}
+	initiator, target := t.getNode(fromID), t.getNode(p.Target)
+	if initiator != nil && target != nil && p.Target != fromID &&
+		initiator.IP().Equal(fromAddr.IP) && initiator.UDP() == fromAddr.Port &&
+		t.relayLimit.allow() {
+		req := &v5wire.RelayRequest{
+			ReqID:     make([]byte, 8),
+			Initiator: initiator.Record(),
+			IP:        fromAddr.IP,
+			Port:      uint16(fromAddr.Port),
+			TCP:       p.TCP,
+		}
+		crand.Read(req.ReqID)
+		toAddr := &net.UDPAddr{IP: target.IP(), Port: target.UDP()}
+		resp.Forwarded = t.sendResponse(target.ID(), toAddr, req) == nil
+	} else {
+		t.log.Debug("Can't relay "+p.Name(), "id", fromID, "addr", fromAddr, "target", p.Target)
+	}
+	t.sendResponse(fromID, fromAddr, resp)
+}
+
+// handleRelayRequest punches the local NAT for the initiator of a relayed request.
+func (t *UDPv5) handleRelayRequest(p *v5wire.RelayRequest, fromID enode.ID, fromAddr *net.UDPAddr) {
+	if !t.isTrustedRelay(fromID) {
+		t.log.Debug("Untrusted relay sent "+p.Name(), "id", fromID, "addr", fromAddr)
+		return
+	}
+	initiator, err := enode.New(t.validSchemes, p.Initiator)
+	if err != nil {
+		t.log.Debug("Invalid record in "+p.Name(), "id", fromID, "addr", fromAddr, "err", err)
+		return
+	}
+	toAddr := &net.UDPAddr{IP: p.IP, Port: int(p.Port)}
+	if !toAddr.IP.Equal(initiator.IP()) || toAddr.Port != initiator.UDP() || (p.TCP != 0 && int(p.TCP) != initiator.TCP()) {
+		t.log.Debug("Relayed endpoint doesn't match record", "id", initiator.ID(), "addr", toAddr, "tcp", p.TCP)
+		return
+	}
+	if err := netutil.CheckRelayIP(fromAddr.IP, toAddr.IP); err != nil {
+		t.log.Debug("Relayed endpoint has invalid IP", "id", initiator.ID(), "addr", toAddr, "err", err)
+		return
+	}
+	if t.netrestrict != nil && !t.netrestrict.Contains(toAddr.IP) {
+		t.log.Debug("Relayed endpoint not in netrestrict", "id", initiator.ID(), "addr", toAddr)
+		return
+	}
+	if toAddr.Port <= 1024 || (p.TCP != 0 && p.TCP <= 1024) {
+		t.log.Debug("Relayed endpoint has low port", "id", initiator.ID(), "addr", toAddr, "tcp", p.TCP)
+		return
+	}
+	if !t.punchLimit.allow() {
+		t.log.Debug("Too many relayed requests", "id", initiator.ID(), "addr", toAddr)
+		return
+	}
+
+	// The PING is sent without a session, so the initiator will most likely answer with
+	// WHOAREYOU, which is dropped. Its only purpose is to open the local NAT.
+	ping := &v5wire.Ping{ReqID: make([]byte, 8), ENRSeq: t.localNode.Node().Seq()}
+	crand.Read(ping.ReqID)
+	t.send(initiator.ID(), toAddr, ping, nil)
+	if p.TCP != 0 && t.punchTCP != nil {
+		go t.punchTCP(initiator, &net.TCPAddr{IP: toAddr.IP, Port: int(p.TCP)})
+	}
+}
+
+// windowLimiter allows a fixed number of events per time window. It is accessed by
+// dispatch only.
+type windowLimiter struct {
+	clock mclock.Clock
+	limit int
+	start mclock.AbsTime
+	count int
+}
+
+func newWindowLimiter(clock mclock.Clock, limit int) *windowLimiter {
+	return &windowLimiter{clock: clock, limit: limit, start: clock.Now()}
+}
+
+// allow reports whmxter another event fits into the current window.
+func (l *windowLimiter) allow() bool {
+	if now := l.clock.Now(); now.Sub(l.start) >= punchLimitWindow {
+		l.start, l.count = now, 0
+	}
+	if l.count >= l.limit {
+		return false
+	}
+	l.count++
+	return true
+}
@@ -0,0 +1,273 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package discover
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mxt/go-mxt/common/mclock"
+	"github.com/mxt/go-mxt/p2p/discover/v5wire"
+	"github.com/mxt/go-mxt/p2p/enode"
+	"github.com/mxt/go-mxt/p2p/enr"
+)
+
+// This test checks that RELAYINIT is forwarded to known targets only.
+func TestUDPv5_relayHandling(t *testing.T) {
+	t.Parallel()
+	test := newUDPV5Test(t)
+	defer test.close()
+
+	var (
+		targetKey  = newkey()
+		targetAddr = &net.UDPAddr{IP: net.IP{10, 0, 1, 100}, Port: 30303}
+		target     = test.getNode(targetKey, targetAddr).Node()
+		initiator  = test.getNode(test.remotekey, test.remoteaddr).Node()
+	)
+
+	// The target is unknown, request is not forwarded.
+	test.packetIn(&v5wire.RelayInit{ReqID: []byte("1"), Target: target.ID(), TCP: 30304})
+	test.waitPacketOut(func(p *v5wire.RelayResponse, addr *net.UDPAddr, _ v5wire.Nonce) {
+		if !bytes.Equal(p.ReqID, []byte("1")) {
+			t.Error("wrong request ID in response:", p.ReqID)
+		}
+		if p.Forwarded {
+			t.Error("request to unknown target forwarded")
+		}
+	})
+
+	// Both nodes are known, request is forwarded.
+	test.db.UpdateNode(target)
+	test.db.UpdateNode(initiator)
+	test.packetIn(&v5wire.RelayInit{ReqID: []byte("2"), Target: target.ID(), TCP: 30304})
+	test.waitPacketOut(func(p *v5wire.RelayRequest, addr *net.UDPAddr, _ v5wire.Nonce) {
+		if !addr.IP.Equal(targetAddr.IP) || addr.Port != targetAddr.Port {
+			t.Error("request forwarded to wrong endpoint:", addr)
+		}
+		n, err := enode.New(enode.ValidSchemesForTesting, p.Initiator)
+		if err != nil {
+			t.Fatal("invalid initiator record:", err)
+		}
+		if n.ID() != initiator.ID() {
+			t.Error("wrong initiator record:", n.ID())
+		}
+		if !p.IP.Equal(test.remoteaddr.IP) || int(p.Port) != test.remoteaddr.Port || p.TCP != 30304 {
+			t.Errorf("wrong initiator endpoint %v:%d, tcp %d", p.IP, p.Port, p.TCP)
+		}
+	})
+	test.waitPacketOut(func(p *v5wire.RelayResponse, addr *net.UDPAddr, _ v5wire.Nonce) {
+		if !bytes.Equal(p.ReqID, []byte("2")) {
+			t.Error("wrong request ID in response:", p.ReqID)
+		}
+		if !p.Forwarded {
+			t.Error("request to known target not forwarded")
+		}
+	})
+}
+
+// This test checks that a relayed request makes the target contact the initiator.
+func TestUDPv5_relayRequestHandling(t *testing.T) {
+	t.Parallel()
+	test := newUDPV5Test(t)
+	defer test.close()
+
+	var (
+		initKey  = newkey()
+		initAddr = &net.UDPAddr{IP: net.IP{10, 0, 2, 1}, Port: 40404}
+		initLN   = test.getNode(initKey, initAddr)
+		relay    = test.getNode(test.remotekey, test.remoteaddr).Node()
+		clock    = new(mclock.Simulated)
+		tcpc     = make(chan *net.TCPAddr, 2)
+	)
+	initLN.Set(enr.TCP(30303))
+	init := initLN.Node()
+	test.udp.punchLimit = newWindowLimiter(clock, 1)
+	test.udp.punchTCP = func(n *enode.Node, addr *net.TCPAddr) {
+		if n.ID() != init.ID() {
+			t.Error("wrong initiator passed to TCP hole punch:", n.ID())
+		}
+		tcpc <- addr
+	}
+	request := func(ip net.IP, port, tcp int) {
+		test.packetIn(&v5wire.RelayRequest{
+			ReqID:     []byte("1"),
+			Initiator: init.Record(),
+			IP:        ip,
+			Port:      uint16(port),
+			TCP:       uint16(tcp),
+		})
+	}
+	expectNone := func(reason string) {
+		test.pipe.mu.Lock()
+		n := len(test.pipe.queue)
+		test.pipe.mu.Unlock()
+		if n != 0 {
+			t.Fatalf("punch packet sent for %s", reason)
+		}
+	}
+	expectPunch := func() {
+		test.waitPacketOut(func(p *v5wire.Ping, addr *net.UDPAddr, _ v5wire.Nonce) {
+			if !addr.IP.Equal(initAddr.IP) || addr.Port != initAddr.Port {
+				t.Error("punch packet sent to wrong endpoint:", addr)
+			}
+		})
+		select {
+		case addr := <-tcpc:
+			if !addr.IP.Equal(initAddr.IP) || addr.Port != 30303 {
+				t.Error("wrong TCP hole punch address:", addr)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("TCP hole punch not started")
+		}
+	}
+
+	// Requests relayed by unknown nodes are dropped.
+	request(initAddr.IP, initAddr.Port, 30303)
+	expectNone("unknown relay")
+
+	// Endpoints not matching the initiator record are dropped.
+	test.table.addSeenNode(wrapNode(relay))
+	request(net.IP{10, 0, 2, 2}, initAddr.Port, 30303)
+	expectNone("wrong IP")
+	request(initAddr.IP, initAddr.Port+1, 30303)
+	expectNone("wrong UDP port")
+	request(initAddr.IP, initAddr.Port, 30304)
+	expectNone("wrong TCP port")
+
+	// A matching request is punched for, but only once per window.
+	request(initAddr.IP, initAddr.Port, 30303)
+	expectPunch()
+	request(initAddr.IP, initAddr.Port, 30303)
+	expectNone("rate limited request")
+	clock.Run(punchLimitWindow)
+	request(initAddr.IP, initAddr.Port, 30303)
+	expectPunch()
+}
+
+// This test checks that relayed requests are accepted from relays asked by us.
+func TestUDPv5_relayRequestAskedRelay(t *testing.T) {
+	t.Parallel()
+	test := newUDPV5Test(t)
+	defer test.close()
+
+	var (
+		initKey  = newkey()
+		initAddr = &net.UDPAddr{IP: net.IP{10, 0, 2, 1}, Port: 40404}
+		init     = test.getNode(initKey, initAddr).Node()
+		relay    = test.getNode(test.remotekey, test.remoteaddr).Node()
+		done     = make(chan error, 1)
+	)
+	go func() { done <- test.udp.HolePunch(init, relay, 0) }()
+	test.waitPacketOut(func(p *v5wire.RelayInit, addr *net.UDPAddr, _ v5wire.Nonce) {
+		test.packetIn(&v5wire.RelayResponse{ReqID: p.ReqID})
+	})
+	if err := <-done; err != errRelayNotForwarded {
+		t.Fatalf("want errRelayNotForwarded, got %v", err)
+	}
+
+	test.packetIn(&v5wire.RelayRequest{
+		ReqID:     []byte("1"),
+		Initiator: init.Record(),
+		IP:        initAddr.IP,
+		Port:      uint16(initAddr.Port),
+	})
+	test.waitPacketOut(func(p *v5wire.Ping, addr *net.UDPAddr, _ v5wire.Nonce) {
+		if !addr.IP.Equal(initAddr.IP) || addr.Port != initAddr.Port {
+			t.Error("punch packet sent to wrong endpoint:", addr)
+		}
+	})
+}
+
+// This test checks that RELAYINIT forwarding is rate limited.
+func TestUDPv5_relayLimit(t *testing.T) {
+	t.Parallel()
+	test := newUDPV5Test(t)
+	defer test.close()
+
+	var (
+		targetKey  = newkey()
+		targetAddr = &net.UDPAddr{IP: net.IP{10, 0, 1, 100}, Port: 30303}
+		target     = test.getNode(targetKey, targetAddr).Node()
+		initiator  = test.getNode(test.remotekey, test.remoteaddr).Node()
+		clock      = new(mclock.Simulated)
+	)
+	test.udp.relayLimit = newWindowLimiter(clock, 1)
+	test.db.UpdateNode(target)
+	test.db.UpdateNode(initiator)
+	relay := func(reqid string, forwarded bool) {
+		test.packetIn(&v5wire.RelayInit{ReqID: []byte(reqid), Target: target.ID()})
+		if forwarded {
+			test.waitPacketOut(func(p *v5wire.RelayRequest, addr *net.UDPAddr, _ v5wire.Nonce) {})
+		}
+		test.waitPacketOut(func(p *v5wire.RelayResponse, addr *net.UDPAddr, _ v5wire.Nonce) {
+			if p.Forwarded != forwarded {
+				t.Errorf("request %s: forwarded %t, want %t", reqid, p.Forwarded, forwarded)
+			}
+		})
+	}
+	relay("1", true)
+	relay("2", false)
+	clock.Run(punchLimitWindow)
+	relay("3", true)
+}
+
+// This test checks the initiator side of hole punching.
+func TestUDPv5_holePunch(t *testing.T) {
+	t.Parallel()
+	test := newUDPV5Test(t)
+	defer test.close()
+
+	var (
+		relay      = test.getNode(test.remotekey, test.remoteaddr).Node()
+		targetKey  = newkey()
+		targetAddr = &net.UDPAddr{IP: net.IP{10, 0, 1, 100}, Port: 30303}
+		target     = test.getNode(targetKey, targetAddr).Node()
+		done       = make(chan error, 1)
+	)
+
+	// The relay doesn't know the target.
+	go func() { done <- test.udp.HolePunch(target, relay, 0) }()
+	test.waitPacketOut(func(p *v5wire.RelayInit, addr *net.UDPAddr, _ v5wire.Nonce) {
+		test.packetInFrom(test.remotekey, test.remoteaddr, &v5wire.RelayResponse{ReqID: p.ReqID})
+	})
+	if err := <-done; err != errRelayNotForwarded {
+		t.Fatalf("want errRelayNotForwarded, got %v", err)
+	}
+
+	// The relay forwards the request and the target answers.
+	go func() { done <- test.udp.HolePunch(target, relay, 30303) }()
+	test.waitPacketOut(func(p *v5wire.RelayInit, addr *net.UDPAddr, _ v5wire.Nonce) {
+		if p.Target != target.ID() {
+			t.Error("wrong target in RELAYINIT:", p.Target)
+		}
+		if p.TCP != 30303 {
+			t.Error("wrong TCP port in RELAYINIT:", p.TCP)
+		}
+		test.packetInFrom(test.remotekey, test.remoteaddr, &v5wire.RelayResponse{ReqID: p.ReqID, Forwarded: true})
+	})
+	test.waitPacketOut(func(p *v5wire.Ping, addr *net.UDPAddr, _ v5wire.Nonce) {
+		if !addr.IP.Equal(targetAddr.IP) || addr.Port != targetAddr.Port {
+			t.Error("PING sent to wrong endpoint:", addr)
+		}
+		test.packetInFrom(targetKey, targetAddr, &v5wire.Pong{ReqID: p.ReqID})
+	})
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
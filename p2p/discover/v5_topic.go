@@ -0,0 +1,553 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package discover
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mxt/go-mxt/common/mclock"
+	"github.com/mxt/go-mxt/crypto"
+	"github.com/mxt/go-mxt/p2p/discover/v5wire"
+	"github.com/mxt/go-mxt/p2p/enode"
+	"github.com/mxt/go-mxt/p2p/netutil"
+	"github.com/mxt/go-mxt/rlp"
+)
+
+const (
+	maxTopicLength  = 128              // max length of a topic name
+	topicQueueLimit = 50               // max registrations per topic
+	topicTableLimit = 500              // max registrations across all topics
+	topicRegTTL     = 10 * time.Minute // lifetime of a topic registration
+	ticketValidity  = 10 * time.Second // time after the wait time in which a ticket can be used
+	maxTicketWait   = topicRegTTL / 4  // registrars asking for longer waits are skipped
+
+	topicRegistrars     = 5                // number of nodes closest to the topic to register at
+	topicSearchInterval = 10 * time.Second // delay between topic search rounds
+)
+
+var (
+	errTicketInvalid = errors.New("invalid ticket")
+	errTicketEarly   = errors.New("ticket used before wait time")
+	errTicketExpired = errors.New("ticket expired")
+	errTicketWait    = errors.New("ticket wait time too long")
+	errTopicFull     = errors.New("no free registration slot")
+)
+
+// topicID returns the position of a topic in the node ID space. Registrations for
+// the topic are placed at the nodes closest to it.
+func topicID(topic string) enode.ID {
+	return enode.ID(crypto.Keccak256Hash([]byte(topic)))
+}
+
+// ticketData is the authenticated content of a topic registration ticket. Tickets
+// are opaque to the registrant and can only be verified by the issuing node.
+type ticketData struct {
+	ID     enode.ID
+	IP     net.IP
+	Topic  []byte
+	Issued uint64 // local clock time of issue
+	Wait   uint64 // nanoseconds to wait before the ticket can be used
+}
+
+// topicTable keeps the nodes registered for topics at the local node. Slots are
+// handed out through tickets: a node asking to register obtains a ticket with a
+// wait time, after which it may use the ticket to register. Every ticket reserves
+// a slot until it is used or runs out, so the wait time is zero only if the topic
+// queue has slots left that aren't reserved yet. Otherwise it lasts until enough
+// registrations expire to free a slot for the ticket. Registrations are rejected
+// if no slot is free, they never displace other nodes.
+//
+// The table is only accessed from the UDPv5 dispatch loop and isn't thread-safe.
+type topicTable struct {
+	clock   mclock.Clock
+	key     []byte                   // ticket authentication key
+	queues  map[string][]topicReg    // registrations per topic, oldest first
+	count   int                      // number of registrations across all topics
+	tickets map[string][]topicTicket // outstanding tickets per topic
+	pending int                      // number of outstanding tickets across all topics
+}
+
+// topicReg is a registration of a node for a topic.
+type topicReg struct {
+	node    *enode.Node
+	expires mclock.AbsTime
+}
+
+// topicTicket is a ticket issued for a topic which wasn't used yet.
+type topicTicket struct {
+	id     enode.ID
+	lapses mclock.AbsTime // end of the ticket's validity
+}
+
+func newTopicTable(clock mclock.Clock) *topicTable {
+	key := make([]byte, 32)
+	crand.Read(key)
+	return &topicTable{
+		clock:   clock,
+		key:     key,
+		queues:  make(map[string][]topicReg),
+		tickets: make(map[string][]topicTicket),
+	}
+}
+
+// expire drops all registrations and tickets which have run out.
+func (tab *topicTable) expire(now mclock.AbsTime) {
+	for topic, tickets := range tab.tickets {
+		live := tickets[:0]
+		for _, tk := range tickets {
+			if tk.lapses > now {
+				live = append(live, tk)
+			}
+		}
+		tab.pending -= len(tickets) - len(live)
+		if len(live) == 0 {
+			delete(tab.tickets, topic)
+		} else {
+			tab.tickets[topic] = live
+		}
+	}
+	for topic, queue := range tab.queues {
+		n := 0
+		for n < len(queue) && queue[n].expires <= now {
+			n++
+		}
+		tab.count -= n
+		if n == len(queue) {
+			delete(tab.queues, topic)
+		} else {
+			tab.queues[topic] = queue[n:]
+		}
+	}
+}
+
+// dropTicket forgets the outstanding ticket of a node for the given topic.
+func (tab *topicTable) dropTicket(id enode.ID, topic string) {
+	tickets := tab.tickets[topic]
+	for i, tk := range tickets {
+		if tk.id == id {
+			tickets = append(tickets[:i:i], tickets[i+1:]...)
+			tab.pending--
+			break
+		}
+	}
+	if len(tickets) == 0 {
+		delete(tab.tickets, topic)
+	} else {
+		tab.tickets[topic] = tickets
+	}
+}
+
+// isRegistered reports whmxter the node is registered for the given topic.
+func (tab *topicTable) isRegistered(id enode.ID, topic string) bool {
+	for _, reg := range tab.queues[topic] {
+		if reg.node.ID() == id {
+			return true
+		}
+	}
+	return false
+}
+
+// waitTime computes the time a new registrant has to wait before it can register
+// for the given topic, taking the slots reserved by outstanding tickets into account.
+func (tab *topicTable) waitTime(topic string, now mclock.AbsTime) time.Duration {
+	queue := tab.queues[topic]
+	expiries := make([]mclock.AbsTime, len(queue))
+	for i, reg := range queue {
+		expiries[i] = reg.expires
+	}
+	wait := slotWait(expiries, len(queue)+len(tab.tickets[topic]), topicQueueLimit, now)
+
+	if taken := tab.count + tab.pending; taken >= topicTableLimit {
+		expiries = expiries[:0]
+		for _, queue := range tab.queues {
+			for _, reg := range queue {
+				expiries = append(expiries, reg.expires)
+			}
+		}
+		sort.Slice(expiries, func(i, j int) bool { return expiries[i] < expiries[j] })
+		if w := slotWait(expiries, taken, topicTableLimit, now); w > wait {
+			wait = w
+		}
+	}
+	return wait
+}
+
+// slotWait returns the time until a slot frees up for a new registrant, given the
+// sorted expiry times of the registrations, the number of taken or reserved slots
+// and the slot limit. Slots freed by the registrations expiring first are already
+// promised to the holders of earlier tickets.
+func slotWait(expiries []mclock.AbsTime, taken, limit int, now mclock.AbsTime) time.Duration {
+	if taken < limit {
+		return 0
+	}
+	if i := taken - limit; i < len(expiries) {
+		return time.Duration(expiries[i] - now)
+	}
+	// All slots are spoken for until the next round of registrations expires.
+	return topicRegTTL
+}
+
+// issueTicket creates a registration ticket for the given node, returning it
+// along with the time the node has to wait before using it. The ticket replaces
+// any ticket issued to the node for the topic before. Nodes renewing their
+// registration can do so right away.
+func (tab *topicTable) issueTicket(id enode.ID, ip net.IP, topic string) ([]byte, time.Duration) {
+	now := tab.clock.Now()
+	tab.expire(now)
+	tab.dropTicket(id, topic)
+
+	var wait time.Duration
+	if !tab.isRegistered(id, topic) {
+		wait = tab.waitTime(topic, now)
+		// Registrants skip tickets with long waits, don't hold slots for them.
+		if wait <= maxTicketWait {
+			tab.tickets[topic] = append(tab.tickets[topic], topicTicket{id: id, lapses: now.Add(wait + ticketValidity)})
+			tab.pending++
+		}
+	}
+	data, _ := rlp.EncodeToBytes(&ticketData{
+		ID:     id,
+		IP:     ip,
+		Topic:  []byte(topic),
+		Issued: uint64(now),
+		Wait:   uint64(wait),
+	})
+	return append(data, tab.mac(data)...), wait
+}
+
+// mac computes the authentication code of an encoded ticket.
+func (tab *topicTable) mac(data []byte) []byte {
+	mac := hmac.New(sha256.New, tab.key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+// useTicket verifies a ticket presented by the given node, returning the topic
+// it was issued for.
+func (tab *topicTable) useTicket(ticket []byte, id enode.ID, ip net.IP) (string, error) {
+	if len(ticket) <= sha256.Size {
+		return "", errTicketInvalid
+	}
+	data, mac := ticket[:len(ticket)-sha256.Size], ticket[len(ticket)-sha256.Size:]
+	if !hmac.Equal(mac, tab.mac(data)) {
+		return "", errTicketInvalid
+	}
+	var t ticketData
+	if err := rlp.DecodeBytes(data, &t); err != nil {
+		return "", errTicketInvalid
+	}
+	if t.ID != id || !t.IP.Equal(ip) {
+		return "", errTicketInvalid
+	}
+	var (
+		now   = tab.clock.Now()
+		valid = mclock.AbsTime(t.Issued + t.Wait)
+	)
+	switch {
+	case now < valid:
+		return "", errTicketEarly
+	case now > valid.Add(ticketValidity):
+		return "", errTicketExpired
+	}
+	return string(t.Topic), nil
+}
+
+// register adds a node to the topic queue, renewing its registration if it is
+// already registered. It fails if the queue or the table is full.
+func (tab *topicTable) register(node *enode.Node, topic string) error {
+	now := tab.clock.Now()
+	tab.expire(now)
+	tab.dropTicket(node.ID(), topic)
+
+	queue := tab.queues[topic]
+	for i, reg := range queue {
+		if reg.node.ID() == node.ID() {
+			queue = append(queue[:i:i], queue[i+1:]...)
+			tab.count--
+			break
+		}
+	}
+	if len(queue) >= topicQueueLimit || tab.count >= topicTableLimit {
+		return errTopicFull
+	}
+	tab.queues[topic] = append(queue, topicReg{node: node, expires: now.Add(topicRegTTL)})
+	tab.count++
+	return nil
+}
+
+// nodes returns up to limit randomly chosen nodes registered for the topic.
+func (tab *topicTable) nodes(topic string, limit int) []*enode.Node {
+	tab.expire(tab.clock.Now())
+
+	queue := tab.queues[topic]
+	nodes := make([]*enode.Node, 0, min(limit, len(queue)))
+	for _, i := range rand.Perm(len(queue)) {
+		if len(nodes) >= limit {
+			break
+		}
+		nodes = append(nodes, queue[i].node)
+	}
+	return nodes
+}
+
+// RegisterTopic advertises the local node under the given topic until stop is
+// closed or the transport shuts down. The node registers at the nodes closest to
+// the topic and renews its registrations before they expire.
+func (t *UDPv5) RegisterTopic(topic string, stop <-chan struct{}) {
+	for {
+		next := t.clock.Now().Add(topicRegTTL / 2)
+
+		registrars := t.Lookup(topicID(topic))
+		if len(registrars) > topicRegistrars {
+			registrars = registrars[:topicRegistrars]
+		}
+		var wg sync.WaitGroup
+		for _, n := range registrars {
+			wg.Add(1)
+			go func(n *enode.Node) {
+				defer wg.Done()
+				if err := t.registerAt(n, topic, stop); err != nil {
+					t.log.Debug("Topic registration failed", "topic", topic, "id", n.ID(), "err", err)
+				}
+			}(n)
+		}
+		wg.Wait()
+
+		// Renew the registrations in time, or retry soon if nobody was found
+		wait := time.Duration(next - t.clock.Now())
+		if len(registrars) == 0 {
+			wait = topicSearchInterval
+		}
+		select {
+		case <-t.clock.After(wait):
+		case <-stop:
+			return
+		case <-t.closeCtx.Done():
+			return
+		}
+	}
+}
+
+// registerAt obtains a ticket from a registrar, waits for it to become valid
+// and registers the local node with it.
+func (t *UDPv5) registerAt(n *enode.Node, topic string, stop <-chan struct{}) error {
+	ticket, wait, err := t.requestTicket(n, topic)
+	if err != nil {
+		return err
+	}
+	if wait > maxTicketWait {
+		return errTicketWait
+	}
+	select {
+	case <-t.clock.After(wait):
+	case <-stop:
+		return nil
+	case <-t.closeCtx.Done():
+		return errClosed
+	}
+	registered, err := t.regtopic(n, ticket)
+	if err == nil && !registered {
+		err = errors.New("registration rejected")
+	}
+	return err
+}
+
+// TopicSearch returns an iterator over the nodes registered for the given topic.
+// The iterator can be used as a dial source of p2p.Server. It searches in rounds,
+// looking up the nodes closest to the topic and querying them for registrations.
+func (t *UDPv5) TopicSearch(topic string) enode.Iterator {
+	ctx, cancel := context.WithCancel(t.closeCtx)
+	return &topicIterator{t: t, topic: topic, ctx: ctx, cancel: cancel}
+}
+
+// topicIterator is the iterator returned by TopicSearch.
+type topicIterator struct {
+	t      *UDPv5
+	topic  string
+	ctx    context.Context
+	cancel func()
+
+	rounds     int
+	registrars []*enode.Node // registrars of the current round left to query
+	seen       map[enode.ID]bool
+	buffer     []*enode.Node
+}
+
+// Node returns the current node.
+func (it *topicIterator) Node() *enode.Node {
+	if len(it.buffer) == 0 {
+		return nil
+	}
+	return it.buffer[0]
+}
+
+// Next moves to the next node.
+func (it *topicIterator) Next() bool {
+	if len(it.buffer) > 0 {
+		it.buffer = it.buffer[1:]
+	}
+	for len(it.buffer) == 0 {
+		if it.ctx.Err() != nil {
+			it.buffer = nil
+			return false
+		}
+		if len(it.registrars) == 0 {
+			it.nextRound()
+			continue
+		}
+		n := it.registrars[0]
+		it.registrars = it.registrars[1:]
+
+		nodes, err := it.t.topicQuery(n, it.topic)
+		if err != nil {
+			it.t.log.Trace("Topic query failed", "topic", it.topic, "id", n.ID(), "err", err)
+		}
+		for _, rn := range nodes {
+			if !it.seen[rn.ID()] && rn.ID() != it.t.Self().ID() {
+				it.seen[rn.ID()] = true
+				it.buffer = append(it.buffer, rn)
+			}
+		}
+	}
+	return true
+}
+
+// nextRound starts a new search round, waiting a while if a round was run before.
+func (it *topicIterator) nextRound() {
+	if it.rounds > 0 {
+		select {
+		case <-it.t.clock.After(topicSearchInterval):
+		case <-it.ctx.Done():
+			return
+		}
+	}
+	it.rounds++
+	it.seen = make(map[enode.ID]bool)
+	it.registrars = it.t.newLookup(it.ctx, topicID(it.topic)).run()
+}
+
+// Close ends the iterator.
+func (it *topicIterator) Close() {
+	it.cancel()
+}
+
+// requestTicket calls REQUESTTICKET on a node and waits for a TICKET response.
+func (t *UDPv5) requestTicket(n *enode.Node, topic string) ([]byte, time.Duration, error) {
+	resp := t.call(n, v5wire.TicketMsg, &v5wire.RequestTicket{Topic: []byte(topic)})
+	defer t.callDone(resp)
+
+	select {
+	case p := <-resp.ch:
+		ticket := p.(*v5wire.Ticket)
+		return ticket.Ticket, time.Duration(ticket.WaitTime) * time.Millisecond, nil
+	case err := <-resp.err:
+		return nil, 0, err
+	}
+}
+
+// regtopic calls REGTOPIC on a node and waits for a REGCONFIRMATION response.
+func (t *UDPv5) regtopic(n *enode.Node, ticket []byte) (bool, error) {
+	req := &v5wire.Regtopic{Ticket: ticket, ENR: t.localNode.Node().Record()}
+	resp := t.call(n, v5wire.RegconfirmationMsg, req)
+	defer t.callDone(resp)
+
+	select {
+	case p := <-resp.ch:
+		return p.(*v5wire.Regconfirmation).Registered, nil
+	case err := <-resp.err:
+		return false, err
+	}
+}
+
+// topicQuery calls TOPICQUERY on a node and waits for NODES responses.
+func (t *UDPv5) topicQuery(n *enode.Node, topic string) ([]*enode.Node, error) {
+	resp := t.call(n, v5wire.NodesMsg, &v5wire.TopicQuery{Topic: []byte(topic)})
+	return t.waitForNodes(resp, nil)
+}
+
+// handleRequestTicket issues a registration ticket for the requested topic.
+func (t *UDPv5) handleRequestTicket(p *v5wire.RequestTicket, fromID enode.ID, fromAddr *net.UDPAddr) {
+	if !isTopic(p.Topic) {
+		t.log.Debug("Invalid topic in "+p.Name(), "id", fromID, "addr", fromAddr)
+		return
+	}
+	ticket, wait := t.topics.issueTicket(fromID, fromAddr.IP, string(p.Topic))
+	t.sendResponse(fromID, fromAddr, &v5wire.Ticket{
+		ReqID:    p.ReqID,
+		Ticket:   ticket,
+		WaitTime: uint(wait / time.Millisecond),
+	})
+}
+
+// handleRegtopic registers the sender for the topic of its ticket.
+func (t *UDPv5) handleRegtopic(p *v5wire.Regtopic, fromID enode.ID, fromAddr *net.UDPAddr) {
+	topic, err := t.topics.useTicket(p.Ticket, fromID, fromAddr.IP)
+	if err == nil {
+		err = t.registerNode(p, fromID, fromAddr, topic)
+	}
+	if err != nil {
+		t.log.Debug("Rejected "+p.Name(), "id", fromID, "addr", fromAddr, "err", err)
+	}
+	t.sendResponse(fromID, fromAddr, &v5wire.Regconfirmation{ReqID: p.ReqID, Registered: err == nil})
+}
+
+// registerNode validates the record in a REGTOPIC request and adds it to the
+// topic table. The record must match the endpoint the request was received from.
+func (t *UDPv5) registerNode(p *v5wire.Regtopic, fromID enode.ID, fromAddr *net.UDPAddr, topic string) error {
+	if p.ENR == nil {
+		return errors.New("missing record")
+	}
+	node, err := enode.New(t.validSchemes, p.ENR)
+	if err != nil {
+		return err
+	}
+	if node.ID() != fromID {
+		return errors.New("record ID mismatch")
+	}
+	if !node.IP().Equal(fromAddr.IP) || node.UDP() != fromAddr.Port {
+		return errors.New("record endpoint mismatch")
+	}
+	return t.topics.register(node, topic)
+}
+
+// handleTopicQuery returns the nodes registered for a topic to the requester.
+func (t *UDPv5) handleTopicQuery(p *v5wire.TopicQuery, fromID enode.ID, fromAddr *net.UDPAddr) {
+	var nodes []*enode.Node
+	for _, n := range t.topics.nodes(string(p.Topic), findnodeResultLimit) {
+		if n.ID() != fromID && netutil.CheckRelayIP(fromAddr.IP, n.IP()) == nil {
+			nodes = append(nodes, n)
+		}
+	}
+	for _, resp := range packNodes(p.ReqID, nodes) {
+		t.sendResponse(fromID, fromAddr, resp)
+	}
+}
+
+// isTopic reports whmxter the given byte slice is a valid topic name.
+func isTopic(topic []byte) bool {
+	return len(topic) > 0 && len(topic) <= maxTopicLength && !bytes.ContainsRune(topic, 0)
+}
@@ -0,0 +1,280 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package discover
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mxt/go-mxt/common/mclock"
+	"github.com/mxt/go-mxt/p2p/discover/v5wire"
+	"github.com/mxt/go-mxt/p2p/enode"
+	"github.com/mxt/go-mxt/p2p/enr"
+)
+
+func TestTopicTableTickets(t *testing.T) {
+	var (
+		clock = new(mclock.Simulated)
+		tab   = newTopicTable(clock)
+		id    = enode.ID{1}
+		ip    = net.IP{10, 0, 0, 1}
+	)
+	ticket, wait := tab.issueTicket(id, ip, "foo")
+	if wait != 0 {
+		t.Fatalf("wrong wait time for empty table: %v", wait)
+	}
+	// Tickets are bound to the node they were issued to.
+	if _, err := tab.useTicket(ticket, enode.ID{2}, ip); err != errTicketInvalid {
+		t.Fatalf("wrong error for ID mismatch: %v", err)
+	}
+	if _, err := tab.useTicket(ticket, id, net.IP{10, 0, 0, 2}); err != errTicketInvalid {
+		t.Fatalf("wrong error for IP mismatch: %v", err)
+	}
+	forged := append([]byte{}, ticket...)
+	forged[0]++
+	if _, err := tab.useTicket(forged, id, ip); err != errTicketInvalid {
+		t.Fatalf("wrong error for forged ticket: %v", err)
+	}
+	if topic, err := tab.useTicket(ticket, id, ip); err != nil || topic != "foo" {
+		t.Fatalf("valid ticket rejected: topic %q, err %v", topic, err)
+	}
+	// Tickets can only be used for a limited time.
+	clock.Run(ticketValidity + 1)
+	if _, err := tab.useTicket(ticket, id, ip); err != errTicketExpired {
+		t.Fatalf("wrong error for expired ticket: %v", err)
+	}
+}
+
+func TestTopicTableQueue(t *testing.T) {
+	var (
+		clock = new(mclock.Simulated)
+		tab   = newTopicTable(clock)
+		ip    = net.IP{10, 0, 0, 1}
+		nodes = make([]*enode.Node, topicQueueLimit+1)
+	)
+	for i := range nodes {
+		nodes[i] = enode.SignNull(new(enr.Record), enode.ID{byte(i), 1})
+	}
+	// Fill the topic queue.
+	for i, n := range nodes[:topicQueueLimit] {
+		if err := tab.register(n, "foo"); err != nil {
+			t.Fatalf("registration %d failed: %v", i, err)
+		}
+		clock.Run(time.Second)
+		if i == 0 {
+			clock.Run(time.Minute)
+		}
+	}
+	if n := len(tab.nodes("foo", 2*topicQueueLimit)); n != topicQueueLimit {
+		t.Fatalf("wrong number of registered nodes: have %d, want %d", n, topicQueueLimit)
+	}
+	// Registered nodes can renew immediately, others have to wait for a slot.
+	if _, wait := tab.issueTicket(nodes[1].ID(), ip, "foo"); wait != 0 {
+		t.Fatalf("wrong wait time for renewal: %v", wait)
+	}
+	last := nodes[topicQueueLimit]
+	ticket, wait := tab.issueTicket(last.ID(), ip, "foo")
+	if want := topicRegTTL - time.Minute - time.Duration(topicQueueLimit)*time.Second; wait != want {
+		t.Fatalf("wrong wait time for full queue: have %v, want %v", wait, want)
+	}
+	if _, err := tab.useTicket(ticket, last.ID(), ip); err != errTicketEarly {
+		t.Fatalf("wrong error for early ticket: %v", err)
+	}
+	// Registrations don't displace others while the queue is full.
+	if err := tab.register(last, "foo"); err != errTopicFull {
+		t.Fatalf("wrong error for full queue: %v", err)
+	}
+	if err := tab.register(nodes[1], "foo"); err != nil {
+		t.Fatalf("renewal rejected: %v", err)
+	}
+	clock.Run(wait)
+	if _, err := tab.useTicket(ticket, last.ID(), ip); err != nil {
+		t.Fatalf("ticket rejected after wait time: %v", err)
+	}
+	if err := tab.register(last, "foo"); err != nil {
+		t.Fatalf("registration rejected after wait time: %v", err)
+	}
+	for _, n := range tab.nodes("foo", 2*topicQueueLimit) {
+		if n.ID() == nodes[0].ID() {
+			t.Fatalf("oldest registration not expired")
+		}
+	}
+	// All registrations expire eventually.
+	clock.Run(topicRegTTL)
+	if n := len(tab.nodes("foo", topicQueueLimit)); n != 0 || tab.count != 0 {
+		t.Fatalf("registrations not expired: %d nodes, count %d", n, tab.count)
+	}
+}
+
+func TestTopicTableReservations(t *testing.T) {
+	var (
+		clock = new(mclock.Simulated)
+		tab   = newTopicTable(clock)
+		ip    = net.IP{10, 0, 0, 1}
+	)
+	// Fill all but two slots of the topic queue.
+	for i := 0; i < topicQueueLimit-2; i++ {
+		if err := tab.register(enode.SignNull(new(enr.Record), enode.ID{byte(i), 1}), "foo"); err != nil {
+			t.Fatalf("registration %d failed: %v", i, err)
+		}
+		clock.Run(time.Second)
+	}
+	clock.Run(topicRegTTL - time.Minute)
+
+	// Only as many tickets as there are free slots come without a wait.
+	for i := 0; i < 2; i++ {
+		if _, wait := tab.issueTicket(enode.ID{byte(i), 2}, ip, "foo"); wait != 0 {
+			t.Fatalf("ticket %d: wrong wait time for free slot: %v", i, wait)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		want := time.Minute - time.Duration(topicQueueLimit-2-i)*time.Second
+		if _, wait := tab.issueTicket(enode.ID{byte(i), 3}, ip, "foo"); wait != want {
+			t.Fatalf("ticket %d: wrong wait time for reserved slots: have %v, want %v", i, wait, want)
+		}
+	}
+	// Reissuing a ticket to a node replaces its reservation with one at the end.
+	want := time.Minute - time.Duration(topicQueueLimit-4)*time.Second
+	if _, wait := tab.issueTicket(enode.ID{0, 2}, ip, "foo"); wait != want {
+		t.Fatalf("wrong wait time for reissued ticket: have %v, want %v", wait, want)
+	}
+	if tab.pending != 5 {
+		t.Fatalf("wrong number of outstanding tickets: have %d, want 5", tab.pending)
+	}
+	// Using a ticket releases its reservation, unused ones lapse.
+	if err := tab.register(enode.SignNull(new(enr.Record), enode.ID{0, 2}), "foo"); err != nil {
+		t.Fatalf("registration with reserved slot failed: %v", err)
+	}
+	if tab.pending != 4 {
+		t.Fatalf("wrong number of outstanding tickets: have %d, want 4", tab.pending)
+	}
+	clock.Run(ticketValidity + 1)
+	tab.expire(clock.Now())
+	if tab.pending != 3 {
+		t.Fatalf("wrong number of outstanding tickets: have %d, want 3", tab.pending)
+	}
+}
+
+// This test checks that incoming REQUESTTICKET, REGTOPIC and TOPICQUERY calls
+// are handled correctly.
+func TestUDPv5_topicHandling(t *testing.T) {
+	t.Parallel()
+	test := newUDPV5Test(t)
+	defer test.close()
+
+	// Get a ticket and register with it.
+	var ticket []byte
+	test.packetIn(&v5wire.RequestTicket{ReqID: []byte("1"), Topic: []byte("foo")})
+	test.waitPacketOut(func(p *v5wire.Ticket, addr *net.UDPAddr, _ v5wire.Nonce) {
+		if !bytes.Equal(p.ReqID, []byte("1")) {
+			t.Error("wrong request ID in response:", p.ReqID)
+		}
+		if p.WaitTime != 0 {
+			t.Error("wrong wait time in response:", p.WaitTime)
+		}
+		ticket = p.Ticket
+	})
+	remote := test.getNode(test.remotekey, test.remoteaddr).Node()
+	test.packetIn(&v5wire.Regtopic{ReqID: []byte("2"), Ticket: ticket, ENR: remote.Record()})
+	test.waitPacketOut(func(p *v5wire.Regconfirmation, addr *net.UDPAddr, _ v5wire.Nonce) {
+		if !bytes.Equal(p.ReqID, []byte("2")) {
+			t.Error("wrong request ID in response:", p.ReqID)
+		}
+		if !p.Registered {
+			t.Error("registration rejected")
+		}
+	})
+
+	// Tickets can't be used by other nodes.
+	otherKey, otherAddr := newkey(), &net.UDPAddr{IP: net.IP{10, 0, 1, 98}, Port: 30303}
+	other := test.getNode(otherKey, otherAddr).Node()
+	test.packetInFrom(otherKey, otherAddr, &v5wire.Regtopic{ReqID: []byte("3"), Ticket: ticket, ENR: other.Record()})
+	test.waitPacketOut(func(p *v5wire.Regconfirmation, addr *net.UDPAddr, _ v5wire.Nonce) {
+		if p.Registered {
+			t.Error("registration with foreign ticket accepted")
+		}
+	})
+
+	// Records have to match the endpoint they are registered from.
+	movedAddr := &net.UDPAddr{IP: net.IP{10, 0, 1, 97}, Port: 30303}
+	test.packetInFrom(otherKey, movedAddr, &v5wire.RequestTicket{ReqID: []byte("6"), Topic: []byte("foo")})
+	test.waitPacketOut(func(p *v5wire.Ticket, addr *net.UDPAddr, _ v5wire.Nonce) {
+		ticket = p.Ticket
+	})
+	test.packetInFrom(otherKey, movedAddr, &v5wire.Regtopic{ReqID: []byte("7"), Ticket: ticket, ENR: other.Record()})
+	test.waitPacketOut(func(p *v5wire.Regconfirmation, addr *net.UDPAddr, _ v5wire.Nonce) {
+		if p.Registered {
+			t.Error("registration with mismatching record endpoint accepted")
+		}
+	})
+
+	// Registered nodes should be returned by TOPICQUERY.
+	test.packetInFrom(otherKey, otherAddr, &v5wire.TopicQuery{ReqID: []byte("4"), Topic: []byte("foo")})
+	test.expectNodes([]byte("4"), 1, []*enode.Node{remote})
+	test.packetInFrom(otherKey, otherAddr, &v5wire.TopicQuery{ReqID: []byte("5"), Topic: []byte("bar")})
+	test.expectNodes([]byte("5"), 1, nil)
+}
+
+// Real sockets, real crypto: this test checks that nodes can find each other
+// through topic advertisement.
+func TestUDPv5_topicE2E(t *testing.T) {
+	t.Parallel()
+
+	const N = 4
+	var nodes []*UDPv5
+	for i := 0; i < N; i++ {
+		var cfg Config
+		if len(nodes) > 0 {
+			bn := nodes[0].Self()
+			cfg.Bootnodes = []*enode.Node{bn}
+		}
+		node := startLocalhostV5(t, cfg)
+		nodes = append(nodes, node)
+		defer node.Close()
+	}
+	var (
+		topic     = "test"
+		advertise = nodes[1]
+		searcher  = nodes[N-1]
+		stop      = make(chan struct{})
+	)
+	defer close(stop)
+	go advertise.RegisterTopic(topic, stop)
+
+	// Wait for the registration to land at the bootnode.
+	for i := 0; ; i++ {
+		found, _ := searcher.topicQuery(nodes[0].Self(), topic)
+		if len(found) > 0 {
+			break
+		}
+		if i == 50 {
+			t.Fatal("topic registration not found at bootnode")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	it := searcher.TopicSearch(topic)
+	defer it.Close()
+	if !it.Next() {
+		t.Fatal("topic search ended")
+	}
+	if id := it.Node().ID(); id != advertise.Self().ID() {
+		t.Fatalf("wrong node found: %v", id)
+	}
+}
@@ -71,11 +71,21 @@ type UDPv5 struct {
 	log          log.Logger
 	clock        mclock.Clock
 	validSchemes enr.IdentityScheme
+	punchTCP     func(*enode.Node, *net.TCPAddr)
 
 	// talkreq handler registry
 	trlock     sync.Mutex
 	trhandlers map[string]func([]byte) []byte
 
+	// topic registrations, accessed by dispatch only
+	topics *topicTable
+
+	// hole punching state
+	punchMu     sync.Mutex
+	askedRelays map[enode.ID]mclock.AbsTime
+	relayLimit  *windowLimiter // accessed by dispatch only
+	punchLimit  *windowLimiter // accessed by dispatch only
+
 	// channels into dispatch
 	packetInCh    chan ReadPacket
 	readNextCh    chan struct{}
@@ -145,6 +155,7 @@ func newUDPv5(conn UDPConn, ln *enode.LocalNode, cfg Config) (*UDPv5, error) {
 		log:          cfg.Log,
 		validSchemes: cfg.ValidSchemes,
 		clock:        cfg.Clock,
+		punchTCP:     cfg.HolePunchTCP,
 		trhandlers:   make(map[string]func([]byte) []byte),
 		// channels into dispatch
 		packetInCh:    make(chan ReadPacket, 1),
@@ -157,6 +168,10 @@ func newUDPv5(conn UDPConn, ln *enode.LocalNode, cfg Config) (*UDPv5, error) {
 		activeCallByNode: make(map[enode.ID]*callV5),
 		activeCallByAuth: make(map[v5wire.Nonce]*callV5),
 		callQueue:        make(map[enode.ID][]*callV5),
+		topics:           newTopicTable(cfg.Clock),
+		askedRelays:      make(map[enode.ID]mclock.AbsTime),
+		relayLimit:       newWindowLimiter(cfg.Clock, maxRelaysInWindow),
+		punchLimit:       newWindowLimiter(cfg.Clock, maxPunchesInWindow),
 		// shutdown
 		closeCtx:       closeCtx,
 		cancelCloseCtx: cancelCloseCtx,
@@ -700,6 +715,22 @@ func (t *UDPv5) handle(p v5wire.Packet, fromID enode.ID, fromAddr *net.UDPAddr)
 		t.handleTalkRequest(p, fromID, fromAddr)
 	case *v5wire.TalkResponse:
 		t.handleCallResponse(fromID, fromAddr, p)
+	case *v5wire.RequestTicket:
+		t.handleRequestTicket(p, fromID, fromAddr)
+	case *v5wire.Ticket:
+		t.handleCallResponse(fromID, fromAddr, p)
+	case *v5wire.Regtopic:
+		t.handleRegtopic(p, fromID, fromAddr)
+	case *v5wire.Regconfirmation:
+		t.handleCallResponse(fromID, fromAddr, p)
+	case *v5wire.TopicQuery:
+		t.handleTopicQuery(p, fromID, fromAddr)
+	case *v5wire.RelayInit:
+		t.handleRelayInit(p, fromID, fromAddr)
+	case *v5wire.RelayRequest:
+		t.handleRelayRequest(p, fromID, fromAddr)
+	case *v5wire.RelayResponse:
+		t.handleCallResponse(fromID, fromAddr, p)
 	}
 }
 
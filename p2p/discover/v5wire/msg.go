@@ -47,6 +47,9 @@ const (
 	RegtopicMsg
 	RegconfirmationMsg
 	TopicQueryMsg
+	RelayInitMsg
+	RelayRequestMsg
+	RelayResponseMsg
 
 	UnknownPacket   = byte(255) // any non-decryptable packet
 	WhoareyouPacket = byte(254) // the WHOAREYOU packet
@@ -121,8 +124,9 @@ type (
 
 	// TICKET is the response to REQUESTTICKET.
 	Ticket struct {
-		ReqID  []byte
-		Ticket []byte
+		ReqID    []byte
+		Ticket   []byte
+		WaitTime uint // Milliseconds to wait before the ticket can be used
 	}
 
 	// REGTOPIC registers the sender in a topic queue using a ticket.
@@ -143,6 +147,28 @@ type (
 		ReqID []byte
 		Topic []byte
 	}
+
+	// RELAYINIT asks a relay to coordinate hole punching with the target node.
+	RelayInit struct {
+		ReqID  []byte
+		Target enode.ID
+		TCP    uint16 // TCP port of the initiator, zero if only UDP should be punched
+	}
+
+	// RELAYREQ is sent by the relay to the target of a RELAYINIT.
+	RelayRequest struct {
+		ReqID     []byte
+		Initiator *enr.Record
+		IP        net.IP // These fields hold the UDP envelope address of the RELAYINIT
+		Port      uint16 // packet, i.e. the external endpoint of the initiator.
+		TCP       uint16
+	}
+
+	// RELAYRESP is the reply to RELAYINIT.
+	RelayResponse struct {
+		ReqID     []byte
+		Forwarded bool // false if the target is unknown to the relay
+	}
 )
 
 // DecodeMessage decodes the message body of a packet.
@@ -171,6 +197,12 @@ func DecodeMessage(ptype byte, body []byte) (Packet, error) {
 		dec = new(Regconfirmation)
 	case TopicQueryMsg:
 		dec = new(TopicQuery)
+	case RelayInitMsg:
+		dec = new(RelayInit)
+	case RelayRequestMsg:
+		dec = new(RelayRequest)
+	case RelayResponseMsg:
+		dec = new(RelayResponse)
 	default:
 		return nil, fmt.Errorf("unknown packet type %d", ptype)
 	}
@@ -247,3 +279,18 @@ func (*TopicQuery) Name() string             { return "TOPICQUERY/v5" }
 func (*TopicQuery) Kind() byte               { return TopicQueryMsg }
 func (p *TopicQuery) RequestID() []byte      { return p.ReqID }
 func (p *TopicQuery) SetRequestID(id []byte) { p.ReqID = id }
+
+func (*RelayInit) Name() string             { return "RELAYINIT/v5" }
+func (*RelayInit) Kind() byte               { return RelayInitMsg }
+func (p *RelayInit) RequestID() []byte      { return p.ReqID }
+func (p *RelayInit) SetRequestID(id []byte) { p.ReqID = id }
+
+func (*RelayRequest) Name() string             { return "RELAYREQ/v5" }
+func (*RelayRequest) Kind() byte               { return RelayRequestMsg }
+func (p *RelayRequest) RequestID() []byte      { return p.ReqID }
+func (p *RelayRequest) SetRequestID(id []byte) { p.ReqID = id }
+
+func (*RelayResponse) Name() string             { return "RELAYRESP/v5" }
+func (*RelayResponse) Kind() byte               { return RelayResponseMsg }
+func (p *RelayResponse) RequestID() []byte      { return p.ReqID }
+func (p *RelayResponse) SetRequestID(id []byte) { p.ReqID = id }
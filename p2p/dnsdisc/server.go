@@ -0,0 +1,383 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package dnsdisc
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mxt/go-mxt/log"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	maxUDPMessageSize  = 512              // max UDP response size without EDNS
+	maxEDNSMessageSize = 4096             // max UDP response size with EDNS
+	maxTXTStringLength = 255              // max length of a single TXT character-string
+	tcpIdleTimeout     = 10 * time.Second // time after which idle TCP connections are closed
+)
+
+// ServerConfig can be used to configure the DNS server.
+type ServerConfig struct {
+	RootTTL  time.Duration // TTL of tree root records (default 30 min)
+	EntryTTL time.Duration // TTL of all other tree records (default 4 weeks)
+	Logger   log.Logger    // log messages go here
+}
+
+func (cfg ServerConfig) withDefaults() ServerConfig {
+	if cfg.RootTTL == 0 {
+		cfg.RootTTL = 30 * time.Minute
+	}
+	if cfg.EntryTTL == 0 {
+		cfg.EntryTTL = 4 * 7 * 24 * time.Hour
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = log.Root()
+	}
+	return cfg
+}
+
+// Server is an authoritative DNS server for node trees. It answers TXT queries for the
+// records of all trees it serves, over both UDP and TCP. Queries for names outside of
+// the served domains are refused.
+type Server struct {
+	cfg ServerConfig
+	udp net.PacketConn
+	tcp net.Listener
+	wg  sync.WaitGroup
+
+	mu      sync.RWMutex
+	zones   map[string]map[string]string // domain -> name -> TXT record
+	conns   map[net.Conn]struct{}        // active TCP connections
+	closing bool
+}
+
+// NewServer creates a DNS server. Call Start to begin serving.
+func NewServer(cfg ServerConfig) *Server {
+	return &Server{
+		cfg:   cfg.withDefaults(),
+		zones: make(map[string]map[string]string),
+		conns: make(map[net.Conn]struct{}),
+	}
+}
+
+// SetTree sets the tree served for the given domain, replacing any tree previously
+// served for it. It is safe to call SetTree while the server is running.
+func (s *Server) SetTree(domain string, t *Tree) {
+	domain = canonicalName(domain)
+	records := make(map[string]string)
+	for name, txt := range t.ToTXT(domain) {
+		records[canonicalName(name)] = txt
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.zones[domain] = records
+}
+
+// RemoveTree stops serving the tree of the given domain.
+func (s *Server) RemoveTree(domain string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.zones, canonicalName(domain))
+}
+
+// Start listens for queries on the given UDP and TCP address. If the port is zero,
+// a random port is chosen, which is the same for UDP and TCP.
+func (s *Server) Start(addr string) error {
+	udp, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	tcp, err := net.Listen("tcp", udp.LocalAddr().String())
+	if err != nil {
+		udp.Close()
+		return err
+	}
+	s.udp, s.tcp = udp, tcp
+	s.cfg.Logger.Info("DNS server started", "addr", udp.LocalAddr())
+
+	s.wg.Add(2)
+	go s.serveUDP()
+	go s.serveTCP()
+	return nil
+}
+
+// Addr returns the listening address of the server.
+func (s *Server) Addr() *net.UDPAddr {
+	return s.udp.LocalAddr().(*net.UDPAddr)
+}
+
+// Close stops the server.
+func (s *Server) Close() {
+	s.mu.Lock()
+	s.closing = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.udp.Close()
+	s.tcp.Close()
+	s.wg.Wait()
+}
+
+// serveUDP handles queries received over UDP.
+func (s *Server) serveUDP() {
+	defer s.wg.Done()
+
+	buf := make([]byte, maxEDNSMessageSize)
+	for {
+		n, addr, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			if !s.isClosing() {
+				s.cfg.Logger.Debug("DNS UDP read error", "err", err)
+			}
+			return
+		}
+		resp, err := s.handle(buf[:n], true)
+		if err != nil {
+			s.cfg.Logger.Trace("Ignoring invalid DNS query", "addr", addr, "err", err)
+			continue
+		}
+		s.udp.WriteTo(resp, addr)
+	}
+}
+
+// serveTCP accepts TCP connections and launches their handlers.
+func (s *Server) serveTCP() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			if !s.isClosing() {
+				s.cfg.Logger.Debug("DNS TCP accept error", "err", err)
+			}
+			return
+		}
+		s.mu.Lock()
+		if s.closing {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.serveConn(conn)
+	}
+}
+
+// serveConn handles length-prefixed queries on a TCP connection until it is closed
+// by the client, becomes idle or the server shuts down.
+func (s *Server) serveConn(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	var size [2]byte
+	for {
+		conn.SetDeadline(time.Now().Add(tcpIdleTimeout))
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		query := make([]byte, binary.BigEndian.Uint16(size[:]))
+		if _, err := io.ReadFull(conn, query); err != nil {
+			return
+		}
+		resp, err := s.handle(query, false)
+		if err != nil {
+			s.cfg.Logger.Trace("Invalid DNS query", "addr", conn.RemoteAddr(), "err", err)
+			return
+		}
+		binary.BigEndian.PutUint16(size[:], uint16(len(resp)))
+		if _, err := conn.Write(append(size[:], resp...)); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) isClosing() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.closing
+}
+
+// handle answers a single query. Responses to UDP queries which don't fit into
+// a datagram are truncated, and the client is expected to retry over TCP.
+func (s *Server) handle(query []byte, udp bool) ([]byte, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	if h.Response {
+		return nil, errors.New("not a query")
+	}
+	questions, err := p.AllQuestions()
+	if err != nil {
+		return nil, err
+	}
+	maxSize, edns := maxUDPMessageSize, false
+	if err := p.SkipAllAnswers(); err == nil {
+		if err := p.SkipAllAuthorities(); err == nil {
+			if opt := findOPT(&p); opt != nil {
+				edns = true
+				if size := int(opt.Class); size > maxSize {
+					maxSize = size
+				}
+				if maxSize > maxEDNSMessageSize {
+					maxSize = maxEDNSMessageSize
+				}
+			}
+		}
+	}
+
+	resp := dnsmessage.Message{
+		Header: dnsmessage.Header{
+			ID:               h.ID,
+			Response:         true,
+			OpCode:           h.OpCode,
+			Authoritative:    true,
+			RecursionDesired: h.RecursionDesired,
+			RCode:            dnsmessage.RCodeSuccess,
+		},
+		Questions: questions,
+	}
+	switch {
+	case h.OpCode != 0:
+		resp.Header.RCode = dnsmessage.RCodeNotImplemented
+	case len(questions) != 1:
+		resp.Header.RCode = dnsmessage.RCodeFormatError
+	default:
+		s.answer(&resp, questions[0])
+	}
+	if edns {
+		resp.Additionals = append(resp.Additionals, optResource())
+	}
+
+	msg, err := resp.Pack()
+	if err != nil {
+		return nil, err
+	}
+	if udp && len(msg) > maxSize {
+		resp.Header.Truncated = true
+		resp.Answers = nil
+		if msg, err = resp.Pack(); err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+// answer fills in the answer section and response code for the given question.
+func (s *Server) answer(resp *dnsmessage.Message, q dnsmessage.Question) {
+	if q.Class != dnsmessage.ClassINET && q.Class != dnsmessage.ClassANY {
+		resp.Header.RCode = dnsmessage.RCodeRefused
+		return
+	}
+	name := canonicalName(q.Name.String())
+	domain, txt, found := s.lookup(name)
+	switch {
+	case domain == "":
+		resp.Header.RCode = dnsmessage.RCodeRefused
+	case !found:
+		resp.Header.RCode = dnsmessage.RCodeNameError
+	case q.Type == dnsmessage.TypeTXT || q.Type == dnsmessage.TypeALL:
+		ttl := s.cfg.EntryTTL
+		if name == domain {
+			ttl = s.cfg.RootTTL
+		}
+		resp.Answers = append(resp.Answers, dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{
+				Name:  q.Name,
+				Type:  dnsmessage.TypeTXT,
+				Class: dnsmessage.ClassINET,
+				TTL:   uint32(ttl / time.Second),
+			},
+			Body: &dnsmessage.TXTResource{TXT: splitTXT(txt)},
+		})
+	}
+}
+
+// lookup finds the record with the given name. The returned domain is the served
+// domain containing the name, or empty if the name is outside of all served domains.
+// If served domains are nested, the name belongs to the innermost one.
+func (s *Server) lookup(name string) (domain, txt string, found bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for d := range s.zones {
+		if (name == d || strings.HasSuffix(name, "."+d)) && len(d) > len(domain) {
+			domain = d
+		}
+	}
+	if domain == "" {
+		return "", "", false
+	}
+	txt, found = s.zones[domain][name]
+	return domain, txt, found
+}
+
+// findOPT returns the header of the EDNS OPT record in the additional section.
+func findOPT(p *dnsmessage.Parser) *dnsmessage.ResourceHeader {
+	for {
+		h, err := p.AdditionalHeader()
+		if err != nil {
+			return nil
+		}
+		if h.Type == dnsmessage.TypeOPT {
+			return &h
+		}
+		if err := p.SkipAdditional(); err != nil {
+			return nil
+		}
+	}
+}
+
+// optResource creates the EDNS OPT record added to responses of EDNS queries.
+func optResource() dnsmessage.Resource {
+	var h dnsmessage.ResourceHeader
+	h.SetEDNS0(maxEDNSMessageSize, dnsmessage.RCodeSuccess, false)
+	return dnsmessage.Resource{Header: h, Body: &dnsmessage.OPTResource{}}
+}
+
+// splitTXT splits a record into character-strings of the maximum allowed length.
+// Clients concatenate the strings of a TXT record when reading it.
+func splitTXT(txt string) []string {
+	var parts []string
+	for len(txt) > maxTXTStringLength {
+		parts = append(parts, txt[:maxTXTStringLength])
+		txt = txt[maxTXTStringLength:]
+	}
+	return append(parts, txt)
+}
+
+// canonicalName converts a domain name to lower case and strips the trailing dot.
+func canonicalName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(name), ".")
+}
@@ -0,0 +1,162 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package dnsdisc
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/mxt/go-mxt/internal/testlog"
+	"github.com/mxt/go-mxt/log"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// This test checks that a tree served by Server can be synced over UDP and TCP. Large
+// records don't fit into UDP responses, so the resolver falls back to TCP for them.
+func TestServerSyncTree(t *testing.T) {
+	nodes := testNodes(nodesSeed1, 20)
+	tree, url := makeTestTree("nodes.example.org", nodes, nil)
+	srv := startTestServer(t)
+	defer srv.Close()
+	srv.SetTree("nodes.example.org", tree)
+
+	for _, network := range []string{"", "tcp"} {
+		c := NewClient(Config{
+			Resolver:  newServerResolver(srv, network),
+			Logger:    testlog.Logger(t, log.LvlTrace),
+			RateLimit: 500,
+		})
+		synced, err := c.SyncTree(url)
+		if err != nil {
+			t.Fatalf("network %q: sync error: %v", network, err)
+		}
+		if !reflect.DeepEqual(sortByID(synced.Nodes()), sortByID(nodes)) {
+			t.Errorf("network %q: wrong nodes in synced tree", network)
+		}
+	}
+}
+
+// This test checks the response codes for queries which can't be answered.
+func TestServerErrors(t *testing.T) {
+	tree, _ := makeTestTree("nodes.example.org", testNodes(nodesSeed1, 1), nil)
+	srv := startTestServer(t)
+	defer srv.Close()
+	srv.SetTree("nodes.example.org", tree)
+
+	tests := []struct {
+		name  string
+		qtype dnsmessage.Type
+		rcode dnsmessage.RCode
+		count int
+	}{
+		{"NODES.Example.org.", dnsmessage.TypeTXT, dnsmessage.RCodeSuccess, 1},
+		{"nodes.example.org.", dnsmessage.TypeA, dnsmessage.RCodeSuccess, 0},
+		{"missing.nodes.example.org.", dnsmessage.TypeTXT, dnsmessage.RCodeNameError, 0},
+		{"example.org.", dnsmessage.TypeTXT, dnsmessage.RCodeRefused, 0},
+	}
+	for _, test := range tests {
+		q := dnsmessage.Message{
+			Header: dnsmessage.Header{ID: 1},
+			Questions: []dnsmessage.Question{{
+				Name:  dnsmessage.MustNewName(test.name),
+				Type:  test.qtype,
+				Class: dnsmessage.ClassINET,
+			}},
+		}
+		query, _ := q.Pack()
+		enc, err := srv.handle(query, true)
+		if err != nil {
+			t.Fatalf("%s: handle error: %v", test.name, err)
+		}
+		var resp dnsmessage.Message
+		if err := resp.Unpack(enc); err != nil {
+			t.Fatalf("%s: invalid response: %v", test.name, err)
+		}
+		if resp.Header.RCode != test.rcode {
+			t.Errorf("%s: wrong rcode %v, want %v", test.name, resp.Header.RCode, test.rcode)
+		}
+		if !resp.Header.Authoritative {
+			t.Errorf("%s: response not authoritative", test.name)
+		}
+		if len(resp.Answers) != test.count {
+			t.Errorf("%s: wrong answer count %d, want %d", test.name, len(resp.Answers), test.count)
+		}
+	}
+}
+
+// This test checks that records of nested domains are served from the innermost
+// domain containing them.
+func TestServerNestedZones(t *testing.T) {
+	parent, _ := makeTestTree("example.org", testNodes(nodesSeed1, 5), nil)
+	child, _ := makeTestTree("nodes.example.org", testNodes(nodesSeed2, 5), nil)
+	srv := startTestServer(t)
+	defer srv.Close()
+	srv.SetTree("example.org", parent)
+	srv.SetTree("nodes.example.org", child)
+
+	zones := map[string]*Tree{"example.org": parent, "nodes.example.org": child}
+	// Map iteration order is random, check a few times to cover the zone order.
+	for i := 0; i < 20; i++ {
+		for zone, tree := range zones {
+			for name, want := range tree.ToTXT(zone) {
+				domain, txt, found := srv.lookup(canonicalName(name))
+				if domain != zone || !found || txt != want {
+					t.Fatalf("%s: wrong lookup result: domain %q, found %t, record %q", name, domain, found, txt)
+				}
+			}
+		}
+	}
+}
+
+func TestSplitTXT(t *testing.T) {
+	long := make([]byte, 2*maxTXTStringLength+1)
+	for i := range long {
+		long[i] = 'a'
+	}
+	parts := splitTXT(string(long))
+	if len(parts) != 3 || len(parts[0]) != maxTXTStringLength || len(parts[2]) != 1 {
+		t.Fatalf("wrong split: %d parts", len(parts))
+	}
+	if parts := splitTXT("short"); !reflect.DeepEqual(parts, []string{"short"}) {
+		t.Fatalf("wrong split of short record: %q", parts)
+	}
+}
+
+func startTestServer(t *testing.T) *Server {
+	srv := NewServer(ServerConfig{Logger: testlog.Logger(t, log.LvlTrace)})
+	if err := srv.Start("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+// newServerResolver creates a resolver which sends all queries to the given server.
+// If network is empty, the resolver chooses the network itself.
+func newServerResolver(srv *Server, network string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, defaultNetwork, _ string) (net.Conn, error) {
+			var d net.Dialer
+			if network != "" {
+				defaultNetwork = network
+			}
+			return d.DialContext(ctx, defaultNetwork, srv.Addr().String())
+		},
+	}
+}
@@ -19,6 +19,9 @@ package enode
 import (
 	"sync"
 	"time"
+
+	"github.com/mxt/go-mxt/p2p/enr"
+	"github.com/mxt/go-mxt/rlp"
 )
 
 // Iterator represents a sequence of nodes. The Next mmxtod moves to the next node in the
@@ -123,6 +126,48 @@ func (f *filterIter) Next() bool {
 	return false
 }
 
+// HasEntry returns a filter function which accepts nodes whose record contains
+// the given key, regardless of its value.
+func HasEntry(key string) func(*Node) bool {
+	return func(n *Node) bool {
+		var value rlp.RawValue
+		return n.Load(enr.WithEntry(key, &value)) == nil
+	}
+}
+
+// AllOf returns a filter function which accepts nodes accepted by all of the
+// given checks.
+func AllOf(checks ...func(*Node) bool) func(*Node) bool {
+	return func(n *Node) bool {
+		for _, check := range checks {
+			if !check(n) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// AnyOf returns a filter function which accepts nodes accepted by at least one
+// of the given checks.
+func AnyOf(checks ...func(*Node) bool) func(*Node) bool {
+	return func(n *Node) bool {
+		for _, check := range checks {
+			if check(n) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Not returns a filter function which accepts nodes rejected by the given check.
+func Not(check func(*Node) bool) func(*Node) bool {
+	return func(n *Node) bool {
+		return !check(n)
+	}
+}
+
 // FairMix aggregates multiple node iterators. The mixer itself is an iterator which ends
 // only when Close is called. Source iterators added via AddSource are removed from the
 // mix when they end.
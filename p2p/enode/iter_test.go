@@ -70,6 +70,43 @@ func TestFilterNodes(t *testing.T) {
 	}
 }
 
+func TestFilterEntries(t *testing.T) {
+	var (
+		plain = testNode(0, 0)
+		foo   = testNodeWith(1, enr.WithEntry("foo", uint(1)))
+		bar   = testNodeWith(2, enr.WithEntry("bar", uint(2)))
+		both  = testNodeWith(3, enr.WithEntry("foo", uint(3)), enr.WithEntry("bar", uint(3)))
+	)
+	tests := []struct {
+		name   string
+		check  func(*Node) bool
+		accept []*Node
+	}{
+		{"HasEntry", HasEntry("foo"), []*Node{foo, both}},
+		{"AllOf", AllOf(HasEntry("foo"), HasEntry("bar")), []*Node{both}},
+		{"AnyOf", AnyOf(HasEntry("foo"), HasEntry("bar")), []*Node{foo, bar, both}},
+		{"Not", Not(HasEntry("foo")), []*Node{plain, bar}},
+		{"AllOf-empty", AllOf(), []*Node{plain, foo, bar, both}},
+		{"AnyOf-empty", AnyOf(), nil},
+	}
+	for _, test := range tests {
+		it := Filter(IterNodes([]*Node{plain, foo, bar, both}), test.check)
+		var accepted []*Node
+		for it.Next() {
+			accepted = append(accepted, it.Node())
+		}
+		if len(accepted) != len(test.accept) {
+			t.Errorf("%s: accepted %d nodes, want %d", test.name, len(accepted), len(test.accept))
+			continue
+		}
+		for i := range accepted {
+			if accepted[i] != test.accept[i] {
+				t.Errorf("%s: wrong node %d: %v, want %v", test.name, i, accepted[i].ID(), test.accept[i].ID())
+			}
+		}
+	}
+}
+
 func checkNodes(t *testing.T, nodes []*Node, wantLen int) {
 	if len(nodes) != wantLen {
 		t.Errorf("slice has %d nodes, want %d", len(nodes), wantLen)
@@ -279,6 +316,16 @@ func testNode(id, seq uint64) *Node {
 	return SignNull(r, nodeID)
 }
 
+func testNodeWith(id uint64, entries ...enr.Entry) *Node {
+	var nodeID ID
+	binary.BigEndian.PutUint64(nodeID[:], id)
+	r := new(enr.Record)
+	for _, e := range entries {
+		r.Set(e)
+	}
+	return SignNull(r, nodeID)
+}
+
 // callCountIter counts calls to NextNode.
 type callCountIter struct {
 	Iterator
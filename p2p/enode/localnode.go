@@ -183,6 +183,19 @@ func (ln *LocalNode) SetFallbackUDP(port int) {
 	ln.updateEndpoints()
 }
 
+// SetEndpointConsensus enables or disables consensus-based endpoint prediction. When
+// enabled, the predicted endpoint must be reported by a majority of distinct networks
+// before it is put into the record. This is useful for hosts behind carrier-grade NAT,
+// where the endpoint cannot be determined locally.
+func (ln *LocalNode) SetEndpointConsensus(enabled bool) {
+	ln.mu.Lock()
+	defer ln.mu.Unlock()
+
+	ln.endpoint4.track.SetConsensus(enabled)
+	ln.endpoint6.track.SetConsensus(enabled)
+	ln.updateEndpoints()
+}
+
 // UDPEndpointStatement should be called whenever a statement about the local node's
 // UDP endpoint is received. It feeds the local endpoint predictor.
 func (ln *LocalNode) UDPEndpointStatement(fromaddr, endpoint *net.UDPAddr) {
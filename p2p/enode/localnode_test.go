@@ -120,3 +120,34 @@ func TestLocalNodeEndpoint(t *testing.T) {
 	assert.Equal(t, fallback.Port, ln.Node().UDP())
 	assert.Equal(t, uint64(4), ln.Node().Seq())
 }
+
+// This test checks that consensus-based prediction ignores statements from a single
+// network and follows the endpoint reported by a majority of networks.
+func TestLocalNodeEndpointConsensus(t *testing.T) {
+	var (
+		fallback  = &net.UDPAddr{IP: net.IP{127, 0, 0, 1}, Port: 80}
+		predicted = &net.UDPAddr{IP: net.IP{127, 0, 1, 2}, Port: 81}
+		bogus     = &net.UDPAddr{IP: net.IP{127, 0, 1, 3}, Port: 82}
+	)
+	ln, db := newLocalNodeForTesting()
+	defer db.Close()
+	ln.SetFallbackIP(fallback.IP)
+	ln.SetFallbackUDP(fallback.Port)
+	ln.SetEndpointConsensus(true)
+
+	// Many statements from hosts in the same network don't change the record.
+	for i := 0; i < 2*iptrackMinStatements; i++ {
+		from := &net.UDPAddr{IP: net.IP{10, 0, 0, byte(i + 1)}, Port: 90}
+		ln.UDPEndpointStatement(from, bogus)
+	}
+	assert.Equal(t, fallback.IP, ln.Node().IP())
+	assert.Equal(t, fallback.Port, ln.Node().UDP())
+
+	// Statements from distinct networks do.
+	for i := 0; i < iptrackMinStatements; i++ {
+		from := &net.UDPAddr{IP: net.IP{10, byte(i + 1), 0, 1}, Port: 90}
+		ln.UDPEndpointStatement(from, predicted)
+	}
+	assert.Equal(t, predicted.IP, ln.Node().IP())
+	assert.Equal(t, predicted.Port, ln.Node().UDP())
+}
@@ -26,6 +26,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/mxt/go-mxt/common/mclock"
 	"github.com/mxt/go-mxt/rlp"
 	"github.com/syndtr/goleveldb/leveldb"
 	"github.com/syndtr/goleveldb/leveldb/errors"
@@ -40,6 +41,7 @@ const (
 	dbVersionKey   = "version" // Version of the database to flush if changes
 	dbNodePrefix   = "n:"      // Identifier to prefix node entries with
 	dbLocalPrefix  = "local:"
+	dbBanPrefix    = "ban:" // Identifier to prefix ban entries with
 	dbDiscoverRoot = "v4"
 	dbDiscv5Root   = "v5"
 
@@ -53,6 +55,11 @@ const (
 	// Local information is keyed by ID only, the full key is "local:<ID>:seq".
 	// Use localItemKey to create those keys.
 	dbLocalSeq = "seq"
+
+	// Bans are keyed by node ID or IP, the full key is "ban:id:<ID>" or "ban:ip:<IP>".
+	// Use banKey to create those keys.
+	dbBanID = "id"
+	dbBanIP = "ip"
 )
 
 const (
@@ -67,6 +74,7 @@ var zeroIP = make(net.IP, 16)
 // them for QoS purposes.
 type DB struct {
 	lvl    *leveldb.DB   // Interface to the database itself
+	clock  mclock.Clock  // Clock the stored bans are measured against
 	runner sync.Once     // Ensures we can start at most one expirer
 	quit   chan struct{} // Channel to signal the expiring thread to stop
 }
@@ -86,7 +94,7 @@ func newMemoryDB() (*DB, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &DB{lvl: db, quit: make(chan struct{})}, nil
+	return &DB{lvl: db, clock: mclock.System{}, quit: make(chan struct{})}, nil
 }
 
 // newPersistentNodeDB creates/opens a leveldb backed persistent node database,
@@ -124,7 +132,7 @@ func newPersistentDB(path string) (*DB, error) {
 			return newPersistentDB(path)
 		}
 	}
-	return &DB{lvl: db, quit: make(chan struct{})}, nil
+	return &DB{lvl: db, clock: mclock.System{}, quit: make(chan struct{})}, nil
 }
 
 // nodeKey returns the database key for a node record.
@@ -191,6 +199,14 @@ func localItemKey(id ID, field string) []byte {
 	return key
 }
 
+// banKey returns the key of a ban entry for a node ID or IP.
+func banKey(kind string, subject []byte) []byte {
+	key := append([]byte(dbBanPrefix), kind...)
+	key = append(key, ':')
+	key = append(key, subject...)
+	return key
+}
+
 // fetchInt64 retrieves an integer associated with a particular key.
 func (db *DB) fetchInt64(key []byte) int64 {
 	blob, err := db.lvl.Get(key, nil)
@@ -311,6 +327,7 @@ func (db *DB) expirer() {
 		select {
 		case <-tick.C:
 			db.expireNodes()
+			db.expireBans(mclock.WallTime(db.clock))
 		case <-db.quit:
 			return
 		}
@@ -356,6 +373,18 @@ func (db *DB) expireNodes() {
 	}
 }
 
+// expireBans deletes all bans which have run out at the given time.
+func (db *DB) expireBans(now time.Time) {
+	it := db.lvl.NewIterator(util.BytesPrefix([]byte(dbBanPrefix)), nil)
+	defer it.Release()
+
+	for it.Next() {
+		if until, _ := binary.Varint(it.Value()); until <= now.Unix() {
+			db.lvl.Delete(it.Key(), nil)
+		}
+	}
+}
+
 // LastPingReceived retrieves the time of the last ping packet received from
 // a remote node.
 func (db *DB) LastPingReceived(id ID, ip net.IP) time.Time {
@@ -399,6 +428,50 @@ func (db *DB) UpdateFindFailsV5(id ID, ip net.IP, fails int) error {
 	return db.storeInt64(v5Key(id, ip, dbNodeFindFails), int64(fails))
 }
 
+// SetClock sets the clock the ban expiry is measured against. It must match the
+// clock used to compute the stored ban times and be set before the database is used.
+func (db *DB) SetClock(clock mclock.Clock) {
+	db.clock = clock
+}
+
+// NodeBan retrieves the time until which a node is banned. The returned time
+// lies in the past if the node isn't banned.
+func (db *DB) NodeBan(id ID) time.Time {
+	return time.Unix(db.fetchInt64(banKey(dbBanID, id[:])), 0)
+}
+
+// UpdateNodeBan bans a node until the given time. A zero time lifts the ban.
+func (db *DB) UpdateNodeBan(id ID, until time.Time) error {
+	return db.storeBan(banKey(dbBanID, id[:]), until)
+}
+
+// IPBan retrieves the time until which an IP address is banned. The returned
+// time lies in the past if the address isn't banned.
+func (db *DB) IPBan(ip net.IP) time.Time {
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return time.Unix(0, 0)
+	}
+	return time.Unix(db.fetchInt64(banKey(dbBanIP, ip16)), 0)
+}
+
+// UpdateIPBan bans an IP address until the given time. A zero time lifts the ban.
+func (db *DB) UpdateIPBan(ip net.IP, until time.Time) error {
+	ip16 := ip.To16()
+	if ip16 == nil {
+		return fmt.Errorf("invalid IP (length %d)", len(ip))
+	}
+	return db.storeBan(banKey(dbBanIP, ip16), until)
+}
+
+// storeBan stores or, if the given time is zero, deletes a ban entry.
+func (db *DB) storeBan(key []byte, until time.Time) error {
+	if until.IsZero() {
+		return db.lvl.Delete(key, nil)
+	}
+	return db.storeInt64(key, until.Unix())
+}
+
 // LocalSeq retrieves the local record sequence counter.
 func (db *DB) localSeq(id ID) uint64 {
 	return db.fetchUint64(localItemKey(id, dbLocalSeq))
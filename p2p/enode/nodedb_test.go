@@ -473,3 +473,51 @@ func TestDBExpireV5(t *testing.T) {
 	db.UpdateFindFailsV5(ID{}, ip, 4)
 	db.expireNodes()
 }
+
+// This test checks that node and IP bans are stored, lifted and expired.
+func TestDBBans(t *testing.T) {
+	db, _ := OpenDB("")
+	defer db.Close()
+
+	var (
+		id    = ID{0x01}
+		ip    = net.IP{10, 0, 0, 1}
+		now   = time.Now().Truncate(time.Second)
+		until = now.Add(time.Hour)
+	)
+	if ban := db.NodeBan(id); ban.After(now) {
+		t.Fatalf("fresh node banned until %v", ban)
+	}
+	if err := db.UpdateNodeBan(id, until); err != nil {
+		t.Fatalf("failed to ban node: %v", err)
+	}
+	if err := db.UpdateIPBan(ip, until); err != nil {
+		t.Fatalf("failed to ban IP: %v", err)
+	}
+	if ban := db.NodeBan(id); !ban.Equal(until) {
+		t.Errorf("node ban mismatch: have %v, want %v", ban, until)
+	}
+	if ban := db.IPBan(ip); !ban.Equal(until) {
+		t.Errorf("IP ban mismatch: have %v, want %v", ban, until)
+	}
+	if ban := db.IPBan(ip.To16()); !ban.Equal(until) {
+		t.Errorf("16 byte IP ban mismatch: have %v, want %v", ban, until)
+	}
+	// Bans must not interfere with node expiration and should survive it.
+	db.expireNodes()
+	db.expireBans(now)
+	if ban := db.NodeBan(id); !ban.Equal(until) {
+		t.Errorf("node ban mismatch after expiration: have %v, want %v", ban, until)
+	}
+	// Lift the node ban and let the IP one run out.
+	if err := db.UpdateNodeBan(id, time.Time{}); err != nil {
+		t.Fatalf("failed to lift node ban: %v", err)
+	}
+	db.expireBans(until)
+	if ban := db.NodeBan(id); ban.After(now) {
+		t.Errorf("lifted node ban still active until %v", ban)
+	}
+	if ban := db.IPBan(ip); !ban.Equal(time.Unix(0, 0)) {
+		t.Errorf("expired IP ban still present: %v", ban)
+	}
+}
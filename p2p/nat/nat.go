@@ -59,6 +59,7 @@ type Interface interface {
 //     "upnp"               uses the Universal Plug and Play protocol
 //     "pmp"                uses NAT-PMP with an auto-detected gateway address
 //     "pmp:192.168.0.1"    uses NAT-PMP with the given gateway address
+//     "punch"              learns the external endpoint from discovery and uses hole punching
 func Parse(spec string) (Interface, error) {
 	var (
 		parts = strings.SplitN(spec, ":", 2)
@@ -85,6 +86,8 @@ func Parse(spec string) (Interface, error) {
 		return UPnP(), nil
 	case "pmp", "natpmp", "nat-pmp":
 		return PMP(ip), nil
+	case "punch", "holepunch":
+		return HolePunch{}, nil
 	default:
 		return nil, fmt.Errorf("unknown mechanism %q", parts[0])
 	}
@@ -138,6 +141,22 @@ func (n ExtIP) String() string              { return fmt.Sprintf("ExtIP(%v)", ne
 func (ExtIP) AddMapping(string, int, int, string, time.Duration) error { return nil }
 func (ExtIP) DeleteMapping(string, int, int) error                     { return nil }
 
+// HolePunch is meant for machines behind NAT which can't be configured, such as
+// carrier-grade NAT. The external endpoint is learned from the statements of other
+// nodes, and connections between two such machines are established by UDP and TCP
+// hole punching. Mapping operations do nothing and ExternalIP always fails.
+type HolePunch struct{}
+
+var errHolePunchIP = errors.New("external IP is learned from discovery")
+
+func (HolePunch) ExternalIP() (net.IP, error) { return nil, errHolePunchIP }
+func (HolePunch) String() string              { return "HolePunch" }
+
+// These do nothing.
+
+func (HolePunch) AddMapping(string, int, int, string, time.Duration) error { return nil }
+func (HolePunch) DeleteMapping(string, int, int) error                     { return nil }
+
 // Any returns a port mapper that tries to discover any supported
 // mechanism on the local network.
 func Any() Interface {
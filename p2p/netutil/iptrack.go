@@ -17,6 +17,7 @@
 package netutil
 
 import (
+	"net"
 	"time"
 
 	"github.com/mxt/go-mxt/common/mclock"
@@ -28,6 +29,7 @@ type IPTracker struct {
 	window          time.Duration
 	contactWindow   time.Duration
 	minStatements   int
+	consensus       bool
 	clock           mclock.Clock
 	statements      map[string]ipStatement
 	contact         map[string]mclock.AbsTime
@@ -73,9 +75,22 @@ func (it *IPTracker) PredictFullConeNAT() bool {
 	return false
 }
 
+// SetConsensus enables or disables consensus mode. In consensus mode, statements are
+// grouped by the network of the reporting host and each network has a single vote,
+// which is the most recent statement made by any host in it. An endpoint is only
+// predicted when it is backed by at least minStatements networks and by a strict
+// majority of all voting networks. This makes the prediction robust against a group of
+// hosts in a single network reporting a bogus endpoint.
+func (it *IPTracker) SetConsensus(enabled bool) {
+	it.consensus = enabled
+}
+
 // PredictEndpoint returns the current prediction of the external endpoint.
 func (it *IPTracker) PredictEndpoint() string {
 	it.gcStatements(it.clock.Now())
+	if it.consensus {
+		return it.predictConsensus()
+	}
 
 	// The current strategy is simple: find the endpoint with most statements.
 	counts := make(map[string]int)
@@ -90,6 +105,47 @@ func (it *IPTracker) PredictEndpoint() string {
 	return max
 }
 
+// predictConsensus finds the endpoint backed by a majority of reporter networks.
+func (it *IPTracker) predictConsensus() string {
+	votes := make(map[string]ipStatement)
+	for host, s := range it.statements {
+		nw := hostNetwork(host)
+		if v, ok := votes[nw]; !ok || s.time > v.time {
+			votes[nw] = s
+		}
+	}
+	counts := make(map[string]int)
+	maxcount, max := 0, ""
+	for _, v := range votes {
+		c := counts[v.endpoint] + 1
+		counts[v.endpoint] = c
+		if c > maxcount {
+			maxcount, max = c, v.endpoint
+		}
+	}
+	if maxcount < it.minStatements || maxcount*2 <= len(votes) {
+		return ""
+	}
+	return max
+}
+
+// hostNetwork returns the /24 (IPv4) or /48 (IPv6) network of a host. Hosts which are
+// not IP addresses are returned unchanged.
+func hostNetwork(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+		return host
+	case ip.To4() != nil:
+		return ip.Mask(net.CIDRMask(24, 32)).String()
+	default:
+		return ip.Mask(net.CIDRMask(48, 128)).String()
+	}
+}
+
 // AddStatement records that a certain host thinks our external endpoint is the one given.
 func (it *IPTracker) AddStatement(host, endpoint string) {
 	now := it.clock.Now()
@@ -78,16 +78,53 @@ func TestIPTracker(t *testing.T) {
 		},
 	}
 	for name, test := range tests {
-		t.Run(name, func(t *testing.T) { runIPTrackerTest(t, test) })
+		t.Run(name, func(t *testing.T) { runIPTrackerTest(t, test, false) })
 	}
 }
 
-func runIPTrackerTest(t *testing.T, evs []iptrackTestEvent) {
+func TestIPTrackerConsensus(t *testing.T) {
+	tests := map[string][]iptrackTestEvent{
+		"sameNetwork": {
+			{opStatement, 0, "127.0.0.1", "10.0.1.2:30303"},
+			{opStatement, 0, "127.0.0.1", "10.0.1.3:30303"},
+			{opStatement, 0, "127.0.0.1", "10.0.1.4:30303"},
+			{opPredict, 1000, "", ""}, // only one network voted
+			{opStatement, 1000, "127.0.0.1", "10.0.2.2:30303"},
+			{opStatement, 1000, "127.0.0.1", "10.0.3.2:30303"},
+			{opPredict, 1000, "127.0.0.1", ""},
+		},
+		"latestVote": {
+			{opStatement, 0, "127.0.0.2", "10.0.1.2:30303"},
+			{opStatement, 0, "127.0.0.1", "10.0.2.2:30303"},
+			{opStatement, 0, "127.0.0.1", "10.0.3.2:30303"},
+			{opPredict, 1000, "", ""},
+			{opStatement, 1000, "127.0.0.1", "10.0.1.3:30303"}, // replaces vote of 10.0.1.0/24
+			{opPredict, 1000, "127.0.0.1", ""},
+		},
+		"majority": {
+			{opStatement, 0, "127.0.0.1", "10.0.1.2:30303"},
+			{opStatement, 0, "127.0.0.1", "10.0.2.2:30303"},
+			{opStatement, 0, "127.0.0.1", "10.0.3.2:30303"},
+			{opStatement, 0, "127.0.0.2", "10.0.4.2:30303"},
+			{opStatement, 0, "127.0.0.2", "10.0.5.2:30303"},
+			{opStatement, 0, "127.0.0.2", "10.0.6.2:30303"},
+			{opPredict, 1000, "", ""}, // tie
+			{opStatement, 1000, "127.0.0.1", "10.0.7.2:30303"},
+			{opPredict, 1000, "127.0.0.1", ""},
+		},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) { runIPTrackerTest(t, test, true) })
+	}
+}
+
+func runIPTrackerTest(t *testing.T, evs []iptrackTestEvent, consensus bool) {
 	var (
 		clock mclock.Simulated
 		it    = NewIPTracker(10*time.Second, 10*time.Second, 3)
 	)
 	it.clock = &clock
+	it.SetConsensus(consensus)
 	for i, ev := range evs {
 		evtime := time.Duration(ev.time) * time.Millisecond
 		clock.Run(evtime - time.Duration(clock.Now()))
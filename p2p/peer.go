@@ -107,6 +107,7 @@ type Peer struct {
 	rw      *conn
 	running map[string]*protoRW
 	log     log.Logger
+	clock   mclock.Clock
 	created mclock.AbsTime
 
 	wg       sync.WaitGroup
@@ -116,6 +117,9 @@ type Peer struct {
 
 	// events receives message send / receive events if set
 	events *event.Feed
+
+	// srv tracks the reputation of the peer, nil for test peers
+	srv *Server
 }
 
 // NewPeer returns a peer for testing purposes.
@@ -123,7 +127,7 @@ func NewPeer(id enode.ID, name string, caps []Cap) *Peer {
 	pipe, _ := net.Pipe()
 	node := enode.SignNull(new(enr.Record), id)
 	conn := &conn{fd: pipe, transport: nil, node: node, caps: caps, name: name}
-	peer := newPeer(log.Root(), conn, nil)
+	peer := newPeer(log.Root(), mclock.System{}, conn, nil)
 	close(peer.closed) // ensures Disconnect doesn't block
 	return peer
 }
@@ -177,6 +181,15 @@ func (p *Peer) Disconnect(reason DiscReason) {
 	}
 }
 
+// Report adjusts the reputation of the peer by the given amount, positive for
+// useful and negative for faulty behaviour. Peers whose score drops too low are
+// disconnected and temporarily banned.
+func (p *Peer) Report(delta float64, reason string) {
+	if p.srv != nil {
+		p.srv.reportPeer(p, delta, reason)
+	}
+}
+
 // String implements fmt.Stringer.
 func (p *Peer) String() string {
 	id := p.ID()
@@ -188,12 +201,13 @@ func (p *Peer) Inbound() bool {
 	return p.rw.is(inboundConn)
 }
 
-func newPeer(log log.Logger, conn *conn, protocols []Protocol) *Peer {
+func newPeer(log log.Logger, clock mclock.Clock, conn *conn, protocols []Protocol) *Peer {
 	protomap := matchProtocols(protocols, conn.caps, conn)
 	p := &Peer{
 		rw:       conn,
 		running:  protomap,
-		created:  mclock.Now(),
+		clock:    clock,
+		created:  clock.Now(),
 		disc:     make(chan DiscReason),
 		protoErr: make(chan error, len(protomap)+1), // protocols + pingLoop
 		closed:   make(chan struct{}),
@@ -257,12 +271,12 @@ loop:
 }
 
 func (p *Peer) pingLoop() {
-	ping := time.NewTimer(pingInterval)
+	ping := p.clock.NewTimer(pingInterval)
 	defer p.wg.Done()
 	defer ping.Stop()
 	for {
 		select {
-		case <-ping.C:
+		case <-ping.C():
 			if err := SendItems(p.rw, pingMsg); err != nil {
 				p.protoErr <- err
 				return
@@ -282,7 +296,7 @@ func (p *Peer) readLoop(errc chan<- error) {
 			errc <- err
 			return
 		}
-		msg.ReceivedAt = time.Now()
+		msg.ReceivedAt = mclock.WallTime(p.clock)
 		if err = p.handle(msg); err != nil {
 			errc <- err
 			return
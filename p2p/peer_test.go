@@ -28,6 +28,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/mxt/go-mxt/common/mclock"
 	"github.com/mxt/go-mxt/log"
 	"github.com/mxt/go-mxt/p2p/enode"
 	"github.com/mxt/go-mxt/p2p/enr"
@@ -100,7 +101,7 @@ func testPeer(protos []Protocol) (func(), *conn, *Peer, <-chan error) {
 		c2.caps = append(c2.caps, p.cap())
 	}
 
-	peer := newPeer(log.Root(), c1, protos)
+	peer := newPeer(log.Root(), mclock.System{}, c1, protos)
 	errc := make(chan error, 1)
 	go func() {
 		_, err := peer.run()
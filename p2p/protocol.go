@@ -59,6 +59,12 @@ type Protocol struct {
 	// attempts to create connections to them.
 	DialCandidates enode.Iterator
 
+	// DialFilter, if non-nil, reports whmxter a discovered node is worth dialing for
+	// this protocol, typically by checking the entries of its node record. Nodes are
+	// dialed if any protocol declaring a filter accepts them. Static nodes are always
+	// dialed.
+	DialFilter func(*enode.Node) bool
+
 	// Attributes contains protocol specific information for the node record.
 	Attributes []enr.Entry
 }
@@ -0,0 +1,133 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"context"
+	"net"
+
+	"github.com/mxt/go-mxt/p2p/enode"
+	"github.com/mxt/go-mxt/p2p/nat"
+)
+
+// maxPunchRelays is the number of relays tried when hole punching to a node.
+const maxPunchRelays = 3
+
+// TCP hole punching works by simultaneous open: both nodes dial each other from their
+// listening port at about the same time, which opens both NATs for the other side's SYN.
+// The node whose dial failed initiates punching through a relay and performs the RLPx
+// handshake as the dialer. The target connects back and treats the connection as
+// inbound. Port reuse must be enabled on the listener for this to work.
+
+// punchDialer wraps a NodeDialer, falling back to hole punching when the regular dial
+// fails.
+type punchDialer struct {
+	NodeDialer
+	srv *Server
+}
+
+func (d punchDialer) Dial(ctx context.Context, dest *enode.Node) (net.Conn, error) {
+	fd, err := d.NodeDialer.Dial(ctx, dest)
+	if err == nil || dest.UDP() == 0 {
+		return fd, err
+	}
+	port := d.srv.listenPort()
+	if port == 0 {
+		return nil, err
+	}
+	for _, relay := range d.srv.punchRelays(dest.ID()) {
+		if perr := d.srv.punchtab.HolePunch(dest, relay, port); perr != nil {
+			d.srv.log.Trace("Hole punching failed", "id", dest.ID(), "relay", relay.ID(), "err", perr)
+			continue
+		}
+		if fd, perr := dialReusePort(ctx, port, nodeAddr(dest)); perr == nil {
+			return fd, nil
+		}
+	}
+	return nil, err
+}
+
+// holePunching reports whmxter NAT traversal by hole punching is enabled.
+func (srv *Server) holePunching() bool {
+	_, ok := srv.NAT.(nat.HolePunch)
+	return ok
+}
+
+// listenPort returns the port of the TCP listener, or zero if not listening.
+func (srv *Server) listenPort() int {
+	if srv.listener == nil {
+		return 0
+	}
+	if tcp, ok := srv.listener.Addr().(*net.TCPAddr); ok {
+		return tcp.Port
+	}
+	return 0
+}
+
+// punchRelays returns the nodes which may relay a hole punching request to the given
+// node. These are the bootstrap nodes and the connected peers.
+func (srv *Server) punchRelays(target enode.ID) []*enode.Node {
+	var relays []*enode.Node
+	for _, n := range srv.BootstrapNodes {
+		if n.ID() != target && n.UDP() != 0 {
+			relays = append(relays, n)
+		}
+	}
+	for _, p := range srv.Peers() {
+		if n := p.Node(); n.ID() != target && n.UDP() != 0 {
+			relays = append(relays, n)
+		}
+	}
+	if len(relays) > maxPunchRelays {
+		relays = relays[:maxPunchRelays]
+	}
+	return relays
+}
+
+// punchTCP is called by discovery when a relayed node asks for a TCP hole punch. It
+// connects to the initiator and runs the connection as inbound.
+func (srv *Server) punchTCP(initiator *enode.Node, addr *net.TCPAddr) {
+	port := srv.listenPort()
+	if port == 0 || addr.Port <= 1024 || srv.isBanned(initiator.ID(), addr.IP) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), defaultDialTimeout)
+	defer cancel()
+	fd, err := dialReusePort(ctx, port, addr)
+	if err != nil {
+		srv.log.Trace("TCP hole punch failed", "id", initiator.ID(), "addr", addr, "err", err)
+		return
+	}
+	srv.log.Trace("TCP hole punch succeeded", "id", initiator.ID(), "addr", addr)
+	srv.SetupConn(fd, inboundConn, nil)
+}
+
+// listenReusePort creates a listener with port reuse enabled.
+func listenReusePort(network, addr string) (net.Listener, error) {
+	lc := net.ListenConfig{Control: reusePortControl}
+	return lc.Listen(context.Background(), network, addr)
+}
+
+// dialReusePort connects to addr from the given local port.
+func dialReusePort(ctx context.Context, port int, addr net.Addr) (net.Conn, error) {
+	d := net.Dialer{
+		Timeout:   defaultDialTimeout,
+		LocalAddr: &net.TCPAddr{Port: port},
+		Control:   reusePortControl,
+	}
+	return d.DialContext(ctx, "tcp", addr.String())
+}
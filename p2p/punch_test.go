@@ -0,0 +1,89 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package p2p
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/mxt/go-mxt/internal/testlog"
+	"github.com/mxt/go-mxt/log"
+	"github.com/mxt/go-mxt/p2p/nat"
+)
+
+// This test checks that the server sets up discovery v5 and the hole punching dialer
+// when NAT hole punching is enabled.
+func TestServerHolePunchSetup(t *testing.T) {
+	config := Config{
+		Name:        "test",
+		MaxPeers:    10,
+		ListenAddr:  "127.0.0.1:0",
+		NoDiscovery: true,
+		PrivateKey:  newkey(),
+		NAT:         nat.HolePunch{},
+		Logger:      testlog.Logger(t, log.LvlTrace),
+	}
+	srv := &Server{Config: config}
+	if err := srv.Start(); err != nil {
+		t.Fatalf("could not start server: %v", err)
+	}
+	defer srv.Stop()
+
+	if srv.punchtab == nil {
+		t.Fatal("discovery v5 not started")
+	}
+	if _, ok := srv.dialsched.dialer.(punchDialer); !ok {
+		t.Fatalf("wrong dialer type %T", srv.dialsched.dialer)
+	}
+
+	// Hole punching can't share the socket with topic discovery.
+	config.DiscoveryV5 = true
+	config.ListenAddr = "127.0.0.1:0"
+	srv2 := &Server{Config: config}
+	if err := srv2.Start(); err == nil {
+		srv2.Stop()
+		t.Fatal("server started with both hole punching and topic discovery")
+	}
+}
+
+// This test checks that connections can be made from a port which is in use by a
+// listener.
+func TestDialReusePort(t *testing.T) {
+	local, err := listenReusePort("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer local.Close()
+	remote, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+
+	port := local.Addr().(*net.TCPAddr).Port
+	fd, err := dialReusePort(context.Background(), port, remote.Addr())
+	if err != nil {
+		t.Fatal("dial error:", err)
+	}
+	defer fd.Close()
+	if p := fd.LocalAddr().(*net.TCPAddr).Port; p != port {
+		t.Fatalf("connection uses local port %d, want %d", p, port)
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"math"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/mxt/go-mxt/common/mclock"
+	"github.com/mxt/go-mxt/p2p/enode"
+	"github.com/mxt/go-mxt/p2p/netutil"
+)
+
+// Score adjustments protocols can report for common kinds of peer behaviour.
+const (
+	ScoreUseful  = 1   // Peer served a request or relayed new, valid data
+	ScoreUseless = -10 // Peer sent unrequested, stale or empty data
+	ScoreFaulty  = -50 // Peer sent invalid data or failed to serve a request
+)
+
+const (
+	scoreLimit      = 100              // Maximum absolute score a peer can accumulate
+	scoreHalfLife   = 30 * time.Minute // Time in which a score decays to half its value
+	scoreDisconnect = -50              // Score at or below which a peer is disconnected
+	scoreBan        = -100             // Score at or below which a peer is also banned
+	scoreCacheLimit = 1024             // Number of tracked scores above which negligible ones are dropped
+
+	// DefaultBanDuration is the time a peer is banned for when its score drops
+	// too low, or if no explicit duration is requested.
+	DefaultBanDuration = time.Hour
+)
+
+// reputation tracks the scores of peers, decaying them towards zero over time.
+// Scores are kept in memory, even after the peer disconnects, so that a peer
+// can't clear its record by reconnecting.
+type reputation struct {
+	clock  mclock.Clock
+	lock   sync.Mutex
+	scores map[enode.ID]*score
+}
+
+// score is the reputation of a single peer at the time of its last update.
+type score struct {
+	value   float64
+	updated mclock.AbsTime
+}
+
+func newReputation(clock mclock.Clock) *reputation {
+	return &reputation{
+		clock:  clock,
+		scores: make(map[enode.ID]*score),
+	}
+}
+
+// at returns the value of the score decayed until the given time.
+func (s *score) at(now mclock.AbsTime) float64 {
+	elapsed := time.Duration(now - s.updated)
+	return s.value * math.Pow(0.5, float64(elapsed)/float64(scoreHalfLife))
+}
+
+// add adjusts the score of a node by the given amount, returning the new value.
+func (r *reputation) add(id enode.ID, delta float64) float64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := r.clock.Now()
+	s := r.scores[id]
+	if s == nil {
+		if len(r.scores) >= scoreCacheLimit {
+			r.prune(now)
+		}
+		s = &score{updated: now}
+		r.scores[id] = s
+	}
+	value := math.Max(-scoreLimit, math.Min(scoreLimit, s.at(now)+delta))
+	s.value, s.updated = value, now
+	return value
+}
+
+// score returns the current score of a node.
+func (r *reputation) score(id enode.ID) float64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if s := r.scores[id]; s != nil {
+		return s.at(r.clock.Now())
+	}
+	return 0
+}
+
+// prune drops all scores which decayed to insignificance.
+func (r *reputation) prune(now mclock.AbsTime) {
+	for id, s := range r.scores {
+		if math.Abs(s.at(now)) < 1 {
+			delete(r.scores, id)
+		}
+	}
+}
+
+// PeerScore is the reputation of a connected peer.
+type PeerScore struct {
+	ID    string  `json:"id"`    // Unique node identifier
+	Name  string  `json:"name"`  // Name of the node, including client type, version, OS, custom data
+	Score float64 `json:"score"` // Current score of the peer, decayed towards zero over time
+}
+
+// PeerScores returns the reputation of all connected peers, sorted by node ID.
+func (srv *Server) PeerScores() []*PeerScore {
+	var scores []*PeerScore
+	for _, p := range srv.Peers() {
+		scores = append(scores, &PeerScore{
+			ID:    p.ID().String(),
+			Name:  p.Fullname(),
+			Score: srv.reputation.score(p.ID()),
+		})
+	}
+	sort.Slice(scores, func(i, j int) bool { return scores[i].ID < scores[j].ID })
+	return scores
+}
+
+// BanPeer bans a node by ID and IP address for the given duration, disconnecting
+// it if it's currently connected. Bans aren't enforced on static and trusted nodes.
+func (srv *Server) BanPeer(node *enode.Node, duration time.Duration) error {
+	var peer *Peer
+	srv.doPeerOp(func(peers map[enode.ID]*Peer) {
+		peer = peers[node.ID()]
+	})
+	ip := node.IP()
+	if peer != nil {
+		ip = netutil.AddrIP(peer.RemoteAddr())
+	}
+	if err := srv.banNode(node.ID(), ip, duration); err != nil {
+		return err
+	}
+	if peer != nil {
+		peer.Disconnect(DiscRequested)
+	}
+	return nil
+}
+
+// reportPeer adjusts the score of a peer, disconnecting and banning it if the
+// score drops too low.
+func (srv *Server) reportPeer(p *Peer, delta float64, reason string) {
+	value := srv.reputation.add(p.ID(), delta)
+	p.log.Trace("Adjusted peer score", "delta", delta, "score", value, "reason", reason)
+
+	if value > scoreDisconnect || p.rw.is(trustedConn|staticDialedConn) {
+		return
+	}
+	if value <= scoreBan {
+		p.log.Debug("Banning peer", "score", value, "reason", reason)
+		if err := srv.banNode(p.ID(), netutil.AddrIP(p.RemoteAddr()), DefaultBanDuration); err != nil {
+			p.log.Warn("Failed to ban peer", "err", err)
+		}
+	}
+	p.Disconnect(DiscUselessPeer)
+}
+
+// banNode persists a ban of the given node ID and IP address. LAN addresses
+// are never banned, as they are likely shared by many local nodes.
+func (srv *Server) banNode(id enode.ID, ip net.IP, duration time.Duration) error {
+	if srv.nodedb == nil {
+		return errServerStopped
+	}
+	until := mclock.WallTime(srv.Clock).Add(duration)
+	if err := srv.nodedb.UpdateNodeBan(id, until); err != nil {
+		return err
+	}
+	if ip == nil || netutil.IsLAN(ip) {
+		return nil
+	}
+	return srv.nodedb.UpdateIPBan(ip, until)
+}
+
+// isBanned reports whmxter the given node ID or IP address is currently banned.
+func (srv *Server) isBanned(id enode.ID, ip net.IP) bool {
+	now := mclock.WallTime(srv.Clock)
+	if srv.nodedb.NodeBan(id).After(now) {
+		return true
+	}
+	return ip != nil && srv.nodedb.IPBan(ip).After(now)
+}
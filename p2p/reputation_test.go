@@ -0,0 +1,165 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package p2p
+
+import (
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mxt/go-mxt/common/mclock"
+	"github.com/mxt/go-mxt/p2p/enode"
+)
+
+func TestReputationDecay(t *testing.T) {
+	var (
+		clock = new(mclock.Simulated)
+		rep   = newReputation(clock)
+		id    = enode.ID{0x01}
+	)
+	if score := rep.add(id, -40); score != -40 {
+		t.Fatalf("score mismatch: have %v, want %v", score, -40)
+	}
+	clock.Run(scoreHalfLife)
+	if score := rep.score(id); math.Abs(score+20) > 1e-9 {
+		t.Fatalf("decayed score mismatch: have %v, want %v", score, -20)
+	}
+	if score := rep.add(id, 10); math.Abs(score+10) > 1e-9 {
+		t.Fatalf("adjusted score mismatch: have %v, want %v", score, -10)
+	}
+	if score := rep.add(id, -1000); score != -scoreLimit {
+		t.Fatalf("score not capped: have %v, want %v", score, -scoreLimit)
+	}
+	// Scores decayed to insignificance should be pruned.
+	clock.Run(10 * scoreHalfLife)
+	rep.prune(clock.Now())
+	if len(rep.scores) != 0 {
+		t.Fatalf("negligible score not pruned: %v", rep.score(id))
+	}
+}
+
+// This test checks that reported peers are disconnected and banned once their
+// score drops low enough, and that banned peers can't reconnect.
+func TestServerReportPeer(t *testing.T) {
+	connected := make(chan *Peer, 1)
+	remid := &newkey().PublicKey
+	srv := startTestServer(t, remid, func(p *Peer) { connected <- p })
+	defer srv.Stop()
+
+	events := make(chan *PeerEvent, 10)
+	sub := srv.SubscribeEvents(events)
+	defer sub.Unsubscribe()
+
+	var conns []net.Conn
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+	dial := func() *Peer {
+		conn, err := net.DialTimeout("tcp", srv.ListenAddr, 5*time.Second)
+		if err != nil {
+			t.Fatalf("could not dial: %v", err)
+		}
+		conns = append(conns, conn)
+
+		select {
+		case p := <-connected:
+			return p
+		case <-time.After(time.Second):
+			return nil
+		}
+	}
+	waitDrop := func() {
+		timeout := time.After(time.Second)
+		for {
+			select {
+			case ev := <-events:
+				if ev.Type == PeerEventTypeDrop {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("peer not dropped")
+			}
+		}
+	}
+	// Mildly bad behaviour should only lower the score.
+	peer := dial()
+	if peer == nil {
+		t.Fatalf("server did not accept peer")
+	}
+	peer.Report(ScoreUseless, "test")
+	scores := srv.PeerScores()
+	if len(scores) != 1 {
+		t.Fatalf("peer score count mismatch: have %d, want %d", len(scores), 1)
+	}
+	if math.Abs(scores[0].Score-ScoreUseless) > 0.01 {
+		t.Fatalf("peer score mismatch: have %v, want %v", scores[0].Score, ScoreUseless)
+	}
+	// Crossing the disconnect threshold should drop the peer, but not ban it.
+	peer.Report(ScoreFaulty, "test")
+	waitDrop()
+	if srv.isBanned(peer.ID(), nil) {
+		t.Fatalf("peer banned below disconnect threshold")
+	}
+	// Crossing the ban threshold should ban the peer.
+	if peer = dial(); peer == nil {
+		t.Fatalf("server did not accept reconnecting peer")
+	}
+	peer.Report(ScoreFaulty, "test")
+	waitDrop()
+	if !srv.isBanned(peer.ID(), nil) {
+		t.Fatalf("peer not banned")
+	}
+	if peer = dial(); peer != nil {
+		t.Fatalf("server accepted banned peer")
+	}
+}
+
+// This test checks that explicitly banned peers are disconnected.
+func TestServerBanPeer(t *testing.T) {
+	connected := make(chan *Peer, 1)
+	remid := &newkey().PublicKey
+	srv := startTestServer(t, remid, func(p *Peer) { connected <- p })
+	defer srv.Stop()
+
+	conn, err := net.DialTimeout("tcp", srv.ListenAddr, 5*time.Second)
+	if err != nil {
+		t.Fatalf("could not dial: %v", err)
+	}
+	defer conn.Close()
+
+	var peer *Peer
+	select {
+	case peer = <-connected:
+	case <-time.After(time.Second):
+		t.Fatalf("server did not accept peer")
+	}
+	if err := srv.BanPeer(peer.Node(), time.Minute); err != nil {
+		t.Fatalf("failed to ban peer: %v", err)
+	}
+	if !srv.isBanned(peer.ID(), nil) {
+		t.Fatalf("peer not banned")
+	}
+	for i := 0; srv.PeerCount() > 0; i++ {
+		if i == 100 {
+			t.Fatalf("banned peer not disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
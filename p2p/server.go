@@ -139,7 +139,9 @@ type Config struct {
 
 	// If set to a non-nil value, the given NAT port mapper
 	// is used to make the listening port available to the
-	// Internet.
+	// Internet. Setting it to nat.HolePunch runs discovery v5
+	// to learn the external endpoint and to punch through NAT
+	// when dialing nodes which are not reachable directly.
 	NAT nat.Interface `toml:",omitempty"`
 
 	// If Dialer is set to a non-nil value, the given Dialer
@@ -156,7 +158,10 @@ type Config struct {
 	// Logger is a custom logger to use with the p2p.Server.
 	Logger log.Logger `toml:",omitempty"`
 
-	clock mclock.Clock
+	// Clock is the time source of the server and its peers. It drives timers like
+	// the dial scheduler and the ping interval, and the expiry of bans. Network I/O
+	// deadlines always use the system clock. The default is the system clock.
+	Clock mclock.Clock `toml:"-"`
 }
 
 // Server manages all peer connections.
@@ -179,12 +184,14 @@ type Server struct {
 	peerFeed     event.Feed
 	log          log.Logger
 
-	nodedb    *enode.DB
-	localnode *enode.LocalNode
-	ntab      *discover.UDPv4
-	DiscV5    *discv5.Network
-	discmix   *enode.FairMix
-	dialsched *dialScheduler
+	nodedb     *enode.DB
+	reputation *reputation
+	localnode  *enode.LocalNode
+	ntab       *discover.UDPv4
+	punchtab   *discover.UDPv5
+	DiscV5     *discv5.Network
+	discmix    *enode.FairMix
+	dialsched  *dialScheduler
 
 	// Channels into the run loop.
 	quit                    chan struct{}
@@ -445,8 +452,8 @@ func (srv *Server) Start() (err error) {
 	if srv.log == nil {
 		srv.log = log.Root()
 	}
-	if srv.clock == nil {
-		srv.clock = mclock.System{}
+	if srv.Clock == nil {
+		srv.Clock = mclock.System{}
 	}
 	if srv.NoDial && srv.ListenAddr == "" {
 		srv.log.Warn("P2P server will be useless, neither dialing nor listening")
@@ -461,6 +468,9 @@ func (srv *Server) Start() (err error) {
 	}
 	if srv.listenFunc == nil {
 		srv.listenFunc = net.Listen
+		if srv.holePunching() {
+			srv.listenFunc = listenReusePort
+		}
 	}
 	srv.quit = make(chan struct{})
 	srv.delpeer = make(chan peerDrop)
@@ -470,6 +480,7 @@ func (srv *Server) Start() (err error) {
 	srv.removetrusted = make(chan *enode.Node)
 	srv.peerOp = make(chan peerOpFunc)
 	srv.peerOpDone = make(chan struct{})
+	srv.reputation = newReputation(srv.Clock)
 
 	if err := srv.setupLocalNode(); err != nil {
 		return err
@@ -503,6 +514,7 @@ func (srv *Server) setupLocalNode() error {
 	if err != nil {
 		return err
 	}
+	db.SetClock(srv.Clock)
 	srv.nodedb = db
 	srv.localnode = enode.NewLocalNode(db, srv.PrivateKey)
 	srv.localnode.SetFallbackIP(net.IP{127, 0, 0, 1})
@@ -519,6 +531,10 @@ func (srv *Server) setupLocalNode() error {
 		// ExtIP doesn't block, set the IP right away.
 		ip, _ := srv.NAT.ExternalIP()
 		srv.localnode.SetStaticIP(ip)
+	case nat.HolePunch:
+		// The external endpoint is predicted from discovery statements. Require
+		// agreement of distinct networks because there is nothing to check against.
+		srv.localnode.SetEndpointConsensus(true)
 	default:
 		// Ask the router about the IP. This takes a while and blocks startup,
 		// do it in the background.
@@ -546,9 +562,12 @@ func (srv *Server) setupDiscovery() error {
 	}
 
 	// Don't listen on UDP endpoint if DHT is disabled.
-	if srv.NoDiscovery && !srv.DiscoveryV5 {
+	if srv.NoDiscovery && !srv.DiscoveryV5 && !srv.holePunching() {
 		return nil
 	}
+	if srv.DiscoveryV5 && srv.holePunching() {
+		return errors.New("NAT hole punching can't be used with topic discovery v5")
+	}
 
 	addr, err := net.ResolveUDPAddr("udp", srv.ListenAddr)
 	if err != nil {
@@ -560,7 +579,7 @@ func (srv *Server) setupDiscovery() error {
 	}
 	realaddr := conn.LocalAddr().(*net.UDPAddr)
 	srv.log.Debug("UDP listener up", "addr", realaddr)
-	if srv.NAT != nil {
+	if srv.NAT != nil && !srv.holePunching() {
 		if !realaddr.IP.IsLoopback() {
 			srv.loopWG.Add(1)
 			go func() {
@@ -575,7 +594,7 @@ func (srv *Server) setupDiscovery() error {
 	var unhandled chan discover.ReadPacket
 	var sconn *sharedUDPConn
 	if !srv.NoDiscovery {
-		if srv.DiscoveryV5 {
+		if srv.DiscoveryV5 || srv.holePunching() {
 			unhandled = make(chan discover.ReadPacket, 100)
 			sconn = &sharedUDPConn{conn, unhandled}
 		}
@@ -611,6 +630,29 @@ func (srv *Server) setupDiscovery() error {
 		}
 		srv.DiscV5 = ntab
 	}
+
+	// Discovery V5 for NAT hole punching
+	if srv.holePunching() {
+		var ptab *discover.UDPv5
+		var err error
+		cfg := discover.Config{
+			PrivateKey:   srv.PrivateKey,
+			NetRestrict:  srv.NetRestrict,
+			Bootnodes:    srv.BootstrapNodes,
+			Log:          srv.log,
+			HolePunchTCP: srv.punchTCP,
+		}
+		if sconn != nil {
+			ptab, err = discover.ListenV5(sconn, srv.localnode, cfg)
+		} else {
+			ptab, err = discover.ListenV5(conn, srv.localnode, cfg)
+		}
+		if err != nil {
+			return err
+		}
+		srv.punchtab = ptab
+		srv.discmix.AddSource(ptab.RandomNodes())
+	}
 	return nil
 }
 
@@ -622,7 +664,11 @@ func (srv *Server) setupDialScheduler() {
 		log:            srv.Logger,
 		netRestrict:    srv.NetRestrict,
 		dialer:         srv.Dialer,
-		clock:          srv.clock,
+		clock:          srv.Clock,
+		banned: func(n *enode.Node) bool {
+			return srv.isBanned(n.ID(), n.IP())
+		},
+		filter: srv.dialFilter(),
 	}
 	if srv.ntab != nil {
 		config.resolver = srv.ntab
@@ -630,12 +676,30 @@ func (srv *Server) setupDialScheduler() {
 	if config.dialer == nil {
 		config.dialer = tcpDialer{&net.Dialer{Timeout: defaultDialTimeout}}
 	}
+	if srv.punchtab != nil {
+		config.dialer = punchDialer{config.dialer, srv}
+	}
 	srv.dialsched = newDialScheduler(config, srv.discmix, srv.SetupConn)
 	for _, n := range srv.StaticNodes {
 		srv.dialsched.addStatic(n)
 	}
 }
 
+// dialFilter combines the dial filters of all protocols. Nodes pass if any protocol
+// declaring a filter accepts them. It returns nil if no protocol declares a filter.
+func (srv *Server) dialFilter() func(*enode.Node) bool {
+	var filters []func(*enode.Node) bool
+	for _, proto := range srv.Protocols {
+		if proto.DialFilter != nil {
+			filters = append(filters, proto.DialFilter)
+		}
+	}
+	if len(filters) == 0 {
+		return nil
+	}
+	return enode.AnyOf(filters...)
+}
+
 func (srv *Server) maxInboundConns() int {
 	return srv.MaxPeers - srv.maxDialedConns()
 }
@@ -667,7 +731,7 @@ func (srv *Server) setupListening() error {
 	// Update the local node record and map the TCP listening port if NAT is configured.
 	if tcp, ok := listener.Addr().(*net.TCPAddr); ok {
 		srv.localnode.Set(enr.TCP(tcp.Port))
-		if !tcp.IP.IsLoopback() && srv.NAT != nil {
+		if !tcp.IP.IsLoopback() && srv.NAT != nil && !srv.holePunching() {
 			srv.loopWG.Add(1)
 			go func() {
 				nat.Map(srv.NAT, srv.quit, "tcp", tcp.Port, tcp.Port, "mxt p2p")
@@ -767,7 +831,7 @@ running:
 
 		case pd := <-srv.delpeer:
 			// A peer disconnected.
-			d := common.PrettyDuration(mclock.Now() - pd.created)
+			d := common.PrettyDuration(srv.Clock.Now() - pd.created)
 			delete(peers, pd.ID())
 			srv.log.Debug("Removing p2p peer", "peercount", len(peers), "id", pd.ID(), "duration", d, "req", pd.requested, "err", pd.err)
 			srv.dialsched.peerRemoved(pd.rw)
@@ -783,6 +847,9 @@ running:
 	if srv.ntab != nil {
 		srv.ntab.Close()
 	}
+	if srv.punchtab != nil {
+		srv.punchtab.Close()
+	}
 	if srv.DiscV5 != nil {
 		srv.DiscV5.Close()
 	}
@@ -810,6 +877,8 @@ func (srv *Server) postHandshakeChecks(peers map[enode.ID]*Peer, inboundCount in
 		return DiscAlreadyConnected
 	case c.node.ID() == srv.localnode.ID():
 		return DiscSelf
+	case !c.is(trustedConn|staticDialedConn) && srv.isBanned(c.node.ID(), netutil.AddrIP(c.fd.RemoteAddr())):
+		return DiscUselessPeer
 	default:
 		return nil
 	}
@@ -901,7 +970,7 @@ func (srv *Server) checkInboundConn(fd net.Conn, remoteIP net.IP) error {
 		return fmt.Errorf("not whitelisted in NetRestrict")
 	}
 	// Reject Internet peers that try too often.
-	now := srv.clock.Now()
+	now := srv.Clock.Now()
 	srv.inboundHistory.expire(now, nil)
 	if !netutil.IsLAN(remoteIP) && srv.inboundHistory.contains(remoteIP.String()) {
 		return fmt.Errorf("too many attempts")
@@ -1008,7 +1077,8 @@ func (srv *Server) checkpoint(c *conn, stage chan<- *conn) error {
 }
 
 func (srv *Server) launchPeer(c *conn) *Peer {
-	p := newPeer(srv.log, c, srv.Protocols)
+	p := newPeer(srv.log, srv.Clock, c, srv.Protocols)
+	p.srv = srv
 	if srv.EnableMsgEvents {
 		// If message events are enabled, pass the peerFeed
 		// to the peer.
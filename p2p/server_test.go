@@ -233,6 +233,33 @@ func TestServerRemovePeerDisconnect(t *testing.T) {
 	}
 }
 
+// This test checks that the protocol dial filters are combined correctly.
+func TestServerDialFilter(t *testing.T) {
+	var (
+		foo    = enode.SignNull(new(enr.Record), enode.ID{1})
+		bar    = enode.SignNull(new(enr.Record), enode.ID{2})
+		other  = enode.SignNull(new(enr.Record), enode.ID{3})
+		accept = func(n *enode.Node) func(*enode.Node) bool {
+			return func(m *enode.Node) bool { return m.ID() == n.ID() }
+		}
+	)
+	srv := &Server{Config: Config{Protocols: []Protocol{{Name: "unfiltered"}}}}
+	if srv.dialFilter() != nil {
+		t.Fatal("dial filter set without any protocol filters")
+	}
+	srv.Protocols = append(srv.Protocols,
+		Protocol{Name: "foo", DialFilter: accept(foo)},
+		Protocol{Name: "bar", DialFilter: accept(bar)},
+	)
+	filter := srv.dialFilter()
+	if !filter(foo) || !filter(bar) {
+		t.Fatal("node accepted by a protocol filter was rejected")
+	}
+	if filter(other) {
+		t.Fatal("node rejected by all protocol filters was accepted")
+	}
+}
+
 // This test checks that connections are disconnected just after the encryption handshake
 // when the server is at capacity. Trusted connections should still be accepted.
 func TestServerAtCap(t *testing.T) {
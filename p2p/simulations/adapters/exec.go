@@ -35,6 +35,7 @@ import (
 	"time"
 
 	"github.com/docker/docker/pkg/reexec"
+	"github.com/mxt/go-mxt/common/mclock"
 	"github.com/mxt/go-mxt/log"
 	"github.com/mxt/go-mxt/node"
 	"github.com/mxt/go-mxt/p2p"
@@ -445,6 +446,7 @@ func startExecNodeStack() (*node.Node, error) {
 		ctx := &ServiceContext{
 			RPCDialer: &wsRPCDialer{addrs: conf.PeerAddrs},
 			Config:    conf.Node,
+			Clock:     mclock.System{},
 		}
 		if conf.Snapshots != nil {
 			ctx.Snapshot = conf.Snapshots[name]
@@ -17,13 +17,16 @@
 package adapters
 
 import (
+	"bytes"
 	"context"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"math"
 	"net"
 	"sync"
 
+	"github.com/mxt/go-mxt/common/mclock"
 	"github.com/mxt/go-mxt/event"
 	"github.com/mxt/go-mxt/log"
 	"github.com/mxt/go-mxt/node"
@@ -35,11 +38,14 @@ import (
 )
 
 // SimAdapter is a NodeAdapter which creates in-memory simulation nodes and
-// connects them using net.Pipe
+// connects them using net.Pipe. Connections between nodes pass through emulated
+// links, see SetLink.
 type SimAdapter struct {
 	pipe       func() (net.Conn, net.Conn, error)
 	mtx        sync.RWMutex
 	nodes      map[enode.ID]*SimNode
+	links      map[[2]enode.ID]*pipes.Link
+	clock      mclock.Clock
 	lifecycles LifecycleConstructors
 }
 
@@ -48,13 +54,28 @@ type SimAdapter struct {
 // particular node are passed to the NewNode function in the NodeConfig)
 // the adapter uses a net.Pipe for in-memory simulated network connections
 func NewSimAdapter(services LifecycleConstructors) *SimAdapter {
+	return NewSimAdapterWithClock(services, mclock.System{})
+}
+
+// NewSimAdapterWithClock creates a SimAdapter whose nodes and emulated links use
+// the given clock. Services receive the clock in their ServiceContext and should
+// pass it on to the subsystems they create. With a simulated clock, the network
+// can run for hours of simulated time in seconds, with reproducible outcomes.
+func NewSimAdapterWithClock(services LifecycleConstructors, clock mclock.Clock) *SimAdapter {
 	return &SimAdapter{
 		pipe:       pipes.NetPipe,
 		nodes:      make(map[enode.ID]*SimNode),
+		links:      make(map[[2]enode.ID]*pipes.Link),
+		clock:      clock,
 		lifecycles: services,
 	}
 }
 
+// Clock returns the clock used by the adapter's nodes.
+func (s *SimAdapter) Clock() mclock.Clock {
+	return s.clock
+}
+
 // Name returns the name of the adapter for logging purposes
 func (s *SimAdapter) Name() string {
 	return "sim-adapter"
@@ -96,8 +117,9 @@ func (s *SimAdapter) NewNode(config *NodeConfig) (Node, error) {
 			PrivateKey:      config.PrivateKey,
 			MaxPeers:        math.MaxInt32,
 			NoDiscovery:     true,
-			Dialer:          s,
+			Dialer:          &simDialer{adapter: s, from: id},
 			EnableMsgEvents: config.EnableMsgEvents,
+			Clock:           s.clock,
 		},
 		NoUSB:  true,
 		Logger: log.New("node.id", id.String()),
@@ -118,8 +140,15 @@ func (s *SimAdapter) NewNode(config *NodeConfig) (Node, error) {
 }
 
 // Dial implements the p2p.NodeDialer interface by connecting to the node using
-// an in-memory net.Pipe
+// an in-memory net.Pipe. Connections created by Dial don't pass through an
+// emulated link because the dialing node is unknown.
 func (s *SimAdapter) Dial(ctx context.Context, dest *enode.Node) (conn net.Conn, err error) {
+	return s.dial(nil, dest)
+}
+
+// dial connects to the destination node. If link is non-nil, the connection
+// passes through it.
+func (s *SimAdapter) dial(link *pipes.Link, dest *enode.Node) (conn net.Conn, err error) {
 	node, ok := s.GetNode(dest.ID())
 	if !ok {
 		return nil, fmt.Errorf("unknown node: %s", dest.ID())
@@ -133,6 +162,9 @@ func (s *SimAdapter) Dial(ctx context.Context, dest *enode.Node) (conn net.Conn,
 	if err != nil {
 		return nil, err
 	}
+	if link != nil {
+		pipe1, pipe2 = link.Pipe(pipe1, pipe2)
+	}
 	// this is simulated 'listening'
 	// asynchronously call the dialed destination node's p2p server
 	// to set up connection on the 'listening' side
@@ -140,6 +172,49 @@ func (s *SimAdapter) Dial(ctx context.Context, dest *enode.Node) (conn net.Conn,
 	return pipe2, nil
 }
 
+// SetLink sets the properties of the emulated link between two nodes. The
+// config applies to existing connections between the nodes as well as to
+// future ones.
+func (s *SimAdapter) SetLink(one, other enode.ID, config pipes.LinkConfig) error {
+	return s.link(one, other).SetConfig(config)
+}
+
+// Link returns the properties of the emulated link between two nodes.
+func (s *SimAdapter) Link(one, other enode.ID) pipes.LinkConfig {
+	return s.link(one, other).Config()
+}
+
+// link returns the emulated link between two nodes, creating it if necessary.
+// The link's seed is derived from the node IDs, making simulations with fixed
+// node keys reproducible.
+func (s *SimAdapter) link(one, other enode.ID) *pipes.Link {
+	key := [2]enode.ID{one, other}
+	if bytes.Compare(one[:], other[:]) > 0 {
+		key = [2]enode.ID{other, one}
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	link, ok := s.links[key]
+	if !ok {
+		seed := binary.BigEndian.Uint64(key[0][:]) ^ binary.BigEndian.Uint64(key[1][:])
+		link = pipes.NewLink(s.clock, int64(seed))
+		s.links[key] = link
+	}
+	return link
+}
+
+// simDialer dials nodes of a SimAdapter on behalf of a node, passing
+// connections through the emulated link between the two nodes.
+type simDialer struct {
+	adapter *SimAdapter
+	from    enode.ID
+}
+
+// Dial implements p2p.NodeDialer.
+func (d *simDialer) Dial(ctx context.Context, dest *enode.Node) (net.Conn, error) {
+	return d.adapter.dial(d.adapter.link(d.from, dest.ID()), dest)
+}
+
 // DialRPC implements the RPCDialer interface by creating an in-memory RPC
 // client of the given node
 func (s *SimAdapter) DialRPC(id enode.ID) (*rpc.Client, error) {
@@ -248,6 +323,7 @@ func (sn *SimNode) Start(snapshots map[string][]byte) error {
 			ctx := &ServiceContext{
 				RPCDialer: sn.adapter,
 				Config:    sn.config,
+				Clock:     sn.adapter.clock,
 			}
 			if snapshots != nil {
 				ctx.Snapshot = snapshots[name]
@@ -26,12 +26,14 @@ import (
 	"strconv"
 
 	"github.com/docker/docker/pkg/reexec"
+	"github.com/mxt/go-mxt/common/mclock"
 	"github.com/mxt/go-mxt/crypto"
 	"github.com/mxt/go-mxt/log"
 	"github.com/mxt/go-mxt/node"
 	"github.com/mxt/go-mxt/p2p"
 	"github.com/mxt/go-mxt/p2p/enode"
 	"github.com/mxt/go-mxt/p2p/enr"
+	"github.com/mxt/go-mxt/p2p/simulations/pipes"
 	"github.com/mxt/go-mxt/rpc"
 	"github.com/gorilla/websocket"
 )
@@ -76,6 +78,16 @@ type NodeAdapter interface {
 	NewNode(config *NodeConfig) (Node, error)
 }
 
+// LinkEmulator is implemented by node adapters which can emulate the properties
+// of the network links between nodes
+type LinkEmulator interface {
+	// SetLink sets the properties of the link between two nodes
+	SetLink(one, other enode.ID, config pipes.LinkConfig) error
+
+	// Link returns the properties of the link between two nodes
+	Link(one, other enode.ID) pipes.LinkConfig
+}
+
 // NodeConfig is the configuration used to start a node in a simulation
 // network
 type NodeConfig struct {
@@ -235,6 +247,10 @@ type ServiceContext struct {
 
 	Config   *NodeConfig
 	Snapshot []byte
+
+	// Clock is the time source of the node. Services should use it for their own
+	// timers and pass it to the subsystems they create, e.g. via mxt.Config.Clock.
+	Clock mclock.Clock
 }
 
 // RPCDialer is used when initialising services which need to connect to
@@ -33,6 +33,7 @@ import (
 	"github.com/mxt/go-mxt/p2p"
 	"github.com/mxt/go-mxt/p2p/enode"
 	"github.com/mxt/go-mxt/p2p/simulations/adapters"
+	"github.com/mxt/go-mxt/p2p/simulations/pipes"
 	"github.com/mxt/go-mxt/rpc"
 	"github.com/gorilla/websocket"
 	"github.com/julienschmidt/httprouter"
@@ -205,6 +206,27 @@ func (c *Client) DisconnectNode(nodeID, peerID string) error {
 	return c.Delete(fmt.Sprintf("/nodes/%s/conn/%s", nodeID, peerID))
 }
 
+// GetLink returns the properties of the emulated link between two nodes
+func (c *Client) GetLink(nodeID, peerID string) (*pipes.LinkConfig, error) {
+	config := &pipes.LinkConfig{}
+	return config, c.Get(fmt.Sprintf("/nodes/%s/link/%s", nodeID, peerID), config)
+}
+
+// SetLink sets the properties of the emulated link between two nodes
+func (c *Client) SetLink(nodeID, peerID string, config pipes.LinkConfig) error {
+	return c.Post(fmt.Sprintf("/nodes/%s/link/%s", nodeID, peerID), config, nil)
+}
+
+// Partition cuts the links between all nodes which are in different groups
+func (c *Client) Partition(groups ...[]string) error {
+	return c.Post("/partition", groups, nil)
+}
+
+// Heal reconnects all partitioned links
+func (c *Client) Heal() error {
+	return c.Delete("/partition")
+}
+
 // RPCClient returns an RPC client connected to a node
 func (c *Client) RPCClient(ctx context.Context, nodeID string) (*rpc.Client, error) {
 	baseURL := strings.Replace(c.URL, "http", "ws", 1)
@@ -296,6 +318,10 @@ func NewServer(network *Network) *Server {
 	s.POST("/nodes/:nodeid/conn/:peerid", s.ConnectNode)
 	s.DELETE("/nodes/:nodeid/conn/:peerid", s.DisconnectNode)
 	s.GET("/nodes/:nodeid/rpc", s.NodeRPC)
+	s.GET("/nodes/:nodeid/link/:peerid", s.GetLink)
+	s.POST("/nodes/:nodeid/link/:peerid", s.SetLink)
+	s.POST("/partition", s.Partition)
+	s.DELETE("/partition", s.Heal)
 
 	return s
 }
@@ -642,6 +668,73 @@ func (s *Server) DisconnectNode(w http.ResponseWriter, req *http.Request) {
 	s.JSON(w, http.StatusOK, node.NodeInfo())
 }
 
+// GetLink returns the properties of the emulated link between two nodes
+func (s *Server) GetLink(w http.ResponseWriter, req *http.Request) {
+	node := req.Context().Value("node").(*Node)
+	peer := req.Context().Value("peer").(*Node)
+
+	s.JSON(w, http.StatusOK, s.network.GetLink(node.ID(), peer.ID()))
+}
+
+// SetLink sets the properties of the emulated link between two nodes
+func (s *Server) SetLink(w http.ResponseWriter, req *http.Request) {
+	node := req.Context().Value("node").(*Node)
+	peer := req.Context().Value("peer").(*Node)
+
+	var config pipes.LinkConfig
+	if err := json.NewDecoder(req.Body).Decode(&config); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := config.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := s.network.SetLink(node.ID(), peer.ID(), config); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.JSON(w, http.StatusOK, s.network.GetLink(node.ID(), peer.ID()))
+}
+
+// Partition cuts the links between all nodes which are in different groups.
+// The request body is a list of groups of node IDs or names.
+func (s *Server) Partition(w http.ResponseWriter, req *http.Request) {
+	var names [][]string
+	if err := json.NewDecoder(req.Body).Decode(&names); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	groups := make([][]enode.ID, len(names))
+	for i, group := range names {
+		for _, name := range group {
+			node := s.lookupNode(name)
+			if node == nil {
+				http.Error(w, fmt.Sprintf("unknown node %q", name), http.StatusBadRequest)
+				return
+			}
+			groups[i] = append(groups[i], node.ID())
+		}
+	}
+	if err := s.network.Partition(groups...); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.JSON(w, http.StatusOK, s.network)
+}
+
+// Heal reconnects all partitioned links
+func (s *Server) Heal(w http.ResponseWriter, req *http.Request) {
+	if err := s.network.Heal(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.JSON(w, http.StatusOK, s.network)
+}
+
 // Options responds to the OPTIONS HTTP mmxtod by returning a 200 OK response
 // with the "Access-Control-Allow-Headers" header set to "Content-Type"
 func (s *Server) Options(w http.ResponseWriter, req *http.Request) {
@@ -708,13 +801,7 @@ func (s *Server) wrapHandler(handler http.HandlerFunc) httprouter.Handle {
 		ctx := req.Context()
 
 		if id := params.ByName("nodeid"); id != "" {
-			var nodeID enode.ID
-			var node *Node
-			if nodeID.UnmarshalText([]byte(id)) == nil {
-				node = s.network.GetNode(nodeID)
-			} else {
-				node = s.network.GetNodeByName(id)
-			}
+			node := s.lookupNode(id)
 			if node == nil {
 				http.NotFound(w, req)
 				return
@@ -723,13 +810,7 @@ func (s *Server) wrapHandler(handler http.HandlerFunc) httprouter.Handle {
 		}
 
 		if id := params.ByName("peerid"); id != "" {
-			var peerID enode.ID
-			var peer *Node
-			if peerID.UnmarshalText([]byte(id)) == nil {
-				peer = s.network.GetNode(peerID)
-			} else {
-				peer = s.network.GetNodeByName(id)
-			}
+			peer := s.lookupNode(id)
 			if peer == nil {
 				http.NotFound(w, req)
 				return
@@ -740,3 +821,12 @@ func (s *Server) wrapHandler(handler http.HandlerFunc) httprouter.Handle {
 		handler(w, req.WithContext(ctx))
 	}
 }
+
+// lookupNode returns the node with the given ID or name
+func (s *Server) lookupNode(id string) *Node {
+	var nodeID enode.ID
+	if nodeID.UnmarshalText([]byte(id)) == nil {
+		return s.network.GetNode(nodeID)
+	}
+	return s.network.GetNodeByName(id)
+}
@@ -35,6 +35,7 @@ import (
 	"github.com/mxt/go-mxt/p2p"
 	"github.com/mxt/go-mxt/p2p/enode"
 	"github.com/mxt/go-mxt/p2p/simulations/adapters"
+	"github.com/mxt/go-mxt/p2p/simulations/pipes"
 	"github.com/mxt/go-mxt/rpc"
 	"github.com/mattn/go-colorable"
 )
@@ -751,6 +752,58 @@ func TestHTTPSnapshot(t *testing.T) {
 	)
 }
 
+// TestHTTPLinks tests setting link properties and partitions via the HTTP API
+func TestHTTPLinks(t *testing.T) {
+	network, s := testHTTPServer(t)
+	defer s.Close()
+	defer network.Shutdown()
+
+	client := NewClient(s.URL)
+	var names []string
+	for i := 0; i < 3; i++ {
+		config := adapters.RandomNodeConfig()
+		config.Name = fmt.Sprintf("node%02d", i+1)
+		if _, err := client.CreateNode(config); err != nil {
+			t.Fatalf("error creating node: %s", err)
+		}
+		names = append(names, config.Name)
+	}
+
+	want := pipes.LinkConfig{Latency: 100 * time.Millisecond, Bandwidth: 125000, Loss: 0.01}
+	if err := client.SetLink(names[0], names[1], want); err != nil {
+		t.Fatalf("error setting link: %s", err)
+	}
+	if err := client.SetLink(names[0], names[1], pipes.LinkConfig{Loss: 2}); err == nil {
+		t.Fatal("expected error for invalid link config")
+	}
+	link, err := client.GetLink(names[1], names[0])
+	if err != nil {
+		t.Fatalf("error getting link: %s", err)
+	}
+	if *link != want {
+		t.Fatalf("wrong link: %+v", link)
+	}
+
+	if err := client.Partition([]string{names[0]}, []string{names[1], names[2]}); err != nil {
+		t.Fatalf("error partitioning network: %s", err)
+	}
+	if err := client.Partition([]string{"unknown"}, []string{names[0]}); err == nil {
+		t.Fatal("expected error for unknown node")
+	}
+	if link, _ := client.GetLink(names[0], names[2]); !link.Partitioned {
+		t.Fatalf("link not partitioned: %+v", link)
+	}
+	if err := client.Heal(); err != nil {
+		t.Fatalf("error healing network: %s", err)
+	}
+	if link, _ := client.GetLink(names[0], names[1]); *link != want {
+		t.Fatalf("wrong link after heal: %+v", link)
+	}
+	if link, _ := client.GetLink(names[0], names[2]); link.Partitioned {
+		t.Fatalf("link still partitioned: %+v", link)
+	}
+}
+
 // TestMsgFilterPassMultiple tests streaming message events using a filter
 // with multiple protocols
 func TestMsgFilterPassMultiple(t *testing.T) {
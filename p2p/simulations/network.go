@@ -32,6 +32,7 @@ import (
 	"github.com/mxt/go-mxt/p2p"
 	"github.com/mxt/go-mxt/p2p/enode"
 	"github.com/mxt/go-mxt/p2p/simulations/adapters"
+	"github.com/mxt/go-mxt/p2p/simulations/pipes"
 )
 
 var DialBanTimeout = 200 * time.Millisecond
@@ -402,6 +403,93 @@ func (net *Network) DidReceive(sender, receiver enode.ID, proto string, code uin
 	return nil
 }
 
+// SetLink sets the properties of the emulated link between two nodes. The node
+// adapter must implement adapters.LinkEmulator.
+func (net *Network) SetLink(oneID, otherID enode.ID, config pipes.LinkConfig) error {
+	net.lock.Lock()
+	defer net.lock.Unlock()
+	return net.setLink(oneID, otherID, config)
+}
+
+func (net *Network) setLink(oneID, otherID enode.ID, config pipes.LinkConfig) error {
+	emulator, ok := net.nodeAdapter.(adapters.LinkEmulator)
+	if !ok {
+		return fmt.Errorf("%s does not support link emulation", net.nodeAdapter.Name())
+	}
+	if oneID == otherID {
+		return fmt.Errorf("refusing to set link to self %v", oneID)
+	}
+	conn, err := net.getOrCreateConn(oneID, otherID)
+	if err != nil {
+		return err
+	}
+	if err := emulator.SetLink(oneID, otherID, config); err != nil {
+		return err
+	}
+	if config == (pipes.LinkConfig{}) {
+		conn.Link = nil
+	} else {
+		conn.Link = &config
+	}
+	log.Debug("Link changed", "id", oneID, "other", otherID, "config", fmt.Sprintf("%+v", config))
+	net.events.Send(NewEvent(conn))
+	return nil
+}
+
+// GetLink returns the properties of the emulated link between two nodes
+func (net *Network) GetLink(oneID, otherID enode.ID) pipes.LinkConfig {
+	conn := net.GetConn(oneID, otherID)
+	if conn == nil || conn.Link == nil {
+		return pipes.LinkConfig{}
+	}
+	return *conn.Link
+}
+
+// Partition cuts the links between all nodes which are in different groups.
+// Links to nodes which are not in any group remain unchanged.
+func (net *Network) Partition(groups ...[]enode.ID) error {
+	net.lock.Lock()
+	defer net.lock.Unlock()
+	for i, group := range groups {
+		for _, other := range groups[i+1:] {
+			for _, oneID := range group {
+				for _, otherID := range other {
+					config := net.linkConfig(oneID, otherID)
+					config.Partitioned = true
+					if err := net.setLink(oneID, otherID, config); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Heal reconnects all partitioned links, keeping their other properties
+func (net *Network) Heal() error {
+	net.lock.Lock()
+	defer net.lock.Unlock()
+	for _, conn := range net.Conns {
+		if conn.Link == nil || !conn.Link.Partitioned {
+			continue
+		}
+		config := *conn.Link
+		config.Partitioned = false
+		if err := net.setLink(conn.One, conn.Other, config); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (net *Network) linkConfig(oneID, otherID enode.ID) pipes.LinkConfig {
+	if conn := net.getConn(oneID, otherID); conn != nil && conn.Link != nil {
+		return *conn.Link
+	}
+	return pipes.LinkConfig{}
+}
+
 // GetNode gets the node with the given ID, returning nil if the node does not
 // exist
 func (net *Network) GetNode(id enode.ID) *Node {
@@ -819,6 +907,11 @@ type Conn struct {
 
 	// Up tracks whmxter or not the connection is active
 	Up bool `json:"up"`
+
+	// Link holds the properties of the emulated link between the nodes, nil
+	// means a perfect link
+	Link *pipes.LinkConfig `json:"link,omitempty"`
+
 	// Registers when the connection was grabbed to dial
 	initiated time.Time
 
@@ -1048,6 +1141,17 @@ func (net *Network) Load(snap *Snapshot) error {
 	case <-time.After(snapshotLoadTimeout):
 		return errors.New("snapshot connections not established")
 	}
+
+	// Restore the links once connected, partitioned links would prevent the
+	// connections from being established.
+	for _, conn := range snap.Conns {
+		if conn.Link == nil {
+			continue
+		}
+		if err := net.SetLink(conn.One, conn.Other, *conn.Link); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -24,13 +24,17 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/mxt/go-mxt/common/mclock"
 	"github.com/mxt/go-mxt/log"
 	"github.com/mxt/go-mxt/node"
+	"github.com/mxt/go-mxt/p2p"
 	"github.com/mxt/go-mxt/p2p/enode"
 	"github.com/mxt/go-mxt/p2p/simulations/adapters"
+	"github.com/mxt/go-mxt/p2p/simulations/pipes"
 )
 
 // Tests that a created snapshot with a minimal service only contains the expected connections
@@ -433,6 +437,192 @@ func createTestNodesWithProperty(property string, count int, network *Network) (
 	return propertyNodes, nil
 }
 
+// TestNetworkLinks tests that link properties are tracked by the network and that
+// partitioned links prevent connections until they are healed.
+func TestNetworkLinks(t *testing.T) {
+	adapter := adapters.NewSimAdapter(adapters.LifecycleConstructors{
+		"noopwoop": func(ctx *adapters.ServiceContext, stack *node.Node) (node.Lifecycle, error) {
+			return NewNoopService(nil), nil
+		},
+	})
+	network := NewNetwork(adapter, &NetworkConfig{
+		DefaultService: "noopwoop",
+	})
+	defer network.Shutdown()
+
+	nodes, err := createTestNodes(3, network)
+	if err != nil {
+		t.Fatalf("Could not create test nodes %v", err)
+	}
+	one, two, three := nodes[0].ID(), nodes[1].ID(), nodes[2].ID()
+
+	latency := pipes.LinkConfig{Latency: 20 * time.Millisecond}
+	if err := network.SetLink(one, two, latency); err != nil {
+		t.Fatal(err)
+	}
+	if err := network.Partition([]enode.ID{one}, []enode.ID{two, three}); err != nil {
+		t.Fatal(err)
+	}
+	if link := adapter.Link(two, one); !link.Partitioned || link.Latency != latency.Latency {
+		t.Fatalf("wrong link between one and two: %+v", link)
+	}
+	if link := network.GetLink(one, three); !link.Partitioned {
+		t.Fatalf("link between one and three not partitioned: %+v", link)
+	}
+	if link := network.GetLink(two, three); link != (pipes.LinkConfig{}) {
+		t.Fatalf("link between two and three changed: %+v", link)
+	}
+
+	// Connecting across the partition doesn't succeed until it heals.
+	events := make(chan *Event, 10)
+	sub := network.Events().Subscribe(events)
+	defer sub.Unsubscribe()
+	if err := network.Connect(one, two); err != nil {
+		t.Fatal(err)
+	}
+	waitConn := func(timeout time.Duration) bool {
+		deadline := time.After(timeout)
+		for {
+			select {
+			case ev := <-events:
+				if ev.Type == EventTypeConn && !ev.Control && ev.Conn.Up {
+					return true
+				}
+			case <-deadline:
+				return false
+			}
+		}
+	}
+	if waitConn(500 * time.Millisecond) {
+		t.Fatal("nodes connected across partition")
+	}
+	if err := network.Heal(); err != nil {
+		t.Fatal(err)
+	}
+	if !waitConn(5 * time.Second) {
+		t.Fatal("nodes not connected after partition healed")
+	}
+	if link := network.GetLink(one, two); link != latency {
+		t.Fatalf("wrong link after heal: %+v", link)
+	}
+}
+
+// clockService sends the time of its clock to its peers every hour, recording
+// the times it receives.
+type clockService struct {
+	clock    mclock.Clock
+	running  chan struct{}
+	mu       sync.Mutex
+	received []mclock.AbsTime
+}
+
+func (s *clockService) Protocols() []p2p.Protocol {
+	return []p2p.Protocol{{Name: "clock", Version: 1, Length: 1, Run: s.run}}
+}
+
+func (s *clockService) Start() error { return nil }
+func (s *clockService) Stop() error  { return nil }
+
+func (s *clockService) run(p *p2p.Peer, rw p2p.MsgReadWriter) error {
+	timer := s.clock.NewTimer(time.Hour)
+	defer timer.Stop()
+	s.running <- struct{}{}
+
+	errc := make(chan error, 1)
+	go func() {
+		for {
+			var sent uint64
+			msg, err := rw.ReadMsg()
+			if err == nil {
+				err = msg.Decode(&sent)
+			}
+			if err != nil {
+				errc <- err
+				return
+			}
+			s.mu.Lock()
+			s.received = append(s.received, mclock.AbsTime(sent))
+			s.mu.Unlock()
+		}
+	}()
+	for {
+		select {
+		case now := <-timer.C():
+			// Schedule relative to the tick, the clock may have advanced since.
+			timer.Reset(now.Add(time.Hour).Sub(s.clock.Now()))
+			if err := p2p.Send(rw, 0, uint64(now)); err != nil {
+				return err
+			}
+		case err := <-errc:
+			return err
+		}
+	}
+}
+
+func (s *clockService) receivedTimes() []mclock.AbsTime {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]mclock.AbsTime(nil), s.received...)
+}
+
+// TestNetworkSimulatedClock runs a network for hours of simulated time, checking
+// that the nodes and their services are driven by the adapter's clock.
+func TestNetworkSimulatedClock(t *testing.T) {
+	const hours = 10
+	var (
+		clock    = new(mclock.Simulated)
+		running  = make(chan struct{}, 2)
+		services []*clockService
+		mu       sync.Mutex
+	)
+	adapter := adapters.NewSimAdapterWithClock(adapters.LifecycleConstructors{
+		"clock": func(ctx *adapters.ServiceContext, stack *node.Node) (node.Lifecycle, error) {
+			svc := &clockService{clock: ctx.Clock, running: running}
+			stack.RegisterProtocols(svc.Protocols())
+			mu.Lock()
+			services = append(services, svc)
+			mu.Unlock()
+			return svc, nil
+		},
+	}, clock)
+	network := NewNetwork(adapter, &NetworkConfig{DefaultService: "clock"})
+	defer network.Shutdown()
+
+	nodes, err := createTestNodes(2, network)
+	if err != nil {
+		t.Fatalf("Could not create test nodes %v", err)
+	}
+	if err := network.Connect(nodes[0].ID(), nodes[1].ID()); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-running:
+		case <-time.After(5 * time.Second):
+			t.Fatal("protocol didn't start")
+		}
+	}
+
+	// Advance the clock hour by hour, waiting for the nodes to exchange their times.
+	var want []mclock.AbsTime
+	for i := 1; i <= hours; i++ {
+		clock.Run(time.Hour)
+		want = append(want, mclock.AbsTime(time.Duration(i)*time.Hour))
+		for _, svc := range services {
+			deadline := time.Now().Add(5 * time.Second)
+			for len(svc.receivedTimes()) < i && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+			if got := svc.receivedTimes(); !reflect.DeepEqual(got, want) {
+				t.Fatalf("wrong times received after %dh:\nhave %v\nwant %v", i, got, want)
+			}
+		}
+	}
+	if conn := network.GetConn(nodes[0].ID(), nodes[1].ID()); conn == nil || !conn.Up {
+		t.Fatal("nodes disconnected during simulation")
+	}
+}
+
 // TestGetNodeIDs creates a set of nodes and attempts to retrieve their IDs,.
 // It then tests again whilst excluding a node ID from being returned.
 // If a node ID is not returned, or more node IDs than expected are returned, the test fails.
@@ -0,0 +1,330 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package pipes
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mxt/go-mxt/common/mclock"
+)
+
+const (
+	// segmentSize is the unit of packet loss. Writes are split into segments of
+	// this size and each segment is lost independently.
+	segmentSize = 1400
+
+	// minRetransmitTimeout is the smallest delay after which a lost segment is
+	// sent again.
+	minRetransmitTimeout = 200 * time.Millisecond
+)
+
+// LinkConfig describes the properties of an emulated network link. The zero
+// value is a perfect link.
+type LinkConfig struct {
+	// Latency is the one-way delay of the link.
+	Latency time.Duration `json:"latency,omitempty"`
+
+	// Bandwidth is the capacity of the link in bytes per second in each
+	// direction. Zero means unlimited.
+	Bandwidth int `json:"bandwidth,omitempty"`
+
+	// Loss is the probability that a segment is lost and needs to be sent again.
+	Loss float64 `json:"loss,omitempty"`
+
+	// Partitioned cuts the link. Data written while the link is cut is delivered
+	// when the partition heals, unless the connection is closed before.
+	Partitioned bool `json:"partitioned,omitempty"`
+}
+
+// Validate checks whmxter the config describes a possible link.
+func (cfg LinkConfig) Validate() error {
+	switch {
+	case cfg.Latency < 0:
+		return errors.New("negative latency")
+	case cfg.Bandwidth < 0:
+		return errors.New("negative bandwidth")
+	case cfg.Loss < 0 || cfg.Loss >= 1:
+		return errors.New("loss must be in range [0, 1)")
+	}
+	return nil
+}
+
+// retransmitTimeout returns the delay after which a lost segment is sent again.
+func (cfg LinkConfig) retransmitTimeout() time.Duration {
+	if rto := 2 * cfg.Latency; rto > minRetransmitTimeout {
+		return rto
+	}
+	return minRetransmitTimeout
+}
+
+// Link emulates a network link between two endpoints. Connections wrapped by the
+// link are delayed, throttled and cut according to its config, which can be
+// changed at any time.
+//
+// Packet loss is modeled the way a reliable transport experiences it: lost
+// segments are sent again after a timeout, delaying all data written after them.
+// The outcome of a link is determined by its seed, the config and the timing of
+// writes, so links driven by a simulated clock behave reproducibly.
+type Link struct {
+	clock mclock.Clock
+	seed  int64
+
+	mu      sync.Mutex
+	config  LinkConfig
+	conns   int64
+	changed chan struct{} // closed when config changes
+}
+
+// NewLink creates a perfect link.
+func NewLink(clock mclock.Clock, seed int64) *Link {
+	return &Link{clock: clock, seed: seed, changed: make(chan struct{})}
+}
+
+// Config returns the current config of the link.
+func (l *Link) Config() LinkConfig {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.config
+}
+
+// SetConfig changes the properties of the link. The change applies to data
+// written afterwards, and to held back data if the link is no longer partitioned.
+func (l *Link) SetConfig(config LinkConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.config = config
+	close(l.changed)
+	l.changed = make(chan struct{})
+	return nil
+}
+
+// Pipe wraps both ends of a pipe. Data written to either end passes through the link.
+func (l *Link) Pipe(c1, c2 net.Conn) (net.Conn, net.Conn) {
+	return l.Wrap(c1), l.Wrap(c2)
+}
+
+// Wrap returns a connection which sends data written to it through the link.
+// Reads are passed through, the other end of the connection must be wrapped
+// separately. Data which hasn't been delivered when the connection is closed is
+// discarded.
+func (l *Link) Wrap(c net.Conn) net.Conn {
+	l.mu.Lock()
+	seed := l.seed + l.conns
+	l.conns++
+	l.mu.Unlock()
+
+	lc := &linkConn{
+		Conn:    c,
+		link:    l,
+		rand:    rand.New(rand.NewSource(seed)),
+		pending: make(chan struct{}, 1),
+		closed:  make(chan struct{}),
+	}
+	go lc.deliverLoop()
+	return lc
+}
+
+// partition returns whmxter the link is cut, along with a channel which is closed
+// when the config changes.
+func (l *Link) partition() (bool, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.config.Partitioned, l.changed
+}
+
+// linkConn is one end of a connection through a link.
+type linkConn struct {
+	net.Conn
+	link *Link
+	rand *rand.Rand
+
+	mu        sync.Mutex
+	queue     []*packet
+	sentUntil mclock.AbsTime // time at which all queued data is sent
+	lastDue   mclock.AbsTime // delivery time of the last queued packet
+	err       error
+
+	pending   chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+type packet struct {
+	data []byte
+	due  mclock.AbsTime
+}
+
+// Write queues data for delivery. It blocks for as long as it takes to send the
+// data at the link's bandwidth, but doesn't wait for the data to be delivered.
+func (c *linkConn) Write(b []byte) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+
+	config := c.link.Config()
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return 0, c.err
+	}
+	now := c.link.clock.Now()
+	sent, due := c.schedule(now, config, len(b))
+	c.queue = append(c.queue, &packet{data: append([]byte(nil), b...), due: due})
+	c.mu.Unlock()
+
+	select {
+	case c.pending <- struct{}{}:
+	default:
+	}
+	if wait := sent.Sub(now); wait > 0 {
+		c.link.clock.Sleep(wait)
+	}
+	return len(b), nil
+}
+
+// schedule computes when n bytes written at time now are sent and when they are
+// delivered.
+func (c *linkConn) schedule(now mclock.AbsTime, config LinkConfig, n int) (sent, due mclock.AbsTime) {
+	// Count transmissions of all segments, including retransmissions of lost ones.
+	// The most often lost segment delays delivery.
+	segments := (n + segmentSize - 1) / segmentSize
+	if segments == 0 {
+		segments = 1
+	}
+	var retransmissions, maxRetries int
+	for i := 0; i < segments; i++ {
+		retries := 0
+		for config.Loss > 0 && c.rand.Float64() < config.Loss {
+			retries++
+		}
+		retransmissions += retries
+		if retries > maxRetries {
+			maxRetries = retries
+		}
+	}
+
+	sent = now
+	if c.sentUntil > sent {
+		sent = c.sentUntil
+	}
+	if config.Bandwidth > 0 {
+		size := n + retransmissions*segmentSize
+		sent = sent.Add(time.Duration(size) * time.Second / time.Duration(config.Bandwidth))
+	}
+	c.sentUntil = sent
+
+	due = sent.Add(config.Latency + time.Duration(maxRetries)*config.retransmitTimeout())
+	if due < c.lastDue {
+		due = c.lastDue // data is delivered in order
+	}
+	c.lastDue = due
+	return sent, due
+}
+
+// deliverLoop writes queued packets to the underlying connection when they are due.
+func (c *linkConn) deliverLoop() {
+	for {
+		c.mu.Lock()
+		var p *packet
+		if len(c.queue) > 0 {
+			p = c.queue[0]
+		}
+		c.mu.Unlock()
+
+		if p == nil {
+			select {
+			case <-c.pending:
+				continue
+			case <-c.closed:
+				return
+			}
+		}
+		if !c.waitDue(p.due) || !c.waitConnected() {
+			return
+		}
+		if _, err := c.Conn.Write(p.data); err != nil {
+			c.mu.Lock()
+			c.err = err
+			c.queue = nil
+			c.mu.Unlock()
+			return
+		}
+		c.mu.Lock()
+		c.queue = c.queue[1:]
+		c.mu.Unlock()
+	}
+}
+
+// waitDue waits until the given time. It returns false if the connection was
+// closed in the meantime.
+func (c *linkConn) waitDue(due mclock.AbsTime) bool {
+	wait := due.Sub(c.link.clock.Now())
+	if wait <= 0 {
+		return true
+	}
+	timer := c.link.clock.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return true
+	case <-c.closed:
+		return false
+	}
+}
+
+// waitConnected waits until the link isn't partitioned. It returns false if the
+// connection was closed in the meantime.
+func (c *linkConn) waitConnected() bool {
+	for {
+		partitioned, changed := c.link.partition()
+		if !partitioned {
+			return true
+		}
+		select {
+		case <-changed:
+		case <-c.closed:
+			return false
+		}
+	}
+}
+
+// Close closes the connection, discarding undelivered data.
+func (c *linkConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+// SetDeadline sets the read deadline of the connection. Write deadlines are
+// ignored because writes only block while data is sent.
+func (c *linkConn) SetDeadline(t time.Time) error {
+	return c.Conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline does nothing, writes only block while data is sent.
+func (c *linkConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
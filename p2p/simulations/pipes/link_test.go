@@ -0,0 +1,189 @@
+// Copyright 2020 The go-mxt Authors
+// This file is part of the go-mxt library.
+//
+// The go-mxt library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-mxt library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-mxt library. If not, see <http://www.gnu.org/licenses/>.
+
+package pipes
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mxt/go-mxt/common/mclock"
+)
+
+type readResult struct {
+	data []byte
+	at   mclock.AbsTime
+}
+
+// readAsync reads n bytes from c in the background, recording the time at which
+// they arrive.
+func readAsync(c net.Conn, clock mclock.Clock, n int) <-chan readResult {
+	ch := make(chan readResult, 1)
+	go func() {
+		buf := make([]byte, n)
+		if _, err := io.ReadFull(c, buf); err != nil {
+			close(ch)
+			return
+		}
+		ch <- readResult{buf, clock.Now()}
+	}()
+	return ch
+}
+
+func newTestPipe(config LinkConfig) (*mclock.Simulated, *Link, net.Conn, net.Conn) {
+	clock := new(mclock.Simulated)
+	link := NewLink(clock, 1)
+	if err := link.SetConfig(config); err != nil {
+		panic(err)
+	}
+	c1, c2 := net.Pipe()
+	a, b := link.Pipe(c1, c2)
+	return clock, link, a, b
+}
+
+func TestLinkLatency(t *testing.T) {
+	clock, _, a, b := newTestPipe(LinkConfig{Latency: 100 * time.Millisecond})
+	defer a.Close()
+	defer b.Close()
+
+	result := readAsync(b, clock, 5)
+	if _, err := a.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	clock.WaitForTimers(1)
+	clock.Run(99 * time.Millisecond)
+	select {
+	case <-result:
+		t.Fatal("data delivered before latency elapsed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	clock.Run(1 * time.Millisecond)
+	r := <-result
+	if !bytes.Equal(r.data, []byte("hello")) {
+		t.Fatalf("wrong data %q", r.data)
+	}
+	if r.at != mclock.AbsTime(100*time.Millisecond) {
+		t.Fatalf("data delivered at %v, want 100ms", time.Duration(r.at))
+	}
+}
+
+func TestLinkBandwidth(t *testing.T) {
+	clock, _, a, b := newTestPipe(LinkConfig{Bandwidth: 1000, Latency: 10 * time.Millisecond})
+	defer a.Close()
+	defer b.Close()
+
+	result := readAsync(b, clock, 1000)
+	written := make(chan struct{})
+	go func() {
+		a.Write(make([]byte, 500))
+		a.Write(make([]byte, 500))
+		close(written)
+	}()
+	// The first write blocks for 500ms, the second one for another 500ms.
+	for i := 0; i < 2; i++ {
+		clock.WaitForTimers(2)
+		clock.Run(500 * time.Millisecond)
+	}
+	<-written
+	clock.WaitForTimers(1)
+	clock.Run(10 * time.Millisecond)
+	r := <-result
+	if r.at != mclock.AbsTime(1010*time.Millisecond) {
+		t.Fatalf("data delivered at %v, want 1.01s", time.Duration(r.at))
+	}
+}
+
+func TestLinkPartition(t *testing.T) {
+	clock, link, a, b := newTestPipe(LinkConfig{Partitioned: true})
+	defer a.Close()
+	defer b.Close()
+
+	result := readAsync(b, clock, 5)
+	if _, err := a.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	clock.Run(time.Hour)
+	select {
+	case <-result:
+		t.Fatal("data delivered through partitioned link")
+	case <-time.After(50 * time.Millisecond):
+	}
+	link.SetConfig(LinkConfig{})
+	select {
+	case r := <-result:
+		if !bytes.Equal(r.data, []byte("hello")) {
+			t.Fatalf("wrong data %q", r.data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("data not delivered after partition healed")
+	}
+}
+
+func TestLinkLossReproducible(t *testing.T) {
+	config := LinkConfig{Latency: 50 * time.Millisecond, Loss: 0.3}
+	schedule := func() []mclock.AbsTime {
+		c1, c2 := net.Pipe()
+		defer c2.Close()
+		c := NewLink(new(mclock.Simulated), 42).Wrap(c1).(*linkConn)
+		defer c.Close()
+		var dues []mclock.AbsTime
+		for i := 0; i < 100; i++ {
+			_, due := c.schedule(mclock.AbsTime(i)*mclock.AbsTime(time.Second), config, 4000)
+			dues = append(dues, due)
+		}
+		return dues
+	}
+
+	dues := schedule()
+	var delayed int
+	for i, due := range dues {
+		sent := mclock.AbsTime(i) * mclock.AbsTime(time.Second)
+		switch delay := due.Sub(sent); {
+		case delay < config.Latency:
+			t.Fatalf("write %d delivered after %v, less than latency", i, delay)
+		case delay > config.Latency:
+			delayed++
+		}
+	}
+	if delayed == 0 {
+		t.Fatal("no write was delayed by packet loss")
+	}
+	for i, due := range schedule() {
+		if due != dues[i] {
+			t.Fatalf("write %d: delivery time differs between runs", i)
+		}
+	}
+}
+
+func TestLinkConfigValidate(t *testing.T) {
+	invalid := []LinkConfig{
+		{Latency: -1},
+		{Bandwidth: -1},
+		{Loss: -0.1},
+		{Loss: 1},
+	}
+	for _, config := range invalid {
+		if err := config.Validate(); err == nil {
+			t.Errorf("no error for invalid config %+v", config)
+		}
+	}
+	if err := NewLink(mclock.System{}, 0).SetConfig(LinkConfig{Loss: 1}); err == nil {
+		t.Error("SetConfig accepted invalid config")
+	}
+}
@@ -20,6 +20,8 @@ import (
 	"encoding/binary"
 	"fmt"
 	"math/big"
+	"reflect"
+	"strings"
 
 	"github.com/mxt/go-mxt/common"
 	"github.com/mxt/go-mxt/crypto"
@@ -239,17 +241,17 @@ var (
 	//
 	// This configuration is intentionally not using keyed fields to force anyone
 	// adding flags to the config to also have to set these fields.
-	AllEthashProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, new(EthashConfig), nil}
+	AllEthashProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, nil, nil, new(EthashConfig), nil, nil}
 
 	// AllCliqueProtocolChanges contains every protocol change (EIPs) introduced
 	// and accepted by the Ethereum core developers into the Clique consensus.
 	//
 	// This configuration is intentionally not using keyed fields to force anyone
 	// adding flags to the config to also have to set these fields.
-	AllCliqueProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, nil, &CliqueConfig{Period: 0, Epoch: 30000}}
+	AllCliqueProtocolChanges = &ChainConfig{big.NewInt(1337), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, nil, nil, nil, &CliqueConfig{Period: 0, Epoch: 30000}, nil}
 
-	TestChainConfig = &ChainConfig{big.NewInt(1), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, new(EthashConfig), nil}
-	TestRules       = TestChainConfig.Rules(new(big.Int))
+	TestChainConfig = &ChainConfig{big.NewInt(1), big.NewInt(0), nil, false, big.NewInt(0), common.Hash{}, big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), big.NewInt(0), nil, nil, nil, nil, nil, new(EthashConfig), nil, nil}
+	TestRules       = TestChainConfig.Rules(new(big.Int), 0)
 )
 
 // TrustedCheckpoint represents a set of post-processed trie roots (CHT and
@@ -323,9 +325,15 @@ type ChainConfig struct {
 	YoloV1Block *big.Int `json:"yoloV1Block,omitempty"` // YOLO v1: https://github.com/mxt/EIPs/pull/2657 (Ephemeral testnet)
 	EWASMBlock  *big.Int `json:"ewasmBlock,omitempty"`  // EWASM switch block (nil = no fork, 0 = already activated)
 
+	// Forks scheduled by block timestamp instead of number, for coordinated
+	// upgrades of private networks. A fork may be scheduled by either, not both.
+	YoloV1Time *uint64 `json:"yoloV1Time,omitempty"` // YOLO v1 switch time (nil = no fork, 0 = already activated)
+	EWASMTime  *uint64 `json:"ewasmTime,omitempty"`  // EWASM switch time (nil = no fork, 0 = already activated)
+
 	// Various consensus engines
 	Ethash *EthashConfig `json:"mxtash,omitempty"`
 	Clique *CliqueConfig `json:"clique,omitempty"`
+	IBFT   *IBFTConfig   `json:"ibft,omitempty"`
 }
 
 // EthashConfig is the consensus engine configs for proof-of-work based sealing.
@@ -340,6 +348,17 @@ func (c *EthashConfig) String() string {
 type CliqueConfig struct {
 	Period uint64 `json:"period"` // Number of seconds between blocks to enforce
 	Epoch  uint64 `json:"epoch"`  // Epoch length to reset votes and checkpoint
+
+	// BackupPeriod, if set, is the number of milliseconds out-of-turn signers
+	// wait for each other, taking turns in a deterministic order instead of a
+	// random delay.
+	BackupPeriod uint64 `json:"backupPeriod,omitempty"`
+
+	// GovernanceContract, if set, is a system contract exposing a getSigners()
+	// mmxtod. The signers it returns at every checkpoint replace the ones voted
+	// in through the header nonces, which are disabled.
+	GovernanceContract *common.Address `json:"governanceContract,omitempty"`
+	GovernanceBlock    *big.Int        `json:"governanceBlock,omitempty"` // First block of signer governance (nil = genesis)
 }
 
 // String implements the stringer interface, returning the consensus engine details.
@@ -347,6 +366,28 @@ func (c *CliqueConfig) String() string {
 	return "clique"
 }
 
+// IsGovernance returns whmxter num is governed by the signer contract instead of
+// header votes.
+func (c *CliqueConfig) IsGovernance(num uint64) bool {
+	if c.GovernanceContract == nil {
+		return false
+	}
+	return c.GovernanceBlock == nil || c.GovernanceBlock.Cmp(new(big.Int).SetUint64(num)) <= 0
+}
+
+// IBFTConfig is the consensus engine configs for Byzantine fault tolerant
+// sealing with instant finality.
+type IBFTConfig struct {
+	Period         uint64 `json:"period"`         // Number of seconds between blocks to enforce
+	Epoch          uint64 `json:"epoch"`          // Epoch length to reset votes and checkpoint
+	RequestTimeout uint64 `json:"requestTimeout"` // Milliseconds to wait for a round to commit before changing it
+}
+
+// String implements the stringer interface, returning the consensus engine details.
+func (c *IBFTConfig) String() string {
+	return "ibft"
+}
+
 // String implements the fmt.Stringer interface.
 func (c *ChainConfig) String() string {
 	var engine interface{}
@@ -355,10 +396,12 @@ func (c *ChainConfig) String() string {
 		engine = c.Ethash
 	case c.Clique != nil:
 		engine = c.Clique
+	case c.IBFT != nil:
+		engine = c.IBFT
 	default:
 		engine = "unknown"
 	}
-	return fmt.Sprintf("{ChainID: %v Homestead: %v DAO: %v DAOSupport: %v EIP150: %v EIP155: %v EIP158: %v Byzantium: %v Constantinople: %v Petersburg: %v Istanbul: %v, Muir Glacier: %v, YOLO v1: %v, Engine: %v}",
+	return fmt.Sprintf("{ChainID: %v Homestead: %v DAO: %v DAOSupport: %v EIP150: %v EIP155: %v EIP158: %v Byzantium: %v Constantinople: %v Petersburg: %v Istanbul: %v, Muir Glacier: %v, YOLO v1: %v, YOLO v1 time: %v, Engine: %v}",
 		c.ChainID,
 		c.HomesteadBlock,
 		c.DAOForkBlock,
@@ -372,6 +415,7 @@ func (c *ChainConfig) String() string {
 		c.IstanbulBlock,
 		c.MuirGlacierBlock,
 		c.YoloV1Block,
+		timeString(c.YoloV1Time),
 		engine,
 	)
 }
@@ -428,41 +472,108 @@ func (c *ChainConfig) IsIstanbul(num *big.Int) bool {
 	return isForked(c.IstanbulBlock, num)
 }
 
-// IsYoloV1 returns whmxter num is either equal to the YoloV1 fork block or greater.
-func (c *ChainConfig) IsYoloV1(num *big.Int) bool {
-	return isForked(c.YoloV1Block, num)
+// IsYoloV1 returns whmxter num is either equal to the YoloV1 fork block or greater,
+// or time is past the YoloV1 fork time.
+func (c *ChainConfig) IsYoloV1(num *big.Int, time uint64) bool {
+	return isForked(c.YoloV1Block, num) || isTimestampForked(c.YoloV1Time, time)
 }
 
-// IsEWASM returns whmxter num represents a block number after the EWASM fork
-func (c *ChainConfig) IsEWASM(num *big.Int) bool {
-	return isForked(c.EWASMBlock, num)
+// IsEWASM returns whmxter num represents a block number after the EWASM fork,
+// or time is past the EWASM fork time.
+func (c *ChainConfig) IsEWASM(num *big.Int, time uint64) bool {
+	return isForked(c.EWASMBlock, num) || isTimestampForked(c.EWASMTime, time)
 }
 
 // CheckCompatible checks whmxter scheduled fork transitions have been imported
-// with a mismatching chain configuration.
-func (c *ChainConfig) CheckCompatible(newcfg *ChainConfig, height uint64) *ConfigCompatError {
-	bhead := new(big.Int).SetUint64(height)
-
+// with a mismatching chain configuration, given the number and the timestamp of
+// the head block.
+func (c *ChainConfig) CheckCompatible(newcfg *ChainConfig, height uint64, time uint64) *ConfigCompatError {
+	var (
+		bhead = new(big.Int).SetUint64(height)
+		btime = time
+	)
 	// Iterate checkCompatible to find the lowest conflict.
 	var lasterr *ConfigCompatError
 	for {
-		err := c.checkCompatible(newcfg, bhead)
-		if err == nil || (lasterr != nil && err.RewindTo == lasterr.RewindTo) {
+		err := c.checkCompatible(newcfg, bhead, btime)
+		if err == nil || (lasterr != nil && err.RewindTo == lasterr.RewindTo && err.RewindToTime == lasterr.RewindToTime) {
 			break
 		}
 		lasterr = err
-		bhead.SetUint64(err.RewindTo)
+
+		if err.RewindToTime > 0 {
+			btime = err.RewindToTime
+		} else {
+			bhead.SetUint64(err.RewindTo)
+		}
 	}
 	return lasterr
 }
 
+// ForkNames returns the JSON field names of all the forks of the chain config,
+// whmxter scheduled by block number or by timestamp.
+func ForkNames() []string {
+	var (
+		kind  = reflect.TypeOf(ChainConfig{})
+		names []string
+	)
+	for i := 0; i < kind.NumField(); i++ {
+		if name, ok := forkName(kind.Field(i)); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// forkName returns the JSON name of a chain config field if it schedules a fork.
+func forkName(field reflect.StructField) (string, bool) {
+	name := strings.Split(field.Tag.Get("json"), ",")[0]
+	switch {
+	case strings.HasSuffix(field.Name, "Block") && field.Type == reflect.TypeOf(new(big.Int)):
+		return name, true
+	case strings.HasSuffix(field.Name, "Time") && field.Type == reflect.TypeOf(new(uint64)):
+		return name, true
+	}
+	return "", false
+}
+
+// SetFork schedules the fork with the given JSON field name (e.g. "istanbulBlock"
+// or "yoloV1Time") at a block number or timestamp, or unschedules it if at is nil.
+func (c *ChainConfig) SetFork(name string, at *uint64) error {
+	kind := reflect.TypeOf(*c)
+	conf := reflect.ValueOf(c).Elem()
+
+	for i := 0; i < kind.NumField(); i++ {
+		if fork, ok := forkName(kind.Field(i)); !ok || fork != name {
+			continue
+		}
+		if kind.Field(i).Type == reflect.TypeOf(new(big.Int)) {
+			var block *big.Int
+			if at != nil {
+				block = new(big.Int).SetUint64(*at)
+			}
+			conf.Field(i).Set(reflect.ValueOf(block))
+		} else {
+			var time *uint64
+			if at != nil {
+				time = new(uint64)
+				*time = *at
+			}
+			conf.Field(i).Set(reflect.ValueOf(time))
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown fork %q", name)
+}
+
 // CheckConfigForkOrder checks that we don't "skip" any forks, gmxt isn't pluggable enough
 // to guarantee that forks can be implemented in a different order than on official networks
 func (c *ChainConfig) CheckConfigForkOrder() error {
 	type fork struct {
-		name     string
-		block    *big.Int
-		optional bool // if true, the fork may be nil and next fork is still allowed
+		name      string
+		block     *big.Int // forks up to and including YOLOv1 use block numbers
+		timestamp *uint64  // forks from YOLOv1 onwards may use timestamps instead
+		optional  bool     // if true, the fork may be nil and next fork is still allowed
 	}
 	var lastFork fork
 	for _, cur := range []fork{
@@ -476,30 +587,50 @@ func (c *ChainConfig) CheckConfigForkOrder() error {
 		{name: "petersburgBlock", block: c.PetersburgBlock},
 		{name: "istanbulBlock", block: c.IstanbulBlock},
 		{name: "muirGlacierBlock", block: c.MuirGlacierBlock, optional: true},
-		{name: "yoloV1Block", block: c.YoloV1Block},
+		{name: "yoloV1", block: c.YoloV1Block, timestamp: c.YoloV1Time},
 	} {
+		if cur.block != nil && cur.timestamp != nil {
+			return fmt.Errorf("invalid fork scheduling: %v scheduled at both block %v and timestamp %v", cur.name, cur.block, *cur.timestamp)
+		}
 		if lastFork.name != "" {
-			// Next one must be higher number
-			if lastFork.block == nil && cur.block != nil {
-				return fmt.Errorf("unsupported fork ordering: %v not enabled, but %v enabled at %v",
-					lastFork.name, cur.name, cur.block)
-			}
-			if lastFork.block != nil && cur.block != nil {
-				if lastFork.block.Cmp(cur.block) > 0 {
-					return fmt.Errorf("unsupported fork ordering: %v enabled at %v, but %v enabled at %v",
+			switch {
+			// Non-optional forks must all be present in the chain config up to the last defined fork
+			case lastFork.block == nil && lastFork.timestamp == nil && (cur.block != nil || cur.timestamp != nil):
+				if cur.block != nil {
+					return fmt.Errorf("unsupported fork ordering: %v not enabled, but %v enabled at block %v",
+						lastFork.name, cur.name, cur.block)
+				}
+				return fmt.Errorf("unsupported fork ordering: %v not enabled, but %v enabled at timestamp %v",
+					lastFork.name, cur.name, *cur.timestamp)
+
+			// Fork (whmxter defined by block or timestamp) must follow the fork definition sequence
+			case (lastFork.block != nil && cur.block != nil) || (lastFork.timestamp != nil && cur.timestamp != nil):
+				if lastFork.block != nil && lastFork.block.Cmp(cur.block) > 0 {
+					return fmt.Errorf("unsupported fork ordering: %v enabled at block %v, but %v enabled at block %v",
 						lastFork.name, lastFork.block, cur.name, cur.block)
+				} else if lastFork.timestamp != nil && *lastFork.timestamp > *cur.timestamp {
+					return fmt.Errorf("unsupported fork ordering: %v enabled at timestamp %v, but %v enabled at timestamp %v",
+						lastFork.name, *lastFork.timestamp, cur.name, *cur.timestamp)
 				}
+
+			// Timestamp based forks can follow block based ones, but not the other way around
+			case lastFork.timestamp != nil && cur.block != nil:
+				return fmt.Errorf("unsupported fork ordering: %v used timestamp ordering, but %v reverted to block ordering",
+					lastFork.name, cur.name)
 			}
 		}
 		// If it was optional and not set, then ignore it
-		if !cur.optional || cur.block != nil {
+		if !cur.optional || (cur.block != nil || cur.timestamp != nil) {
 			lastFork = cur
 		}
 	}
+	if c.EWASMBlock != nil && c.EWASMTime != nil {
+		return fmt.Errorf("invalid fork scheduling: ewasm scheduled at both block %v and timestamp %v", c.EWASMBlock, *c.EWASMTime)
+	}
 	return nil
 }
 
-func (c *ChainConfig) checkCompatible(newcfg *ChainConfig, head *big.Int) *ConfigCompatError {
+func (c *ChainConfig) checkCompatible(newcfg *ChainConfig, head *big.Int, headTime uint64) *ConfigCompatError {
 	if isForkIncompatible(c.HomesteadBlock, newcfg.HomesteadBlock, head) {
 		return newCompatError("Homestead fork block", c.HomesteadBlock, newcfg.HomesteadBlock)
 	}
@@ -546,6 +677,12 @@ func (c *ChainConfig) checkCompatible(newcfg *ChainConfig, head *big.Int) *Confi
 	if isForkIncompatible(c.EWASMBlock, newcfg.EWASMBlock, head) {
 		return newCompatError("ewasm fork block", c.EWASMBlock, newcfg.EWASMBlock)
 	}
+	if isTimestampForkIncompatible(c.YoloV1Time, newcfg.YoloV1Time, headTime) {
+		return newTimestampCompatError("YOLOv1 fork timestamp", c.YoloV1Time, newcfg.YoloV1Time)
+	}
+	if isTimestampForkIncompatible(c.EWASMTime, newcfg.EWASMTime, headTime) {
+		return newTimestampCompatError("ewasm fork timestamp", c.EWASMTime, newcfg.EWASMTime)
+	}
 	return nil
 }
 
@@ -563,6 +700,39 @@ func isForked(s, head *big.Int) bool {
 	return s.Cmp(head) <= 0
 }
 
+// isTimestampForkIncompatible returns true if a fork scheduled at timestamp s1
+// cannot be rescheduled to timestamp s2 because head is already past the fork.
+func isTimestampForkIncompatible(s1, s2 *uint64, head uint64) bool {
+	return (isTimestampForked(s1, head) || isTimestampForked(s2, head)) && !configTimestampEqual(s1, s2)
+}
+
+// isTimestampForked returns whmxter a fork scheduled at timestamp s is active
+// at the given head timestamp.
+func isTimestampForked(s *uint64, head uint64) bool {
+	if s == nil {
+		return false
+	}
+	return *s <= head
+}
+
+func configTimestampEqual(x, y *uint64) bool {
+	if x == nil {
+		return y == nil
+	}
+	if y == nil {
+		return x == nil
+	}
+	return *x == *y
+}
+
+// timeString formats an optional fork timestamp.
+func timeString(t *uint64) string {
+	if t == nil {
+		return "<nil>"
+	}
+	return fmt.Sprint(*t)
+}
+
 func configNumEqual(x, y *big.Int) bool {
 	if x == nil {
 		return y == nil
@@ -577,10 +747,15 @@ func configNumEqual(x, y *big.Int) bool {
 // ChainConfig that would alter the past.
 type ConfigCompatError struct {
 	What string
-	// block numbers of the stored and new configurations
+	// block numbers of the stored and new configurations if block based forking
 	StoredConfig, NewConfig *big.Int
+	// timestamps of the stored and new configurations if time based forking
+	StoredTime, NewTime *uint64
 	// the block number to which the local chain must be rewound to correct the error
 	RewindTo uint64
+	// the timestamp to which the local chain must be rewound to correct the error,
+	// the caller resolving it to the block number above
+	RewindToTime uint64
 }
 
 func newCompatError(what string, storedblock, newblock *big.Int) *ConfigCompatError {
@@ -593,14 +768,34 @@ func newCompatError(what string, storedblock, newblock *big.Int) *ConfigCompatEr
 	default:
 		rew = newblock
 	}
-	err := &ConfigCompatError{what, storedblock, newblock, 0}
+	err := &ConfigCompatError{What: what, StoredConfig: storedblock, NewConfig: newblock}
 	if rew != nil && rew.Sign() > 0 {
 		err.RewindTo = rew.Uint64() - 1
 	}
 	return err
 }
 
+func newTimestampCompatError(what string, storedtime, newtime *uint64) *ConfigCompatError {
+	var rew *uint64
+	switch {
+	case storedtime == nil:
+		rew = newtime
+	case newtime == nil || *storedtime < *newtime:
+		rew = storedtime
+	default:
+		rew = newtime
+	}
+	err := &ConfigCompatError{What: what, StoredTime: storedtime, NewTime: newtime}
+	if rew != nil && *rew > 0 {
+		err.RewindToTime = *rew - 1
+	}
+	return err
+}
+
 func (err *ConfigCompatError) Error() string {
+	if err.StoredConfig == nil && err.NewConfig == nil && (err.StoredTime != nil || err.NewTime != nil) {
+		return fmt.Sprintf("mismatching %s in database (have timestamp %s, want timestamp %s, rewindto timestamp %d)", err.What, timeString(err.StoredTime), timeString(err.NewTime), err.RewindToTime)
+	}
 	return fmt.Sprintf("mismatching %s in database (have %d, want %d, rewindto %d)", err.What, err.StoredConfig, err.NewConfig, err.RewindTo)
 }
 
@@ -617,7 +812,7 @@ type Rules struct {
 }
 
 // Rules ensures c's ChainID is not nil.
-func (c *ChainConfig) Rules(num *big.Int) Rules {
+func (c *ChainConfig) Rules(num *big.Int, time uint64) Rules {
 	chainID := c.ChainID
 	if chainID == nil {
 		chainID = new(big.Int)
@@ -632,6 +827,6 @@ func (c *ChainConfig) Rules(num *big.Int) Rules {
 		IsConstantinople: c.IsConstantinople(num),
 		IsPetersburg:     c.IsPetersburg(num),
 		IsIstanbul:       c.IsIstanbul(num),
-		IsYoloV1:         c.IsYoloV1(num),
+		IsYoloV1:         c.IsYoloV1(num, time),
 	}
 }
@@ -26,6 +26,7 @@ func TestCheckCompatible(t *testing.T) {
 	type test struct {
 		stored, new *ChainConfig
 		head        uint64
+		headTime    uint64
 		wantErr     *ConfigCompatError
 	}
 	tests := []test{
@@ -87,12 +88,116 @@ func TestCheckCompatible(t *testing.T) {
 				RewindTo:     30,
 			},
 		},
+		{
+			stored:   &ChainConfig{YoloV1Time: newUint64(10)},
+			new:      &ChainConfig{YoloV1Time: newUint64(20)},
+			headTime: 9,
+			wantErr:  nil,
+		},
+		{
+			stored:   &ChainConfig{YoloV1Time: newUint64(10)},
+			new:      &ChainConfig{YoloV1Time: newUint64(20)},
+			headTime: 25,
+			wantErr: &ConfigCompatError{
+				What:         "YOLOv1 fork timestamp",
+				StoredTime:   newUint64(10),
+				NewTime:      newUint64(20),
+				RewindToTime: 9,
+			},
+		},
+		{
+			stored:   &ChainConfig{YoloV1Block: big.NewInt(10)},
+			new:      &ChainConfig{YoloV1Time: newUint64(10)},
+			head:     20,
+			headTime: 5,
+			wantErr: &ConfigCompatError{
+				What:         "YOLOv1 fork block",
+				StoredConfig: big.NewInt(10),
+				NewConfig:    nil,
+				RewindTo:     9,
+			},
+		},
 	}
 
 	for _, test := range tests {
-		err := test.stored.CheckCompatible(test.new, test.head)
+		err := test.stored.CheckCompatible(test.new, test.head, test.headTime)
 		if !reflect.DeepEqual(err, test.wantErr) {
-			t.Errorf("error mismatch:\nstored: %v\nnew: %v\nhead: %v\nerr: %v\nwant: %v", test.stored, test.new, test.head, err, test.wantErr)
+			t.Errorf("error mismatch:\nstored: %v\nnew: %v\nhead: %v (time %v)\nerr: %v\nwant: %v", test.stored, test.new, test.head, test.headTime, err, test.wantErr)
+		}
+	}
+}
+
+func newUint64(val uint64) *uint64 { return &val }
+
+func TestCheckConfigForkOrder(t *testing.T) {
+	base := func() *ChainConfig {
+		return &ChainConfig{
+			HomesteadBlock:      big.NewInt(0),
+			EIP150Block:         big.NewInt(0),
+			EIP155Block:         big.NewInt(0),
+			EIP158Block:         big.NewInt(0),
+			ByzantiumBlock:      big.NewInt(0),
+			ConstantinopleBlock: big.NewInt(0),
+			PetersburgBlock:     big.NewInt(0),
+			IstanbulBlock:       big.NewInt(0),
 		}
 	}
+	tests := []struct {
+		config func(*ChainConfig)
+		valid  bool
+	}{
+		{func(c *ChainConfig) {}, true},
+		{func(c *ChainConfig) { c.YoloV1Block = big.NewInt(10) }, true},
+		{func(c *ChainConfig) { c.YoloV1Time = newUint64(1000) }, true},
+		{func(c *ChainConfig) { c.YoloV1Block, c.YoloV1Time = big.NewInt(10), newUint64(1000) }, false},
+		{func(c *ChainConfig) { c.IstanbulBlock, c.YoloV1Time = nil, newUint64(1000) }, false},
+		{func(c *ChainConfig) { c.EWASMBlock, c.EWASMTime = big.NewInt(10), newUint64(1000) }, false},
+	}
+	for i, tt := range tests {
+		config := base()
+		tt.config(config)
+		if err := config.CheckConfigForkOrder(); (err == nil) != tt.valid {
+			t.Errorf("test %d: validity mismatch: have %v, want valid %v", i, err, tt.valid)
+		}
+	}
+}
+
+func TestTimestampForks(t *testing.T) {
+	config := &ChainConfig{YoloV1Time: newUint64(1000)}
+	if config.IsYoloV1(big.NewInt(100), 999) {
+		t.Errorf("YOLOv1 active before its timestamp")
+	}
+	if !config.IsYoloV1(big.NewInt(100), 1000) || !config.Rules(big.NewInt(100), 1000).IsYoloV1 {
+		t.Errorf("YOLOv1 not active at its timestamp")
+	}
+}
+
+func TestSetFork(t *testing.T) {
+	config := *TestChainConfig
+	block, time := uint64(10), uint64(1000)
+
+	if err := config.SetFork("istanbulBlock", &block); err != nil {
+		t.Fatalf("failed to set block fork: %v", err)
+	}
+	if err := config.SetFork("yoloV1Time", &time); err != nil {
+		t.Fatalf("failed to set timestamp fork: %v", err)
+	}
+	if err := config.SetFork("homesteadBlock", nil); err != nil {
+		t.Fatalf("failed to unset fork: %v", err)
+	}
+	if config.IstanbulBlock.Uint64() != block || *config.YoloV1Time != time || config.HomesteadBlock != nil {
+		t.Errorf("forks not rescheduled: %v", &config)
+	}
+	if TestChainConfig.IstanbulBlock.Uint64() != 0 || TestChainConfig.HomesteadBlock == nil {
+		t.Errorf("original config modified: %v", TestChainConfig)
+	}
+	for _, name := range []string{"chainId", "eip150Hash", "istanbul", "unknownBlock"} {
+		if err := config.SetFork(name, &block); err == nil {
+			t.Errorf("non-fork field %q set", name)
+		}
+	}
+	names := ForkNames()
+	if len(names) == 0 || names[0] != "homesteadBlock" || names[len(names)-1] != "ewasmTime" {
+		t.Errorf("fork names mismatch: %v", names)
+	}
 }
@@ -63,6 +63,13 @@ func (s *Server) WebsocketHandler(allowedOrigins []string) http.Handler {
 	})
 }
 
+// WebsocketOriginValidator returns a function which checks the Origin header of
+// WebSocket upgrade requests against the given list, using the same rules as the
+// JSON-RPC WebSocket handler.
+func WebsocketOriginValidator(allowedOrigins []string) func(*http.Request) bool {
+	return wsHandshakeValidator(allowedOrigins)
+}
+
 // wsHandshakeValidator returns a handler that verifies the origin during the
 // websocket upgrade process. When a '*' is specified as an allowed origins all
 // connections are accepted.